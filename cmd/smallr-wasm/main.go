@@ -19,13 +19,21 @@ func main() {
 		code := args[0].String()
 		res, err := ctx.EvalString(code)
 		if err != nil {
+			msg := "Error: " + err.Error()
+			if res.Error != nil {
+				msg = res.Error.Report()
+			}
 			return map[string]any{
-				"error":  err.Error(),
+				"error":  msg,
 				"output": res.Output,
 			}
 		}
+		value := ""
+		if !res.Invisible {
+			value = ctx.SprintValue(res.Value)
+		}
 		return map[string]any{
-			"value":  res.Value.String(),
+			"value":  value,
 			"json":   rt.ToJSON(res.Value),
 			"output": res.Output,
 		}
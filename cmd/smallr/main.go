@@ -2,9 +2,12 @@ package main
 
 import (
 	"bufio"
+	"context"
+	"errors"
 	"flag"
 	"fmt"
 	"os"
+	"os/signal"
 	"strings"
 
 	"simonwaldherr.de/go/smallr"
@@ -20,13 +23,14 @@ func main() {
 	if expr != "" {
 		res, err := ctx.EvalString(expr)
 		if err != nil {
-			fmt.Fprintln(os.Stderr, "Error:", err)
+			fmt.Print(res.Output)
+			fmt.Fprintln(os.Stderr, errorReport(res, err))
 			os.Exit(1)
 		}
 		if strings.TrimSpace(res.Output) != "" {
 			fmt.Print(res.Output)
-		} else {
-			fmt.Println(res.Value.String())
+		} else if !res.Invisible {
+			fmt.Println(ctx.SprintValue(res.Value))
 		}
 		return
 	}
@@ -38,16 +42,17 @@ func main() {
 			fmt.Fprintln(os.Stderr, "Error:", err)
 			os.Exit(1)
 		}
-		res, err := ctx.EvalString(string(b))
+		res, err := ctx.EvalScript(path, string(b))
 		if err != nil {
-			fmt.Fprintln(os.Stderr, "Error:", err)
+			fmt.Print(res.Output)
+			fmt.Fprintln(os.Stderr, errorReport(res, err))
 			os.Exit(1)
 		}
 		if strings.TrimSpace(res.Output) != "" {
 			fmt.Print(res.Output)
-		} else {
+		} else if !res.Invisible {
 			// print last value only if there was no printed output
-			fmt.Println(res.Value.String())
+			fmt.Println(ctx.SprintValue(res.Value))
 		}
 		return
 	}
@@ -72,20 +77,51 @@ func main() {
 		if !looksComplete(src) {
 			continue
 		}
-		res, err := ctx.EvalString(src)
+		res, err := evalInterruptible(ctx, src)
 		if err != nil {
-			fmt.Println("Error:", err)
+			fmt.Print(res.Output)
+			fmt.Println(errorReport(res, err))
 			buf.Reset()
 			continue
 		}
 		if strings.TrimSpace(res.Output) != "" {
 			fmt.Print(res.Output)
 		}
-		fmt.Println(res.Value.String())
+		if !res.Invisible {
+			fmt.Println(ctx.SprintValue(res.Value))
+		}
 		buf.Reset()
 	}
 }
 
+// evalInterruptible evaluates src so that Ctrl-C stops the evaluation
+// instead of the process. Outside evaluation Ctrl-C exits as usual.
+func evalInterruptible(ctx *smallr.Context, src string) (smallr.EvalResult, error) {
+	c, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	return ctx.EvalStringContext(c, src)
+}
+
+// errorReport formats an evaluation error the way R prints it, with the
+// source position for runtime errors and one line per syntax error.
+func errorReport(res smallr.EvalResult, err error) string {
+	if res.Error != nil {
+		return res.Error.Report()
+	}
+	if errors.Is(err, smallr.ErrInterrupted) {
+		return "Interrupted"
+	}
+	var syntax smallr.ErrorList
+	if errors.As(err, &syntax) {
+		lines := make([]string, len(syntax))
+		for i, d := range syntax {
+			lines[i] = "Error: " + d.Error()
+		}
+		return strings.Join(lines, "\n")
+	}
+	return "Error: " + err.Error()
+}
+
 func looksComplete(src string) bool {
 	// Heuristic: balanced (), {}, []
 	var p, b, s int
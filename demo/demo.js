@@ -51,30 +51,19 @@ list(
   n = length(data)
 )`;
 
-const DEFAULT_REGRESSION = `# You already have vectors x and y (generated in JS).
+const DEFAULT_REGRESSION = `# You already have vectors x and y (drawn with runif/rnorm).
 # Modify this code and click "Run smallR".
 
-mx <- mean(x)
-my <- mean(y)
-
-sxy <- sum((x - mx) * (y - my))
-sxx <- sum((x - mx) * (x - mx))
-
-slope <- sxy / sxx
-intercept <- my - slope * mx
-
-yhat <- intercept + slope * x
-
-ss_tot <- sum((y - my) * (y - my))
-ss_res <- sum((y - yhat) * (y - yhat))
-r2 <- 1 - ss_res / ss_tot
+# Ordinary least squares; print(summary(fit)) shows the full table
+fit <- lm(y ~ x)
+beta <- coef(fit)
 
 # smallR returns a named list -> JSON object
 list(
-  intercept = intercept,
-  slope = slope,
-  r2 = r2,
-  yhat = yhat
+  intercept = beta[[1]],
+  slope = beta[[2]],
+  r2 = summary(fit)$r.squared,
+  yhat = as.vector(fitted(fit))
 )`;
 
 const DEFAULT_STATS = `# Vector operations and statistics
@@ -262,18 +251,10 @@ $$('.tab').forEach(tab => {
 // Helpers
 // ════════════════════════════════════════════
 
-function randn() {
-  let u = 0, v = 0;
-  while (u === 0) u = Math.random();
-  while (v === 0) v = Math.random();
-  return Math.sqrt(-2.0 * Math.log(u)) * Math.cos(2.0 * Math.PI * v);
-}
-
-function toRVec(arr) {
-  const parts = arr.map(v =>
-    Number.isFinite(v) ? v.toFixed(6).replace(/0+$/, '').replace(/\.$/, '') : "NA"
-  );
-  return `c(${parts.join(",")})`;
+// A fresh seed for each regenerated data set; the data itself is drawn by
+// smallR, so the R code shown with a seed reproduces it in GNU R.
+function newSeed() {
+  return Math.floor(Math.random() * 1e6);
 }
 
 function smallrEval(code) {
@@ -409,16 +390,15 @@ const regenBtn = $("#regen"); const runBtn = $("#run");
 codeEl.value = DEFAULT_REGRESSION;
 
 function generateData(n, slope, intercept, noise) {
-  const x = [], y = [];
-  for (let i = 0; i < n; i++) {
-    const xv = Math.random() * 10;
-    x.push(xv);
-    y.push(intercept + slope * xv + randn() * noise);
-  }
-  return { x, y };
+  const prelude = `set.seed(${newSeed()})
+x <- runif(${n}, 0, 10)
+y <- ${intercept} + ${slope} * x + rnorm(${n}, sd = ${noise})
+`;
+  const { json } = smallrEval(prelude + "list(x = x, y = y)");
+  return { x: json.x, y: json.y, prelude };
 }
 
-let currentReg = generateData(+nEl.value, +trueSlopeEl.value, +trueInterceptEl.value, +noiseEl.value);
+let currentReg = null;
 
 function updateRegLabels() {
   nVal.textContent = nEl.value;
@@ -523,8 +503,10 @@ function renderReg({ x, y, intercept, slope }) {
 function recomputeReg() {
   setStatus("Running...", "running");
   updateRegLabels();
-  const prelude = `x <- ${toRVec(currentReg.x)}\ny <- ${toRVec(currentReg.y)}\n`;
-  const { json, output } = smallrEval(prelude + codeEl.value);
+  if (!currentReg) {
+    currentReg = generateData(+nEl.value, +trueSlopeEl.value, +trueInterceptEl.value, +noiseEl.value);
+  }
+  const { json, output } = smallrEval(currentReg.prelude + codeEl.value);
 
   outEl.textContent = (output || "").trim();
 
@@ -768,15 +750,11 @@ $("#runTimeSeries").addEventListener("click", () => {
     const n = +tsPointsEl.value;
     const win = +tsWindowEl.value;
 
-    // Generate synthetic time series
-    const data = [];
-    let val = 50;
-    for (let i = 0; i < n; i++) {
-      val += (Math.random() - 0.5) * 5 + Math.sin(i / 10) * 3;
-      data.push(val);
-    }
-
-    const prelude = `data <- ${toRVec(data)}\nwindow <- ${win}\n`;
+    // Generate a synthetic time series: a random walk with a seasonal drift
+    const prelude = `set.seed(${newSeed()})
+data <- 50 + cumsum(runif(${n}, -2.5, 2.5) + sin((1:${n} - 1) / 10) * 3)
+window <- ${win}
+`;
     const code = prelude + codeTSEl.value;
     const { json } = smallrEval(code);
 
@@ -9,6 +9,8 @@ import (
 
 type Node interface {
 	Pos() token.Pos
+	// String returns a compact form for debugging. Package deparse writes
+	// nodes as R source that parses back.
 	String() string
 }
 
@@ -50,11 +52,15 @@ func (i *Ident) Pos() token.Pos { return i.P }
 func (i *Ident) exprNode()      {}
 func (i *Ident) String() string { return i.Name }
 
+// NumberLit is a numeric constant. Text is the source spelling, suffix
+// included. Constants are double unless they carry the L suffix (IsInt)
+// or the i suffix (IsImag, Value is then the imaginary part).
 type NumberLit struct {
-	P     token.Pos
-	Text  string
-	Value float64
-	IsInt bool
+	P      token.Pos
+	Text   string
+	Value  float64
+	IsInt  bool
+	IsImag bool
 }
 
 func (n *NumberLit) Pos() token.Pos { return n.P }
@@ -254,22 +260,46 @@ type CallExpr struct {
 
 func (c *CallExpr) Pos() token.Pos { return c.P }
 func (c *CallExpr) exprNode()      {}
-func (c *CallExpr) String() string { return c.Fun.String() + "(...)" }
+func (c *CallExpr) String() string {
+	parts := make([]string, len(c.Args))
+	for k, a := range c.Args {
+		s := ""
+		if a.Value != nil {
+			s = a.Value.String()
+		}
+		if a.Name != "" {
+			s = a.Name + " = " + s
+		}
+		parts[k] = s
+	}
+	return fmt.Sprintf("%s(%s)", c.Fun.String(), strings.Join(parts, ", "))
+}
 
 type IndexExpr struct {
 	P      token.Pos
 	X      Expr
-	Index  Expr
-	Double bool // [[ ]]
+	Args   []Arg // subscripts; an Arg with nil Value is an empty subscript as in x[, j]
+	Double bool  // [[ ]]
 }
 
 func (i *IndexExpr) Pos() token.Pos { return i.P }
 func (i *IndexExpr) exprNode()      {}
 func (i *IndexExpr) String() string {
+	parts := make([]string, len(i.Args))
+	for k, a := range i.Args {
+		s := ""
+		if a.Value != nil {
+			s = a.Value.String()
+		}
+		if a.Name != "" {
+			s = a.Name + " = " + s
+		}
+		parts[k] = s
+	}
 	if i.Double {
-		return fmt.Sprintf("%s[[%s]]", i.X.String(), i.Index.String())
+		return fmt.Sprintf("%s[[%s]]", i.X.String(), strings.Join(parts, ", "))
 	}
-	return fmt.Sprintf("%s[%s]", i.X.String(), i.Index.String())
+	return fmt.Sprintf("%s[%s]", i.X.String(), strings.Join(parts, ", "))
 }
 
 type DollarExpr struct {
@@ -281,3 +311,28 @@ type DollarExpr struct {
 func (d *DollarExpr) Pos() token.Pos { return d.P }
 func (d *DollarExpr) exprNode()      {}
 func (d *DollarExpr) String() string { return fmt.Sprintf("%s$%s", d.X.String(), d.Name) }
+
+// ValueExpr embeds an already evaluated value in an expression. The
+// runtime creates it when building calls from values, as call(),
+// substitute() and bquote() do; Text is the value in R syntax. Deparsing
+// a large value is expensive, so the runtime may leave Text empty and set
+// Deparse instead, which Source calls the first time the text is needed.
+type ValueExpr struct {
+	P       token.Pos
+	Value   any
+	Text    string
+	Deparse func() string
+}
+
+func (v *ValueExpr) Pos() token.Pos { return v.P }
+func (v *ValueExpr) exprNode()      {}
+func (v *ValueExpr) String() string { return v.Source() }
+
+// Source returns Text, computing it with Deparse on first use.
+func (v *ValueExpr) Source() string {
+	if v.Deparse != nil {
+		v.Text = v.Deparse()
+		v.Deparse = nil
+	}
+	return v.Text
+}
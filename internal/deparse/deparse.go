@@ -0,0 +1,315 @@
+// Package deparse turns AST nodes back into R source code. The output
+// parses back to the same expression.
+package deparse
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+
+	"simonwaldherr.de/go/smallr/internal/ast"
+	"simonwaldherr.de/go/smallr/internal/parser"
+	"simonwaldherr.de/go/smallr/internal/token"
+)
+
+// Options control the layout of deparsed code.
+type Options struct {
+	// Width is the line length after which argument lists and binary
+	// operators continue on the next line, like width.cutoff in R. Lines
+	// are only cut between arguments and after operators, so they may be
+	// longer. Zero disables line breaking.
+	Width int
+	// Indent is inserted once per block level and once more for continued
+	// lines.
+	Indent string
+}
+
+// DefaultOptions match R's deparse(): a width cutoff of 60 characters and
+// four spaces of indentation.
+var DefaultOptions = Options{Width: 60, Indent: "    "}
+
+// Expr returns e as R source code using DefaultOptions.
+func Expr(e ast.Expr) string { return DefaultOptions.Expr(e) }
+
+// Expr returns e as R source code.
+func (o Options) Expr(e ast.Expr) string { return strings.Join(o.Lines(e), "\n") }
+
+// Lines returns e as R source code, one element per line.
+func (o Options) Lines(e ast.Expr) []string {
+	p := &printer{opts: o}
+	p.expr(e)
+	return append(p.lines, p.cur.String())
+}
+
+type printer struct {
+	opts   Options
+	lines  []string
+	cur    strings.Builder
+	indent int
+}
+
+func (p *printer) write(s string) { p.cur.WriteString(s) }
+
+// newline starts a new line at the current indentation.
+func (p *printer) newline() {
+	p.lines = append(p.lines, p.cur.String())
+	p.cur.Reset()
+	p.write(strings.Repeat(p.opts.Indent, p.indent))
+}
+
+// wrap continues on a new line when the current one has grown past the
+// width. The first wrap of a construct indents the continuation; the
+// caller undoes that when broke is set.
+func (p *printer) wrap(broke *bool) {
+	if p.opts.Width <= 0 || p.cur.Len() <= p.opts.Width {
+		return
+	}
+	if !*broke {
+		*broke = true
+		p.indent++
+	}
+	p.newline()
+}
+
+func (p *printer) unwrap(broke bool) {
+	if broke {
+		p.indent--
+	}
+}
+
+const precAtom = 100
+
+// precOpen is the binding power of if, for, while, repeat and function,
+// which extend as far to the right as possible: they need parentheses
+// only where something follows them.
+const precOpen = 0
+
+// precCall is the binding power of calls, indexing, $, @ and ::, which
+// need no parentheses around each other.
+var precCall = parser.Precedence(token.LPAREN)
+
+// prec returns how tightly e binds as an operand.
+func prec(e ast.Expr) int {
+	switch x := e.(type) {
+	case *ast.BinaryExpr:
+		return parser.Precedence(x.Op)
+	case *ast.AssignExpr:
+		return parser.Precedence(x.Op)
+	case *ast.UnaryExpr:
+		return parser.PrefixPrecedence(x.Op)
+	case *ast.IfExpr, *ast.ForExpr, *ast.WhileExpr, *ast.RepeatExpr, *ast.FuncExpr:
+		return precOpen
+	case *ast.ValueExpr:
+		if strings.HasPrefix(x.Source(), "-") {
+			return parser.UnaryPrecedence
+		}
+	}
+	return precAtom
+}
+
+// operand prints e, parenthesized when it binds less tightly than min.
+// last is set when nothing follows e, as for the value of an assignment
+// at the end of a line.
+func (p *printer) operand(e ast.Expr, min int, last bool) {
+	if prec(e) < min && !(last && prec(e) == precOpen) {
+		p.write("(")
+		p.expr(e)
+		p.write(")")
+		return
+	}
+	p.node(e, last)
+}
+
+func (p *printer) expr(e ast.Expr) { p.node(e, true) }
+
+// node prints e; last is as for operand.
+func (p *printer) node(e ast.Expr, last bool) {
+	switch x := e.(type) {
+	case nil:
+	case *ast.Ident:
+		p.write(Name(x.Name))
+	case *ast.NumberLit:
+		p.write(x.Text)
+	case *ast.StringLit:
+		p.write(Quote(x.Value))
+	case *ast.BoolLit, *ast.NullLit, *ast.NALit, *ast.BreakExpr, *ast.NextExpr:
+		p.write(x.String())
+	case *ast.ValueExpr:
+		p.write(x.Source())
+	case *ast.UnaryExpr:
+		p.write(string(x.Op))
+		p.operand(x.X, parser.PrefixPrecedence(x.Op), last)
+	case *ast.BinaryExpr:
+		p.binary(x, last)
+	case *ast.AssignExpr:
+		target, value, op := x.Left, x.Right, string(x.Op)
+		switch x.Op {
+		case token.ASSIGN_RIGHT:
+			target, value, op = x.Right, x.Left, string(token.ASSIGN_LEFT)
+		case token.ASSIGN_SUPER_RIGHT:
+			target, value, op = x.Right, x.Left, string(token.ASSIGN_SUPER)
+		}
+		prec := parser.Precedence(x.Op)
+		p.operand(target, prec+1, false)
+		p.write(" " + op + " ")
+		p.operand(value, prec, last)
+	case *ast.BlockExpr:
+		p.block(x)
+	case *ast.IfExpr:
+		p.write("if (")
+		p.expr(x.Cond)
+		p.write(") ")
+		p.expr(x.Then)
+		if x.Else != nil {
+			p.write(" else ")
+			p.expr(x.Else)
+		}
+	case *ast.ForExpr:
+		p.write("for (" + Name(x.Var) + " in ")
+		p.expr(x.Seq)
+		p.write(") ")
+		p.expr(x.Body)
+	case *ast.WhileExpr:
+		p.write("while (")
+		p.expr(x.Cond)
+		p.write(") ")
+		p.expr(x.Body)
+	case *ast.RepeatExpr:
+		p.write("repeat ")
+		p.expr(x.Body)
+	case *ast.ReturnExpr:
+		p.write("return(")
+		p.expr(x.X)
+		p.write(")")
+	case *ast.FuncExpr:
+		p.function(x)
+	case *ast.CallExpr:
+		if id, ok := x.Fun.(*ast.Ident); ok && token.IsSpecial(token.Type(id.Name)) && len(x.Args) == 2 &&
+			x.Args[0].Name == "" && x.Args[1].Name == "" {
+			// `%op%`(a, b) is written as a %op% b, like R does
+			p.binary(&ast.BinaryExpr{Op: token.Type(id.Name), Left: x.Args[0].Value, Right: x.Args[1].Value}, last)
+			return
+		}
+		if id, ok := x.Fun.(*ast.Ident); ok {
+			p.write(Name(id.Name))
+		} else {
+			p.operand(x.Fun, precCall, false)
+		}
+		p.write("(")
+		p.args(x.Args)
+		p.write(")")
+	case *ast.IndexExpr:
+		p.operand(x.X, precCall, false)
+		if x.Double {
+			p.write("[[")
+			p.args(x.Args)
+			p.write("]]")
+		} else {
+			p.write("[")
+			p.args(x.Args)
+			p.write("]")
+		}
+	case *ast.DollarExpr:
+		p.operand(x.X, precCall, false)
+		p.write("$" + Name(x.Name))
+	default:
+		p.write(e.String())
+	}
+}
+
+// binary prints an infix operation. ^ is right associative, all other
+// operators associate to the left; ^, :, ::, ::: and @ are written without
+// spaces.
+func (p *printer) binary(x *ast.BinaryExpr, last bool) {
+	op := parser.Precedence(x.Op)
+	left, right := op, op+1
+	if x.Op == token.CARET {
+		left, right = op+1, op
+	}
+	p.operand(x.Left, left, false)
+	switch x.Op {
+	case token.CARET, token.COLON, token.NS_GET, token.NS_GET_INT, token.AT:
+		p.write(string(x.Op))
+		p.operand(x.Right, right, last)
+		return
+	}
+	p.write(" " + string(x.Op) + " ")
+	broke := false
+	p.wrap(&broke)
+	p.operand(x.Right, right, last)
+	p.unwrap(broke)
+}
+
+func (p *printer) function(f *ast.FuncExpr) {
+	p.write("function(")
+	broke := false
+	for i, prm := range f.Params {
+		if i > 0 {
+			p.write(", ")
+			p.wrap(&broke)
+		}
+		if prm.Dots {
+			p.write("...")
+			continue
+		}
+		p.write(Name(prm.Name))
+		if prm.Default != nil {
+			p.write(" = ")
+			p.expr(prm.Default)
+		}
+	}
+	p.unwrap(broke)
+	p.write(") ")
+	p.expr(f.Body)
+}
+
+func (p *printer) block(b *ast.BlockExpr) {
+	p.write("{")
+	p.indent++
+	for _, e := range b.Exprs {
+		p.newline()
+		p.expr(e)
+	}
+	p.indent--
+	p.newline()
+	p.write("}")
+}
+
+func (p *printer) args(args []ast.Arg) {
+	broke := false
+	for i, a := range args {
+		if i > 0 {
+			p.write(", ")
+			p.wrap(&broke)
+		}
+		if a.Name != "" {
+			p.write(Name(a.Name) + " = ")
+		}
+		p.expr(a.Value)
+	}
+	p.unwrap(broke)
+}
+
+var syntacticName = regexp.MustCompile(`^((([A-Za-z]|[.][A-Za-z._])[A-Za-z0-9._]*)|[.])$`)
+
+// reserved lists the words the parser does not accept as names. Inf, NaN
+// and the typed NA constants are ordinary bindings here and stay unquoted.
+var reserved = map[string]bool{
+	"if": true, "else": true, "repeat": true, "while": true, "function": true,
+	"for": true, "in": true, "next": true, "break": true, "TRUE": true,
+	"FALSE": true, "NULL": true, "NA": true,
+}
+
+// Name returns a symbol name as it has to be written in R source: names
+// that are not syntactic are quoted with backticks.
+func Name(name string) string {
+	if name == "" || name == "..." || (syntacticName.MatchString(name) && !reserved[name]) {
+		return name
+	}
+	return "`" + strings.ReplaceAll(name, "`", "\\`") + "`"
+}
+
+// Quote returns s as a double-quoted R string literal.
+func Quote(s string) string {
+	return strconv.Quote(s)
+}
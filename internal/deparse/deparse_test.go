@@ -0,0 +1,123 @@
+package deparse
+
+import (
+	"strings"
+	"testing"
+
+	"simonwaldherr.de/go/smallr/internal/parser"
+)
+
+func TestExpr(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"x+y*2", "x + y * 2"},
+		{"(a + b) * c", "(a + b) * c"},
+		{"a - (b - c)", "a - (b - c)"},
+		{"(a - b) - c", "a - b - c"},
+		{"2^3^2", "2^3^2"},
+		{"(2^3)^2", "(2^3)^2"},
+		{"-(a + b)", "-(a + b)"},
+		{"1:n", "1:n"},
+		{"!done", "!done"},
+		{"x <- y <- 1", "x <- y <- 1"},
+		{"1 -> x", "x <- 1"},
+		{"f(a, b = 2)", "f(a, b = 2)"},
+		{"x[i, ]", "x[i, ]"},
+		{"x[[\"a\"]]$b", "x[[\"a\"]]$b"},
+		{"`my var` + 1", "`my var` + 1"},
+		{"f(`if` = 1)", "f(`if` = 1)"},
+		{"if (a) b else c", "if (a) b else c"},
+		{"for (i in 1:3) print(i)", "for (i in 1:3) print(i)"},
+		{"while (TRUE) break", "while (TRUE) break"},
+		{"repeat next", "repeat next"},
+		{"function(x, y = 2, ...) x + y", "function(x, y = 2, ...) x + y"},
+		{"(function(x) x)(1)", "(function(x) x)(1)"},
+		{"y <- if (a) 1 else 2", "y <- if (a) 1 else 2"},
+		{"f <- function(v) v", "f <- function(v) v"},
+		{"g <- f <- function() NULL", "g <- f <- function() NULL"},
+		{"x + for (i in y) z", "x + for (i in y) z"},
+		{"-repeat break", "-repeat break"},
+		{"(if (a) 1 else 2) + 3", "(if (a) 1 else 2) + 3"},
+		{"a * (if (b) 1 else 2) + 3", "a * (if (b) 1 else 2) + 3"},
+		{"(y <- function(v) v)(1)", "(y <- function(v) v)(1)"},
+		{"(while (a) b)$c", "(while (a) b)$c"},
+		{"f(x <- function() 1) + 2", "f(x <- function() 1) + 2"},
+		{"{ a; b }", "{\n    a\n    b\n}"},
+		{"function(x) { if (x) { 1 } else 2 }", "function(x) {\n    if (x) {\n        1\n    } else 2\n}"},
+		{"\"a\\\"b\"", "\"a\\\"b\""},
+		{"return(x)", "return(x)"},
+		{"-2^2", "-2^2"},
+		{"(-2)^2", "(-2)^2"},
+		{"2 ** 3", "2^3"},
+		{"!a == b", "!a == b"},
+		{"(!a) == b", "(!a) == b"},
+		{"a %||% b", "a %||% b"},
+		{"`%||%`(a, b)", "a %||% b"},
+		{"(a + b) %in% c", "(a + b) %in% c"},
+		{"y ~ x + z", "y ~ x + z"},
+		{"~x", "~x"},
+		{"1 ->> x", "x <<- 1"},
+		{"base::paste(x)", "base::paste(x)"},
+		{"stats:::f", "stats:::f"},
+		{"x@slot", "x@slot"},
+		{"?help", "?help"},
+	}
+
+	for _, tt := range tests {
+		prog, err := parser.New(tt.input).ParseProgram()
+		if err != nil {
+			t.Fatalf("input %q: parse error: %v", tt.input, err)
+		}
+		got := Expr(prog.Exprs[0])
+		if got != tt.expected {
+			t.Errorf("input %q: expected %q, got %q", tt.input, tt.expected, got)
+			continue
+		}
+		// the output parses back to the same expression
+		again, err := parser.New(got).ParseProgram()
+		if err != nil {
+			t.Errorf("input %q: deparsed %q does not parse: %v", tt.input, got, err)
+			continue
+		}
+		if Expr(again.Exprs[0]) != got {
+			t.Errorf("input %q: round trip changed %q to %q", tt.input, got, Expr(again.Exprs[0]))
+		}
+	}
+}
+
+func TestOptions(t *testing.T) {
+	tests := []struct {
+		input    string
+		opts     Options
+		expected []string
+	}{
+		{"f(aaaa, bbbb, cccc)", Options{Width: 10, Indent: "  "}, []string{"f(aaaa, bbbb, ", "  cccc)"}},
+		{"f(aaaa, bbbb, cccc)", Options{Indent: "  "}, []string{"f(aaaa, bbbb, cccc)"}},
+		{"aaaa + bbbb + cccc + dddd", Options{Width: 12, Indent: "    "}, []string{"aaaa + bbbb + ", "    cccc + dddd"}},
+		{"function(alpha, beta, gamma) alpha", Options{Width: 20, Indent: "    "}, []string{"function(alpha, beta, ", "    gamma) alpha"}},
+		{"{ f(aaaa, bbbb) }", Options{Width: 8, Indent: "\t"}, []string{"{", "\tf(aaaa, ", "\t\tbbbb)", "}"}},
+		{"x <- 1", DefaultOptions, []string{"x <- 1"}},
+	}
+
+	for _, tt := range tests {
+		prog, err := parser.New(tt.input).ParseProgram()
+		if err != nil {
+			t.Fatalf("input %q: parse error: %v", tt.input, err)
+		}
+		got := tt.opts.Lines(prog.Exprs[0])
+		if strings.Join(got, "\n") != strings.Join(tt.expected, "\n") {
+			t.Errorf("input %q: expected %q, got %q", tt.input, tt.expected, got)
+			continue
+		}
+		again, err := parser.New(strings.Join(got, "\n")).ParseProgram()
+		if err != nil {
+			t.Errorf("input %q: wrapped output %q does not parse: %v", tt.input, got, err)
+			continue
+		}
+		if Expr(again.Exprs[0]) != Expr(prog.Exprs[0]) {
+			t.Errorf("input %q: wrapping changed the expression to %q", tt.input, Expr(again.Exprs[0]))
+		}
+	}
+}
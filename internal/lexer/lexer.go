@@ -2,6 +2,7 @@ package lexer
 
 import (
 	"fmt"
+	"strings"
 	"unicode"
 	"unicode/utf8"
 
@@ -17,8 +18,21 @@ type Lexer struct {
 	line int
 	col  int
 
-	parenDepth int
-	brackDepth int
+	// nest holds the open '(', '[' and '{' delimiters. Newlines separate
+	// statements at the top level and directly inside braces only.
+	nest []byte
+	// brackStack records whether each open bracket was '[[' (true) or '['
+	// (false), so that x[y[1]] closes with two single ']' tokens.
+	brackStack []bool
+}
+
+func (l *Lexer) open(ch byte) { l.nest = append(l.nest, ch) }
+
+// close pops the innermost delimiter if it matches ch.
+func (l *Lexer) close(ch byte) {
+	if n := len(l.nest); n > 0 && l.nest[n-1] == ch {
+		l.nest = l.nest[:n-1]
+	}
 }
 
 func New(src string) *Lexer {
@@ -26,6 +40,50 @@ func New(src string) *Lexer {
 }
 
 func (l *Lexer) Next() token.Token {
+	tok := l.scan()
+	tok.End = l.curPos()
+	return tok
+}
+
+// InParens reports whether the innermost open delimiter is a parenthesis
+// or bracket, where newlines do not separate statements.
+func (l *Lexer) InParens() bool {
+	n := len(l.nest)
+	return n > 0 && l.nest[n-1] != '{'
+}
+
+// Resync forgets the parentheses and brackets opened since the innermost
+// brace, so that newlines separate statements again. The parser calls it
+// when it skips over a statement with a syntax error.
+func (l *Lexer) Resync() {
+	for l.InParens() {
+		if l.nest[len(l.nest)-1] == '[' && len(l.brackStack) > 0 {
+			l.brackStack = l.brackStack[:len(l.brackStack)-1]
+		}
+		l.nest = l.nest[:len(l.nest)-1]
+	}
+}
+
+// ClosesParens reports whether the parentheses and brackets open since the
+// innermost brace are closed later in the source. It scans ahead on a copy
+// and leaves the lexer where it is.
+func (l *Lexer) ClosesParens() bool {
+	base := len(l.nest)
+	for base > 0 && l.nest[base-1] != '{' {
+		base--
+	}
+	c := *l
+	c.nest = append([]byte(nil), l.nest...)
+	c.brackStack = append([]bool(nil), l.brackStack...)
+	for len(c.nest) > base {
+		if c.scan().Type == token.EOF {
+			return false
+		}
+	}
+	return true
+}
+
+func (l *Lexer) scan() token.Token {
 	l.skipWhitespaceAndComments()
 
 	if l.pos >= len(l.src) {
@@ -36,61 +94,68 @@ func (l *Lexer) Next() token.Token {
 
 	// Newline as statement separator unless inside parens/brackets
 	if ch == '\n' {
+		p := l.curPos()
 		l.read()
-		if l.parenDepth > 0 || l.brackDepth > 0 {
+		if l.InParens() {
 			// treat as whitespace
-			return l.Next()
+			tok := l.scan()
+			tok.AfterNewline = true
+			return tok
 		}
-		return token.Token{Type: token.NL, Lit: "\n", Pos: l.curPosWithOffset(l.pos - 1)}
+		return token.Token{Type: token.NL, Lit: "\n", Pos: p}
 	}
 
 	// Delimiters affecting depth
 	if ch == '(' {
 		p := l.curPos()
 		l.read()
-		l.parenDepth++
+		l.open('(')
 		return token.Token{Type: token.LPAREN, Lit: "(", Pos: p}
 	}
 	if ch == ')' {
 		p := l.curPos()
 		l.read()
-		if l.parenDepth > 0 {
-			l.parenDepth--
-		}
+		l.close('(')
 		return token.Token{Type: token.RPAREN, Lit: ")", Pos: p}
 	}
 	if ch == '[' {
 		p := l.curPos()
 		l.read()
+		l.open('[')
 		if l.match('[') {
-			l.brackDepth++
+			l.brackStack = append(l.brackStack, true)
 			return token.Token{Type: token.LDBRACK, Lit: "[[", Pos: p}
 		}
-		l.brackDepth++
+		l.brackStack = append(l.brackStack, false)
 		return token.Token{Type: token.LBRACK, Lit: "[", Pos: p}
 	}
 	if ch == ']' {
 		p := l.curPos()
 		l.read()
-		if l.match(']') {
-			if l.brackDepth > 0 {
-				l.brackDepth--
+		// Only close with ']]' when the innermost open bracket was '[['.
+		dbl := len(l.brackStack) == 0 || l.brackStack[len(l.brackStack)-1]
+		l.close('[')
+		if dbl && l.match(']') {
+			if len(l.brackStack) > 0 {
+				l.brackStack = l.brackStack[:len(l.brackStack)-1]
 			}
 			return token.Token{Type: token.RDBRACK, Lit: "]]", Pos: p}
 		}
-		if l.brackDepth > 0 {
-			l.brackDepth--
+		if len(l.brackStack) > 0 {
+			l.brackStack = l.brackStack[:len(l.brackStack)-1]
 		}
 		return token.Token{Type: token.RBRACK, Lit: "]", Pos: p}
 	}
 	if ch == '{' {
 		p := l.curPos()
 		l.read()
+		l.open('{')
 		return token.Token{Type: token.LBRACE, Lit: "{", Pos: p}
 	}
 	if ch == '}' {
 		p := l.curPos()
 		l.read()
+		l.close('{')
 		return token.Token{Type: token.RBRACE, Lit: "}", Pos: p}
 	}
 	if ch == ',' {
@@ -113,6 +178,9 @@ func (l *Lexer) Next() token.Token {
 	if ch == '"' || ch == '\'' {
 		return l.readString()
 	}
+	if (ch == 'r' || ch == 'R') && l.pos+1 < len(l.src) && (l.src[l.pos+1] == '"' || l.src[l.pos+1] == '\'') {
+		return l.readRawString()
+	}
 
 	// Backtick identifiers
 	if ch == '`' {
@@ -150,6 +218,9 @@ func (l *Lexer) Next() token.Token {
 	case '-':
 		l.read()
 		if l.match('>') {
+			if l.match('>') {
+				return token.Token{Type: token.ASSIGN_SUPER_RIGHT, Lit: "->>", Pos: p}
+			}
 			return token.Token{Type: token.ASSIGN_RIGHT, Lit: "->", Pos: p}
 		}
 		return token.Token{Type: token.MINUS, Lit: "-", Pos: p}
@@ -176,6 +247,10 @@ func (l *Lexer) Next() token.Token {
 		return token.Token{Type: token.PLUS, Lit: "+", Pos: p}
 	case '*':
 		l.read()
+		if l.match('*') {
+			// R reads ** as ^
+			return token.Token{Type: token.CARET, Lit: "**", Pos: p}
+		}
 		return token.Token{Type: token.STAR, Lit: "*", Pos: p}
 	case '/':
 		l.read()
@@ -185,6 +260,12 @@ func (l *Lexer) Next() token.Token {
 		return token.Token{Type: token.CARET, Lit: "^", Pos: p}
 	case ':':
 		l.read()
+		if l.match(':') {
+			if l.match(':') {
+				return token.Token{Type: token.NS_GET_INT, Lit: ":::", Pos: p}
+			}
+			return token.Token{Type: token.NS_GET, Lit: "::", Pos: p}
+		}
 		return token.Token{Type: token.COLON, Lit: ":", Pos: p}
 	case '&':
 		l.read()
@@ -202,21 +283,16 @@ func (l *Lexer) Next() token.Token {
 		}
 		return token.Token{Type: token.OR, Lit: "|", Pos: p}
 	case '%':
+		return l.readSpecial()
+	case '@':
 		l.read()
-		if l.match('%') {
-			return token.Token{Type: token.MOD, Lit: "%%", Pos: p}
-		}
-		if l.match('/') && l.match('%') {
-			return token.Token{Type: token.INTDIV, Lit: "%/%", Pos: p}
-		}
-		// %in%
-		if l.pos+3 <= len(l.src) && l.src[l.pos:l.pos+3] == "in%" {
-			l.read() // i
-			l.read() // n
-			l.read() // %
-			return token.Token{Type: token.INOP, Lit: "%in%", Pos: p}
-		}
-		return token.Token{Type: token.ILLEGAL, Lit: "%", Pos: p}
+		return token.Token{Type: token.AT, Lit: "@", Pos: p}
+	case '~':
+		l.read()
+		return token.Token{Type: token.TILDE, Lit: "~", Pos: p}
+	case '?':
+		l.read()
+		return token.Token{Type: token.QUESTION, Lit: "?", Pos: p}
 	}
 
 	// Unknown
@@ -253,39 +329,29 @@ func (l *Lexer) readString() token.Token {
 	p := l.curPos()
 	l.read() // consume quote
 	var out []rune
+	bad := "" // first malformed escape; the string is still read to its end
 	for {
 		if l.pos >= len(l.src) {
 			return token.Token{Type: token.ILLEGAL, Lit: "unterminated string", Pos: p}
 		}
 		ch := l.peek()
 		if ch == quote {
-			lit := string(out)
 			l.read()
-			return token.Token{Type: token.STRING, Lit: lit, Pos: p}
+			if bad != "" {
+				return token.Token{Type: token.ILLEGAL, Lit: bad, Pos: p}
+			}
+			return token.Token{Type: token.STRING, Lit: string(out), Pos: p}
 		}
 		if ch == '\\' {
 			l.read()
 			if l.pos >= len(l.src) {
 				return token.Token{Type: token.ILLEGAL, Lit: "unterminated escape", Pos: p}
 			}
-			esc := l.peek()
-			l.read()
-			switch esc {
-			case 'n':
-				out = append(out, '\n')
-			case 't':
-				out = append(out, '\t')
-			case 'r':
-				out = append(out, '\r')
-			case '\\':
-				out = append(out, '\\')
-			case '"':
-				out = append(out, '"')
-			case '\'':
-				out = append(out, '\'')
-			default:
-				out = append(out, esc)
+			r, msg := l.readEscape()
+			if msg != "" && bad == "" {
+				bad = msg
 			}
+			out = append(out, r)
 			continue
 		}
 		out = append(out, ch)
@@ -293,6 +359,113 @@ func (l *Lexer) readString() token.Token {
 	}
 }
 
+var simpleEscapes = map[rune]rune{
+	'n': '\n', 't': '\t', 'r': '\r', 'a': '\a', 'b': '\b', 'f': '\f', 'v': '\v',
+	'\\': '\\', '"': '"', '\'': '\'', '`': '`', ' ': ' ', '\n': '\n',
+}
+
+// readEscape reads the escape sequence after a backslash. Besides the
+// single-character escapes it accepts octal \ooo, \xhh, \unnnn or \u{nnnn}
+// and \Unnnnnnnn or \U{nnnnnnnn}. It returns a message for malformed
+// sequences and for the nul character, which strings cannot hold.
+func (l *Lexer) readEscape() (rune, string) {
+	esc := l.read()
+	var r rune
+	switch esc {
+	case 'x':
+		r = l.readCodePoint(2, false)
+		if r < 0 {
+			return 0, "'\\x' used without hex digits"
+		}
+	case 'u', 'U':
+		max := 4
+		if esc == 'U' {
+			max = 8
+		}
+		r = l.readCodePoint(max, true)
+		if r < 0 || r > unicode.MaxRune || (r >= 0xD800 && r <= 0xDFFF) {
+			return 0, fmt.Sprintf("invalid \\%c escape", esc)
+		}
+	default:
+		if esc >= '0' && esc <= '7' {
+			r = esc - '0'
+			for i := 0; i < 2 && l.peek() >= '0' && l.peek() <= '7'; i++ {
+				r = r*8 + l.read() - '0'
+			}
+			break
+		}
+		s, ok := simpleEscapes[esc]
+		if !ok {
+			return esc, fmt.Sprintf("unrecognized escape '\\%c'", esc)
+		}
+		return s, ""
+	}
+	if r == 0 {
+		return 0, "nul character not allowed"
+	}
+	return r, ""
+}
+
+// readCodePoint reads up to max hex digits, optionally enclosed in braces,
+// and returns their value or -1 if there are none.
+func (l *Lexer) readCodePoint(max int, braces bool) rune {
+	braced := braces && l.match('{')
+	r, n := rune(0), 0
+	for ; n < max && isHexDigit(l.peek()); n++ {
+		d := l.read()
+		switch {
+		case d >= 'a':
+			d -= 'a' - 10
+		case d >= 'A':
+			d -= 'A' - 10
+		default:
+			d -= '0'
+		}
+		r = r*16 + d
+	}
+	if n == 0 || (braced && !l.match('}')) {
+		return -1
+	}
+	return r
+}
+
+// readRawString reads a raw string such as r"(...)", r"[...]" or
+// r"---{...}---": the delimiters may be padded with dashes, and the
+// content is taken literally, backslashes and newlines included.
+func (l *Lexer) readRawString() token.Token {
+	p := l.curPos()
+	l.read() // r or R
+	quote := l.read()
+	dashes := 0
+	for l.match('-') {
+		dashes++
+	}
+	var closer rune
+	switch l.read() {
+	case '(':
+		closer = ')'
+	case '[':
+		closer = ']'
+	case '{':
+		closer = '}'
+	default:
+		return token.Token{Type: token.ILLEGAL, Lit: "malformed raw string literal", Pos: p}
+	}
+	end := string(closer) + strings.Repeat("-", dashes) + string(quote)
+	i := strings.Index(l.src[l.pos:], end)
+	if i < 0 {
+		for l.pos < len(l.src) {
+			l.read()
+		}
+		return token.Token{Type: token.ILLEGAL, Lit: "unterminated raw string", Pos: p}
+	}
+	lit := l.src[l.pos : l.pos+i]
+	for stop := l.pos + i + len(end); l.pos < stop; {
+		l.read()
+	}
+	return token.Token{Type: token.STRING, Lit: lit, Pos: p}
+}
+
 func (l *Lexer) readBacktickIdent() token.Token {
 	p := l.curPos()
 	l.read() // consume `
@@ -311,41 +484,85 @@ func (l *Lexer) readBacktickIdent() token.Token {
 	}
 }
 
-func (l *Lexer) readNumber() token.Token {
+// readSpecial reads a %op% operator: everything up to the next % on the
+// same line. The token type is the operator's spelling, which covers the
+// built-in %%, %/%, %in%, %*% and %o% as well as user-defined operators
+// (see token.IsSpecial).
+func (l *Lexer) readSpecial() token.Token {
 	p := l.curPos()
 	start := l.pos
-
-	// leading dot
-	if l.peek() == '.' {
+	end := strings.IndexAny(l.src[start+1:], "%\n")
+	if end < 0 || l.src[start+1+end] != '%' {
 		l.read()
+		return token.Token{Type: token.ILLEGAL, Lit: "%", Pos: p}
 	}
-	for l.pos < len(l.src) && isDigit(l.peek()) {
+	for l.pos <= start+1+end {
 		l.read()
 	}
+	lit := l.src[start:l.pos]
+	return token.Token{Type: token.Type(lit), Lit: lit, Pos: p}
+}
 
-	// decimal
-	if l.pos < len(l.src) && l.peek() == '.' {
-		l.read()
-		for l.pos < len(l.src) && isDigit(l.peek()) {
+// readNumber reads a numeric constant: decimal or hexadecimal, the latter
+// with an optional binary exponent (0x1p3), followed by an optional L
+// (integer) or i (imaginary) suffix. The literal keeps the suffix.
+func (l *Lexer) readNumber() token.Token {
+	p := l.curPos()
+	start := l.pos
+
+	if l.peek() == '0' && l.pos+1 < len(l.src) && (l.src[l.pos+1] == 'x' || l.src[l.pos+1] == 'X') {
+		l.read() // 0
+		l.read() // x
+		l.readDigits(isHexDigit)
+		if l.peek() == '.' {
 			l.read()
+			l.readDigits(isHexDigit)
 		}
-	}
-
-	// exponent
-	if l.pos < len(l.src) && (l.peek() == 'e' || l.peek() == 'E') {
-		l.read()
-		if l.pos < len(l.src) && (l.peek() == '+' || l.peek() == '-') {
+		if l.peek() == 'p' || l.peek() == 'P' {
+			l.readExponent()
+		}
+	} else {
+		// leading dot
+		if l.peek() == '.' {
 			l.read()
 		}
-		for l.pos < len(l.src) && isDigit(l.peek()) {
+		l.readDigits(isDigit)
+
+		// decimal
+		if l.peek() == '.' {
 			l.read()
+			l.readDigits(isDigit)
+		}
+
+		// exponent
+		if l.peek() == 'e' || l.peek() == 'E' {
+			l.readExponent()
 		}
 	}
 
+	if l.peek() == 'L' || l.peek() == 'i' {
+		l.read()
+	}
 	lit := l.src[start:l.pos]
 	return token.Token{Type: token.NUMBER, Lit: lit, Pos: p}
 }
 
+func (l *Lexer) readDigits(valid func(rune) bool) {
+	for l.pos < len(l.src) && valid(l.peek()) {
+		l.read()
+	}
+}
+
+// readExponent reads the exponent marker, an optional sign and the
+// decimal exponent.
+func (l *Lexer) readExponent() {
+	l.read()
+	if l.peek() == '+' || l.peek() == '-' {
+		l.read()
+	}
+	l.readDigits(isDigit)
+}
+
 func (l *Lexer) readIdent() token.Token {
 	p := l.curPos()
 	start := l.pos
@@ -360,7 +577,7 @@ func (l *Lexer) readIdent() token.Token {
 	l.read() // consume first
 	for l.pos < len(l.src) {
 		ch := l.peek()
-		if isIdentPart(ch) || isDigit(ch) {
+		if isIdentPart(ch) {
 			l.read()
 			continue
 		}
@@ -412,22 +629,23 @@ func (l *Lexer) curPos() token.Pos {
 	return token.Pos{Offset: l.pos, Line: l.line, Col: l.col}
 }
 
-func (l *Lexer) curPosWithOffset(off int) token.Pos {
-	// best effort: we don't backtrack line/col precisely here;
-	// used only for newline token.
-	return token.Pos{Offset: off, Line: l.line, Col: l.col}
-}
-
 func isDigit(r rune) bool {
 	return r >= '0' && r <= '9'
 }
 
+func isHexDigit(r rune) bool {
+	return isDigit(r) || (r >= 'a' && r <= 'f') || (r >= 'A' && r <= 'F')
+}
+
 func isIdentStart(r rune) bool {
 	return r == '.' || r == '_' || unicode.IsLetter(r)
 }
 
+// isIdentPart accepts what may follow the first character of a name:
+// besides '.' and '_' any letter, digit or combining mark, so that names
+// such as größe or naïve work as in a UTF-8 locale.
 func isIdentPart(r rune) bool {
-	return r == '.' || r == '_' || unicode.IsLetter(r)
+	return r == '.' || r == '_' || unicode.IsLetter(r) || unicode.IsDigit(r) || unicode.IsMark(r)
 }
 
 func (l *Lexer) DebugTokens() ([]token.Token, error) {
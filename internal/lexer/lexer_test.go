@@ -45,6 +45,12 @@ func TestNumbers(t *testing.T) {
 		{"3.14", "3.14"},
 		{".5", ".5"},
 		{"1e10", "1e10"},
+		{"5L", "5L"},
+		{"0x1F", "0x1F"},
+		{"0x1p3", "0x1p3"},
+		{"0XA.8P-1L", "0XA.8P-1L"},
+		{"2i", "2i"},
+		{"1e-3i", "1e-3i"},
 	}
 
 	for _, tt := range tests {
@@ -66,6 +72,15 @@ func TestStrings(t *testing.T) {
 	}{
 		{`"hello"`, "hello"},
 		{`'world'`, "world"},
+		{`"a\tb\\c\"d"`, "a\tb\\c\"d"},
+		{`"\x41\x7e"`, "A~"},
+		{`"\101\60"`, "A0"},
+		{`"\u00e9\u{e9}\U0001F600\U{1F600}"`, "éé😀😀"},
+		{"'\\a\\b\\f\\v\\`'", "\a\b\f\v`"},
+		{`r"(C:\path\n)"`, `C:\path\n`},
+		{`R'[a"b]'`, `a"b`},
+		{`r"-(x)")-"`, `x)"`},
+		{`r"{` + "\n" + `}"`, "\n"},
 	}
 
 	for _, tt := range tests {
@@ -80,6 +95,50 @@ func TestStrings(t *testing.T) {
 	}
 }
 
+func TestStringErrors(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`"\q" + 1`, `unrecognized escape '\q'`},
+		{`"\x"`, `'\x' used without hex digits`},
+		{`"\u{1F600}"`, `invalid \u escape`},
+		{`"\0"`, "nul character not allowed"},
+		{`r"(abc"`, "unterminated raw string"},
+		{`r"abc"`, "malformed raw string literal"},
+	}
+
+	for _, tt := range tests {
+		tok := New(tt.input).Next()
+		if tok.Type != token.ILLEGAL || tok.Lit != tt.expected {
+			t.Errorf("input %s: expected ILLEGAL %q, got %s %q", tt.input, tt.expected, tok.Type, tok.Lit)
+		}
+	}
+	// the malformed string is skipped as a whole
+	l := New(`"\q" + 1`)
+	l.Next()
+	if tok := l.Next(); tok.Type != token.PLUS {
+		t.Errorf("expected '+' after the malformed string, got %s", tok.Type)
+	}
+}
+
+func TestIdentifiers(t *testing.T) {
+	tests := []string{"x", ".hidden", "a_b.c2", "größe", "naïve", "résumé2", "ʻokina", "변수", "x\u0301"}
+	for _, input := range tests {
+		tok := New(input + " ").Next()
+		if tok.Type != token.IDENT || tok.Lit != input {
+			t.Errorf("expected identifier %q, got %s %q", input, tok.Type, tok.Lit)
+		}
+	}
+	// r and R are names unless a quote follows
+	l := New("r + R")
+	for _, want := range []token.Type{token.IDENT, token.PLUS, token.IDENT} {
+		if tok := l.Next(); tok.Type != want {
+			t.Errorf("expected %s, got %s", want, tok.Type)
+		}
+	}
+}
+
 func TestOperators(t *testing.T) {
 	tests := []struct {
 		input    string
@@ -94,6 +153,22 @@ func TestOperators(t *testing.T) {
 		{">", token.GT},
 		{">=", token.GTE},
 		{"%%", token.MOD},
+		{"%*%", token.MATMUL},
+		{"%o%", token.OUTER},
+		{"%/%", token.INTDIV},
+		{"%in%", token.INOP},
+		{"%||%", token.Type("%||%")},
+		{"%>%", token.Type("%>%")},
+		{"% a b %", token.Type("% a b %")},
+		{"%\n%", token.ILLEGAL},
+		{"->", token.ASSIGN_RIGHT},
+		{"->>", token.ASSIGN_SUPER_RIGHT},
+		{"**", token.CARET},
+		{"@", token.AT},
+		{"?", token.QUESTION},
+		{"::", token.NS_GET},
+		{":::", token.NS_GET_INT},
+		{"~", token.TILDE},
 	}
 
 	for _, tt := range tests {
@@ -128,3 +203,80 @@ func TestKeywords(t *testing.T) {
 		}
 	}
 }
+
+func TestBrackets(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected []token.Type
+	}{
+		{
+			input:    "x[[1]]",
+			expected: []token.Type{token.IDENT, token.LDBRACK, token.NUMBER, token.RDBRACK, token.EOF},
+		},
+		{
+			input:    "x[y[1]]",
+			expected: []token.Type{token.IDENT, token.LBRACK, token.IDENT, token.LBRACK, token.NUMBER, token.RBRACK, token.RBRACK, token.EOF},
+		},
+		{
+			input:    "x[[y[1]]]",
+			expected: []token.Type{token.IDENT, token.LDBRACK, token.IDENT, token.LBRACK, token.NUMBER, token.RBRACK, token.RDBRACK, token.EOF},
+		},
+		{
+			// newlines separate statements in braces, even inside a call
+			input:    "f(\n{ a\nb })",
+			expected: []token.Type{token.IDENT, token.LPAREN, token.LBRACE, token.IDENT, token.NL, token.IDENT, token.RBRACE, token.RPAREN, token.EOF},
+		},
+	}
+
+	for _, tt := range tests {
+		l := New(tt.input)
+		for i, expectedType := range tt.expected {
+			tok := l.Next()
+			if tok.Type != expectedType {
+				t.Errorf("test %q token %d: expected %s, got %s", tt.input, i, expectedType, tok.Type)
+			}
+		}
+	}
+}
+
+func TestTokenPositions(t *testing.T) {
+	l := New("foo(1,\n  bar)\nx")
+	want := []struct {
+		typ          token.Type
+		pos, end     string
+		afterNewline bool
+	}{
+		{token.IDENT, "1:1", "1:4", false},
+		{token.LPAREN, "1:4", "1:5", false},
+		{token.NUMBER, "1:5", "1:6", false},
+		{token.COMMA, "1:6", "1:7", false},
+		{token.IDENT, "2:3", "2:6", true},
+		{token.RPAREN, "2:6", "2:7", false},
+		{token.NL, "2:7", "3:1", false},
+		{token.IDENT, "3:1", "3:2", false},
+	}
+	for i, w := range want {
+		tok := l.Next()
+		if tok.Type != w.typ || tok.Pos.String() != w.pos || tok.End.String() != w.end || tok.AfterNewline != w.afterNewline {
+			t.Errorf("token %d: expected %s %s-%s newline=%v, got %s %s-%s newline=%v",
+				i, w.typ, w.pos, w.end, w.afterNewline, tok.Type, tok.Pos, tok.End, tok.AfterNewline)
+		}
+	}
+}
+
+func TestResync(t *testing.T) {
+	l := New("f(a\nb\nc")
+	for i := 0; i < 3; i++ {
+		l.Next() // f ( a
+	}
+	if !l.InParens() || l.ClosesParens() {
+		t.Fatalf("expected an unclosed parenthesis")
+	}
+	if tok := l.Next(); tok.Type != token.IDENT || !tok.AfterNewline {
+		t.Fatalf("expected b after a swallowed newline, got %v", tok)
+	}
+	l.Resync()
+	if tok := l.Next(); tok.Type != token.NL {
+		t.Errorf("expected a newline after Resync, got %s", tok.Type)
+	}
+}
@@ -0,0 +1,156 @@
+package parser
+
+import (
+	"fmt"
+	"strings"
+
+	"simonwaldherr.de/go/smallr/internal/token"
+)
+
+// Diagnostic describes a syntax error. Start and End delimit the offending
+// token; Expected lists what the parser would have accepted in its place,
+// when that is known.
+type Diagnostic struct {
+	Start    token.Pos
+	End      token.Pos
+	Msg      string
+	Expected []string
+}
+
+func (d *Diagnostic) Error() string {
+	msg := d.Start.String() + ": " + d.Msg
+	if len(d.Expected) == 0 {
+		return msg
+	}
+	quoted := make([]string, len(d.Expected))
+	for i, e := range d.Expected {
+		quoted[i] = quoteExpected(e)
+	}
+	return msg + "; expected " + strings.Join(quoted, " or ")
+}
+
+// quoteExpected quotes tokens, but not descriptions such as "expression".
+func quoteExpected(s string) string {
+	for _, r := range s {
+		if r < 'a' || r > 'z' {
+			return "'" + s + "'"
+		}
+	}
+	return s
+}
+
+// ErrorList holds every syntax error of a source text, in order. It is the
+// error ParseProgram returns.
+type ErrorList []*Diagnostic
+
+func (l ErrorList) Error() string {
+	switch len(l) {
+	case 0:
+		return "no errors"
+	case 1:
+		return l[0].Error()
+	case 2:
+		return l[0].Error() + " (and 1 more error)"
+	}
+	return fmt.Sprintf("%s (and %d more errors)", l[0].Error(), len(l)-1)
+}
+
+// errorAt records a syntax error at tok. Only the first error of a
+// statement is kept: the ones that follow are usually caused by it and
+// are dropped until the parser has skipped to the next statement.
+func (p *Parser) errorAt(tok token.Token, expected []string, format string, args ...any) {
+	if p.recovering {
+		return
+	}
+	p.recovering = true
+	end := tok.End
+	if end.Offset < tok.Pos.Offset {
+		end = tok.Pos
+	}
+	p.diags = append(p.diags, &Diagnostic{
+		Start:    tok.Pos,
+		End:      end,
+		Msg:      fmt.Sprintf(format, args...),
+		Expected: expected,
+	})
+}
+
+// unexpected reports tok in the words R uses, e.g. "unexpected symbol".
+func (p *Parser) unexpected(tok token.Token, expected ...string) {
+	p.errorAt(tok, expected, "unexpected %s", describe(tok))
+}
+
+func describe(tok token.Token) string {
+	switch tok.Type {
+	case token.IDENT:
+		return "symbol"
+	case token.NUMBER:
+		return "numeric constant"
+	case token.STRING:
+		return "string constant"
+	case token.EOF:
+		return "end of input"
+	case token.NL:
+		return "end of line"
+	case token.ILLEGAL:
+		// the lexer describes malformed strings and escapes in words;
+		// otherwise Lit is the offending input
+		if strings.Contains(tok.Lit, " ") {
+			return tok.Lit
+		}
+		return "input '" + tok.Lit + "'"
+	}
+	return "'" + string(tok.Type) + "'"
+}
+
+// endStatement moves from the last token of a statement to the first
+// token of the next one. A statement must be followed by a newline, ';'
+// or closer (the '}' of the enclosing block, or EOF). A newline swallowed
+// before the lexer was resynchronized also ends it. After a syntax error
+// the rest of the statement is skipped instead. It reports whether the
+// statement was free of errors.
+func (p *Parser) endStatement(closer token.Type) bool {
+	if !p.recovering {
+		if p.peekIs(token.NL) || p.peekIs(token.SEMI) || p.peekIs(closer) || p.peekIs(token.EOF) || p.peek.AfterNewline {
+			p.next()
+			p.skipSeparatorsCur()
+			return true
+		}
+		p.next()
+		p.unexpected(p.cur)
+	}
+	p.synchronize(closer)
+	p.skipSeparatorsCur()
+	return false
+}
+
+// synchronize skips tokens up to the next statement boundary: a newline or
+// ';', the closer of the enclosing block, or EOF. When the broken
+// statement left a parenthesis open that is never closed, the newlines the
+// lexer swallowed count as boundaries too, and the lexer is told to forget
+// that parenthesis.
+func (p *Parser) synchronize(closer token.Type) {
+	defer func() { p.recovering = false }()
+	depth := 0 // braces opened while skipping
+	for {
+		switch {
+		case p.curIs(token.EOF):
+			return
+		case p.curIs(token.LBRACE):
+			depth++
+		case p.curIs(token.RBRACE):
+			if depth == 0 && closer == token.RBRACE {
+				return
+			}
+			if depth > 0 {
+				depth--
+			}
+		case depth == 0 && (p.curIs(token.NL) || p.curIs(token.SEMI)):
+			return
+		case depth == 0 && p.cur.AfterNewline && p.l.InParens() && !p.l.ClosesParens():
+			p.l.Resync()
+			return
+		}
+		p.next()
+	}
+}
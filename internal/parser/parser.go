@@ -1,8 +1,10 @@
 package parser
 
 import (
-	"fmt"
+	"errors"
+	"math"
 	"strconv"
+	"strings"
 
 	"simonwaldherr.de/go/smallr/internal/ast"
 	"simonwaldherr.de/go/smallr/internal/lexer"
@@ -14,7 +16,8 @@ type Parser struct {
 	cur  token.Token
 	peek token.Token
 
-	errors []error
+	diags      []*Diagnostic
+	recovering bool // an error was reported in the current statement
 }
 
 func New(src string) *Parser {
@@ -26,7 +29,17 @@ func New(src string) *Parser {
 	return p
 }
 
-func (p *Parser) Errors() []error { return p.errors }
+// Errors returns the syntax errors found so far.
+func (p *Parser) Errors() []error {
+	out := make([]error, len(p.diags))
+	for i, d := range p.diags {
+		out[i] = d
+	}
+	return out
+}
+
+// Diagnostics returns the syntax errors found so far.
+func (p *Parser) Diagnostics() []*Diagnostic { return p.diags }
 
 func (p *Parser) next() {
 	p.cur = p.peek
@@ -54,86 +67,108 @@ func (p *Parser) expectPeek(t token.Type) bool {
 		p.next()
 		return true
 	}
-	p.errorf(p.peek.Pos, "expected next token %s, got %s", t, p.peek.Type)
+	p.unexpected(p.peek, string(t))
 	return false
 }
 
-func (p *Parser) errorf(pos token.Pos, format string, args ...any) {
-	p.errors = append(p.errors, fmt.Errorf("%s: %s", pos.String(), fmt.Sprintf(format, args...)))
-}
-
+// ParseProgram parses the whole source. Syntax errors do not stop it: the
+// parser skips to the next statement and carries on, so the returned
+// ErrorList holds every error. Statements with errors are left out of the
+// program.
 func (p *Parser) ParseProgram() (*ast.Program, error) {
 	prog := &ast.Program{}
 	// consume leading separators
 	p.skipSeparatorsCur()
 	for !p.curIs(token.EOF) {
 		expr := p.parseExpression(precLowest)
-		if expr != nil {
+		if p.endStatement(token.EOF) && expr != nil {
 			prog.Exprs = append(prog.Exprs, expr)
 		}
-		// advance to next statement separator or EOF
-		for p.cur.Type != token.EOF && p.cur.Type != token.NL && p.cur.Type != token.SEMI {
-			p.next()
-		}
-		p.skipSeparatorsCur()
 	}
-	if len(p.errors) > 0 {
-		return prog, p.errors[0]
+	if len(p.diags) > 0 {
+		return prog, ErrorList(p.diags)
 	}
 	return prog, nil
 }
 
 // --- Pratt precedence ---
 
+// The levels follow R's ?Syntax, loosest first. | and || share a level,
+// as do & and &&; |> binds like the %op% operators.
 const (
 	precLowest = iota
+	precHelp
 	precAssign
-	precPipe
-	precOrOr
-	precAndAnd
+	precTilde
 	precOr
 	precAnd
+	precNot
 	precCompare
 	precAdd
 	precMul
+	precSpecial
 	precColon
-	precCaret
 	precUnary
+	precCaret
 	precCall
 )
 
+// Precedence returns the binding power of an infix operator; higher
+// values bind tighter. Prefix operators bind with PrefixPrecedence. The
+// deparser uses it to decide where parentheses are needed.
+func Precedence(t token.Type) int { return precedence(t) }
+
+// UnaryPrecedence is the binding power of the prefix operators + and -.
+const UnaryPrecedence = precUnary
+
+// PrefixPrecedence returns the binding power of a prefix operator: ! takes
+// a comparison as its operand, ~ and ? take almost a whole expression.
+func PrefixPrecedence(t token.Type) int {
+	switch t {
+	case token.BANG:
+		return precNot
+	case token.TILDE:
+		return precTilde
+	case token.QUESTION:
+		return precHelp
+	}
+	return precUnary
+}
+
 func (p *Parser) peekPrecedence() int { return precedence(p.peek.Type) }
 func (p *Parser) curPrecedence() int  { return precedence(p.cur.Type) }
 
 func precedence(t token.Type) int {
 	switch t {
-	case token.ASSIGN_LEFT, token.ASSIGN_EQ, token.ASSIGN_SUPER, token.ASSIGN_RIGHT:
+	case token.QUESTION:
+		return precHelp
+	case token.ASSIGN_LEFT, token.ASSIGN_EQ, token.ASSIGN_SUPER, token.ASSIGN_RIGHT, token.ASSIGN_SUPER_RIGHT:
 		return precAssign
-	case token.PIPE:
-		return precPipe
-	case token.OROR:
-		return precOrOr
-	case token.ANDAND:
-		return precAndAnd
-	case token.OR:
+	case token.TILDE:
+		return precTilde
+	case token.OR, token.OROR:
 		return precOr
-	case token.AND:
+	case token.AND, token.ANDAND:
 		return precAnd
 	case token.LT, token.LTE, token.GT, token.GTE, token.EQ, token.NEQ:
 		return precCompare
 	case token.PLUS, token.MINUS:
 		return precAdd
-	case token.STAR, token.SLASH, token.MOD, token.INTDIV, token.INOP:
+	case token.STAR, token.SLASH:
 		return precMul
+	case token.PIPE:
+		return precSpecial
 	case token.COLON:
 		return precColon
 	case token.CARET:
 		return precCaret
-	case token.LPAREN, token.LBRACK, token.LDBRACK, token.DOLLAR:
+	case token.LPAREN, token.LBRACK, token.LDBRACK, token.DOLLAR, token.AT, token.NS_GET, token.NS_GET_INT:
 		return precCall
-	default:
-		return precLowest
 	}
+	if token.IsSpecial(t) {
+		return precSpecial
+	}
+	return precLowest
 }
 
 func (p *Parser) parseExpression(precedence int) ast.Expr {
@@ -144,7 +179,7 @@ func (p *Parser) parseExpression(precedence int) ast.Expr {
 		return nil
 	}
 
-	for !p.peekIs(token.EOF) && !p.peekIs(token.NL) && !p.peekIs(token.SEMI) && precedence < p.peekPrecedence() {
+	for !p.recovering && !p.peekIs(token.EOF) && !p.peekIs(token.NL) && !p.peekIs(token.SEMI) && precedence < p.peekPrecedence() {
 		p.next() // advance to infix token
 		left = p.parseInfix(left)
 		if left == nil {
@@ -190,15 +225,15 @@ func (p *Parser) parsePrefix() ast.Expr {
 		return p.parseReturn()
 	case token.FUNCTION:
 		return p.parseFunction()
-	case token.PLUS, token.MINUS, token.BANG:
+	case token.PLUS, token.MINUS, token.BANG, token.TILDE, token.QUESTION:
 		op := p.cur.Type
 		pos := p.cur.Pos
 		p.next()
 		p.skipSeparatorsCur()
-		x := p.parseExpression(precUnary)
+		x := p.parseExpression(PrefixPrecedence(op))
 		return &ast.UnaryExpr{P: pos, Op: op, X: x}
 	default:
-		p.errorf(p.cur.Pos, "unexpected token: %s", p.cur.Type)
+		p.unexpected(p.cur)
 		return nil
 	}
 }
@@ -206,9 +241,9 @@ func (p *Parser) parsePrefix() ast.Expr {
 func (p *Parser) parseInfix(left ast.Expr) ast.Expr {
 	switch p.cur.Type {
 	case token.PLUS, token.MINUS, token.STAR, token.SLASH, token.CARET, token.COLON,
-		token.MOD, token.INTDIV, token.INOP,
+		token.MOD, token.INTDIV, token.INOP, token.MATMUL, token.OUTER,
 		token.LT, token.LTE, token.GT, token.GTE, token.EQ, token.NEQ,
-		token.AND, token.ANDAND, token.OR, token.OROR:
+		token.AND, token.ANDAND, token.OR, token.OROR, token.TILDE, token.QUESTION:
 		op := p.cur.Type
 		pos := p.cur.Pos
 		prec := p.curPrecedence()
@@ -227,7 +262,7 @@ func (p *Parser) parseInfix(left ast.Expr) ast.Expr {
 		pos := p.cur.Pos
 		p.next()
 		p.skipSeparatorsCur()
-		right := p.parseExpression(precPipe)
+		right := p.parseExpression(precSpecial)
 		// Transform: if right is a CallExpr, prepend left as first arg
 		if call, ok := right.(*ast.CallExpr); ok {
 			newArgs := make([]ast.Arg, 0, 1+len(call.Args))
@@ -238,7 +273,7 @@ func (p *Parser) parseInfix(left ast.Expr) ast.Expr {
 		// Otherwise, treat right as a function and call it with left
 		return &ast.CallExpr{P: pos, Fun: right, Args: []ast.Arg{{Value: left}}}
 
-	case token.ASSIGN_LEFT, token.ASSIGN_EQ, token.ASSIGN_SUPER, token.ASSIGN_RIGHT:
+	case token.ASSIGN_LEFT, token.ASSIGN_EQ, token.ASSIGN_SUPER, token.ASSIGN_RIGHT, token.ASSIGN_SUPER_RIGHT:
 		op := p.cur.Type
 		pos := p.cur.Pos
 		prec := p.curPrecedence()
@@ -257,8 +292,18 @@ func (p *Parser) parseInfix(left ast.Expr) ast.Expr {
 		return p.parseIndex(left, true)
 	case token.DOLLAR:
 		return p.parseDollar(left)
+	case token.AT, token.NS_GET, token.NS_GET_INT:
+		return p.parseNameOp(left)
 	default:
-		p.errorf(p.cur.Pos, "unexpected infix token: %s", p.cur.Type)
+		if token.IsSpecial(p.cur.Type) {
+			op := p.cur.Type
+			pos := p.cur.Pos
+			p.next()
+			p.skipSeparatorsCur()
+			right := p.parseExpression(precSpecial)
+			return &ast.BinaryExpr{P: pos, Op: op, Left: left, Right: right}
+		}
+		p.unexpected(p.cur)
 		return nil
 	}
 }
@@ -285,17 +330,14 @@ func (p *Parser) parseBlock() ast.Expr {
 	p.skipSeparatorsCur()
 	for !p.curIs(token.RBRACE) && !p.curIs(token.EOF) {
 		e := p.parseExpression(precLowest)
-		if e != nil {
+		// cur is the last token of the expression (which may itself be
+		// the '}' of a nested block); move to the next one or to }
+		if p.endStatement(token.RBRACE) && e != nil {
 			exprs = append(exprs, e)
 		}
-		// move to separator or }
-		for p.cur.Type != token.EOF && p.cur.Type != token.RBRACE && p.cur.Type != token.NL && p.cur.Type != token.SEMI {
-			p.next()
-		}
-		p.skipSeparatorsCur()
 	}
 	if !p.curIs(token.RBRACE) {
-		p.errorf(p.cur.Pos, "expected }")
+		p.unexpected(p.cur, "}")
 	}
 	// current is '}'
 	return &ast.BlockExpr{P: pos, Exprs: exprs}
@@ -345,7 +387,7 @@ func (p *Parser) parseFor() ast.Expr {
 	p.next() // at '('
 	p.skipSeparatorsCur()
 	if p.cur.Type != token.IDENT {
-		p.errorf(p.cur.Pos, "expected identifier in for()")
+		p.unexpected(p.cur, "symbol")
 		return nil
 	}
 	varName := p.cur.Lit
@@ -445,7 +487,7 @@ func (p *Parser) parseFunction() ast.Expr {
 			p.skipSeparatorsCur()
 			continue
 		} else {
-			p.errorf(p.cur.Pos, "unexpected token in parameter list: %s", p.cur.Type)
+			p.unexpected(p.cur, "symbol", ")")
 			return nil
 		}
 		// after param, expect comma or ')'
@@ -457,10 +499,13 @@ func (p *Parser) parseFunction() ast.Expr {
 		} else if p.peekIs(token.COMMA) {
 			p.next()
 			p.next()
+		} else {
+			p.unexpected(p.cur, ",", ")")
+			return nil
 		}
 	}
 	if !p.curIs(token.RPAREN) {
-		p.errorf(p.cur.Pos, "expected ) to close parameter list")
+		p.unexpected(p.cur, ")")
 		return nil
 	}
 	// consume ')'
@@ -486,7 +531,7 @@ func (p *Parser) parseCall(fun ast.Expr) ast.Expr {
 		return &ast.CallExpr{P: pos, Fun: fun, Args: args}
 	}
 
-	for !p.curIs(token.RPAREN) && !p.curIs(token.EOF) {
+	for !p.curIs(token.RPAREN) && !p.curIs(token.EOF) && !p.recovering {
 		p.skipSeparatorsCur()
 		// allow stray commas
 		if p.curIs(token.COMMA) {
@@ -518,22 +563,11 @@ func (p *Parser) parseCall(fun ast.Expr) ast.Expr {
 			p.next() // cur becomes ')'
 			break
 		}
-		if p.peekIs(token.EOF) {
-			break
-		}
-
-		// Unexpected token; attempt to recover by advancing.
-		p.errorf(p.peek.Pos, "expected ',' or ')', got %s", p.peek.Type)
-		p.next()
+		p.unexpected(p.peek, ",", ")")
 	}
 
 	if !p.curIs(token.RPAREN) {
-		// try to sync at ')'
-		if p.peekIs(token.RPAREN) {
-			p.next()
-		} else {
-			p.errorf(p.cur.Pos, "expected ) to close call")
-		}
+		p.unexpected(p.cur, ")")
 	}
 	// current is ')'
 	return &ast.CallExpr{P: pos, Fun: fun, Args: args}
@@ -541,25 +575,49 @@ func (p *Parser) parseCall(fun ast.Expr) ast.Expr {
 
 func (p *Parser) parseIndex(x ast.Expr, dbl bool) ast.Expr {
 	pos := p.cur.Pos
+	closer := token.RBRACK
+	if dbl {
+		closer = token.RDBRACK
+	}
 	// current is '[' or '[['
 	// move to first token inside
 	p.next()
 	p.skipSeparatorsCur()
-	var idx ast.Expr
-	if dbl {
-		idx = p.parseExpression(precLowest)
-		if !p.expectPeek(token.RDBRACK) {
-			return &ast.IndexExpr{P: pos, X: x, Index: idx, Double: true}
+
+	// Subscripts are comma separated and may be empty (m[, 2], df[i, ])
+	// or named (drop = FALSE, exact = TRUE).
+	var args []ast.Arg
+	for {
+		var arg ast.Arg
+		if !p.curIs(token.COMMA) && !p.curIs(closer) && !p.curIs(token.EOF) {
+			if p.cur.Type == token.IDENT && p.peekIs(token.ASSIGN_EQ) {
+				arg.Name = p.cur.Lit
+				p.next() // cur becomes '='
+				p.next() // first token of value
+				p.skipSeparatorsCur()
+				if !p.curIs(token.COMMA) && !p.curIs(closer) {
+					arg.Value = p.parseExpression(precLowest)
+					p.next()
+				}
+			} else {
+				arg.Value = p.parseExpression(precLowest)
+				p.next()
+			}
+		}
+		args = append(args, arg)
+		if p.curIs(token.COMMA) {
+			p.next()
+			p.skipSeparatorsCur()
+			continue
 		}
-		// current is ']]'
-	} else {
-		idx = p.parseExpression(precLowest)
-		if !p.expectPeek(token.RBRACK) {
-			return &ast.IndexExpr{P: pos, X: x, Index: idx, Double: false}
+		if p.curIs(closer) {
+			break
 		}
-		// current is ']'
+		p.unexpected(p.cur, ",", string(closer))
+		break
 	}
-	return &ast.IndexExpr{P: pos, X: x, Index: idx, Double: dbl}
+	// current is ']' or ']]'
+	return &ast.IndexExpr{P: pos, X: x, Args: args, Double: dbl}
 }
 
 func (p *Parser) parseDollar(x ast.Expr) ast.Expr {
@@ -568,7 +626,7 @@ func (p *Parser) parseDollar(x ast.Expr) ast.Expr {
 	p.next()
 	p.skipSeparatorsCur()
 	if p.cur.Type != token.IDENT && p.cur.Type != token.STRING {
-		p.errorf(p.cur.Pos, "expected name after $")
+		p.unexpected(p.cur, "symbol", "string")
 		return &ast.DollarExpr{P: pos, X: x, Name: ""}
 	}
 	name := p.cur.Lit
@@ -576,21 +634,50 @@ func (p *Parser) parseDollar(x ast.Expr) ast.Expr {
 	return &ast.DollarExpr{P: pos, X: x, Name: name}
 }
 
+// parseNameOp parses x@name, pkg::name and pkg:::name. The right operand
+// must be a name or a string, which becomes a name.
+func (p *Parser) parseNameOp(left ast.Expr) ast.Expr {
+	op := p.cur.Type
+	pos := p.cur.Pos
+	p.next()
+	if p.cur.Type != token.IDENT && p.cur.Type != token.STRING {
+		p.unexpected(p.cur, "symbol", "string")
+		return nil
+	}
+	name := &ast.Ident{P: p.cur.Pos, Name: p.cur.Lit}
+	return &ast.BinaryExpr{P: pos, Op: op, Left: left, Right: name}
+}
+
+// parseNumber follows R's typing of constants: 5 and 0x10 are double,
+// 5L is integer and 5i is complex. An L suffix on a value that is not a
+// whole number in the integer range yields a double, as in R.
 func (p *Parser) parseNumber() ast.Expr {
 	pos := p.cur.Pos
 	txt := p.cur.Lit
-	// heuristics for int vs double
-	isInt := true
-	for _, c := range txt {
-		if c == '.' || c == 'e' || c == 'E' {
-			isInt = false
-			break
-		}
-	}
-	v, err := strconv.ParseFloat(txt, 64)
-	if err != nil {
-		p.errorf(pos, "invalid number: %s", txt)
+	num := txt
+	suffix := byte(0)
+	if n := len(num); n > 0 && (num[n-1] == 'L' || num[n-1] == 'i') {
+		suffix, num = num[n-1], num[:n-1]
+	}
+	if isHex(num) && !strings.ContainsAny(num, "pP") {
+		num += "p0"
+	}
+	v, err := strconv.ParseFloat(num, 64)
+	if err != nil && !errors.Is(err, strconv.ErrRange) {
+		// out of range is not an error: 1e400 is Inf and 1e-400 is 0, as in R
+		p.errorAt(p.cur, nil, "invalid number: %s", txt)
 		v = 0
 	}
-	return &ast.NumberLit{P: pos, Text: txt, Value: v, IsInt: isInt}
+	lit := &ast.NumberLit{P: pos, Text: txt, Value: v}
+	switch suffix {
+	case 'L':
+		lit.IsInt = v == math.Trunc(v) && math.Abs(v) <= math.MaxInt32
+	case 'i':
+		lit.IsImag = true
+	}
+	return lit
+}
+
+func isHex(s string) bool {
+	return len(s) > 1 && s[0] == '0' && (s[1] == 'x' || s[1] == 'X')
 }
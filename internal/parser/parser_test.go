@@ -1,6 +1,9 @@
 package parser
 
 import (
+	"errors"
+	"math"
+	"strings"
 	"testing"
 
 	"simonwaldherr.de/go/smallr/internal/ast"
@@ -10,9 +13,23 @@ func TestParseNumbers(t *testing.T) {
 	tests := []struct {
 		input    string
 		expected float64
+		isInt    bool
+		isImag   bool
 	}{
-		{"42", 42},
-		{"3.14", 3.14},
+		{"42", 42, false, false},
+		{"3.14", 3.14, false, false},
+		{"42L", 42, true, false},
+		{"1e3L", 1000, true, false},
+		{"1.5L", 1.5, false, false},
+		{"3000000000L", 3e9, false, false},
+		{"0x10", 16, false, false},
+		{"0xFFL", 255, true, false},
+		{"0x1p3", 8, false, false},
+		{"0x1.8p1", 3, false, false},
+		{"2i", 2, false, true},
+		{"0.5i", 0.5, false, true},
+		{"1e400", math.Inf(1), false, false},
+		{"1e-400", 0, false, false},
 	}
 
 	for _, tt := range tests {
@@ -31,6 +48,12 @@ func TestParseNumbers(t *testing.T) {
 		if lit.Value != tt.expected {
 			t.Errorf("expected %f, got %f", tt.expected, lit.Value)
 		}
+		if lit.IsInt != tt.isInt || lit.IsImag != tt.isImag {
+			t.Errorf("%s: expected IsInt=%v IsImag=%v, got %v %v", tt.input, tt.isInt, tt.isImag, lit.IsInt, lit.IsImag)
+		}
+		if lit.Text != tt.input {
+			t.Errorf("expected text %q, got %q", tt.input, lit.Text)
+		}
 	}
 }
 
@@ -56,6 +79,46 @@ func TestParseBinaryExpr(t *testing.T) {
 	}
 }
 
+func TestParsePrecedence(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"-2^2", "(-(2 ^ 2))"},
+		{"2^-1", "(2 ^ (-1))"},
+		{"-1:2", "((-1) : 2)"},
+		{"2 ** 3 ** 2", "(2 ^ (3 ^ 2))"},
+		{"2 * 5 %% 3", "(2 * (5 %% 3))"},
+		{"a %||% b %>% c", "((a %||% b) %>% c)"},
+		{"1:n %in% x", "((1 : n) %in% x)"},
+		{"!a == b", "(!(a == b))"},
+		{"!a & b", "((!a) & b)"},
+		{"a | b && c", "(a | (b && c))"},
+		{"y ~ a + b", "(y ~ (a + b))"},
+		{"~ a | b", "(~(a | b))"},
+		{"f <- y ~ x", "(f <- (y ~ x))"},
+		{"1 -> x", "(1 -> x)"},
+		{"1 ->> x", "(1 ->> x)"},
+		{"?mean", "(?mean)"},
+		{"?x <- 1", "(?(x <- 1))"},
+		{"base::sum(x)", "(base :: sum)(x)"},
+		{"x@a$b", "(x @ a)$b"},
+		{"x |> f() + 1", "(f(x) + 1)"},
+	}
+
+	for _, tt := range tests {
+		p := New(tt.input)
+		prog, err := p.ParseProgram()
+		if err != nil {
+			t.Errorf("input %q: ParseProgram() error: %v", tt.input, err)
+			continue
+		}
+		if got := prog.Exprs[0].String(); got != tt.expected {
+			t.Errorf("input %q: expected %s, got %s", tt.input, tt.expected, got)
+		}
+	}
+}
+
 func TestParseAssignment(t *testing.T) {
 	tests := []string{
 		"x <- 10",
@@ -107,6 +170,25 @@ func TestParseFunction(t *testing.T) {
 	}
 }
 
+func TestParseNestedBlock(t *testing.T) {
+	p := New("local({ n <- 0; function() { n } })")
+	prog, err := p.ParseProgram()
+	if err != nil {
+		t.Fatalf("ParseProgram() error: %v", err)
+	}
+	call, ok := prog.Exprs[0].(*ast.CallExpr)
+	if !ok {
+		t.Fatalf("expected CallExpr, got %T", prog.Exprs[0])
+	}
+	block, ok := call.Args[0].Value.(*ast.BlockExpr)
+	if !ok {
+		t.Fatalf("expected BlockExpr argument, got %T", call.Args[0].Value)
+	}
+	if len(block.Exprs) != 2 {
+		t.Errorf("expected 2 expressions in block, got %d", len(block.Exprs))
+	}
+}
+
 func TestParseCall(t *testing.T) {
 	tests := []struct {
 		input    string
@@ -163,3 +245,107 @@ func TestParseIf(t *testing.T) {
 		}
 	}
 }
+
+func TestParseIndex(t *testing.T) {
+	tests := []struct {
+		input    string
+		argCount int
+		double   bool
+	}{
+		{"x[1]", 1, false},
+		{"x[]", 1, false},
+		{"m[1, 2]", 2, false},
+		{"m[, 2]", 2, false},
+		{"df[x > 3, ]", 2, false},
+		{"df[1, 2, drop = FALSE]", 3, false},
+		{"x[[1]]", 1, true},
+		{"x[[\"a\", exact = FALSE]]", 2, true},
+	}
+
+	for _, tt := range tests {
+		p := New(tt.input)
+		prog, err := p.ParseProgram()
+		if err != nil {
+			t.Fatalf("input %q: ParseProgram() error: %v", tt.input, err)
+		}
+		if len(prog.Exprs) != 1 {
+			t.Fatalf("input %q: expected 1 expression, got %d", tt.input, len(prog.Exprs))
+		}
+		ix, ok := prog.Exprs[0].(*ast.IndexExpr)
+		if !ok {
+			t.Fatalf("input %q: expected IndexExpr, got %T", tt.input, prog.Exprs[0])
+		}
+		if len(ix.Args) != tt.argCount {
+			t.Errorf("input %q: expected %d args, got %d", tt.input, tt.argCount, len(ix.Args))
+		}
+		if ix.Double != tt.double {
+			t.Errorf("input %q: expected double=%v, got %v", tt.input, tt.double, ix.Double)
+		}
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		input    string
+		exprs    int
+		expected []string
+	}{
+		{"x <- 1 2\ny <- )\nz <- 3", 1, []string{"1:8: unexpected numeric constant", "2:6: unexpected ')'"}},
+		{"f(1, 2\nx <- 5\ny <- 6", 2, []string{"2:1: unexpected symbol; expected ',' or ')'"}},
+		{"list(\n  a = 1\n  b = 2\n)\nq <- 1", 1, []string{"3:3: unexpected symbol; expected ',' or ')'"}},
+		{"f <- function(a b) a\ng <- function() {\n  x <- * 2\n  z\n}", 1, []string{
+			"1:17: unexpected symbol; expected ',' or ')'",
+			"3:8: unexpected '*'",
+		}},
+		{"}\nif (x) else 2\nok", 1, []string{"1:1: unexpected '}'", "2:8: unexpected 'else'"}},
+		{"a$1; for (1 in x) y", 0, []string{
+			"1:3: unexpected numeric constant; expected symbol or string",
+			"1:11: unexpected numeric constant; expected symbol",
+		}},
+		{"x[1,\n\"open", 0, []string{"2:1: unexpected unterminated string"}},
+		{"x <- \"a\\qb\"\ny <- 0x", 0, []string{"1:6: unexpected unrecognized escape '\\q'", "2:6: invalid number: 0x"}},
+		{"f(1", 0, []string{"1:4: unexpected end of input; expected ',' or ')'"}},
+		{"{ a\n", 0, []string{"2:1: unexpected end of input; expected '}'"}},
+	}
+
+	for _, tt := range tests {
+		p := New(tt.input)
+		prog, err := p.ParseProgram()
+		if err == nil {
+			t.Errorf("input %q: expected errors", tt.input)
+			continue
+		}
+		diags := p.Diagnostics()
+		var got []string
+		for _, d := range diags {
+			got = append(got, d.Error())
+		}
+		if strings.Join(got, "\n") != strings.Join(tt.expected, "\n") {
+			t.Errorf("input %q: expected %q, got %q", tt.input, tt.expected, got)
+		}
+		var list ErrorList
+		if !errors.As(err, &list) || len(list) != len(diags) {
+			t.Errorf("input %q: expected an ErrorList of %d, got %v", tt.input, len(diags), err)
+		}
+		if len(prog.Exprs) != tt.exprs {
+			t.Errorf("input %q: expected %d valid expressions, got %d", tt.input, tt.exprs, len(prog.Exprs))
+		}
+	}
+}
+
+func TestDiagnosticRange(t *testing.T) {
+	p := New("x <- foo bar")
+	if _, err := p.ParseProgram(); err == nil {
+		t.Fatal("expected an error")
+	}
+	d := p.Diagnostics()[0]
+	if d.Start.Col != 10 || d.End.Col != 13 || d.Start.Line != 1 || d.End.Line != 1 {
+		t.Errorf("expected range 1:10-1:13, got %s-%s", d.Start, d.End)
+	}
+	if d.Msg != "unexpected symbol" || d.Expected != nil {
+		t.Errorf("unexpected diagnostic %+v", d)
+	}
+	if got := (ErrorList{d, d, d}).Error(); got != "1:10: unexpected symbol (and 2 more errors)" {
+		t.Errorf("unexpected ErrorList message %q", got)
+	}
+}
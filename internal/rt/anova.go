@@ -0,0 +1,465 @@
+package rt
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"simonwaldherr.de/go/smallr/internal/deparse"
+)
+
+// builtinAnova implements anova(object, ..., test). For one fit it
+// returns the table of the terms added one after another: sums of
+// squares with F tests for lm, deviances for glm. For several fits it
+// compares them, in the order given, as nested models. glm tables are
+// tested by chi-squared when the dispersion is fixed and by F when it is
+// estimated, unless test says otherwise; test = FALSE leaves the tests
+// out.
+func builtinAnova(ctx *Context, args []ArgValue) (Value, error) {
+	fargs, err := forceArgs(ctx, args)
+	if err != nil {
+		return nil, err
+	}
+	m, rest := matchArgs(fargs, "object", "...")
+	fl, err := lmArg(ctx, "anova", m[0])
+	if err != nil {
+		return nil, err
+	}
+	models := []*fittedLm{fl}
+	var testArg Value
+	for _, a := range rest {
+		if a.Name == "test" {
+			testArg = a.Val
+			continue
+		}
+		other, err := lmArg(ctx, "anova", a.Val)
+		if err != nil {
+			return nil, err
+		}
+		if (other.family == nil) != (fl.family == nil) {
+			return nil, fmt.Errorf("models are not all of the same class")
+		}
+		models = append(models, other)
+	}
+	test, err := anovaTest(testArg, fl)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case len(models) > 1:
+		return anovaModels(ctx, models, test)
+	case fl.family != nil:
+		return anovaGlm(ctx, fl, test)
+	}
+	return anovaLm(ctx, fl)
+}
+
+// anovaTest reads the test argument of anova(): "" for no test.
+func anovaTest(v Value, fl *fittedLm) (string, error) {
+	if lv, ok := v.(*LogicalVec); ok && lv.Len() == 1 && !lv.Data[0].NA && !lv.Data[0].Val {
+		return "", nil
+	}
+	if v == nil {
+		if fl.family != nil && fl.family.fixedDispersion() {
+			return "Chisq", nil
+		}
+		return "F", nil
+	}
+	test, err := choiceArg(v, "test", "Chisq", "LRT", "F")
+	if test == "LRT" {
+		test = "Chisq"
+	}
+	return test, err
+}
+
+// anovaLm returns the sequential sums of squares of an lm fit, computed
+// from its effects: each term accounts for the squared effects of its
+// estimable columns.
+func anovaLm(ctx *Context, fl *fittedLm) (Value, error) {
+	mt, err := fl.terms(ctx)
+	if err != nil {
+		return nil, err
+	}
+	labels := mt.labels()
+	effects := toFloats(listElem(fl.obj, "effects"))
+	assign := toFloats(listElem(fl.obj, "assign"))
+	if len(assign) != len(fl.coef) || len(effects) < fl.qr.rank {
+		return nil, fmt.Errorf("invalid \"lm\" object")
+	}
+	var rowNames []string
+	var df, ss []float64
+	index := map[int]int{}
+	for k, j := range fl.estimable() {
+		t := int(assign[j])
+		if t == 0 {
+			continue
+		}
+		i, ok := index[t]
+		if !ok {
+			i = len(ss)
+			index[t] = i
+			rowNames = append(rowNames, labels[t-1])
+			df = append(df, 0)
+			ss = append(ss, 0)
+		}
+		df[i]++
+		ss[i] += effects[k] * effects[k]
+	}
+	var mss float64
+	for _, s := range ss {
+		mss += s
+	}
+	ssr, dfr := fl.rss(), float64(fl.rdf)
+	if ssr < 1e-10*mss {
+		if err := ctx.warn("ANOVA F-tests on an essentially perfect fit are unreliable"); err != nil {
+			return nil, err
+		}
+	}
+	nt := len(ss)
+	if dfr > 0 {
+		rowNames = append(rowNames, "Residuals")
+		df = append(df, dfr)
+		ss = append(ss, ssr)
+	}
+	ms := make([]float64, len(ss))
+	f := make([]float64, len(ss))
+	p := make([]float64, len(ss))
+	for i := range ss {
+		ms[i] = ss[i] / df[i]
+		f[i], p[i] = math.NaN(), math.NaN()
+		if i < nt {
+			f[i] = ms[i] / (ssr / dfr)
+			p[i] = pfFn(f[i], df[i], dfr, false, false)
+		}
+	}
+	heading := []string{"Analysis of Variance Table\n", "Response: " + mt.varNames[0]}
+	return anovaValue(heading, rowNames, []string{"Df", "Sum Sq", "Mean Sq", "F value", "Pr(>F)"}, [][]float64{df, ss, ms, f, p}), nil
+}
+
+// anovaGlm returns the analysis of deviance of a glm: the fits of the
+// model with its terms added one after another, from the null model up,
+// refitted to the data of the fit.
+func anovaGlm(ctx *Context, fl *fittedLm, test string) (Value, error) {
+	mt, err := fl.terms(ctx)
+	if err != nil {
+		return nil, err
+	}
+	mf, ok := listElem(fl.obj, "model").(*ListVec)
+	if !ok {
+		return nil, fmt.Errorf("invalid \"glm\" object: no 'model'")
+	}
+	mm, err := modelMatrix(ctx, mt, mf)
+	if err != nil {
+		return nil, err
+	}
+	x, err := finiteFloats(ctx, mm, "x")
+	if err != nil {
+		return nil, err
+	}
+	n, p, _ := matrixDims(mm)
+	assignAttr, _ := mm.GetAttr("assign")
+	assign := toFloats(assignAttr)
+	ctl, err := controlArg(ctx, listElem(fl.obj, "control"))
+	if err != nil {
+		return nil, err
+	}
+	y, _, w := glmResponseFromValue(fl.obj)
+	labels := mt.labels()
+	scalar := func(name string) float64 { return toFloats(listElem(fl.obj, name))[0] }
+	resdf := []float64{scalar("df.null")}
+	resdev := []float64{scalar("null.deviance")}
+	for t := 1; t < len(labels); t++ {
+		var sub []float64
+		cols := 0
+		for j := 0; j < p; j++ {
+			if int(assign[j]) <= t {
+				sub = append(sub, x[j*n:(j+1)*n]...)
+				cols++
+			}
+		}
+		r := &glmResponse{y: append([]float64(nil), y...), weights: w, n: make([]float64, n)}
+		for i := range r.n {
+			r.n[i] = 1
+		}
+		fit, err := fitGlm(ctx, sub, n, cols, r, fl.family, mt.intercept, nil, ctl)
+		if err != nil {
+			return nil, err
+		}
+		resdf = append(resdf, float64(fit.dfResid))
+		resdev = append(resdev, fit.dev)
+	}
+	if len(labels) > 0 {
+		resdf = append(resdf, float64(fl.rdf))
+		resdev = append(resdev, scalar("deviance"))
+	}
+	df := []float64{math.NaN()}
+	dev := []float64{math.NaN()}
+	for i := 1; i < len(resdf); i++ {
+		df = append(df, resdf[i-1]-resdf[i])
+		dev = append(dev, math.Max(0, resdev[i-1]-resdev[i]))
+	}
+	cols := [][]float64{df, dev, resdf, resdev}
+	colNames := []string{"Df", "Deviance", "Resid. Df", "Resid. Dev"}
+	if test != "" {
+		if cols, colNames, err = glmTests(ctx, fl, cols, colNames, test, 1); err != nil {
+			return nil, err
+		}
+	}
+	heading := []string{"Analysis of Deviance Table\n\nModel: " + fl.family.family + ", link: " + fl.family.link +
+		"\n\nResponse: " + mt.varNames[0] + "\n\nTerms added sequentially (first to last)\n\n"}
+	return anovaValue(heading, append([]string{"NULL"}, labels...), colNames, cols), nil
+}
+
+// glmTests adds the test columns to a table of deviances for a glm whose
+// dispersion is that of fl, in column dev of cols.
+func glmTests(ctx *Context, fl *fittedLm, cols [][]float64, colNames []string, test string, dev int) ([][]float64, []string, error) {
+	scale, dfScale := fl.dispersion(), math.Inf(1)
+	if !fl.family.fixedDispersion() {
+		dfScale = float64(fl.rdf)
+	} else if test == "F" {
+		if err := ctx.warn(fmt.Sprintf("using F test with a '%s' family is inappropriate", fl.family.family)); err != nil {
+			return nil, nil, err
+		}
+	}
+	df := cols[indexOf(colNames, "Df")]
+	cols, colNames = statAnova(cols, colNames, df, cols[dev], test, scale, dfScale)
+	return cols, colNames, nil
+}
+
+func indexOf(list []string, s string) int {
+	for i, e := range list {
+		if e == s {
+			return i
+		}
+	}
+	return -1
+}
+
+// statAnova adds the columns of a chi-squared or F test of the changes
+// dev, on df degrees of freedom, to an anova table, as R's stat.anova.
+// Changes of zero degrees of freedom or of the wrong sign are not tested.
+func statAnova(cols [][]float64, colNames []string, df, dev []float64, test string, scale, dfScale float64) ([][]float64, []string) {
+	n := len(df)
+	stat := make([]float64, n)
+	p := make([]float64, n)
+	for i := range stat {
+		stat[i], p[i] = math.NaN(), math.NaN()
+		if math.IsNaN(df[i]) || df[i] == 0 || math.IsNaN(dev[i]) {
+			continue
+		}
+		k := math.Abs(df[i])
+		if test == "F" {
+			stat[i] = dev[i] / df[i] / scale
+		} else {
+			stat[i] = dev[i] / scale
+			if df[i] < 0 {
+				stat[i] = -stat[i]
+			}
+		}
+		if stat[i] < 0 {
+			stat[i] = math.NaN()
+			continue
+		}
+		switch {
+		case test != "F":
+			p[i] = pchisqFn(stat[i], k, false, false)
+		case math.IsInf(dfScale, 1):
+			p[i] = pchisqFn(stat[i]*k, k, false, false)
+		default:
+			p[i] = pfFn(stat[i], k, dfScale, false, false)
+		}
+	}
+	if test == "F" {
+		return append(cols, stat, p), append(colNames, "F", "Pr(>F)")
+	}
+	return append(cols, p), append(colNames, "Pr(>Chi)")
+}
+
+// anovaModels compares several lm or glm fits by their residual sums of
+// squares or deviances. The tests use the scale of the model with the
+// fewest residual degrees of freedom.
+func anovaModels(ctx *Context, models []*fittedLm, test string) (Value, error) {
+	k := len(models)
+	resdf := make([]float64, k)
+	resdev := make([]float64, k)
+	formulas := make([]string, k)
+	big := 0
+	for i, fl := range models {
+		resdf[i] = float64(fl.rdf)
+		if fl.family != nil {
+			resdev[i] = toFloats(listElem(fl.obj, "deviance"))[0]
+		} else {
+			resdev[i] = fl.rss()
+		}
+		f, err := asFormula(ctx, fl.obj, ctx.callingEnv())
+		if err != nil {
+			return nil, err
+		}
+		formulas[i] = f.String()
+		if resdf[i] < resdf[big] {
+			big = i
+		}
+	}
+	df := []float64{math.NaN()}
+	dev := []float64{math.NaN()}
+	rowNames := []string{"1"}
+	for i := 1; i < k; i++ {
+		df = append(df, resdf[i-1]-resdf[i])
+		dev = append(dev, resdev[i-1]-resdev[i])
+		rowNames = append(rowNames, strconv.Itoa(i+1))
+	}
+	w := len(strconv.Itoa(k))
+	notes := make([]string, k)
+	for i, f := range formulas {
+		notes[i] = "Model " + padCell(strconv.Itoa(i+1), w, false) + ": " + f
+	}
+	cols := [][]float64{resdf, resdev, df, dev}
+	var colNames []string
+	var title string
+	var err error
+	if models[0].family != nil {
+		title = "Analysis of Deviance Table\n"
+		colNames = []string{"Resid. Df", "Resid. Dev", "Df", "Deviance"}
+		if test != "" {
+			if cols, colNames, err = glmTests(ctx, models[big], cols, colNames, test, 3); err != nil {
+				return nil, err
+			}
+		}
+	} else {
+		title = "Analysis of Variance Table\n"
+		colNames = []string{"Res.Df", "RSS", "Df", "Sum of Sq"}
+		if test != "" {
+			scale := resdev[big] / resdf[big]
+			cols, colNames = statAnova(cols, colNames, df, dev, test, scale, resdf[big])
+		}
+	}
+	return anovaValue([]string{title, strings.Join(notes, "\n")}, rowNames, colNames, cols), nil
+}
+
+// anovaValue returns an anova table: a data frame of class
+// c("anova", "data.frame") with its heading as an attribute.
+func anovaValue(heading, rowNames, colNames []string, cols [][]float64) Value {
+	values := make([]Value, len(cols))
+	for j, col := range cols {
+		data := make([]FloatElem, len(col))
+		for i, v := range col {
+			data[i] = FloatElem{Val: v, NA: math.IsNaN(v)}
+		}
+		values[j] = &DoubleVec{Data: data}
+	}
+	names := make([]StringElem, len(colNames))
+	for j, name := range colNames {
+		names[j].Val = name
+	}
+	out := newDataFrame(values, names, charVecOf(rowNames))
+	out.SetAttr("heading", charVecOf(heading))
+	out.SetAttr("class", charVecOf([]string{"anova", "data.frame"}))
+	return out
+}
+
+var (
+	pvalueColumn = regexp.MustCompile(`^(P|Pr)\(`)
+	dfColumn     = regexp.MustCompile(`Df$`)
+)
+
+// formatAnova renders an anova table like R's print.anova: the heading,
+// then the table with test statistics, p-values and stars, NA cells
+// left blank.
+func formatAnova(v Value) string {
+	var sb strings.Builder
+	if h, ok := v.GetAttr("heading"); ok {
+		for _, line := range toPlainStrings(h) {
+			sb.WriteString(line + "\n")
+		}
+	}
+	df, ok := v.(*ListVec)
+	if !ok || len(df.Data) == 0 {
+		return sb.String()
+	}
+	colNames, _ := listNames(df)
+	cols := make([][]float64, len(df.Data))
+	for j, col := range df.Data {
+		cols[j] = toFloats(col)
+	}
+	nc := len(cols)
+	hasP := pvalueColumn.MatchString(colNames[nc-1])
+	var tst, zap []int
+	for j, name := range colNames {
+		if len(name) >= 7 && name[1:7] == " value" || name == "F" || name == "Cp" || name == "Chisq" {
+			tst = append(tst, j)
+		}
+	}
+	for j := 0; j < nc; j++ {
+		if hasP && j == nc-1 || containsInt(tst, j) || dfColumn.MatchString(colNames[j]) {
+			continue
+		}
+		zap = append(zap, j)
+	}
+	sb.WriteString(formatCoefmat(coefmat{
+		rowNames: dfRowNames(df), colNames: colNames, cols: cols, digits: 5,
+		tst: tst, zap: zap, hasP: hasP, naPrint: "",
+	}))
+	return sb.String()
+}
+
+func containsInt(list []int, n int) bool {
+	for _, e := range list {
+		if e == n {
+			return true
+		}
+	}
+	return false
+}
+
+// builtinAIC implements AIC(object, ...): the Akaike information
+// criterion of lm and glm fits, and for several fits a data frame of
+// their degrees of freedom and AIC.
+func builtinAIC(ctx *Context, args []ArgValue) (Value, error) {
+	if len(args) == 0 {
+		return nil, fmt.Errorf("argument \"object\" is missing, with no default")
+	}
+	var rowNames []string
+	var dfs, aics []float64
+	for _, a := range args {
+		v, err := Force(ctx, a.Val)
+		if err != nil {
+			return nil, err
+		}
+		fl, err := lmArg(ctx, "AIC", v)
+		if err != nil {
+			return nil, err
+		}
+		df, aic := fl.aic()
+		dfs, aics = append(dfs, df), append(aics, aic)
+		rowNames = append(rowNames, deparse.Expr(promiseExpr(a.Val)))
+	}
+	if len(args) == 1 {
+		return DoubleScalar(aics[0]), nil
+	}
+	return newDataFrame([]Value{doubleVecOf(dfs), doubleVecOf(aics)},
+		[]StringElem{{Val: "df"}, {Val: "AIC"}}, charVecOf(rowNames)), nil
+}
+
+// aic returns the number of parameters of a fit and its AIC. For lm the
+// log-likelihood is that of normal errors, with the residual variance
+// as a parameter; a glm records its AIC.
+func (fl *fittedLm) aic() (float64, float64) {
+	df := float64(fl.qr.rank)
+	if fl.family != nil {
+		if !fl.family.fixedDispersion() {
+			df++
+		}
+		return df, toFloats(listElem(fl.obj, "aic"))[0]
+	}
+	var n, sumLogW float64
+	for i := range fl.residuals {
+		if w := fl.weight(i); w > 0 {
+			n++
+			sumLogW += math.Log(w)
+		}
+	}
+	ll := 0.5 * (sumLogW - n*(math.Log(2*math.Pi)+1-math.Log(n)+math.Log(fl.rss())))
+	return df + 1, -2*ll + 2*(df+1)
+}
@@ -9,11 +9,25 @@ import (
 
 func InstallBuiltins(env *Env) {
 	installMathBuiltins(env)
+	installComplexBuiltins(env)
+	installOperatorBuiltins(env)
 	installStringBuiltins(env)
 	installUtilBuiltins(env)
+	installMatrixBuiltins(env)
+	installFactorBuiltins(env)
+	installEnvBuiltins(env)
+	installConditionBuiltins(env)
+	installLangBuiltins(env)
+	installS3Builtins(env)
+	installFormulaBuiltins(env)
+	installModelBuiltins(env)
+	installGlmBuiltins(env)
+	installRandomBuiltins(env)
+	installDistributionBuiltins(env)
+	installStatsBuiltins(env)
 
 	builtins := map[string]*BuiltinFunc{
-		"print":        {FnName: "print", Impl: builtinPrint},
+		"print":        {FnName: "print", Impl: builtinPrint, Generic: true},
 		"cat":          {FnName: "cat", Impl: builtinCat},
 		"c":            {FnName: "c", Impl: builtinC},
 		"list":         {FnName: "list", Impl: builtinList},
@@ -23,8 +37,8 @@ func InstallBuiltins(env *Env) {
 		"dim":          {FnName: "dim", Impl: builtinDim},
 		"head":         {FnName: "head", Impl: builtinHead},
 		"tail":         {FnName: "tail", Impl: builtinTail},
-		"length":       {FnName: "length", Impl: builtinLength},
-		"sum":          {FnName: "sum", Impl: builtinSum},
+		"length":       {FnName: "length", Impl: builtinLength, Generic: true},
+		"sum":          {FnName: "sum", Impl: builtinSum, Group: "Summary"},
 		"mean":         {FnName: "mean", Impl: builtinMean},
 		"sd":           {FnName: "sd", Impl: builtinSD},
 		"seq":          {FnName: "seq", Impl: builtinSeq},
@@ -34,13 +48,19 @@ func InstallBuiltins(env *Env) {
 		"attr":         {FnName: "attr", Impl: builtinAttr},
 		"attributes":   {FnName: "attributes", Impl: builtinAttributes},
 		"names":        {FnName: "names", Impl: builtinNames},
+		"names<-":      {FnName: "names<-", Impl: builtinSetNames},
+		"attr<-":       {FnName: "attr<-", Impl: builtinSetAttr},
 		"is.na":        {FnName: "is.na", Impl: builtinIsNA},
+		"logical":      {FnName: "logical", Impl: vectorConstructor("logical")},
+		"integer":      {FnName: "integer", Impl: vectorConstructor("integer")},
+		"numeric":      {FnName: "numeric", Impl: vectorConstructor("double")},
+		"double":       {FnName: "double", Impl: vectorConstructor("double")},
+		"character":    {FnName: "character", Impl: vectorConstructor("character")},
 		"as.integer":   {FnName: "as.integer", Impl: builtinAsInteger},
 		"as.numeric":   {FnName: "as.numeric", Impl: builtinAsNumeric},
-		"as.character": {FnName: "as.character", Impl: builtinAsCharacter},
+		"as.character": {FnName: "as.character", Impl: builtinAsCharacter, Generic: true},
 		"as.logical":   {FnName: "as.logical", Impl: builtinAsLogical},
-		"stop":         {FnName: "stop", Impl: builtinStop},
-		"warning":      {FnName: "warning", Impl: builtinWarning},
+		"as.list":      {FnName: "as.list", Impl: builtinAsList},
 		"str":          {FnName: "str", Impl: builtinStr},
 	}
 	for name, fn := range builtins {
@@ -69,6 +89,48 @@ func getNamed(args []ArgValue, name string) (Value, bool) {
 	return nil, false
 }
 
+// matchArgs binds args to formals like R does for closures: exact names
+// first, then positional arguments in order. Formals after "..." can only be
+// matched by name. Arguments that match nothing are returned in rest. The
+// values are not forced.
+func matchArgs(args []ArgValue, formals ...string) ([]Value, []ArgValue) {
+	out := make([]Value, len(formals))
+	used := make([]bool, len(args))
+	for i, a := range args {
+		if a.Name == "" {
+			continue
+		}
+		for j, f := range formals {
+			if f == a.Name && f != "..." && out[j] == nil {
+				out[j] = a.Val
+				used[i] = true
+				break
+			}
+		}
+	}
+	var rest []ArgValue
+	j := 0
+	for i, a := range args {
+		if used[i] {
+			continue
+		}
+		if a.Name != "" {
+			rest = append(rest, a)
+			continue
+		}
+		for j < len(formals) && formals[j] != "..." && out[j] != nil {
+			j++
+		}
+		if j < len(formals) && formals[j] != "..." {
+			out[j] = a.Val
+			j++
+			continue
+		}
+		rest = append(rest, a)
+	}
+	return out, rest
+}
+
 func builtinPrint(ctx *Context, args []ArgValue) (Value, error) {
 	fargs, err := forceArgs(ctx, args)
 	if err != nil {
@@ -79,7 +141,7 @@ func builtinPrint(ctx *Context, args []ArgValue) (Value, error) {
 		return NullValue, nil
 	}
 	for _, a := range fargs {
-		ctx.Println(a.Val.String())
+		ctx.Println(formatValueForPrint(a.Val))
 	}
 	return fargs[0].Val, nil
 }
@@ -116,6 +178,9 @@ func builtinCat(ctx *Context, args []ArgValue) (Value, error) {
 }
 
 func toPlainStrings(v Value) []string {
+	if isFactor(v) {
+		return toPlainStrings(&CharVec{Data: factorLabels(v)})
+	}
 	switch t := v.(type) {
 	case *CharVec:
 		out := make([]string, 0, t.Len())
@@ -133,7 +198,17 @@ func toPlainStrings(v Value) []string {
 			if e.NA {
 				out = append(out, "NA")
 			} else {
-				out = append(out, strconv.FormatFloat(e.Val, 'g', -1, 64))
+				out = append(out, formatFloat(e.Val))
+			}
+		}
+		return out
+	case *ComplexVec:
+		out := make([]string, 0, t.Len())
+		for _, e := range t.Data {
+			if e.NA {
+				out = append(out, "NA")
+			} else {
+				out = append(out, formatComplex(e.Val))
 			}
 		}
 		return out
@@ -169,6 +244,16 @@ func builtinC(ctx *Context, args []ArgValue) (Value, error) {
 	if err != nil {
 		return nil, err
 	}
+	// c() of factors combines their levels
+	if len(fargs) > 0 {
+		allFactors := true
+		for _, a := range fargs {
+			allFactors = allFactors && isFactor(a.Val)
+		}
+		if allFactors {
+			return combineFactors(fargs), nil
+		}
+	}
 	// Determine target type
 	target := "logical"
 	hasList := false
@@ -176,15 +261,9 @@ func builtinC(ctx *Context, args []ArgValue) (Value, error) {
 		switch a.Val.Type() {
 		case "list":
 			hasList = true
-		case "character":
-			target = "character"
-		case "double":
-			if target != "character" {
-				target = "double"
-			}
-		case "integer":
-			if target != "character" && target != "double" {
-				target = "integer"
+		case "character", "complex", "double", "integer":
+			if typeRank(a.Val.Type()) > typeRank(target) {
+				target = a.Val.Type()
 			}
 		case "logical":
 			// keep
@@ -192,21 +271,74 @@ func builtinC(ctx *Context, args []ArgValue) (Value, error) {
 			hasList = true
 		}
 	}
+	names := combinedNames(fargs)
 	if hasList {
 		var out []Value
-		var names []StringElem
 		for _, a := range fargs {
 			if lv, ok := a.Val.(*ListVec); ok {
 				out = append(out, lv.Data...)
-				// ignore names for now
-				_ = names
 			} else {
 				out = append(out, a.Val)
 			}
 		}
-		return &ListVec{Data: out}, nil
+		l := &ListVec{Data: out}
+		if names != nil {
+			l.SetAttr("names", names)
+		}
+		return l, nil
+	}
+
+	v, err := combineAtomic(ctx, fargs, target)
+	if err != nil {
+		return nil, err
+	}
+	if names != nil {
+		v.SetAttr("names", names)
+	}
+	return v, nil
+}
+
+// combinedNames builds the names of c(...): argument names, extended by
+// element names or positions for longer arguments. It returns nil when no
+// element is named.
+func combinedNames(args []ArgValue) *CharVec {
+	var names []StringElem
+	any := false
+	for _, a := range args {
+		inner := valueNames(a.Val)
+		n := a.Val.Len()
+		if _, ok := a.Val.(*ListVec); !ok && !isAtomic(a.Val) {
+			n = 1
+			inner = nil
+		}
+		for i := 0; i < n; i++ {
+			name := ""
+			if inner != nil && i < len(inner) {
+				name = inner[i]
+			}
+			if a.Name != "" {
+				switch {
+				case name != "":
+					name = a.Name + "." + name
+				case n == 1:
+					name = a.Name
+				default:
+					name = fmt.Sprintf("%s%d", a.Name, i+1)
+				}
+			}
+			if name != "" {
+				any = true
+			}
+			names = append(names, StringElem{Val: name})
+		}
 	}
+	if !any {
+		return nil
+	}
+	return &CharVec{Data: names}
+}
 
+func combineAtomic(ctx *Context, fargs []ArgValue, target string) (Value, error) {
 	switch target {
 	case "character":
 		var out []StringElem
@@ -228,6 +360,16 @@ func builtinC(ctx *Context, args []ArgValue) (Value, error) {
 			out = append(out, dv...)
 		}
 		return &DoubleVec{Data: out}, nil
+	case "complex":
+		var out []ComplexElem
+		for _, a := range fargs {
+			zv, err := asComplexVec(ctx, a.Val)
+			if err != nil {
+				return nil, err
+			}
+			out = append(out, zv...)
+		}
+		return &ComplexVec{Data: out}, nil
 	case "integer":
 		var out []IntElem
 		for _, a := range fargs {
@@ -305,7 +447,9 @@ func builtinSum(ctx *Context, args []ArgValue) (Value, error) {
 		}
 	}
 	var sum float64
+	var zsum complex128
 	anyNA := false
+	typ := "integer" // integers and logicals sum to an integer
 	for _, a := range args {
 		if a.Name == "na.rm" {
 			continue
@@ -314,6 +458,26 @@ func builtinSum(ctx *Context, args []ArgValue) (Value, error) {
 		if err != nil {
 			return nil, err
 		}
+		switch v.Type() {
+		case "double":
+			if typ == "integer" {
+				typ = "double"
+			}
+		case "complex":
+			typ = "complex"
+			zv, err := asComplexVec(ctx, v)
+			if err != nil {
+				return nil, err
+			}
+			for _, e := range zv {
+				if e.NA {
+					anyNA = anyNA || !naRm
+					continue
+				}
+				zsum += e.Val
+			}
+			continue
+		}
 		dv, err := asDoubleVec(ctx, v)
 		if err != nil {
 			return nil, err
@@ -329,6 +493,24 @@ func builtinSum(ctx *Context, args []ArgValue) (Value, error) {
 			sum += e.Val
 		}
 	}
+	switch typ {
+	case "integer":
+		if anyNA && !naRm {
+			return IntNA(), nil
+		}
+		if math.Abs(sum) > math.MaxInt32 {
+			if err := ctx.warn("integer overflow - use sum(as.numeric(.))"); err != nil {
+				return nil, err
+			}
+			return IntNA(), nil
+		}
+		return IntScalar(int64(sum)), nil
+	case "complex":
+		if anyNA {
+			return &ComplexVec{Data: []ComplexElem{{NA: true}}}, nil
+		}
+		return ComplexScalar(zsum + complex(sum, 0)), nil
+	}
 	if anyNA && !naRm {
 		return DoubleNA(), nil
 	}
@@ -385,70 +567,24 @@ func builtinMean(ctx *Context, args []ArgValue) (Value, error) {
 }
 
 func builtinSD(ctx *Context, args []ArgValue) (Value, error) {
-	naRm := false
-	for _, a := range args {
-		if a.Name == "na.rm" {
-			v, err := Force(ctx, a.Val)
-			if err != nil {
-				return nil, err
-			}
-			b, na, err := asLogicalScalar(ctx, v)
-			if err != nil {
-				return nil, err
-			}
-			if na {
-				naRm = false
-			} else {
-				naRm = b
-			}
-		}
-	}
-	if len(args) == 0 {
-		return nil, fmt.Errorf("sd() expects at least 1 argument")
-	}
-	v, err := Force(ctx, args[0].Val)
+	fargs, err := forceArgs(ctx, args)
 	if err != nil {
 		return nil, err
 	}
-	dv, err := asDoubleVec(ctx, v)
+	m, _ := matchArgs(fargs, "x", "na.rm")
+	naRm, err := logicalArg(ctx, m[1], false)
 	if err != nil {
 		return nil, err
 	}
-
-	// First pass: calculate mean
-	var sum float64
-	var n int
-	for _, e := range dv {
-		if e.NA {
-			if naRm {
-				continue
-			}
-			return DoubleNA(), nil
-		}
-		sum += e.Val
-		n++
-	}
-	if n <= 1 {
-		return DoubleNA(), nil
+	x, err := statData(m[0], "'x' must be numeric")
+	if err != nil {
+		return nil, err
 	}
-	mean := sum / float64(n)
-
-	// Second pass: calculate variance
-	var variance float64
-	for _, e := range dv {
-		if e.NA && naRm {
-			continue
-		}
-		diff := e.Val - mean
-		variance += diff * diff
+	if naRm {
+		x = dropNaN(x)
 	}
-	variance /= float64(n - 1) // sample standard deviation
-
-	return DoubleScalar(mathSqrt(variance)), nil
-}
-
-func mathSqrt(x float64) float64 {
-	return math.Sqrt(x)
+	v, _ := pairStat(x, x, "pearson", false)
+	return statVec([]float64{math.Sqrt(v)}), nil
 }
 
 func builtinSeq(ctx *Context, args []ArgValue) (Value, error) {
@@ -571,6 +707,12 @@ func builtinRep(ctx *Context, args []ArgValue) (Value, error) {
 			out = append(out, xv.Data...)
 		}
 		return &DoubleVec{Data: out}, nil
+	case *ComplexVec:
+		out := make([]ComplexElem, 0, xv.Len()*times)
+		for i := 0; i < times; i++ {
+			out = append(out, xv.Data...)
+		}
+		return &ComplexVec{Data: out}, nil
 	case *IntVec:
 		out := make([]IntElem, 0, xv.Len()*times)
 		for i := 0; i < times; i++ {
@@ -620,10 +762,7 @@ func builtinClass(ctx *Context, args []ArgValue) (Value, error) {
 	if err != nil {
 		return nil, err
 	}
-	if cls, ok := v.GetAttr("class"); ok {
-		return cls, nil
-	}
-	return CharScalar(v.Type()), nil
+	return charVecOf(classOf(v)), nil
 }
 
 func builtinAttr(ctx *Context, args []ArgValue) (Value, error) {
@@ -687,6 +826,64 @@ func builtinNames(ctx *Context, args []ArgValue) (Value, error) {
 	return NullValue, nil
 }
 
+func builtinSetNames(ctx *Context, args []ArgValue) (Value, error) {
+	fargs, err := forceArgs(ctx, args)
+	if err != nil {
+		return nil, err
+	}
+	m, _ := matchArgs(fargs, "x", "value")
+	if m[0] == nil || m[1] == nil {
+		return nil, fmt.Errorf("names<- expects 2 arguments")
+	}
+	out := cloneValue(m[0])
+	if m[1] == NullValue {
+		out.SetAttr("names", nil)
+		return out, nil
+	}
+	cv, err := asCharVec(ctx, m[1])
+	if err != nil {
+		return nil, err
+	}
+	if len(cv) > out.Len() {
+		return nil, fmt.Errorf("'names' attribute [%d] must be the same length as the vector [%d]", len(cv), out.Len())
+	}
+	names := make([]StringElem, out.Len())
+	copy(names, cv)
+	for i := len(cv); i < len(names); i++ {
+		names[i] = StringElem{NA: true}
+	}
+	out.SetAttr("names", &CharVec{Data: names})
+	return out, nil
+}
+
+func builtinSetAttr(ctx *Context, args []ArgValue) (Value, error) {
+	fargs, err := forceArgs(ctx, args)
+	if err != nil {
+		return nil, err
+	}
+	m, _ := matchArgs(fargs, "x", "which", "value")
+	if m[0] == nil || m[1] == nil || m[2] == nil {
+		return nil, fmt.Errorf("attr<- expects 3 arguments")
+	}
+	cv, ok := m[1].(*CharVec)
+	if !ok || cv.Len() != 1 || cv.Data[0].NA {
+		return nil, fmt.Errorf("'name' must be non-null character string")
+	}
+	switch cv.Data[0].Val {
+	case "names":
+		return builtinSetNames(ctx, []ArgValue{{Val: m[0]}, {Val: m[2]}})
+	case "dim":
+		return builtinSetDim(ctx, []ArgValue{{Val: m[0]}, {Val: m[2]}})
+	}
+	out := cloneValue(m[0])
+	if m[2] == NullValue {
+		out.SetAttr(cv.Data[0].Val, nil)
+	} else {
+		out.SetAttr(cv.Data[0].Val, m[2])
+	}
+	return out, nil
+}
+
 func builtinIsNA(ctx *Context, args []ArgValue) (Value, error) {
 	if len(args) != 1 {
 		return nil, fmt.Errorf("is.na(x) expects 1 argument")
@@ -714,6 +911,12 @@ func builtinIsNA(ctx *Context, args []ArgValue) (Value, error) {
 			out[i] = LogicalElem{Val: e.NA}
 		}
 		return &LogicalVec{Data: out}, nil
+	case *ComplexVec:
+		out := make([]LogicalElem, t.Len())
+		for i, e := range t.Data {
+			out[i] = LogicalElem{Val: e.NA}
+		}
+		return &LogicalVec{Data: out}, nil
 	case *CharVec:
 		out := make([]LogicalElem, t.Len())
 		for i, e := range t.Data {
@@ -740,6 +943,33 @@ func builtinAsInteger(ctx *Context, args []ArgValue) (Value, error) {
 	return &IntVec{Data: iv}, nil
 }
 
+// vectorConstructor returns the builtin behind numeric(length = 0) and its
+// siblings: a vector of zeros, empty strings or FALSE values.
+func vectorConstructor(typ string) func(*Context, []ArgValue) (Value, error) {
+	return func(ctx *Context, args []ArgValue) (Value, error) {
+		fargs, err := forceArgs(ctx, args)
+		if err != nil {
+			return nil, err
+		}
+		m, _ := matchArgs(fargs, "length")
+		n := 0
+		if m[0] != nil {
+			if n, err = intArg(ctx, m[0], "length"); err != nil {
+				return nil, err
+			}
+		}
+		switch typ {
+		case "logical":
+			return &LogicalVec{Data: make([]LogicalElem, n)}, nil
+		case "integer":
+			return &IntVec{Data: make([]IntElem, n)}, nil
+		case "character":
+			return &CharVec{Data: make([]StringElem, n)}, nil
+		}
+		return &DoubleVec{Data: make([]FloatElem, n)}, nil
+	}
+}
+
 func builtinAsNumeric(ctx *Context, args []ArgValue) (Value, error) {
 	if len(args) != 1 {
 		return nil, fmt.Errorf("as.numeric(x) expects 1 argument")
@@ -785,29 +1015,55 @@ func builtinAsLogical(ctx *Context, args []ArgValue) (Value, error) {
 	return &LogicalVec{Data: lv}, nil
 }
 
-func builtinStop(ctx *Context, args []ArgValue) (Value, error) {
-	fargs, err := forceArgs(ctx, args)
+// builtinAsList converts vectors, environments and calls to lists. Names
+// are kept; a call becomes the list of its function and arguments.
+func builtinAsList(ctx *Context, args []ArgValue) (Value, error) {
+	if len(args) != 1 {
+		return nil, fmt.Errorf("as.list(x) expects 1 argument")
+	}
+	v, err := Force(ctx, args[0].Val)
 	if err != nil {
 		return nil, err
 	}
-	msg := "stopped"
-	if len(fargs) > 0 {
-		msg = strings.Join(toPlainStrings(fargs[0].Val), " ")
+	switch t := v.(type) {
+	case *ListVec:
+		if !isDataFrame(t) {
+			return t, nil
+		}
+		out := &ListVec{Data: t.Data}
+		if names, ok := t.GetAttr("names"); ok {
+			out.SetAttr("names", names)
+		}
+		return out, nil
+	case *ExprValue:
+		return langToList(t), nil
+	case *EnvValue:
+		names := t.Env.Names()
+		out := &ListVec{Data: make([]Value, len(names))}
+		for i, n := range names {
+			val, _ := t.Env.GetLocal(n)
+			if out.Data[i], err = Force(ctx, val); err != nil {
+				return nil, err
+			}
+		}
+		out.SetAttr("names", charVecOf(names))
+		return out, nil
+	case *Null:
+		return &ListVec{}, nil
 	}
-	return nil, fmt.Errorf("%s", msg)
-}
-
-func builtinWarning(ctx *Context, args []ArgValue) (Value, error) {
-	fargs, err := forceArgs(ctx, args)
-	if err != nil {
-		return nil, err
+	if isFactor(v) {
+		v = &CharVec{Data: factorLabels(v)}
 	}
-	msg := "warning"
-	if len(fargs) > 0 {
-		msg = strings.Join(toPlainStrings(fargs[0].Val), " ")
+	out := &ListVec{Data: make([]Value, v.Len())}
+	for i := range out.Data {
+		if out.Data[i], err = vectorElement(ctx, v, i); err != nil {
+			return nil, err
+		}
 	}
-	ctx.Println("Warning:", msg)
-	return NullValue, nil
+	if names, ok := v.GetAttr("names"); ok {
+		out.SetAttr("names", names)
+	}
+	return out, nil
 }
 
 func builtinStr(ctx *Context, args []ArgValue) (Value, error) {
@@ -859,6 +1115,13 @@ func builtinDataFrame(ctx *Context, args []ArgValue) (Value, error) {
 	// Optional row.names
 	var rowNames Value = nil
 
+	stringsAsFactors := false
+	if v, ok := getNamed(fargs, "stringsAsFactors"); ok {
+		if stringsAsFactors, err = logicalArg(ctx, v, false); err != nil {
+			return nil, err
+		}
+	}
+
 	colAuto := 1
 	for _, a := range fargs {
 		switch a.Name {
@@ -873,6 +1136,11 @@ func builtinDataFrame(ctx *Context, args []ArgValue) (Value, error) {
 			// In R, NULL columns are dropped; mimic that.
 			continue
 		}
+		if _, ok := v.(*CharVec); ok && stringsAsFactors {
+			if v, err = builtinFactor(ctx, []ArgValue{{Val: v}}); err != nil {
+				return nil, err
+			}
+		}
 		cols = append(cols, v)
 		if a.Name != "" {
 			colNames = append(colNames, StringElem{Val: a.Name})
@@ -901,22 +1169,7 @@ func builtinDataFrame(ctx *Context, args []ArgValue) (Value, error) {
 		cols[i] = rc
 	}
 
-	df := &ListVec{Data: cols}
-	df.SetAttr("names", &CharVec{Data: colNames})
-	df.SetAttr("class", &CharVec{Data: []StringElem{{Val: "data.frame"}}})
-
-	// row.names
-	if rowNames != nil {
-		df.SetAttr("row.names", rowNames)
-	} else {
-		// default 1:nrow
-		rn := make([]IntElem, nrow)
-		for i := 0; i < nrow; i++ {
-			rn[i] = IntElem{Val: int64(i + 1)}
-		}
-		df.SetAttr("row.names", &IntVec{Data: rn})
-	}
-	return df, nil
+	return newDataFrame(cols, colNames, rowNames), nil
 }
 
 func recycleTo(ctx *Context, v Value, n int) (Value, error) {
@@ -929,8 +1182,10 @@ func recycleTo(ctx *Context, v Value, n int) (Value, error) {
 		switch t := v.(type) {
 		case *DoubleVec:
 			return &DoubleVec{Data: nil}, nil
+		case *ComplexVec:
+			return &ComplexVec{Data: nil}, nil
 		case *IntVec:
-			return &IntVec{Data: nil}, nil
+			return copyFactorAttrs(&IntVec{Data: nil}, v), nil
 		case *LogicalVec:
 			return &LogicalVec{Data: nil}, nil
 		case *CharVec:
@@ -952,12 +1207,18 @@ func recycleTo(ctx *Context, v Value, n int) (Value, error) {
 			out[i] = t.Data[i%t.Len()]
 		}
 		return &DoubleVec{Data: out}, nil
+	case *ComplexVec:
+		out := make([]ComplexElem, n)
+		for i := 0; i < n; i++ {
+			out[i] = t.Data[i%t.Len()]
+		}
+		return &ComplexVec{Data: out}, nil
 	case *IntVec:
 		out := make([]IntElem, n)
 		for i := 0; i < n; i++ {
 			out[i] = t.Data[i%t.Len()]
 		}
-		return &IntVec{Data: out}, nil
+		return copyFactorAttrs(&IntVec{Data: out}, v), nil
 	case *LogicalVec:
 		out := make([]LogicalElem, n)
 		for i := 0; i < n; i++ {
@@ -990,11 +1251,14 @@ func builtinNRow(ctx *Context, args []ArgValue) (Value, error) {
 		return nil, err
 	}
 	if isDataFrame(x) {
-		if lv, ok := x.(*ListVec); ok && lv.Len() > 0 {
-			return IntScalar(int64(lv.Data[0].Len())), nil
+		if lv, ok := x.(*ListVec); ok {
+			return IntScalar(int64(dfNRow(lv))), nil
 		}
 		return IntScalar(0), nil
 	}
+	if d := getDims(x); len(d) > 0 {
+		return IntScalar(int64(d[0])), nil
+	}
 	return NullValue, nil
 }
 
@@ -1009,6 +1273,9 @@ func builtinNCol(ctx *Context, args []ArgValue) (Value, error) {
 	if isDataFrame(x) {
 		return IntScalar(int64(x.Len())), nil
 	}
+	if d := getDims(x); len(d) > 1 {
+		return IntScalar(int64(d[1])), nil
+	}
 	return NullValue, nil
 }
 
@@ -1022,12 +1289,15 @@ func builtinDim(ctx *Context, args []ArgValue) (Value, error) {
 	}
 	if isDataFrame(x) {
 		nr := int64(0)
-		if lv, ok := x.(*ListVec); ok && lv.Len() > 0 {
-			nr = int64(lv.Data[0].Len())
+		if lv, ok := x.(*ListVec); ok {
+			nr = int64(dfNRow(lv))
 		}
 		nc := int64(x.Len())
 		return &IntVec{Data: []IntElem{{Val: nr}, {Val: nc}}}, nil
 	}
+	if d, ok := x.GetAttr("dim"); ok {
+		return d, nil
+	}
 	return NullValue, nil
 }
 
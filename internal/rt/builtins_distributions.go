@@ -0,0 +1,288 @@
+package rt
+
+import (
+	"fmt"
+	"math"
+)
+
+// The d/p/q functions of the common distributions and the special
+// functions behind them. Every numeric argument is recycled to the
+// longest; the result keeps the names and dimensions of that argument,
+// as in R.
+
+// distSpec describes one distribution or special function builtin.
+type distSpec struct {
+	name     string
+	params   []string                 // numeric formals, the first being x, q or p
+	defaults []float64                // defaults of params[1:]; NaN for none
+	kind     byte                     // 'd' (has log), 'p' or 'q' (have lower.tail and log.p), 0 (no flags)
+	check    func(a []float64) string // warning for an argument the function rounds or ignores
+	fn       func(a []float64, lower, logP bool) float64
+}
+
+// nonIntX warns about the non-integer x of a discrete density, which has
+// probability 0.
+func nonIntX(a []float64) string {
+	if isFinite(a[0]) && nonInt(a[0]) {
+		return fmt.Sprintf("non-integer x = %f", a[0])
+	}
+	return ""
+}
+
+// roundedK warns that choose() rounds k to an integer.
+func roundedK(a []float64) string {
+	if k := math.RoundToEven(a[1]); math.Abs(a[1]-k) > 1e-7 {
+		return fmt.Sprintf("'k' (%.2f) must be integer, rounded to %.0f", a[1], k)
+	}
+	return ""
+}
+
+func installDistributionBuiltins(env *Env) {
+	nan := math.NaN()
+	specs := []*distSpec{
+		{name: "dnorm", params: []string{"x", "mean", "sd"}, defaults: []float64{0, 1}, kind: 'd',
+			fn: func(a []float64, _, logP bool) float64 { return dnormFn(a[0], a[1], a[2], logP) }},
+		{name: "pnorm", params: []string{"q", "mean", "sd"}, defaults: []float64{0, 1}, kind: 'p',
+			fn: func(a []float64, lower, logP bool) float64 { return pnormFn(a[0], a[1], a[2], lower, logP) }},
+		{name: "qnorm", params: []string{"p", "mean", "sd"}, defaults: []float64{0, 1}, kind: 'q',
+			fn: func(a []float64, lower, logP bool) float64 { return qnormFn(a[0], a[1], a[2], lower, logP) }},
+		{name: "dbinom", params: []string{"x", "size", "prob"}, defaults: []float64{nan, nan}, kind: 'd', check: nonIntX,
+			fn: func(a []float64, _, logP bool) float64 { return dbinomFn(a[0], a[1], a[2], logP) }},
+		{name: "pbinom", params: []string{"q", "size", "prob"}, defaults: []float64{nan, nan}, kind: 'p',
+			fn: func(a []float64, lower, logP bool) float64 { return pbinomFn(a[0], a[1], a[2], lower, logP) }},
+		{name: "qbinom", params: []string{"p", "size", "prob"}, defaults: []float64{nan, nan}, kind: 'q',
+			fn: func(a []float64, lower, logP bool) float64 { return qbinomFn(a[0], a[1], a[2], lower, logP) }},
+		{name: "dpois", params: []string{"x", "lambda"}, defaults: []float64{nan}, kind: 'd', check: nonIntX,
+			fn: func(a []float64, _, logP bool) float64 { return dpoisFn(a[0], a[1], logP) }},
+		{name: "ppois", params: []string{"q", "lambda"}, defaults: []float64{nan}, kind: 'p',
+			fn: func(a []float64, lower, logP bool) float64 { return ppoisFn(a[0], a[1], lower, logP) }},
+		{name: "qpois", params: []string{"p", "lambda"}, defaults: []float64{nan}, kind: 'q',
+			fn: func(a []float64, lower, logP bool) float64 { return qpoisFn(a[0], a[1], lower, logP) }},
+		{name: "dt", params: []string{"x", "df"}, defaults: []float64{nan}, kind: 'd',
+			fn: func(a []float64, _, logP bool) float64 { return dtFn(a[0], a[1], logP) }},
+		{name: "pt", params: []string{"q", "df"}, defaults: []float64{nan}, kind: 'p',
+			fn: func(a []float64, lower, logP bool) float64 { return ptFn(a[0], a[1], lower, logP) }},
+		{name: "qt", params: []string{"p", "df"}, defaults: []float64{nan}, kind: 'q',
+			fn: func(a []float64, lower, logP bool) float64 { return qtFn(a[0], a[1], lower, logP) }},
+		{name: "dchisq", params: []string{"x", "df"}, defaults: []float64{nan}, kind: 'd',
+			fn: func(a []float64, _, logP bool) float64 { return dchisqFn(a[0], a[1], logP) }},
+		{name: "pchisq", params: []string{"q", "df"}, defaults: []float64{nan}, kind: 'p',
+			fn: func(a []float64, lower, logP bool) float64 { return pchisqFn(a[0], a[1], lower, logP) }},
+		{name: "qchisq", params: []string{"p", "df"}, defaults: []float64{nan}, kind: 'q',
+			fn: func(a []float64, lower, logP bool) float64 { return qchisqFn(a[0], a[1], lower, logP) }},
+		{name: "df", params: []string{"x", "df1", "df2"}, defaults: []float64{nan, nan}, kind: 'd',
+			fn: func(a []float64, _, logP bool) float64 { return dfFn(a[0], a[1], a[2], logP) }},
+		{name: "pf", params: []string{"q", "df1", "df2"}, defaults: []float64{nan, nan}, kind: 'p',
+			fn: func(a []float64, lower, logP bool) float64 { return pfFn(a[0], a[1], a[2], lower, logP) }},
+		{name: "qf", params: []string{"p", "df1", "df2"}, defaults: []float64{nan, nan}, kind: 'q',
+			fn: func(a []float64, lower, logP bool) float64 { return qfFn(a[0], a[1], a[2], lower, logP) }},
+		{name: "dbeta", params: []string{"x", "shape1", "shape2"}, defaults: []float64{nan, nan}, kind: 'd',
+			fn: func(a []float64, _, logP bool) float64 { return dbetaFn(a[0], a[1], a[2], logP) }},
+		{name: "pbeta", params: []string{"q", "shape1", "shape2"}, defaults: []float64{nan, nan}, kind: 'p',
+			fn: func(a []float64, lower, logP bool) float64 { return pbetaFn(a[0], a[1], a[2], lower, logP) }},
+		{name: "qbeta", params: []string{"p", "shape1", "shape2"}, defaults: []float64{nan, nan}, kind: 'q',
+			fn: func(a []float64, lower, logP bool) float64 { return qbetaFn(a[0], a[1], a[2], lower, logP) }},
+		{name: "dexp", params: []string{"x", "rate"}, defaults: []float64{1}, kind: 'd',
+			fn: func(a []float64, _, logP bool) float64 { return dexpFn(a[0], a[1], logP) }},
+		{name: "pexp", params: []string{"q", "rate"}, defaults: []float64{1}, kind: 'p',
+			fn: func(a []float64, lower, logP bool) float64 { return pexpFn(a[0], a[1], lower, logP) }},
+		{name: "qexp", params: []string{"p", "rate"}, defaults: []float64{1}, kind: 'q',
+			fn: func(a []float64, lower, logP bool) float64 { return qexpFn(a[0], a[1], lower, logP) }},
+		{name: "dunif", params: []string{"x", "min", "max"}, defaults: []float64{0, 1}, kind: 'd',
+			fn: func(a []float64, _, logP bool) float64 { return dunifFn(a[0], a[1], a[2], logP) }},
+		{name: "punif", params: []string{"q", "min", "max"}, defaults: []float64{0, 1}, kind: 'p',
+			fn: func(a []float64, lower, logP bool) float64 { return punifFn(a[0], a[1], a[2], lower, logP) }},
+		{name: "qunif", params: []string{"p", "min", "max"}, defaults: []float64{0, 1}, kind: 'q',
+			fn: func(a []float64, lower, logP bool) float64 { return qunifFn(a[0], a[1], a[2], lower, logP) }},
+
+		{name: "gamma", params: []string{"x"}, fn: func(a []float64, _, _ bool) float64 { return gammaFn(a[0]) }},
+		{name: "lgamma", params: []string{"x"}, fn: func(a []float64, _, _ bool) float64 { return lgammaFn(a[0]) }},
+		{name: "factorial", params: []string{"x"}, fn: func(a []float64, _, _ bool) float64 { return gammaFn(a[0] + 1) }},
+		{name: "lfactorial", params: []string{"x"}, fn: func(a []float64, _, _ bool) float64 { return lgammaFn(a[0] + 1) }},
+		{name: "beta", params: []string{"a", "b"}, defaults: []float64{nan},
+			fn: func(a []float64, _, _ bool) float64 { return betaFn(a[0], a[1]) }},
+		{name: "lbeta", params: []string{"a", "b"}, defaults: []float64{nan},
+			fn: func(a []float64, _, _ bool) float64 { return lbetaFn(a[0], a[1]) }},
+		{name: "choose", params: []string{"n", "k"}, defaults: []float64{nan}, check: roundedK,
+			fn: func(a []float64, _, _ bool) float64 { return chooseFn(a[0], a[1]) }},
+		{name: "lchoose", params: []string{"n", "k"}, defaults: []float64{nan}, check: roundedK,
+			fn: func(a []float64, _, _ bool) float64 { return lchooseFn(a[0], a[1]) }},
+	}
+	for _, spec := range specs {
+		env.SetLocal(spec.name, &BuiltinFunc{FnName: spec.name, Impl: func(ctx *Context, args []ArgValue) (Value, error) {
+			return builtinDist(ctx, spec, args)
+		}})
+	}
+	for _, spec := range gammaSpecs {
+		env.SetLocal(spec.name, &BuiltinFunc{FnName: spec.name, Impl: func(ctx *Context, args []ArgValue) (Value, error) {
+			return builtinGammaDist(ctx, spec, args)
+		}})
+	}
+}
+
+// flagNames lists the logical formals that follow the numeric ones.
+func (spec *distSpec) flagNames() []string {
+	switch spec.kind {
+	case 'd':
+		return []string{"log"}
+	case 'p', 'q':
+		return []string{"lower.tail", "log.p"}
+	}
+	return nil
+}
+
+func builtinDist(ctx *Context, spec *distSpec, args []ArgValue) (Value, error) {
+	fargs, err := forceArgs(ctx, args)
+	if err != nil {
+		return nil, err
+	}
+	m, _ := matchArgs(fargs, append(append([]string{}, spec.params...), spec.flagNames()...)...)
+	k := len(spec.params)
+	for i := 1; i < k; i++ {
+		if m[i] == nil && !math.IsNaN(spec.defaults[i-1]) {
+			m[i] = DoubleScalar(spec.defaults[i-1])
+		}
+	}
+	return distApply(ctx, spec, m[:k], m[k:])
+}
+
+// distApply evaluates spec over the recycled numeric arguments vals;
+// flags holds the log or lower.tail and log.p arguments.
+func distApply(ctx *Context, spec *distSpec, vals, flags []Value) (Value, error) {
+	lower, logP := true, false
+	var err error
+	switch spec.kind {
+	case 'd':
+		if logP, err = logicalArg(ctx, flags[0], false); err != nil {
+			return nil, err
+		}
+	case 'p', 'q':
+		if lower, err = logicalArg(ctx, flags[0], true); err != nil {
+			return nil, err
+		}
+		if logP, err = logicalArg(ctx, flags[1], false); err != nil {
+			return nil, err
+		}
+	}
+
+	cols := make([][]FloatElem, len(vals))
+	n := 0
+	var longest Value
+	for i, v := range vals {
+		if v == nil {
+			return nil, fmt.Errorf("argument \"%s\" is missing, with no default", spec.params[i])
+		}
+		if !isNumericValue(v) && v.Type() != "logical" {
+			return nil, fmt.Errorf("Non-numeric argument to mathematical function")
+		}
+		if cols[i], err = asDoubleVec(ctx, v); err != nil {
+			return nil, err
+		}
+		if len(cols[i]) > n {
+			n, longest = len(cols[i]), v
+		}
+	}
+	for _, c := range cols {
+		if len(c) == 0 {
+			n = 0
+		}
+	}
+
+	out := make([]FloatElem, n)
+	a := make([]float64, len(vals))
+	nanProduced := false
+	for i := range out {
+		na, nanIn := false, false
+		for j, c := range cols {
+			e := c[i%len(c)]
+			na = na || e.NA
+			nanIn = nanIn || math.IsNaN(e.Val)
+			a[j] = e.Val
+		}
+		if na {
+			out[i] = FloatElem{NA: true}
+			continue
+		}
+		if spec.check != nil && !nanIn {
+			if msg := spec.check(a); msg != "" {
+				if err := ctx.warn(msg); err != nil {
+					return nil, err
+				}
+			}
+		}
+		r := spec.fn(a, lower, logP)
+		if math.IsNaN(r) && !nanIn {
+			nanProduced = true
+		}
+		out[i] = FloatElem{Val: r}
+	}
+	if nanProduced {
+		if err := ctx.warn("NaNs produced"); err != nil {
+			return nil, err
+		}
+	}
+	res := &DoubleVec{Data: out}
+	if longest != nil && n > 0 {
+		if nm, ok := longest.GetAttr("names"); ok {
+			res.SetAttr("names", nm)
+		}
+		copyDims(res, longest)
+	}
+	return res, nil
+}
+
+// gammaScale resolves the rate and scale arguments of dgamma and pgamma,
+// which may not both be given unless they agree.
+func gammaScale(ctx *Context, rate, scale Value) (Value, error) {
+	switch {
+	case scale == nil && rate == nil:
+		return DoubleScalar(1), nil
+	case scale == nil:
+		rv, err := asDoubleVec(ctx, rate)
+		if err != nil {
+			return nil, err
+		}
+		out := make([]FloatElem, len(rv))
+		for i, r := range rv {
+			out[i] = FloatElem{Val: 1 / r.Val, NA: r.NA}
+		}
+		return &DoubleVec{Data: out}, nil
+	case rate != nil:
+		r, err1 := asFloatElem(ctx, rate)
+		s, err2 := asFloatElem(ctx, scale)
+		if err1 != nil || err2 != nil || math.Abs(r.Val*s.Val-1) >= 1e-15 {
+			return nil, fmt.Errorf("specify 'rate' or 'scale' but not both")
+		}
+		if err := ctx.warn("specify 'rate' or 'scale' but not both"); err != nil {
+			return nil, err
+		}
+	}
+	return scale, nil
+}
+
+// gammaSpecs are dgamma, pgamma and qgamma, whose scale is given as rate
+// or scale; their params are the formals after resolving the two.
+var gammaSpecs = []*distSpec{
+	{name: "dgamma", params: []string{"x", "shape", "scale"}, kind: 'd',
+		fn: func(a []float64, _, logP bool) float64 { return dgammaFn(a[0], a[1], a[2], logP) }},
+	{name: "pgamma", params: []string{"q", "shape", "scale"}, kind: 'p',
+		fn: func(a []float64, lower, logP bool) float64 { return pgammaFn(a[0], a[1], a[2], lower, logP) }},
+	{name: "qgamma", params: []string{"p", "shape", "scale"}, kind: 'q',
+		fn: func(a []float64, lower, logP bool) float64 { return qgammaFn(a[0], a[1], a[2], lower, logP) }},
+}
+
+// builtinGammaDist implements dgamma(x, shape, rate = 1, scale = 1/rate,
+// log = FALSE) and pgamma and qgamma, which take lower.tail and log.p
+// instead of log.
+func builtinGammaDist(ctx *Context, spec *distSpec, args []ArgValue) (Value, error) {
+	fargs, err := forceArgs(ctx, args)
+	if err != nil {
+		return nil, err
+	}
+	m, _ := matchArgs(fargs, append([]string{spec.params[0], "shape", "rate", "scale"}, spec.flagNames()...)...)
+	scale, err := gammaScale(ctx, m[2], m[3])
+	if err != nil {
+		return nil, err
+	}
+	return distApply(ctx, spec, []Value{m[0], m[1], scale}, m[4:])
+}
@@ -0,0 +1,546 @@
+package rt
+
+import (
+	"fmt"
+	"strings"
+
+	"simonwaldherr.de/go/smallr/internal/ast"
+)
+
+func installEnvBuiltins(env *Env) {
+	builtins := map[string]*BuiltinFunc{
+		"new.env":        {FnName: "new.env", Impl: builtinNewEnv},
+		"globalenv":      {FnName: "globalenv", Impl: builtinGlobalEnv},
+		"emptyenv":       {FnName: "emptyenv", Impl: builtinEmptyEnv},
+		"environment":    {FnName: "environment", Impl: builtinEnvironment},
+		"environment<-":  {FnName: "environment<-", Impl: builtinSetEnvironment},
+		"parent.env":     {FnName: "parent.env", Impl: builtinParentEnv},
+		"is.environment": {FnName: "is.environment", Impl: builtinIsEnvironment},
+		"assign":         {FnName: "assign", Impl: builtinAssign},
+		"get":            {FnName: "get", Impl: builtinGet},
+		"get0":           {FnName: "get0", Impl: builtinGet0},
+		"exists":         {FnName: "exists", Impl: builtinExists},
+		"rm":             {FnName: "rm", Impl: builtinRm},
+		"ls":             {FnName: "ls", Impl: builtinLs},
+		"local":          {FnName: "local", Impl: builtinLocal},
+		"parent.frame":   {FnName: "parent.frame", Impl: builtinParentFrame},
+		"sys.function":   {FnName: "sys.function", Impl: builtinSysFunction},
+		"on.exit":        {FnName: "on.exit", Impl: builtinOnExit},
+		"sys.on.exit":    {FnName: "sys.on.exit", Impl: builtinSysOnExit},
+	}
+	for name, fn := range builtins {
+		env.SetLocal(name, fn)
+	}
+}
+
+// envArg converts an envir= argument. A nil v selects def.
+func envArg(v Value, def *Env, what string) (*Env, error) {
+	if v == nil {
+		return def, nil
+	}
+	ev, ok := v.(*EnvValue)
+	if !ok {
+		return nil, fmt.Errorf("invalid '%s' argument", what)
+	}
+	return ev.Env, nil
+}
+
+// nameArg extracts a single variable name.
+func nameArg(v Value, fn string) (string, error) {
+	cv, ok := v.(*CharVec)
+	if !ok || cv.Len() < 1 || cv.Data[0].NA {
+		return "", fmt.Errorf("%s: first argument must be a character string", fn)
+	}
+	return cv.Data[0].Val, nil
+}
+
+// lookupVar finds name in env, or along its parents when inherits is set.
+func lookupVar(env *Env, name string, inherits bool) (Value, bool) {
+	if inherits {
+		return env.Get(name)
+	}
+	return env.GetLocal(name)
+}
+
+func builtinNewEnv(ctx *Context, args []ArgValue) (Value, error) {
+	caller := ctx.callingEnv()
+	fargs, err := forceArgs(ctx, args)
+	if err != nil {
+		return nil, err
+	}
+	m, _ := matchArgs(fargs, "hash", "parent", "size")
+	parent, err := envArg(m[1], caller, "parent")
+	if err != nil {
+		return nil, err
+	}
+	return &EnvValue{Env: NewEnv(parent)}, nil
+}
+
+func builtinGlobalEnv(ctx *Context, args []ArgValue) (Value, error) {
+	return &EnvValue{Env: ctx.Global}, nil
+}
+
+func builtinEmptyEnv(ctx *Context, args []ArgValue) (Value, error) {
+	return &EnvValue{Env: emptyEnv}, nil
+}
+
+// builtinEnvironment returns the enclosing environment of a closure, or
+// the calling environment when called without arguments.
+func builtinEnvironment(ctx *Context, args []ArgValue) (Value, error) {
+	caller := ctx.callingEnv()
+	fargs, err := forceArgs(ctx, args)
+	if err != nil {
+		return nil, err
+	}
+	m, _ := matchArgs(fargs, "fun")
+	switch fn := m[0].(type) {
+	case nil, *Null:
+		return &EnvValue{Env: caller}, nil
+	case *ClosureFunc:
+		return &EnvValue{Env: fn.Env}, nil
+	default:
+		// formulas keep theirs as an attribute
+		if env, ok := fn.GetAttr(".Environment"); ok {
+			return env, nil
+		}
+		return NullValue, nil
+	}
+}
+
+func builtinSetEnvironment(ctx *Context, args []ArgValue) (Value, error) {
+	fargs, err := forceArgs(ctx, args)
+	if err != nil {
+		return nil, err
+	}
+	m, _ := matchArgs(fargs, "fun", "value")
+	fn, ok := m[0].(*ClosureFunc)
+	if !ok {
+		return nil, fmt.Errorf("replacement object is not an environment")
+	}
+	env, err := envArg(m[1], nil, "value")
+	if err != nil || env == nil {
+		return nil, fmt.Errorf("replacement object is not an environment")
+	}
+	out := cloneValue(fn).(*ClosureFunc)
+	out.Env = env
+	return out, nil
+}
+
+func builtinParentEnv(ctx *Context, args []ArgValue) (Value, error) {
+	fargs, err := forceArgs(ctx, args)
+	if err != nil {
+		return nil, err
+	}
+	m, _ := matchArgs(fargs, "env")
+	env, err := envArg(m[0], nil, "env")
+	if err != nil || env == nil {
+		return nil, fmt.Errorf("argument is not an environment")
+	}
+	if env == emptyEnv {
+		return nil, fmt.Errorf("the empty environment has no parent")
+	}
+	if env.parent == nil {
+		return &EnvValue{Env: emptyEnv}, nil
+	}
+	return &EnvValue{Env: env.parent}, nil
+}
+
+func builtinIsEnvironment(ctx *Context, args []ArgValue) (Value, error) {
+	if len(args) != 1 {
+		return nil, fmt.Errorf("is.environment(x) expects 1 argument")
+	}
+	v, err := Force(ctx, args[0].Val)
+	if err != nil {
+		return nil, err
+	}
+	_, ok := v.(*EnvValue)
+	return LogicalScalar(ok), nil
+}
+
+// builtinAssign implements assign(x, value, envir, inherits). With
+// inherits = TRUE an existing binding in a parent environment is updated.
+func builtinAssign(ctx *Context, args []ArgValue) (Value, error) {
+	caller := ctx.callingEnv()
+	fargs, err := forceArgs(ctx, args)
+	if err != nil {
+		return nil, err
+	}
+	m, _ := matchArgs(fargs, "x", "value", "pos", "envir", "inherits")
+	if m[0] == nil || m[1] == nil {
+		return nil, fmt.Errorf("assign(x, value) expects 2 arguments")
+	}
+	name, err := nameArg(m[0], "assign")
+	if err != nil {
+		return nil, err
+	}
+	env, err := envArg(m[3], caller, "envir")
+	if err != nil {
+		return nil, err
+	}
+	inherits, err := logicalArg(ctx, m[4], false)
+	if err != nil {
+		return nil, err
+	}
+	if inherits {
+		for e := env; e != nil; e = e.parent {
+			if _, ok := e.vars[name]; ok {
+				env = e
+				break
+			}
+		}
+	}
+	if env == emptyEnv {
+		return nil, errEmptyEnv()
+	}
+	env.SetLocal(name, m[1])
+	return m[1], nil
+}
+
+func builtinGet(ctx *Context, args []ArgValue) (Value, error) {
+	caller := ctx.callingEnv()
+	fargs, err := forceArgs(ctx, args)
+	if err != nil {
+		return nil, err
+	}
+	m, _ := matchArgs(fargs, "x", "pos", "envir", "mode", "inherits")
+	if m[0] == nil {
+		return nil, fmt.Errorf("get(x) expects 1 argument")
+	}
+	name, err := nameArg(m[0], "get")
+	if err != nil {
+		return nil, err
+	}
+	env, err := envArg(m[2], caller, "envir")
+	if err != nil {
+		return nil, err
+	}
+	inherits, err := logicalArg(ctx, m[4], true)
+	if err != nil {
+		return nil, err
+	}
+	mode := "any"
+	if cv, ok := m[3].(*CharVec); ok && cv.Len() == 1 && !cv.Data[0].NA {
+		mode = cv.Data[0].Val
+	}
+	for e := env; e != nil; e = e.parent {
+		if v, ok := e.GetLocal(name); ok {
+			if v, err = Force(ctx, v); err != nil {
+				return nil, err
+			}
+			if _, isFn := v.(Callable); mode != "function" || isFn {
+				return v, nil
+			}
+		}
+		if !inherits {
+			break
+		}
+	}
+	if mode == "function" {
+		// builtins shadowed by a global variable are still found
+		if b, ok := ctx.builtins[name].(Callable); ok && inherits {
+			return b, nil
+		}
+		return nil, fmt.Errorf("object '%s' of mode 'function' was not found", name)
+	}
+	return nil, fmt.Errorf("object '%s' not found", name)
+}
+
+func builtinGet0(ctx *Context, args []ArgValue) (Value, error) {
+	caller := ctx.callingEnv()
+	fargs, err := forceArgs(ctx, args)
+	if err != nil {
+		return nil, err
+	}
+	m, _ := matchArgs(fargs, "x", "envir", "mode", "inherits", "ifnotfound")
+	if m[0] == nil {
+		return nil, fmt.Errorf("get0(x) expects 1 argument")
+	}
+	name, err := nameArg(m[0], "get0")
+	if err != nil {
+		return nil, err
+	}
+	env, err := envArg(m[1], caller, "envir")
+	if err != nil {
+		return nil, err
+	}
+	inherits, err := logicalArg(ctx, m[3], true)
+	if err != nil {
+		return nil, err
+	}
+	if v, ok := lookupVar(env, name, inherits); ok {
+		return Force(ctx, v)
+	}
+	if m[4] != nil {
+		return m[4], nil
+	}
+	return NullValue, nil
+}
+
+func builtinExists(ctx *Context, args []ArgValue) (Value, error) {
+	caller := ctx.callingEnv()
+	fargs, err := forceArgs(ctx, args)
+	if err != nil {
+		return nil, err
+	}
+	m, _ := matchArgs(fargs, "x", "where", "envir", "frame", "mode", "inherits")
+	if m[0] == nil {
+		return nil, fmt.Errorf("exists(x) expects at least 1 argument")
+	}
+	name, err := nameArg(m[0], "exists")
+	if err != nil {
+		return nil, err
+	}
+	def := caller
+	if m[1] != nil {
+		def, err = envArg(m[1], caller, "where")
+		if err != nil {
+			return nil, err
+		}
+	}
+	env, err := envArg(m[2], def, "envir")
+	if err != nil {
+		return nil, err
+	}
+	inherits, err := logicalArg(ctx, m[5], true)
+	if err != nil {
+		return nil, err
+	}
+	_, found := lookupVar(env, name, inherits)
+	return LogicalScalar(found), nil
+}
+
+// builtinRm implements rm(..., list = character(), envir). Objects given
+// in ... may be names or strings.
+func builtinRm(ctx *Context, args []ArgValue) (Value, error) {
+	caller := ctx.callingEnv()
+	var names []string
+	var envV Value
+	for _, a := range args {
+		switch a.Name {
+		case "envir":
+			v, err := Force(ctx, a.Val)
+			if err != nil {
+				return nil, err
+			}
+			envV = v
+		case "list":
+			v, err := Force(ctx, a.Val)
+			if err != nil {
+				return nil, err
+			}
+			if v != NullValue {
+				cv, ok := v.(*CharVec)
+				if !ok {
+					return nil, fmt.Errorf("invalid first argument")
+				}
+				names = append(names, toPlainStrings(cv)...)
+			}
+		case "":
+			if p, ok := a.Val.(*Promise); ok {
+				switch e := p.Expr.(type) {
+				case *ast.Ident:
+					names = append(names, e.Name)
+					continue
+				case *ast.StringLit:
+					names = append(names, e.Value)
+					continue
+				}
+			}
+			return nil, fmt.Errorf("... must contain names or character strings")
+		default:
+			return nil, fmt.Errorf("unused argument '%s'", a.Name)
+		}
+	}
+	env, err := envArg(envV, caller, "envir")
+	if err != nil {
+		return nil, err
+	}
+	for _, n := range names {
+		if !env.Remove(n) {
+			if err := ctx.warn(fmt.Sprintf("object '%s' not found", n)); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return NullValue, nil
+}
+
+// builtinLs lists the names bound in an environment. Names starting with
+// a dot are hidden unless all.names = TRUE, and so are the builtins of the
+// global environment.
+func builtinLs(ctx *Context, args []ArgValue) (Value, error) {
+	caller := ctx.callingEnv()
+	fargs, err := forceArgs(ctx, args)
+	if err != nil {
+		return nil, err
+	}
+	m, _ := matchArgs(fargs, "name", "envir", "all.names", "sorted")
+	def := caller
+	if m[0] != nil {
+		def, err = envArg(m[0], caller, "name")
+		if err != nil {
+			return nil, err
+		}
+	}
+	env, err := envArg(m[1], def, "envir")
+	if err != nil {
+		return nil, err
+	}
+	all, err := logicalArg(ctx, m[2], false)
+	if err != nil {
+		return nil, err
+	}
+	var out []string
+	for _, n := range env.Names() {
+		if !all && strings.HasPrefix(n, ".") {
+			continue
+		}
+		if env == ctx.Global {
+			if b, ok := ctx.builtins[n]; ok && b == env.vars[n] {
+				continue
+			}
+		}
+		out = append(out, n)
+	}
+	return charVecOf(out), nil
+}
+
+// builtinLocal evaluates its (unevaluated) expression in a fresh
+// environment whose parent is the calling environment.
+func builtinLocal(ctx *Context, args []ArgValue) (Value, error) {
+	caller := ctx.callingEnv()
+	m, _ := matchArgs(args, "expr", "envir")
+	if m[0] == nil {
+		return NullValue, nil
+	}
+	env := NewEnv(caller)
+	if m[1] != nil {
+		v, err := Force(ctx, m[1])
+		if err != nil {
+			return nil, err
+		}
+		if env, err = envArg(v, nil, "envir"); err != nil {
+			return nil, err
+		}
+	}
+	p, ok := m[0].(*Promise)
+	if !ok {
+		return Force(ctx, m[0])
+	}
+	return Eval(ctx, env, p.Expr)
+}
+
+// builtinParentFrame returns the environment the current function was
+// called from, n generations up.
+func builtinParentFrame(ctx *Context, args []ArgValue) (Value, error) {
+	caller := ctx.callingEnv()
+	fargs, err := forceArgs(ctx, args)
+	if err != nil {
+		return nil, err
+	}
+	m, _ := matchArgs(fargs, "n")
+	n := 1
+	if m[0] != nil {
+		if n, err = intArg(ctx, m[0], "n"); err != nil {
+			return nil, err
+		}
+		if n < 1 {
+			return nil, fmt.Errorf("invalid 'n' value")
+		}
+	}
+	env := caller
+	for ; n > 0; n-- {
+		fr := ctx.frameFor(env)
+		if fr == nil {
+			return &EnvValue{Env: ctx.Global}, nil
+		}
+		env = fr.Caller
+	}
+	return &EnvValue{Env: env}, nil
+}
+
+// builtinSysFunction returns the function of the current call.
+func builtinSysFunction(ctx *Context, args []ArgValue) (Value, error) {
+	caller := ctx.callingEnv()
+	fargs, err := forceArgs(ctx, args)
+	if err != nil {
+		return nil, err
+	}
+	m, _ := matchArgs(fargs, "which")
+	if m[0] != nil {
+		which, err := intArg(ctx, m[0], "which")
+		if err != nil {
+			return nil, err
+		}
+		if which > 0 {
+			if which > len(ctx.frames) {
+				return nil, fmt.Errorf("not that many frames on the stack")
+			}
+			return ctx.frames[which-1].Fn, nil
+		}
+	}
+	fr := ctx.frameFor(caller)
+	if fr == nil {
+		return nil, fmt.Errorf("not that many frames on the stack")
+	}
+	return fr.Fn, nil
+}
+
+// builtinOnExit implements on.exit(expr, add = FALSE, after = TRUE). The
+// unevaluated expression is recorded in the frame of the calling closure
+// and evaluated when that closure exits, however it exits.
+func builtinOnExit(ctx *Context, args []ArgValue) (Value, error) {
+	caller := ctx.callingEnv()
+	m, _ := matchArgs(args, "expr", "add", "after")
+	var opts [2]bool
+	for i, def := range []bool{false, true} {
+		v := m[i+1]
+		if v != nil {
+			var err error
+			if v, err = Force(ctx, v); err != nil {
+				return nil, err
+			}
+		}
+		b, err := logicalArg(ctx, v, def)
+		if err != nil {
+			return nil, err
+		}
+		opts[i] = b
+	}
+	add, after := opts[0], opts[1]
+	fr := ctx.frameFor(caller)
+	if fr == nil {
+		return NullValue, nil
+	}
+	var expr ast.Expr
+	// an already evaluated value has nothing left to do at exit
+	if p, ok := m[0].(*Promise); ok {
+		expr = p.Expr
+	}
+	switch {
+	case !add:
+		fr.OnExit = nil
+		if expr != nil {
+			fr.OnExit = []ast.Expr{expr}
+		}
+	case expr == nil:
+	case after:
+		fr.OnExit = append(fr.OnExit, expr)
+	default:
+		fr.OnExit = append([]ast.Expr{expr}, fr.OnExit...)
+	}
+	return NullValue, nil
+}
+
+// builtinSysOnExit returns the expressions registered with on.exit() in
+// the calling closure, wrapped in braces when there is more than one.
+func builtinSysOnExit(ctx *Context, args []ArgValue) (Value, error) {
+	fr := ctx.frameFor(ctx.callingEnv())
+	if fr == nil || len(fr.OnExit) == 0 {
+		return NullValue, nil
+	}
+	if len(fr.OnExit) == 1 {
+		return &ExprValue{Expr: fr.OnExit[0]}, nil
+	}
+	return &ExprValue{Expr: &ast.BlockExpr{Exprs: append([]ast.Expr(nil), fr.OnExit...)}}, nil
+}
@@ -0,0 +1,823 @@
+package rt
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+)
+
+func installFactorBuiltins(env *Env) {
+	builtins := map[string]*BuiltinFunc{
+		"factor":     {FnName: "factor", Impl: builtinFactor},
+		"as.factor":  {FnName: "as.factor", Impl: builtinAsFactor},
+		"is.factor":  {FnName: "is.factor", Impl: builtinIsFactor},
+		"is.ordered": {FnName: "is.ordered", Impl: builtinIsOrdered},
+		"levels":     {FnName: "levels", Impl: builtinLevels},
+		"levels<-":   {FnName: "levels<-", Impl: builtinSetLevels},
+		"nlevels":    {FnName: "nlevels", Impl: builtinNlevels},
+		"droplevels": {FnName: "droplevels", Impl: builtinDroplevels},
+		"relevel":    {FnName: "relevel", Impl: builtinRelevel},
+		"cut":        {FnName: "cut", Impl: builtinCut},
+		"summary":    {FnName: "summary", Impl: builtinSummary, Generic: true},
+	}
+	for name, fn := range builtins {
+		env.SetLocal(name, fn)
+	}
+}
+
+// --- factor helpers ---
+
+// A factor is an integer vector of 1-based level codes with a "levels"
+// attribute and class "factor" (or c("ordered", "factor")).
+
+func isFactor(v Value) bool {
+	if _, ok := v.(*IntVec); !ok {
+		return false
+	}
+	return hasClass(v, "factor")
+}
+
+func isOrdered(v Value) bool {
+	return isFactor(v) && hasClass(v, "ordered")
+}
+
+func hasClass(v Value, class string) bool {
+	cls, ok := v.GetAttr("class")
+	if !ok {
+		return false
+	}
+	for _, c := range toPlainStrings(cls) {
+		if c == class {
+			return true
+		}
+	}
+	return false
+}
+
+func factorLevels(v Value) []string {
+	lv, ok := v.GetAttr("levels")
+	if !ok {
+		return nil
+	}
+	return toPlainStrings(lv)
+}
+
+func newFactor(codes []IntElem, levels []string, ordered bool) *IntVec {
+	f := &IntVec{Data: codes}
+	f.SetAttr("levels", charVecOf(levels))
+	if ordered {
+		f.SetAttr("class", charVecOf([]string{"ordered", "factor"}))
+	} else {
+		f.SetAttr("class", CharScalar("factor"))
+	}
+	return f
+}
+
+// factorLabels returns the level label of every element of a factor.
+func factorLabels(f Value) []StringElem {
+	levels := factorLevels(f)
+	iv := f.(*IntVec)
+	out := make([]StringElem, len(iv.Data))
+	for i, e := range iv.Data {
+		if e.NA || e.Val < 1 || int(e.Val) > len(levels) {
+			out[i] = StringElem{NA: true}
+		} else {
+			out[i] = StringElem{Val: levels[e.Val-1]}
+		}
+	}
+	return out
+}
+
+// copyFactorAttrs carries levels and class from src to dst when src is a
+// factor, so that subsets of a factor stay factors.
+func copyFactorAttrs(dst, src Value) Value {
+	if !isFactor(src) {
+		return dst
+	}
+	if _, ok := dst.(*IntVec); !ok {
+		return dst
+	}
+	lv, _ := src.GetAttr("levels")
+	cls, _ := src.GetAttr("class")
+	dst.SetAttr("levels", lv)
+	dst.SetAttr("class", cls)
+	return dst
+}
+
+// factorCodes maps labels onto the positions of levels; unknown labels and
+// NA become NA.
+func factorCodes(labels []StringElem, levels []string) []IntElem {
+	pos := make(map[string]int, len(levels))
+	for i, l := range levels {
+		if _, ok := pos[l]; !ok {
+			pos[l] = i + 1
+		}
+	}
+	out := make([]IntElem, len(labels))
+	for i, l := range labels {
+		if p, ok := pos[l.Val]; ok && !l.NA {
+			out[i] = IntElem{Val: int64(p)}
+		} else {
+			out[i] = IntElem{NA: true}
+		}
+	}
+	return out
+}
+
+// sortedLevels returns the sorted unique non-NA values of x as labels.
+// Numbers sort numerically, everything else lexicographically.
+func sortedLevels(ctx *Context, x Value) ([]string, error) {
+	switch x.(type) {
+	case *DoubleVec, *IntVec, *LogicalVec:
+		fv, err := asDoubleVec(ctx, x)
+		if err != nil {
+			return nil, err
+		}
+		labels := toPlainStrings(x)
+		seen := map[float64]bool{}
+		var vals []float64
+		byVal := map[float64]string{}
+		for i, e := range fv {
+			if e.NA || seen[e.Val] {
+				continue
+			}
+			seen[e.Val] = true
+			vals = append(vals, e.Val)
+			byVal[e.Val] = labels[i]
+		}
+		sort.Float64s(vals)
+		out := make([]string, len(vals))
+		for i, v := range vals {
+			out[i] = byVal[v]
+		}
+		return out, nil
+	}
+	cv, err := asCharVec(ctx, x)
+	if err != nil {
+		return nil, err
+	}
+	seen := map[string]bool{}
+	var out []string
+	for _, e := range cv {
+		if e.NA || seen[e.Val] {
+			continue
+		}
+		seen[e.Val] = true
+		out = append(out, e.Val)
+	}
+	sort.Strings(out)
+	return out, nil
+}
+
+// usedLevels returns the levels of f that occur in it, in level order.
+func usedLevels(f Value) []string {
+	levels := factorLevels(f)
+	used := make([]bool, len(levels))
+	for _, e := range f.(*IntVec).Data {
+		if !e.NA && e.Val >= 1 && int(e.Val) <= len(levels) {
+			used[e.Val-1] = true
+		}
+	}
+	var out []string
+	for i, l := range levels {
+		if used[i] {
+			out = append(out, l)
+		}
+	}
+	return out
+}
+
+// relabel replaces the levels of a factor. Duplicated new labels merge the
+// corresponding levels, as in R.
+func relabel(f *IntVec, labels []string) *IntVec {
+	var levels []string
+	index := map[string]int{}
+	remap := make([]int, len(labels))
+	for i, l := range labels {
+		p, ok := index[l]
+		if !ok {
+			levels = append(levels, l)
+			p = len(levels)
+			index[l] = p
+		}
+		remap[i] = p
+	}
+	codes := make([]IntElem, len(f.Data))
+	for i, e := range f.Data {
+		if e.NA || e.Val < 1 || int(e.Val) > len(remap) {
+			codes[i] = IntElem{NA: true}
+		} else {
+			codes[i] = IntElem{Val: int64(remap[e.Val-1])}
+		}
+	}
+	out := newFactor(codes, levels, isOrdered(f))
+	if nm, ok := f.GetAttr("names"); ok {
+		out.SetAttr("names", nm)
+	}
+	return out
+}
+
+func logicalArg(ctx *Context, v Value, def bool) (bool, error) {
+	if v == nil {
+		return def, nil
+	}
+	b, na, err := asLogicalScalar(ctx, v)
+	if err != nil {
+		return false, err
+	}
+	if na {
+		return def, nil
+	}
+	return b, nil
+}
+
+// --- builtins ---
+
+func builtinFactor(ctx *Context, args []ArgValue) (Value, error) {
+	fargs, err := forceArgs(ctx, args)
+	if err != nil {
+		return nil, err
+	}
+	m, _ := matchArgs(fargs, "x", "levels", "labels", "exclude", "ordered")
+	var x Value = &CharVec{}
+	if m[0] != nil && m[0] != NullValue {
+		x = m[0]
+	}
+	ordered, err := logicalArg(ctx, m[4], isOrdered(x))
+	if err != nil {
+		return nil, err
+	}
+
+	var labels []StringElem
+	if isFactor(x) {
+		labels = factorLabels(x)
+	} else if labels, err = asCharVec(ctx, x); err != nil {
+		return nil, err
+	}
+
+	var levels []string
+	switch {
+	case m[1] != nil:
+		levels = toPlainStrings(m[1])
+	case isFactor(x):
+		levels = usedLevels(x)
+	default:
+		if levels, err = sortedLevels(ctx, x); err != nil {
+			return nil, err
+		}
+	}
+	if m[3] != nil && m[3] != NullValue {
+		excl := map[string]bool{}
+		for _, e := range toPlainStrings(m[3]) {
+			excl[e] = true
+		}
+		kept := levels[:0:0]
+		for _, l := range levels {
+			if !excl[l] {
+				kept = append(kept, l)
+			}
+		}
+		levels = kept
+	}
+
+	f := newFactor(factorCodes(labels, levels), levels, ordered)
+	if nm, ok := x.GetAttr("names"); ok {
+		f.SetAttr("names", nm)
+	}
+	if m[2] != nil {
+		names := toPlainStrings(m[2])
+		switch {
+		case len(names) == len(levels):
+		case len(names) == 1:
+			base := names[0]
+			names = make([]string, len(levels))
+			for i := range names {
+				names[i] = fmt.Sprintf("%s%d", base, i+1)
+			}
+		default:
+			return nil, fmt.Errorf("invalid 'labels'; length %d should be 1 or %d", len(names), len(levels))
+		}
+		return relabel(f, names), nil
+	}
+	return f, nil
+}
+
+func builtinAsFactor(ctx *Context, args []ArgValue) (Value, error) {
+	if len(args) != 1 {
+		return nil, fmt.Errorf("as.factor(x) expects 1 argument")
+	}
+	x, err := Force(ctx, args[0].Val)
+	if err != nil {
+		return nil, err
+	}
+	if isFactor(x) {
+		return x, nil
+	}
+	return builtinFactor(ctx, []ArgValue{{Val: x}})
+}
+
+func builtinIsFactor(ctx *Context, args []ArgValue) (Value, error) {
+	return typeCheck(ctx, args, "is.factor", isFactor)
+}
+
+func builtinIsOrdered(ctx *Context, args []ArgValue) (Value, error) {
+	return typeCheck(ctx, args, "is.ordered", isOrdered)
+}
+
+func builtinLevels(ctx *Context, args []ArgValue) (Value, error) {
+	if len(args) != 1 {
+		return nil, fmt.Errorf("levels(x) expects 1 argument")
+	}
+	x, err := Force(ctx, args[0].Val)
+	if err != nil {
+		return nil, err
+	}
+	if lv, ok := x.GetAttr("levels"); ok {
+		return lv, nil
+	}
+	return NullValue, nil
+}
+
+func builtinSetLevels(ctx *Context, args []ArgValue) (Value, error) {
+	fargs, err := forceArgs(ctx, args)
+	if err != nil {
+		return nil, err
+	}
+	m, _ := matchArgs(fargs, "x", "value")
+	if m[0] == nil || m[1] == nil {
+		return nil, fmt.Errorf("levels<- expects 2 arguments")
+	}
+	if !isFactor(m[0]) {
+		out := cloneValue(m[0])
+		out.SetAttr("levels", m[1])
+		return out, nil
+	}
+	labels := toPlainStrings(m[1])
+	if len(labels) < len(factorLevels(m[0])) {
+		return nil, fmt.Errorf("number of levels differs")
+	}
+	return relabel(m[0].(*IntVec), labels), nil
+}
+
+func builtinNlevels(ctx *Context, args []ArgValue) (Value, error) {
+	if len(args) != 1 {
+		return nil, fmt.Errorf("nlevels(x) expects 1 argument")
+	}
+	x, err := Force(ctx, args[0].Val)
+	if err != nil {
+		return nil, err
+	}
+	return IntScalar(int64(len(factorLevels(x)))), nil
+}
+
+func builtinDroplevels(ctx *Context, args []ArgValue) (Value, error) {
+	if len(args) < 1 {
+		return nil, fmt.Errorf("droplevels(x) expects 1 argument")
+	}
+	x, err := Force(ctx, args[0].Val)
+	if err != nil {
+		return nil, err
+	}
+	if df, ok := x.(*ListVec); ok && isDataFrame(x) {
+		out := cloneList(df)
+		for i, col := range out.Data {
+			if isFactor(col) {
+				if out.Data[i], err = dropUnusedLevels(col); err != nil {
+					return nil, err
+				}
+			}
+		}
+		return out, nil
+	}
+	if !isFactor(x) {
+		return nil, fmt.Errorf("droplevels() expects a factor or data frame")
+	}
+	return dropUnusedLevels(x)
+}
+
+func dropUnusedLevels(f Value) (Value, error) {
+	levels := usedLevels(f)
+	out := newFactor(factorCodes(factorLabels(f), levels), levels, isOrdered(f))
+	if nm, ok := f.GetAttr("names"); ok {
+		out.SetAttr("names", nm)
+	}
+	return out, nil
+}
+
+func builtinRelevel(ctx *Context, args []ArgValue) (Value, error) {
+	fargs, err := forceArgs(ctx, args)
+	if err != nil {
+		return nil, err
+	}
+	m, _ := matchArgs(fargs, "x", "ref")
+	if m[0] == nil || m[1] == nil {
+		return nil, fmt.Errorf("relevel(x, ref) expects 2 arguments")
+	}
+	x := m[0]
+	if isOrdered(x) {
+		return nil, fmt.Errorf("'relevel' only for unordered factors")
+	}
+	if !isFactor(x) {
+		return nil, fmt.Errorf("'relevel' only for (unordered) factors")
+	}
+	levels := factorLevels(x)
+	ref := -1
+	if cv, ok := m[1].(*CharVec); ok && cv.Len() == 1 {
+		ref = matchName(levels, cv.Data[0].Val, true)
+		if ref < 0 {
+			return nil, fmt.Errorf("'ref' must be an existing level")
+		}
+	} else {
+		fe, err := asFloatElem(ctx, m[1])
+		if err != nil || fe.NA || int(fe.Val) < 1 || int(fe.Val) > len(levels) {
+			return nil, fmt.Errorf("ref = %s must be in 1L:%d", m[1].String(), len(levels))
+		}
+		ref = int(fe.Val) - 1
+	}
+	newLevels := append([]string{levels[ref]}, append(append([]string(nil), levels[:ref]...), levels[ref+1:]...)...)
+	out := newFactor(factorCodes(factorLabels(x), newLevels), newLevels, false)
+	if nm, ok := x.GetAttr("names"); ok {
+		out.SetAttr("names", nm)
+	}
+	return out, nil
+}
+
+func builtinCut(ctx *Context, args []ArgValue) (Value, error) {
+	fargs, err := forceArgs(ctx, args)
+	if err != nil {
+		return nil, err
+	}
+	m, _ := matchArgs(fargs, "x", "breaks", "labels", "include.lowest", "right", "dig.lab", "ordered_result")
+	if m[0] == nil || m[1] == nil {
+		return nil, fmt.Errorf("cut(x, breaks) expects at least 2 arguments")
+	}
+	x, err := asDoubleVec(ctx, m[0])
+	if err != nil {
+		return nil, fmt.Errorf("'x' must be numeric")
+	}
+	bv, err := asDoubleVec(ctx, m[1])
+	if err != nil {
+		return nil, fmt.Errorf("invalid 'breaks'")
+	}
+	includeLowest, err := logicalArg(ctx, m[3], false)
+	if err != nil {
+		return nil, err
+	}
+	right, err := logicalArg(ctx, m[4], true)
+	if err != nil {
+		return nil, err
+	}
+	digits := 3
+	if m[5] != nil {
+		if digits, err = intArg(ctx, m[5], "dig.lab"); err != nil {
+			return nil, err
+		}
+	}
+	ordered, err := logicalArg(ctx, m[6], false)
+	if err != nil {
+		return nil, err
+	}
+
+	var breaks []float64
+	if len(bv) == 1 {
+		// a number of intervals: equal width over the range of x, widened
+		// slightly so that the extremes fall inside
+		n := int(bv[0].Val)
+		if bv[0].NA || n < 2 {
+			return nil, fmt.Errorf("invalid number of intervals")
+		}
+		lo, hi := math.Inf(1), math.Inf(-1)
+		for _, e := range x {
+			if !e.NA && !math.IsNaN(e.Val) {
+				lo, hi = math.Min(lo, e.Val), math.Max(hi, e.Val)
+			}
+		}
+		if math.IsInf(lo, 0) {
+			return nil, fmt.Errorf("'x' must contain finite values")
+		}
+		dx := hi - lo
+		if dx == 0 {
+			dx = math.Abs(lo)
+			if dx == 0 {
+				dx = 1
+			}
+			lo, hi = lo-dx/1000, hi+dx/1000
+			for i := 0; i <= n; i++ {
+				breaks = append(breaks, lo+float64(i)*(hi-lo)/float64(n))
+			}
+		} else {
+			for i := 0; i <= n; i++ {
+				breaks = append(breaks, lo+float64(i)*dx/float64(n))
+			}
+			breaks[0] -= dx / 1000
+			breaks[n] += dx / 1000
+		}
+	} else {
+		for _, e := range bv {
+			if e.NA {
+				return nil, fmt.Errorf("invalid 'breaks'")
+			}
+			breaks = append(breaks, e.Val)
+		}
+		sort.Float64s(breaks)
+	}
+	nb := len(breaks) - 1
+	for i := 0; i < nb; i++ {
+		if breaks[i] == breaks[i+1] {
+			return nil, fmt.Errorf("'breaks' are not unique")
+		}
+	}
+
+	codes := make([]IntElem, len(x))
+	for k, e := range x {
+		codes[k] = IntElem{NA: true}
+		if e.NA || math.IsNaN(e.Val) {
+			continue
+		}
+		for i := 0; i < nb; i++ {
+			lo, hi := breaks[i], breaks[i+1]
+			var in bool
+			if right {
+				in = (e.Val > lo && e.Val <= hi) || (includeLowest && i == 0 && e.Val == lo)
+			} else {
+				in = (e.Val >= lo && e.Val < hi) || (includeLowest && i == nb-1 && e.Val == hi)
+			}
+			if in {
+				codes[k] = IntElem{Val: int64(i + 1)}
+				break
+			}
+		}
+	}
+
+	if lv, ok := m[2].(*LogicalVec); ok && lv.Len() == 1 && !lv.Data[0].NA && !lv.Data[0].Val {
+		return &IntVec{Data: codes}, nil
+	}
+	var labels []string
+	if m[2] != nil && m[2] != NullValue {
+		labels = toPlainStrings(m[2])
+		if len(labels) != nb {
+			return nil, fmt.Errorf("number of intervals and length of 'labels' differ")
+		}
+	} else {
+		labels = intervalLabels(breaks, digits, right, includeLowest)
+	}
+	return newFactor(codes, labels, ordered), nil
+}
+
+// intervalLabels builds "(a,b]" style labels for cut(), using more digits
+// until all formatted breaks are distinct.
+func intervalLabels(breaks []float64, digits int, right, includeLowest bool) []string {
+	var formatted []string
+	for d := digits; d <= 12; d++ {
+		formatted = formatted[:0]
+		seen := map[string]bool{}
+		unique := true
+		for _, b := range breaks {
+			s := fmt.Sprintf("%.*g", d, b)
+			if seen[s] {
+				unique = false
+			}
+			seen[s] = true
+			formatted = append(formatted, s)
+		}
+		if unique {
+			break
+		}
+	}
+	nb := len(breaks) - 1
+	labels := make([]string, nb)
+	for i := 0; i < nb; i++ {
+		open, close := "(", "]"
+		if !right {
+			open, close = "[", ")"
+		}
+		if includeLowest {
+			if right && i == 0 {
+				open = "["
+			}
+			if !right && i == nb-1 {
+				close = "]"
+			}
+		}
+		labels[i] = open + formatted[i] + "," + formatted[i+1] + close
+	}
+	return labels
+}
+
+// combineFactors implements c() for factors: the result has the union of
+// all levels, in order of first appearance.
+func combineFactors(args []ArgValue) Value {
+	var levels []string
+	seen := map[string]bool{}
+	var labels []StringElem
+	for _, a := range args {
+		for _, l := range factorLevels(a.Val) {
+			if !seen[l] {
+				seen[l] = true
+				levels = append(levels, l)
+			}
+		}
+		labels = append(labels, factorLabels(a.Val)...)
+	}
+	return newFactor(factorCodes(labels, levels), levels, false)
+}
+
+// factorOperands prepares factors for comparison: plain factors compare by
+// label, ordered factors by level position.
+func factorOperands(a, b Value) (Value, Value) {
+	if f, ok := a.(*IntVec); ok && isOrdered(a) {
+		return &IntVec{Data: f.Data}, orderedCodes(b, factorLevels(a))
+	}
+	if f, ok := b.(*IntVec); ok && isOrdered(b) {
+		return orderedCodes(a, factorLevels(b)), &IntVec{Data: f.Data}
+	}
+	if isFactor(a) {
+		a = &CharVec{Data: factorLabels(a)}
+	}
+	if isFactor(b) {
+		b = &CharVec{Data: factorLabels(b)}
+	}
+	return a, b
+}
+
+func orderedCodes(v Value, levels []string) Value {
+	if isFactor(v) {
+		return &IntVec{Data: factorCodes(factorLabels(v), levels)}
+	}
+	if cv, ok := v.(*CharVec); ok {
+		return &IntVec{Data: factorCodes(cv.Data, levels)}
+	}
+	return v
+}
+
+// tableCounts tabulates one classifying vector: factors use their levels,
+// other vectors their sorted unique values. NAs are not counted.
+func tableCounts(ctx *Context, v Value) ([]string, []int, error) {
+	f := v
+	if !isFactor(v) {
+		var err error
+		if f, err = builtinFactor(ctx, []ArgValue{{Val: v}}); err != nil {
+			return nil, nil, err
+		}
+	}
+	codes := make([]int, f.Len())
+	for i, e := range f.(*IntVec).Data {
+		codes[i] = -1
+		if !e.NA {
+			codes[i] = int(e.Val) - 1
+		}
+	}
+	return factorLevels(f), codes, nil
+}
+
+func builtinTable(ctx *Context, args []ArgValue) (Value, error) {
+	// table(...) — counts per level: one factor gives a named integer
+	// vector, two give a contingency matrix with dimnames
+	fargs, err := forceArgs(ctx, args)
+	if err != nil {
+		return nil, err
+	}
+	var vars []Value
+	for _, a := range fargs {
+		if a.Name == "useNA" || a.Name == "dnn" || a.Name == "exclude" {
+			continue
+		}
+		vars = append(vars, a.Val)
+	}
+	switch len(vars) {
+	case 1:
+		levels, codes, err := tableCounts(ctx, vars[0])
+		if err != nil {
+			return nil, err
+		}
+		counts := make([]IntElem, len(levels))
+		for _, c := range codes {
+			if c >= 0 {
+				counts[c].Val++
+			}
+		}
+		result := &IntVec{Data: counts}
+		result.SetAttr("names", charVecOf(levels))
+		return result, nil
+	case 2:
+		rows, rc, err := tableCounts(ctx, vars[0])
+		if err != nil {
+			return nil, err
+		}
+		cols, cc, err := tableCounts(ctx, vars[1])
+		if err != nil {
+			return nil, err
+		}
+		if len(rc) != len(cc) {
+			return nil, fmt.Errorf("all arguments must have the same length")
+		}
+		counts := make([]IntElem, len(rows)*len(cols))
+		for i := range rc {
+			if rc[i] >= 0 && cc[i] >= 0 {
+				counts[rc[i]+cc[i]*len(rows)].Val++
+			}
+		}
+		result := &IntVec{Data: counts}
+		setDims(result, len(rows), len(cols))
+		setDimNames(result, rows, cols)
+		return result, nil
+	case 0:
+		return nil, fmt.Errorf("nothing to tabulate")
+	}
+	return nil, fmt.Errorf("table() supports at most 2 classifying factors")
+}
+
+func builtinSummary(ctx *Context, args []ArgValue) (Value, error) {
+	if len(args) < 1 {
+		return nil, fmt.Errorf("summary(object) expects at least 1 argument")
+	}
+	x, err := Force(ctx, args[0].Val)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case hasClass(x, "glm"):
+		return summaryGlm(ctx, x)
+	case hasClass(x, "lm"):
+		return summaryLm(ctx, x)
+	case isFactor(x):
+		levels, codes, err := tableCounts(ctx, x)
+		if err != nil {
+			return nil, err
+		}
+		counts := make([]IntElem, len(levels))
+		nas := 0
+		for _, c := range codes {
+			if c < 0 {
+				nas++
+			} else {
+				counts[c].Val++
+			}
+		}
+		if nas > 0 {
+			counts = append(counts, IntElem{Val: int64(nas)})
+			levels = append(levels, "NA's")
+		}
+		out := &IntVec{Data: counts}
+		out.SetAttr("names", charVecOf(levels))
+		return out, nil
+	case isNumericValue(x):
+		return summaryDefault(x), nil
+	case x.Type() == "logical":
+		lv := x.(*LogicalVec)
+		var f, t, na int
+		for _, e := range lv.Data {
+			switch {
+			case e.NA:
+				na++
+			case e.Val:
+				t++
+			default:
+				f++
+			}
+		}
+		names := []string{"Mode"}
+		vals := []string{"logical"}
+		for _, c := range []struct {
+			name string
+			n    int
+		}{{"FALSE", f}, {"TRUE", t}, {"NA's", na}} {
+			if c.n > 0 {
+				names = append(names, c.name)
+				vals = append(vals, fmt.Sprint(c.n))
+			}
+		}
+		out := charVecOf(vals)
+		out.SetAttr("names", charVecOf(names))
+		return out, nil
+	case x.Type() == "character":
+		out := charVecOf([]string{fmt.Sprint(x.Len()), "character", "character"})
+		out.SetAttr("names", charVecOf([]string{"Length", "Class", "Mode"}))
+		return out, nil
+	}
+	return nil, fmt.Errorf("summary: unsupported type %s", x.Type())
+}
+
+// formatFactor renders a factor like R's print.factor: the labels followed
+// by a line listing the levels.
+func formatFactor(f Value) string {
+	labels := factorLabels(f)
+	parts := make([]string, len(labels))
+	for i, l := range labels {
+		if l.NA {
+			parts[i] = "<NA>"
+		} else {
+			parts[i] = l.Val
+		}
+	}
+	sep := " "
+	if isOrdered(f) {
+		sep = " < "
+	}
+	body := strings.Join(parts, " ")
+	if len(parts) == 0 {
+		body = "factor(0)"
+	}
+	return body + "\nLevels: " + strings.Join(factorLevels(f), sep)
+}
@@ -3,36 +3,39 @@ package rt
 import (
 	"fmt"
 	"math"
+	"math/cmplx"
 )
 
 func installMathBuiltins(env *Env) {
 	builtins := map[string]*BuiltinFunc{
-		"abs":     {FnName: "abs", Impl: builtinAbs},
-		"sqrt":    {FnName: "sqrt", Impl: builtinSqrt},
-		"floor":   {FnName: "floor", Impl: builtinFloor},
-		"ceiling": {FnName: "ceiling", Impl: builtinCeiling},
-		"round":   {FnName: "round", Impl: builtinRound},
-		"trunc":   {FnName: "trunc", Impl: builtinTrunc},
-		"log":     {FnName: "log", Impl: builtinLog},
-		"log2":    {FnName: "log2", Impl: builtinLog2},
-		"log10":   {FnName: "log10", Impl: builtinLog10},
-		"exp":     {FnName: "exp", Impl: builtinExp},
-		"sin":     {FnName: "sin", Impl: builtinSin},
-		"cos":     {FnName: "cos", Impl: builtinCos},
-		"tan":     {FnName: "tan", Impl: builtinTan},
-		"asin":    {FnName: "asin", Impl: builtinAsin},
-		"acos":    {FnName: "acos", Impl: builtinAcos},
-		"atan":    {FnName: "atan", Impl: builtinAtan},
+		"abs":     {FnName: "abs", Impl: builtinAbs, Group: "Math"},
+		"sqrt":    {FnName: "sqrt", Impl: builtinSqrt, Group: "Math"},
+		"floor":   {FnName: "floor", Impl: builtinFloor, Group: "Math"},
+		"ceiling": {FnName: "ceiling", Impl: builtinCeiling, Group: "Math"},
+		"round":   {FnName: "round", Impl: builtinRound, Group: "Math"},
+		"trunc":   {FnName: "trunc", Impl: builtinTrunc, Group: "Math"},
+		"log":     {FnName: "log", Impl: builtinLog, Group: "Math"},
+		"log2":    {FnName: "log2", Impl: builtinLog2, Group: "Math"},
+		"log10":   {FnName: "log10", Impl: builtinLog10, Group: "Math"},
+		"exp":     {FnName: "exp", Impl: builtinExp, Group: "Math"},
+		"sin":     {FnName: "sin", Impl: builtinSin, Group: "Math"},
+		"cos":     {FnName: "cos", Impl: builtinCos, Group: "Math"},
+		"tan":     {FnName: "tan", Impl: builtinTan, Group: "Math"},
+		"asin":    {FnName: "asin", Impl: builtinAsin, Group: "Math"},
+		"acos":    {FnName: "acos", Impl: builtinAcos, Group: "Math"},
+		"atan":    {FnName: "atan", Impl: builtinAtan, Group: "Math"},
 		"atan2":   {FnName: "atan2", Impl: builtinAtan2},
-		"sign":    {FnName: "sign", Impl: builtinSign},
-		"max":     {FnName: "max", Impl: builtinMax},
-		"min":     {FnName: "min", Impl: builtinMin},
-		"range":   {FnName: "range", Impl: builtinRange},
-		"cumsum":  {FnName: "cumsum", Impl: builtinCumsum},
-		"cumprod": {FnName: "cumprod", Impl: builtinCumprod},
-		"cummax":  {FnName: "cummax", Impl: builtinCummax},
-		"cummin":  {FnName: "cummin", Impl: builtinCummin},
-		"prod":    {FnName: "prod", Impl: builtinProd},
+		"sign":    {FnName: "sign", Impl: builtinSign, Group: "Math"},
+		"max":     {FnName: "max", Impl: builtinMax, Group: "Summary"},
+		"min":     {FnName: "min", Impl: builtinMin, Group: "Summary"},
+		"range":   {FnName: "range", Impl: builtinRange, Group: "Summary"},
+		"cumsum":  {FnName: "cumsum", Impl: builtinCumsum, Group: "Math"},
+		"cumprod": {FnName: "cumprod", Impl: builtinCumprod, Group: "Math"},
+		"cummax":  {FnName: "cummax", Impl: builtinCummax, Group: "Math"},
+		"cummin":  {FnName: "cummin", Impl: builtinCummin, Group: "Math"},
+		"cumall":  {FnName: "cumall", Impl: builtinCumall},
+		"cumany":  {FnName: "cumany", Impl: builtinCumany},
+		"prod":    {FnName: "prod", Impl: builtinProd, Group: "Summary"},
 		"diff":    {FnName: "diff", Impl: builtinDiff},
 	}
 	for name, fn := range builtins {
@@ -43,6 +46,9 @@ func installMathBuiltins(env *Env) {
 	env.SetLocal("pi", DoubleScalar(math.Pi))
 	env.SetLocal("Inf", DoubleScalar(math.Inf(1)))
 	env.SetLocal("NaN", DoubleScalar(math.NaN()))
+	env.SetLocal("NA_integer_", &IntVec{Data: []IntElem{{NA: true}}})
+	env.SetLocal("NA_real_", &DoubleVec{Data: []FloatElem{{NA: true}}})
+	env.SetLocal("NA_character_", &CharVec{Data: []StringElem{{NA: true}}})
 	env.SetLocal("T", LogicalScalar(true))
 	env.SetLocal("F", LogicalScalar(false))
 	env.SetLocal("LETTERS", makeLetters(true))
@@ -71,6 +77,9 @@ func vecMathUnary(ctx *Context, args []ArgValue, name string, fn func(float64) f
 	if err != nil {
 		return nil, err
 	}
+	if z, ok := v.(*ComplexVec); ok {
+		return complexMathUnary(z, name)
+	}
 	dv, err := asDoubleVec(ctx, v)
 	if err != nil {
 		return nil, err
@@ -87,6 +96,26 @@ func vecMathUnary(ctx *Context, args []ArgValue, name string, fn func(float64) f
 }
 
 func builtinAbs(ctx *Context, args []ArgValue) (Value, error) {
+	if len(args) == 1 {
+		v, err := Force(ctx, args[0].Val)
+		if err != nil {
+			return nil, err
+		}
+		switch t := v.(type) {
+		case *IntVec:
+			// abs() keeps integers integer
+			out := make([]IntElem, len(t.Data))
+			for i, e := range t.Data {
+				out[i] = IntElem{Val: e.Val, NA: e.NA}
+				if e.Val < 0 {
+					out[i].Val = -e.Val
+				}
+			}
+			return &IntVec{Data: out}, nil
+		case *ComplexVec:
+			return complexPart("abs", cmplx.Abs)(ctx, args)
+		}
+	}
 	return vecMathUnary(ctx, args, "abs", math.Abs)
 }
 
@@ -142,6 +171,10 @@ func builtinRound(ctx *Context, args []ArgValue) (Value, error) {
 	if err != nil {
 		return nil, err
 	}
+	if _, ok := v.(*IntVec); ok && digits >= 0 && !isFactor(v) {
+		// integers are already round; floor() and ceiling() give doubles
+		return v, nil
+	}
 	dv, err := asDoubleVec(ctx, v)
 	if err != nil {
 		return nil, err
@@ -292,6 +325,7 @@ func builtinMax(ctx *Context, args []ArgValue) (Value, error) {
 	result := math.Inf(-1)
 	anyNA := false
 	any := false
+	integer := true
 	for _, a := range args {
 		if a.Name == "na.rm" {
 			continue
@@ -300,6 +334,7 @@ func builtinMax(ctx *Context, args []ArgValue) (Value, error) {
 		if err != nil {
 			return nil, err
 		}
+		integer = integer && keepsInteger(v)
 		dv, err := asDoubleVec(ctx, v)
 		if err != nil {
 			return nil, err
@@ -319,11 +354,17 @@ func builtinMax(ctx *Context, args []ArgValue) (Value, error) {
 		}
 	}
 	if anyNA && !naRm {
+		if integer {
+			return IntNA(), nil
+		}
 		return DoubleNA(), nil
 	}
 	if !any {
 		return DoubleScalar(math.Inf(-1)), nil
 	}
+	if integer {
+		return IntScalar(int64(result)), nil
+	}
 	return DoubleScalar(result), nil
 }
 
@@ -339,6 +380,7 @@ func builtinMin(ctx *Context, args []ArgValue) (Value, error) {
 	result := math.Inf(1)
 	anyNA := false
 	any := false
+	integer := true
 	for _, a := range args {
 		if a.Name == "na.rm" {
 			continue
@@ -347,6 +389,7 @@ func builtinMin(ctx *Context, args []ArgValue) (Value, error) {
 		if err != nil {
 			return nil, err
 		}
+		integer = integer && keepsInteger(v)
 		dv, err := asDoubleVec(ctx, v)
 		if err != nil {
 			return nil, err
@@ -366,11 +409,17 @@ func builtinMin(ctx *Context, args []ArgValue) (Value, error) {
 		}
 	}
 	if anyNA && !naRm {
+		if integer {
+			return IntNA(), nil
+		}
 		return DoubleNA(), nil
 	}
 	if !any {
 		return DoubleScalar(math.Inf(1)), nil
 	}
+	if integer {
+		return IntScalar(int64(result)), nil
+	}
 	return DoubleScalar(result), nil
 }
 
@@ -386,6 +435,7 @@ func builtinRange(ctx *Context, args []ArgValue) (Value, error) {
 	minVal := math.Inf(1)
 	maxVal := math.Inf(-1)
 	anyNA := false
+	integer := true
 	for _, a := range args {
 		if a.Name == "na.rm" {
 			continue
@@ -394,6 +444,7 @@ func builtinRange(ctx *Context, args []ArgValue) (Value, error) {
 		if err != nil {
 			return nil, err
 		}
+		integer = integer && keepsInteger(v)
 		dv, err := asDoubleVec(ctx, v)
 		if err != nil {
 			return nil, err
@@ -415,8 +466,14 @@ func builtinRange(ctx *Context, args []ArgValue) (Value, error) {
 		}
 	}
 	if anyNA && !naRm {
+		if integer {
+			return &IntVec{Data: []IntElem{{NA: true}, {NA: true}}}, nil
+		}
 		return &DoubleVec{Data: []FloatElem{{NA: true}, {NA: true}}}, nil
 	}
+	if integer && minVal <= maxVal {
+		return &IntVec{Data: []IntElem{{Val: int64(minVal)}, {Val: int64(maxVal)}}}, nil
+	}
 	return &DoubleVec{Data: []FloatElem{{Val: minVal}, {Val: maxVal}}}, nil
 }
 
@@ -432,6 +489,9 @@ func builtinCumsum(ctx *Context, args []ArgValue) (Value, error) {
 	if err != nil {
 		return nil, err
 	}
+	if keepsInteger(v) {
+		return cumInteger(ctx, dv, "cumsum", func(acc, x int64) int64 { return acc + x })
+	}
 	out := make([]FloatElem, len(dv))
 	var sum float64
 	for i, e := range dv {
@@ -486,6 +546,14 @@ func builtinCummax(ctx *Context, args []ArgValue) (Value, error) {
 	if err != nil {
 		return nil, err
 	}
+	if keepsInteger(v) {
+		return cumInteger(ctx, dv, "cummax", func(acc, x int64) int64 {
+			if x > acc {
+				return x
+			}
+			return acc
+		})
+	}
 	out := make([]FloatElem, len(dv))
 	curMax := math.Inf(-1)
 	for i, e := range dv {
@@ -515,6 +583,14 @@ func builtinCummin(ctx *Context, args []ArgValue) (Value, error) {
 	if err != nil {
 		return nil, err
 	}
+	if keepsInteger(v) {
+		return cumInteger(ctx, dv, "cummin", func(acc, x int64) int64 {
+			if x < acc {
+				return x
+			}
+			return acc
+		})
+	}
 	out := make([]FloatElem, len(dv))
 	curMin := math.Inf(1)
 	for i, e := range dv {
@@ -532,6 +608,82 @@ func builtinCummin(ctx *Context, args []ArgValue) (Value, error) {
 	return &DoubleVec{Data: out}, nil
 }
 
+// keepsInteger reports whether v is an integer or logical vector, or NULL,
+// for which max(), cumsum() and diff() give integers as in R.
+func keepsInteger(v Value) bool {
+	switch v.(type) {
+	case *IntVec, *LogicalVec, *Null:
+		return !isFactor(v)
+	}
+	return false
+}
+
+// cumInteger is cumsum(), cummax() and cummin() for integer x: it
+// accumulates with step from the first element on. An NA, or a sum
+// outside the integer range, makes the rest of the result NA.
+func cumInteger(ctx *Context, dv []FloatElem, name string, step func(acc, x int64) int64) (Value, error) {
+	out := make([]IntElem, len(dv))
+	for i, e := range dv {
+		if e.NA {
+			for j := i; j < len(dv); j++ {
+				out[j] = IntElem{NA: true}
+			}
+			break
+		}
+		acc := int64(e.Val)
+		if i > 0 {
+			acc = step(out[i-1].Val, acc)
+		}
+		if acc > math.MaxInt32 || acc < -math.MaxInt32 {
+			msg := fmt.Sprintf("integer overflow in '%s'; use '%s(as.numeric(.))'", name, name)
+			if err := ctx.warn(msg); err != nil {
+				return nil, err
+			}
+			for j := i; j < len(dv); j++ {
+				out[j] = IntElem{NA: true}
+			}
+			break
+		}
+		out[i] = IntElem{Val: acc}
+	}
+	return &IntVec{Data: out}, nil
+}
+
+func builtinCumall(ctx *Context, args []ArgValue) (Value, error) {
+	return cumLogical(ctx, args, "cumall", true)
+}
+
+func builtinCumany(ctx *Context, args []ArgValue) (Value, error) {
+	return cumLogical(ctx, args, "cumany", false)
+}
+
+// cumLogical accumulates x with & (cumall, start TRUE) or | (cumany,
+// start FALSE) in R's three-valued logic: an NA leaves the result open
+// until a FALSE (TRUE) settles it for good.
+func cumLogical(ctx *Context, args []ArgValue, name string, start bool) (Value, error) {
+	if len(args) != 1 {
+		return nil, fmt.Errorf("%s(x) expects 1 argument", name)
+	}
+	lv, err := asLogicalVec(ctx, args[0].Val)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]LogicalElem, len(lv))
+	acc := LogicalElem{Val: start}
+	for i, e := range lv {
+		switch {
+		case acc.Val != start && !acc.NA:
+			// settled
+		case !e.NA && e.Val != start:
+			acc = e
+		case e.NA:
+			acc = LogicalElem{NA: true}
+		}
+		out[i] = acc
+	}
+	return &LogicalVec{Data: out}, nil
+}
+
 func builtinProd(ctx *Context, args []ArgValue) (Value, error) {
 	naRm := false
 	if v, ok := getNamed(args, "na.rm"); ok {
@@ -598,6 +750,9 @@ func builtinDiff(ctx *Context, args []ArgValue) (Value, error) {
 			lag = int(fe.Val)
 		}
 	}
+	if keepsInteger(v) {
+		return diffInteger(ctx, dv, lag)
+	}
 	if lag < 1 || lag >= len(dv) {
 		return &DoubleVec{Data: nil}, nil
 	}
@@ -613,3 +768,31 @@ func builtinDiff(ctx *Context, args []ArgValue) (Value, error) {
 	}
 	return &DoubleVec{Data: out}, nil
 }
+
+// diffInteger is diff() for integer x, which stays integer in R.
+func diffInteger(ctx *Context, dv []FloatElem, lag int) (Value, error) {
+	if lag < 1 || lag >= len(dv) {
+		return &IntVec{}, nil
+	}
+	out := make([]IntElem, len(dv)-lag)
+	overflow := false
+	for i := range out {
+		a, b := dv[i], dv[i+lag]
+		d := b.Val - a.Val
+		switch {
+		case a.NA || b.NA:
+			out[i] = IntElem{NA: true}
+		case math.Abs(d) > math.MaxInt32:
+			out[i] = IntElem{NA: true}
+			overflow = true
+		default:
+			out[i] = IntElem{Val: int64(d)}
+		}
+	}
+	if overflow {
+		if err := ctx.warn("NAs produced by integer overflow"); err != nil {
+			return nil, err
+		}
+	}
+	return &IntVec{Data: out}, nil
+}
@@ -0,0 +1,1689 @@
+package rt
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"simonwaldherr.de/go/smallr/internal/token"
+)
+
+func installMatrixBuiltins(env *Env) {
+	builtins := map[string]*BuiltinFunc{
+		"matrix":     {FnName: "matrix", Impl: builtinMatrix},
+		"array":      {FnName: "array", Impl: builtinArray},
+		"is.matrix":  {FnName: "is.matrix", Impl: builtinIsMatrix},
+		"is.array":   {FnName: "is.array", Impl: builtinIsArray},
+		"as.matrix":  {FnName: "as.matrix", Impl: builtinAsMatrix},
+		"as.vector":  {FnName: "as.vector", Impl: builtinAsVector},
+		"drop":       {FnName: "drop", Impl: builtinDrop},
+		"dim<-":      {FnName: "dim<-", Impl: builtinSetDim},
+		"dimnames":   {FnName: "dimnames", Impl: builtinDimnames},
+		"dimnames<-": {FnName: "dimnames<-", Impl: builtinSetDimnames},
+		"rownames":   {FnName: "rownames", Impl: builtinRownames},
+		"colnames":   {FnName: "colnames", Impl: builtinColnames},
+		"rownames<-": {FnName: "rownames<-", Impl: builtinSetRownames},
+		"colnames<-": {FnName: "colnames<-", Impl: builtinSetColnames},
+		"t":          {FnName: "t", Impl: builtinTranspose},
+		"cbind":      {FnName: "cbind", Impl: builtinCbind},
+		"rbind":      {FnName: "rbind", Impl: builtinRbind},
+		"%*%":        {FnName: "%*%", Impl: builtinMatMul},
+		"crossprod":  {FnName: "crossprod", Impl: builtinCrossprod},
+		"tcrossprod": {FnName: "tcrossprod", Impl: builtinTcrossprod},
+		"outer":      {FnName: "outer", Impl: builtinOuter},
+		"%o%":        {FnName: "%o%", Impl: builtinOuter},
+		"diag":       {FnName: "diag", Impl: builtinDiag},
+		"solve":      {FnName: "solve", Impl: builtinSolve},
+		"det":        {FnName: "det", Impl: builtinDet},
+		"colSums":    {FnName: "colSums", Impl: builtinColSums},
+		"rowSums":    {FnName: "rowSums", Impl: builtinRowSums},
+		"colMeans":   {FnName: "colMeans", Impl: builtinColMeans},
+		"rowMeans":   {FnName: "rowMeans", Impl: builtinRowMeans},
+	}
+	for name, fn := range builtins {
+		env.SetLocal(name, fn)
+	}
+}
+
+// --- dim helpers ---
+
+// getDims returns the dim attribute of v, or nil if v has none.
+func getDims(v Value) []int {
+	d, ok := v.GetAttr("dim")
+	if !ok {
+		return nil
+	}
+	switch t := d.(type) {
+	case *IntVec:
+		out := make([]int, len(t.Data))
+		for i, e := range t.Data {
+			out[i] = int(e.Val)
+		}
+		return out
+	case *DoubleVec:
+		out := make([]int, len(t.Data))
+		for i, e := range t.Data {
+			out[i] = int(e.Val)
+		}
+		return out
+	}
+	return nil
+}
+
+// matrixDims returns the number of rows and columns of a matrix.
+func matrixDims(v Value) (int, int, bool) {
+	d := getDims(v)
+	if len(d) != 2 {
+		return 0, 0, false
+	}
+	return d[0], d[1], true
+}
+
+func setDims(v Value, d ...int) {
+	data := make([]IntElem, len(d))
+	for i, n := range d {
+		data[i] = IntElem{Val: int64(n)}
+	}
+	v.SetAttr("dim", &IntVec{Data: data})
+}
+
+// dimNamesAt returns the names along dimension k, or nil.
+func dimNamesAt(v Value, k int) []string {
+	dn, ok := v.GetAttr("dimnames")
+	if !ok {
+		return nil
+	}
+	l, ok := dn.(*ListVec)
+	if !ok || k >= len(l.Data) || l.Data[k] == nil || l.Data[k] == NullValue {
+		return nil
+	}
+	return toPlainStrings(l.Data[k])
+}
+
+// setDimNames stores per-dimension names; nil entries become NULL and an
+// all-nil slice removes the attribute.
+func setDimNames(v Value, names ...[]string) {
+	data := make([]Value, len(names))
+	any := false
+	for k, n := range names {
+		if n == nil {
+			data[k] = NullValue
+			continue
+		}
+		any = true
+		cv := &CharVec{Data: make([]StringElem, len(n))}
+		for i, s := range n {
+			cv.Data[i] = StringElem{Val: s}
+		}
+		data[k] = cv
+	}
+	if !any {
+		v.SetAttr("dimnames", nil)
+		return
+	}
+	v.SetAttr("dimnames", &ListVec{Data: data})
+}
+
+func intArg(ctx *Context, v Value, what string) (int, error) {
+	fe, err := asFloatElem(ctx, v)
+	if err != nil || fe.NA || fe.Val < 0 {
+		return 0, fmt.Errorf("invalid '%s' value", what)
+	}
+	return int(fe.Val), nil
+}
+
+// numericMatrix returns the data of x as a column-major double matrix. Plain
+// vectors are treated as a single column, data frames are bound column-wise.
+func numericMatrix(ctx *Context, x Value) ([]FloatElem, int, int, error) {
+	if df, ok := x.(*ListVec); ok && isDataFrame(x) {
+		nr := dfNRow(df)
+		out := make([]FloatElem, 0, nr*len(df.Data))
+		for _, col := range df.Data {
+			fv, err := asDoubleVec(ctx, col)
+			if err != nil {
+				return nil, 0, 0, fmt.Errorf("non-numeric data frame column")
+			}
+			out = append(out, fv...)
+		}
+		return out, nr, len(df.Data), nil
+	}
+	fv, err := asDoubleVec(ctx, x)
+	if err != nil {
+		return nil, 0, 0, fmt.Errorf("requires numeric matrix/vector arguments")
+	}
+	if nr, nc, ok := matrixDims(x); ok {
+		return fv, nr, nc, nil
+	}
+	return fv, len(fv), 1, nil
+}
+
+func newDoubleMatrix(data []FloatElem, nr, nc int) *DoubleVec {
+	m := &DoubleVec{Data: data}
+	setDims(m, nr, nc)
+	return m
+}
+
+// --- construction ---
+
+// matrixRecycling returns the warning matrix() gives when n data elements
+// do not fill an nr x nc matrix evenly, or "".
+func matrixRecycling(n, nr, nc int) string {
+	uneven := func(k int) bool { return (n > k && n%k != 0) || (n < k && k%n != 0) }
+	switch {
+	case n <= 1:
+	case nr*nc == 0:
+		return "data length exceeds size of matrix"
+	case (nr*nc)%n == 0:
+	case uneven(nr):
+		return fmt.Sprintf("data length [%d] is not a sub-multiple or multiple of the number of rows [%d]", n, nr)
+	case uneven(nc):
+		return fmt.Sprintf("data length [%d] is not a sub-multiple or multiple of the number of columns [%d]", n, nc)
+	default:
+		return fmt.Sprintf("data length differs from size of matrix: [%d != %d x %d]", n, nr, nc)
+	}
+	return ""
+}
+
+func builtinMatrix(ctx *Context, args []ArgValue) (Value, error) {
+	fargs, err := forceArgs(ctx, args)
+	if err != nil {
+		return nil, err
+	}
+	m, _ := matchArgs(fargs, "data", "nrow", "ncol", "byrow", "dimnames")
+	var data Value = LogicalNA()
+	if m[0] != nil {
+		data = m[0]
+	}
+	if isDataFrame(data) {
+		return builtinAsMatrix(ctx, []ArgValue{{Val: data}})
+	}
+	n := data.Len()
+	nr, nc := -1, -1
+	if m[1] != nil {
+		if nr, err = intArg(ctx, m[1], "nrow"); err != nil {
+			return nil, err
+		}
+	}
+	if m[2] != nil {
+		if nc, err = intArg(ctx, m[2], "ncol"); err != nil {
+			return nil, err
+		}
+	}
+	switch {
+	case nr < 0 && nc < 0:
+		nr, nc = n, 1
+	case nr < 0:
+		nr = 0
+		if nc > 0 {
+			nr = (n + nc - 1) / nc
+		}
+	case nc < 0:
+		nc = 0
+		if nr > 0 {
+			nc = (n + nr - 1) / nr
+		}
+	}
+	byrow := false
+	if m[3] != nil {
+		b, na, err := asLogicalScalar(ctx, m[3])
+		if err != nil {
+			return nil, err
+		}
+		byrow = b && !na
+	}
+	if msg := matrixRecycling(n, nr, nc); msg != "" {
+		if err := ctx.warn(msg); err != nil {
+			return nil, err
+		}
+	}
+
+	var out Value
+	if n == 0 {
+		out = makeNAOfType(data.Type(), nr*nc)
+	} else {
+		pos := make([]int, nr*nc)
+		for k := range pos {
+			i, j := k%nr, k/nr
+			if byrow {
+				pos[k] = (i*nc + j) % n
+			} else {
+				pos[k] = k % n
+			}
+		}
+		out, err = subsetVector(ctx, data, positionsToIndex(pos))
+		if err != nil {
+			return nil, err
+		}
+	}
+	setDims(out, nr, nc)
+	if m[4] != nil && m[4] != NullValue {
+		// dimnames<- returns a modified copy
+		if out, err = builtinSetDimnames(ctx, []ArgValue{{Val: out}, {Name: "value", Val: m[4]}}); err != nil {
+			return nil, err
+		}
+	}
+	return out, nil
+}
+
+func builtinArray(ctx *Context, args []ArgValue) (Value, error) {
+	fargs, err := forceArgs(ctx, args)
+	if err != nil {
+		return nil, err
+	}
+	m, _ := matchArgs(fargs, "data", "dim", "dimnames")
+	var data Value = LogicalNA()
+	if m[0] != nil {
+		data = m[0]
+	}
+	d := []int{data.Len()}
+	if m[1] != nil {
+		iv, err := coerceToIntVec(ctx, m[1])
+		if err != nil {
+			return nil, err
+		}
+		d = d[:0]
+		for _, e := range iv {
+			if e.NA || e.Val < 0 {
+				return nil, fmt.Errorf("negative or NA 'dim' values are not allowed")
+			}
+			d = append(d, int(e.Val))
+		}
+	}
+	total := 1
+	for _, n := range d {
+		total *= n
+	}
+	var out Value
+	if data.Len() == 0 {
+		out = makeNAOfType(data.Type(), total)
+	} else {
+		pos := make([]int, total)
+		for k := range pos {
+			pos[k] = k % data.Len()
+		}
+		out, err = subsetVector(ctx, data, positionsToIndex(pos))
+		if err != nil {
+			return nil, err
+		}
+	}
+	setDims(out, d...)
+	if m[2] != nil && m[2] != NullValue {
+		out.SetAttr("dimnames", m[2])
+	}
+	return out, nil
+}
+
+func builtinIsMatrix(ctx *Context, args []ArgValue) (Value, error) {
+	return typeCheck(ctx, args, "is.matrix", func(v Value) bool {
+		return len(getDims(v)) == 2
+	})
+}
+
+func builtinIsArray(ctx *Context, args []ArgValue) (Value, error) {
+	return typeCheck(ctx, args, "is.array", func(v Value) bool {
+		return len(getDims(v)) > 0
+	})
+}
+
+func builtinAsMatrix(ctx *Context, args []ArgValue) (Value, error) {
+	if len(args) < 1 {
+		return nil, fmt.Errorf("as.matrix(x) expects 1 argument")
+	}
+	x, err := Force(ctx, args[0].Val)
+	if err != nil {
+		return nil, err
+	}
+	if df, ok := x.(*ListVec); ok && isDataFrame(x) {
+		// the columns are combined into the most general column type
+		var cols []ArgValue
+		for _, c := range df.Data {
+			cols = append(cols, ArgValue{Val: c})
+		}
+		out, err := builtinC(ctx, cols)
+		if err != nil {
+			return nil, err
+		}
+		out.SetAttr("names", nil)
+		setDims(out, dfNRow(df), len(df.Data))
+		var rn []string
+		if rv, ok := df.GetAttr("row.names"); ok && rv.Type() == "character" {
+			rn = dfRowNames(df)
+		}
+		cn, _ := listNames(df)
+		setDimNames(out, rn, cn)
+		return out, nil
+	}
+	if len(getDims(x)) == 2 {
+		return x, nil
+	}
+	out := cloneValue(x)
+	names := valueNames(x)
+	out.SetAttr("names", nil)
+	setDims(out, x.Len(), 1)
+	if names != nil {
+		setDimNames(out, names, nil)
+	}
+	return out, nil
+}
+
+func builtinAsVector(ctx *Context, args []ArgValue) (Value, error) {
+	if len(args) < 1 {
+		return nil, fmt.Errorf("as.vector(x) expects 1 argument")
+	}
+	x, err := Force(ctx, args[0].Val)
+	if err != nil {
+		return nil, err
+	}
+	if !isAtomic(x) {
+		return x, nil
+	}
+	out := cloneValue(x)
+	for name := range out.Attrs() {
+		out.SetAttr(name, nil)
+	}
+	return out, nil
+}
+
+func builtinDrop(ctx *Context, args []ArgValue) (Value, error) {
+	if len(args) != 1 {
+		return nil, fmt.Errorf("drop(x) expects 1 argument")
+	}
+	x, err := Force(ctx, args[0].Val)
+	if err != nil {
+		return nil, err
+	}
+	d := getDims(x)
+	if d == nil {
+		return x, nil
+	}
+	subs := make([]Value, len(d))
+	for k := range subs {
+		subs[k] = MissingValue
+	}
+	return arraySubset(ctx, x, d, subs, true)
+}
+
+// --- dim / dimnames accessors ---
+
+func builtinSetDim(ctx *Context, args []ArgValue) (Value, error) {
+	fargs, err := forceArgs(ctx, args)
+	if err != nil {
+		return nil, err
+	}
+	m, _ := matchArgs(fargs, "x", "value")
+	if m[0] == nil || m[1] == nil {
+		return nil, fmt.Errorf("dim<- expects 2 arguments")
+	}
+	out := cloneValue(m[0])
+	out.SetAttr("dimnames", nil)
+	if m[1] == NullValue {
+		out.SetAttr("dim", nil)
+		return out, nil
+	}
+	iv, err := coerceToIntVec(ctx, m[1])
+	if err != nil {
+		return nil, err
+	}
+	d := make([]int, len(iv))
+	total := 1
+	for i, e := range iv {
+		if e.NA || e.Val < 0 {
+			return nil, fmt.Errorf("the dims contain missing or negative values")
+		}
+		d[i] = int(e.Val)
+		total *= d[i]
+	}
+	if total != out.Len() {
+		return nil, fmt.Errorf("dims [product %d] do not match the length of object [%d]", total, out.Len())
+	}
+	out.SetAttr("names", nil)
+	setDims(out, d...)
+	return out, nil
+}
+
+func builtinDimnames(ctx *Context, args []ArgValue) (Value, error) {
+	if len(args) != 1 {
+		return nil, fmt.Errorf("dimnames(x) expects 1 argument")
+	}
+	x, err := Force(ctx, args[0].Val)
+	if err != nil {
+		return nil, err
+	}
+	if df, ok := x.(*ListVec); ok && isDataFrame(x) {
+		names, _ := builtinNames(ctx, []ArgValue{{Val: df}})
+		return List(charVecOf(dfRowNames(df)), names), nil
+	}
+	if dn, ok := x.GetAttr("dimnames"); ok {
+		return dn, nil
+	}
+	return NullValue, nil
+}
+
+func builtinSetDimnames(ctx *Context, args []ArgValue) (Value, error) {
+	fargs, err := forceArgs(ctx, args)
+	if err != nil {
+		return nil, err
+	}
+	m, _ := matchArgs(fargs, "x", "value")
+	if m[0] == nil || m[1] == nil {
+		return nil, fmt.Errorf("dimnames<- expects 2 arguments")
+	}
+	out := cloneValue(m[0])
+	if m[1] == NullValue {
+		out.SetAttr("dimnames", nil)
+		return out, nil
+	}
+	l, ok := m[1].(*ListVec)
+	d := getDims(out)
+	if !ok || len(l.Data) != len(d) {
+		return nil, fmt.Errorf("length of 'dimnames' [%d] must match that of 'dims' [%d]", m[1].Len(), len(d))
+	}
+	data := make([]Value, len(l.Data))
+	for k, v := range l.Data {
+		if v == nil || v == NullValue {
+			data[k] = NullValue
+			continue
+		}
+		if v.Len() != d[k] {
+			return nil, fmt.Errorf("length of 'dimnames' [%d] not equal to array extent", k+1)
+		}
+		cv, err := asCharVec(ctx, v)
+		if err != nil {
+			return nil, err
+		}
+		data[k] = &CharVec{Data: cv}
+	}
+	out.SetAttr("dimnames", &ListVec{Data: data})
+	return out, nil
+}
+
+func charVecOf(s []string) *CharVec {
+	out := &CharVec{Data: make([]StringElem, len(s))}
+	for i, v := range s {
+		out.Data[i] = StringElem{Val: v}
+	}
+	return out
+}
+
+func dimNamesValue(ctx *Context, args []ArgValue, k int, name string) (Value, error) {
+	if len(args) != 1 {
+		return nil, fmt.Errorf("%s(x) expects 1 argument", name)
+	}
+	x, err := Force(ctx, args[0].Val)
+	if err != nil {
+		return nil, err
+	}
+	if df, ok := x.(*ListVec); ok && isDataFrame(x) {
+		if k == 0 {
+			return charVecOf(dfRowNames(df)), nil
+		}
+		return builtinNames(ctx, []ArgValue{{Val: df}})
+	}
+	if names := dimNamesAt(x, k); names != nil {
+		return charVecOf(names), nil
+	}
+	return NullValue, nil
+}
+
+func builtinRownames(ctx *Context, args []ArgValue) (Value, error) {
+	return dimNamesValue(ctx, args, 0, "rownames")
+}
+
+func builtinColnames(ctx *Context, args []ArgValue) (Value, error) {
+	return dimNamesValue(ctx, args, 1, "colnames")
+}
+
+func setDimNamesValue(ctx *Context, args []ArgValue, k int, name string) (Value, error) {
+	fargs, err := forceArgs(ctx, args)
+	if err != nil {
+		return nil, err
+	}
+	m, _ := matchArgs(fargs, "x", "value")
+	if m[0] == nil || m[1] == nil {
+		return nil, fmt.Errorf("%s<- expects 2 arguments", name)
+	}
+	out := cloneValue(m[0])
+	if isDataFrame(out) {
+		if m[1] == NullValue {
+			return nil, fmt.Errorf("invalid %s", name)
+		}
+		cv, err := asCharVec(ctx, m[1])
+		if err != nil {
+			return nil, err
+		}
+		attr := "names"
+		if k == 0 {
+			attr = "row.names"
+		}
+		out.SetAttr(attr, &CharVec{Data: cv})
+		return out, nil
+	}
+	d := getDims(out)
+	if len(d) < 2 {
+		return nil, fmt.Errorf("attempt to set '%s' on an object with less than two dimensions", name)
+	}
+	names := make([][]string, len(d))
+	for i := range names {
+		names[i] = dimNamesAt(out, i)
+	}
+	names[k] = nil
+	if m[1] != NullValue {
+		if m[1].Len() != d[k] {
+			return nil, fmt.Errorf("length of 'dimnames' [%d] not equal to array extent", k+1)
+		}
+		names[k] = toPlainStrings(m[1])
+	}
+	setDimNames(out, names...)
+	return out, nil
+}
+
+func builtinSetRownames(ctx *Context, args []ArgValue) (Value, error) {
+	return setDimNamesValue(ctx, args, 0, "rownames")
+}
+
+func builtinSetColnames(ctx *Context, args []ArgValue) (Value, error) {
+	return setDimNamesValue(ctx, args, 1, "colnames")
+}
+
+// --- subsetting ---
+
+// arrayPositions resolves one subscript per dimension of an array with
+// extents d. It returns the selected 0-based positions in each dimension and
+// the resulting column-major offsets into the data.
+func arrayPositions(ctx *Context, x Value, d []int, subs []Value) ([][]int, []int, error) {
+	sel := make([][]int, len(d))
+	total := 1
+	for k, s := range subs {
+		pos, err := resolveSubscript(ctx, s, d[k], dimNamesAt(x, k))
+		if err != nil {
+			return nil, nil, err
+		}
+		for _, p := range pos {
+			if p < 0 || p >= d[k] {
+				return nil, nil, fmt.Errorf("subscript out of bounds")
+			}
+		}
+		sel[k] = pos
+		total *= len(pos)
+	}
+	offsets := make([]int, 0, total)
+	if total == 0 {
+		return sel, offsets, nil
+	}
+	counter := make([]int, len(d))
+	for {
+		off, stride := 0, 1
+		for k := range d {
+			off += sel[k][counter[k]] * stride
+			stride *= d[k]
+		}
+		offsets = append(offsets, off)
+		k := 0
+		for ; k < len(d); k++ {
+			counter[k]++
+			if counter[k] < len(sel[k]) {
+				break
+			}
+			counter[k] = 0
+		}
+		if k == len(d) {
+			break
+		}
+	}
+	return sel, offsets, nil
+}
+
+// arraySubset implements m[i, j] (and higher dimensional x[i, j, k]).
+// With drop, extents of length one are removed from the result.
+func arraySubset(ctx *Context, x Value, d []int, subs []Value, drop bool) (Value, error) {
+	sel, offsets, err := arrayPositions(ctx, x, d, subs)
+	if err != nil {
+		return nil, err
+	}
+	out, err := subsetVector(ctx, x, positionsToIndex(offsets))
+	if err != nil {
+		return nil, err
+	}
+	var nd []int
+	var names [][]string
+	hasNames := false
+	for k := range d {
+		if drop && len(sel[k]) == 1 {
+			continue
+		}
+		nd = append(nd, len(sel[k]))
+		var kn []string
+		if all := dimNamesAt(x, k); all != nil {
+			kn = make([]string, len(sel[k]))
+			for i, p := range sel[k] {
+				kn[i] = all[p]
+			}
+			hasNames = true
+		}
+		names = append(names, kn)
+	}
+	if len(nd) <= 1 {
+		if len(nd) == 1 && names[0] != nil {
+			out.SetAttr("names", charVecOf(names[0]))
+		}
+		return out, nil
+	}
+	setDims(out, nd...)
+	if hasNames {
+		setDimNames(out, names...)
+	}
+	return out, nil
+}
+
+// arrayElement implements m[[i, j]].
+func arrayElement(ctx *Context, x Value, d []int, subs []Value) (Value, error) {
+	_, offsets, err := arrayPositions(ctx, x, d, subs)
+	if err != nil {
+		return nil, err
+	}
+	if len(offsets) != 1 {
+		return nil, fmt.Errorf("subscript out of bounds")
+	}
+	if l, ok := x.(*ListVec); ok {
+		return l.Data[offsets[0]], nil
+	}
+	return vectorElement(ctx, x, offsets[0])
+}
+
+// arrayAssign implements m[i, j] <- value.
+func arrayAssign(ctx *Context, x Value, d []int, subs []Value, rhs Value, dbl bool) (Value, error) {
+	_, offsets, err := arrayPositions(ctx, x, d, subs)
+	if err != nil {
+		return nil, err
+	}
+	if dbl && len(offsets) != 1 {
+		return nil, fmt.Errorf("[[ ]] improper number of subscripts")
+	}
+	if len(offsets) == 0 {
+		return x, nil
+	}
+	if rhs.Len() == 0 {
+		return nil, fmt.Errorf("replacement has length zero")
+	}
+	if len(offsets)%rhs.Len() != 0 {
+		return nil, fmt.Errorf("number of items to replace is not a multiple of replacement length")
+	}
+	return setSubset(ctx, x, positionsToIndex(offsets), rhs, dbl)
+}
+
+// --- transpose and binding ---
+
+func builtinTranspose(ctx *Context, args []ArgValue) (Value, error) {
+	if len(args) != 1 {
+		return nil, fmt.Errorf("t(x) expects 1 argument")
+	}
+	x, err := Force(ctx, args[0].Val)
+	if err != nil {
+		return nil, err
+	}
+	if isDataFrame(x) {
+		if x, err = builtinAsMatrix(ctx, []ArgValue{{Val: x}}); err != nil {
+			return nil, err
+		}
+	}
+	nr, nc, ok := matrixDims(x)
+	var rn, cn []string
+	if ok {
+		rn, cn = dimNamesAt(x, 0), dimNamesAt(x, 1)
+	} else {
+		if len(getDims(x)) > 2 {
+			return nil, fmt.Errorf("argument is not a matrix")
+		}
+		nr, nc = x.Len(), 1
+		rn = valueNames(x)
+	}
+	pos := make([]int, nr*nc)
+	for k := range pos {
+		// element (j, i) of the result is element (i, j) of x
+		j, i := k%nc, k/nc
+		pos[k] = i + j*nr
+	}
+	out, err := subsetVector(ctx, x, positionsToIndex(pos))
+	if err != nil {
+		return nil, err
+	}
+	setDims(out, nc, nr)
+	setDimNames(out, cn, rn)
+	return out, nil
+}
+
+func builtinCbind(ctx *Context, args []ArgValue) (Value, error) {
+	return bindImpl(ctx, args, false)
+}
+
+func builtinRbind(ctx *Context, args []ArgValue) (Value, error) {
+	return bindImpl(ctx, args, true)
+}
+
+// bindImpl implements cbind (byRow == false) and rbind. Vectors are
+// recycled to the common extent; argument names label the new columns
+// (or rows).
+func bindImpl(ctx *Context, args []ArgValue, byRow bool) (Value, error) {
+	fargs, err := forceArgs(ctx, args)
+	if err != nil {
+		return nil, err
+	}
+	var parts []ArgValue
+	for _, a := range fargs {
+		if a.Name == "deparse.level" || a.Val == NullValue {
+			continue
+		}
+		parts = append(parts, a)
+	}
+	if len(parts) == 0 {
+		return NullValue, nil
+	}
+	for _, a := range parts {
+		if isDataFrame(a.Val) {
+			return bindDataFrames(ctx, parts, byRow)
+		}
+	}
+
+	// extent shared by all parts: rows for cbind, columns for rbind
+	extent := 0
+	fromMatrix := false
+	target := "logical"
+	for _, a := range parts {
+		if typeRank(a.Val.Type()) > typeRank(target) {
+			target = a.Val.Type()
+		}
+		if nr, nc, ok := matrixDims(a.Val); ok {
+			n := nr
+			if byRow {
+				n = nc
+			}
+			if fromMatrix && n != extent {
+				if byRow {
+					return nil, fmt.Errorf("number of columns of matrices must match (see arg %d)", len(parts))
+				}
+				return nil, fmt.Errorf("number of rows of matrices must match (see arg %d)", len(parts))
+			}
+			extent, fromMatrix = n, true
+		} else if !fromMatrix && a.Val.Len() > extent {
+			extent = a.Val.Len()
+		}
+	}
+
+	// collect the result as a list of slices (columns for cbind, rows for rbind)
+	var slices []Value
+	var labels []string
+	var other []string
+	hasLabels := false
+	for _, a := range parts {
+		v, err := coerceTo(ctx, a.Val, target)
+		if err != nil {
+			return nil, err
+		}
+		if nr, nc, ok := matrixDims(a.Val); ok {
+			count := nc
+			if byRow {
+				count = nr
+			}
+			k := 1
+			if byRow {
+				k = 0
+			}
+			names := dimNamesAt(a.Val, k)
+			if other == nil {
+				other = dimNamesAt(a.Val, 1-k)
+			}
+			for s := 0; s < count; s++ {
+				subs := []Value{MissingValue, IntScalar(int64(s + 1))}
+				if byRow {
+					subs = []Value{IntScalar(int64(s + 1)), MissingValue}
+				}
+				sl, err := arraySubset(ctx, v, getDims(a.Val), subs, true)
+				if err != nil {
+					return nil, err
+				}
+				sl.SetAttr("names", nil)
+				slices = append(slices, sl)
+				label := ""
+				if names != nil {
+					label = names[s]
+					hasLabels = true
+				}
+				labels = append(labels, label)
+			}
+			continue
+		}
+		if v.Len() == 0 {
+			continue
+		}
+		if other == nil && v.Len() == extent {
+			other = valueNames(a.Val)
+		}
+		sl, err := recycleTo(ctx, v, extent)
+		if err != nil {
+			return nil, err
+		}
+		slices = append(slices, sl)
+		labels = append(labels, a.Name)
+		if a.Name != "" {
+			hasLabels = true
+		}
+	}
+
+	count := len(slices)
+	nr, nc := extent, count
+	if byRow {
+		nr, nc = count, extent
+	}
+	out := makeNAOfType(target, nr*nc)
+	pos := make([]int, 0, nr*nc)
+	var combined []ArgValue
+	for _, sl := range slices {
+		combined = append(combined, ArgValue{Val: sl})
+	}
+	flat, err := builtinC(ctx, combined)
+	if err != nil {
+		return nil, err
+	}
+	if count > 0 && extent > 0 {
+		for k := 0; k < nr*nc; k++ {
+			i, j := k%nr, k/nr
+			if byRow {
+				// slice i holds row i
+				pos = append(pos, i*extent+j)
+			} else {
+				pos = append(pos, j*extent+i)
+			}
+		}
+		if out, err = subsetVector(ctx, flat, positionsToIndex(pos)); err != nil {
+			return nil, err
+		}
+	}
+	setDims(out, nr, nc)
+	if !hasLabels {
+		labels = nil
+	}
+	if byRow {
+		setDimNames(out, labels, other)
+	} else {
+		setDimNames(out, other, labels)
+	}
+	return out, nil
+}
+
+// bindDataFrames implements cbind/rbind when a data frame is involved.
+func bindDataFrames(ctx *Context, parts []ArgValue, byRow bool) (Value, error) {
+	if !byRow {
+		var cols []Value
+		var names []StringElem
+		var rowNames Value
+		for _, a := range parts {
+			if df, ok := a.Val.(*ListVec); ok && isDataFrame(df) {
+				dn, _ := listNames(df)
+				for i, c := range df.Data {
+					cols = append(cols, c)
+					names = append(names, StringElem{Val: dn[i]})
+				}
+				if rowNames == nil {
+					rowNames, _ = df.GetAttr("row.names")
+				}
+				continue
+			}
+			cols = append(cols, a.Val)
+			name := a.Name
+			if name == "" {
+				name = fmt.Sprintf("V%d", len(cols))
+			}
+			names = append(names, StringElem{Val: name})
+		}
+		n := 0
+		for _, c := range cols {
+			if c.Len() > n {
+				n = c.Len()
+			}
+		}
+		for i, c := range cols {
+			rc, err := recycleTo(ctx, c, n)
+			if err != nil {
+				return nil, err
+			}
+			cols[i] = rc
+		}
+		return newDataFrame(cols, names, rowNames), nil
+	}
+
+	first, ok := parts[0].Val.(*ListVec)
+	if !ok || !isDataFrame(first) {
+		return nil, fmt.Errorf("rbind: first argument must be a data frame")
+	}
+	names, _ := listNames(first)
+	cols := make([]Value, len(first.Data))
+	for j, name := range names {
+		var pieces []ArgValue
+		for _, a := range parts {
+			var piece Value
+			if l, ok := a.Val.(*ListVec); ok {
+				ln, _ := listNames(l)
+				i := matchName(ln, name, true)
+				if i < 0 {
+					return nil, fmt.Errorf("names do not match previous names")
+				}
+				piece = l.Data[i]
+			} else {
+				if a.Val.Len() != len(names) {
+					return nil, fmt.Errorf("invalid list argument: all variables should have the same length")
+				}
+				v, err := vectorElement(ctx, a.Val, j)
+				if err != nil {
+					return nil, err
+				}
+				piece = v
+			}
+			pieces = append(pieces, ArgValue{Val: piece})
+		}
+		col, err := builtinC(ctx, pieces)
+		if err != nil {
+			return nil, err
+		}
+		col.SetAttr("names", nil)
+		cols[j] = col
+	}
+	return newDataFrame(cols, charVecOf(names).Data, nil), nil
+}
+
+// --- linear algebra ---
+
+func builtinMatMul(ctx *Context, args []ArgValue) (Value, error) {
+	fargs, err := forceArgs(ctx, args)
+	if err != nil {
+		return nil, err
+	}
+	if len(fargs) != 2 {
+		return nil, fmt.Errorf("%%*%% expects 2 arguments")
+	}
+	return matMul(ctx, fargs[0].Val, fargs[1].Val)
+}
+
+// matMul implements x %*% y. A vector on the left is taken as a row vector
+// when that makes the product conformable, as in R.
+func matMul(ctx *Context, x, y Value) (Value, error) {
+	a, ar, ac, err := numericMatrix(ctx, x)
+	if err != nil {
+		return nil, err
+	}
+	b, br, bc, err := numericMatrix(ctx, y)
+	if err != nil {
+		return nil, err
+	}
+	_, _, xMat := matrixDims(x)
+	_, _, yMat := matrixDims(y)
+	switch {
+	case !xMat && !yMat:
+		if len(a) == len(b) {
+			// inner product
+			ar, ac = 1, len(a)
+		} else {
+			// outer product of a column and a row vector
+			br, bc = 1, len(b)
+		}
+	case !xMat:
+		if len(a) == br {
+			ar, ac = 1, len(a)
+		}
+	case !yMat:
+		if ac != len(b) && ac == 1 {
+			br, bc = 1, len(b)
+		}
+	}
+	if ac != br {
+		return nil, fmt.Errorf("non-conformable arguments")
+	}
+	out := make([]FloatElem, ar*bc)
+	for i := 0; i < ar; i++ {
+		for j := 0; j < bc; j++ {
+			sum := 0.0
+			na := false
+			for k := 0; k < ac; k++ {
+				ae, be := a[i+k*ar], b[k+j*br]
+				if ae.NA || be.NA {
+					na = true
+					break
+				}
+				sum += ae.Val * be.Val
+			}
+			if na {
+				out[i+j*ar] = FloatElem{NA: true}
+			} else {
+				out[i+j*ar] = FloatElem{Val: sum}
+			}
+		}
+	}
+	res := newDoubleMatrix(out, ar, bc)
+	var rn, cn []string
+	if xMat {
+		rn = dimNamesAt(x, 0)
+	}
+	if yMat {
+		cn = dimNamesAt(y, 1)
+	}
+	setDimNames(res, rn, cn)
+	return res, nil
+}
+
+func builtinCrossprod(ctx *Context, args []ArgValue) (Value, error) {
+	return crossprodImpl(ctx, args, "crossprod", false)
+}
+
+func builtinTcrossprod(ctx *Context, args []ArgValue) (Value, error) {
+	return crossprodImpl(ctx, args, "tcrossprod", true)
+}
+
+// crossprodImpl computes t(x) %*% y, or x %*% t(y) for tcrossprod; y
+// defaults to x.
+func crossprodImpl(ctx *Context, args []ArgValue, name string, tr bool) (Value, error) {
+	fargs, err := forceArgs(ctx, args)
+	if err != nil {
+		return nil, err
+	}
+	m, _ := matchArgs(fargs, "x", "y")
+	if m[0] == nil {
+		return nil, fmt.Errorf("%s(x, y) expects at least 1 argument", name)
+	}
+	x, y := m[0], m[1]
+	if y == nil || y == NullValue {
+		y = x
+	}
+	if !tr {
+		xt, err := builtinTranspose(ctx, []ArgValue{{Val: asMatrixValue(ctx, x)}})
+		if err != nil {
+			return nil, err
+		}
+		return matMul(ctx, xt, asMatrixValue(ctx, y))
+	}
+	yt, err := builtinTranspose(ctx, []ArgValue{{Val: asMatrixValue(ctx, y)}})
+	if err != nil {
+		return nil, err
+	}
+	return matMul(ctx, asMatrixValue(ctx, x), yt)
+}
+
+// asMatrixValue turns vectors and data frames into matrices and leaves
+// everything else unchanged.
+func asMatrixValue(ctx *Context, v Value) Value {
+	if len(getDims(v)) == 2 {
+		return v
+	}
+	m, err := builtinAsMatrix(ctx, []ArgValue{{Val: v}})
+	if err != nil {
+		return v
+	}
+	return m
+}
+
+func builtinOuter(ctx *Context, args []ArgValue) (Value, error) {
+	fargs, err := forceArgs(ctx, args)
+	if err != nil {
+		return nil, err
+	}
+	m, rest := matchArgs(fargs, "X", "Y", "FUN")
+	if m[0] == nil || m[1] == nil {
+		return nil, fmt.Errorf("outer(X, Y, FUN) expects at least 2 arguments")
+	}
+	var fun Value = CharScalar("*")
+	if m[2] != nil {
+		fun = m[2]
+	}
+	if cv, ok := fun.(*CharVec); ok && cv.Len() == 1 && len(rest) == 0 {
+		if res, err := outerProduct(ctx, m[0], m[1], cv.Data[0].Val); err == nil || !strings.Contains(err.Error(), "unsupported") {
+			return res, err
+		}
+		fv, ok := ctx.Global.Get(cv.Data[0].Val)
+		if !ok {
+			return nil, fmt.Errorf("could not find function \"%s\"", cv.Data[0].Val)
+		}
+		fun = fv
+	}
+	fn, ok := fun.(Callable)
+	if !ok {
+		return nil, fmt.Errorf("outer: FUN is not a function")
+	}
+	xs, ys, err := outerOperands(ctx, m[0], m[1])
+	if err != nil {
+		return nil, err
+	}
+	callArgs := append([]ArgValue{{Val: xs}, {Val: ys}}, rest...)
+	res, err := fn.Call(ctx, nil, callArgs)
+	if err != nil {
+		return nil, err
+	}
+	if res.Len() != xs.Len() {
+		return nil, fmt.Errorf("dims [product %d] do not match the length of object [%d]", xs.Len(), res.Len())
+	}
+	return outerResult(res, m[0], m[1]), nil
+}
+
+// outerOperands expands X and Y so that FUN can be applied once to all
+// combinations: X varies fastest, Y is repeated per element.
+func outerOperands(ctx *Context, x, y Value) (Value, Value, error) {
+	nx, ny := x.Len(), y.Len()
+	xp := make([]int, nx*ny)
+	yp := make([]int, nx*ny)
+	for k := range xp {
+		xp[k], yp[k] = k%nx, k/nx
+	}
+	xs, err := subsetVector(ctx, x, positionsToIndex(xp))
+	if err != nil {
+		return nil, nil, err
+	}
+	ys, err := subsetVector(ctx, y, positionsToIndex(yp))
+	if err != nil {
+		return nil, nil, err
+	}
+	return xs, ys, nil
+}
+
+func outerResult(res, x, y Value) Value {
+	out := cloneValue(res)
+	out.SetAttr("names", nil)
+	setDims(out, x.Len(), y.Len())
+	setDimNames(out, valueNames(x), valueNames(y))
+	return out
+}
+
+// outerProduct implements outer() for the arithmetic and comparison
+// operators given by name, such as "*" or "+".
+func outerProduct(ctx *Context, x, y Value, op string) (Value, error) {
+	ops := map[string]func(a, b Value) (Value, error){}
+	for _, t := range []string{"+", "-", "*", "/", "^", "%%", "%/%"} {
+		tok := token.Type(t)
+		ops[t] = func(a, b Value) (Value, error) { return evalNumericBinary(ctx, tok, a, b) }
+	}
+	for _, t := range []string{"<", "<=", ">", ">=", "==", "!="} {
+		tok := token.Type(t)
+		ops[t] = func(a, b Value) (Value, error) { return evalCompare(ctx, tok, a, b) }
+	}
+	f, ok := ops[op]
+	if !ok {
+		return nil, fmt.Errorf("unsupported outer operator %s", op)
+	}
+	xs, ys, err := outerOperands(ctx, x, y)
+	if err != nil {
+		return nil, err
+	}
+	res, err := f(xs, ys)
+	if err != nil {
+		return nil, err
+	}
+	return outerResult(res, x, y), nil
+}
+
+func builtinDiag(ctx *Context, args []ArgValue) (Value, error) {
+	fargs, err := forceArgs(ctx, args)
+	if err != nil {
+		return nil, err
+	}
+	m, _ := matchArgs(fargs, "x", "nrow", "ncol")
+	var x Value = DoubleScalar(1)
+	if m[0] != nil {
+		x = m[0]
+	}
+	if nr, nc, ok := matrixDims(x); ok {
+		n := nr
+		if nc < n {
+			n = nc
+		}
+		pos := make([]int, n)
+		for i := range pos {
+			pos[i] = i + i*nr
+		}
+		return subsetVector(ctx, x, positionsToIndex(pos))
+	}
+	vals, err := asDoubleVec(ctx, x)
+	if err != nil {
+		return nil, err
+	}
+	n := len(vals)
+	if m[0] != nil && len(vals) == 1 && m[1] == nil {
+		// diag(n) is the n x n identity matrix
+		n = int(vals[0].Val)
+		vals = []FloatElem{{Val: 1}}
+	}
+	nr, nc := n, n
+	if m[1] != nil {
+		if nr, err = intArg(ctx, m[1], "nrow"); err != nil {
+			return nil, err
+		}
+		nc = nr
+	}
+	if m[2] != nil {
+		if nc, err = intArg(ctx, m[2], "ncol"); err != nil {
+			return nil, err
+		}
+	}
+	out := make([]FloatElem, nr*nc)
+	for i := 0; i < nr && i < nc && len(vals) > 0; i++ {
+		out[i+i*nr] = vals[i%len(vals)]
+	}
+	return newDoubleMatrix(out, nr, nc), nil
+}
+
+// luDecompose factors the n x n column-major matrix a in place using partial
+// pivoting. It returns the row permutation and its sign, or the index of a
+// zero pivot.
+func luDecompose(a []float64, n int) ([]int, float64, int) {
+	perm := make([]int, n)
+	for i := range perm {
+		perm[i] = i
+	}
+	sign := 1.0
+	for k := 0; k < n; k++ {
+		p := k
+		for i := k + 1; i < n; i++ {
+			if math.Abs(a[i+k*n]) > math.Abs(a[p+k*n]) {
+				p = i
+			}
+		}
+		if a[p+k*n] == 0 {
+			return perm, sign, k
+		}
+		if p != k {
+			for j := 0; j < n; j++ {
+				a[k+j*n], a[p+j*n] = a[p+j*n], a[k+j*n]
+			}
+			perm[k], perm[p] = perm[p], perm[k]
+			sign = -sign
+		}
+		for i := k + 1; i < n; i++ {
+			a[i+k*n] /= a[k+k*n]
+			for j := k + 1; j < n; j++ {
+				a[i+j*n] -= a[i+k*n] * a[k+j*n]
+			}
+		}
+	}
+	return perm, sign, -1
+}
+
+func squareMatrix(ctx *Context, x Value, name string) ([]float64, int, error) {
+	data, nr, nc, err := numericMatrix(ctx, x)
+	if err != nil {
+		return nil, 0, err
+	}
+	if nr != nc {
+		return nil, 0, fmt.Errorf("'%s' must be a square matrix", name)
+	}
+	a := make([]float64, len(data))
+	for i, e := range data {
+		if e.NA {
+			return nil, 0, fmt.Errorf("'%s' contains missing values", name)
+		}
+		a[i] = e.Val
+	}
+	return a, nr, nil
+}
+
+func builtinSolve(ctx *Context, args []ArgValue) (Value, error) {
+	fargs, err := forceArgs(ctx, args)
+	if err != nil {
+		return nil, err
+	}
+	m, _ := matchArgs(fargs, "a", "b")
+	if m[0] == nil {
+		return nil, fmt.Errorf("solve(a, b) expects at least 1 argument")
+	}
+	a, n, err := squareMatrix(ctx, m[0], "a")
+	if err != nil {
+		return nil, err
+	}
+	perm, _, zero := luDecompose(a, n)
+	if zero >= 0 {
+		return nil, fmt.Errorf("Lapack routine dgesv: system is exactly singular: U[%d,%d] = 0", zero+1, zero+1)
+	}
+
+	var b []FloatElem
+	nb := n
+	vecResult := false
+	if m[1] == nil {
+		b = make([]FloatElem, n*n)
+		for i := 0; i < n; i++ {
+			b[i+i*n] = FloatElem{Val: 1}
+		}
+	} else {
+		var br int
+		b, br, nb, err = numericMatrix(ctx, m[1])
+		if err != nil {
+			return nil, err
+		}
+		if br != n {
+			return nil, fmt.Errorf("'b' (%d x %d) must be compatible with 'a' (%d x %d)", br, nb, n, n)
+		}
+		vecResult = len(getDims(m[1])) != 2
+	}
+
+	out := make([]FloatElem, n*nb)
+	col := make([]float64, n)
+	for j := 0; j < nb; j++ {
+		for i := 0; i < n; i++ {
+			e := b[perm[i]+j*n]
+			if e.NA {
+				return nil, fmt.Errorf("'b' contains missing values")
+			}
+			col[i] = e.Val
+		}
+		// forward substitution with the unit lower triangle
+		for i := 0; i < n; i++ {
+			for k := 0; k < i; k++ {
+				col[i] -= a[i+k*n] * col[k]
+			}
+		}
+		// back substitution with the upper triangle
+		for i := n - 1; i >= 0; i-- {
+			for k := i + 1; k < n; k++ {
+				col[i] -= a[i+k*n] * col[k]
+			}
+			col[i] /= a[i+i*n]
+		}
+		for i := 0; i < n; i++ {
+			out[i+j*n] = FloatElem{Val: col[i]}
+		}
+	}
+	if vecResult {
+		return &DoubleVec{Data: out}, nil
+	}
+	res := newDoubleMatrix(out, n, nb)
+	if m[1] == nil {
+		setDimNames(res, dimNamesAt(m[0], 1), dimNamesAt(m[0], 0))
+	}
+	return res, nil
+}
+
+func builtinDet(ctx *Context, args []ArgValue) (Value, error) {
+	if len(args) != 1 {
+		return nil, fmt.Errorf("det(x) expects 1 argument")
+	}
+	x, err := Force(ctx, args[0].Val)
+	if err != nil {
+		return nil, err
+	}
+	a, n, err := squareMatrix(ctx, x, "a")
+	if err != nil {
+		return nil, err
+	}
+	_, sign, zero := luDecompose(a, n)
+	if zero >= 0 {
+		return DoubleScalar(0), nil
+	}
+	det := sign
+	for i := 0; i < n; i++ {
+		det *= a[i+i*n]
+	}
+	return DoubleScalar(det), nil
+}
+
+// --- row and column summaries ---
+
+func builtinColSums(ctx *Context, args []ArgValue) (Value, error) {
+	return marginSums(ctx, args, "colSums", false, false)
+}
+
+func builtinRowSums(ctx *Context, args []ArgValue) (Value, error) {
+	return marginSums(ctx, args, "rowSums", true, false)
+}
+
+func builtinColMeans(ctx *Context, args []ArgValue) (Value, error) {
+	return marginSums(ctx, args, "colMeans", false, true)
+}
+
+func builtinRowMeans(ctx *Context, args []ArgValue) (Value, error) {
+	return marginSums(ctx, args, "rowMeans", true, true)
+}
+
+func marginSums(ctx *Context, args []ArgValue, name string, rows, mean bool) (Value, error) {
+	fargs, err := forceArgs(ctx, args)
+	if err != nil {
+		return nil, err
+	}
+	m, _ := matchArgs(fargs, "x", "na.rm")
+	if m[0] == nil {
+		return nil, fmt.Errorf("%s(x) expects 1 argument", name)
+	}
+	x := m[0]
+	naRm := false
+	if m[1] != nil {
+		b, na, err := asLogicalScalar(ctx, m[1])
+		if err != nil {
+			return nil, err
+		}
+		naRm = b && !na
+	}
+	_, _, isMat := matrixDims(x)
+	if !isMat && !isDataFrame(x) {
+		return nil, fmt.Errorf("'x' must be an array of at least two dimensions")
+	}
+	data, nr, nc, err := numericMatrix(ctx, x)
+	if err != nil {
+		return nil, fmt.Errorf("'x' must be numeric")
+	}
+	n, inner := nc, nr
+	if rows {
+		n, inner = nr, nc
+	}
+	out := make([]FloatElem, n)
+	for o := 0; o < n; o++ {
+		sum, cnt := 0.0, 0
+		na := false
+		for i := 0; i < inner; i++ {
+			e := data[o*nr+i]
+			if rows {
+				e = data[o+i*nr]
+			}
+			if e.NA {
+				if naRm {
+					continue
+				}
+				na = true
+				break
+			}
+			sum += e.Val
+			cnt++
+		}
+		switch {
+		case na:
+			out[o] = FloatElem{NA: true}
+		case mean && cnt == 0:
+			out[o] = FloatElem{Val: math.NaN()}
+		case mean:
+			out[o] = FloatElem{Val: sum / float64(cnt)}
+		default:
+			out[o] = FloatElem{Val: sum}
+		}
+	}
+	res := &DoubleVec{Data: out}
+	var names []string
+	if df, ok := x.(*ListVec); ok && isDataFrame(x) {
+		if rows {
+			names = dfRowNames(df)
+		} else {
+			names, _ = listNames(df)
+		}
+	} else if rows {
+		names = dimNamesAt(x, 0)
+	} else {
+		names = dimNamesAt(x, 1)
+	}
+	if names != nil {
+		res.SetAttr("names", charVecOf(names))
+	}
+	return res, nil
+}
+
+// --- printing ---
+
+// formatArray renders a matrix like R's print: a header with column labels
+// and one line per row. Higher dimensional arrays are printed as a sequence
+// of matrix slices.
+func formatArray(v Value, d []int) string {
+	if len(d) == 2 {
+		return formatMatrix(v, d[0], d[1], dimNamesAt(v, 0), dimNamesAt(v, 1))
+	}
+	nr, nc := d[0], d[1]
+	slices := 1
+	for _, n := range d[2:] {
+		slices *= n
+	}
+	if nr*nc*slices == 0 {
+		return fmt.Sprintf("<%s array of %s>", joinDims(d), v.Type())
+	}
+	var sb strings.Builder
+	counter := make([]int, len(d)-2)
+	for s := 0; s < slices; s++ {
+		var labels []string
+		for k, c := range counter {
+			if names := dimNamesAt(v, k+2); names != nil {
+				labels = append(labels, names[c])
+			} else {
+				labels = append(labels, strconv.Itoa(c+1))
+			}
+		}
+		pos := make([]int, nr*nc)
+		for k := range pos {
+			pos[k] = s*nr*nc + k
+		}
+		slice, _ := subsetVector(nil, v, positionsToIndex(pos))
+		if s > 0 {
+			sb.WriteString("\n\n")
+		}
+		sb.WriteString(", , " + strings.Join(labels, ", ") + "\n\n")
+		sb.WriteString(formatMatrix(slice, nr, nc, dimNamesAt(v, 0), dimNamesAt(v, 1)))
+		for k := range counter {
+			counter[k]++
+			if counter[k] < d[k+2] {
+				break
+			}
+			counter[k] = 0
+		}
+	}
+	return sb.String()
+}
+
+func joinDims(d []int) string {
+	parts := make([]string, len(d))
+	for i, n := range d {
+		parts[i] = strconv.Itoa(n)
+	}
+	return strings.Join(parts, " x ")
+}
+
+func formatMatrix(v Value, nr, nc int, rowNames, colNames []string) string {
+	if nr == 0 || nc == 0 {
+		return fmt.Sprintf("<%d x %d matrix>", nr, nc)
+	}
+	cells := make([][]string, nc)
+	for j := 0; j < nc; j++ {
+		cells[j] = formatColumn(v, j*nr, nr)
+	}
+	_, leftAlign := v.(*CharVec)
+
+	rowLabels := make([]string, nr)
+	labelWidth := 0
+	for i := range rowLabels {
+		if rowNames != nil {
+			rowLabels[i] = rowNames[i]
+		} else {
+			rowLabels[i] = fmt.Sprintf("[%d,]", i+1)
+		}
+		if len(rowLabels[i]) > labelWidth {
+			labelWidth = len(rowLabels[i])
+		}
+	}
+
+	var sb strings.Builder
+	sb.WriteString(strings.Repeat(" ", labelWidth))
+	widths := make([]int, nc)
+	headers := make([]string, nc)
+	for j := 0; j < nc; j++ {
+		if colNames != nil {
+			headers[j] = colNames[j]
+		} else {
+			headers[j] = fmt.Sprintf("[,%d]", j+1)
+		}
+		widths[j] = len(headers[j])
+		for _, c := range cells[j] {
+			if len(c) > widths[j] {
+				widths[j] = len(c)
+			}
+		}
+		sb.WriteString(" " + padCell(headers[j], widths[j], leftAlign))
+	}
+	for i := 0; i < nr; i++ {
+		sb.WriteString("\n" + rowLabels[i] + strings.Repeat(" ", labelWidth-len(rowLabels[i])))
+		for j := 0; j < nc; j++ {
+			sb.WriteString(" " + padCell(cells[j][i], widths[j], leftAlign))
+		}
+	}
+	return sb.String()
+}
+
+func padCell(s string, width int, left bool) string {
+	pad := strings.Repeat(" ", width-len(s))
+	if left {
+		return s + pad
+	}
+	return pad + s
+}
+
+// formatColumn formats n elements of v starting at offset. Doubles share the
+// number of decimals within a column, as R does.
+func formatColumn(v Value, offset, n int) []string {
+	out := make([]string, n)
+	switch t := v.(type) {
+	case *DoubleVec:
+		decimals, sci := 0, false
+		for _, e := range t.Data[offset : offset+n] {
+			if e.NA || math.IsNaN(e.Val) || math.IsInf(e.Val, 0) {
+				continue
+			}
+			s := strconv.FormatFloat(e.Val, 'g', 7, 64)
+			if strings.ContainsRune(s, 'e') {
+				sci = true
+			} else if dot := strings.IndexByte(s, '.'); dot >= 0 && len(s)-dot-1 > decimals {
+				decimals = len(s) - dot - 1
+			}
+		}
+		for i, e := range t.Data[offset : offset+n] {
+			switch {
+			case e.NA:
+				out[i] = "NA"
+			case math.IsNaN(e.Val):
+				out[i] = "NaN"
+			case math.IsInf(e.Val, 1):
+				out[i] = "Inf"
+			case math.IsInf(e.Val, -1):
+				out[i] = "-Inf"
+			case sci:
+				out[i] = strconv.FormatFloat(e.Val, 'g', 7, 64)
+			default:
+				out[i] = strconv.FormatFloat(e.Val, 'f', decimals, 64)
+			}
+		}
+	case *CharVec:
+		for i, e := range t.Data[offset : offset+n] {
+			if e.NA {
+				out[i] = "NA"
+			} else {
+				out[i] = strconv.Quote(e.Val)
+			}
+		}
+	case *ListVec:
+		for i, e := range t.Data[offset : offset+n] {
+			if e == nil {
+				out[i] = "NULL"
+			} else {
+				out[i] = formatValueForPrint(e)
+			}
+		}
+	default:
+		all := toPlainStrings(v)
+		copy(out, all[offset:offset+n])
+	}
+	return out
+}
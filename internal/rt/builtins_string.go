@@ -2,6 +2,7 @@ package rt
 
 import (
 	"fmt"
+	"math"
 	"strings"
 	"unicode"
 )
@@ -24,7 +25,7 @@ func installStringBuiltins(env *Env) {
 		"gsub":       {FnName: "gsub", Impl: builtinGsub},
 		"strsplit":   {FnName: "strsplit", Impl: builtinStrsplit},
 		"sprintf":    {FnName: "sprintf", Impl: builtinSprintf},
-		"format":     {FnName: "format", Impl: builtinFormat},
+		"format":     {FnName: "format", Impl: builtinFormat, Generic: true},
 		"chartr":     {FnName: "chartr", Impl: builtinChartr},
 		"strrep":     {FnName: "strrep", Impl: builtinStrrep},
 	}
@@ -590,7 +591,7 @@ func builtinSprintf(ctx *Context, args []ArgValue) (Value, error) {
 		switch t := v.(type) {
 		case *DoubleVec:
 			if t.Len() == 1 && !t.Data[0].NA {
-				fmtArgs = append(fmtArgs, t.Data[0].Val)
+				fmtArgs = append(fmtArgs, sprintfDouble(t.Data[0].Val))
 			} else {
 				fmtArgs = append(fmtArgs, v.String())
 			}
@@ -625,6 +626,18 @@ func builtinSprintf(ctx *Context, args []ArgValue) (Value, error) {
 	return CharScalar(result), nil
 }
 
+// sprintfDouble lets whole doubles be formatted with %d, as sprintf("%d", 3)
+// does in R; 3 is a double there too.
+type sprintfDouble float64
+
+func (d sprintfDouble) Format(f fmt.State, verb rune) {
+	if (verb == 'd' || verb == 'i') && float64(d) == math.Trunc(float64(d)) {
+		fmt.Fprintf(f, fmt.FormatString(f, 'd'), int64(d))
+		return
+	}
+	fmt.Fprintf(f, fmt.FormatString(f, verb), float64(d))
+}
+
 func builtinFormat(ctx *Context, args []ArgValue) (Value, error) {
 	// format(x) — convert to character representation
 	if len(args) < 1 {
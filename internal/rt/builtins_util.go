@@ -2,8 +2,7 @@ package rt
 
 import (
 	"fmt"
-	"sort"
-	"strings"
+	"math"
 )
 
 func installUtilBuiltins(env *Env) {
@@ -12,8 +11,8 @@ func installUtilBuiltins(env *Env) {
 		"which":      {FnName: "which", Impl: builtinWhich},
 		"which.min":  {FnName: "which.min", Impl: builtinWhichMin},
 		"which.max":  {FnName: "which.max", Impl: builtinWhichMax},
-		"any":        {FnName: "any", Impl: builtinAny},
-		"all":        {FnName: "all", Impl: builtinAll},
+		"any":        {FnName: "any", Impl: builtinAny, Group: "Summary"},
+		"all":        {FnName: "all", Impl: builtinAll, Group: "Summary"},
 		"rev":        {FnName: "rev", Impl: builtinRev},
 		"sort":       {FnName: "sort", Impl: builtinSort},
 		"order":      {FnName: "order", Impl: builtinOrder},
@@ -54,16 +53,12 @@ func installUtilBuiltins(env *Env) {
 		"do.call": {FnName: "do.call", Impl: builtinDoCall},
 
 		// Control / error handling
-		"ifelse":   {FnName: "ifelse", Impl: builtinIfelse},
-		"switch":   {FnName: "switch", Impl: builtinSwitch},
-		"tryCatch": {FnName: "tryCatch", Impl: builtinTryCatch},
-		"message":  {FnName: "message", Impl: builtinMessage},
-		"nargs":    {FnName: "nargs", Impl: builtinNargs},
+		"ifelse": {FnName: "ifelse", Impl: builtinIfelse},
+		"switch": {FnName: "switch", Impl: builtinSwitch},
+		"nargs":  {FnName: "nargs", Impl: builtinNargs},
 
 		// Environment
-		"exists":      {FnName: "exists", Impl: builtinExists},
-		"environment": {FnName: "environment", Impl: builtinEnvironment},
-		"Sys.time":    {FnName: "Sys.time", Impl: builtinSysTime},
+		"Sys.time": {FnName: "Sys.time", Impl: builtinSysTime},
 
 		// Numeric utilities
 		"is.na":    nil, // already installed in builtins.go
@@ -256,12 +251,18 @@ func builtinRev(ctx *Context, args []ArgValue) (Value, error) {
 			out[len(out)-1-i] = e
 		}
 		return &DoubleVec{Data: out}, nil
+	case *ComplexVec:
+		out := make([]ComplexElem, len(t.Data))
+		for i, e := range t.Data {
+			out[len(out)-1-i] = e
+		}
+		return &ComplexVec{Data: out}, nil
 	case *IntVec:
 		out := make([]IntElem, len(t.Data))
 		for i, e := range t.Data {
 			out[len(out)-1-i] = e
 		}
-		return &IntVec{Data: out}, nil
+		return copyFactorAttrs(&IntVec{Data: out}, t), nil
 	case *LogicalVec:
 		out := make([]LogicalElem, len(t.Data))
 		for i, e := range t.Data {
@@ -310,12 +311,14 @@ func builtinSort(ctx *Context, args []ArgValue) (Value, error) {
 				out = append(out, e)
 			}
 		}
-		sort.Slice(out, func(i, j int) bool {
+		if err := ctx.sortSlice(out, func(i, j int) bool {
 			if decreasing {
 				return out[i].Val > out[j].Val
 			}
 			return out[i].Val < out[j].Val
-		})
+		}); err != nil {
+			return nil, err
+		}
 		return &DoubleVec{Data: out}, nil
 	case *IntVec:
 		out := make([]IntElem, 0, len(t.Data))
@@ -324,13 +327,15 @@ func builtinSort(ctx *Context, args []ArgValue) (Value, error) {
 				out = append(out, e)
 			}
 		}
-		sort.Slice(out, func(i, j int) bool {
+		if err := ctx.sortSlice(out, func(i, j int) bool {
 			if decreasing {
 				return out[i].Val > out[j].Val
 			}
 			return out[i].Val < out[j].Val
-		})
-		return &IntVec{Data: out}, nil
+		}); err != nil {
+			return nil, err
+		}
+		return copyFactorAttrs(&IntVec{Data: out}, t), nil
 	case *CharVec:
 		out := make([]StringElem, 0, len(t.Data))
 		for _, e := range t.Data {
@@ -338,12 +343,14 @@ func builtinSort(ctx *Context, args []ArgValue) (Value, error) {
 				out = append(out, e)
 			}
 		}
-		sort.Slice(out, func(i, j int) bool {
+		if err := ctx.sortSlice(out, func(i, j int) bool {
 			if decreasing {
 				return out[i].Val > out[j].Val
 			}
 			return out[i].Val < out[j].Val
-		})
+		}); err != nil {
+			return nil, err
+		}
 		return &CharVec{Data: out}, nil
 	default:
 		return nil, fmt.Errorf("sort: unsupported type %s", v.Type())
@@ -375,7 +382,7 @@ func builtinOrder(ctx *Context, args []ArgValue) (Value, error) {
 	for i := range indices {
 		indices[i] = i
 	}
-	sort.Slice(indices, func(i, j int) bool {
+	if err := ctx.sortSlice(indices, func(i, j int) bool {
 		ai := dv[indices[i]]
 		aj := dv[indices[j]]
 		if ai.NA && aj.NA {
@@ -391,7 +398,9 @@ func builtinOrder(ctx *Context, args []ArgValue) (Value, error) {
 			return ai.Val > aj.Val
 		}
 		return ai.Val < aj.Val
-	})
+	}); err != nil {
+		return nil, err
+	}
 	out := make([]IntElem, len(indices))
 	for i, idx := range indices {
 		out[i] = IntElem{Val: int64(idx + 1)}
@@ -436,7 +445,7 @@ func builtinUnique(ctx *Context, args []ArgValue) (Value, error) {
 				out = append(out, e)
 			}
 		}
-		return &IntVec{Data: out}, nil
+		return copyFactorAttrs(&IntVec{Data: out}, t), nil
 	case *CharVec:
 		seen := map[string]bool{}
 		var out []StringElem
@@ -490,39 +499,6 @@ func builtinDuplicated(ctx *Context, args []ArgValue) (Value, error) {
 	return &LogicalVec{Data: out}, nil
 }
 
-func builtinTable(ctx *Context, args []ArgValue) (Value, error) {
-	// table(x) — returns named integer vector of counts
-	if len(args) < 1 {
-		return nil, fmt.Errorf("table(x) expects at least 1 argument")
-	}
-	v, err := Force(ctx, args[0].Val)
-	if err != nil {
-		return nil, err
-	}
-	strs := toPlainStrings(v)
-
-	// Ordered unique keys
-	var keys []string
-	counts := map[string]int{}
-	for _, s := range strs {
-		if _, ok := counts[s]; !ok {
-			keys = append(keys, s)
-		}
-		counts[s]++
-	}
-	sort.Strings(keys)
-
-	data := make([]IntElem, len(keys))
-	names := make([]StringElem, len(keys))
-	for i, k := range keys {
-		data[i] = IntElem{Val: int64(counts[k])}
-		names[i] = StringElem{Val: k}
-	}
-	result := &IntVec{Data: data}
-	result.SetAttr("names", &CharVec{Data: names})
-	return result, nil
-}
-
 func builtinMatch(ctx *Context, args []ArgValue) (Value, error) {
 	// match(x, table) — returns integer vector of first positions
 	if len(args) < 2 {
@@ -845,7 +821,8 @@ func builtinIsVector(ctx *Context, args []ArgValue) (Value, error) {
 
 func builtinIsFunction(ctx *Context, args []ArgValue) (Value, error) {
 	return typeCheck(ctx, args, "is.function", func(v Value) bool {
-		return v.Type() == "function"
+		_, ok := v.(Callable)
+		return ok
 	})
 }
 
@@ -936,7 +913,39 @@ func builtinIdentical(ctx *Context, args []ArgValue) (Value, error) {
 	if err != nil {
 		return nil, err
 	}
-	return LogicalScalar(x.String() == y.String() && x.Type() == y.Type()), nil
+	return LogicalScalar(identicalValues(x, y)), nil
+}
+
+// identicalValues compares type, elements and attributes, so that 1 and 1L
+// or a vector and the same vector with names differ. Attribute order does
+// not matter.
+func identicalValues(x, y Value) bool {
+	if x.Type() != y.Type() || x.Len() != y.Len() {
+		return false
+	}
+	xa, ya := x.Attrs(), y.Attrs()
+	if len(xa) != len(ya) {
+		return false
+	}
+	for k, a := range xa {
+		b, ok := ya[k]
+		if !ok || !identicalValues(a, b) {
+			return false
+		}
+	}
+	if xl, ok := x.(*ListVec); ok {
+		yl, ok := y.(*ListVec)
+		if !ok {
+			return false
+		}
+		for i := range xl.Data {
+			if !identicalValues(xl.Data[i], yl.Data[i]) {
+				return false
+			}
+		}
+		return true
+	}
+	return x.String() == y.String()
 }
 
 // --- Apply family ---
@@ -954,6 +963,16 @@ func builtinLapply(ctx *Context, args []ArgValue) (Value, error) {
 	if err != nil {
 		return nil, err
 	}
+	// FUN may name the function, as in sapply(x, "+", 1)
+	if cv, ok := funV.(*CharVec); ok && cv.Len() == 1 && !cv.Data[0].NA {
+		fv, ok := ctx.callingEnv().Get(cv.Data[0].Val)
+		if !ok {
+			return nil, fmt.Errorf("could not find function \"%s\"", cv.Data[0].Val)
+		}
+		if funV, err = Force(ctx, fv); err != nil {
+			return nil, err
+		}
+	}
 	callable, ok := funV.(Callable)
 	if !ok {
 		return nil, fmt.Errorf("lapply: FUN is not a function")
@@ -972,6 +991,9 @@ func builtinLapply(ctx *Context, args []ArgValue) (Value, error) {
 		callArgs := make([]ArgValue, 0, 1+len(extraArgs))
 		callArgs = append(callArgs, ArgValue{Val: elem})
 		callArgs = append(callArgs, extraArgs...)
+		if err := ctx.interrupted(); err != nil {
+			return nil, err
+		}
 		res, err := callable.Call(ctx, nil, callArgs)
 		if err != nil {
 			return nil, err
@@ -1106,6 +1128,9 @@ func builtinMapFunc(ctx *Context, args []ArgValue) (Value, error) {
 			}
 			callArgs[j] = ArgValue{Val: elem}
 		}
+		if err := ctx.interrupted(); err != nil {
+			return nil, err
+		}
 		res, err := callable.Call(ctx, nil, callArgs)
 		if err != nil {
 			return nil, err
@@ -1161,6 +1186,9 @@ func builtinReduce(ctx *Context, args []ArgValue) (Value, error) {
 		if err != nil {
 			return nil, err
 		}
+		if err := ctx.interrupted(); err != nil {
+			return nil, err
+		}
 		acc, err = callable.Call(ctx, nil, []ArgValue{{Val: acc}, {Val: elem}})
 		if err != nil {
 			return nil, err
@@ -1193,6 +1221,9 @@ func builtinFilter(ctx *Context, args []ArgValue) (Value, error) {
 		if err != nil {
 			return nil, err
 		}
+		if err := ctx.interrupted(); err != nil {
+			return nil, err
+		}
 		res, err := callable.Call(ctx, nil, []ArgValue{{Val: elem}})
 		if err != nil {
 			return nil, err
@@ -1372,77 +1403,12 @@ func builtinSwitch(ctx *Context, args []ArgValue) (Value, error) {
 	return NullValue, nil
 }
 
-func builtinTryCatch(ctx *Context, args []ArgValue) (Value, error) {
-	// tryCatch(expr, error=function(e) {...}, warning=function(w) {...})
-	if len(args) < 1 {
-		return nil, fmt.Errorf("tryCatch() expects at least 1 argument")
-	}
-
-	// Evaluate the expression
-	result, err := Force(ctx, args[0].Val)
-	if err != nil {
-		// Check if there's an error handler
-		if handler, ok := getNamed(args, "error"); ok {
-			handlerV, herr := Force(ctx, handler)
-			if herr != nil {
-				return nil, herr
-			}
-			callable, ok := handlerV.(Callable)
-			if !ok {
-				return nil, fmt.Errorf("tryCatch: error handler is not a function")
-			}
-			// Create error message value
-			errMsg := CharScalar(err.Error())
-			return callable.Call(ctx, nil, []ArgValue{{Val: errMsg}})
-		}
-		return nil, err
-	}
-
-	return result, nil
-}
-
-func builtinMessage(ctx *Context, args []ArgValue) (Value, error) {
-	fargs, err := forceArgs(ctx, args)
-	if err != nil {
-		return nil, err
-	}
-	var parts []string
-	for _, a := range fargs {
-		ps := toPlainStrings(a.Val)
-		parts = append(parts, ps...)
-	}
-	ctx.Println(strings.Join(parts, ""))
-	return NullValue, nil
-}
-
 func builtinNargs(ctx *Context, args []ArgValue) (Value, error) {
 	return IntScalar(int64(len(args))), nil
 }
 
 // --- Environment ---
 
-func builtinExists(ctx *Context, args []ArgValue) (Value, error) {
-	if len(args) < 1 {
-		return nil, fmt.Errorf("exists(x) expects at least 1 argument")
-	}
-	v, err := Force(ctx, args[0].Val)
-	if err != nil {
-		return nil, err
-	}
-	cv, ok := v.(*CharVec)
-	if !ok || cv.Len() < 1 || cv.Data[0].NA {
-		return nil, fmt.Errorf("exists: argument must be a character string")
-	}
-	name := cv.Data[0].Val
-	_, found := ctx.Global.Get(name)
-	return LogicalScalar(found), nil
-}
-
-func builtinEnvironment(ctx *Context, args []ArgValue) (Value, error) {
-	// Simplified: just returns a description
-	return CharScalar("<environment>"), nil
-}
-
 func builtinSysTime(ctx *Context, args []ArgValue) (Value, error) {
 	// Return current time as a numeric (not using time package to keep it simple)
 	return CharScalar("Sys.time() not implemented in smallR"), nil
@@ -1491,36 +1457,39 @@ func builtinWhichNA(ctx *Context, args []ArgValue) (Value, error) {
 }
 
 func builtinTabulate(ctx *Context, args []ArgValue) (Value, error) {
-	// tabulate(bin, nbins = max(1, bin, na.rm=TRUE))
-	if len(args) < 1 {
-		return nil, fmt.Errorf("tabulate(bin) expects at least 1 argument")
-	}
-	v, err := Force(ctx, args[0].Val)
+	// tabulate(bin, nbins = max(1, bin, na.rm = TRUE)); the bins of a
+	// factor are its levels
+	fargs, err := forceArgs(ctx, args)
 	if err != nil {
 		return nil, err
 	}
-	iv, err := coerceToIntVec(ctx, v)
+	m, _ := matchArgs(fargs, "bin", "nbins")
+	if m[0] == nil {
+		return nil, fmt.Errorf("argument \"bin\" is missing, with no default")
+	}
+	if !isNumericValue(m[0]) && !isFactor(m[0]) && m[0].Type() != "logical" {
+		return nil, fmt.Errorf("'bin' must be numeric or a factor")
+	}
+	iv, err := coerceToIntVec(ctx, m[0])
 	if err != nil {
 		return nil, err
 	}
-	nbins := 0
-	for _, e := range iv {
-		if !e.NA && int(e.Val) > nbins {
-			nbins = int(e.Val)
+	nbins := 1
+	if isFactor(m[0]) {
+		nbins = len(factorLevels(m[0]))
+	} else {
+		for _, e := range iv {
+			if !e.NA && int(e.Val) > nbins {
+				nbins = int(e.Val)
+			}
 		}
 	}
-	if len(args) >= 2 {
-		nbV, err := Force(ctx, args[1].Val)
-		if err != nil {
-			return nil, err
-		}
-		fe, err := asFloatElem(ctx, nbV)
-		if err != nil {
-			return nil, err
-		}
-		if !fe.NA {
-			nbins = int(fe.Val)
+	if m[1] != nil {
+		fe, err := asFloatElem(ctx, m[1])
+		if err != nil || fe.NA || fe.Val < 0 || fe.Val > math.MaxInt32 {
+			return nil, fmt.Errorf("invalid 'nbins' argument")
 		}
+		nbins = int(fe.Val)
 	}
 	out := make([]IntElem, nbins)
 	for _, e := range iv {
@@ -0,0 +1,321 @@
+package rt
+
+import (
+	"fmt"
+	"math"
+	"math/cmplx"
+
+	"simonwaldherr.de/go/smallr/internal/token"
+)
+
+func installComplexBuiltins(env *Env) {
+	builtins := map[string]*BuiltinFunc{
+		"complex":    {FnName: "complex", Impl: builtinComplex},
+		"Re":         {FnName: "Re", Impl: complexPart("Re", func(z complex128) float64 { return real(z) })},
+		"Im":         {FnName: "Im", Impl: complexPart("Im", func(z complex128) float64 { return imag(z) })},
+		"Mod":        {FnName: "Mod", Impl: complexPart("Mod", cmplx.Abs)},
+		"Arg":        {FnName: "Arg", Impl: complexPart("Arg", cmplx.Phase)},
+		"Conj":       {FnName: "Conj", Impl: builtinConj},
+		"is.complex": {FnName: "is.complex", Impl: builtinIsComplex},
+		"as.complex": {FnName: "as.complex", Impl: builtinAsComplex},
+	}
+	for name, fn := range builtins {
+		env.SetLocal(name, fn)
+	}
+	env.SetLocal("NA_complex_", &ComplexVec{Data: []ComplexElem{{NA: true}}})
+}
+
+func asComplexVec(ctx *Context, v Value) ([]ComplexElem, error) {
+	v, err := Force(ctx, v)
+	if err != nil {
+		return nil, err
+	}
+	if t, ok := v.(*ComplexVec); ok {
+		return t.Data, nil
+	}
+	if cv, ok := v.(*CharVec); ok {
+		out := make([]ComplexElem, len(cv.Data))
+		for i, e := range cv.Data {
+			if e.NA {
+				out[i] = ComplexElem{NA: true}
+				continue
+			}
+			z, err := parseComplex(e.Val)
+			if err != nil {
+				return nil, err
+			}
+			out[i] = ComplexElem{Val: z}
+		}
+		return out, nil
+	}
+	dv, err := asDoubleVec(ctx, v)
+	if err != nil {
+		return nil, fmt.Errorf("cannot coerce %s to complex", v.Type())
+	}
+	out := make([]ComplexElem, len(dv))
+	for i, e := range dv {
+		out[i] = ComplexElem{Val: complex(e.Val, 0), NA: e.NA}
+	}
+	return out, nil
+}
+
+// parseComplex reads numbers such as "1", "2i" or "1-2.5i", the way
+// as.complex() does for character input.
+func parseComplex(s string) (complex128, error) {
+	var re, im float64
+	if _, err := fmt.Sscanf(s+"|", "%g%gi|", &re, &im); err == nil {
+		return complex(re, im), nil
+	}
+	if _, err := fmt.Sscanf(s+"|", "%gi|", &im); err == nil {
+		return complex(0, im), nil
+	}
+	if _, err := fmt.Sscanf(s+"|", "%g|", &re); err == nil {
+		return complex(re, 0), nil
+	}
+	return 0, fmt.Errorf("cannot coerce '%s' to complex", s)
+}
+
+// complexArith implements the arithmetic operators when an operand is
+// complex. Comparisons other than == and != are not defined on complex
+// numbers and neither are %% and %/%.
+func complexArith(ctx *Context, op token.Type, a, b Value) (Value, error) {
+	av, err := asComplexVec(ctx, a)
+	if err != nil {
+		return nil, err
+	}
+	bv, err := asComplexVec(ctx, b)
+	if err != nil {
+		return nil, err
+	}
+	if len(av) == 0 || len(bv) == 0 {
+		return &ComplexVec{Data: nil}, nil
+	}
+	n := max(len(av), len(bv))
+	out := make([]ComplexElem, n)
+	for i := range out {
+		ae, be := av[i%len(av)], bv[i%len(bv)]
+		if ae.NA || be.NA {
+			out[i] = ComplexElem{NA: true}
+			continue
+		}
+		switch op {
+		case token.PLUS:
+			out[i] = ComplexElem{Val: ae.Val + be.Val}
+		case token.MINUS:
+			out[i] = ComplexElem{Val: ae.Val - be.Val}
+		case token.STAR:
+			out[i] = ComplexElem{Val: ae.Val * be.Val}
+		case token.SLASH:
+			out[i] = ComplexElem{Val: ae.Val / be.Val}
+		case token.CARET:
+			out[i] = ComplexElem{Val: complexPow(ae.Val, be.Val)}
+		default:
+			return nil, fmt.Errorf("invalid operation on complex numbers")
+		}
+	}
+	return &ComplexVec{Data: out}, nil
+}
+
+// complexPow keeps integral powers exact, so that (1i)^2 is -1+0i rather
+// than -1+1.2e-16i.
+func complexPow(z, w complex128) complex128 {
+	if imag(w) == 0 && real(w) == math.Trunc(real(w)) && math.Abs(real(w)) <= 65536 {
+		k := int(real(w))
+		r := complex(1, 0)
+		base := z
+		if k < 0 {
+			base, k = 1/z, -k
+		}
+		for ; k > 0; k >>= 1 {
+			if k&1 == 1 {
+				r *= base
+			}
+			base *= base
+		}
+		return r
+	}
+	return cmplx.Pow(z, w)
+}
+
+func complexCompare(ctx *Context, op token.Type, a, b Value) (Value, error) {
+	if op != token.EQ && op != token.NEQ {
+		return nil, fmt.Errorf("invalid comparison with complex values")
+	}
+	av, err := asComplexVec(ctx, a)
+	if err != nil {
+		return nil, err
+	}
+	bv, err := asComplexVec(ctx, b)
+	if err != nil {
+		return nil, err
+	}
+	if len(av) == 0 || len(bv) == 0 {
+		return &LogicalVec{Data: nil}, nil
+	}
+	out := make([]LogicalElem, max(len(av), len(bv)))
+	for i := range out {
+		ae, be := av[i%len(av)], bv[i%len(bv)]
+		if ae.NA || be.NA {
+			out[i] = LogicalElem{NA: true}
+			continue
+		}
+		out[i] = LogicalElem{Val: (ae.Val == be.Val) == (op == token.EQ)}
+	}
+	return &LogicalVec{Data: out}, nil
+}
+
+// complexMath holds the Math functions that are defined on complex
+// numbers.
+var complexMath = map[string]func(complex128) complex128{
+	"sqrt": cmplx.Sqrt,
+	"exp":  cmplx.Exp,
+	"log":  cmplx.Log,
+	"sin":  cmplx.Sin,
+	"cos":  cmplx.Cos,
+	"tan":  cmplx.Tan,
+}
+
+func complexMathUnary(z *ComplexVec, name string) (Value, error) {
+	fn, ok := complexMath[name]
+	if !ok {
+		return nil, fmt.Errorf("invalid argument to %s(): complex values are not supported", name)
+	}
+	out := make([]ComplexElem, len(z.Data))
+	for i, e := range z.Data {
+		if e.NA {
+			out[i] = ComplexElem{NA: true}
+		} else {
+			out[i] = ComplexElem{Val: fn(e.Val)}
+		}
+	}
+	return &ComplexVec{Data: out}, nil
+}
+
+// builtinComplex implements complex(length.out, real, imaginary, modulus,
+// argument). modulus and argument take precedence over real and
+// imaginary, as in R.
+func builtinComplex(ctx *Context, args []ArgValue) (Value, error) {
+	formals := []string{"length.out", "real", "imaginary", "modulus", "argument"}
+	m, rest := matchArgs(args, formals...)
+	if len(rest) > 0 {
+		return nil, fmt.Errorf("unused argument %s", rest[0].Name)
+	}
+	parts := map[string][]FloatElem{}
+	n := 0
+	for i, name := range formals {
+		if m[i] == nil {
+			continue
+		}
+		dv, err := asDoubleVec(ctx, m[i])
+		if err != nil {
+			return nil, err
+		}
+		if name == "length.out" {
+			if len(dv) != 1 || dv[0].NA || dv[0].Val < 0 {
+				return nil, fmt.Errorf("invalid length")
+			}
+			n = max(n, int(dv[0].Val))
+			continue
+		}
+		parts[name] = dv
+		n = max(n, len(dv))
+	}
+	at := func(name string, i int, def float64) (float64, bool) {
+		dv, ok := parts[name]
+		if !ok || len(dv) == 0 {
+			return def, false
+		}
+		e := dv[i%len(dv)]
+		return e.Val, e.NA
+	}
+	_, polar := parts["modulus"]
+	if _, ok := parts["argument"]; ok {
+		polar = true
+	}
+	out := make([]ComplexElem, n)
+	for i := range out {
+		if polar {
+			r, na1 := at("modulus", i, 1)
+			theta, na2 := at("argument", i, 0)
+			out[i] = ComplexElem{Val: cmplx.Rect(r, theta), NA: na1 || na2}
+			continue
+		}
+		re, na1 := at("real", i, 0)
+		im, na2 := at("imaginary", i, 0)
+		out[i] = ComplexElem{Val: complex(re, im), NA: na1 || na2}
+	}
+	return &ComplexVec{Data: out}, nil
+}
+
+// complexPart returns Re, Im, Mod or Arg: fn is applied to each element
+// of the argument taken as complex.
+func complexPart(name string, fn func(complex128) float64) func(*Context, []ArgValue) (Value, error) {
+	return func(ctx *Context, args []ArgValue) (Value, error) {
+		if len(args) != 1 {
+			return nil, fmt.Errorf("%s(z) expects 1 argument", name)
+		}
+		x, err := Force(ctx, args[0].Val)
+		if err != nil {
+			return nil, err
+		}
+		zv, err := asComplexVec(ctx, x)
+		if err != nil {
+			return nil, fmt.Errorf("non-numeric argument to function")
+		}
+		out := make([]FloatElem, len(zv))
+		for i, e := range zv {
+			if e.NA {
+				out[i] = FloatElem{NA: true}
+			} else {
+				out[i] = FloatElem{Val: fn(e.Val)}
+			}
+		}
+		return copyDims(&DoubleVec{Data: out}, x), nil
+	}
+}
+
+func builtinConj(ctx *Context, args []ArgValue) (Value, error) {
+	if len(args) != 1 {
+		return nil, fmt.Errorf("Conj(z) expects 1 argument")
+	}
+	x, err := Force(ctx, args[0].Val)
+	if err != nil {
+		return nil, err
+	}
+	zv, ok := x.(*ComplexVec)
+	if !ok {
+		// the conjugate of a real number is the number itself
+		if x.Type() == "integer" || x.Type() == "double" {
+			return x, nil
+		}
+		return nil, fmt.Errorf("non-numeric argument to function")
+	}
+	out := cloneComplex(zv)
+	for i, e := range out.Data {
+		out.Data[i].Val = cmplx.Conj(e.Val)
+	}
+	return out, nil
+}
+
+func builtinIsComplex(ctx *Context, args []ArgValue) (Value, error) {
+	if len(args) != 1 {
+		return nil, fmt.Errorf("is.complex(x) expects 1 argument")
+	}
+	x, err := Force(ctx, args[0].Val)
+	if err != nil {
+		return nil, err
+	}
+	_, ok := x.(*ComplexVec)
+	return LogicalScalar(ok), nil
+}
+
+func builtinAsComplex(ctx *Context, args []ArgValue) (Value, error) {
+	if len(args) < 1 {
+		return nil, fmt.Errorf("as.complex(x) expects 1 argument")
+	}
+	zv, err := asComplexVec(ctx, args[0].Val)
+	if err != nil {
+		return nil, err
+	}
+	return &ComplexVec{Data: append([]ComplexElem(nil), zv...)}, nil
+}
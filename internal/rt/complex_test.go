@@ -0,0 +1,154 @@
+package rt
+
+import "testing"
+
+func TestNumericLiterals(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"typeof(5)", `"double"`},
+		{"typeof(5L)", `"integer"`},
+		{"typeof(1e3L)", `"integer"`},
+		{"typeof(1.5L)", `"double"`},
+		{"typeof(0x10)", `"double"`},
+		{"typeof(0x10L)", `"integer"`},
+		{"typeof(2i)", `"complex"`},
+		{"class(5)", `"numeric"`},
+		{"identical(5, 5L)", "FALSE"},
+		{"identical(0x10L, 16L)", "TRUE"},
+		{"identical(0x1p3, 8)", "TRUE"},
+		{"identical(1:3, c(1L, 2L, 3L))", "TRUE"},
+		{"identical(c(a = 1), c(b = 1))", "FALSE"},
+		{"identical(list(1L, \"a\"), list(1L, \"a\"))", "TRUE"},
+		{"typeof(1.5:3)", `"double"`},
+		{"1.5:3", "1.5 2.5"},
+		{"identical(-2L, 0L - 2L)", "TRUE"},
+		{"typeof(2L + 3L)", `"integer"`},
+		{"typeof(TRUE + TRUE)", `"integer"`},
+		{"typeof(2L * 1.5)", `"double"`},
+		{"typeof(4L / 2L)", `"double"`},
+		{"typeof(4L ^ 2L)", `"double"`},
+		{"c(7L %/% 2L, 7L %% 2L, -7L %% 3L)", "3 1 2"},
+		{"c(-5 %% 3, 5 %% -3)", "1 -1"},
+		{"identical(sum(1:4), 10L)", "TRUE"},
+		{"typeof(sum(1:4, 0.5))", `"double"`},
+		{"identical(abs(-3L), 3L)", "TRUE"},
+		{"identical(max(1:3), 3L)", "TRUE"},
+		{"identical(c(min(c(2L, NA), na.rm = TRUE), max(c(2L, NA))), c(2L, NA))", "TRUE"},
+		{"identical(max(TRUE, FALSE), 1L)", "TRUE"},
+		{"identical(max(1:3, 2.5), 3)", "TRUE"},
+		{"identical(max(integer(0)), -Inf)", "TRUE"},
+		{"identical(range(c(3L, 1L, 2L)), c(1L, 3L))", "TRUE"},
+		{"identical(cumsum(1:3), c(1L, 3L, 6L))", "TRUE"},
+		{"identical(cummax(c(1L, 3L, 2L)), c(1L, 3L, 3L))", "TRUE"},
+		{"identical(cummin(c(3L, NA, 2L)), c(3L, NA, NA))", "TRUE"},
+		{"identical(cumprod(1:3), c(1, 2, 6))", "TRUE"},
+		{"identical(diff(c(1L, 4L, 9L)), c(3L, 5L))", "TRUE"},
+		{"identical(diff(1L), integer(0))", "TRUE"},
+		{"identical(round(5L), 5L)", "TRUE"},
+		{"identical(round(15L, -1), 20)", "TRUE"},
+		{"identical(c(floor(5L), ceiling(5L)), c(5, 5))", "TRUE"},
+		{"tryCatch(cumsum(c(2147483647L, 1L)), warning = function(w) conditionMessage(w))", `"integer overflow in 'cumsum'; use 'cumsum(as.numeric(.))'"`},
+		{`"\x41\u00e9\U{1F600}"`, `"Aé😀"`},
+		{`r"(C:\dir)"`, `"C:\\dir"`},
+		{"größe <- 3; größe * 2", "6"},
+		{`sprintf("%d items", 3)`, `"3 items"`},
+		{"c(1234567, 123456.5)", "1234567 123456.5"},
+		{"1234567", "1234567"},
+		{"c(1e6, 1e5, 0.001, 1e-4, 1e15)", "1e+06 1e+05 0.001 1e-04 1e+15"},
+		{"1e6", "1e+06"},
+		{"0.0001234", "0.0001234"},
+		{"0.00001234", "1.234e-05"},
+		{"paste(1234567, 1e6, 100000, 0.001)", `"1234567 1e+06 1e+05 0.001"`},
+		{"as.character(-1234567)", `"-1234567"`},
+		{"c(1e400, -1e999, 1e-400)", "Inf -Inf 0"},
+	}
+
+	for _, tt := range tests {
+		ctx := NewContext()
+		res, err := ctx.EvalString(tt.input)
+		if err != nil {
+			t.Errorf("input %q: unexpected error: %v", tt.input, err)
+			continue
+		}
+		if res.Value.String() != tt.expected {
+			t.Errorf("input %q: expected %s, got %s", tt.input, tt.expected, res.Value.String())
+		}
+	}
+}
+
+func TestComplex(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"1i", "0+1i"},
+		{"1i^2", "-1+0i"},
+		{"(1+2i) * (3-1i)", "5+5i"},
+		{"(1+2i) / 1i", "2-1i"},
+		{"-(1+2i)", "-1-2i"},
+		{"c(1, 2i, NA)", "1+0i 0+2i NA"},
+		{"c(1L, 2i)", "1+0i 0+2i"},
+		{"c(1i, \"a\")", `"0+1i" "a"`},
+		{"Re(3+4i)", "3"},
+		{"Im(3+4i)", "4"},
+		{"Mod(3+4i)", "5"},
+		{"abs(-3+4i)", "5"},
+		{"Arg(1i) == pi / 2", "TRUE"},
+		{"Conj(3+4i)", "3-4i"},
+		{"sqrt(as.complex(-4))", "0+2i"},
+		{"as.complex(\"1-2.5i\")", "1-2.5i"},
+		{"complex(real = 1, imaginary = c(2, 3))", "1+2i 1+3i"},
+		{"complex(modulus = 2, argument = 0)", "2+0i"},
+		{"complex(2)", "0+0i 0+0i"},
+		{"is.complex(1i)", "TRUE"},
+		{"is.complex(1)", "FALSE"},
+		{"is.numeric(1i)", "FALSE"},
+		{"1i == 1i", "TRUE"},
+		{"c(1i, 2) != 2", "TRUE FALSE"},
+		{"x <- c(1, 2); x[2] <- 1i; x", "1+0i 0+1i"},
+		{"z <- c(a = 1i, b = 2); z[[2]]", "2+0i"},
+		{"rev(c(1i, 2))", "2+0i 0+1i"},
+		{"sum(1i, 2)", "2+1i"},
+		{"is.na(c(1i, NA_complex_))", "FALSE TRUE"},
+		{"deparse(c(1+2i, -3i))", `"c(1+2i, 0-3i)"`},
+		{"identical(1i, complex(imaginary = 1))", "TRUE"},
+	}
+
+	for _, tt := range tests {
+		ctx := NewContext()
+		res, err := ctx.EvalString(tt.input)
+		if err != nil {
+			t.Errorf("input %q: unexpected error: %v", tt.input, err)
+			continue
+		}
+		if res.Value.String() != tt.expected {
+			t.Errorf("input %q: expected %s, got %s", tt.input, tt.expected, res.Value.String())
+		}
+	}
+}
+
+func TestComplexErrors(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"1i < 2", "invalid comparison with complex values"},
+		{"1i %% 2", "invalid operation on complex numbers"},
+		{"floor(1i)", "invalid argument to floor(): complex values are not supported"},
+		{"as.complex(\"x\")", "cannot coerce 'x' to complex"},
+	}
+
+	for _, tt := range tests {
+		ctx := NewContext()
+		_, err := ctx.EvalString(tt.input)
+		if err == nil {
+			t.Errorf("input %q: expected error", tt.input)
+			continue
+		}
+		if err.Error() != tt.expected {
+			t.Errorf("input %q: expected %q, got %q", tt.input, tt.expected, err.Error())
+		}
+	}
+}
@@ -0,0 +1,555 @@
+package rt
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"simonwaldherr.de/go/smallr/internal/deparse"
+)
+
+func installConditionBuiltins(env *Env) {
+	builtins := map[string]*BuiltinFunc{
+		"stop":                {FnName: "stop", Impl: builtinStop},
+		"traceback":           {FnName: "traceback", Impl: builtinTraceback},
+		"warning":             {FnName: "warning", Impl: builtinWarning},
+		"message":             {FnName: "message", Impl: builtinMessage},
+		"signalCondition":     {FnName: "signalCondition", Impl: builtinSignalCondition},
+		"simpleCondition":     {FnName: "simpleCondition", Impl: builtinSimpleCondition},
+		"simpleError":         {FnName: "simpleError", Impl: builtinSimpleError},
+		"simpleWarning":       {FnName: "simpleWarning", Impl: builtinSimpleWarning},
+		"simpleMessage":       {FnName: "simpleMessage", Impl: builtinSimpleMessage},
+		"conditionMessage":    {FnName: "conditionMessage", Impl: builtinConditionMessage, Generic: true},
+		"conditionCall":       {FnName: "conditionCall", Impl: builtinConditionCall, Generic: true},
+		"tryCatch":            {FnName: "tryCatch", Impl: builtinTryCatch},
+		"withCallingHandlers": {FnName: "withCallingHandlers", Impl: builtinWithCallingHandlers},
+		"try":                 {FnName: "try", Impl: builtinTry},
+		"invokeRestart":       {FnName: "invokeRestart", Impl: builtinInvokeRestart},
+		"suppressWarnings":    {FnName: "suppressWarnings", Impl: builtinSuppressWarnings},
+		"suppressMessages":    {FnName: "suppressMessages", Impl: builtinSuppressMessages},
+	}
+	for name, fn := range builtins {
+		env.SetLocal(name, fn)
+	}
+}
+
+// handler is an entry on the condition handler stack. Calling handlers
+// (withCallingHandlers) run where the condition is signalled; exiting
+// handlers (tryCatch) unwind to the tryCatch call that established them.
+type handler struct {
+	class   string
+	fn      Callable
+	exiting bool
+}
+
+// ConditionError carries an R error condition raised by stop().
+type ConditionError struct {
+	Cond Value
+}
+
+func (e *ConditionError) Error() string { return conditionMessage(e.Cond) }
+
+// conditionUnwind transfers a condition to the tryCatch whose exiting
+// handler h was selected.
+type conditionUnwind struct {
+	h    *handler
+	cond Value
+}
+
+func (u *conditionUnwind) Error() string { return conditionMessage(u.cond) }
+
+// restartInvoked is returned by invokeRestart and travels up to the code
+// that established the restart.
+type restartInvoked struct {
+	name string
+}
+
+func (r *restartInvoked) Error() string { return fmt.Sprintf("no 'restart' '%s' found", r.name) }
+
+// makeCondition builds a condition object: a list with message and call
+// and the given class vector.
+func makeCondition(msg string, call Value, classes ...string) *ListVec {
+	if call == nil {
+		call = NullValue
+	}
+	cond := &ListVec{Data: []Value{CharScalar(msg), call}}
+	cond.SetAttr("names", charVecOf([]string{"message", "call"}))
+	cond.SetAttr("class", charVecOf(classes))
+	return cond
+}
+
+func isCondition(v Value) bool { return hasClass(v, "condition") }
+
+func conditionMessage(cond Value) string {
+	if l, ok := cond.(*ListVec); ok {
+		if names, ok := listNames(l); ok {
+			for i, n := range names {
+				if n == "message" && i < len(l.Data) {
+					return strings.Join(toPlainStrings(l.Data[i]), "")
+				}
+			}
+		}
+	}
+	return ""
+}
+
+func conditionCallOf(cond Value) Value {
+	if l, ok := cond.(*ListVec); ok {
+		if names, ok := listNames(l); ok {
+			for i, n := range names {
+				if n == "call" && i < len(l.Data) {
+					return l.Data[i]
+				}
+			}
+		}
+	}
+	return NullValue
+}
+
+// errorCondition returns the R condition an error represents. Control flow
+// (break, return, unwinding to a handler, restarts) is not a condition.
+func errorCondition(err error) (Value, bool) {
+	var ce *ConditionError
+	if errors.As(err, &ce) {
+		return ce.Cond, true
+	}
+	var ctrl *ControlError
+	var unwind *conditionUnwind
+	var restart *restartInvoked
+	if errors.As(err, &ctrl) || errors.As(err, &unwind) || errors.As(err, &restart) || errors.Is(err, ErrInterrupted) {
+		return nil, false
+	}
+	var re *RuntimeError
+	if errors.As(err, &re) && re.Call != nil {
+		return makeCondition(re.Msg, &ExprValue{Expr: re.Call}, "simpleError", "error", "condition"), true
+	}
+	return makeCondition(err.Error(), nil, "simpleError", "error", "condition"), true
+}
+
+// currentCall returns the call of the closure the running builtin was
+// called from, for use as the call of a condition.
+func (ctx *Context) currentCall() Value {
+	if fr := ctx.frameFor(ctx.callingEnv()); fr != nil && fr.Call != nil {
+		return &ExprValue{Expr: fr.Call}
+	}
+	return NullValue
+}
+
+// signal offers cond to the established handlers, innermost first.
+// Calling handlers run with the handler stack below them; an exiting
+// handler ends the search and unwinds to its tryCatch.
+func (ctx *Context) signal(cond Value) error {
+	classes := classAttr(cond)
+	for i := len(ctx.handlers) - 1; i >= 0; i-- {
+		h := ctx.handlers[i]
+		if !containsString(classes, h.class) {
+			continue
+		}
+		if h.exiting {
+			return &conditionUnwind{h: h, cond: cond}
+		}
+		saved := ctx.handlers
+		ctx.handlers = saved[:i:i]
+		_, err := h.fn.Call(ctx, nil, []ArgValue{{Val: cond}})
+		ctx.handlers = saved
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// withRestart runs fn with the named restart available. It reports
+// whether the restart was invoked.
+func (ctx *Context) withRestart(name string, fn func() error) (bool, error) {
+	ctx.restarts = append(ctx.restarts, name)
+	err := fn()
+	ctx.restarts = ctx.restarts[:len(ctx.restarts)-1]
+	var r *restartInvoked
+	if errors.As(err, &r) && r.name == name {
+		return true, nil
+	}
+	return false, err
+}
+
+// warn signals a warning from Go code. Unless a handler muffles it, the
+// warning is printed.
+func (ctx *Context) warn(msg string) error {
+	return ctx.signalWarning(makeCondition(msg, ctx.currentCall(), "simpleWarning", "warning", "condition"))
+}
+
+func (ctx *Context) signalWarning(cond Value) error {
+	muffled, err := ctx.withRestart("muffleWarning", func() error { return ctx.signal(cond) })
+	if err != nil || muffled {
+		return err
+	}
+	ctx.Println("Warning:", conditionMessage(cond))
+	return nil
+}
+
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// conditionArgs builds the message of stop(), warning() and message() by
+// pasting the unnamed arguments together. A single condition argument is
+// returned as is.
+func conditionArgs(ctx *Context, args []ArgValue) (string, Value, bool, error) {
+	fargs, err := forceArgs(ctx, args)
+	if err != nil {
+		return "", nil, false, err
+	}
+	withCall := true
+	var parts []string
+	for _, a := range fargs {
+		switch a.Name {
+		case "call.":
+			if withCall, err = logicalArg(ctx, a.Val, true); err != nil {
+				return "", nil, false, err
+			}
+			continue
+		case "immediate.", "domain", "appendLF":
+			continue
+		}
+		if isCondition(a.Val) && len(fargs) == 1 {
+			return "", a.Val, withCall, nil
+		}
+		parts = append(parts, toPlainStrings(a.Val)...)
+	}
+	return strings.Join(parts, ""), nil, withCall, nil
+}
+
+func builtinStop(ctx *Context, args []ArgValue) (Value, error) {
+	msg, cond, withCall, err := conditionArgs(ctx, args)
+	if err != nil {
+		return nil, err
+	}
+	if cond == nil {
+		var call Value = NullValue
+		if withCall {
+			call = ctx.currentCall()
+		}
+		cond = makeCondition(msg, call, "simpleError", "error", "condition")
+	}
+	if err := ctx.signal(cond); err != nil {
+		return nil, err
+	}
+	return nil, &ConditionError{Cond: cond}
+}
+
+func builtinWarning(ctx *Context, args []ArgValue) (Value, error) {
+	msg, cond, withCall, err := conditionArgs(ctx, args)
+	if err != nil {
+		return nil, err
+	}
+	if cond == nil {
+		var call Value = NullValue
+		if withCall {
+			call = ctx.currentCall()
+		}
+		cond = makeCondition(msg, call, "simpleWarning", "warning", "condition")
+	}
+	if err := ctx.signalWarning(cond); err != nil {
+		return nil, err
+	}
+	return CharScalar(conditionMessage(cond)), nil
+}
+
+func builtinMessage(ctx *Context, args []ArgValue) (Value, error) {
+	msg, cond, _, err := conditionArgs(ctx, args)
+	if err != nil {
+		return nil, err
+	}
+	if cond == nil {
+		cond = makeCondition(msg+"\n", ctx.currentCall(), "simpleMessage", "message", "condition")
+	}
+	muffled, err := ctx.withRestart("muffleMessage", func() error { return ctx.signal(cond) })
+	if err != nil {
+		return nil, err
+	}
+	if !muffled {
+		fmt.Fprint(ctx.Output, conditionMessage(cond))
+	}
+	return NullValue, nil
+}
+
+func builtinSignalCondition(ctx *Context, args []ArgValue) (Value, error) {
+	fargs, err := forceArgs(ctx, args)
+	if err != nil {
+		return nil, err
+	}
+	m, _ := matchArgs(fargs, "cond", "message", "call")
+	if m[0] == nil {
+		return nil, fmt.Errorf("signalCondition(cond) expects 1 argument")
+	}
+	if err := ctx.signal(m[0]); err != nil {
+		return nil, err
+	}
+	return NullValue, nil
+}
+
+func newConditionBuiltin(classes ...string) func(ctx *Context, args []ArgValue) (Value, error) {
+	return func(ctx *Context, args []ArgValue) (Value, error) {
+		fargs, err := forceArgs(ctx, args)
+		if err != nil {
+			return nil, err
+		}
+		m, _ := matchArgs(fargs, "message", "call")
+		if m[0] == nil {
+			return nil, fmt.Errorf("argument \"message\" is missing, with no default")
+		}
+		return makeCondition(strings.Join(toPlainStrings(m[0]), ""), m[1], classes...), nil
+	}
+}
+
+func builtinSimpleCondition(ctx *Context, args []ArgValue) (Value, error) {
+	return newConditionBuiltin("simpleCondition", "condition")(ctx, args)
+}
+
+func builtinSimpleError(ctx *Context, args []ArgValue) (Value, error) {
+	return newConditionBuiltin("simpleError", "error", "condition")(ctx, args)
+}
+
+func builtinSimpleWarning(ctx *Context, args []ArgValue) (Value, error) {
+	return newConditionBuiltin("simpleWarning", "warning", "condition")(ctx, args)
+}
+
+func builtinSimpleMessage(ctx *Context, args []ArgValue) (Value, error) {
+	return newConditionBuiltin("simpleMessage", "message", "condition")(ctx, args)
+}
+
+func builtinConditionMessage(ctx *Context, args []ArgValue) (Value, error) {
+	if len(args) != 1 {
+		return nil, fmt.Errorf("conditionMessage(c) expects 1 argument")
+	}
+	v, err := Force(ctx, args[0].Val)
+	if err != nil {
+		return nil, err
+	}
+	return CharScalar(conditionMessage(v)), nil
+}
+
+func builtinConditionCall(ctx *Context, args []ArgValue) (Value, error) {
+	if len(args) != 1 {
+		return nil, fmt.Errorf("conditionCall(c) expects 1 argument")
+	}
+	v, err := Force(ctx, args[0].Val)
+	if err != nil {
+		return nil, err
+	}
+	return conditionCallOf(v), nil
+}
+
+// pushHandlers establishes the named handler arguments of tryCatch and
+// withCallingHandlers. They are pushed innermost-last in reverse order so
+// that the first matching handler in argument order wins.
+func (ctx *Context) pushHandlers(args []ArgValue, exiting bool) ([]*handler, error) {
+	var hs []*handler
+	for _, a := range args {
+		v, err := Force(ctx, a.Val)
+		if err != nil {
+			return nil, err
+		}
+		fn, ok := v.(Callable)
+		if !ok {
+			return nil, fmt.Errorf("handler for '%s' is not a function", a.Name)
+		}
+		hs = append(hs, &handler{class: a.Name, fn: fn, exiting: exiting})
+	}
+	for i := len(hs) - 1; i >= 0; i-- {
+		ctx.handlers = append(ctx.handlers, hs[i])
+	}
+	return hs, nil
+}
+
+// builtinTryCatch implements tryCatch(expr, ..., finally). Handlers are
+// named by condition class; the one selected receives the condition and
+// its value becomes the value of tryCatch.
+func builtinTryCatch(ctx *Context, args []ArgValue) (Value, error) {
+	m, rest := matchArgs(args, "expr", "...", "finally")
+	var named []ArgValue
+	for _, a := range rest {
+		if a.Name != "" {
+			named = append(named, a)
+		}
+	}
+	if m[2] != nil {
+		defer func() {
+			// errors in finally are not reported over the original result
+			_, _ = Force(ctx, m[2])
+		}()
+	}
+
+	base := len(ctx.handlers)
+	hs, err := ctx.pushHandlers(named, true)
+	if err != nil {
+		ctx.handlers = ctx.handlers[:base]
+		return nil, err
+	}
+	var result Value = NullValue
+	if m[0] != nil {
+		result, err = Force(ctx, m[0])
+	}
+	ctx.handlers = ctx.handlers[:base]
+	if err == nil {
+		return result, nil
+	}
+
+	var unwind *conditionUnwind
+	if errors.As(err, &unwind) {
+		for _, h := range hs {
+			if h == unwind.h {
+				return h.fn.Call(ctx, nil, []ArgValue{{Val: unwind.cond}})
+			}
+		}
+		return nil, err
+	}
+	// Errors raised by Go code were not signalled when they occurred;
+	// offer them to this tryCatch as they pass.
+	var ce *ConditionError
+	if cond, ok := errorCondition(err); ok && !errors.As(err, &ce) {
+		for _, h := range hs {
+			if containsString(classAttr(cond), h.class) {
+				return h.fn.Call(ctx, nil, []ArgValue{{Val: cond}})
+			}
+		}
+	}
+	return nil, err
+}
+
+// builtinWithCallingHandlers evaluates expr with calling handlers
+// established. Handlers run where the condition is signalled and, unless
+// they invoke a restart or unwind, the computation continues.
+func builtinWithCallingHandlers(ctx *Context, args []ArgValue) (Value, error) {
+	m, rest := matchArgs(args, "expr", "...")
+	var named []ArgValue
+	for _, a := range rest {
+		if a.Name != "" {
+			named = append(named, a)
+		}
+	}
+	base := len(ctx.handlers)
+	hs, err := ctx.pushHandlers(named, false)
+	if err != nil {
+		ctx.handlers = ctx.handlers[:base]
+		return nil, err
+	}
+	var result Value = NullValue
+	if m[0] != nil {
+		result, err = Force(ctx, m[0])
+	}
+	ctx.handlers = ctx.handlers[:base]
+	if err == nil {
+		return result, nil
+	}
+	var ce *ConditionError
+	if cond, ok := errorCondition(err); ok && !errors.As(err, &ce) {
+		for _, h := range hs {
+			if containsString(classAttr(cond), h.class) {
+				if _, herr := h.fn.Call(ctx, nil, []ArgValue{{Val: cond}}); herr != nil {
+					return nil, herr
+				}
+			}
+		}
+	}
+	return nil, err
+}
+
+// builtinTry evaluates expr and returns an object of class "try-error"
+// instead of failing. The error message is printed unless silent = TRUE.
+func builtinTry(ctx *Context, args []ArgValue) (Value, error) {
+	m, _ := matchArgs(args, "expr", "silent", "outFile")
+	silent := false
+	if m[1] != nil {
+		v, err := Force(ctx, m[1])
+		if err != nil {
+			return nil, err
+		}
+		if silent, err = logicalArg(ctx, v, false); err != nil {
+			return nil, err
+		}
+	}
+	if m[0] == nil {
+		return NullValue, nil
+	}
+	result, err := Force(ctx, m[0])
+	if err == nil {
+		return result, nil
+	}
+	cond, ok := errorCondition(err)
+	if !ok {
+		return nil, err
+	}
+	msg := "Error : " + conditionMessage(cond) + "\n"
+	if call, ok := conditionCallOf(cond).(*ExprValue); ok {
+		msg = "Error in " + deparse.Expr(call.Expr) + " : " + conditionMessage(cond) + "\n"
+	}
+	if !silent {
+		fmt.Fprint(ctx.Output, msg)
+	}
+	out := CharScalar(msg)
+	out.SetAttr("class", CharScalar("try-error"))
+	out.SetAttr("condition", cond)
+	return out, nil
+}
+
+func builtinInvokeRestart(ctx *Context, args []ArgValue) (Value, error) {
+	fargs, err := forceArgs(ctx, args)
+	if err != nil {
+		return nil, err
+	}
+	m, _ := matchArgs(fargs, "r")
+	cv, ok := m[0].(*CharVec)
+	if !ok || cv.Len() != 1 || cv.Data[0].NA {
+		return nil, fmt.Errorf("invokeRestart: restart must be a character string")
+	}
+	name := cv.Data[0].Val
+	if !containsString(ctx.restarts, name) {
+		return nil, fmt.Errorf("no 'restart' '%s' found", name)
+	}
+	return nil, &restartInvoked{name: name}
+}
+
+// suppressWith evaluates expr with a calling handler that muffles
+// conditions of the given class.
+func suppressWith(ctx *Context, args []ArgValue, class, restart string) (Value, error) {
+	m, _ := matchArgs(args, "expr", "classes")
+	if m[0] == nil {
+		return NullValue, nil
+	}
+	muffle := &BuiltinFunc{FnName: restart, Impl: func(ctx *Context, args []ArgValue) (Value, error) {
+		return nil, &restartInvoked{name: restart}
+	}}
+	ctx.handlers = append(ctx.handlers, &handler{class: class, fn: muffle})
+	base := len(ctx.handlers) - 1
+	v, err := Force(ctx, m[0])
+	ctx.handlers = ctx.handlers[:base]
+	return v, err
+}
+
+func builtinSuppressWarnings(ctx *Context, args []ArgValue) (Value, error) {
+	return suppressWith(ctx, args, "warning", "muffleWarning")
+}
+
+func builtinSuppressMessages(ctx *Context, args []ArgValue) (Value, error) {
+	return suppressWith(ctx, args, "message", "muffleMessage")
+}
+
+// formatCondition renders a condition the way print does in R, e.g.
+// <simpleError in f(x): boom>.
+func formatCondition(v Value) string {
+	cls := "condition"
+	if c := classAttr(v); len(c) > 0 {
+		cls = c[0]
+	}
+	msg := strings.TrimSuffix(conditionMessage(v), "\n")
+	if call, ok := conditionCallOf(v).(*ExprValue); ok && call.Expr != nil {
+		return fmt.Sprintf("<%s in %s: %s>", cls, deparse.Expr(call.Expr), msg)
+	}
+	return fmt.Sprintf("<%s: %s>", cls, msg)
+}
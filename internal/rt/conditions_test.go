@@ -0,0 +1,110 @@
+package rt
+
+import "testing"
+
+func TestConditions(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`tryCatch(stop("boom"), error = function(e) conditionMessage(e))`, `"boom"`},
+		{`tryCatch(stop("a", 1, "b"), error = function(e) conditionMessage(e))`, `"a1b"`},
+		{`f <- function(x) stop("bad"); e <- tryCatch(f(1), error = function(e) e); class(e)`, `"simpleError" "error" "condition"`},
+		{`f <- function(x) stop("bad", call. = FALSE); e <- tryCatch(f(1), error = function(e) e); is.null(conditionCall(e))`, "TRUE"},
+		{`tryCatch(log("a"), error = function(e) "internal")`, `"internal"`},
+		{`tryCatch(warning("w"), warning = function(w) class(w))`, `"simpleWarning" "warning" "condition"`},
+		{`tryCatch(message("m"), message = function(m) conditionMessage(m))`, `"m\n"`},
+		{`tryCatch(stop("e"), condition = function(c) "any")`, `"any"`},
+		{`tryCatch(stop("e"), warning = function(w) "w", error = function(e) "e")`, `"e"`},
+		{`tryCatch(tryCatch(stop("e"), warning = function(w) "inner"), error = function(e) "outer")`, `"outer"`},
+		{`tryCatch(42, error = function(e) 0)`, "42"},
+		{`x <- 0; tryCatch(1, finally = x <- 5); x`, "5"},
+		{`x <- 0; try(tryCatch(stop("e"), finally = x <- 5), silent = TRUE); x`, "5"},
+		// calling handlers
+		{`n <- 0; withCallingHandlers({ warning("a"); warning("b"); "done" }, warning = function(w) { n <<- n + 1; invokeRestart("muffleWarning") }); n`, "2"},
+		{`f <- function() { warning("w"); "cont" }; withCallingHandlers(f(), warning = function(w) invokeRestart("muffleWarning"))`, `"cont"`},
+		{`msgs <- c(); withCallingHandlers({ message("x"); message("y") }, message = function(m) { msgs <<- c(msgs, conditionMessage(m)); invokeRestart("muffleMessage") }); msgs`, `"x\n" "y\n"`},
+		{`tryCatch(withCallingHandlers(stop("e"), error = function(e) cat("")), error = function(e) "unwound")`, `"unwound"`},
+		{`seen <- ""; tryCatch(withCallingHandlers(log("a"), error = function(e) seen <<- "calling"), error = function(e) seen)`, `"calling"`},
+		// custom conditions
+		{`cond <- structure(class = c("myCondition", "condition"), list(message = "hi", call = NULL)); tryCatch(signalCondition(cond), myCondition = function(c) conditionMessage(c))`, `"hi"`},
+		{`cond <- simpleCondition("m"); class(cond) <- c("custom", "condition"); signalCondition(cond)`, "NULL"},
+		{`e <- simpleError("custom error"); tryCatch(stop(e), error = function(x) conditionMessage(x))`, `"custom error"`},
+		{`w <- simpleWarning("sw"); tryCatch(warning(w), warning = function(x) conditionMessage(x))`, `"sw"`},
+		// try and suppress
+		{`r <- try(stop("oops"), silent = TRUE); class(r)`, `"try-error"`},
+		{`r <- try(stop("oops"), silent = TRUE); conditionMessage(attr(r, "condition"))`, `"oops"`},
+		{`try(1 + 1)`, "2"},
+		{`suppressWarnings({ warning("x"); 7 })`, "7"},
+		{`suppressMessages({ message("x"); 8 })`, "8"},
+	}
+
+	for _, tt := range tests {
+		ctx := NewContext()
+		res, err := ctx.EvalString(tt.input)
+		if err != nil {
+			t.Errorf("input %q: unexpected error: %v", tt.input, err)
+			continue
+		}
+		if res.Value.String() != tt.expected {
+			t.Errorf("input %q: expected %s, got %s", tt.input, tt.expected, res.Value.String())
+		}
+	}
+}
+
+func TestConditionOutput(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`warning("careful"); 1`, "Warning: careful\n"},
+		{`suppressWarnings(warning("careful")); 1`, ""},
+		{`message("hello")`, "hello\n"},
+		{`suppressMessages(message("hello"))`, ""},
+		{`withCallingHandlers(message("hi"), message = function(m) cat("got", conditionMessage(m)))`, "got hi\nhi\n"},
+		{`f <- function(x) stop("bad"); try(f(1))`, "Error in f(1) : bad\n"},
+		{`try(stop("bad", call. = FALSE))`, "Error : bad\n"},
+		{`try(stop("bad"), silent = TRUE)`, ""},
+		{`f <- function(x) stop("bad"); print(tryCatch(f(1), error = function(e) e))`, "<simpleError in f(1): bad>\n"},
+		{`print(simpleCondition("plain"))`, "<simpleCondition: plain>\n"},
+		{`tryCatch(stop("e"), error = function(e) cat("handler\n"), finally = cat("finally\n"))`, "handler\nfinally\n"},
+		{`rm(nothere)`, "Warning: object 'nothere' not found\n"},
+	}
+
+	for _, tt := range tests {
+		ctx := NewContext()
+		res, err := ctx.EvalString(tt.input)
+		if err != nil {
+			t.Errorf("input %q: unexpected error: %v", tt.input, err)
+			continue
+		}
+		if res.Output != tt.expected {
+			t.Errorf("input %q: expected output %q, got %q", tt.input, tt.expected, res.Output)
+		}
+	}
+}
+
+func TestConditionErrors(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`stop("plain")`, "plain"},
+		{`invokeRestart("muffleWarning")`, "no 'restart' 'muffleWarning' found"},
+		{`tryCatch(stop("e"), warning = function(w) "w")`, "e"},
+		{`withCallingHandlers(stop("still"), error = function(e) NULL)`, "still"},
+		{`tryCatch(stop("e"), error = function(e) stop("rethrown"))`, "rethrown"},
+	}
+
+	for _, tt := range tests {
+		ctx := NewContext()
+		_, err := ctx.EvalString(tt.input)
+		if err == nil {
+			t.Errorf("input %q: expected error", tt.input)
+			continue
+		}
+		if err.Error() != tt.expected {
+			t.Errorf("input %q: expected error %q, got %q", tt.input, tt.expected, err.Error())
+		}
+	}
+}
@@ -2,16 +2,78 @@ package rt
 
 import (
 	"bytes"
+	"context"
+	"errors"
 	"fmt"
 	"io"
 	"os"
+	"strings"
 
+	"simonwaldherr.de/go/smallr/internal/ast"
 	"simonwaldherr.de/go/smallr/internal/parser"
 )
 
 type Context struct {
 	Global *Env
 	Output io.Writer
+
+	frames     []*Frame         // active closure calls, innermost last
+	builtinEnv *Env             // environment the running builtin was called from
+	builtins   map[string]Value // bindings installed by InstallBuiltins
+	handlers   []*handler       // established condition handlers, innermost last
+	restarts   []string         // names of the available restarts
+	lastError  *RuntimeError    // last error that reached the top level
+	random     *rngState        // random number generator, seeded on first use
+	interrupt  context.Context  // stops evaluation when done, nil if there is none
+	invisible  bool             // the last value came from invisible() and is not printed
+}
+
+// Frame describes one active closure call.
+type Frame struct {
+	Fn     *ClosureFunc
+	Call   *ast.CallExpr // call expression, nil when called from a builtin
+	Args   []ArgValue    // actual arguments as supplied by the caller
+	Env    *Env          // evaluation environment of the call
+	Caller *Env          // environment the call was made from (may be nil)
+	OnExit []ast.Expr    // expressions registered with on.exit()
+
+	// Dispatch state, set when the call was entered through S3 dispatch.
+	Generic  string
+	Group    string       // group generic ("Ops", "Math", "Summary"), if any
+	Method   []string     // .Method of an Ops dispatch, one entry per operand
+	Classes  []string     // remaining dispatch classes, matched class first
+	Object   Value        // object dispatched on
+	Internal *BuiltinFunc // builtin default reached by NextMethod, if any
+}
+
+func (ctx *Context) pushFrame(fr *Frame) { ctx.frames = append(ctx.frames, fr) }
+
+func (ctx *Context) popFrame() { ctx.frames = ctx.frames[:len(ctx.frames)-1] }
+
+// frameFor returns the innermost frame evaluating in env, or nil.
+func (ctx *Context) frameFor(env *Env) *Frame {
+	for i := len(ctx.frames) - 1; i >= 0; i-- {
+		if ctx.frames[i].Env == env {
+			return ctx.frames[i]
+		}
+	}
+	return nil
+}
+
+// callingEnv returns the environment the running builtin was called from.
+func (ctx *Context) callingEnv() *Env { return ctx.callerEnv(ctx.builtinEnv) }
+
+// callerEnv returns the environment a builtin was called from. Builtins
+// that call back into R code pass a nil caller; they run inside the
+// innermost closure frame, or at top level.
+func (ctx *Context) callerEnv(caller *Env) *Env {
+	if caller != nil {
+		return caller
+	}
+	if n := len(ctx.frames); n > 0 {
+		return ctx.frames[n-1].Env
+	}
+	return ctx.Global
 }
 
 func NewContext() *Context {
@@ -19,7 +81,12 @@ func NewContext() *Context {
 		Global: NewEnv(nil),
 		Output: os.Stdout,
 	}
+	ctx.Global.name = "R_GlobalEnv"
 	InstallBuiltins(ctx.Global)
+	ctx.builtins = make(map[string]Value, len(ctx.Global.vars))
+	for name, v := range ctx.Global.vars {
+		ctx.builtins[name] = v
+	}
 	return ctx
 }
 
@@ -30,39 +97,92 @@ func NewContextWithOutput(w io.Writer) *Context {
 }
 
 type EvalResult struct {
-	Value  Value
-	Output string
+	Value     Value
+	Output    string
+	Error     *RuntimeError // set when evaluation failed with an R error
+	Invisible bool          // Value was returned invisibly and is not auto-printed
 }
 
 func (ctx *Context) EvalString(src string) (EvalResult, error) {
-	var buf bytes.Buffer
-	// tee output: simple approach
-	out := ctx.Output
-	ctx.Output = &buf
-	defer func() { ctx.Output = out }()
+	return ctx.EvalScript("", src)
+}
 
+// EvalScript evaluates src like EvalString. Runtime errors name the
+// script in their position.
+func (ctx *Context) EvalScript(name, src string) (EvalResult, error) {
 	p := parser.New(src)
 	prog, err := p.ParseProgram()
 	if err != nil {
 		return EvalResult{}, err
 	}
-	env := ctx.Global
+	return ctx.run(name, ctx.Global, prog.Exprs)
+}
+
+// run evaluates the top-level expressions of the script name in env,
+// capturing the output.
+func (ctx *Context) run(name string, env *Env, exprs []ast.Expr) (EvalResult, error) {
+	var buf bytes.Buffer
+	// tee output: simple approach
+	out := ctx.Output
+	ctx.Output = &buf
+	defer func() { ctx.Output = out }()
+
 	var last Value = NullValue
-	for _, e := range prog.Exprs {
+	for _, e := range exprs {
+		if err := ctx.interrupted(); err != nil {
+			return EvalResult{Value: last, Output: buf.String()}, err
+		}
 		v, err := Eval(ctx, env, e)
 		if err != nil {
-			return EvalResult{Value: last, Output: buf.String()}, err
+			res := EvalResult{Value: last, Output: buf.String()}
+			if errors.As(err, &res.Error) {
+				res.Error.Script = name
+				ctx.lastError = res.Error
+			}
+			return res, err
 		}
 		last = v
 	}
-	return EvalResult{Value: last, Output: buf.String()}, nil
+	return EvalResult{Value: last, Output: buf.String(), Invisible: ctx.invisible}, nil
 }
 
 func (ctx *Context) SprintValue(v Value) string {
 	if v == nil {
 		return "<nil>"
 	}
-	return v.String()
+	if classAttr(v) != nil {
+		if s, ok := ctx.sprintMethod(v); ok {
+			return s
+		}
+	}
+	return formatValueForPrint(v)
+}
+
+// sprintMethod renders v through a user-defined print method, capturing
+// what the method writes.
+func (ctx *Context) sprintMethod(v Value) (string, bool) {
+	fn, classes, ok := findMethod(ctx, ctx.Global, "print", "", classAttr(v))
+	if !ok {
+		return "", false
+	}
+	if _, builtin := fn.(*BuiltinFunc); builtin {
+		return "", false
+	}
+	var buf bytes.Buffer
+	out := ctx.Output
+	ctx.Output = &buf
+	defer func() { ctx.Output = out }()
+	if _, err := callMethod(ctx, fn, &Frame{
+		Args:     []ArgValue{{Val: v}},
+		Caller:   ctx.Global,
+		Generic:  "print",
+		Classes:  classes,
+		Object:   v,
+		Internal: internalGeneric(ctx, "print"),
+	}); err != nil {
+		return "", false
+	}
+	return strings.TrimSuffix(buf.String(), "\n"), true
 }
 
 func (ctx *Context) Println(v ...any) {
@@ -0,0 +1,665 @@
+package rt
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"sort"
+	"time"
+)
+
+// Conversion between Go values and R values for programs embedding the
+// interpreter. Go scalars become vectors of length one, slices and arrays
+// vectors (or lists if their elements are not scalars of one type), maps
+// with string keys named lists and time.Time POSIXct date-times. Go
+// integers become R integers, or doubles if one of them is outside R's
+// 32-bit range. nil pointers and nil interface elements stand for NA.
+// Structs are handled in marshal.go.
+
+var (
+	valueType = reflect.TypeFor[Value]()
+	timeType  = reflect.TypeFor[time.Time]()
+)
+
+// atomic kinds a Go scalar converts to, in R's coercion order
+const (
+	kindNone = iota
+	kindLogical
+	kindInteger
+	kindDouble
+	kindComplex
+	kindCharacter
+	kindTime
+)
+
+// ToValue converts a Go value to an R value: bool, the integer, float,
+// complex and string types, time.Time, the sql.Null types, structs, and
+// slices, arrays, pointers and string-keyed maps of them. A slice of
+// structs becomes a data frame. A Value is returned as is and nil is NULL.
+func ToValue(x any) (Value, error) {
+	if x == nil {
+		return NullValue, nil
+	}
+	if v, ok := x.(Value); ok {
+		return v, nil
+	}
+	return toValue(reflect.ValueOf(x))
+}
+
+func toValue(rv reflect.Value) (Value, error) {
+	if !rv.IsValid() {
+		return NullValue, nil
+	}
+	t := rv.Type()
+	if t.Implements(valueType) {
+		if (t.Kind() == reflect.Pointer || t.Kind() == reflect.Interface) && rv.IsNil() {
+			return NullValue, nil
+		}
+		return rv.Interface().(Value), nil
+	}
+	if k := typeKind(t); k != kindNone {
+		return atomicVector(k, []reflect.Value{rv})
+	}
+	switch t.Kind() {
+	case reflect.Interface:
+		if rv.IsNil() {
+			return NullValue, nil
+		}
+		return toValue(rv.Elem())
+	case reflect.Pointer:
+		if rv.IsNil() {
+			return NullValue, nil
+		}
+		return toValue(rv.Elem())
+	case reflect.Slice, reflect.Array:
+		elems := make([]reflect.Value, rv.Len())
+		for i := range elems {
+			elems[i] = rv.Index(i)
+		}
+		if k := typeKind(t.Elem()); k != kindNone {
+			return atomicVector(k, elems)
+		}
+		if st, ok := structType(t.Elem()); ok {
+			return structFrame(st, elems)
+		}
+		if k := commonKind(elems); k != kindNone {
+			return atomicVector(k, elems)
+		}
+		out := &ListVec{Data: make([]Value, len(elems))}
+		for i, e := range elems {
+			v, err := toValue(e)
+			if err != nil {
+				return nil, err
+			}
+			out.Data[i] = v
+		}
+		return out, nil
+	case reflect.Map:
+		if t.Key().Kind() != reflect.String {
+			return nil, fmt.Errorf("cannot convert %s to an R value: map keys must be strings", t)
+		}
+		keys := rv.MapKeys()
+		sort.Slice(keys, func(i, j int) bool { return keys[i].String() < keys[j].String() })
+		out := &ListVec{Data: make([]Value, len(keys))}
+		names := make([]string, len(keys))
+		for i, k := range keys {
+			v, err := toValue(rv.MapIndex(k))
+			if err != nil {
+				return nil, err
+			}
+			names[i] = k.String()
+			out.Data[i] = v
+		}
+		out.SetAttr("names", charVecOf(names))
+		return out, nil
+	case reflect.Struct:
+		return structList(rv)
+	}
+	return nil, fmt.Errorf("cannot convert %s to an R value", t)
+}
+
+// typeKind returns the atomic kind of the Go type t, looking through a
+// pointer, or kindNone if t is not a scalar type.
+func typeKind(t reflect.Type) int {
+	if t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	if t == timeType {
+		return kindTime
+	}
+	if sqlNull(t) {
+		return typeKind(t.Field(0).Type)
+	}
+	switch t.Kind() {
+	case reflect.Bool:
+		return kindLogical
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return kindInteger
+	case reflect.Float32, reflect.Float64:
+		return kindDouble
+	case reflect.Complex64, reflect.Complex128:
+		return kindComplex
+	case reflect.String:
+		return kindCharacter
+	}
+	return kindNone
+}
+
+// commonKind returns the kind of a vector holding the dynamic values
+// elems, such as the elements of a []any, or kindNone if they are not
+// all scalars (or nil) that combine into one vector.
+func commonKind(elems []reflect.Value) int {
+	kind := kindNone
+	for _, e := range elems {
+		for e.Kind() == reflect.Interface && !e.IsNil() {
+			e = e.Elem()
+		}
+		if e.Kind() == reflect.Interface || (e.Kind() == reflect.Pointer && e.IsNil() && typeKind(e.Type()) == kindNone) {
+			continue // nil: NA
+		}
+		k := typeKind(e.Type())
+		switch {
+		case k == kindNone:
+			return kindNone
+		case kind == kindNone:
+			kind = k
+		case k != kind:
+			// logical, integer, double and complex combine as in c()
+			if k >= kindCharacter || kind >= kindCharacter {
+				return kindNone
+			}
+			kind = max(k, kind)
+		}
+	}
+	return kind
+}
+
+// atomicVector builds a vector of the given kind from Go scalars; nil
+// pointers and interfaces, invalid sql.Null values and invalid elems
+// become NA.
+func atomicVector(kind int, elems []reflect.Value) (Value, error) {
+	scalars := make([]reflect.Value, len(elems))
+	for i, e := range elems {
+		for (e.Kind() == reflect.Interface || e.Kind() == reflect.Pointer) && !e.IsNil() {
+			e = e.Elem()
+		}
+		if e.IsValid() && sqlNull(e.Type()) {
+			e = nullScalar(e)
+		}
+		if e.Kind() != reflect.Interface && e.Kind() != reflect.Pointer {
+			scalars[i] = e
+		}
+	}
+	if kind == kindInteger && !fitInteger(scalars) {
+		kind = kindDouble
+	}
+	switch kind {
+	case kindLogical:
+		out := make([]LogicalElem, len(scalars))
+		for i, s := range scalars {
+			if out[i].NA = !s.IsValid(); !out[i].NA {
+				out[i].Val = s.Bool()
+			}
+		}
+		return &LogicalVec{Data: out}, nil
+	case kindInteger:
+		out := make([]IntElem, len(scalars))
+		for i, s := range scalars {
+			switch {
+			case !s.IsValid():
+				out[i].NA = true
+			case s.CanInt():
+				out[i].Val = s.Int()
+			case s.CanUint():
+				out[i].Val = int64(s.Uint())
+			case s.Kind() == reflect.Bool:
+				out[i].Val = boolInt(s.Bool())
+			}
+		}
+		return &IntVec{Data: out}, nil
+	case kindDouble:
+		out := make([]FloatElem, len(scalars))
+		for i, s := range scalars {
+			f, ok := scalarFloat(s)
+			out[i] = FloatElem{Val: f, NA: !ok}
+		}
+		return &DoubleVec{Data: out}, nil
+	case kindComplex:
+		out := make([]ComplexElem, len(scalars))
+		for i, s := range scalars {
+			switch {
+			case !s.IsValid():
+				out[i].NA = true
+			case s.CanComplex():
+				out[i].Val = s.Complex()
+			default:
+				f, _ := scalarFloat(s)
+				out[i].Val = complex(f, 0)
+			}
+		}
+		return &ComplexVec{Data: out}, nil
+	case kindCharacter:
+		out := make([]StringElem, len(scalars))
+		for i, s := range scalars {
+			if out[i].NA = !s.IsValid(); !out[i].NA {
+				out[i].Val = s.String()
+			}
+		}
+		return &CharVec{Data: out}, nil
+	}
+	times := make([]*time.Time, len(scalars))
+	for i, s := range scalars {
+		if s.IsValid() {
+			t := s.Interface().(time.Time)
+			times[i] = &t
+		}
+	}
+	return posixct(times), nil
+}
+
+// fitInteger reports whether the Go integers scalars all fit into an R
+// integer, a 32-bit int without math.MinInt32, which R uses for NA. If
+// one does not, the whole vector becomes double.
+func fitInteger(scalars []reflect.Value) bool {
+	for _, s := range scalars {
+		switch {
+		case !s.IsValid():
+		case s.CanInt():
+			if v := s.Int(); v <= math.MinInt32 || v > math.MaxInt32 {
+				return false
+			}
+		case s.CanUint():
+			if s.Uint() > math.MaxInt32 {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// scalarFloat returns the numeric scalar s as a float64, false if it is
+// invalid (NA).
+func scalarFloat(s reflect.Value) (float64, bool) {
+	switch {
+	case !s.IsValid():
+		return 0, false
+	case s.CanFloat():
+		return s.Float(), true
+	case s.CanInt():
+		return float64(s.Int()), true
+	case s.CanUint():
+		return float64(s.Uint()), true
+	case s.Kind() == reflect.Bool:
+		return float64(boolInt(s.Bool())), true
+	}
+	return 0, false
+}
+
+// posixct builds a POSIXct vector, seconds since the epoch; nil times are
+// NA. The time zone of the first time is kept in the tzone attribute.
+func posixct(times []*time.Time) *DoubleVec {
+	out := make([]FloatElem, len(times))
+	tzone := "UTC"
+	first := true
+	for i, t := range times {
+		if t == nil {
+			out[i].NA = true
+			continue
+		}
+		out[i].Val = float64(t.Unix()) + float64(t.Nanosecond())/1e9
+		if first {
+			first = false
+			switch loc := t.Location(); loc {
+			case time.Local:
+				tzone = ""
+			default:
+				tzone = loc.String()
+			}
+		}
+	}
+	v := &DoubleVec{Data: out}
+	v.SetAttr("class", charVecOf([]string{"POSIXct", "POSIXt"}))
+	v.SetAttr("tzone", CharScalar(tzone))
+	return v
+}
+
+// timeAt returns element i of a POSIXct or Date vector as a time.Time,
+// false if it is NA.
+func timeAt(v Value, i int) (time.Time, bool) {
+	f := toFloats(v)[i]
+	if math.IsNaN(f) || math.IsInf(f, 0) {
+		return time.Time{}, false
+	}
+	if hasClass(v, "Date") {
+		return time.Unix(int64(math.Floor(f))*86400, 0).UTC(), true
+	}
+	sec := math.Floor(f)
+	t := time.Unix(int64(sec), int64(math.Round((f-sec)*1e9)))
+	loc := time.UTC
+	if tz, ok := v.GetAttr("tzone"); ok {
+		if cv, ok := tz.(*CharVec); ok && cv.Len() > 0 && !cv.Data[0].NA {
+			if cv.Data[0].Val == "" {
+				loc = time.Local
+			} else if l, err := time.LoadLocation(cv.Data[0].Val); err == nil {
+				loc = l
+			}
+		}
+	}
+	return t.In(loc), true
+}
+
+func isTimeValue(v Value) bool {
+	return (hasClass(v, "POSIXct") || hasClass(v, "Date")) && isNumericValue(v)
+}
+
+// elemAt returns element i of the atomic vector v as a Go scalar: bool,
+// int64, float64, complex128 or string (the label of a factor level), or
+// time.Time for date-times. The second result is false for NA.
+func elemAt(v Value, i int) (any, bool) {
+	if isTimeValue(v) {
+		t, ok := timeAt(v, i)
+		return t, ok
+	}
+	if isFactor(v) {
+		e := factorLabels(v)[i]
+		return e.Val, !e.NA
+	}
+	switch t := v.(type) {
+	case *LogicalVec:
+		return t.Data[i].Val, !t.Data[i].NA
+	case *IntVec:
+		return t.Data[i].Val, !t.Data[i].NA
+	case *DoubleVec:
+		return t.Data[i].Val, !t.Data[i].NA
+	case *ComplexVec:
+		return t.Data[i].Val, !t.Data[i].NA
+	case *CharVec:
+		return t.Data[i].Val, !t.Data[i].NA
+	}
+	return nil, false
+}
+
+// FromValue converts an R value to a Go value. Vectors of length one
+// become scalars (bool, int, float64, complex128, string or time.Time),
+// longer vectors []any; NA is nil. Factors give their labels. Lists with
+// unique, non-empty names become map[string]any, other lists []any, and
+// NULL is nil. Functions, environments and language objects are returned
+// as the Value itself.
+func FromValue(v Value) any {
+	switch t := v.(type) {
+	case nil, *Null:
+		return nil
+	case *ListVec:
+		if names, ok := listNames(t); ok && uniqueNames(names) {
+			out := make(map[string]any, len(names))
+			for i, n := range names {
+				out[n] = FromValue(t.Data[i])
+			}
+			return out
+		}
+		out := make([]any, len(t.Data))
+		for i, e := range t.Data {
+			out[i] = FromValue(e)
+		}
+		return out
+	}
+	if !isAtomic(v) {
+		return v
+	}
+	if v.Len() == 1 {
+		return goElem(v, 0)
+	}
+	out := make([]any, v.Len())
+	for i := range out {
+		out[i] = goElem(v, i)
+	}
+	return out
+}
+
+// goElem is element i of an atomic vector for FromValue.
+func goElem(v Value, i int) any {
+	x, ok := elemAt(v, i)
+	if !ok {
+		return nil
+	}
+	if n, isInt := x.(int64); isInt {
+		return int(n)
+	}
+	return x
+}
+
+func uniqueNames(names []string) bool {
+	seen := make(map[string]bool, len(names))
+	for _, n := range names {
+		if n == "" || seen[n] {
+			return false
+		}
+		seen[n] = true
+	}
+	return true
+}
+
+// Decode stores the R value v in the Go variable dst points to, converting
+// as FromValue does but to the static type of *dst: vectors decode into
+// slices and arrays element by element, named vectors and lists into
+// string-keyed maps, named lists into structs, data frames into slices of
+// structs row by row, and length-one vectors into scalars. NA decodes into
+// a nil pointer, an invalid sql.Null value, or NaN for floats; into other
+// types it is an error.
+func Decode(v Value, dst any) error {
+	rv := reflect.ValueOf(dst)
+	if rv.Kind() != reflect.Pointer || rv.IsNil() {
+		return fmt.Errorf("cannot decode into %T: need a non-nil pointer", dst)
+	}
+	return decodeValue(v, rv.Elem())
+}
+
+func decodeValue(v Value, dst reflect.Value) error {
+	if v == nil {
+		v = NullValue
+	}
+	t := dst.Type()
+	if t.Kind() == reflect.Interface && t.NumMethod() == 0 {
+		if x := FromValue(v); x != nil {
+			dst.Set(reflect.ValueOf(x))
+		} else {
+			dst.SetZero()
+		}
+		return nil
+	}
+	if reflect.TypeOf(v).AssignableTo(t) {
+		dst.Set(reflect.ValueOf(v))
+		return nil
+	}
+	if v == NullValue {
+		switch t.Kind() {
+		case reflect.Pointer, reflect.Slice, reflect.Map, reflect.Interface:
+			dst.SetZero()
+			return nil
+		}
+		return fmt.Errorf("cannot decode NULL into %s", t)
+	}
+	if typeKind(t) != kindNone {
+		if v.Len() != 1 {
+			return fmt.Errorf("cannot decode %s of length %d into %s", v.Type(), v.Len(), t)
+		}
+		return decodeElem(v, 0, dst)
+	}
+	switch t.Kind() {
+	case reflect.Pointer:
+		p := reflect.New(t.Elem())
+		if err := decodeValue(v, p.Elem()); err != nil {
+			return err
+		}
+		dst.Set(p)
+		return nil
+	case reflect.Slice:
+		if _, ok := structType(t.Elem()); ok && isDataFrame(v) {
+			return decodeRows(v.(*ListVec), dst)
+		}
+		n := v.Len()
+		s := reflect.MakeSlice(t, n, n)
+		for i := 0; i < n; i++ {
+			if err := decodeElem(v, i, s.Index(i)); err != nil {
+				return fmt.Errorf("element %d: %w", i+1, err)
+			}
+		}
+		dst.Set(s)
+		return nil
+	case reflect.Array:
+		if v.Len() != t.Len() {
+			return fmt.Errorf("cannot decode %s of length %d into %s", v.Type(), v.Len(), t)
+		}
+		for i := 0; i < t.Len(); i++ {
+			if err := decodeElem(v, i, dst.Index(i)); err != nil {
+				return fmt.Errorf("element %d: %w", i+1, err)
+			}
+		}
+		return nil
+	case reflect.Map:
+		names := valueNames(v)
+		if t.Key().Kind() != reflect.String || (names == nil && v.Len() > 0) {
+			return fmt.Errorf("cannot decode %s without names into %s", v.Type(), t)
+		}
+		m := reflect.MakeMapWithSize(t, v.Len())
+		for i, name := range names {
+			e := reflect.New(t.Elem()).Elem()
+			if err := decodeElem(v, i, e); err != nil {
+				return fmt.Errorf("element %q: %w", name, err)
+			}
+			m.SetMapIndex(reflect.ValueOf(name).Convert(t.Key()), e)
+		}
+		dst.Set(m)
+		return nil
+	case reflect.Struct:
+		return decodeStruct(v, dst)
+	}
+	return fmt.Errorf("cannot decode %s into %s", v.Type(), t)
+}
+
+// decodeElem decodes element i of the vector or list v into dst.
+func decodeElem(v Value, i int, dst reflect.Value) error {
+	if l, ok := v.(*ListVec); ok {
+		return decodeValue(l.Data[i], dst)
+	}
+	if !isAtomic(v) {
+		return fmt.Errorf("cannot decode %s into %s", v.Type(), dst.Type())
+	}
+	t := dst.Type()
+	if typeKind(t) == kindNone {
+		if t.Kind() == reflect.Interface && t.NumMethod() == 0 {
+			if x := goElem(v, i); x != nil {
+				dst.Set(reflect.ValueOf(x))
+			}
+			return nil
+		}
+		return fmt.Errorf("cannot decode an element of %s into %s", v.Type(), t)
+	}
+	x, ok := elemAt(v, i)
+	return storeElem(dst, x, ok)
+}
+
+// storeElem stores the Go scalar x, as returned by elemAt, in dst, which
+// is of a scalar type. NA (ok false) becomes a nil pointer, an invalid
+// sql.Null value or NaN.
+func storeElem(dst reflect.Value, x any, ok bool) error {
+	t := dst.Type()
+	switch {
+	case t.Kind() == reflect.Pointer:
+		if !ok {
+			dst.SetZero()
+			return nil
+		}
+		p := reflect.New(t.Elem())
+		if err := storeElem(p.Elem(), x, ok); err != nil {
+			return err
+		}
+		dst.Set(p)
+		return nil
+	case sqlNull(t):
+		dst.SetZero()
+		if !ok {
+			return nil
+		}
+		if err := setScalar(dst.Field(0), x); err != nil {
+			return err
+		}
+		dst.Field(1).SetBool(true)
+		return nil
+	}
+	if !ok {
+		if t.Kind() == reflect.Float32 || t.Kind() == reflect.Float64 {
+			dst.SetFloat(math.NaN())
+			return nil
+		}
+		return fmt.Errorf("cannot decode NA into %s", t)
+	}
+	return setScalar(dst, x)
+}
+
+// setScalar stores the Go scalar x, as returned by elemAt, in dst.
+func setScalar(dst reflect.Value, x any) error {
+	t := dst.Type()
+	bad := func() error { return fmt.Errorf("cannot decode %T into %s", x, t) }
+	if t == timeType {
+		tm, ok := x.(time.Time)
+		if !ok {
+			return bad()
+		}
+		dst.Set(reflect.ValueOf(tm))
+		return nil
+	}
+	var f float64
+	numeric := true
+	switch n := x.(type) {
+	case bool:
+		f = float64(boolInt(n))
+	case int64:
+		f = float64(n)
+	case float64:
+		f = n
+	default:
+		numeric = false
+	}
+	switch t.Kind() {
+	case reflect.Bool:
+		b, ok := x.(bool)
+		if !ok {
+			return bad()
+		}
+		dst.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if !numeric || f != math.Trunc(f) || math.Abs(f) >= math.MaxInt64 || dst.OverflowInt(int64(f)) {
+			return fmt.Errorf("cannot decode %v into %s", x, t)
+		}
+		dst.SetInt(int64(f))
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		if !numeric || f != math.Trunc(f) || f < 0 || f >= math.MaxUint64 || dst.OverflowUint(uint64(f)) {
+			return fmt.Errorf("cannot decode %v into %s", x, t)
+		}
+		dst.SetUint(uint64(f))
+	case reflect.Float32, reflect.Float64:
+		if !numeric {
+			return bad()
+		}
+		dst.SetFloat(f)
+	case reflect.Complex64, reflect.Complex128:
+		if c, ok := x.(complex128); ok {
+			dst.SetComplex(c)
+		} else if numeric {
+			dst.SetComplex(complex(f, 0))
+		} else {
+			return bad()
+		}
+	case reflect.String:
+		s, ok := x.(string)
+		if !ok {
+			return bad()
+		}
+		dst.SetString(s)
+	default:
+		return bad()
+	}
+	return nil
+}
@@ -0,0 +1,1457 @@
+package rt
+
+import "math"
+
+// Density, distribution and quantile functions, following the
+// algorithms of R's nmath library so that results agree with R to near
+// machine precision. The lower and logP flags are R's lower.tail and
+// log.p: tails are computed directly rather than as 1 - p, so that small
+// p-values keep their precision, and logs are formed without underflow
+// where the algorithm allows.
+
+const (
+	lnSqrt2Pi  = 0.918938533204672741780329736406 // log(sqrt(2*pi))
+	ln2Pi      = 1.837877066409345483560659472811 // log(2*pi)
+	invSqrt2Pi = 0.398942280401432677939946059934 // 1/sqrt(2*pi)
+	dblEpsilon = 2.220446049250313e-16
+)
+
+// The helpers below correspond to nmath's R_D_* and R_DT_* macros.
+
+// dZero and dOne are a density or probability of 0 and 1.
+func dZero(logP bool) float64 {
+	if logP {
+		return math.Inf(-1)
+	}
+	return 0
+}
+
+func dOne(logP bool) float64 {
+	if logP {
+		return 0
+	}
+	return 1
+}
+
+// dExp returns exp(lv), or lv itself on the log scale.
+func dExp(lv float64, logP bool) float64 {
+	if logP {
+		return lv
+	}
+	return math.Exp(lv)
+}
+
+// dVal returns v, or its log.
+func dVal(v float64, logP bool) float64 {
+	if logP {
+		return math.Log(v)
+	}
+	return v
+}
+
+// pZero and pOne are the probabilities 0 and 1 of the lower tail,
+// reported in the requested tail and scale.
+func pZero(lower, logP bool) float64 {
+	if lower {
+		return dZero(logP)
+	}
+	return dOne(logP)
+}
+
+func pOne(lower, logP bool) float64 {
+	if lower {
+		return dOne(logP)
+	}
+	return dZero(logP)
+}
+
+// pLogVal converts the log of a tail probability to the same tail (same
+// is true) or the complementary one, in the requested scale.
+func pLogVal(lp float64, same, logP bool) float64 {
+	if same {
+		return dExp(lp, logP)
+	}
+	if logP {
+		return log1mExp(lp)
+	}
+	return -math.Expm1(lp)
+}
+
+// log1mExp returns log(1 - exp(x)) for x <= 0 without cancellation.
+func log1mExp(x float64) float64 {
+	if x > -math.Ln2 {
+		return math.Log(-math.Expm1(x))
+	}
+	return math.Log1p(-math.Exp(x))
+}
+
+// qLower and qUpper return the lower and upper tail probabilities that
+// the argument p of a quantile function stands for.
+func qLower(p float64, lower, logP bool) float64 {
+	switch {
+	case logP && lower:
+		return math.Exp(p)
+	case logP:
+		return -math.Expm1(p)
+	case lower:
+		return p
+	}
+	return 0.5 - p + 0.5
+}
+
+func qUpper(p float64, lower, logP bool) float64 {
+	return qLower(p, !lower, logP)
+}
+
+// qBounds handles the arguments of a quantile function at and beyond the
+// ends of [0, 1]: it reports the quantile left or right (or NaN) and
+// whether p was such a case.
+func qBounds(p float64, lower, logP bool, left, right float64) (float64, bool) {
+	if logP {
+		switch {
+		case p > 0:
+			return math.NaN(), true
+		case p == 0:
+			return pick(lower, right, left), true
+		case math.IsInf(p, -1):
+			return pick(lower, left, right), true
+		}
+		return 0, false
+	}
+	switch {
+	case p < 0 || p > 1:
+		return math.NaN(), true
+	case p == 0:
+		return pick(lower, left, right), true
+	case p == 1:
+		return pick(lower, right, left), true
+	}
+	return 0, false
+}
+
+func pick(cond bool, a, b float64) float64 {
+	if cond {
+		return a
+	}
+	return b
+}
+
+// nonInt reports whether x is not (close to) an integer.
+func nonInt(x float64) bool {
+	return math.Abs(x-math.RoundToEven(x)) > 1e-7*math.Max(1, math.Abs(x))
+}
+
+func anyNaN(xs ...float64) bool {
+	for _, x := range xs {
+		if math.IsNaN(x) {
+			return true
+		}
+	}
+	return false
+}
+
+func isFinite(x float64) bool { return !math.IsNaN(x) && !math.IsInf(x, 0) }
+
+// --- special functions ---
+
+// gammaFn is the gamma function; it is undefined at 0 and the negative
+// integers.
+func gammaFn(x float64) float64 {
+	if x == 0 || (x < 0 && x == math.Round(x)) {
+		return math.NaN()
+	}
+	return math.Gamma(x)
+}
+
+// lgammaFn returns log|gamma(x)|.
+func lgammaFn(x float64) float64 {
+	if x <= 0 && x == math.Trunc(x) {
+		return math.Inf(1)
+	}
+	lg, _ := math.Lgamma(x)
+	return lg
+}
+
+// stirlerr returns the error of Stirling's approximation,
+// log(x!) - log(sqrt(2*pi*x)*(x/e)^x).
+func stirlerr(n float64) float64 {
+	const (
+		s0 = 1.0 / 12
+		s1 = 1.0 / 360
+		s2 = 1.0 / 1260
+		s3 = 1.0 / 1680
+		s4 = 1.0 / 1188
+	)
+	if n <= 15 {
+		if n == 0 {
+			return 0
+		}
+		return lgammaFn(n+1) - (n+0.5)*math.Log(n) + n - lnSqrt2Pi
+	}
+	nn := n * n
+	switch {
+	case n > 500:
+		return (s0 - s1/nn) / n
+	case n > 80:
+		return (s0 - (s1-s2/nn)/nn) / n
+	case n > 35:
+		return (s0 - (s1-(s2-s3/nn)/nn)/nn) / n
+	}
+	return (s0 - (s1-(s2-(s3-s4/nn)/nn)/nn)/nn) / n
+}
+
+// bd0 returns x log(x/np) + np - x, the deviance term of the saddle
+// point expansions, without cancellation when x is close to np.
+func bd0(x, np float64) float64 {
+	if math.Abs(x-np) < 0.1*(x+np) {
+		v := (x - np) / (x + np)
+		s := (x - np) * v
+		if math.Abs(s) < math.SmallestNonzeroFloat64 {
+			return s
+		}
+		ej := 2 * x * v
+		v *= v
+		for j := 1; j < 1000; j++ {
+			ej *= v
+			s1 := s + ej/float64(2*j+1)
+			if s1 == s {
+				return s1
+			}
+			s = s1
+		}
+	}
+	return x*math.Log(x/np) + np - x
+}
+
+// lgammacor returns the remainder of Stirling's series for log gamma,
+// lgamma(x) - ((x - 0.5) log(x) - x + log(sqrt(2 pi))), for x >= 10.
+func lgammacor(x float64) float64 {
+	x2 := 1 / (x * x)
+	return (1.0/12 - x2*(1.0/360-x2*(1.0/1260-x2*(1.0/1680-x2*(1.0/1188-x2*(691.0/360360-x2/156)))))) / x
+}
+
+// lbetaFn returns the log of the beta function, avoiding the
+// cancellation of the three log gamma terms when the arguments are large.
+func lbetaFn(a, b float64) float64 {
+	if math.IsNaN(a) || math.IsNaN(b) {
+		return a + b
+	}
+	p, q := math.Min(a, b), math.Max(a, b)
+	switch {
+	case p < 0:
+		return math.NaN()
+	case p == 0:
+		return math.Inf(1)
+	case math.IsInf(q, 1):
+		return math.Inf(-1)
+	case p >= 10:
+		corr := lgammacor(p) + lgammacor(q) - lgammacor(p+q)
+		return -0.5*math.Log(q) + lnSqrt2Pi + corr + (p-0.5)*math.Log(p/(p+q)) + q*math.Log1p(-p/(p+q))
+	case q >= 10:
+		corr := lgammacor(q) - lgammacor(p+q)
+		return lgammaFn(p) + corr + p - p*math.Log(p+q) + (q-0.5)*math.Log1p(-p/(p+q))
+	case p < 1e-306:
+		return lgammaFn(p) + (lgammaFn(q) - lgammaFn(p+q))
+	}
+	return math.Log(gammaFn(p) * (gammaFn(q) / gammaFn(p+q)))
+}
+
+// betaFn returns the beta function B(a, b).
+func betaFn(a, b float64) float64 {
+	const xmax = 171.61447887182298
+	switch {
+	case math.IsNaN(a) || math.IsNaN(b):
+		return a + b
+	case a < 0 || b < 0:
+		return math.NaN()
+	case a == 0 || b == 0:
+		return math.Inf(1)
+	case math.IsInf(a, 1) || math.IsInf(b, 1):
+		return 0
+	case a+b < xmax:
+		return (1 / gammaFn(a+b)) * (gammaFn(a) * gammaFn(b))
+	}
+	return math.Exp(lbetaFn(a, b))
+}
+
+// lfastchoose returns log(choose(n, k)) for n >= k.
+func lfastchoose(n, k float64) float64 {
+	return -math.Log(n+1) - lbetaFn(n-k+1, k+1)
+}
+
+// lfastchoose2 is lfastchoose for non-integer n < k - 1, where
+// gamma(n - k + 1) may be negative; its sign is returned as well.
+func lfastchoose2(n, k float64) (float64, float64) {
+	r, s := math.Lgamma(n - k + 1)
+	return lgammaFn(n+1) - lgammaFn(k+1) - r, float64(s)
+}
+
+// chooseFn returns the binomial coefficient for real n and integer k
+// (k is rounded).
+func chooseFn(n, k float64) float64 {
+	const kSmallMax = 30
+	if math.IsNaN(n) || math.IsNaN(k) {
+		return n + k
+	}
+	k = math.RoundToEven(k)
+	if k < kSmallMax {
+		if n-k < k && n >= 0 && !nonInt(n) {
+			k = math.RoundToEven(n - k)
+		}
+		if k < 0 {
+			return 0
+		}
+		if k == 0 {
+			return 1
+		}
+		r := n
+		for j := 2.0; j <= k; j++ {
+			r *= (n - j + 1) / j
+		}
+		if !nonInt(n) {
+			return math.RoundToEven(r)
+		}
+		return r
+	}
+	if n < 0 {
+		r := chooseFn(-n+k-1, k)
+		if math.Mod(k, 2) == 1 {
+			r = -r
+		}
+		return r
+	}
+	if !nonInt(n) {
+		n = math.RoundToEven(n)
+		if n < k {
+			return 0
+		}
+		if n-k < kSmallMax {
+			return chooseFn(n, n-k)
+		}
+		return math.RoundToEven(math.Exp(lfastchoose(n, k)))
+	}
+	if n < k-1 {
+		r, s := lfastchoose2(n, k)
+		return s * math.Exp(r)
+	}
+	return math.Exp(lfastchoose(n, k))
+}
+
+// lchooseFn returns log|choose(n, k)|.
+func lchooseFn(n, k float64) float64 {
+	if math.IsNaN(n) || math.IsNaN(k) {
+		return n + k
+	}
+	k = math.RoundToEven(k)
+	if k < 2 {
+		switch {
+		case k < 0:
+			return math.Inf(-1)
+		case k == 0:
+			return 0
+		}
+		return math.Log(math.Abs(n))
+	}
+	if n < 0 {
+		return lchooseFn(-n+k-1, k)
+	}
+	if !nonInt(n) {
+		n = math.RoundToEven(n)
+		if n < k {
+			return math.Inf(-1)
+		}
+		if n-k < 2 {
+			return lchooseFn(n, n-k)
+		}
+		return lfastchoose(n, k)
+	}
+	if n < k-1 {
+		r, _ := lfastchoose2(n, k)
+		return r
+	}
+	return lfastchoose(n, k)
+}
+
+// --- normal ---
+
+func dnormFn(x, mu, sigma float64, logP bool) float64 {
+	if anyNaN(x, mu, sigma) {
+		return x + mu + sigma
+	}
+	switch {
+	case sigma < 0:
+		return math.NaN()
+	case !isFinite(sigma):
+		return dZero(logP)
+	case !isFinite(x) && mu == x:
+		return math.NaN()
+	case sigma == 0:
+		if x == mu {
+			return math.Inf(1)
+		}
+		return dZero(logP)
+	}
+	x = math.Abs((x - mu) / sigma)
+	if !isFinite(x) {
+		return dZero(logP)
+	}
+	if logP {
+		return -(lnSqrt2Pi + 0.5*x*x + math.Log(sigma))
+	}
+	if x < 5 {
+		return invSqrt2Pi * math.Exp(-0.5*x*x) / sigma
+	}
+	if x > math.Sqrt(2*math.Ln2*1073) {
+		return 0 // exp(-x^2/2) underflows
+	}
+	// split x so that exp(-x^2/2) is computed without the rounding error
+	// of squaring x
+	x1 := math.Ldexp(math.RoundToEven(math.Ldexp(x, 16)), -16)
+	x2 := x - x1
+	return invSqrt2Pi / sigma * (math.Exp(-0.5*x1*x1) * math.Exp((-0.5*x2-x1)*x2))
+}
+
+func pnormFn(x, mu, sigma float64, lower, logP bool) float64 {
+	if anyNaN(x, mu, sigma) {
+		return x + mu + sigma
+	}
+	if !isFinite(x) && mu == x {
+		return math.NaN()
+	}
+	switch {
+	case sigma < 0:
+		return math.NaN()
+	case sigma == 0:
+		if x < mu {
+			return pZero(lower, logP)
+		}
+		return pOne(lower, logP)
+	}
+	z := (x - mu) / sigma
+	if !isFinite(z) {
+		if z < 0 {
+			return pZero(lower, logP)
+		}
+		return pOne(lower, logP)
+	}
+	if !lower {
+		z = -z
+	}
+	// z is now the point at which the lower tail is wanted
+	if z > 0 {
+		q := 0.5 * math.Erfc(z/math.Sqrt2)
+		if logP {
+			return math.Log1p(-q)
+		}
+		return 0.5 - q + 0.5
+	}
+	if logP && z < -35 {
+		// the tail underflows: use the asymptotic expansion of Mills' ratio
+		t := -z
+		r := 1 / (t * t)
+		s := 1 - r*(1-3*r*(1-5*r*(1-7*r*(1-9*r*(1-11*r)))))
+		return -0.5*t*t - math.Log(t) - lnSqrt2Pi + math.Log(s)
+	}
+	return dVal(0.5*math.Erfc(-z/math.Sqrt2), logP)
+}
+
+// qnormFn uses Wichura's algorithm AS 241, which is accurate to about
+// 1e-16.
+func qnormFn(p, mu, sigma float64, lower, logP bool) float64 {
+	if anyNaN(p, mu, sigma) {
+		return p + mu + sigma
+	}
+	if v, ok := qBounds(p, lower, logP, math.Inf(-1), math.Inf(1)); ok {
+		return v
+	}
+	switch {
+	case sigma < 0:
+		return math.NaN()
+	case sigma == 0:
+		return mu
+	}
+	pl := qLower(p, lower, logP)
+	q := pl - 0.5
+	if math.Abs(q) <= 0.425 {
+		r := 0.180625 - q*q
+		return mu + sigma*q*(((((((r*2509.0809287301226727+33430.575583588128105)*r+67265.770927008700853)*r+
+			45921.953931549871457)*r+13731.693765509461125)*r+1971.5909503065514427)*r+133.14166789178437745)*r+
+			3.387132872796366608)/
+			(((((((r*5226.495278852545925+28729.085735721942674)*r+39307.89580009271061)*r+
+				21213.794301586595867)*r+5394.1960214247511077)*r+687.1870074920579083)*r+42.313330701600911252)*r+1)
+	}
+	// r = sqrt(-log(min(p, 1 - p))), taken from the log scale directly
+	// when p was given as a log
+	var lp float64
+	if logP && ((lower && q <= 0) || (!lower && q > 0)) {
+		lp = p
+	} else if q > 0 {
+		lp = math.Log(qUpper(p, lower, logP))
+	} else {
+		lp = math.Log(pl)
+	}
+	r := math.Sqrt(-lp)
+	var val float64
+	if r <= 5 {
+		r -= 1.6
+		val = (((((((r*7.7454501427834140764e-4+0.0227238449892691845833)*r+0.24178072517745061177)*r+
+			1.27045825245236838258)*r+3.64784832476320460504)*r+5.7694972214606914055)*r+4.6303378461565452959)*r +
+			1.42343711074968357734) /
+			(((((((r*1.05075007164441684324e-9+5.475938084995344946e-4)*r+0.0151986665636164571966)*r+
+				0.14810397642748007459)*r+0.68976733498510000455)*r+1.6763848301838038494)*r+2.05319162663775882187)*r + 1)
+	} else {
+		r -= 5
+		val = (((((((r*2.01033439929228813265e-7+2.71155556874348757815e-5)*r+0.0012426609473880784386)*r+
+			0.026532189526576123093)*r+0.29656057182850489123)*r+1.7848265399172913358)*r+5.4637849111641143699)*r +
+			6.6579046435011037772) /
+			(((((((r*2.04426310338993978564e-15+1.4215117583164458887e-7)*r+1.8463183175100546818e-5)*r+
+				7.868691311456132591e-4)*r+0.0148753612908506148525)*r+0.13692988092273580531)*r+0.59983220655588793769)*r + 1)
+	}
+	if q < 0 {
+		val = -val
+	}
+	return mu + sigma*val
+}
+
+// --- binomial and Poisson ---
+
+// dbinomRaw is Loader's saddle point expansion of the binomial
+// probability of x successes in n trials; x and n need not be integers,
+// which the beta and F densities rely on. q is 1 - p.
+func dbinomRaw(x, n, p, q float64, logP bool) float64 {
+	if p == 0 {
+		return pick(x == 0, dOne(logP), dZero(logP))
+	}
+	if q == 0 {
+		return pick(x == n, dOne(logP), dZero(logP))
+	}
+	if x == 0 {
+		if n == 0 {
+			return dOne(logP)
+		}
+		var lc float64
+		if p < 0.1 {
+			lc = -bd0(n, n*q) - n*p
+		} else {
+			lc = n * math.Log(q)
+		}
+		return dExp(lc, logP)
+	}
+	if x == n {
+		var lc float64
+		if q < 0.1 {
+			lc = -bd0(n, n*p) - n*q
+		} else {
+			lc = n * math.Log(p)
+		}
+		return dExp(lc, logP)
+	}
+	if x < 0 || x > n {
+		return dZero(logP)
+	}
+	lc := stirlerr(n) - stirlerr(x) - stirlerr(n-x) - bd0(x, n*p) - bd0(n-x, n*q)
+	lf := ln2Pi + math.Log(x) + math.Log1p(-x/n)
+	return dExp(lc-0.5*lf, logP)
+}
+
+// dbinomFn expects integer x; callers warn about and zero other values.
+func dbinomFn(x, n, p float64, logP bool) float64 {
+	if anyNaN(x, n, p) {
+		return x + n + p
+	}
+	if p < 0 || p > 1 || n < 0 || nonInt(n) {
+		return math.NaN()
+	}
+	if nonInt(x) || x < 0 || !isFinite(x) {
+		return dZero(logP)
+	}
+	return dbinomRaw(math.RoundToEven(x), math.RoundToEven(n), p, 1-p, logP)
+}
+
+func pbinomFn(x, n, p float64, lower, logP bool) float64 {
+	if anyNaN(x, n, p) {
+		return x + n + p
+	}
+	if !isFinite(n) || !isFinite(p) || nonInt(n) {
+		return math.NaN()
+	}
+	n = math.RoundToEven(n)
+	if n < 0 || p < 0 || p > 1 {
+		return math.NaN()
+	}
+	if x < 0 {
+		return pZero(lower, logP)
+	}
+	x = math.Floor(x + 1e-7)
+	if n <= x {
+		return pOne(lower, logP)
+	}
+	return pbetaRaw(p, 0.5-p+0.5, x+1, n-x, !lower, logP)
+}
+
+// qbinomFn starts from the Cornish-Fisher expansion and searches for the
+// smallest x with P(X <= x) >= p, allowing a little fuzz so that p
+// values computed by pbinom map back to their x.
+func qbinomFn(p, n, pr float64, lower, logP bool) float64 {
+	if anyNaN(p, n, pr) {
+		return p + n + pr
+	}
+	if !isFinite(n) || !isFinite(pr) || (!logP && !isFinite(p)) || nonInt(n) {
+		return math.NaN()
+	}
+	n = math.RoundToEven(n)
+	if pr < 0 || pr > 1 || n < 0 {
+		return math.NaN()
+	}
+	if v, ok := qBounds(p, lower, logP, 0, n); ok {
+		return v
+	}
+	if pr == 0 || n == 0 {
+		return 0
+	}
+	q := 1 - pr
+	if q == 0 {
+		return n
+	}
+	mu := n * pr
+	sigma := math.Sqrt(n * pr * q)
+	gamma := (q - pr) / sigma
+
+	pn := qLower(p, lower, logP)
+	switch {
+	case pn == 0:
+		return 0
+	case pn+1.01*dblEpsilon >= 1:
+		return n
+	}
+	z := qnormFn(pn, 0, 1, true, false)
+	y := math.Max(0, math.Min(n, math.RoundToEven(mu+sigma*(z+gamma*(z*z-1)/6))))
+	pn *= 1 - 64*dblEpsilon
+	if pbinomFn(y, n, pr, true, false) >= pn {
+		for y > 0 && pbinomFn(y-1, n, pr, true, false) >= pn {
+			y--
+		}
+		return y
+	}
+	for y < n {
+		y++
+		if pbinomFn(y, n, pr, true, false) >= pn {
+			break
+		}
+	}
+	return y
+}
+
+// dpoisRaw returns the Poisson probability of x for real x, by the same
+// saddle point expansion as dbinomRaw.
+func dpoisRaw(x, lambda float64, logP bool) float64 {
+	switch {
+	case lambda == 0:
+		return pick(x == 0, dOne(logP), dZero(logP))
+	case !isFinite(lambda) || x < 0:
+		return dZero(logP)
+	case x <= lambda*math.SmallestNonzeroFloat64:
+		return dExp(-lambda, logP)
+	case lambda < x*math.SmallestNonzeroFloat64:
+		return dExp(-lambda+x*math.Log(lambda)-lgammaFn(x+1), logP)
+	}
+	v := -stirlerr(x) - bd0(x, lambda)
+	f := 2 * math.Pi * x
+	if logP {
+		return -0.5*math.Log(f) + v
+	}
+	return math.Exp(v) / math.Sqrt(f)
+}
+
+// dpoisFn expects integer x; callers warn about and zero other values.
+func dpoisFn(x, lambda float64, logP bool) float64 {
+	if anyNaN(x, lambda) {
+		return x + lambda
+	}
+	if lambda < 0 {
+		return math.NaN()
+	}
+	if nonInt(x) || x < 0 || !isFinite(x) {
+		return dZero(logP)
+	}
+	return dpoisRaw(math.RoundToEven(x), lambda, logP)
+}
+
+// ppoisFn is the Poisson distribution function, which is the upper tail
+// of a gamma distribution: P(X <= x) = P(G(x+1) > lambda).
+func ppoisFn(x, lambda float64, lower, logP bool) float64 {
+	if anyNaN(x, lambda) {
+		return x + lambda
+	}
+	if lambda < 0 {
+		return math.NaN()
+	}
+	switch {
+	case x < 0:
+		return pZero(lower, logP)
+	case lambda == 0 || !isFinite(x):
+		return pOne(lower, logP)
+	}
+	return pgammaRaw(lambda, math.Floor(x+1e-7)+1, !lower, logP)
+}
+
+// qpoisFn searches from the Cornish-Fisher expansion like qbinomFn.
+func qpoisFn(p, lambda float64, lower, logP bool) float64 {
+	if anyNaN(p, lambda) {
+		return p + lambda
+	}
+	if !isFinite(lambda) || lambda < 0 {
+		return math.NaN()
+	}
+	if v, ok := qBounds(p, lower, logP, 0, math.Inf(1)); ok {
+		return v
+	}
+	if lambda == 0 {
+		return 0
+	}
+	sigma := math.Sqrt(lambda)
+	gamma := 1 / sigma
+
+	pn := qLower(p, lower, logP)
+	switch {
+	case pn == 0:
+		return 0
+	case pn+1.01*dblEpsilon >= 1:
+		return math.Inf(1)
+	}
+	z := qnormFn(pn, 0, 1, true, false)
+	y := math.Max(0, math.RoundToEven(lambda+sigma*(z+gamma*(z*z-1)/6)))
+	pn *= 1 - 64*dblEpsilon
+	if ppoisFn(y, lambda, true, false) >= pn {
+		for y > 0 && ppoisFn(y-1, lambda, true, false) >= pn {
+			y--
+		}
+		return y
+	}
+	for {
+		y++
+		if ppoisFn(y, lambda, true, false) >= pn {
+			return y
+		}
+	}
+}
+
+// --- beta ---
+
+func dbetaFn(x, a, b float64, logP bool) float64 {
+	if anyNaN(x, a, b) {
+		return x + a + b
+	}
+	if a < 0 || b < 0 {
+		return math.NaN()
+	}
+	if x < 0 || x > 1 {
+		return dZero(logP)
+	}
+	// limits in which the distribution is a point mass
+	if a == 0 || b == 0 || !isFinite(a) || !isFinite(b) {
+		switch {
+		case a == 0 && b == 0:
+			return pick(x == 0 || x == 1, math.Inf(1), dZero(logP))
+		case a == 0 || a/b == math.Inf(1):
+			return pick(x == 0, math.Inf(1), dZero(logP))
+		case b == 0 || b/a == math.Inf(1):
+			return pick(x == 1, math.Inf(1), dZero(logP))
+		}
+		return pick(x == 0.5, math.Inf(1), dZero(logP))
+	}
+	if x == 0 {
+		switch {
+		case a > 1:
+			return dZero(logP)
+		case a < 1:
+			return math.Inf(1)
+		}
+		return dVal(b, logP)
+	}
+	if x == 1 {
+		switch {
+		case b > 1:
+			return dZero(logP)
+		case b < 1:
+			return math.Inf(1)
+		}
+		return dVal(a, logP)
+	}
+	var lval float64
+	if a <= 2 || b <= 2 {
+		lval = (a-1)*math.Log(x) + (b-1)*math.Log1p(-x) - lbetaFn(a, b)
+	} else {
+		lval = math.Log(a+b-1) + dbinomRaw(a-1, a+b-2, x, 1-x, true)
+	}
+	return dExp(lval, logP)
+}
+
+func pbetaFn(x, a, b float64, lower, logP bool) float64 {
+	if anyNaN(x, a, b) {
+		return x + a + b
+	}
+	if a < 0 || b < 0 {
+		return math.NaN()
+	}
+	return pbetaRaw(x, 0.5-x+0.5, a, b, lower, logP)
+}
+
+// pbetaRaw returns the regularized incomplete beta function I_x(a, b)
+// in the requested tail and scale; y is 1 - x, passed separately so that
+// callers can supply it without cancellation.
+func pbetaRaw(x, y, a, b float64, lower, logP bool) float64 {
+	switch {
+	case x <= 0:
+		return pZero(lower, logP)
+	case y <= 0:
+		return pOne(lower, logP)
+	}
+	if a == 0 || b == 0 || !isFinite(a) || !isFinite(b) {
+		switch {
+		case a == 0 && b == 0:
+			return pick(logP, -math.Ln2, 0.5)
+		case a == 0 || a/b == math.Inf(1):
+			return pOne(lower, logP)
+		case b == 0 || b/a == math.Inf(1):
+			return pZero(lower, logP)
+		case x < 0.5:
+			return pZero(lower, logP)
+		}
+		return pOne(lower, logP)
+	}
+	// the continued fraction converges quickly below the mean; above it
+	// the symmetry I_x(a, b) = 1 - I_y(b, a) is used
+	if x > (a+1)/(a+b+2) {
+		return pbetaRaw(y, x, b, a, !lower, logP)
+	}
+	// log of x^a y^b / (a B(a, b)); for large a and b by way of the
+	// binomial saddle point expansion, which keeps its relative accuracy
+	var lfront float64
+	if a <= 2 || b <= 2 {
+		lfront = a*math.Log(x) + b*math.Log(y) - lbetaFn(a, b) - math.Log(a)
+	} else {
+		lfront = math.Log(b/(a+b)) + dbinomRaw(a, a+b, x, y, true)
+	}
+	return pLogVal(lfront+math.Log(betaFraction(x, a, b)), lower, logP)
+}
+
+// betaFraction evaluates the continued fraction of the incomplete beta
+// function by the modified Lentz method.
+func betaFraction(x, a, b float64) float64 {
+	const tiny = 1e-300
+	c, d := 1.0, 1-(a+b)*x/(a+1)
+	if math.Abs(d) < tiny {
+		d = tiny
+	}
+	d = 1 / d
+	h := d
+	for m := 1; m <= 100000; m++ {
+		fm := float64(m)
+		aa := fm * (b - fm) * x / ((a + 2*fm - 1) * (a + 2*fm))
+		d = 1 + aa*d
+		if math.Abs(d) < tiny {
+			d = tiny
+		}
+		c = 1 + aa/c
+		if math.Abs(c) < tiny {
+			c = tiny
+		}
+		d = 1 / d
+		h *= d * c
+		aa = -(a + fm) * (a + b + fm) * x / ((a + 2*fm) * (a + 2*fm + 1))
+		d = 1 + aa*d
+		if math.Abs(d) < tiny {
+			d = tiny
+		}
+		c = 1 + aa/c
+		if math.Abs(c) < tiny {
+			c = tiny
+		}
+		d = 1 / d
+		del := d * c
+		h *= del
+		if math.Abs(del-1) < 1e-16 {
+			break
+		}
+	}
+	return h
+}
+
+// qbetaFn inverts pbetaFn on [0, 1], starting from the mean.
+func qbetaFn(p, a, b float64, lower, logP bool) float64 {
+	if anyNaN(p, a, b) {
+		return p + a + b
+	}
+	if a < 0 || b < 0 {
+		return math.NaN()
+	}
+	if v, ok := qBounds(p, lower, logP, 0, 1); ok {
+		return v
+	}
+	// limits in which the distribution is a point mass
+	if a == 0 || b == 0 || !isFinite(a) || !isFinite(b) {
+		switch {
+		case a == 0 && b == 0:
+			pl := qLower(p, lower, logP)
+			return pick(pl < 0.5, 0, pick(pl > 0.5, 1, 0.5))
+		case a == 0 || a/b == math.Inf(1):
+			return 0
+		case b == 0 || b/a == math.Inf(1):
+			return 1
+		}
+		return 0.5
+	}
+	return invertCDF(qLower(p, lower, logP), qUpper(p, lower, logP), a/(a+b),
+		func(x float64, lower bool) float64 { return pbetaFn(x, a, b, lower, false) },
+		func(x float64) float64 { return dbetaFn(x, a, b, false) })
+}
+
+// --- gamma, chi-squared and exponential ---
+
+func dgammaFn(x, shape, scale float64, logP bool) float64 {
+	if anyNaN(x, shape, scale) {
+		return x + shape + scale
+	}
+	if shape < 0 || scale <= 0 {
+		return math.NaN()
+	}
+	if x < 0 {
+		return dZero(logP)
+	}
+	if shape == 0 {
+		return pick(x == 0, math.Inf(1), dZero(logP))
+	}
+	if x == 0 {
+		switch {
+		case shape < 1:
+			return math.Inf(1)
+		case shape > 1:
+			return dZero(logP)
+		}
+		return pick(logP, -math.Log(scale), 1/scale)
+	}
+	if shape < 1 {
+		pr := dpoisRaw(shape, x/scale, logP)
+		if !logP {
+			return pr * shape / x
+		}
+		if isFinite(shape / x) {
+			return pr + math.Log(shape/x)
+		}
+		return pr + math.Log(shape) - math.Log(x)
+	}
+	pr := dpoisRaw(shape-1, x/scale, logP)
+	return pick(logP, pr-math.Log(scale), pr/scale)
+}
+
+func pgammaFn(x, shape, scale float64, lower, logP bool) float64 {
+	if anyNaN(x, shape, scale) {
+		return x + shape + scale
+	}
+	if shape < 0 || scale <= 0 {
+		return math.NaN()
+	}
+	x /= scale
+	if math.IsNaN(x) {
+		return x
+	}
+	if shape == 0 {
+		if x <= 0 {
+			return pZero(lower, logP)
+		}
+		return pOne(lower, logP)
+	}
+	return pgammaRaw(x, shape, lower, logP)
+}
+
+// pgammaRaw returns the regularized incomplete gamma function P(shape, x)
+// in the requested tail and scale. The series converges below shape+1
+// and gives the lower tail, the continued fraction above it gives the
+// upper tail; the other tail is formed from the log without cancellation.
+func pgammaRaw(x, shape float64, lower, logP bool) float64 {
+	switch {
+	case x <= 0:
+		return pZero(lower, logP)
+	case math.IsInf(x, 1):
+		return pOne(lower, logP)
+	}
+	// log of x^shape e^-x / gamma(shape)
+	lfront := math.Log(shape) + dpoisRaw(shape, x, true)
+	if x < shape+1 {
+		sum, term := 1/shape, 1/shape
+		for n := 1; n <= 100000; n++ {
+			term *= x / (shape + float64(n))
+			sum += term
+			if math.Abs(term) < math.Abs(sum)*1e-17 {
+				break
+			}
+		}
+		return pLogVal(lfront+math.Log(sum), lower, logP)
+	}
+	// modified Lentz evaluation of the continued fraction
+	const tiny = 1e-300
+	b := x + 1 - shape
+	c, d := 1/tiny, 1/b
+	h := d
+	for n := 1; n <= 100000; n++ {
+		an := -float64(n) * (float64(n) - shape)
+		b += 2
+		d = an*d + b
+		if math.Abs(d) < tiny {
+			d = tiny
+		}
+		c = b + an/c
+		if math.Abs(c) < tiny {
+			c = tiny
+		}
+		d = 1 / d
+		del := d * c
+		h *= del
+		if math.Abs(del-1) < 1e-16 {
+			break
+		}
+	}
+	return pLogVal(lfront+math.Log(h), !lower, logP)
+}
+
+func dchisqFn(x, df float64, logP bool) float64 {
+	return dgammaFn(x, df/2, 2, logP)
+}
+
+func pchisqFn(x, df float64, lower, logP bool) float64 {
+	return pgammaFn(x, df/2, 2, lower, logP)
+}
+
+func qchisqFn(p, df float64, lower, logP bool) float64 {
+	if anyNaN(p, df) {
+		return p + df
+	}
+	if df < 0 {
+		return math.NaN()
+	}
+	if v, ok := qBounds(p, lower, logP, 0, math.Inf(1)); ok {
+		return v
+	}
+	if df == 0 {
+		return 0
+	}
+	// Wilson-Hilferty start
+	z := qnormFn(p, 0, 1, lower, logP)
+	c := 2 / (9 * df)
+	start := df * math.Pow(math.Max(1-c+z*math.Sqrt(c), 0.1), 3)
+	return invertCDF(qLower(p, lower, logP), qUpper(p, lower, logP), start,
+		func(x float64, lower bool) float64 { return pchisqFn(x, df, lower, false) },
+		func(x float64) float64 { return dchisqFn(x, df, false) })
+}
+
+// qgammaFn scales the chi-squared quantile with 2*shape degrees of
+// freedom.
+func qgammaFn(p, shape, scale float64, lower, logP bool) float64 {
+	if anyNaN(p, shape, scale) {
+		return p + shape + scale
+	}
+	if shape < 0 || scale <= 0 {
+		return math.NaN()
+	}
+	return 0.5 * scale * qchisqFn(p, 2*shape, lower, logP)
+}
+
+func dexpFn(x, rate float64, logP bool) float64 {
+	if anyNaN(x, rate) {
+		return x + rate
+	}
+	scale := 1 / rate
+	if scale <= 0 {
+		return math.NaN()
+	}
+	if x < 0 {
+		return dZero(logP)
+	}
+	return pick(logP, -x/scale-math.Log(scale), math.Exp(-x/scale)/scale)
+}
+
+func pexpFn(x, rate float64, lower, logP bool) float64 {
+	if anyNaN(x, rate) {
+		return x + rate
+	}
+	scale := 1 / rate
+	if scale < 0 {
+		return math.NaN()
+	}
+	if x <= 0 {
+		return pZero(lower, logP)
+	}
+	// -x/scale is the log of the upper tail
+	return pLogVal(-x/scale, !lower, logP)
+}
+
+func qexpFn(p, rate float64, lower, logP bool) float64 {
+	if anyNaN(p, rate) {
+		return p + rate
+	}
+	scale := 1 / rate
+	if scale < 0 {
+		return math.NaN()
+	}
+	if v, ok := qBounds(p, lower, logP, 0, math.Inf(1)); ok {
+		return v
+	}
+	return -scale * qUpperLog(p, lower, logP)
+}
+
+// qUpperLog returns the log of the upper tail probability that p stands
+// for, without forming 1 - p where that would lose precision.
+func qUpperLog(p float64, lower, logP bool) float64 {
+	switch {
+	case logP && lower:
+		return log1mExp(p)
+	case logP:
+		return p
+	case lower:
+		return math.Log1p(-p)
+	}
+	return math.Log(p)
+}
+
+func dunifFn(x, a, b float64, logP bool) float64 {
+	if anyNaN(x, a, b) {
+		return x + a + b
+	}
+	if b <= a {
+		return math.NaN()
+	}
+	if a <= x && x <= b {
+		return pick(logP, -math.Log(b-a), 1/(b-a))
+	}
+	return dZero(logP)
+}
+
+func punifFn(x, a, b float64, lower, logP bool) float64 {
+	if anyNaN(x, a, b) {
+		return x + a + b
+	}
+	if b < a || !isFinite(a) || !isFinite(b) {
+		return math.NaN()
+	}
+	switch {
+	case x >= b:
+		return pOne(lower, logP)
+	case x <= a:
+		return pZero(lower, logP)
+	case lower:
+		return dVal((x-a)/(b-a), logP)
+	}
+	return dVal((b-x)/(b-a), logP)
+}
+
+func qunifFn(p, a, b float64, lower, logP bool) float64 {
+	if anyNaN(p, a, b) {
+		return p + a + b
+	}
+	if b < a || !isFinite(a) || !isFinite(b) {
+		return math.NaN()
+	}
+	if v, ok := qBounds(p, lower, logP, a, b); ok {
+		return v
+	}
+	if b == a {
+		return a
+	}
+	return a + qLower(p, lower, logP)*(b-a)
+}
+
+// --- Student's t ---
+
+func dtFn(x, n float64, logP bool) float64 {
+	if anyNaN(x, n) {
+		return x + n
+	}
+	if n <= 0 {
+		return math.NaN()
+	}
+	if !isFinite(x) {
+		return dZero(logP)
+	}
+	if !isFinite(n) {
+		return dnormFn(x, 0, 1, logP)
+	}
+	t := -bd0(n/2, (n+1)/2) + stirlerr((n+1)/2) - stirlerr(n/2)
+	x2n := x * x / n
+	var lx2n, u, ax float64
+	large := x2n > 1/dblEpsilon
+	switch {
+	case large:
+		ax = math.Abs(x)
+		lx2n = math.Log(ax) - math.Log(n)/2
+		u = n * lx2n
+	case x2n > 0.2:
+		lx2n = math.Log(1+x2n) / 2
+		u = n * lx2n
+	default:
+		lx2n = math.Log1p(x2n) / 2
+		u = -bd0(n/2, (n+x*x)/2) + x*x/2
+	}
+	if logP {
+		return t - u - (lnSqrt2Pi + lx2n)
+	}
+	isqrt := math.Exp(-lx2n)
+	if large {
+		isqrt = math.Sqrt(n) / ax
+	}
+	return math.Exp(t-u) * invSqrt2Pi * isqrt
+}
+
+func ptFn(x, n float64, lower, logP bool) float64 {
+	if anyNaN(x, n) {
+		return x + n
+	}
+	if n <= 0 {
+		return math.NaN()
+	}
+	if !isFinite(x) {
+		if x < 0 {
+			return pZero(lower, logP)
+		}
+		return pOne(lower, logP)
+	}
+	if !isFinite(n) {
+		return pnormFn(x, 0, 1, lower, logP)
+	}
+	if n > 4e5 {
+		// Abramowitz & Stegun 26.7.8
+		v := 1 / (4 * n)
+		return pnormFn(x*(1-v)/math.Sqrt(1+x*x*2*v), 0, 1, lower, logP)
+	}
+	// val is P(|T| > |x|), or its log
+	nx := 1 + (x/n)*x
+	var val float64
+	switch {
+	case nx > 1e100:
+		lval := -0.5*n*(2*math.Log(math.Abs(x))-math.Log(n)) - lbetaFn(0.5*n, 0.5) - math.Log(0.5*n)
+		val = dExp(lval, logP)
+	case n > x*x:
+		val = pbetaRaw(x*x/(n+x*x), n/(n+x*x), 0.5, n/2, false, logP)
+	default:
+		val = pbetaRaw(1/nx, x*x/(n+x*x), n/2, 0.5, true, logP)
+	}
+	if x <= 0 {
+		lower = !lower
+	}
+	if logP {
+		if lower {
+			return math.Log1p(-0.5 * math.Exp(val))
+		}
+		return val - math.Ln2
+	}
+	val /= 2
+	if lower {
+		return 0.5 - val + 0.5
+	}
+	return val
+}
+
+// qtFn solves P(T > t) = q for the smaller tail q by Newton's method,
+// safeguarded by bisection, and uses the symmetry of the distribution.
+func qtFn(p, df float64, lower, logP bool) float64 {
+	if anyNaN(p, df) {
+		return p + df
+	}
+	if v, ok := qBounds(p, lower, logP, math.Inf(-1), math.Inf(1)); ok {
+		return v
+	}
+	if df <= 0 {
+		return math.NaN()
+	}
+	if math.IsInf(df, 1) {
+		return qnormFn(p, 0, 1, lower, logP)
+	}
+	pl, pu := qLower(p, lower, logP), qUpper(p, lower, logP)
+	if pl == pu {
+		return 0
+	}
+	q := math.Min(pl, pu)
+	lo, hi := 0.0, 1.0
+	for ptFn(hi, df, false, false) > q {
+		lo, hi = hi, hi*2
+		if math.IsInf(hi, 1) {
+			break
+		}
+	}
+	t := math.Max(lo, math.Min(hi, math.Abs(qnormFn(q, 0, 1, true, false))))
+	for i := 0; i < 1000; i++ {
+		f := ptFn(t, df, false, false) - q
+		if f > 0 {
+			lo = t
+		} else {
+			hi = t
+		}
+		next := t + f/dtFn(t, df, false)
+		if next <= lo || next >= hi || math.IsNaN(next) {
+			next = (lo + hi) / 2
+		}
+		if math.Abs(next-t) <= 1e-15*math.Max(1, math.Abs(t)) {
+			t = next
+			break
+		}
+		t = next
+	}
+	if pl < pu {
+		return -t
+	}
+	return t
+}
+
+// --- F ---
+
+func dfFn(x, m, n float64, logP bool) float64 {
+	if anyNaN(x, m, n) {
+		return x + m + n
+	}
+	if m <= 0 || n <= 0 {
+		return math.NaN()
+	}
+	if x < 0 {
+		return dZero(logP)
+	}
+	if x == 0 {
+		switch {
+		case m > 2:
+			return dZero(logP)
+		case m == 2:
+			return dOne(logP)
+		}
+		return math.Inf(1)
+	}
+	if !isFinite(m) && !isFinite(n) {
+		return pick(x == 1, math.Inf(1), dZero(logP))
+	}
+	if !isFinite(n) {
+		return dgammaFn(x, m/2, 2/m, logP)
+	}
+	if m > 1e14 {
+		dens := dgammaFn(1/x, n/2, 2/n, logP)
+		return pick(logP, dens-2*math.Log(x), dens/(x*x))
+	}
+	f := 1 / (n + x*m)
+	q := n * f
+	p := x * m * f
+	var dens float64
+	if m >= 2 {
+		f = m * q / 2
+		dens = dbinomRaw((m-2)/2, (m+n-2)/2, p, q, logP)
+	} else {
+		f = m * m * q / (2 * p * (m + n))
+		dens = dbinomRaw(m/2, (m+n)/2, p, q, logP)
+	}
+	return pick(logP, math.Log(f)+dens, f*dens)
+}
+
+func pfFn(x, m, n float64, lower, logP bool) float64 {
+	if anyNaN(x, m, n) {
+		return x + m + n
+	}
+	if m <= 0 || n <= 0 {
+		return math.NaN()
+	}
+	switch {
+	case x <= 0:
+		return pZero(lower, logP)
+	case math.IsInf(x, 1):
+		return pOne(lower, logP)
+	}
+	if math.IsInf(n, 1) {
+		if math.IsInf(m, 1) {
+			switch {
+			case x < 1:
+				return pZero(lower, logP)
+			case x == 1:
+				return pick(logP, -math.Ln2, 0.5)
+			}
+			return pOne(lower, logP)
+		}
+		return pchisqFn(x*m, m, lower, logP)
+	}
+	if math.IsInf(m, 1) {
+		return pchisqFn(n/x, n, !lower, logP)
+	}
+	// avoid squeezing pbeta's first argument against 1
+	s := n + m*x
+	if m*x > n {
+		return pbetaRaw(n/s, m*x/s, n/2, m/2, !lower, logP)
+	}
+	return pbetaRaw(m*x/s, n/s, m/2, n/2, lower, logP)
+}
+
+func qfFn(p, m, n float64, lower, logP bool) float64 {
+	if anyNaN(p, m, n) {
+		return p + m + n
+	}
+	if m <= 0 || n <= 0 {
+		return math.NaN()
+	}
+	if v, ok := qBounds(p, lower, logP, 0, math.Inf(1)); ok {
+		return v
+	}
+	if m <= n && n > 4e5 {
+		if math.IsInf(m, 1) {
+			return 1
+		}
+		return qchisqFn(p, m, lower, logP) / m
+	}
+	if m > 4e5 {
+		return n / qchisqFn(p, n, !lower, logP)
+	}
+	return invertCDF(qLower(p, lower, logP), qUpper(p, lower, logP), 1,
+		func(x float64, lower bool) float64 { return pfFn(x, m, n, lower, false) },
+		func(x float64) float64 { return dfFn(x, m, n, false) })
+}
+
+// invertCDF returns the quantile of a continuous distribution on
+// [0, Inf), given both tail probabilities of p. It solves in the smaller
+// tail, where the probability is exact, bracketing the root by doubling
+// and refining it by Newton steps with a bisection fallback.
+func invertCDF(pl, pu, start float64, cdf func(x float64, lower bool) float64, density func(x float64) float64) float64 {
+	lower := pl <= pu
+	target, sign := pl, 1.0
+	if !lower {
+		target, sign = pu, -1
+	}
+	// sign*f is increasing in x
+	f := func(x float64) float64 { return cdf(x, lower) - target }
+	lo, hi := 0.0, math.Max(start, 1)
+	for sign*f(hi) < 0 {
+		lo, hi = hi, hi*2
+		if math.IsInf(hi, 1) {
+			return hi
+		}
+	}
+	x := start
+	if !(x > lo && x < hi) {
+		x = (lo + hi) / 2
+	}
+	for i := 0; i < 2000; i++ {
+		fx := f(x)
+		if fx == 0 {
+			return x
+		}
+		if sign*fx < 0 {
+			lo = x
+		} else {
+			hi = x
+		}
+		next := x - fx/(sign*density(x))
+		if !(next > lo && next < hi) {
+			if lo > 0 && hi/lo > 1e10 {
+				next = math.Sqrt(lo * hi)
+			} else {
+				next = (lo + hi) / 2
+			}
+		}
+		if math.Abs(next-x) <= 4*dblEpsilon*math.Abs(next) || hi-lo <= 4*dblEpsilon*hi {
+			return next
+		}
+		x = next
+	}
+	return x
+}
@@ -0,0 +1,167 @@
+package rt
+
+import (
+	"math"
+	"testing"
+)
+
+func TestDistributions(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"qnorm(c(0.5, 1, 2))", "0 Inf NaN"},
+		{"qbinom(c(0.5, 0.99), 10, 0.3)", "3 7"},
+		{"qpois(c(0, 0.5, 0.9), 3)", "0 3 5"},
+		{"qpois(0.99, 3, lower.tail = FALSE)", "0"},
+		{"pbeta(0.5, 2, 3)", "0.6875"},
+		{"dunif(c(-1, 0.5), 0, 2)", "0 0.5"},
+		{"punif(c(-1, 3), 0, 2)", "0 1"},
+		{"qexp(c(0, 1))", "0 Inf"},
+		{"qbeta(c(0, 1), 2, 3)", "0 1"},
+		{"pexp(-1)", "0"},
+		{"pnorm(c(-Inf, NA, Inf))", "0 NA 1"},
+		{"names(dnorm(c(a = 0, b = 1)))", `"a" "b"`},
+		{"gamma(5)", "24"},
+		{"factorial(0:4)", "1 1 2 6 24"},
+		{"choose(5, 0:5)", "1 5 10 10 5 1"},
+		{"choose(-3, 2)", "6"},
+		{"tryCatch(dbinom(1.5, 3, 0.5), warning = function(w) conditionMessage(w))", `"non-integer x = 1.500000"`},
+		{"tryCatch(qnorm(2), warning = function(w) conditionMessage(w))", `"NaNs produced"`},
+		{"suppressWarnings(gamma(0))", "NaN"},
+	}
+
+	for _, tt := range tests {
+		ctx := NewContext()
+		res, err := ctx.EvalString(tt.input)
+		if err != nil {
+			t.Errorf("input %q: unexpected error: %v", tt.input, err)
+			continue
+		}
+		if res.Value.String() != tt.expected {
+			t.Errorf("input %q: expected %s, got %s", tt.input, tt.expected, res.Value.String())
+		}
+	}
+}
+
+func TestDistributionAccuracy(t *testing.T) {
+	// reference values are exact to the digits given, computed with 60
+	// digit arithmetic
+	tests := []struct {
+		input    string
+		expected float64
+	}{
+		{"dnorm(0)", 0.3989422804014327},
+		{"dnorm(1, 2, 3)", 0.12579440923099772},
+		{"pnorm(1.96)", 0.97500210485177952},
+		{"pnorm(1.96, lower.tail = FALSE)", 0.024997895148220435},
+		{"pnorm(-40, log.p = TRUE)", -804.6084420137538},
+		{"qnorm(0.975)", 1.9599639845400543},
+		{"qnorm(0.1)", -1.2815515655446004},
+		{"qnorm(1e-300)", -37.047096299361201},
+		{"dbinom(3, 10, 0.5)", 0.1171875},
+		{"pbinom(3, 10, 0.3)", 0.64961071839999995},
+		{"pbinom(3, 10, 0.3, lower.tail = FALSE)", 0.35038928159999999},
+		{"dpois(2, 3)", 0.22404180765538775},
+		{"ppois(2, 3)", 0.42319008112684353},
+		{"ppois(2, 3, lower.tail = FALSE)", 0.57680991887315647},
+		{"ppois(10, 2.5, log.p = TRUE)", -6.162880914103087e-05},
+		{"dt(0, 1)", 0.31830988618379069},
+		{"dt(1.5, 3)", 0.12001717451358739},
+		{"pt(2, 5)", 0.94903026058507078},
+		{"pt(-3, 3)", 0.028834442811218653},
+		{"qt(0.975, 10)", 2.2281388519862748},
+		{"qt(0.01, 3)", -4.5407028585681335},
+		{"dchisq(2, 2)", 0.18393972058572117},
+		{"dchisq(3, 5)", 0.15418032980376928},
+		{"pchisq(3, 4)", 0.44217459962892541},
+		{"pchisq(10, 3, lower.tail = FALSE)", 0.018566135463043233},
+		{"qchisq(0.95, 1)", 3.8414588206941258},
+		{"qchisq(0.95, 10)", 18.307038053275146},
+		{"df(1, 3, 4)", 0.3435500312753233},
+		{"pf(3, 2, 10)", 0.904632568359375},
+		{"pf(2.5, 5, 7)", 0.86799377639215924},
+		{"qf(0.95, 2, 10)", 4.1028210151304014},
+		{"qf(0.9, 5, 7)", 2.8833444956782128},
+		{"dbeta(0.3, 2, 3)", 1.764},
+		{"dbeta(0.2, 2.5, 1.5)", 0.40743665431525206},
+		{"pbeta(0.3, 2.5, 1.5)", 0.088943723170665595},
+		{"pbeta(0.9, 2, 3, lower.tail = FALSE)", 0.0037000000000000002},
+		{"qbeta(0.4, 2, 3)", 0.32916650337840786},
+		{"qbeta(0.05, 0.5, 2.5)", 0.00086819920744180348},
+		{"dgamma(2, 3, scale = 2)", 0.091969860292860584},
+		{"dgamma(1.5, 2.5, rate = 2)", 0.38921738663713168},
+		{"pgamma(2, 3, rate = 0.5)", 0.080301397071394193},
+		{"pgamma(4, 2.5, lower.tail = FALSE)", 0.15623562757772233},
+		{"qgamma(0.3, 2.5, rate = 2)", 0.74997703318997655},
+		{"qgamma(0.99, 3)", 8.4059469148854653},
+		{"dexp(1, 2)", 0.2706705664732254},
+		{"pexp(1, 2)", 0.8646647167633873},
+		{"pexp(1, 2, lower.tail = FALSE, log.p = TRUE)", -2},
+		{"pexp(1e-10, 3)", 2.9999999995499998e-10},
+		{"qexp(0.5, 2)", 0.34657359027997264},
+		{"qexp(1e-12)", 1.0000000000005e-12},
+		{"dunif(0.5, 0, 2)", 0.5},
+		{"punif(0.3, 0, 2)", 0.14999999999999999},
+		{"punif(0.3, 0, 2, lower.tail = FALSE)", 0.84999999999999998},
+		{"qunif(0.25, 1, 3)", 1.5},
+		{"gamma(5.5)", 52.342777784553519},
+		{"gamma(0.5)", 1.7724538509055161},
+		{"lgamma(100)", 359.1342053695754},
+		{"lgamma(0.5)", 0.57236494292470008},
+		{"beta(2, 3)", 0.083333333333333329},
+		{"beta(0.5, 1.5)", 1.5707963267948966},
+		{"lbeta(10, 20)", -19.115327299887046},
+		{"lchoose(10, 3)", 4.7874917427820458},
+		{"choose(50, 25)", 126410606437752},
+		{"factorial(20)", 2.43290200817664e+18},
+		{"qnorm(0.5)", 0},
+		{"qnorm(pnorm(-30, log.p = TRUE), log.p = TRUE)", -30},
+		{"pbinom(3, 10, 0.5)", 0.171875},
+		{"dbeta(0.5, 2, 3)", 1.5},
+	}
+
+	for _, tt := range tests {
+		ctx := NewContext()
+		res, err := ctx.EvalString(tt.input)
+		if err != nil {
+			t.Errorf("input %q: unexpected error: %v", tt.input, err)
+			continue
+		}
+		v, ok := res.Value.(*DoubleVec)
+		if !ok || len(v.Data) != 1 || v.Data[0].NA {
+			t.Errorf("input %q: expected a number, got %s", tt.input, res.Value.String())
+			continue
+		}
+		got := v.Data[0].Val
+		if tt.expected == 0 && got != 0 || math.Abs(got-tt.expected) > 1e-12*math.Abs(tt.expected) {
+			t.Errorf("input %q: expected %.17g, got %.17g (relative error %.2g)", tt.input, tt.expected, got, math.Abs(got-tt.expected)/math.Abs(tt.expected))
+		}
+	}
+}
+
+func TestDistributionErrors(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`dnorm("a")`, "Non-numeric argument to mathematical function"},
+		{"dnorm()", `argument "x" is missing, with no default`},
+		{"dgamma(1, 2, rate = 1, scale = 2)", "specify 'rate' or 'scale' but not both"},
+		{"qgamma(0.5, 2, rate = 1, scale = 2)", "specify 'rate' or 'scale' but not both"},
+		{"rgamma(1, 2, rate = 1, scale = 2)", "specify 'rate' or 'scale' but not both"},
+		{"rbeta(1, 2)", `argument "shape2" is missing, with no default`},
+	}
+
+	for _, tt := range tests {
+		ctx := NewContext()
+		_, err := ctx.EvalString(tt.input)
+		if err == nil {
+			t.Errorf("input %q: expected error", tt.input)
+			continue
+		}
+		if err.Error() != tt.expected {
+			t.Errorf("input %q: expected %q, got %q", tt.input, tt.expected, err.Error())
+		}
+	}
+}
@@ -0,0 +1,260 @@
+package rt
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+
+	"simonwaldherr.de/go/smallr/internal/ast"
+	"simonwaldherr.de/go/smallr/internal/parser"
+)
+
+// Set binds name in the global environment to x, converted by ToValue.
+func (ctx *Context) Set(name string, x any) error {
+	v, err := ToValue(x)
+	if err != nil {
+		return err
+	}
+	ctx.Global.SetLocal(name, v)
+	return nil
+}
+
+// Get returns the value of the variable name, converted by FromValue.
+func (ctx *Context) Get(name string) (any, error) {
+	v, ok := ctx.Global.Get(name)
+	if !ok {
+		return nil, fmt.Errorf("object '%s' not found", name)
+	}
+	v, err := Force(ctx, v)
+	if err != nil {
+		return nil, err
+	}
+	return FromValue(v), nil
+}
+
+// Call calls the R function fn with args and returns its result, as
+// lapply and do.call do. fn may be a closure, a builtin, any other
+// Callable, or a character string naming a function in the global
+// environment. A named ArgValue is matched to a parameter by name, the
+// others by position. Output goes to ctx.Output.
+func (ctx *Context) Call(fn Value, args ...ArgValue) (Value, error) {
+	fv, err := Force(ctx, fn)
+	if err != nil {
+		return nil, err
+	}
+	if cv, ok := fv.(*CharVec); ok && cv.Len() == 1 && !cv.Data[0].NA {
+		f, ok := lookupFunction(ctx, ctx.Global, cv.Data[0].Val)
+		if !ok {
+			return nil, fmt.Errorf("could not find function \"%s\"", cv.Data[0].Val)
+		}
+		fv = f
+	}
+	callable, ok := fv.(Callable)
+	if !ok {
+		return nil, fmt.Errorf("attempt to apply non-function")
+	}
+	callArgs := make([]ArgValue, len(args))
+	for i, a := range args {
+		if a.Val == nil {
+			a.Val = NullValue
+		}
+		callArgs[i] = a
+	}
+	return callable.Call(ctx, nil, callArgs)
+}
+
+var (
+	contextType = reflect.TypeFor[*Context]()
+	errorType   = reflect.TypeFor[error]()
+)
+
+// goParam is one parameter of a function registered with RegisterFunc.
+type goParam struct {
+	name string
+	def  ast.Expr // default, nil if there is none
+}
+
+// RegisterFunc makes the Go function fn callable from R as name. The R
+// arguments are matched to fn's parameters like a closure's: by the
+// names given in params, then by position. A parameter spec may carry an
+// R default, as in "n = 10"; defaults are evaluated when the argument is
+// missing and may refer to the parameters before them. Without params the
+// arguments can only be passed by position.
+//
+// Each argument is converted to the type of its parameter with Decode; a
+// Value parameter receives the R value itself. fn may take a *Context as
+// its first parameter and be variadic, in which case extra positional
+// arguments go to the variadic parameter. It may return nothing, a value,
+// an error, or a value and an error; the value is converted with ToValue
+// and a non-nil error becomes an R error.
+func (ctx *Context) RegisterFunc(name string, fn any, params ...string) error {
+	b, err := goBuiltin(name, fn, params)
+	if err != nil {
+		return err
+	}
+	ctx.Global.SetLocal(name, b)
+	ctx.builtins[name] = b
+	return nil
+}
+
+// goBuiltin wraps fn as a builtin, checking its signature up front.
+func goBuiltin(name string, fn any, specs []string) (*BuiltinFunc, error) {
+	rv := reflect.ValueOf(fn)
+	if rv.Kind() != reflect.Func || rv.IsNil() {
+		return nil, fmt.Errorf("RegisterFunc(%q): %T is not a function", name, fn)
+	}
+	ft := rv.Type()
+	first := 0
+	if ft.NumIn() > 0 && ft.In(0) == contextType {
+		first = 1
+	}
+	fixed := ft.NumIn() - first
+	if ft.IsVariadic() {
+		fixed--
+	}
+	if len(specs) > 0 && len(specs) != fixed {
+		return nil, fmt.Errorf("RegisterFunc(%q): %d parameter names for %d parameters", name, len(specs), fixed)
+	}
+	switch ft.NumOut() {
+	case 0, 1:
+	case 2:
+		if ft.Out(1) != errorType {
+			return nil, fmt.Errorf("RegisterFunc(%q): the second result must be an error", name)
+		}
+	default:
+		return nil, fmt.Errorf("RegisterFunc(%q): too many results", name)
+	}
+	params := make([]goParam, fixed)
+	for i, spec := range specs {
+		pname, def, hasDef := strings.Cut(spec, "=")
+		params[i].name = strings.TrimSpace(pname)
+		if params[i].name == "" || params[i].name == "..." {
+			return nil, fmt.Errorf("RegisterFunc(%q): invalid parameter %q", name, spec)
+		}
+		if hasDef {
+			prog, err := parser.New(def).ParseProgram()
+			if err != nil || len(prog.Exprs) != 1 {
+				return nil, fmt.Errorf("RegisterFunc(%q): invalid default for %s", name, params[i].name)
+			}
+			params[i].def = prog.Exprs[0]
+		}
+	}
+	impl := func(ctx *Context, args []ArgValue) (Value, error) {
+		in, err := goArgs(ctx, ft, first, params, args)
+		if err != nil {
+			return nil, err
+		}
+		return callGo(name, rv, in)
+	}
+	return &BuiltinFunc{FnName: name, Impl: impl}, nil
+}
+
+// goArgs matches the R arguments to the parameters of the function type
+// ft and converts them.
+func goArgs(ctx *Context, ft reflect.Type, first int, params []goParam, args []ArgValue) ([]reflect.Value, error) {
+	fargs, err := forceArgs(ctx, args)
+	if err != nil {
+		return nil, err
+	}
+	named := len(params) > 0 && params[0].name != ""
+	bound := make([]Value, len(params))
+	var extra []Value
+	for _, a := range fargs {
+		if a.Name == "" {
+			continue
+		}
+		i := -1
+		for j, p := range params {
+			if named && p.name == a.Name {
+				i = j
+			}
+		}
+		if i < 0 {
+			return nil, fmt.Errorf("unused argument '%s'", a.Name)
+		}
+		if bound[i] != nil {
+			return nil, fmt.Errorf("formal argument '%s' matched by multiple actual arguments", a.Name)
+		}
+		bound[i] = a.Val
+	}
+	pos := 0
+	for _, a := range fargs {
+		if a.Name != "" {
+			continue
+		}
+		for pos < len(bound) && bound[pos] != nil {
+			pos++
+		}
+		switch {
+		case pos < len(bound):
+			bound[pos] = a.Val
+			pos++
+		case ft.IsVariadic():
+			extra = append(extra, a.Val)
+		default:
+			return nil, fmt.Errorf("unused argument (positional)")
+		}
+	}
+
+	var in []reflect.Value
+	if first == 1 {
+		in = append(in, reflect.ValueOf(ctx))
+	}
+	// defaults see the arguments bound so far
+	env := NewEnv(ctx.Global)
+	for i, p := range params {
+		v := bound[i]
+		label := p.name
+		if label == "" {
+			label = fmt.Sprint(i + 1)
+		}
+		if v == nil || v == MissingValue {
+			if p.def == nil {
+				return nil, fmt.Errorf("argument \"%s\" is missing, with no default", label)
+			}
+			if v, err = Eval(ctx, env, p.def); err != nil {
+				return nil, err
+			}
+		}
+		if p.name != "" {
+			env.SetLocal(p.name, v)
+		}
+		arg := reflect.New(ft.In(first + i)).Elem()
+		if err := Decode(v, arg.Addr().Interface()); err != nil {
+			return nil, fmt.Errorf("invalid argument \"%s\": %v", label, err)
+		}
+		in = append(in, arg)
+	}
+	if ft.IsVariadic() {
+		et := ft.In(ft.NumIn() - 1).Elem()
+		for k, v := range extra {
+			arg := reflect.New(et).Elem()
+			if err := Decode(v, arg.Addr().Interface()); err != nil {
+				return nil, fmt.Errorf("invalid argument %d: %v", len(params)+k+1, err)
+			}
+			in = append(in, arg)
+		}
+	}
+	return in, nil
+}
+
+// callGo calls fn and converts its results. A panic in fn is reported as
+// an R error rather than taking down the host program.
+func callGo(name string, fn reflect.Value, in []reflect.Value) (res Value, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			res, err = nil, fmt.Errorf("Go function %s panicked: %v", name, r)
+		}
+	}()
+	out := fn.Call(in)
+	if n := len(out); n > 0 && fn.Type().Out(n-1) == errorType {
+		if e := out[n-1]; !e.IsNil() {
+			return nil, e.Interface().(error)
+		}
+		out = out[:n-1]
+	}
+	if len(out) == 0 {
+		return NullValue, nil
+	}
+	return toValue(out[0])
+}
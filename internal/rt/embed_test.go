@@ -0,0 +1,434 @@
+package rt
+
+import (
+	"errors"
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestToValue(t *testing.T) {
+	x := 2.5
+	var nilInt *int
+	tests := []struct {
+		in       any
+		expected string
+	}{
+		{nil, "NULL"},
+		{true, "TRUE"},
+		{42, "42L"},
+		{uint8(7), "7L"},
+		{1.5, "1.5"},
+		{"a", `"a"`},
+		{complex(1, 2), "1+2i"},
+		{[]float64{1, 2, 3}, "c(1, 2, 3)"},
+		{[]int32{4, 5}, "4:5"},
+		{math.MaxInt32, "2147483647L"},
+		{-math.MaxInt32, "-2147483647L"},
+		{math.MaxInt32 + 1, "2147483648"},
+		{int32(math.MinInt32), "-2147483648"},
+		{int64(1) << 40, "1099511627776"},
+		{uint64(math.MaxUint64), "1.84467440737096e+19"},
+		{[]int{1, math.MaxInt32 + 1}, "c(1, 2147483648)"},
+		{[]uint32{1, math.MaxUint32}, "c(1, 4294967295)"},
+		{[]any{1, int64(math.MinInt32)}, "c(1, -2147483648)"},
+		{[]string{"x", "y"}, `c("x", "y")`},
+		{[3]bool{true, false, true}, "c(TRUE, FALSE, TRUE)"},
+		{&x, "2.5"},
+		{nilInt, "NA_integer_"},
+		{[]*float64{&x, nil}, "c(2.5, NA)"},
+		{[]any{1, nil, 2.5}, "c(1, NA, 2.5)"},
+		{[]any{"a", nil}, `c("a", NA)`},
+		{[]any{1, "a"}, `list(1L, "a")`},
+		{map[string]int{"b": 2, "a": 1}, "list(a = 1L, b = 2L)"},
+		{DoubleScalar(3), "3"},
+	}
+	for _, tt := range tests {
+		v, err := ToValue(tt.in)
+		if err != nil {
+			t.Errorf("ToValue(%#v): unexpected error: %v", tt.in, err)
+			continue
+		}
+		if got := deparseValue(v); got != tt.expected {
+			t.Errorf("ToValue(%#v): expected %s, got %s", tt.in, tt.expected, got)
+		}
+	}
+
+	if _, err := ToValue(make(chan int)); err == nil {
+		t.Errorf("ToValue(chan int): expected error")
+	}
+	if _, err := ToValue(map[int]int{1: 1}); err == nil {
+		t.Errorf("ToValue(map[int]int): expected error")
+	}
+}
+
+func TestFromValue(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected any
+	}{
+		{"NULL", nil},
+		{"TRUE", true},
+		{"3L", 3},
+		{"2.5", 2.5},
+		{`"a"`, "a"},
+		{"NA", nil},
+		{"c(1, NA, 3)", []any{1.0, nil, 3.0}},
+		{`factor(c("u", "v"))`, []any{"u", "v"}},
+		{"list(a = 1, b = list(2L, \"x\"))", map[string]any{"a": 1.0, "b": []any{2, "x"}}},
+		{"list(1, 2)", []any{1.0, 2.0}},
+	}
+	for _, tt := range tests {
+		ctx := NewContext()
+		res, err := ctx.EvalString(tt.input)
+		if err != nil {
+			t.Fatalf("input %q: %v", tt.input, err)
+		}
+		if got := FromValue(res.Value); !reflect.DeepEqual(got, tt.expected) {
+			t.Errorf("input %q: expected %#v, got %#v", tt.input, tt.expected, got)
+		}
+	}
+}
+
+func TestDecode(t *testing.T) {
+	ctx := NewContext()
+	eval := func(src string) Value {
+		res, err := ctx.EvalString(src)
+		if err != nil {
+			t.Fatalf("%s: %v", src, err)
+		}
+		return res.Value
+	}
+
+	var xs []float64
+	if err := Decode(eval("c(1, NA, 3)"), &xs); err != nil || xs[0] != 1 || !math.IsNaN(xs[1]) || xs[2] != 3 {
+		t.Errorf("[]float64: got %v, %v", xs, err)
+	}
+	var ps []*int
+	if err := Decode(eval("c(1L, NA)"), &ps); err != nil || *ps[0] != 1 || ps[1] != nil {
+		t.Errorf("[]*int: got %v, %v", ps, err)
+	}
+	var m map[string]int
+	if err := Decode(eval("c(a = 1, b = 2)"), &m); err != nil || !reflect.DeepEqual(m, map[string]int{"a": 1, "b": 2}) {
+		t.Errorf("map[string]int: got %v, %v", m, err)
+	}
+	var nested map[string][]string
+	if err := Decode(eval(`list(x = c("p", "q"), y = "r")`), &nested); err != nil || !reflect.DeepEqual(nested, map[string][]string{"x": {"p", "q"}, "y": {"r"}}) {
+		t.Errorf("map[string][]string: got %v, %v", nested, err)
+	}
+	var v Value
+	if err := Decode(eval("1:2"), &v); err != nil || v.Type() != "integer" {
+		t.Errorf("Value: got %v, %v", v, err)
+	}
+
+	errs := []struct {
+		src      string
+		dst      any
+		expected string
+	}{
+		{"NA_integer_", new(int), "cannot decode NA into int"},
+		{"1.5", new(int), "cannot decode 1.5 into int"},
+		{"300", new(int8), "cannot decode 300 into int8"},
+		{"1:3", new(int), "cannot decode integer of length 3 into int"},
+		{`c("a", "b")`, new([]float64), "element 1: cannot decode string into float64"},
+		{"1:2", new(map[string]int), "cannot decode integer without names into map[string]int"},
+	}
+	for _, tt := range errs {
+		err := Decode(eval(tt.src), tt.dst)
+		if err == nil || err.Error() != tt.expected {
+			t.Errorf("Decode(%s, %T): expected %q, got %v", tt.src, tt.dst, tt.expected, err)
+		}
+	}
+	if err := Decode(eval("1"), 0); err == nil {
+		t.Errorf("Decode into a non-pointer: expected error")
+	}
+}
+
+func TestTimeConversion(t *testing.T) {
+	ctx := NewContext()
+	when := time.Date(2024, 3, 1, 12, 30, 0, 0, time.UTC)
+	if err := ctx.Set("t", when); err != nil {
+		t.Fatal(err)
+	}
+	res, err := ctx.EvalString(`c(class(t), attr(t, "tzone"))`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := res.Value.String(); got != `"POSIXct" "POSIXt" "UTC"` {
+		t.Errorf("class of a time: got %s", got)
+	}
+	res, err = ctx.EvalString(`structure(as.numeric(t) + 3600, class = class(t), tzone = "UTC")`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var later time.Time
+	if err := Decode(res.Value, &later); err != nil || !later.Equal(when.Add(time.Hour)) {
+		t.Errorf("decoded time: got %v, %v", later, err)
+	}
+	got, err := ctx.Get("t")
+	if err != nil || got != when {
+		t.Errorf("Get(t): got %v, %v", got, err)
+	}
+	res, err = ctx.EvalString(`structure(19782, class = "Date")`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var day time.Time
+	if err := Decode(res.Value, &day); err != nil || !day.Equal(time.Date(2024, 2, 29, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("decoded Date: got %v, %v", day, err)
+	}
+}
+
+func TestSetGet(t *testing.T) {
+	ctx := NewContext()
+	if err := ctx.Set("x", []float64{1, 2, 3}); err != nil {
+		t.Fatal(err)
+	}
+	if err := ctx.Set("cfg", map[string]any{"n": 2, "label": "k"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ctx.EvalString(`y <- sum(x) * cfg$n; z <- paste(cfg$label, y)`); err != nil {
+		t.Fatal(err)
+	}
+	if y, err := ctx.Get("y"); err != nil || y != 12.0 {
+		t.Errorf("Get(y): got %v, %v", y, err)
+	}
+	if z, err := ctx.Get("z"); err != nil || z != "k 12" {
+		t.Errorf("Get(z): got %v, %v", z, err)
+	}
+	if _, err := ctx.Get("nope"); err == nil || err.Error() != "object 'nope' not found" {
+		t.Errorf("Get(nope): got %v", err)
+	}
+	if err := ctx.Set("bad", make(chan int)); err == nil {
+		t.Errorf("Set(chan): expected error")
+	}
+}
+
+func TestRegisterFunc(t *testing.T) {
+	ctx := NewContext()
+	must := func(err error) {
+		t.Helper()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	must(ctx.RegisterFunc("add", func(a, b float64) float64 { return a + b }, "a", "b = 10"))
+	must(ctx.RegisterFunc("scaled", func(x []float64, by float64) []float64 {
+		out := make([]float64, len(x))
+		for i, v := range x {
+			out[i] = v * by
+		}
+		return out
+	}, "x", "by = length(x)"))
+	must(ctx.RegisterFunc("greet", func(name *string) string {
+		if name == nil {
+			return "hello, nobody"
+		}
+		return "hello, " + *name
+	}, "name"))
+	must(ctx.RegisterFunc("total", func(xs ...int) int {
+		s := 0
+		for _, x := range xs {
+			s += x
+		}
+		return s
+	}))
+	must(ctx.RegisterFunc("check", func(ok bool) (string, error) {
+		if !ok {
+			return "", errors.New("check failed")
+		}
+		return "fine", nil
+	}, "ok"))
+	must(ctx.RegisterFunc("kind", func(ctx *Context, v Value) string { return v.Type() }, "v"))
+	must(ctx.RegisterFunc("boom", func() { panic("oops") }))
+
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"add(1, 2)", "3"},
+		{"add(1)", "11"},
+		{"add(b = 1, 5)", "6"},
+		{"scaled(c(1, 2))", "2 4"},
+		{"scaled(by = 3, x = 1:2)", "3 6"},
+		{`greet("ann")`, `"hello, ann"`},
+		{"greet(NA)", `"hello, nobody"`},
+		{"total(1, 2, 3)", "6"},
+		{"total()", "0"},
+		{"check(TRUE)", `"fine"`},
+		{`tryCatch(check(FALSE), error = function(e) conditionMessage(e))`, `"check failed"`},
+		{"kind(list())", `"list"`},
+		{"sapply(1:3, add, b = 1)", "2 3 4"},
+	}
+	for _, tt := range tests {
+		res, err := ctx.EvalString(tt.input)
+		if err != nil {
+			t.Errorf("input %q: unexpected error: %v", tt.input, err)
+			continue
+		}
+		if got := res.Value.String(); got != tt.expected {
+			t.Errorf("input %q: expected %s, got %s", tt.input, tt.expected, got)
+		}
+	}
+
+	errs := []struct {
+		input    string
+		expected string
+	}{
+		{"add()", `argument "a" is missing, with no default`},
+		{"add(1, c = 2)", "unused argument 'c'"},
+		{"add(1, 2, 3)", "unused argument (positional)"},
+		{`add("a")`, `invalid argument "a": cannot decode string into float64`},
+		{"check(FALSE)", "check failed"},
+		{"boom()", "Go function boom panicked: oops"},
+		{"total(1, 2.5)", "invalid argument 2: cannot decode 2.5 into int"},
+	}
+	for _, tt := range errs {
+		_, err := ctx.EvalString(tt.input)
+		if err == nil {
+			t.Errorf("input %q: expected error", tt.input)
+			continue
+		}
+		if !strings.Contains(err.Error(), tt.expected) {
+			t.Errorf("input %q: expected %q, got %q", tt.input, tt.expected, err.Error())
+		}
+	}
+
+	bad := []struct {
+		fn     any
+		params []string
+	}{
+		{42, nil},
+		{func(a int) int { return a }, []string{"a", "b"}},
+		{func() (int, int) { return 0, 0 }, nil},
+		{func(a int) {}, []string{"a = ("}},
+	}
+	for _, tt := range bad {
+		if err := ctx.RegisterFunc("f", tt.fn, tt.params...); err == nil {
+			t.Errorf("RegisterFunc(%T, %v): expected error", tt.fn, tt.params)
+		}
+	}
+}
+
+func TestCall(t *testing.T) {
+	ctx := NewContext()
+	if _, err := ctx.EvalString(`f <- function(x, by = 2, ...) x * by + length(list(...))
+handlers <- list()
+on <- function(event, fn) handlers[[event]] <<- fn
+on("tick", function(n) n + 1)`); err != nil {
+		t.Fatal(err)
+	}
+	f, _ := ctx.Global.Get("f")
+	tests := []struct {
+		fn       Value
+		args     []ArgValue
+		expected string
+	}{
+		{f, []ArgValue{{Val: IntScalar(3)}}, "6"},
+		{f, []ArgValue{{Name: "by", Val: DoubleScalar(10)}, {Val: DoubleScalar(1)}}, "10"},
+		{f, []ArgValue{{Val: DoubleScalar(1)}, {Val: DoubleScalar(1)}, {Val: NullValue}, {Name: "z"}}, "3"},
+		{CharScalar("f"), []ArgValue{{Val: DoubleScalar(4)}}, "8"},
+		{CharScalar("paste"), []ArgValue{{Val: CharScalar("a")}, {Name: "sep", Val: CharScalar("-")}, {Val: CharScalar("b")}}, `"a-b"`},
+	}
+	for _, tt := range tests {
+		v, err := ctx.Call(tt.fn, tt.args...)
+		if err != nil {
+			t.Errorf("Call(%s): %v", tt.fn.String(), err)
+			continue
+		}
+		if got := v.String(); got != tt.expected {
+			t.Errorf("Call(%s): expected %s, got %s", tt.fn.String(), tt.expected, got)
+		}
+	}
+
+	// a handler registered by the script, called from Go
+	handlers, _ := ctx.Global.Get("handlers")
+	tick := handlers.(*ListVec).Data[0]
+	if v, err := ctx.Call(tick, ArgValue{Val: IntScalar(41)}); err != nil || v.String() != "42" {
+		t.Errorf("handler: expected 42, got %v, %v", v, err)
+	}
+
+	errs := []struct {
+		fn       Value
+		expected string
+	}{
+		{DoubleScalar(1), "attempt to apply non-function"},
+		{CharScalar("nope"), `could not find function "nope"`},
+	}
+	for _, tt := range errs {
+		if _, err := ctx.Call(tt.fn); err == nil || !strings.Contains(err.Error(), tt.expected) {
+			t.Errorf("Call(%s): expected %q, got %v", tt.fn.String(), tt.expected, err)
+		}
+	}
+	stop, _ := ctx.EvalString(`function() stop("no")`)
+	var re *RuntimeError
+	if _, err := ctx.Call(stop.Value); !errors.As(err, &re) || re.Msg != "no" {
+		t.Errorf("stop: expected a RuntimeError, got %v", err)
+	}
+}
+
+// goSum is a Go implementation of Callable: it adds up its arguments.
+type goSum struct {
+	Base
+	calls int
+}
+
+func (g *goSum) Type() string   { return "function" }
+func (g *goSum) Len() int       { return 1 }
+func (g *goSum) String() string { return "<goSum>" }
+func (g *goSum) Name() string   { return "goSum" }
+
+func (g *goSum) Call(ctx *Context, caller *Env, args []ArgValue) (Value, error) {
+	g.calls++
+	total := 0.0
+	for _, a := range args {
+		v, err := Force(ctx, a.Val)
+		if err != nil {
+			return nil, err
+		}
+		for _, f := range toFloats(v) {
+			total += f
+		}
+	}
+	return DoubleScalar(total), nil
+}
+
+func TestGoCallable(t *testing.T) {
+	ctx := NewContext()
+	sum := &goSum{}
+	if err := ctx.Set("gsum", sum); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"gsum(1, 2)", "3"},
+		{"sapply(1:3, gsum, 10)", "11 12 13"},
+		{"Reduce(gsum, 1:4)", "10"},
+		{"do.call(gsum, list(1, 2, 3))", "6"},
+		{"Map(gsum, 1:2, 3:4)[[2]]", "6"},
+		{"length(Filter(function(v) gsum(v, v) > 2, 1:3))", "2"},
+		{"(function(f, x) f(x, 1))(gsum, 5)", "6"},
+		{"is.function(gsum)", "TRUE"},
+		{"class(gsum)", `"function"`},
+	}
+	for _, tt := range tests {
+		res, err := ctx.EvalString(tt.input)
+		if err != nil {
+			t.Errorf("input %q: unexpected error: %v", tt.input, err)
+			continue
+		}
+		if got := res.Value.String(); got != tt.expected {
+			t.Errorf("input %q: expected %s, got %s", tt.input, tt.expected, got)
+		}
+	}
+	if v, err := ctx.Call(sum, ArgValue{Val: DoubleScalar(2)}); err != nil || v.String() != "2" {
+		t.Errorf("Call: expected 2, got %v, %v", v, err)
+	}
+	if sum.calls == 0 {
+		t.Errorf("goSum was never called")
+	}
+}
@@ -1,8 +1,25 @@
 package rt
 
+import (
+	"fmt"
+	"sort"
+)
+
 type Env struct {
 	parent *Env
 	vars   map[string]Value
+	name   string // set for the global and the empty environment
+}
+
+// emptyEnv is the environment returned by emptyenv(). Nothing is ever
+// bound in it: it is shared by all Contexts, so the methods that bind or
+// remove variables leave it alone, and the R-level assignments report
+// errEmptyEnv.
+var emptyEnv = &Env{vars: map[string]Value{}, name: "R_EmptyEnv"}
+
+// errEmptyEnv is the error for an assignment into the empty environment.
+func errEmptyEnv() error {
+	return fmt.Errorf("cannot assign values in the empty environment")
 }
 
 func NewEnv(parent *Env) *Env {
@@ -28,12 +45,35 @@ func (e *Env) GetLocal(name string) (Value, bool) {
 }
 
 func (e *Env) SetLocal(name string, v Value) {
+	if e == emptyEnv {
+		return
+	}
 	e.vars[name] = v
 }
 
+// Remove deletes a local binding and reports whether it existed.
+func (e *Env) Remove(name string) bool {
+	if e == emptyEnv {
+		return false
+	}
+	_, ok := e.vars[name]
+	delete(e.vars, name)
+	return ok
+}
+
+// Names returns the names bound locally in e, sorted.
+func (e *Env) Names() []string {
+	names := make([]string, 0, len(e.vars))
+	for n := range e.vars {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	return names
+}
+
 func (e *Env) Assign(name string, v Value) {
 	// R '<-' assigns in current environment by default.
-	e.vars[name] = v
+	e.SetLocal(name, v)
 }
 
 func (e *Env) AssignSuper(name string, v Value) {
@@ -46,8 +86,8 @@ func (e *Env) AssignSuper(name string, v Value) {
 	}
 	// fallback: assign in the topmost env
 	top := e
-	for top.parent != nil {
+	for top.parent != nil && top.parent != emptyEnv {
 		top = top.parent
 	}
-	top.vars[name] = v
+	top.SetLocal(name, v)
 }
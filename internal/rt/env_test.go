@@ -0,0 +1,153 @@
+package rt
+
+import "testing"
+
+func TestEnvironments(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"e <- new.env(); e$a <- 1; e[[\"b\"]] <- 2; assign(\"c\", 3, envir = e); ls(e)", `"a" "b" "c"`},
+		{"e <- new.env(); assign(\"x\", 10, envir = e); get(\"x\", envir = e) + e$x + e[[\"x\"]]", "30"},
+		{"e <- new.env(); e$missing", "NULL"},
+		{"e1 <- new.env(); e2 <- e1; e2$x <- 1; e1$x", "1"},
+		{"e <- new.env(); f <- function(env) env$n <- 5; f(e); e$n", "5"},
+		{"cache <- new.env(); memo <- function(k) { if (!is.null(cache[[k]])) return(\"hit\"); cache[[k]] <- TRUE; \"miss\" }; c(memo(\"a\"), memo(\"a\"), memo(\"b\"))", `"miss" "hit" "miss"`},
+		{"e <- new.env(); e$x <- 1; length(e)", "1"},
+		{"is.environment(globalenv())", "TRUE"},
+		{"class(new.env())", `"environment"`},
+		{"globalenv()", "<environment: R_GlobalEnv>"},
+		{"emptyenv()", "<environment: R_EmptyEnv>"},
+		{"identical(parent.env(new.env()), globalenv())", "TRUE"},
+		{"identical(environment(), globalenv())", "TRUE"},
+		// lookup with envir= and inherits=
+		{"x <- 1; e <- new.env(); exists(\"x\", envir = e)", "TRUE"},
+		{"x <- 1; e <- new.env(); exists(\"x\", envir = e, inherits = FALSE)", "FALSE"},
+		{"e <- new.env(parent = emptyenv()); exists(\"sum\", envir = e)", "FALSE"},
+		{"x <- 1; e <- new.env(); get0(\"y\", envir = e, ifnotfound = \"none\")", `"none"`},
+		{"sum <- 3; get(\"sum\", mode = \"function\")(1, 2)", "3"},
+		{"x <- 1; f <- function() { assign(\"x\", 2, inherits = TRUE) }; f(); x", "2"},
+		{"f <- function() { assign(\"z\", 1); z }; f()", "1"},
+		{"a <- 1; b <- 2; .hidden <- 3; ls()", `"a" "b"`},
+		{"a <- 1; .hidden <- 3; ls(all.names = TRUE)", `".hidden" "a"`},
+		{"a <- 1; b <- 2; rm(a, \"b\"); c(exists(\"a\"), exists(\"b\"))", "FALSE FALSE"},
+		{"e <- new.env(); e$a <- 1; rm(list = \"a\", envir = e); length(ls(e))", "0"},
+		{"f <- function() { v <- 1; ls() }; f()", `"v"`},
+		{"ls(new.env())", "character(0)"},
+		{"ls(emptyenv())", "character(0)"},
+		{"f <- function() ls(); f()", "character(0)"},
+		{"c(integer(0), numeric(0))", "numeric(0)"},
+		{"logical(0)", "logical(0)"},
+		// closures and frames
+		{"make <- function() { v <- 42; function() v }; h <- make(); environment(h)$v", "42"},
+		{"f <- function() x; e <- new.env(); e$x <- \"from e\"; environment(f) <- e; f()", `"from e"`},
+		{"counter <- local({ n <- 0; function() { n <<- n + 1; n } }); counter(); counter()", "2"},
+		{"x <- 1; local({ x <- 2 }); x", "1"},
+		{"e <- new.env(); local(y <- 5, envir = e); e$y", "5"},
+		{"x <- 5; f <- function() { x <- 1; g <- function() parent.frame(); get(\"x\", envir = g()) }; f()", "1"},
+		{"f <- function() parent.frame(); identical(f(), globalenv())", "TRUE"},
+		{"setter <- function(name, value) assign(name, value, envir = parent.frame()); f <- function() { setter(\"k\", 9); k }; f()", "9"},
+		{"f <- function(n) if (n <= 1) 1 else n * sys.function()(n - 1); f(5)", "120"},
+	}
+
+	for _, tt := range tests {
+		ctx := NewContext()
+		res, err := ctx.EvalString(tt.input)
+		if err != nil {
+			t.Errorf("input %q: unexpected error: %v", tt.input, err)
+			continue
+		}
+		if got := ctx.SprintValue(res.Value); got != tt.expected {
+			t.Errorf("input %q: expected %s, got %s", tt.input, tt.expected, got)
+		}
+	}
+}
+
+func TestEnvironmentErrors(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"get(\"nope\")", "object 'nope' not found"},
+		{"x <- 1; e <- new.env(); get(\"x\", envir = e, inherits = FALSE)", "object 'x' not found"},
+		{"assign(\"a\", 1, envir = emptyenv())", "cannot assign values in the empty environment"},
+		{"e <- new.env(); e[1]", "object of type 'environment' is not subsettable"},
+		{"get(\"x\", envir = 1)", "invalid 'envir' argument"},
+		{"local(x <- 1, envir = emptyenv())", "cannot assign values in the empty environment"},
+		{"eval(quote(z <- 1), emptyenv())", "cannot assign values in the empty environment"},
+		{"eval(quote(z <<- 1), emptyenv())", "cannot assign values in the empty environment"},
+		{"e <- emptyenv(); e$z <- 1", "cannot assign values in the empty environment"},
+	}
+
+	for _, tt := range tests {
+		ctx := NewContext()
+		_, err := ctx.EvalString(tt.input)
+		if err == nil {
+			t.Errorf("input %q: expected error", tt.input)
+			continue
+		}
+		if err.Error() != tt.expected {
+			t.Errorf("input %q: expected error %q, got %q", tt.input, tt.expected, err.Error())
+		}
+	}
+}
+
+func TestEmptyEnvIsolation(t *testing.T) {
+	// emptyenv() is shared by all contexts, so nothing may end up in it
+	a, b := NewContext(), NewContext()
+	a.EvalString(`local(x <- "from A", envir = emptyenv())`)
+	a.EvalString(`eval(quote(y <- "from A"), emptyenv())`)
+	emptyEnv.SetLocal("z", CharScalar("from A"))
+	res, err := b.EvalString(`c(exists("x", envir = emptyenv()), exists("y", envir = emptyenv()), exists("z", envir = emptyenv()))`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := res.Value.String(); got != "FALSE FALSE FALSE" {
+		t.Errorf("bindings leaked through emptyenv(): %s", got)
+	}
+	if len(emptyEnv.vars) != 0 {
+		t.Errorf("emptyenv() has bindings %v", emptyEnv.Names())
+	}
+}
+
+func TestOnExit(t *testing.T) {
+	tests := []struct {
+		input    string
+		output   string
+		expected string
+	}{
+		{"f <- function() { on.exit(cat(\"bye\\n\")); cat(\"hi\\n\"); 1 }; f()", "hi\nbye\n", "1"},
+		{"f <- function() { on.exit(cat(\"exit\\n\")); return(2); 3 }; f()", "exit\n", "2"},
+		{"f <- function() { on.exit(cat(\"a\")); on.exit(cat(\"b\")); 0 }; f()", "b", "0"},
+		{"f <- function() { on.exit(cat(\"a\")); on.exit(cat(\"b\"), add = TRUE); on.exit(cat(\"c\"), add = TRUE, after = FALSE); 0 }; f()", "cab", "0"},
+		{"f <- function() { on.exit(cat(\"cleanup\\n\")); stop(\"fail\") }; tryCatch(f(), error = function(e) conditionMessage(e))", "cleanup\n", `"fail"`},
+		{"f <- function() { x <- 1; on.exit(x <- 99); x }; f()", "", "1"},
+		{"opt <- 1; f <- function() { old <- opt; opt <<- 2; on.exit(opt <<- old); opt }; c(f(), opt)", "", "2 1"},
+		{"f <- function() { on.exit(cat(\"x\")); on.exit(); 0 }; f()", "", "0"},
+		{"f <- function(n) { on.exit(cat(n)); if (n > 0) f(n - 1); 0 }; f(2)", "012", "0"},
+		{"f <- function() { on.exit(cat(\"x\")); sys.on.exit() }; is.null(f())", "x", "FALSE"},
+		{"f <- function() sys.on.exit(); f()", "", "NULL"},
+		{"f <- function() { on.exit(stop(\"in exit\")); 1 }; tryCatch(f(), error = function(e) conditionMessage(e))", "", `"in exit"`},
+		{"f <- function() { on.exit(return(5)); 1 }; f()", "", "5"},
+		{"f <- function() { on.exit(return(5)); return(1) }; f()", "", "5"},
+		{"f <- function() { on.exit(return(invisible(5))); 1 }; f() + 1", "", "6"},
+		{"f <- function() { on.exit(return(1)); on.exit(cat(\"b\"), add = TRUE); on.exit(return(2), add = TRUE); 0 }; f()", "b", "2"},
+		{"f <- function() { on.exit(return(5)); stop(\"fail\") }; tryCatch(f(), error = function(e) conditionMessage(e))", "", `"fail"`},
+		{"f <- function() { on.exit(if (FALSE) return(5)); 1 }; f()", "", "1"},
+	}
+
+	for _, tt := range tests {
+		ctx := NewContext()
+		res, err := ctx.EvalString(tt.input)
+		if err != nil {
+			t.Errorf("input %q: unexpected error: %v", tt.input, err)
+			continue
+		}
+		if res.Output != tt.output {
+			t.Errorf("input %q: expected output %q, got %q", tt.input, tt.output, res.Output)
+		}
+		if res.Value.String() != tt.expected {
+			t.Errorf("input %q: expected %s, got %s", tt.input, tt.expected, res.Value.String())
+		}
+	}
+}
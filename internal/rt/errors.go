@@ -0,0 +1,178 @@
+package rt
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"simonwaldherr.de/go/smallr/internal/ast"
+	"simonwaldherr.de/go/smallr/internal/deparse"
+	"simonwaldherr.de/go/smallr/internal/token"
+)
+
+// RuntimeError is an error raised while evaluating R code. It records
+// where evaluation failed and which closures were active at the time.
+// Error returns the bare message, as conditionMessage() does; Report
+// renders it the way R prints uncaught errors.
+type RuntimeError struct {
+	Msg    string
+	Call   ast.Expr     // call the error is reported for, nil at top level
+	Pos    token.Pos    // position of the expression that failed
+	Script string       // name of the script, empty for code given as text
+	Stack  []StackEntry // active closure calls, innermost first
+	Err    error        // the underlying error
+}
+
+// StackEntry is one closure call on the stack of a RuntimeError. Call is
+// nil for closures invoked by builtins such as lapply().
+type StackEntry struct {
+	Call ast.Expr
+	Pos  token.Pos
+}
+
+func (e *RuntimeError) Error() string { return e.Msg }
+
+func (e *RuntimeError) Unwrap() error { return e.Err }
+
+// Report renders the error like R does for an uncaught error, followed by
+// the source position: Error in f(x) : msg (script.R:12:5).
+func (e *RuntimeError) Report() string {
+	var b strings.Builder
+	if e.Call != nil {
+		b.WriteString("Error in " + firstLine(deparse.Expr(e.Call)) + " : ")
+	} else {
+		b.WriteString("Error: ")
+	}
+	b.WriteString(e.Msg)
+	if e.Pos.Line > 0 {
+		b.WriteString(" (" + e.Location() + ")")
+	}
+	return b.String()
+}
+
+// Location returns script:line:col of the failing expression.
+func (e *RuntimeError) Location() string {
+	return fmt.Sprintf("%s:%d:%d", e.scriptName(), e.Pos.Line, e.Pos.Col)
+}
+
+// Traceback returns the calls on the stack, innermost first, numbered
+// the way traceback() prints them.
+func (e *RuntimeError) Traceback() []string {
+	out := make([]string, len(e.Stack))
+	for i, s := range e.Stack {
+		call := "<anonymous>"
+		if s.Call != nil {
+			call = firstLine(deparse.Expr(s.Call))
+		}
+		out[i] = fmt.Sprintf("%d: %s", len(e.Stack)-i, call)
+		if s.Pos.Line > 0 {
+			out[i] += fmt.Sprintf(" at %s:%d", e.scriptName(), s.Pos.Line)
+		}
+	}
+	return out
+}
+
+func (e *RuntimeError) scriptName() string {
+	if e.Script == "" {
+		return "<text>"
+	}
+	return e.Script
+}
+
+func firstLine(s string) string {
+	if i := strings.IndexByte(s, '\n'); i >= 0 {
+		return s[:i]
+	}
+	return s
+}
+
+// locate turns err into a RuntimeError raised at expr. The innermost
+// expression sees the error first; outer expressions pass it on unchanged.
+// Control flow, unwinding to handlers and restarts are left alone.
+func (ctx *Context) locate(err error, env *Env, expr ast.Expr) error {
+	var re *RuntimeError
+	if errors.As(err, &re) {
+		return err
+	}
+	if _, ok := errorCondition(err); !ok {
+		return err
+	}
+	re = &RuntimeError{Msg: err.Error(), Pos: startPos(expr), Stack: ctx.callStack(), Err: err}
+	var ce *ConditionError
+	switch {
+	case errors.As(err, &ce):
+		if call, ok := conditionCallOf(ce.Cond).(*ExprValue); ok {
+			re.Call = call.Expr
+		}
+	case isIdent(expr):
+		// a failed lookup is reported for the function it happened in
+		if fr := ctx.frameFor(env); fr != nil && fr.Call != nil {
+			re.Call = fr.Call
+		}
+	default:
+		re.Call = expr
+	}
+	return re
+}
+
+// startPos returns where the source of e begins. Calls, operators and
+// indexing record the position of their operator token.
+func startPos(e ast.Expr) token.Pos {
+	for {
+		switch x := e.(type) {
+		case *ast.CallExpr:
+			e = x.Fun
+		case *ast.BinaryExpr:
+			e = x.Left
+		case *ast.AssignExpr:
+			e = x.Left
+		case *ast.IndexExpr:
+			e = x.X
+		case *ast.DollarExpr:
+			e = x.X
+		default:
+			return e.Pos()
+		}
+	}
+}
+
+func isIdent(e ast.Expr) bool {
+	_, ok := e.(*ast.Ident)
+	return ok
+}
+
+// callStack returns the active closure calls, innermost first.
+func (ctx *Context) callStack() []StackEntry {
+	out := make([]StackEntry, 0, len(ctx.frames))
+	for i := len(ctx.frames) - 1; i >= 0; i-- {
+		s := StackEntry{}
+		if call := ctx.frames[i].Call; call != nil {
+			s.Call, s.Pos = call, startPos(call)
+		}
+		out = append(out, s)
+	}
+	return out
+}
+
+// builtinTraceback prints the call stack of the last uncaught error and
+// returns it invisibly as a list of deparsed calls, innermost first.
+func builtinTraceback(ctx *Context, args []ArgValue) (Value, error) {
+	if ctx.lastError == nil || len(ctx.lastError.Stack) == 0 {
+		fmt.Fprintln(ctx.Output, "No traceback available")
+		ctx.invisible = true
+		return NullValue, nil
+	}
+	lines := ctx.lastError.Traceback()
+	out := &ListVec{Data: make([]Value, len(lines))}
+	for i, line := range lines {
+		fmt.Fprintln(ctx.Output, line)
+		call := "<anonymous>"
+		if c := ctx.lastError.Stack[i].Call; c != nil {
+			call = deparse.Expr(c)
+		}
+		out.Data[i] = CharScalar(call)
+	}
+	// like R, the stack is printed and the calls returned invisibly
+	ctx.invisible = true
+	return out, nil
+}
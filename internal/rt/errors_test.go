@@ -0,0 +1,134 @@
+package rt
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestRuntimeErrors(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"x + 1", "Error: object 'x' not found (<text>:1:1)"},
+		{"f <- function() nothere\nf()", "Error in f() : object 'nothere' not found (<text>:1:17)"},
+		{"f <- function(a) log(a)\nf(\"x\")", "Error in log(a) : cannot coerce character to double (<text>:1:18)"},
+		{"f <- function(x) {\n  if (x > 1) stop(\"too big\")\n}\nf(5)", "Error in f(5) : too big (<text>:2:14)"},
+		{"stop(\"plain\")", "Error: plain (<text>:1:1)"},
+		{"f <- function() stop(\"quiet\", call. = FALSE); f()", "Error: quiet (<text>:1:17)"},
+		{"f <- function(a) a; f(1, 2)", "Error in f(1, 2) : unused argument (positional) (<text>:1:21)"},
+		{"g <- function() {\n  1 +\n    \"a\"\n}\ng()", "Error in 1 + \"a\" : cannot coerce character to double (<text>:2:3)"},
+	}
+
+	for _, tt := range tests {
+		ctx := NewContext()
+		res, err := ctx.EvalString(tt.input)
+		if err == nil {
+			t.Errorf("input %q: expected error", tt.input)
+			continue
+		}
+		if res.Error == nil {
+			t.Errorf("input %q: expected a RuntimeError, got %T", tt.input, err)
+			continue
+		}
+		if got := res.Error.Report(); got != tt.expected {
+			t.Errorf("input %q: expected %q, got %q", tt.input, tt.expected, got)
+		}
+	}
+}
+
+func TestRuntimeErrorDetails(t *testing.T) {
+	ctx := NewContext()
+	src := "f <- function(x) g(x)\ng <- function(y) {\n  stop(\"deep\")\n}\nf(1)\n"
+	res, err := ctx.EvalScript("script.R", src)
+	var re *RuntimeError
+	if !errors.As(err, &re) || re != res.Error {
+		t.Fatalf("expected the RuntimeError on EvalResult, got %v", err)
+	}
+	if err.Error() != "deep" {
+		t.Errorf("expected message %q, got %q", "deep", err.Error())
+	}
+	if got := re.Report(); got != "Error in g(x) : deep (script.R:3:3)" {
+		t.Errorf("unexpected report %q", got)
+	}
+	want := []string{"2: g(x) at script.R:1", "1: f(1) at script.R:5"}
+	if got := re.Traceback(); strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("expected traceback %q, got %q", want, got)
+	}
+	var ce *ConditionError
+	if !errors.As(err, &ce) {
+		t.Errorf("expected the stop() condition to be wrapped")
+	}
+
+	res, err = ctx.EvalString("tb <- traceback(); c(tb[[1]], tb[[2]])")
+	if err != nil {
+		t.Fatalf("traceback: unexpected error: %v", err)
+	}
+	if res.Output != "2: g(x) at script.R:1\n1: f(1) at script.R:5\n" {
+		t.Errorf("unexpected traceback output %q", res.Output)
+	}
+	if res.Value.String() != `"g(x)" "f(1)"` {
+		t.Errorf("unexpected traceback value %s", res.Value.String())
+	}
+	if res.Invisible {
+		t.Errorf("c(...) after traceback(): expected a visible value")
+	}
+
+	// the REPL shows the stack once: printed, and returned invisibly
+	res, err = ctx.EvalString("traceback()")
+	if err != nil || !res.Invisible || res.Output != "2: g(x) at script.R:1\n1: f(1) at script.R:5\n" {
+		t.Errorf("traceback(): expected the printed stack and an invisible value, got %q, %v, %v", res.Output, res.Invisible, err)
+	}
+}
+
+func TestInvisible(t *testing.T) {
+	tests := []struct {
+		input     string
+		invisible bool
+	}{
+		{"invisible(1)", true},
+		{"invisible()", true},
+		{"f <- function() invisible(7); f()", true},
+		{"f <- function() { on.exit(g <- 1 + 1); invisible(7) }; f()", true},
+		{"f <- function() { invisible(1); 2 }; f()", false},
+		{"invisible(1); 2", false},
+		{"x <- invisible(3); x", false},
+		{"abs(invisible(-1)) + 1", false},
+	}
+	for _, tt := range tests {
+		res, err := NewContext().EvalString(tt.input)
+		if err != nil {
+			t.Errorf("input %q: unexpected error: %v", tt.input, err)
+			continue
+		}
+		if res.Invisible != tt.invisible {
+			t.Errorf("input %q: expected Invisible %v, got %v", tt.input, tt.invisible, res.Invisible)
+		}
+	}
+}
+
+func TestConditionCallFromRuntimeError(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`tryCatch(log("a"), error = function(e) deparse(conditionCall(e)))`, `"log(\"a\")"`},
+		{`f <- function() undefined_var; tryCatch(f(), error = function(e) deparse(conditionCall(e)))`, `"f()"`},
+		{`tryCatch(undefined_var, error = function(e) is.null(conditionCall(e)))`, "TRUE"},
+		{`traceback()`, "NULL"},
+		{`try(stop("caught"), silent = TRUE); traceback()`, "NULL"},
+	}
+
+	for _, tt := range tests {
+		ctx := NewContext()
+		res, err := ctx.EvalString(tt.input)
+		if err != nil {
+			t.Errorf("input %q: unexpected error: %v", tt.input, err)
+			continue
+		}
+		if res.Value.String() != tt.expected {
+			t.Errorf("input %q: expected %s, got %s", tt.input, tt.expected, res.Value.String())
+		}
+	}
+}
@@ -49,7 +49,19 @@ func isControl(err error, kind ControlKind) (*ControlError, bool) {
 	return nil, false
 }
 
+// Eval evaluates expr in env. R errors come back as *RuntimeError, located
+// at the innermost expression that failed.
 func Eval(ctx *Context, env *Env, expr ast.Expr) (Value, error) {
+	// values are visible unless the last call made them invisible
+	ctx.invisible = false
+	v, err := evalNode(ctx, env, expr)
+	if err != nil {
+		return nil, ctx.locate(err, env, expr)
+	}
+	return v, nil
+}
+
+func evalNode(ctx *Context, env *Env, expr ast.Expr) (Value, error) {
 	switch e := expr.(type) {
 	case *ast.Ident:
 		v, ok := env.Get(e.Name)
@@ -58,11 +70,7 @@ func Eval(ctx *Context, env *Env, expr ast.Expr) (Value, error) {
 		}
 		return v, nil
 	case *ast.NumberLit:
-		if e.IsInt {
-			// In R, integer literal uses 1L. We accept plain numbers and treat ints when no '.'/'e'.
-			return IntScalar(int64(e.Value)), nil
-		}
-		return DoubleScalar(e.Value), nil
+		return numberValue(e), nil
 	case *ast.StringLit:
 		return CharScalar(e.Value), nil
 	case *ast.BoolLit:
@@ -71,8 +79,16 @@ func Eval(ctx *Context, env *Env, expr ast.Expr) (Value, error) {
 		return NullValue, nil
 	case *ast.NALit:
 		return LogicalNA(), nil
+	case *ast.ValueExpr:
+		return e.Value.(Value), nil
 
 	case *ast.UnaryExpr:
+		switch e.Op {
+		case token.TILDE:
+			return makeFormula(env, e), nil
+		case token.QUESTION:
+			return callOperator(ctx, env, e.P, e.Op, e.X)
+		}
 		x, err := Eval(ctx, env, e.X)
 		if err != nil {
 			return nil, err
@@ -81,18 +97,22 @@ func Eval(ctx *Context, env *Env, expr ast.Expr) (Value, error) {
 		if err != nil {
 			return nil, err
 		}
-		switch e.Op {
-		case token.BANG:
-			return unaryNot(ctx, x)
-		case token.PLUS:
-			return unaryPlus(ctx, x)
-		case token.MINUS:
-			return unaryMinus(ctx, x)
-		default:
-			return nil, fmt.Errorf("unsupported unary op %s", e.Op)
+		if v, ok, err := dispatchOps(ctx, e.Op, x); ok {
+			return v, err
 		}
+		return unaryOp(ctx, e.Op, x)
 
 	case *ast.BinaryExpr:
+		switch {
+		case e.Op == token.TILDE:
+			return makeFormula(env, e), nil
+		case e.Op == token.NS_GET || e.Op == token.NS_GET_INT:
+			return evalNamespaceGet(ctx, e)
+		case e.Op == token.AT:
+			return evalSlot(ctx, env, e)
+		case e.Op == token.QUESTION || isUserOp(e.Op):
+			return callOperator(ctx, env, e.P, e.Op, e.Left, e.Right)
+		}
 		// short-circuit for && and ||
 		if e.Op == token.ANDAND || e.Op == token.OROR {
 			left, err := Eval(ctx, env, e.Left)
@@ -254,8 +274,14 @@ func Eval(ctx *Context, env *Env, expr ast.Expr) (Value, error) {
 			return nil, err
 		}
 		n := seqV.Len()
+		if env == emptyEnv && n > 0 {
+			return nil, errEmptyEnv()
+		}
 		var last Value = NullValue
 		for i := 0; i < n; i++ {
+			if err := ctx.interrupted(); err != nil {
+				return nil, err
+			}
 			// assign loop var as scalar element
 			elem, err := vectorElement(ctx, seqV, i)
 			if err != nil {
@@ -279,6 +305,9 @@ func Eval(ctx *Context, env *Env, expr ast.Expr) (Value, error) {
 	case *ast.WhileExpr:
 		var last Value = NullValue
 		for {
+			if err := ctx.interrupted(); err != nil {
+				return nil, err
+			}
 			condV, err := Eval(ctx, env, e.Cond)
 			if err != nil {
 				return nil, err
@@ -314,6 +343,9 @@ func Eval(ctx *Context, env *Env, expr ast.Expr) (Value, error) {
 	case *ast.RepeatExpr:
 		var last Value = NullValue
 		for {
+			if err := ctx.interrupted(); err != nil {
+				return nil, err
+			}
 			v, err := Eval(ctx, env, e.Body)
 			if err != nil {
 				if _, ok := isControl(err, ctrlNext); ok {
@@ -366,15 +398,11 @@ func Eval(ctx *Context, env *Env, expr ast.Expr) (Value, error) {
 		if err != nil {
 			return nil, err
 		}
-		idx, err := Eval(ctx, env, e.Index)
+		subs, opts, err := evalSubscripts(ctx, env, e.Args)
 		if err != nil {
 			return nil, err
 		}
-		idx, err = Force(ctx, idx)
-		if err != nil {
-			return nil, err
-		}
-		return subset(ctx, x, idx, e.Double)
+		return subsetIndexed(ctx, x, subs, opts, e.Double)
 
 	case *ast.DollarExpr:
 		x, err := Eval(ctx, env, e.X)
@@ -385,6 +413,10 @@ func Eval(ctx *Context, env *Env, expr ast.Expr) (Value, error) {
 		if err != nil {
 			return nil, err
 		}
+		args := []ArgValue{{Val: x}, {Val: CharScalar(e.Name)}}
+		if v, ok, err := dispatchInternal(ctx, env, dollarBuiltin, args); ok {
+			return v, err
+		}
 		return dollar(ctx, x, e.Name)
 
 	default:
@@ -393,34 +425,39 @@ func Eval(ctx *Context, env *Env, expr ast.Expr) (Value, error) {
 }
 
 // Force resolves a promise if needed.
-func Force(ctx *Context, v Value) (Value, error) {
-	if p, ok := v.(*Promise); ok {
-		return p.Force(ctx)
-	}
-	return v, nil
+// numberValue returns the value of a numeric constant: 1L is integer, 1i
+// complex and everything else double.
+func numberValue(e *ast.NumberLit) Value {
+	switch {
+	case e.IsInt:
+		return IntScalar(int64(e.Value))
+	case e.IsImag:
+		return ComplexScalar(complex(0, e.Value))
+	}
+	return DoubleScalar(e.Value)
 }
 
-func evalAssign(ctx *Context, env *Env, a *ast.AssignExpr) (Value, error) {
-	// Special case: right assignment "->"
-	if a.Op == token.ASSIGN_RIGHT {
-		// value -> name
-		val, err := Eval(ctx, env, a.Left)
-		if err != nil {
-			return nil, err
+func Force(ctx *Context, v Value) (Value, error) {
+	for {
+		p, ok := v.(*Promise)
+		if !ok {
+			return v, nil
 		}
-		val, err = Force(ctx, val)
-		if err != nil {
+		var err error
+		if v, err = p.Force(ctx); err != nil {
 			return nil, err
 		}
-		id, ok := a.Right.(*ast.Ident)
-		if !ok {
-			return nil, fmt.Errorf("invalid right-assignment target")
-		}
-		env.Assign(id.Name, val)
-		return val, nil
 	}
+}
 
-	val, err := Eval(ctx, env, a.Right)
+func evalAssign(ctx *Context, env *Env, a *ast.AssignExpr) (Value, error) {
+	target, src := a.Left, a.Right
+	if a.Op == token.ASSIGN_RIGHT || a.Op == token.ASSIGN_SUPER_RIGHT {
+		// value -> target
+		target, src = a.Right, a.Left
+	}
+
+	val, err := Eval(ctx, env, src)
 	if err != nil {
 		return nil, err
 	}
@@ -428,69 +465,121 @@ func evalAssign(ctx *Context, env *Env, a *ast.AssignExpr) (Value, error) {
 	if err != nil {
 		return nil, err
 	}
-
-	// Ident assignment
-	if id, ok := a.Left.(*ast.Ident); ok {
-		switch a.Op {
-		case token.ASSIGN_LEFT, token.ASSIGN_EQ:
-			env.Assign(id.Name, val)
-		case token.ASSIGN_SUPER:
-			env.AssignSuper(id.Name, val)
-		default:
-			env.Assign(id.Name, val)
-		}
-		return val, nil
+	super := a.Op == token.ASSIGN_SUPER || a.Op == token.ASSIGN_SUPER_RIGHT
+	if err := assignTarget(ctx, env, target, val, super); err != nil {
+		return nil, err
 	}
+	return val, nil
+}
 
-	// Subset assignment x[i] <- v (only when x is ident)
-	if ix, ok := a.Left.(*ast.IndexExpr); ok {
-		if xid, ok := ix.X.(*ast.Ident); ok {
-			cur, ok := env.Get(xid.Name)
-			if !ok {
-				return nil, fmt.Errorf("object '%s' not found", xid.Name)
-			}
-			cur, err = Force(ctx, cur)
-			if err != nil {
-				return nil, err
-			}
-			idxV, err := Eval(ctx, env, ix.Index)
-			if err != nil {
-				return nil, err
-			}
-			idxV, err = Force(ctx, idxV)
-			if err != nil {
-				return nil, err
-			}
-			updated, err := setSubset(ctx, cur, idxV, val, ix.Double)
-			if err != nil {
-				return nil, err
-			}
-			env.Assign(xid.Name, updated)
-			return val, nil
+// assignTarget stores val into target. Complex targets such as x[i], x$a
+// or names(x) are rewritten the way R does it: the current value of the
+// inner target is fetched, updated (via setSubset, setDollar or a `f<-`
+// replacement function) and assigned back recursively.
+func assignTarget(ctx *Context, env *Env, target ast.Expr, val Value, super bool) error {
+	switch t := target.(type) {
+	case *ast.Ident:
+		if env == emptyEnv {
+			return errEmptyEnv()
+		}
+		if super {
+			env.AssignSuper(t.Name, val)
+		} else {
+			env.Assign(t.Name, val)
+		}
+		return nil
+	case *ast.StringLit:
+		return assignTarget(ctx, env, &ast.Ident{P: t.P, Name: t.Value}, val, super)
+	case *ast.IndexExpr:
+		cur, err := assignBase(ctx, env, t.X)
+		if err != nil {
+			return err
+		}
+		subs, _, err := evalSubscripts(ctx, env, t.Args)
+		if err != nil {
+			return err
+		}
+		updated, err := setSubsetIndexed(ctx, cur, subs, val, t.Double)
+		if err != nil {
+			return err
 		}
+		return assignTarget(ctx, env, t.X, updated, super)
+	case *ast.DollarExpr:
+		cur, err := assignBase(ctx, env, t.X)
+		if err != nil {
+			return err
+		}
+		updated, err := setDollar(ctx, cur, t.Name, val)
+		if err != nil {
+			return err
+		}
+		return assignTarget(ctx, env, t.X, updated, super)
+	case *ast.BinaryExpr:
+		if t.Op != token.AT {
+			break
+		}
+		// x@name <- value sets the attribute that holds the slot
+		cur, err := assignBase(ctx, env, t.Left)
+		if err != nil {
+			return err
+		}
+		updated := cloneValue(cur)
+		if val == NullValue {
+			updated.SetAttr(symbolName(t.Right), nil)
+		} else {
+			updated.SetAttr(symbolName(t.Right), val)
+		}
+		return assignTarget(ctx, env, t.Left, updated, super)
+	case *ast.CallExpr:
+		id, ok := t.Fun.(*ast.Ident)
+		if !ok || len(t.Args) == 0 {
+			break
+		}
+		fv, ok := env.Get(id.Name + "<-")
+		if !ok {
+			return fmt.Errorf("could not find function \"%s<-\"", id.Name)
+		}
+		fv, err := Force(ctx, fv)
+		if err != nil {
+			return err
+		}
+		fn, ok := fv.(Callable)
+		if !ok {
+			return fmt.Errorf("attempt to apply non-function")
+		}
+		cur, err := assignBase(ctx, env, t.Args[0].Value)
+		if err != nil {
+			return err
+		}
+		args := []ArgValue{{Val: cur}}
+		for _, arg := range t.Args[1:] {
+			args = append(args, ArgValue{Name: arg.Name, Val: &Promise{Expr: arg.Value, Env: env}})
+		}
+		args = append(args, ArgValue{Name: "value", Val: val})
+		updated, err := fn.Call(ctx, env, args)
+		if err != nil {
+			return err
+		}
+		return assignTarget(ctx, env, t.Args[0].Value, updated, super)
 	}
+	return fmt.Errorf("invalid assignment target")
+}
 
-	// Dollar assignment x$name <- v (only when x is ident)
-	if dx, ok := a.Left.(*ast.DollarExpr); ok {
-		if xid, ok := dx.X.(*ast.Ident); ok {
-			cur, ok := env.Get(xid.Name)
-			if !ok {
-				return nil, fmt.Errorf("object '%s' not found", xid.Name)
-			}
-			cur, err = Force(ctx, cur)
-			if err != nil {
-				return nil, err
-			}
-			updated, err := setDollar(ctx, cur, dx.Name, val)
-			if err != nil {
-				return nil, err
-			}
-			env.Assign(xid.Name, updated)
-			return val, nil
+// assignBase evaluates the object that a complex assignment modifies. A
+// missing variable is an error, as in R.
+func assignBase(ctx *Context, env *Env, x ast.Expr) (Value, error) {
+	if id, ok := x.(*ast.Ident); ok {
+		cur, ok := env.Get(id.Name)
+		if !ok {
+			return nil, fmt.Errorf("object '%s' not found", id.Name)
 		}
+		return Force(ctx, cur)
 	}
-
-	return nil, fmt.Errorf("invalid assignment target")
+	v, err := Eval(ctx, env, x)
+	if err != nil {
+		return nil, err
+	}
+	return Force(ctx, v)
 }
 
 func evalCall(ctx *Context, env *Env, c *ast.CallExpr) (Value, error) {
@@ -501,7 +590,11 @@ func evalCall(ctx *Context, env *Env, c *ast.CallExpr) (Value, error) {
 			if len(c.Args) != 1 {
 				return nil, fmt.Errorf("quote() expects 1 argument")
 			}
-			return &ExprValue{Expr: c.Args[0].Value}, nil
+			return langValue(c.Args[0].Value), nil
+		case "UseMethod":
+			return evalUseMethod(ctx, env, c)
+		case "NextMethod":
+			return evalNextMethod(ctx, env, c)
 		case "missing":
 			// missing(x) inspects whether argument is missing in current function env.
 			if len(c.Args) != 1 {
@@ -559,79 +652,37 @@ func evalCall(ctx *Context, env *Env, c *ast.CallExpr) (Value, error) {
 		args = append(args, ArgValue{Name: a.Name, Val: &Promise{Expr: a.Value, Env: env}})
 	}
 
+	if fn, ok := callable.(*ClosureFunc); ok {
+		return callClosure(ctx, &Frame{Fn: fn, Call: c, Args: args, Caller: env})
+	}
 	return callable.Call(ctx, env, args)
 }
 
-func callClosure(ctx *Context, fn *ClosureFunc, args []ArgValue) (Value, error) {
-	callEnv := NewEnv(fn.Env)
-
-	// Parameter table
-	type bind struct {
-		set bool
-		val Value
-	}
-	binds := make([]bind, len(fn.Params))
-	paramIndex := map[string]int{}
-	dotsIndex := -1
-	for i, p := range fn.Params {
-		if p.Dots {
-			dotsIndex = i
-		} else {
-			paramIndex[p.Name] = i
-		}
+func callClosure(ctx *Context, fr *Frame) (Value, error) {
+	if err := ctx.interrupted(); err != nil {
+		return nil, err
 	}
+	fn, args := fr.Fn, fr.Args
+	callEnv := NewEnv(fn.Env)
 
-	// Capture dots
-	var dotsArgs []ArgValue
-
-	// 1) positional matching for unnamed args
-	paramPos := 0
-	for _, a := range args {
-		if a.Name != "" {
-			continue
-		}
-		// find next non-dots param
-		for paramPos < len(fn.Params) && fn.Params[paramPos].Dots {
-			paramPos++
-		}
-		if paramPos < len(fn.Params) {
-			binds[paramPos] = bind{set: true, val: a.Val}
-			paramPos++
-		} else {
-			if dotsIndex >= 0 {
-				dotsArgs = append(dotsArgs, a)
-			} else {
-				return nil, fmt.Errorf("unused argument (positional)")
-			}
-		}
+	names := make([]string, len(args))
+	for i, a := range args {
+		names[i] = a.Name
 	}
-
-	// 2) named matching
-	for _, a := range args {
-		if a.Name == "" {
-			continue
-		}
-		if idx, ok := paramIndex[a.Name]; ok {
-			if binds[idx].set {
-				return nil, fmt.Errorf("formal argument '%s' matched by multiple actual arguments", a.Name)
-			}
-			binds[idx] = bind{set: true, val: a.Val}
-		} else {
-			if dotsIndex >= 0 {
-				dotsArgs = append(dotsArgs, a)
-			} else {
-				return nil, fmt.Errorf("unused argument '%s'", a.Name)
-			}
-		}
+	bound, dots, err := matchParams(fn.Params, names)
+	if err != nil {
+		return nil, err
 	}
 
-	// 3) bind parameters into callEnv
+	// bind parameters into callEnv
+	dotsIndex := -1
 	for i, p := range fn.Params {
 		if p.Dots {
+			dotsIndex = i
 			continue
 		}
-		if binds[i].set {
-			callEnv.SetLocal(p.Name, binds[i].val)
+		if bound[i] >= 0 {
+			callEnv.SetLocal(p.Name, args[bound[i]].Val)
 			continue
 		}
 		if p.Default != nil {
@@ -642,17 +693,45 @@ func callClosure(ctx *Context, fn *ClosureFunc, args []ArgValue) (Value, error)
 		callEnv.SetLocal(p.Name, MissingValue)
 	}
 
-	// 4) bind dots
 	if dotsIndex >= 0 {
+		dotsArgs := make([]ArgValue, len(dots))
+		for i, j := range dots {
+			dotsArgs[i] = args[j]
+		}
 		callEnv.SetLocal("...", &Dots{Args: dotsArgs})
 	}
+	if fr.Generic != "" {
+		callEnv.SetLocal(".Generic", CharScalar(fr.Generic))
+		callEnv.SetLocal(".Class", charVecOf(fr.Classes))
+		if fr.Method != nil {
+			callEnv.SetLocal(".Method", charVecOf(fr.Method))
+		}
+	}
 
 	// Execute body
+	fr.Env = callEnv
+	ctx.pushFrame(fr)
 	v, err := Eval(ctx, callEnv, fn.Body)
+	if ce, ok := isControl(err, ctrlReturn); ok {
+		v, err = ce.Value, nil
+	}
+	if err == nil {
+		// the result may be an argument that was never forced
+		v, err = Force(ctx, v)
+	}
+	invisible := ctx.invisible
+	ret, xerr := runOnExit(ctx, fr)
+	switch {
+	case err != nil:
+	case xerr != nil:
+		v, err = nil, xerr
+	case ret != nil:
+		// return() in an exit expression replaces the value
+		v, invisible = ret, false
+	}
+	ctx.invisible = invisible
+	ctx.popFrame()
 	if err != nil {
-		if ce, ok := isControl(err, ctrlReturn); ok {
-			return ce.Value, nil
-		}
 		if _, ok := isControl(err, ctrlBreak); ok {
 			return nil, ErrBreakOutsideLoop
 		}
@@ -664,6 +743,88 @@ func callClosure(ctx *Context, fn *ClosureFunc, args []ArgValue) (Value, error)
 	return v, nil
 }
 
+// matchParams matches actual arguments, given by their names ("" when
+// positional), to the parameters of a closure. Named arguments match
+// exactly; the unnamed ones then fill the unbound parameters before ...
+// in order, and parameters after ... can only be matched by name.
+// bound[i] is the index of the argument matched to params[i], or -1; dots
+// lists the arguments collected by ..., in call order.
+func matchParams(params []Param, names []string) (bound []int, dots []int, err error) {
+	bound = make([]int, len(params))
+	paramIndex := map[string]int{}
+	dotsIndex := -1
+	for i, p := range params {
+		bound[i] = -1
+		if p.Dots {
+			dotsIndex = i
+		} else {
+			paramIndex[p.Name] = i
+		}
+	}
+	toDots := make([]bool, len(names))
+	for i, name := range names {
+		if name == "" {
+			continue
+		}
+		if idx, ok := paramIndex[name]; ok {
+			if bound[idx] >= 0 {
+				return nil, nil, fmt.Errorf("formal argument '%s' matched by multiple actual arguments", name)
+			}
+			bound[idx] = i
+		} else if dotsIndex >= 0 {
+			toDots[i] = true
+		} else {
+			return nil, nil, fmt.Errorf("unused argument '%s'", name)
+		}
+	}
+	pos := 0
+	for i, name := range names {
+		if name != "" {
+			continue
+		}
+		for pos < len(params) && !params[pos].Dots && bound[pos] >= 0 {
+			pos++
+		}
+		if pos < len(params) && !params[pos].Dots {
+			bound[pos] = i
+			pos++
+		} else if dotsIndex >= 0 {
+			toDots[i] = true
+		} else {
+			return nil, nil, fmt.Errorf("unused argument (positional)")
+		}
+	}
+	for i, d := range toDots {
+		if d {
+			dots = append(dots, i)
+		}
+	}
+	return bound, dots, nil
+}
+
+// runOnExit evaluates the expressions registered with on.exit() in the
+// frame's environment. It runs on every exit path of a closure; an error
+// in an exit expression does not stop the remaining ones. ret is the value
+// of the last return() called by an exit expression, or nil.
+func runOnExit(ctx *Context, fr *Frame) (ret Value, first error) {
+	exprs := fr.OnExit
+	fr.OnExit = nil
+	for _, e := range exprs {
+		v, err := Eval(ctx, fr.Env, e)
+		if ce, ok := isControl(err, ctrlReturn); ok {
+			if v, err = Force(ctx, ce.Value); err == nil {
+				ret = v
+			}
+		} else if err == nil {
+			_, err = Force(ctx, v)
+		}
+		if err != nil && first == nil {
+			first = err
+		}
+	}
+	return ret, first
+}
+
 // --- Value helpers ---
 
 func vectorElement(ctx *Context, v Value, i int) (Value, error) {
@@ -690,6 +851,8 @@ func vectorElement(ctx *Context, v Value, i int) (Value, error) {
 			return DoubleNA(), nil
 		}
 		return DoubleScalar(e.Val), nil
+	case *ComplexVec:
+		return &ComplexVec{Data: []ComplexElem{t.Data[i]}}, nil
 	case *CharVec:
 		e := t.Data[i]
 		if e.NA {
@@ -727,6 +890,12 @@ func asLogicalScalar(ctx *Context, v Value) (bool, bool, error) {
 			return false, true, nil
 		}
 		return e.Val != 0, false, nil
+	case *ComplexVec:
+		e := t.Data[0]
+		if e.NA {
+			return false, true, nil
+		}
+		return e.Val != 0, false, nil
 	case *CharVec:
 		e := t.Data[0]
 		if e.NA {
@@ -814,43 +983,56 @@ func unaryNot(ctx *Context, v Value) (Value, error) {
 }
 
 func unaryPlus(ctx *Context, v Value) (Value, error) {
-	// no-op but forces numeric coercion
-	f, err := asFloatElem(ctx, v)
-	if err != nil {
-		return nil, err
-	}
-	if f.NA {
-		return DoubleNA(), nil
+	// no-op on numbers, logicals become integer
+	switch v.Type() {
+	case "integer", "double", "complex":
+		return v, nil
+	case "logical":
+		return coerceTo(ctx, v, "integer")
 	}
-	return DoubleScalar(f.Val), nil
+	return nil, fmt.Errorf("invalid argument to unary operator")
 }
 
 func unaryMinus(ctx *Context, v Value) (Value, error) {
-	// numeric
-	if v.Len() == 1 {
-		f, err := asFloatElem(ctx, v)
+	// -x keeps integers integer and complex numbers complex
+	var out Value
+	switch v.Type() {
+	case "integer", "logical":
+		iv, err := coerceToIntVec(ctx, v)
 		if err != nil {
 			return nil, err
 		}
-		if f.NA {
-			return DoubleNA(), nil
+		o := make([]IntElem, len(iv))
+		for i, e := range iv {
+			o[i] = IntElem{Val: -e.Val, NA: e.NA}
 		}
-		return DoubleScalar(-f.Val), nil
-	}
-	// vectorized
-	fv, err := asDoubleVec(ctx, v)
-	if err != nil {
-		return nil, err
-	}
-	out := make([]FloatElem, len(fv))
-	for i, e := range fv {
-		if e.NA {
-			out[i] = FloatElem{NA: true}
-		} else {
-			out[i] = FloatElem{Val: -e.Val}
+		out = &IntVec{Data: o}
+	case "complex":
+		zv, err := asComplexVec(ctx, v)
+		if err != nil {
+			return nil, err
 		}
+		o := make([]ComplexElem, len(zv))
+		for i, e := range zv {
+			o[i] = ComplexElem{Val: -e.Val, NA: e.NA}
+		}
+		out = &ComplexVec{Data: o}
+	default:
+		fv, err := asDoubleVec(ctx, v)
+		if err != nil {
+			return nil, err
+		}
+		o := make([]FloatElem, len(fv))
+		for i, e := range fv {
+			if e.NA {
+				o[i] = FloatElem{NA: true}
+			} else {
+				o[i] = FloatElem{Val: -e.Val}
+			}
+		}
+		out = &DoubleVec{Data: o}
 	}
-	return &DoubleVec{Data: out}, nil
+	return copyDims(out, v), nil
 }
 
 func asLogicalVec(ctx *Context, v Value) ([]LogicalElem, error) {
@@ -881,6 +1063,16 @@ func asLogicalVec(ctx *Context, v Value) ([]LogicalElem, error) {
 			}
 		}
 		return out, nil
+	case *ComplexVec:
+		out := make([]LogicalElem, len(t.Data))
+		for i, e := range t.Data {
+			if e.NA {
+				out[i] = LogicalElem{NA: true}
+			} else {
+				out[i] = LogicalElem{Val: e.Val != 0}
+			}
+		}
+		return out, nil
 	default:
 		return nil, fmt.Errorf("cannot coerce %s to logical", v.Type())
 	}
@@ -916,24 +1108,96 @@ func asDoubleVec(ctx *Context, v Value) ([]FloatElem, error) {
 			}
 		}
 		return out, nil
+	case *ComplexVec:
+		out := make([]FloatElem, len(t.Data))
+		discarded := false
+		for i, e := range t.Data {
+			if e.NA {
+				out[i] = FloatElem{NA: true}
+				continue
+			}
+			out[i] = FloatElem{Val: real(e.Val)}
+			discarded = discarded || imag(e.Val) != 0
+		}
+		if discarded {
+			if err := ctx.warn("imaginary parts discarded in coercion"); err != nil {
+				return nil, err
+			}
+		}
+		return out, nil
 	default:
 		return nil, fmt.Errorf("cannot coerce %s to double", v.Type())
 	}
 }
 
+func unaryOp(ctx *Context, op token.Type, x Value) (Value, error) {
+	switch op {
+	case token.BANG:
+		return unaryNot(ctx, x)
+	case token.PLUS:
+		return unaryPlus(ctx, x)
+	case token.MINUS:
+		return unaryMinus(ctx, x)
+	default:
+		return nil, fmt.Errorf("unsupported unary op %s", op)
+	}
+}
+
+// evalBinary applies a binary operator, dispatching to Ops methods for
+// classed operands.
 func evalBinary(ctx *Context, op token.Type, a, b Value) (Value, error) {
 	switch op {
-	case token.PLUS, token.MINUS, token.STAR, token.SLASH, token.CARET, token.MOD, token.INTDIV, token.COLON:
+	case token.COLON, token.INOP, token.MATMUL, token.OUTER:
+	default:
+		if v, ok, err := dispatchOps(ctx, op, a, b); ok {
+			return v, err
+		}
+	}
+	return binaryOp(ctx, op, a, b)
+}
+
+func binaryOp(ctx *Context, op token.Type, a, b Value) (Value, error) {
+	var out Value
+	var err error
+	switch op {
+	case token.COLON:
 		return evalNumericBinary(ctx, op, a, b)
+	case token.PLUS, token.MINUS, token.STAR, token.SLASH, token.CARET, token.MOD, token.INTDIV:
+		out, err = evalNumericBinary(ctx, op, a, b)
 	case token.INOP:
 		return evalInOp(ctx, a, b)
+	case token.MATMUL:
+		return matMul(ctx, a, b)
+	case token.OUTER:
+		return outerProduct(ctx, a, b, "*")
 	case token.LT, token.LTE, token.GT, token.GTE, token.EQ, token.NEQ:
-		return evalCompare(ctx, op, a, b)
+		out, err = evalCompare(ctx, op, a, b)
 	case token.AND, token.OR:
-		return evalLogicalVector(ctx, op, a, b)
+		out, err = evalLogicalVector(ctx, op, a, b)
 	default:
 		return nil, fmt.Errorf("unsupported binary op %s", op)
 	}
+	if err != nil {
+		return nil, err
+	}
+	return copyDims(out, a, b), nil
+}
+
+// copyDims carries dim and dimnames of an operand over to the result of an
+// element-wise operation, so that m * 2 is still a matrix.
+func copyDims(out Value, operands ...Value) Value {
+	for _, v := range operands {
+		d, ok := v.GetAttr("dim")
+		if !ok || v.Len() != out.Len() {
+			continue
+		}
+		out.SetAttr("dim", d)
+		if dn, ok := v.GetAttr("dimnames"); ok {
+			out.SetAttr("dimnames", dn)
+		}
+		break
+	}
+	return out
 }
 
 func evalInOp(ctx *Context, a, b Value) (Value, error) {
@@ -952,77 +1216,169 @@ func evalInOp(ctx *Context, a, b Value) (Value, error) {
 }
 
 func evalNumericBinary(ctx *Context, op token.Type, a, b Value) (Value, error) {
-	// colon operator creates sequence
 	if op == token.COLON {
-		af, err := asFloatElem(ctx, a)
-		if err != nil {
-			return nil, err
-		}
-		bf, err := asFloatElem(ctx, b)
-		if err != nil {
-			return nil, err
+		return colonSeq(ctx, a, b)
+	}
+	if a.Type() == "complex" || b.Type() == "complex" {
+		return complexArith(ctx, op, a, b)
+	}
+	if isIntegerLike(a) && isIntegerLike(b) {
+		switch op {
+		case token.PLUS, token.MINUS, token.STAR, token.MOD, token.INTDIV:
+			return intArith(ctx, op, a, b)
 		}
-		if af.NA || bf.NA {
-			return &DoubleVec{Data: []FloatElem{{NA: true}}}, nil
+	}
+
+	// vectorize with recycling
+	av, err := asDoubleVec(ctx, a)
+	if err != nil {
+		return nil, err
+	}
+	bv, err := asDoubleVec(ctx, b)
+	if err != nil {
+		return nil, err
+	}
+	n := max(len(av), len(bv))
+	if len(av) == 0 || len(bv) == 0 {
+		return &DoubleVec{Data: nil}, nil
+	}
+	out := make([]FloatElem, n)
+	for i := 0; i < n; i++ {
+		ae := av[i%len(av)]
+		be := bv[i%len(bv)]
+		if ae.NA || be.NA {
+			out[i] = FloatElem{NA: true}
+			continue
 		}
-		start := int64(af.Val)
-		end := int64(bf.Val)
-		step := int64(1)
-		if end < start {
-			step = -1
+		switch op {
+		case token.PLUS:
+			out[i] = FloatElem{Val: ae.Val + be.Val}
+		case token.MINUS:
+			out[i] = FloatElem{Val: ae.Val - be.Val}
+		case token.STAR:
+			out[i] = FloatElem{Val: ae.Val * be.Val}
+		case token.SLASH:
+			out[i] = FloatElem{Val: ae.Val / be.Val}
+		case token.CARET:
+			out[i] = FloatElem{Val: math.Pow(ae.Val, be.Val)}
+		case token.MOD:
+			out[i] = FloatElem{Val: floorMod(ae.Val, be.Val)}
+		case token.INTDIV:
+			out[i] = FloatElem{Val: math.Floor(ae.Val / be.Val)}
+		default:
+			return nil, fmt.Errorf("unsupported numeric op %s", op)
 		}
-		n := int((end-start)/step) + 1
-		out := make([]IntElem, 0, n)
-		for x := start; ; x += step {
-			out = append(out, IntElem{Val: x})
-			if x == end {
-				break
-			}
+	}
+	return &DoubleVec{Data: out}, nil
+}
+
+// floorMod is R's %%: the result takes the sign of the divisor.
+func floorMod(a, b float64) float64 {
+	r := math.Mod(a, b)
+	if r != 0 && (r < 0) != (b < 0) {
+		r += b
+	}
+	return r
+}
+
+// colonSeq evaluates from:to. The result is integer when from is a whole
+// number and the sequence fits the integer range, and double otherwise,
+// so 1:3 is integer and 1.5:3 is c(1.5, 2.5).
+func colonSeq(ctx *Context, a, b Value) (Value, error) {
+	af, err := asFloatElem(ctx, a)
+	if err != nil {
+		return nil, err
+	}
+	bf, err := asFloatElem(ctx, b)
+	if err != nil {
+		return nil, err
+	}
+	if af.NA || bf.NA {
+		return nil, fmt.Errorf("NA/NaN argument")
+	}
+	from, to := af.Val, bf.Val
+	step := 1.0
+	if to < from {
+		step = -1
+	}
+	n := int(math.Floor(math.Abs(to-from)+1e-10)) + 1
+	end := from + float64(n-1)*step
+	if from == math.Trunc(from) && math.Abs(from) <= math.MaxInt32 && math.Abs(end) <= math.MaxInt32 {
+		out := make([]IntElem, n)
+		for i := range out {
+			out[i] = IntElem{Val: int64(from) + int64(i)*int64(step)}
 		}
 		return &IntVec{Data: out}, nil
 	}
+	out := make([]FloatElem, n)
+	for i := range out {
+		out[i] = FloatElem{Val: from + float64(i)*step}
+	}
+	return &DoubleVec{Data: out}, nil
+}
 
-	// vectorize with recycling
-	av, err := asDoubleVec(ctx, a)
+// isIntegerLike reports whether arithmetic on v stays integer, which is
+// the case for integer and logical vectors.
+func isIntegerLike(v Value) bool {
+	t := v.Type()
+	return t == "integer" || t == "logical"
+}
+
+// intArith implements +, -, *, %% and %/% on integers. Results outside
+// the 32-bit range of R integers become NA with a warning.
+func intArith(ctx *Context, op token.Type, a, b Value) (Value, error) {
+	av, err := coerceToIntVec(ctx, a)
 	if err != nil {
 		return nil, err
 	}
-	bv, err := asDoubleVec(ctx, b)
+	bv, err := coerceToIntVec(ctx, b)
 	if err != nil {
 		return nil, err
 	}
-	n := max(len(av), len(bv))
-	if n == 0 {
-		return &DoubleVec{Data: nil}, nil
+	if len(av) == 0 || len(bv) == 0 {
+		return &IntVec{Data: nil}, nil
 	}
-	out := make([]FloatElem, n)
-	for i := 0; i < n; i++ {
-		ae := av[i%len(av)]
-		be := bv[i%len(bv)]
+	n := max(len(av), len(bv))
+	out := make([]IntElem, n)
+	overflow := false
+	for i := range out {
+		ae, be := av[i%len(av)], bv[i%len(bv)]
 		if ae.NA || be.NA {
-			out[i] = FloatElem{NA: true}
+			out[i] = IntElem{NA: true}
 			continue
 		}
+		var r int64
 		switch op {
 		case token.PLUS:
-			out[i] = FloatElem{Val: ae.Val + be.Val}
+			r = ae.Val + be.Val
 		case token.MINUS:
-			out[i] = FloatElem{Val: ae.Val - be.Val}
+			r = ae.Val - be.Val
 		case token.STAR:
-			out[i] = FloatElem{Val: ae.Val * be.Val}
-		case token.SLASH:
-			out[i] = FloatElem{Val: ae.Val / be.Val}
-		case token.CARET:
-			out[i] = FloatElem{Val: math.Pow(ae.Val, be.Val)}
-		case token.MOD:
-			out[i] = FloatElem{Val: math.Mod(ae.Val, be.Val)}
-		case token.INTDIV:
-			out[i] = FloatElem{Val: math.Floor(ae.Val / be.Val)}
-		default:
-			return nil, fmt.Errorf("unsupported numeric op %s", op)
+			r = ae.Val * be.Val
+		case token.MOD, token.INTDIV:
+			if be.Val == 0 {
+				out[i] = IntElem{NA: true}
+				continue
+			}
+			q := int64(math.Floor(float64(ae.Val) / float64(be.Val)))
+			r = q
+			if op == token.MOD {
+				r = ae.Val - q*be.Val
+			}
+		}
+		if r > math.MaxInt32 || r < -math.MaxInt32 {
+			overflow = true
+			out[i] = IntElem{NA: true}
+			continue
 		}
+		out[i] = IntElem{Val: r}
 	}
-	return &DoubleVec{Data: out}, nil
+	if overflow {
+		if err := ctx.warn("NAs produced by integer overflow"); err != nil {
+			return nil, err
+		}
+	}
+	return &IntVec{Data: out}, nil
 }
 
 func evalCompare(ctx *Context, op token.Type, a, b Value) (Value, error) {
@@ -1033,6 +1389,11 @@ func evalCompare(ctx *Context, op token.Type, a, b Value) (Value, error) {
 	// Determine common type.
 	a, _ = Force(ctx, a)
 	b, _ = Force(ctx, b)
+	a, b = factorOperands(a, b)
+
+	if (a.Type() == "complex" || b.Type() == "complex") && a.Type() != "character" && b.Type() != "character" {
+		return complexCompare(ctx, op, a, b)
+	}
 
 	// If either is character, coerce both to character.
 	if a.Type() == "character" || b.Type() == "character" {
@@ -1045,6 +1406,9 @@ func evalCompare(ctx *Context, op token.Type, a, b Value) (Value, error) {
 			return nil, err
 		}
 		n := max(len(ac), len(bc))
+		if len(ac) == 0 || len(bc) == 0 {
+			return &LogicalVec{Data: nil}, nil
+		}
 		out := make([]LogicalElem, n)
 		for i := 0; i < n; i++ {
 			ae := ac[i%len(ac)]
@@ -1077,6 +1441,9 @@ func evalCompare(ctx *Context, op token.Type, a, b Value) (Value, error) {
 			return nil, err2
 		}
 		n := max(len(lv), len(rv))
+		if len(lv) == 0 || len(rv) == 0 {
+			return &LogicalVec{Data: nil}, nil
+		}
 		out := make([]LogicalElem, n)
 		for i := 0; i < n; i++ {
 			ae := lv[i%len(lv)]
@@ -1100,6 +1467,9 @@ func evalCompare(ctx *Context, op token.Type, a, b Value) (Value, error) {
 		return nil, err
 	}
 	n := max(len(av), len(bv))
+	if len(av) == 0 || len(bv) == 0 {
+		return &LogicalVec{Data: nil}, nil
+	}
 	out := make([]LogicalElem, n)
 	for i := 0; i < n; i++ {
 		ae := av[i%len(av)]
@@ -1148,6 +1518,9 @@ func evalLogicalVector(ctx *Context, op token.Type, a, b Value) (Value, error) {
 		return nil, err
 	}
 	n := max(len(av), len(bv))
+	if len(av) == 0 || len(bv) == 0 {
+		return &LogicalVec{Data: nil}, nil
+	}
 	out := make([]LogicalElem, n)
 	for i := 0; i < n; i++ {
 		ae := av[i%len(av)]
@@ -1181,6 +1554,9 @@ func evalLogicalVector(ctx *Context, op token.Type, a, b Value) (Value, error) {
 }
 
 func asCharVec(ctx *Context, v Value) ([]StringElem, error) {
+	if isFactor(v) {
+		return factorLabels(v), nil
+	}
 	v, err := Force(ctx, v)
 	if err != nil {
 		return nil, err
@@ -1194,7 +1570,7 @@ func asCharVec(ctx *Context, v Value) ([]StringElem, error) {
 			if e.NA {
 				out[i] = StringElem{NA: true}
 			} else {
-				out[i] = StringElem{Val: strconv.FormatFloat(e.Val, 'g', -1, 64)}
+				out[i] = StringElem{Val: formatFloat(e.Val)}
 			}
 		}
 		return out, nil
@@ -1220,6 +1596,30 @@ func asCharVec(ctx *Context, v Value) ([]StringElem, error) {
 			}
 		}
 		return out, nil
+	case *ComplexVec:
+		out := make([]StringElem, len(t.Data))
+		for i, e := range t.Data {
+			if e.NA {
+				out[i] = StringElem{NA: true}
+			} else {
+				out[i] = StringElem{Val: formatComplex(e.Val)}
+			}
+		}
+		return out, nil
+	case *ExprValue:
+		// a symbol gives its name, a call the deparsed function and arguments
+		if isSymbol(t) {
+			return []StringElem{{Val: t.String()}}, nil
+		}
+		var out []StringElem
+		for _, e := range langToList(t).Data {
+			if _, ok := e.(*ExprValue); ok || e.Len() != 1 {
+				out = append(out, StringElem{Val: deparseValue(e)})
+			} else {
+				out = append(out, StringElem{Val: toPlainStrings(e)[0]})
+			}
+		}
+		return out, nil
 	default:
 		return nil, fmt.Errorf("cannot coerce %s to character", v.Type())
 	}
@@ -1246,6 +1646,10 @@ func subset(ctx *Context, x Value, idx Value, dbl bool) (Value, error) {
 
 	// [[ expects scalar index
 	if dbl {
+		if dv, ok := idx.(*DoubleVec); ok {
+			iv, _ := coerceToIntVec(ctx, dv)
+			idx = &IntVec{Data: iv}
+		}
 		switch t := idx.(type) {
 		case *IntVec:
 			if t.Len() != 1 {
@@ -1270,7 +1674,11 @@ func subset(ctx *Context, x Value, idx Value, dbl bool) (Value, error) {
 				if i >= x.Len() {
 					return nil, fmt.Errorf("subscript out of bounds")
 				}
-				return vectorElement(ctx, x, i)
+				elem, err := vectorElement(ctx, x, i)
+				if err != nil {
+					return nil, err
+				}
+				return copyFactorAttrs(elem, x), nil
 			}
 		case *CharVec:
 			if t.Len() != 1 {
@@ -1288,8 +1696,42 @@ func subset(ctx *Context, x Value, idx Value, dbl bool) (Value, error) {
 	}
 
 	// [ vectorized
+	if idx == MissingValue {
+		return x, nil
+	}
+	out, err := subsetVector(ctx, x, idx)
+	if err != nil {
+		return nil, err
+	}
+	copyFactorAttrs(out, x)
+	// names travel with the selected elements
+	if nm, ok := x.GetAttr("names"); ok {
+		if cidx, ok := idx.(*CharVec); ok {
+			out.SetAttr("names", &CharVec{Data: append([]StringElem(nil), cidx.Data...)})
+		} else if nmSub, err := subset(ctx, nm, idx, false); err == nil {
+			out.SetAttr("names", nmSub)
+		}
+	}
+	return out, nil
+}
+
+func subsetVector(ctx *Context, x Value, idx Value) (Value, error) {
 	switch xv := x.(type) {
 	case *ListVec:
+		if cidx, ok := idx.(*CharVec); ok {
+			names, _ := listNames(xv)
+			out := make([]Value, len(cidx.Data))
+			for j, e := range cidx.Data {
+				out[j] = NullValue
+				if e.NA {
+					continue
+				}
+				if i := matchName(names, e.Val, true); i >= 0 {
+					out[j] = xv.Data[i]
+				}
+			}
+			return &ListVec{Data: out}, nil
+		}
 		indices, naMask, err := normalizeIndex(ctx, idx, xv.Len())
 		if err != nil {
 			return nil, err
@@ -1314,6 +1756,8 @@ func subset(ctx *Context, x Value, idx Value, dbl bool) (Value, error) {
 		return subsetAtomicInt(ctx, xv, idx)
 	case *DoubleVec:
 		return subsetAtomicDouble(ctx, xv, idx)
+	case *ComplexVec:
+		return subsetAtomicComplex(ctx, xv, idx)
 	case *CharVec:
 		return subsetAtomicChar(ctx, xv, idx)
 	default:
@@ -1328,6 +1772,12 @@ func dollar(ctx *Context, x Value, name string) (Value, error) {
 	}
 	// For lists, use names attribute if present.
 	switch xv := x.(type) {
+	case *EnvValue:
+		v, ok := xv.Env.GetLocal(name)
+		if !ok {
+			return NullValue, nil
+		}
+		return Force(ctx, v)
 	case *ListVec:
 		if nm, ok := xv.GetAttr("names"); ok {
 			nmv, err := Force(ctx, nm)
@@ -1521,6 +1971,25 @@ func subsetAtomicDouble(ctx *Context, x *DoubleVec, idx Value) (Value, error) {
 	return &DoubleVec{Data: out}, nil
 }
 
+func subsetAtomicComplex(ctx *Context, x *ComplexVec, idx Value) (Value, error) {
+	indices, naMask, err := normalizeIndex(ctx, idx, x.Len())
+	if err != nil {
+		if idx.Type() == "character" {
+			return subsetByName(ctx, x, idx)
+		}
+		return nil, err
+	}
+	out := make([]ComplexElem, 0, len(indices))
+	for j, i := range indices {
+		if naMask[j] || i < 0 || i >= x.Len() {
+			out = append(out, ComplexElem{NA: true})
+		} else {
+			out = append(out, x.Data[i])
+		}
+	}
+	return &ComplexVec{Data: out}, nil
+}
+
 func subsetAtomicChar(ctx *Context, x *CharVec, idx Value) (Value, error) {
 	indices, naMask, err := normalizeIndex(ctx, idx, x.Len())
 	if err != nil {
@@ -1619,6 +2088,16 @@ func subsetByName(ctx *Context, x Value, idx Value) (Value, error) {
 			}
 		}
 		return &DoubleVec{Data: out}, nil
+	case *ComplexVec:
+		out := make([]ComplexElem, cidx.Len())
+		for i := range out {
+			if naMask[i] {
+				out[i] = ComplexElem{NA: true}
+			} else {
+				out[i] = xv.Data[outIdx[i]]
+			}
+		}
+		return &ComplexVec{Data: out}, nil
 	case *CharVec:
 		out := make([]StringElem, cidx.Len())
 		for i := range out {
@@ -1654,6 +2133,12 @@ func makeNAOfType(typ string, n int) Value {
 			out[i] = FloatElem{NA: true}
 		}
 		return &DoubleVec{Data: out}
+	case "complex":
+		out := make([]ComplexElem, n)
+		for i := range out {
+			out[i] = ComplexElem{NA: true}
+		}
+		return &ComplexVec{Data: out}
 	case "character":
 		out := make([]StringElem, n)
 		for i := range out {
@@ -1666,7 +2151,6 @@ func makeNAOfType(typ string, n int) Value {
 }
 
 func setSubset(ctx *Context, x Value, idx Value, rhs Value, dbl bool) (Value, error) {
-	// only support atomic vectors and lists. idx must be integer scalar for [[ and [ for now.
 	x, err := Force(ctx, x)
 	if err != nil {
 		return nil, err
@@ -1679,115 +2163,171 @@ func setSubset(ctx *Context, x Value, idx Value, rhs Value, dbl bool) (Value, er
 	if err != nil {
 		return nil, err
 	}
+	if pos, ok, err := matrixSubscript(x, idx); ok && !dbl {
+		if err != nil {
+			return nil, err
+		}
+		idx = pos
+	}
+	if dv, ok := idx.(*DoubleVec); ok {
+		iv, _ := coerceToIntVec(ctx, dv)
+		idx = &IntVec{Data: iv}
+	}
+	// assigning into NULL starts from an empty vector of the rhs type
+	if x == NullValue {
+		if dbl && (rhs.Len() != 1 || !isAtomic(rhs)) {
+			x = &ListVec{}
+		} else {
+			x = makeNAOfType(rhs.Type(), 0)
+		}
+	}
+	// values stored into a factor are matched against its levels
+	if isFactor(x) && isAtomic(rhs) {
+		labels, err := asCharVec(ctx, rhs)
+		if err != nil {
+			return nil, err
+		}
+		codes := factorCodes(labels, factorLevels(x))
+		for i, c := range codes {
+			if c.NA && !labels[i].NA {
+				if err := ctx.warn("invalid factor level, NA generated"); err != nil {
+					return nil, err
+				}
+				break
+			}
+		}
+		rhs = &IntVec{Data: codes}
+	}
 
 	if dbl {
-		// [[ scalar integer
-		iv, ok := idx.(*IntVec)
-		if !ok || iv.Len() != 1 || iv.Data[0].NA {
-			return nil, fmt.Errorf("invalid subscript in [[<-")
-		}
-		i := int(iv.Data[0].Val) - 1
-		if i < 0 {
-			return nil, fmt.Errorf("subscript out of bounds")
-		}
-		switch xv := x.(type) {
-		case *ListVec:
-			out := cloneList(xv)
-			// extend if needed
-			for len(out.Data) <= i {
-				out.Data = append(out.Data, NullValue)
-			}
-			out.Data[i] = rhs
-			return out, nil
-		default:
+		if cv, ok := idx.(*CharVec); ok {
+			if cv.Len() != 1 || cv.Data[0].NA {
+				return nil, fmt.Errorf("invalid subscript in [[<-")
+			}
+			if _, ok := x.(*ListVec); ok {
+				return setDollar(ctx, x, cv.Data[0].Val, rhs)
+			}
+		} else {
+			// [[ scalar integer
+			iv, ok := idx.(*IntVec)
+			if !ok || iv.Len() != 1 || iv.Data[0].NA {
+				return nil, fmt.Errorf("invalid subscript in [[<-")
+			}
+			i := int(iv.Data[0].Val) - 1
+			if i < 0 {
+				return nil, fmt.Errorf("subscript out of bounds")
+			}
+			if xv, ok := x.(*ListVec); ok {
+				out := cloneList(xv)
+				// extend if needed
+				for len(out.Data) <= i {
+					out.Data = append(out.Data, NullValue)
+				}
+				out.Data[i] = rhs
+				return out, nil
+			}
+		}
+		if !isAtomic(x) {
 			return nil, fmt.Errorf("[[<- not implemented for %s", x.Type())
 		}
+		if rhs.Len() != 1 {
+			return nil, fmt.Errorf("more elements supplied than there are to replace")
+		}
+		// atomic x[[i]] <- v behaves like x[i] <- v
 	}
 
-	// [ assignment: support integer positions (no negative) and scalar rhs recycling
-	iv, ok := idx.(*IntVec)
-	if !ok {
-		return nil, fmt.Errorf("[<- only supports integer indices in smallR MVP")
+	// [ assignment
+	pos, names, err := assignPositions(ctx, x, idx)
+	if err != nil {
+		return nil, err
 	}
-	// Determine positions
-	var pos []int
-	for _, e := range iv.Data {
-		if e.NA {
-			continue
-		}
-		if e.Val <= 0 {
-			continue
+	// promote x to the common type of x and rhs
+	if typeRank(rhs.Type()) > typeRank(x.Type()) {
+		x, err = coerceTo(ctx, x, rhs.Type())
+		if err != nil {
+			return nil, err
 		}
-		pos = append(pos, int(e.Val)-1)
 	}
+	var out Value
 	switch xv := x.(type) {
 	case *DoubleVec:
-		out := cloneDouble(xv)
+		o := cloneDouble(xv)
 		rv, err := asDoubleVec(ctx, rhs)
 		if err != nil {
 			return nil, err
 		}
-		if len(rv) == 0 {
-			return out, nil
-		}
-		for i, p := range pos {
-			for len(out.Data) <= p {
-				out.Data = append(out.Data, FloatElem{NA: true})
+		if len(rv) > 0 {
+			for i, p := range pos {
+				for len(o.Data) <= p {
+					o.Data = append(o.Data, FloatElem{NA: true})
+				}
+				o.Data[p] = rv[i%len(rv)]
 			}
-			out.Data[p] = rv[i%len(rv)]
 		}
-		return out, nil
+		out = o
 	case *IntVec:
-		out := cloneInt(xv)
+		o := cloneInt(xv)
 		// coerce rhs to int
 		rv, err := coerceToIntVec(ctx, rhs)
 		if err != nil {
 			return nil, err
 		}
-		if len(rv) == 0 {
-			return out, nil
-		}
-		for i, p := range pos {
-			for len(out.Data) <= p {
-				out.Data = append(out.Data, IntElem{NA: true})
+		if len(rv) > 0 {
+			for i, p := range pos {
+				for len(o.Data) <= p {
+					o.Data = append(o.Data, IntElem{NA: true})
+				}
+				o.Data[p] = rv[i%len(rv)]
 			}
-			out.Data[p] = rv[i%len(rv)]
 		}
-		return out, nil
+		out = o
 	case *LogicalVec:
-		out := cloneLogical(xv)
+		o := cloneLogical(xv)
 		rv, err := asLogicalVec(ctx, rhs)
 		if err != nil {
 			return nil, err
 		}
-		if len(rv) == 0 {
-			return out, nil
+		if len(rv) > 0 {
+			for i, p := range pos {
+				for len(o.Data) <= p {
+					o.Data = append(o.Data, LogicalElem{NA: true})
+				}
+				o.Data[p] = rv[i%len(rv)]
+			}
+		}
+		out = o
+	case *ComplexVec:
+		o := cloneComplex(xv)
+		rv, err := asComplexVec(ctx, rhs)
+		if err != nil {
+			return nil, err
 		}
-		for i, p := range pos {
-			for len(out.Data) <= p {
-				out.Data = append(out.Data, LogicalElem{NA: true})
+		if len(rv) > 0 {
+			for i, p := range pos {
+				for len(o.Data) <= p {
+					o.Data = append(o.Data, ComplexElem{NA: true})
+				}
+				o.Data[p] = rv[i%len(rv)]
 			}
-			out.Data[p] = rv[i%len(rv)]
 		}
-		return out, nil
+		out = o
 	case *CharVec:
-		out := cloneChar(xv)
+		o := cloneChar(xv)
 		rv, err := asCharVec(ctx, rhs)
 		if err != nil {
 			return nil, err
 		}
-		if len(rv) == 0 {
-			return out, nil
-		}
-		for i, p := range pos {
-			for len(out.Data) <= p {
-				out.Data = append(out.Data, StringElem{NA: true})
+		if len(rv) > 0 {
+			for i, p := range pos {
+				for len(o.Data) <= p {
+					o.Data = append(o.Data, StringElem{NA: true})
+				}
+				o.Data[p] = rv[i%len(rv)]
 			}
-			out.Data[p] = rv[i%len(rv)]
 		}
-		return out, nil
+		out = o
 	case *ListVec:
-		out := cloneList(xv)
+		o := cloneList(xv)
 		// rhs can be list or scalar
 		var rlist []Value
 		if rl, ok := rhs.(*ListVec); ok {
@@ -1795,16 +2335,162 @@ func setSubset(ctx *Context, x Value, idx Value, rhs Value, dbl bool) (Value, er
 		} else {
 			rlist = []Value{rhs}
 		}
-		for i, p := range pos {
-			for len(out.Data) <= p {
-				out.Data = append(out.Data, NullValue)
+		if len(rlist) > 0 {
+			for i, p := range pos {
+				for len(o.Data) <= p {
+					o.Data = append(o.Data, NullValue)
+				}
+				o.Data[p] = rlist[i%len(rlist)]
 			}
-			out.Data[p] = rlist[i%len(rlist)]
 		}
-		return out, nil
+		out = o
 	default:
 		return nil, fmt.Errorf("[<- not implemented for %s", x.Type())
 	}
+	if names != nil {
+		for len(names) < out.Len() {
+			names = append(names, StringElem{Val: ""})
+		}
+		out.SetAttr("names", &CharVec{Data: names})
+	}
+	return out, nil
+}
+
+// assignPositions resolves the subscript of x[idx] <- v to 0-based
+// positions, which may lie past the end of x. Character subscripts are
+// matched against names(x); unknown names are appended. The returned names
+// are non-nil when x has (or gains) names.
+func assignPositions(ctx *Context, x Value, idx Value) ([]int, []StringElem, error) {
+	n := x.Len()
+	var names []StringElem
+	if nm, ok := x.GetAttr("names"); ok {
+		if cv, ok := nm.(*CharVec); ok {
+			names = append([]StringElem(nil), cv.Data...)
+		}
+	}
+	switch t := idx.(type) {
+	case *Missing:
+		pos := make([]int, n)
+		for i := range pos {
+			pos[i] = i
+		}
+		return pos, names, nil
+	case *CharVec:
+		for len(names) < n {
+			names = append(names, StringElem{Val: ""})
+		}
+		pos := make([]int, 0, len(t.Data))
+		for _, e := range t.Data {
+			found := -1
+			for j, nme := range names {
+				if !nme.NA && !e.NA && nme.Val == e.Val {
+					found = j
+					break
+				}
+			}
+			if found < 0 {
+				found = len(names)
+				names = append(names, e)
+			}
+			pos = append(pos, found)
+		}
+		return pos, names, nil
+	default:
+		indices, naMask, err := normalizeIndex(ctx, idx, n)
+		if err != nil {
+			return nil, nil, err
+		}
+		pos := make([]int, 0, len(indices))
+		for j, i := range indices {
+			if naMask[j] {
+				continue
+			}
+			pos = append(pos, i)
+		}
+		return pos, names, nil
+	}
+}
+
+// typeRank orders vector types by R's coercion hierarchy.
+func typeRank(typ string) int {
+	switch typ {
+	case "null":
+		return -1
+	case "logical":
+		return 0
+	case "integer":
+		return 1
+	case "double":
+		return 2
+	case "complex":
+		return 3
+	case "character":
+		return 4
+	default:
+		return 5
+	}
+}
+
+func isAtomic(v Value) bool {
+	switch v.(type) {
+	case *LogicalVec, *IntVec, *DoubleVec, *ComplexVec, *CharVec:
+		return true
+	}
+	return false
+}
+
+// coerceTo converts v to the vector type typ, keeping its attributes.
+func coerceTo(ctx *Context, v Value, typ string) (Value, error) {
+	var out Value
+	switch typ {
+	case "logical":
+		lv, err := asLogicalVec(ctx, v)
+		if err != nil {
+			return nil, err
+		}
+		out = &LogicalVec{Data: append([]LogicalElem(nil), lv...)}
+	case "integer":
+		iv, err := coerceToIntVec(ctx, v)
+		if err != nil {
+			return nil, err
+		}
+		out = &IntVec{Data: append([]IntElem(nil), iv...)}
+	case "double":
+		dv, err := asDoubleVec(ctx, v)
+		if err != nil {
+			return nil, err
+		}
+		out = &DoubleVec{Data: append([]FloatElem(nil), dv...)}
+	case "complex":
+		zv, err := asComplexVec(ctx, v)
+		if err != nil {
+			return nil, err
+		}
+		out = &ComplexVec{Data: append([]ComplexElem(nil), zv...)}
+	case "character":
+		cv, err := asCharVec(ctx, v)
+		if err != nil {
+			return nil, err
+		}
+		out = &CharVec{Data: append([]StringElem(nil), cv...)}
+	default:
+		if lv, ok := v.(*ListVec); ok {
+			return lv, nil
+		}
+		data := make([]Value, v.Len())
+		for i := range data {
+			e, err := vectorElement(ctx, v, i)
+			if err != nil {
+				return nil, err
+			}
+			data[i] = e
+		}
+		out = &ListVec{Data: data}
+	}
+	for k, a := range v.Attrs() {
+		out.SetAttr(k, a)
+	}
+	return out, nil
 }
 
 func setDollar(ctx *Context, x Value, name string, rhs Value) (Value, error) {
@@ -1816,7 +2502,16 @@ func setDollar(ctx *Context, x Value, name string, rhs Value) (Value, error) {
 	if err != nil {
 		return nil, err
 	}
+	if isDataFrame(x) {
+		return dfAssign(ctx, x, MissingValue, CharScalar(name), rhs)
+	}
 	switch xv := x.(type) {
+	case *EnvValue:
+		if xv.Env == emptyEnv {
+			return nil, errEmptyEnv()
+		}
+		xv.Env.SetLocal(name, rhs)
+		return xv, nil
 	case *ListVec:
 		out := cloneList(xv)
 		// get names
@@ -1845,10 +2540,20 @@ func setDollar(ctx *Context, x Value, name string, rhs Value) (Value, error) {
 			}
 		}
 		if found >= 0 {
+			if rhs == NullValue {
+				// x$name <- NULL removes the element
+				out.Data = append(out.Data[:found], out.Data[found+1:]...)
+				names = append(names[:found:found], names[found+1:]...)
+				out.SetAttr("names", &CharVec{Data: names})
+				return out, nil
+			}
 			out.Data[found] = rhs
 			out.SetAttr("names", &CharVec{Data: names})
 			return out, nil
 		}
+		if rhs == NullValue {
+			return out, nil
+		}
 		// append
 		out.Data = append(out.Data, rhs)
 		names = append(names, StringElem{Val: name})
@@ -1859,6 +2564,41 @@ func setDollar(ctx *Context, x Value, name string, rhs Value) (Value, error) {
 	}
 }
 
+// cloneValue returns a copy of v that can be modified (data or attributes)
+// without affecting v. Non-vector values are returned as is.
+func cloneValue(v Value) Value {
+	switch t := v.(type) {
+	case *ListVec:
+		return cloneList(t)
+	case *DoubleVec:
+		return cloneDouble(t)
+	case *ComplexVec:
+		return cloneComplex(t)
+	case *IntVec:
+		return cloneInt(t)
+	case *LogicalVec:
+		return cloneLogical(t)
+	case *CharVec:
+		return cloneChar(t)
+	// functions and language objects are immutable apart from their
+	// attributes, so a copy may share everything else
+	case *ClosureFunc:
+		out := *t
+		out.Base = t.cloneAttrs()
+		return &out
+	case *BuiltinFunc:
+		out := *t
+		out.Base = t.cloneAttrs()
+		return &out
+	case *ExprValue:
+		out := *t
+		out.Base = t.cloneAttrs()
+		return &out
+	default:
+		return v
+	}
+}
+
 func cloneList(v *ListVec) *ListVec {
 	out := &ListVec{Data: append([]Value(nil), v.Data...)}
 	// shallow copy attrs
@@ -1874,6 +2614,14 @@ func cloneDouble(v *DoubleVec) *DoubleVec {
 	}
 	return out
 }
+func cloneComplex(v *ComplexVec) *ComplexVec {
+	out := &ComplexVec{Data: append([]ComplexElem(nil), v.Data...)}
+	for k, a := range v.Attrs() {
+		out.SetAttr(k, a)
+	}
+	return out
+}
+
 func cloneInt(v *IntVec) *IntVec {
 	out := &IntVec{Data: append([]IntElem(nil), v.Data...)}
 	for k, a := range v.Attrs() {
@@ -1926,6 +2674,12 @@ func coerceToIntVec(ctx *Context, v Value) ([]IntElem, error) {
 			}
 		}
 		return out, nil
+	case *ComplexVec:
+		dv, err := asDoubleVec(ctx, t)
+		if err != nil {
+			return nil, err
+		}
+		return coerceToIntVec(ctx, &DoubleVec{Data: dv})
 	default:
 		return nil, fmt.Errorf("cannot coerce %s to integer", v.Type())
 	}
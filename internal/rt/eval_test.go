@@ -40,6 +40,10 @@ func TestComparison(t *testing.T) {
 		{"1 == 1", "TRUE"},
 		{"1 != 2", "TRUE"},
 		{"1 == 2", "FALSE"},
+		{"numeric(0) > 1", "logical(0)"},
+		{`character(0) == "a"`, "logical(0)"},
+		{"1:3 == integer(0)", "logical(0)"},
+		{"logical(0) & TRUE", "logical(0)"},
 	}
 
 	for _, tt := range tests {
@@ -120,6 +124,51 @@ func TestFunction(t *testing.T) {
 	}
 }
 
+func TestArgumentMatching(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"f <- function(x, y) x - y; f(2, x = 10)", "8"},
+		{"f <- function(x, ..., n = 1) n; f(1, 2, 3)", "1"},
+		{"f <- function(x, ..., n = 1) length(list(...)); f(1, 2, n = 5, 3)", "2"},
+		{"f <- function(a, b) c(a, b); f(b = 1, 2)", "2 1"},
+		{"f <- function(..., n = 1) n; f(5)", "1"},
+		{"f <- function(x, y = x * 2) y; f(y = 1)", "1"},
+		{"f <- function(x, ..., n = 1) c(..., n); f(1, 2, n = 5, 3)", "2 3 5"},
+		{"f <- function(x, ..., n = 1) match.call(); f(1, 2, n = 3, 4)", "f(x = 1, 2, 4, n = 3)"},
+		{"f <- function(x, y = x + 1) return(y); f(1)", "2"},
+		{"g <- function(a) a; h <- function(b) g(b); h(3)", "3"},
+	}
+
+	for _, tt := range tests {
+		ctx := NewContext()
+		res, err := ctx.EvalString(tt.input)
+		if err != nil {
+			t.Errorf("input %q: unexpected error: %v", tt.input, err)
+			continue
+		}
+		if res.Value.String() != tt.expected {
+			t.Errorf("input %q: expected %q, got %q", tt.input, tt.expected, res.Value.String())
+		}
+	}
+
+	errs := []struct {
+		input    string
+		expected string
+	}{
+		{"f <- function(x) x; f(x = 1, x = 2)", "formal argument 'x' matched by multiple actual arguments"},
+		{"f <- function(x) x; f(1, 2)", "unused argument (positional)"},
+		{"f <- function(x) x; f(y = 1)", "unused argument 'y'"},
+	}
+	for _, tt := range errs {
+		ctx := NewContext()
+		if _, err := ctx.EvalString(tt.input); err == nil || err.Error() != tt.expected {
+			t.Errorf("input %q: expected error %q, got %v", tt.input, tt.expected, err)
+		}
+	}
+}
+
 func TestIfElse(t *testing.T) {
 	tests := []struct {
 		code     string
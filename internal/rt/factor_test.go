@@ -0,0 +1,86 @@
+package rt
+
+import "testing"
+
+func TestFactors(t *testing.T) {
+	const f = `f <- factor(c("lo", "hi", "mid", "hi"), levels = c("lo", "mid", "hi")); `
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{f + "levels(f)", `"lo" "mid" "hi"`},
+		{f + "as.integer(f)", "1 3 2 3"},
+		{f + "as.character(f)", `"lo" "hi" "mid" "hi"`},
+		{f + "nlevels(f)", "3"},
+		{f + "class(f)", `"factor"`},
+		{f + "as.integer(table(f))", "1 1 2"},
+		{f + "names(table(f))", `"lo" "mid" "hi"`},
+		{f + "as.character(sort(f))", `"lo" "mid" "hi" "hi"`},
+		{f + "levels(f[2:3])", `"lo" "mid" "hi"`},
+		{f + "f == \"hi\"", "FALSE TRUE FALSE TRUE"},
+		{f + "levels(f) <- c(\"L\", \"M\", \"H\"); as.character(f)", `"L" "H" "M" "H"`},
+		{f + "levels(f) <- c(\"x\", \"x\", \"y\"); levels(f)", `"x" "y"`},
+		{f + "levels(droplevels(f[f != \"mid\"]))", `"lo" "hi"`},
+		{f + "levels(relevel(f, ref = \"hi\"))", `"hi" "lo" "mid"`},
+		{f + "f[2] <- \"lo\"; as.integer(f)", "1 1 2 3"},
+		{"levels(factor(c(10, 9, 10)))", `"9" "10"`},
+		{"levels(factor(c(\"b\", \"a\", \"b\")))", `"a" "b"`},
+		{"levels(c(factor(\"a\"), factor(c(\"c\", \"a\"))))", `"a" "c"`},
+		{"as.integer(factor(c(\"a\", \"b\"), labels = c(\"x\", \"y\")))", "1 2"},
+		{"o <- factor(c(\"S\", \"L\", \"M\"), levels = c(\"S\", \"M\", \"L\"), ordered = TRUE); o < \"L\"", "TRUE FALSE TRUE"},
+		{"as.character(cut(c(1, 5, 10, 15, 20), breaks = c(0, 5, 10, 20)))", `"(0,5]" "(0,5]" "(5,10]" "(10,20]" "(10,20]"`},
+		{"levels(cut(c(1, 5, 10, 15, 20), 3))", `"(0.981,7.33]" "(7.33,13.7]" "(13.7,20]"`},
+		{"levels(cut(1:4, c(1, 2, 4), right = FALSE, include.lowest = TRUE))", `"[1,2)" "[2,4]"`},
+		{"cut(c(1, 6), c(0, 5, 10), labels = FALSE)", "1 2"},
+		{"df <- data.frame(g = c(\"x\", \"y\", \"x\"), stringsAsFactors = TRUE); levels(df$g)", `"x" "y"`},
+		{"dim(table(c(\"a\", \"b\", \"a\"), c(1, 1, 2)))", "2 2"},
+		{"summary(factor(c(\"a\", \"b\", \"a\")))", "2 1"},
+	}
+
+	for _, tt := range tests {
+		ctx := NewContext()
+		res, err := ctx.EvalString(tt.input)
+		if err != nil {
+			t.Errorf("input %q: unexpected error: %v", tt.input, err)
+			continue
+		}
+		if res.Value.String() != tt.expected {
+			t.Errorf("input %q: expected %s, got %s", tt.input, tt.expected, res.Value.String())
+		}
+	}
+}
+
+func TestFactorPrint(t *testing.T) {
+	ctx := NewContext()
+	res, err := ctx.EvalString(`print(factor(c("b", "a", NA)))`)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := "b a <NA>\nLevels: a b\n"
+	if res.Output != expected {
+		t.Errorf("expected output %q, got %q", expected, res.Output)
+	}
+}
+
+func TestTablePrint(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`print(table(c("b", "a", "b")))`, "a b \n1 2 \n"},
+		{`print(summary(factor(c("x", "long", "x", NA))))`, "long    x NA's \n   1    2    1 \n"},
+		{`print(c(a = 1.5, bb = 2))`, "  a  bb \n1.5 2.0 \n"},
+		{`print(c(u = "p", v = NA))`, `  u   v ` + "\n" + `"p"  NA ` + "\n"},
+	}
+	for _, tt := range tests {
+		ctx := NewContext()
+		res, err := ctx.EvalString(tt.input)
+		if err != nil {
+			t.Errorf("input %q: unexpected error: %v", tt.input, err)
+			continue
+		}
+		if res.Output != tt.expected {
+			t.Errorf("input %q: expected output %q, got %q", tt.input, tt.expected, res.Output)
+		}
+	}
+}
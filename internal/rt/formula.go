@@ -0,0 +1,1528 @@
+package rt
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"simonwaldherr.de/go/smallr/internal/ast"
+	"simonwaldherr.de/go/smallr/internal/deparse"
+	"simonwaldherr.de/go/smallr/internal/parser"
+	"simonwaldherr.de/go/smallr/internal/token"
+)
+
+func installFormulaBuiltins(env *Env) {
+	builtins := map[string]*BuiltinFunc{
+		"formula":        {FnName: "formula", Impl: builtinFormula},
+		"as.formula":     {FnName: "as.formula", Impl: builtinFormula},
+		"all.vars":       {FnName: "all.vars", Impl: builtinAllVars},
+		"terms":          {FnName: "terms", Impl: builtinTerms},
+		"update":         {FnName: "update", Impl: builtinUpdate},
+		"model.frame":    {FnName: "model.frame", Impl: builtinModelFrame},
+		"model.matrix":   {FnName: "model.matrix", Impl: builtinModelMatrix},
+		"model.response": {FnName: "model.response", Impl: builtinModelResponse},
+		"I":              {FnName: "I", Impl: builtinAsIs},
+		"na.omit":        {FnName: "na.omit", Impl: builtinNaOmit},
+		"na.fail":        {FnName: "na.fail", Impl: builtinNaFail},
+		"na.pass":        {FnName: "na.pass", Impl: builtinNaPass},
+		"aggregate":      {FnName: "aggregate", Impl: builtinAggregate},
+	}
+	for name, fn := range builtins {
+		env.SetLocal(name, fn)
+	}
+}
+
+// --- formulas ---
+
+// formulaSides splits a formula into its response and right-hand side;
+// lhs is nil for a one-sided formula such as ~ x.
+func formulaSides(f *ExprValue) (lhs, rhs ast.Expr) {
+	switch x := f.Expr.(type) {
+	case *ast.BinaryExpr:
+		return x.Left, x.Right
+	case *ast.UnaryExpr:
+		return nil, x.X
+	}
+	return nil, f.Expr
+}
+
+// formulaEnv returns the environment a formula was created in.
+func formulaEnv(ctx *Context, f Value) *Env {
+	if ev, ok := f.GetAttr(".Environment"); ok {
+		if e, ok := ev.(*EnvValue); ok {
+			return e.Env
+		}
+	}
+	return ctx.Global
+}
+
+// asFormula returns v as a formula: formulas and terms objects are used
+// as they are, strings are parsed, and objects that carry a "terms"
+// attribute or element (model frames, fitted models) give their terms.
+func asFormula(ctx *Context, v Value, env *Env) (*ExprValue, error) {
+	if isFormula(v) {
+		return v.(*ExprValue), nil
+	}
+	if t, ok := v.GetAttr("terms"); ok && isFormula(t) {
+		return t.(*ExprValue), nil
+	}
+	if l, ok := v.(*ListVec); ok {
+		names, _ := listNames(l)
+		for i, n := range names {
+			if n == "terms" && isFormula(l.Data[i]) {
+				return l.Data[i].(*ExprValue), nil
+			}
+		}
+	}
+	var expr ast.Expr
+	switch x := v.(type) {
+	case *ExprValue:
+		expr = x.Expr
+	case *CharVec:
+		if x.Len() != 1 || x.Data[0].NA {
+			return nil, fmt.Errorf("invalid formula")
+		}
+		prog, err := parser.New(x.Data[0].Val).ParseProgram()
+		if err != nil {
+			return nil, err
+		}
+		if len(prog.Exprs) != 1 {
+			return nil, fmt.Errorf("invalid formula")
+		}
+		expr = prog.Exprs[0]
+	}
+	if expr != nil {
+		if f, err := Eval(ctx, env, expr); err == nil && isFormula(f) {
+			return f.(*ExprValue), nil
+		}
+	}
+	return nil, fmt.Errorf("invalid formula")
+}
+
+// builtinFormula implements formula(x) and as.formula(x). For a terms
+// object it drops the attributes terms() added.
+func builtinFormula(ctx *Context, args []ArgValue) (Value, error) {
+	caller := ctx.callingEnv()
+	fargs, err := forceArgs(ctx, args)
+	if err != nil {
+		return nil, err
+	}
+	m, _ := matchArgs(fargs, "x", "env")
+	if m[0] == nil {
+		return nil, fmt.Errorf("argument \"x\" is missing, with no default")
+	}
+	env, err := envArg(m[1], caller, "env")
+	if err != nil {
+		return nil, err
+	}
+	f, err := asFormula(ctx, m[0], env)
+	if err != nil {
+		return nil, err
+	}
+	if m[1] != nil {
+		return makeFormula(env, f.Expr), nil
+	}
+	return makeFormula(formulaEnv(ctx, f), f.Expr), nil
+}
+
+// allVars appends the names of the variables in e: every symbol that is
+// not called as a function.
+func allVars(e ast.Expr, seen map[string]bool, out []string) []string {
+	switch x := e.(type) {
+	case nil:
+		return out
+	case *ast.Ident:
+		if x.Name != "" && !seen[x.Name] {
+			seen[x.Name] = true
+			out = append(out, x.Name)
+		}
+		return out
+	case *ast.FuncExpr:
+		return allVars(x.Body, seen, out)
+	}
+	fun, args, ok := callParts(e)
+	if !ok {
+		return out
+	}
+	if _, isSym := fun.(*ast.Ident); !isSym {
+		out = allVars(fun, seen, out)
+	}
+	for _, a := range args {
+		out = allVars(a.Value, seen, out)
+	}
+	return out
+}
+
+func builtinAllVars(ctx *Context, args []ArgValue) (Value, error) {
+	if len(args) < 1 {
+		return nil, fmt.Errorf("all.vars(expr) expects 1 argument")
+	}
+	x, err := Force(ctx, args[0].Val)
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	seen := map[string]bool{}
+	switch t := x.(type) {
+	case *ExprValue:
+		names = allVars(t.Expr, seen, nil)
+	case *ListVec:
+		for _, el := range t.Data {
+			if ev, ok := el.(*ExprValue); ok {
+				names = allVars(ev.Expr, seen, names)
+			}
+		}
+	}
+	return charVecOf(names), nil
+}
+
+// --- terms ---
+
+// modelTerms is the expansion of a model formula: the variables, response
+// first, and the terms, each a set of variables, as terms() reports them.
+type modelTerms struct {
+	formula   *ExprValue
+	env       *Env
+	response  bool
+	vars      []ast.Expr
+	varNames  []string
+	terms     [][]int // indices into vars, ascending
+	intercept bool
+	dotted    bool                // the formula used '.', which was replaced by columns
+	xlevels   map[string][]string // levels of the fitted factors, for predictions
+}
+
+// expandFormula computes the terms of f. data supplies the columns that
+// '.' stands for; it may be nil.
+func expandFormula(ctx *Context, f *ExprValue, data Value) (*modelTerms, error) {
+	mt := &modelTerms{formula: f, env: formulaEnv(ctx, f), intercept: true}
+	lhs, rhs := formulaSides(f)
+	if lhs != nil {
+		mt.response = true
+		mt.variable(lhs)
+	}
+	var columns []string
+	if l, ok := data.(*ListVec); ok {
+		columns, _ = listNames(l)
+	}
+	terms, err := mt.expand(rhs, columns)
+	if err != nil {
+		return nil, err
+	}
+	// terms are ordered by degree; within a degree they keep their order
+	sort.SliceStable(terms, func(i, j int) bool { return len(terms[i]) < len(terms[j]) })
+	mt.terms = terms
+	return mt, nil
+}
+
+// variable returns the index of the variable e, adding it when new.
+// Variables are identified by their deparsed source, as in R.
+func (mt *modelTerms) variable(e ast.Expr) int {
+	name := deparse.Expr(e)
+	for i, n := range mt.varNames {
+		if n == name {
+			return i
+		}
+	}
+	mt.vars = append(mt.vars, e)
+	mt.varNames = append(mt.varNames, name)
+	return len(mt.vars) - 1
+}
+
+// expand returns the terms of a right-hand side. It applies the formula
+// operators: + adds terms, - removes them, a:b is the interaction, a*b is
+// a + b + a:b, a/b is a + a:b, (a + b)^2 is all interactions up to order
+// two, and 0 or 1 remove or add the intercept.
+func (mt *modelTerms) expand(e ast.Expr, columns []string) ([][]int, error) {
+	switch x := e.(type) {
+	case *ast.NumberLit:
+		switch x.Value {
+		case 0:
+			mt.intercept = false
+			return nil, nil
+		case 1:
+			mt.intercept = true
+			return nil, nil
+		}
+		return nil, fmt.Errorf("invalid model formula")
+	case *ast.Ident:
+		if x.Name != "." {
+			break
+		}
+		if columns == nil {
+			return nil, fmt.Errorf("'.' in formula and no 'data' argument")
+		}
+		mt.dotted = true
+		var out [][]int
+		for _, c := range columns {
+			if mt.response && c == mt.varNames[0] {
+				continue
+			}
+			out = addTerm(out, []int{mt.variable(&ast.Ident{Name: c})})
+		}
+		return out, nil
+	case *ast.UnaryExpr:
+		switch x.Op {
+		case token.PLUS:
+			return mt.expand(x.X, columns)
+		case token.MINUS:
+			return mt.remove(nil, x.X, columns)
+		}
+	case *ast.BinaryExpr:
+		if x.Op == token.MINUS {
+			left, err := mt.expand(x.Left, columns)
+			if err != nil {
+				return nil, err
+			}
+			return mt.remove(left, x.Right, columns)
+		}
+		var left, right [][]int
+		var err error
+		switch x.Op {
+		case token.PLUS, token.STAR, token.COLON, token.SLASH, token.INOP:
+			if left, err = mt.expand(x.Left, columns); err != nil {
+				return nil, err
+			}
+			if right, err = mt.expand(x.Right, columns); err != nil {
+				return nil, err
+			}
+		case token.CARET:
+			if left, err = mt.expand(x.Left, columns); err != nil {
+				return nil, err
+			}
+			n, ok := x.Right.(*ast.NumberLit)
+			if !ok || n.Value < 1 {
+				return nil, fmt.Errorf("invalid power in formula")
+			}
+			out := left
+			for k := 1; k < int(n.Value); k++ {
+				out = addTerms(out, interact(out, left))
+			}
+			return out, nil
+		default:
+			return []([]int){{mt.variable(e)}}, nil
+		}
+		switch x.Op {
+		case token.PLUS:
+			return addTerms(left, right), nil
+		case token.STAR:
+			return addTerms(addTerms(left, right), interact(left, right)), nil
+		case token.COLON, token.INOP:
+			return interact(left, right), nil
+		case token.SLASH:
+			var all []int
+			for _, t := range left {
+				all = unionVars(all, t)
+			}
+			return addTerms(left, interact([][]int{all}, right)), nil
+		}
+	}
+	return [][]int{{mt.variable(e)}}, nil
+}
+
+// remove drops the terms of e from terms; - 1 and - 0 change the
+// intercept instead.
+func (mt *modelTerms) remove(terms [][]int, e ast.Expr, columns []string) ([][]int, error) {
+	if n, ok := e.(*ast.NumberLit); ok && (n.Value == 0 || n.Value == 1) {
+		mt.intercept = n.Value == 0
+		return terms, nil
+	}
+	intercept := mt.intercept
+	drop, err := mt.expand(e, columns)
+	mt.intercept = intercept
+	if err != nil {
+		return nil, err
+	}
+	var out [][]int
+	for _, t := range terms {
+		if indexOfTerm(drop, t) < 0 {
+			out = append(out, t)
+		}
+	}
+	return out, nil
+}
+
+func addTerm(terms [][]int, t []int) [][]int {
+	if indexOfTerm(terms, t) >= 0 {
+		return terms
+	}
+	return append(terms, t)
+}
+
+func addTerms(terms, more [][]int) [][]int {
+	for _, t := range more {
+		terms = addTerm(terms, t)
+	}
+	return terms
+}
+
+// interact returns every interaction of a term of a with a term of b.
+func interact(a, b [][]int) [][]int {
+	var out [][]int
+	for _, s := range a {
+		for _, t := range b {
+			out = addTerm(out, unionVars(s, t))
+		}
+	}
+	return out
+}
+
+// unionVars merges two sorted sets of variable indices.
+func unionVars(s, t []int) []int {
+	out := append([]int(nil), s...)
+	for _, v := range t {
+		k := sort.SearchInts(out, v)
+		if k < len(out) && out[k] == v {
+			continue
+		}
+		out = append(out, 0)
+		copy(out[k+1:], out[k:])
+		out[k] = v
+	}
+	return out
+}
+
+func indexOfTerm(terms [][]int, t []int) int {
+	for i, s := range terms {
+		if len(s) != len(t) {
+			continue
+		}
+		same := true
+		for k := range s {
+			same = same && s[k] == t[k]
+		}
+		if same {
+			return i
+		}
+	}
+	return -1
+}
+
+// labels returns the term labels, such as "x" or "a:b".
+func (mt *modelTerms) labels() []string {
+	out := make([]string, len(mt.terms))
+	for i, t := range mt.terms {
+		parts := make([]string, len(t))
+		for k, v := range t {
+			parts[k] = mt.varNames[v]
+		}
+		out[i] = strings.Join(parts, ":")
+	}
+	return out
+}
+
+// rebuild returns the formula that lists the terms one by one, as
+// update() and terms() with '.' produce it.
+func (mt *modelTerms) rebuild() ast.Expr {
+	var rhs ast.Expr
+	for _, t := range mt.terms {
+		var term ast.Expr
+		for _, v := range t {
+			if term == nil {
+				term = mt.vars[v]
+			} else {
+				term = &ast.BinaryExpr{Op: token.COLON, Left: term, Right: mt.vars[v]}
+			}
+		}
+		if rhs == nil {
+			rhs = term
+		} else {
+			rhs = &ast.BinaryExpr{Op: token.PLUS, Left: rhs, Right: term}
+		}
+	}
+	switch {
+	case rhs == nil && mt.intercept:
+		rhs = &ast.NumberLit{Text: "1", Value: 1}
+	case rhs == nil:
+		rhs = &ast.UnaryExpr{Op: token.MINUS, X: &ast.NumberLit{Text: "1", Value: 1}}
+	case !mt.intercept:
+		rhs = &ast.BinaryExpr{Op: token.MINUS, Left: rhs, Right: &ast.NumberLit{Text: "1", Value: 1}}
+	}
+	if !mt.response {
+		return &ast.UnaryExpr{Op: token.TILDE, X: rhs}
+	}
+	return &ast.BinaryExpr{Op: token.TILDE, Left: mt.vars[0], Right: rhs}
+}
+
+// value returns the terms object: the formula with the attributes
+// variables, factors, term.labels, order, intercept and response.
+func (mt *modelTerms) value() Value {
+	expr := mt.formula.Expr
+	if mt.dotted {
+		expr = mt.rebuild()
+	}
+	t := &ExprValue{Expr: expr}
+	varArgs := make([]ast.Arg, len(mt.vars))
+	for i, v := range mt.vars {
+		varArgs[i] = ast.Arg{Value: v}
+	}
+	t.SetAttr("variables", &ExprValue{Expr: &ast.CallExpr{Fun: &ast.Ident{Name: "list"}, Args: varArgs}})
+	labels := mt.labels()
+	if len(mt.terms) > 0 {
+		factors := make([]IntElem, len(mt.vars)*len(mt.terms))
+		for j, term := range mt.terms {
+			for _, v := range term {
+				factors[j*len(mt.vars)+v] = IntElem{Val: 1}
+			}
+		}
+		fm := &IntVec{Data: factors}
+		setDims(fm, len(mt.vars), len(mt.terms))
+		setDimNames(fm, mt.varNames, labels)
+		t.SetAttr("factors", fm)
+	} else {
+		t.SetAttr("factors", &IntVec{})
+	}
+	order := make([]IntElem, len(mt.terms))
+	for i, term := range mt.terms {
+		order[i] = IntElem{Val: int64(len(term))}
+	}
+	t.SetAttr("term.labels", charVecOf(labels))
+	t.SetAttr("order", &IntVec{Data: order})
+	t.SetAttr("intercept", IntScalar(boolInt(mt.intercept)))
+	t.SetAttr("response", IntScalar(boolInt(mt.response)))
+	t.SetAttr("class", charVecOf([]string{"terms", "formula"}))
+	t.SetAttr(".Environment", &EnvValue{Env: mt.env})
+	return t
+}
+
+func boolInt(b bool) int64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// termsArg expands the formula, terms object or model frame v.
+func termsArg(ctx *Context, v Value, data Value) (*modelTerms, error) {
+	f, err := asFormula(ctx, v, ctx.callingEnv())
+	if err != nil {
+		return nil, err
+	}
+	return expandFormula(ctx, f, data)
+}
+
+func builtinTerms(ctx *Context, args []ArgValue) (Value, error) {
+	fargs, err := forceArgs(ctx, args)
+	if err != nil {
+		return nil, err
+	}
+	m, _ := matchArgs(fargs, "x", "data")
+	if m[0] == nil {
+		return nil, fmt.Errorf("argument \"x\" is missing, with no default")
+	}
+	mt, err := termsArg(ctx, m[0], m[1])
+	if err != nil {
+		return nil, err
+	}
+	return mt.value(), nil
+}
+
+// builtinUpdate implements update(old, new) for formulas: '.' in new
+// stands for the corresponding side of old, and the result lists the
+// expanded terms.
+func builtinUpdate(ctx *Context, args []ArgValue) (Value, error) {
+	fargs, err := forceArgs(ctx, args)
+	if err != nil {
+		return nil, err
+	}
+	m, _ := matchArgs(fargs, "old", "new")
+	if m[0] == nil || m[1] == nil {
+		return nil, fmt.Errorf("update(old, new) expects 2 arguments")
+	}
+	old, err := asFormula(ctx, m[0], ctx.callingEnv())
+	if err != nil {
+		return nil, err
+	}
+	upd, err := asFormula(ctx, m[1], ctx.callingEnv())
+	if err != nil {
+		return nil, err
+	}
+	oldLHS, oldRHS := formulaSides(old)
+	newLHS, newRHS := formulaSides(upd)
+	dot := func(with ast.Expr) func(ast.Expr) (ast.Expr, bool) {
+		return func(e ast.Expr) (ast.Expr, bool) {
+			if id, ok := e.(*ast.Ident); ok && id.Name == "." && with != nil {
+				return with, true
+			}
+			return nil, false
+		}
+	}
+	lhs := oldLHS
+	if newLHS != nil {
+		if lhs, err = replaceExpr(newLHS, dot(oldLHS)); err != nil {
+			return nil, err
+		}
+	}
+	rhs, err := replaceExpr(newRHS, dot(oldRHS))
+	if err != nil {
+		return nil, err
+	}
+	var expr ast.Expr = &ast.UnaryExpr{Op: token.TILDE, X: rhs}
+	if lhs != nil {
+		expr = &ast.BinaryExpr{Op: token.TILDE, Left: lhs, Right: rhs}
+	}
+	env := formulaEnv(ctx, old)
+	mt, err := expandFormula(ctx, &ExprValue{Expr: expr}, nil)
+	if err != nil {
+		return nil, err
+	}
+	return makeFormula(env, mt.rebuild()), nil
+}
+
+// replaceExpr returns a copy of e in which every node that repl maps is
+// replaced.
+func replaceExpr(e ast.Expr, repl func(ast.Expr) (ast.Expr, bool)) (ast.Expr, error) {
+	if r, ok := repl(e); ok {
+		return r, nil
+	}
+	fun, args, ok := callParts(e)
+	if !ok {
+		return e, nil
+	}
+	fun, err := replaceExpr(fun, repl)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]ast.Arg, len(args))
+	for i, a := range args {
+		v, err := replaceExpr(a.Value, repl)
+		if err != nil {
+			return nil, err
+		}
+		out[i] = ast.Arg{Name: a.Name, Value: v}
+	}
+	return makeCall(e.Pos(), fun, out), nil
+}
+
+// --- model frames ---
+
+// frameOpts are the arguments of model.frame() besides formula and data.
+// subset and weights are unevaluated; they are evaluated in the data, or
+// in env when there is none.
+type frameOpts struct {
+	subset   ast.Expr
+	weights  ast.Expr
+	env      *Env
+	naAction string // "na.omit", "na.fail" or "na.pass"
+}
+
+// modelFrame evaluates the variables of mt in data (enclosed by the
+// formula's environment) and drops incomplete rows according to
+// opts.naAction. Weights become the column "(weights)".
+func modelFrame(ctx *Context, mt *modelTerms, data Value, opts frameOpts) (*ListVec, error) {
+	if data == NullValue {
+		data = nil
+	}
+	if data != nil && !isDataFrame(data) {
+		if _, ok := data.(*ListVec); !ok {
+			return nil, fmt.Errorf("'data' must be a data.frame, environment, or list")
+		}
+	}
+	env, err := evalEnvArg(data, mt.env)
+	if err != nil {
+		return nil, err
+	}
+	exprs, names := mt.vars, mt.varNames
+	if opts.weights != nil {
+		exprs = append(exprs[:len(exprs):len(exprs)], opts.weights)
+		names = append(names[:len(names):len(names)], "(weights)")
+	}
+	cols := make([]Value, len(exprs))
+	n := -1
+	if df, ok := data.(*ListVec); ok && isDataFrame(df) {
+		n = dfNRow(df)
+	}
+	for i, v := range exprs {
+		varEnv := env
+		if i >= len(mt.vars) {
+			varEnv = envOr(env, opts.env, data)
+		}
+		val, err := Eval(ctx, varEnv, v)
+		if err != nil {
+			return nil, err
+		}
+		if val, err = Force(ctx, val); err != nil {
+			return nil, err
+		}
+		if n < 0 {
+			n = frameRows(val)
+		}
+		if frameRows(val) != n {
+			return nil, fmt.Errorf("variable lengths differ (found for '%s')", names[i])
+		}
+		cols[i] = val
+	}
+	if n < 0 {
+		n = 0
+	}
+	rowNames := make([]string, n)
+	for i := range rowNames {
+		rowNames[i] = fmt.Sprint(i + 1)
+	}
+	if df, ok := data.(*ListVec); ok && isDataFrame(df) {
+		rowNames = dfRowNames(df)
+	}
+
+	keep := make([]bool, n)
+	for i := range keep {
+		keep[i] = true
+	}
+	if opts.subset != nil {
+		sv, err := Eval(ctx, envOr(env, opts.env, data), opts.subset)
+		if err != nil {
+			return nil, err
+		}
+		lv, err := Force(ctx, sv)
+		if err != nil {
+			return nil, err
+		}
+		sel, err := asLogicalVec(ctx, lv)
+		if err != nil {
+			return nil, err
+		}
+		if len(sel) == 0 {
+			return nil, fmt.Errorf("invalid subset")
+		}
+		for i := range keep {
+			e := sel[i%len(sel)]
+			keep[i] = !e.NA && e.Val
+		}
+	}
+	var omitted []int
+	if opts.naAction != "na.pass" {
+		for _, col := range cols {
+			na, err := naMask(ctx, col)
+			if err != nil {
+				return nil, err
+			}
+			for k, isNA := range na {
+				// a matrix variable, such as cbind(s, f), drops the
+				// rows with an NA in any column
+				i := k % n
+				if isNA && keep[i] {
+					if opts.naAction == "na.fail" {
+						return nil, fmt.Errorf("missing values in object")
+					}
+					keep[i] = false
+					omitted = append(omitted, i)
+				}
+			}
+		}
+	}
+	var rows []int
+	var kept []string
+	for i, k := range keep {
+		if k {
+			rows = append(rows, i)
+			kept = append(kept, rowNames[i])
+		}
+	}
+	if len(rows) < n {
+		idx := positionsToIndex(rows)
+		for i, col := range cols {
+			subs := []Value{idx}
+			if len(getDims(col)) == 2 {
+				subs = append(subs, MissingValue)
+			}
+			sub, err := subsetIndexed(ctx, col, subs, indexOpts{}, false)
+			if err != nil {
+				return nil, err
+			}
+			cols[i] = sub
+		}
+	}
+	colNames := make([]StringElem, len(names))
+	for i, name := range names {
+		colNames[i] = StringElem{Val: name}
+	}
+	// dropped rows keep their original names; otherwise 1:n is implied
+	var rn Value
+	switch {
+	case len(rows) < n || hasRowNames(data):
+		rn = charVecOf(kept)
+	case len(cols) == 0:
+		// without variables, as for ~ 1, the rows still come from data
+		rn = positionsToIndex(rows)
+	}
+	mf := newDataFrame(cols, colNames, rn)
+	mf.SetAttr("terms", mt.value())
+	if len(omitted) > 0 {
+		// like R, the "omit" object lists the dropped rows by number
+		sort.Ints(omitted)
+		action := positionsToIndex(omitted)
+		omittedNames := make([]string, len(omitted))
+		for i, r := range omitted {
+			omittedNames[i] = rowNames[r]
+		}
+		action.SetAttr("names", charVecOf(omittedNames))
+		action.SetAttr("class", CharScalar("omit"))
+		mf.SetAttr("na.action", action)
+	}
+	return mf, nil
+}
+
+// envOr returns the environment subset= is evaluated in: the data, or
+// the caller's environment when there is no data.
+// frameRows returns the number of rows a variable contributes to a model
+// frame: the rows of a matrix, the length of anything else.
+func frameRows(v Value) int {
+	if d := getDims(v); len(d) == 2 {
+		return d[0]
+	}
+	return v.Len()
+}
+
+func envOr(dataEnv, caller *Env, data Value) *Env {
+	if data == nil {
+		return caller
+	}
+	return dataEnv
+}
+
+// hasRowNames reports whether data is a data frame with character row
+// names.
+func hasRowNames(data Value) bool {
+	if data == nil {
+		return false
+	}
+	rn, ok := data.GetAttr("row.names")
+	if !ok {
+		return false
+	}
+	_, ok = rn.(*CharVec)
+	return ok
+}
+
+// naMask reports which elements of v are NA.
+func naMask(ctx *Context, v Value) ([]bool, error) {
+	res, err := builtinIsNA(ctx, []ArgValue{{Val: v}})
+	if err != nil {
+		return nil, err
+	}
+	lv, ok := res.(*LogicalVec)
+	if !ok {
+		return make([]bool, v.Len()), nil
+	}
+	out := make([]bool, len(lv.Data))
+	for i, e := range lv.Data {
+		out[i] = e.Val
+	}
+	return out, nil
+}
+
+// naActionName returns the name of an na.action argument, given as a
+// string or as one of the na.* functions.
+func naActionName(v Value) (string, error) {
+	switch t := v.(type) {
+	case nil:
+		return "na.omit", nil
+	case *CharVec:
+		if t.Len() == 1 && !t.Data[0].NA {
+			return naActionName(&BuiltinFunc{FnName: t.Data[0].Val})
+		}
+	case *BuiltinFunc:
+		switch t.FnName {
+		case "na.omit", "na.exclude", "na.fail", "na.pass":
+			if t.FnName == "na.exclude" {
+				return "na.omit", nil
+			}
+			return t.FnName, nil
+		}
+	}
+	return "", fmt.Errorf("invalid 'na.action' argument")
+}
+
+// promiseExpr returns the expression of a lazy argument, so that it can be
+// evaluated in another environment, as model.frame() does with subset.
+func promiseExpr(v Value) ast.Expr {
+	switch t := v.(type) {
+	case nil:
+		return nil
+	case *Promise:
+		return t.Expr
+	}
+	return lazyValueExpr(v)
+}
+
+// builtinModelFrame implements model.frame(formula, data, subset,
+// na.action, weights). subset and weights are evaluated in data.
+func builtinModelFrame(ctx *Context, args []ArgValue) (Value, error) {
+	m, _ := matchArgs(args, "formula", "data", "subset", "na.action", "weights")
+	if m[0] == nil {
+		return nil, fmt.Errorf("argument \"formula\" is missing, with no default")
+	}
+	opts := frameOpts{subset: promiseExpr(m[2]), weights: promiseExpr(m[4]), env: ctx.callingEnv()}
+	for i, v := range m {
+		if i == 2 || i == 4 || v == nil {
+			continue
+		}
+		fv, err := Force(ctx, v)
+		if err != nil {
+			return nil, err
+		}
+		m[i] = fv
+	}
+	if isDataFrame(m[0]) {
+		if _, ok := m[0].GetAttr("terms"); ok {
+			return m[0], nil
+		}
+	}
+	var err error
+	if opts.naAction, err = naActionName(m[3]); err != nil {
+		return nil, err
+	}
+	mt, err := termsArg(ctx, m[0], m[1])
+	if err != nil {
+		return nil, err
+	}
+	return modelFrame(ctx, mt, m[1], opts)
+}
+
+// modelResponse returns the response column of a model frame, named by
+// the row names, or nil when the model has none.
+func modelResponse(ctx *Context, mf *ListVec) (Value, error) {
+	t, _ := mf.GetAttr("terms")
+	if r, ok := t.(*ExprValue); !ok || r == nil {
+		return nil, fmt.Errorf("model frame has no terms")
+	}
+	resp, _ := t.GetAttr("response")
+	if resp == nil || toPlainStrings(resp)[0] != "1" || len(mf.Data) == 0 {
+		return nil, nil
+	}
+	y := cloneValue(mf.Data[0])
+	if getDims(y) == nil {
+		y.SetAttr("names", charVecOf(dfRowNames(mf)))
+	}
+	return y, nil
+}
+
+func builtinModelResponse(ctx *Context, args []ArgValue) (Value, error) {
+	if len(args) < 1 {
+		return nil, fmt.Errorf("model.response(data) expects 1 argument")
+	}
+	x, err := Force(ctx, args[0].Val)
+	if err != nil {
+		return nil, err
+	}
+	mf, ok := x.(*ListVec)
+	if !ok || !isDataFrame(mf) {
+		return nil, fmt.Errorf("model.response(data): 'data' must be a model frame")
+	}
+	y, err := modelResponse(ctx, mf)
+	if err != nil || y == nil {
+		return NullValue, err
+	}
+	return y, nil
+}
+
+// --- model matrices ---
+
+// designColumn is one column of a model matrix under construction.
+type designColumn struct {
+	name string
+	data []FloatElem
+}
+
+// factorColumns returns the columns that stand for variable v: a numeric
+// variable is one column; a factor (or a character or logical variable,
+// which is treated as one) gives one indicator per level, without the
+// first level when coded by treatment contrasts. xlevels, when not nil,
+// replaces the levels of v, so that new data is coded like the data a
+// model was fitted to.
+func factorColumns(ctx *Context, name string, v Value, contrasts bool, xlevels []string) ([]designColumn, bool, error) {
+	var labels []StringElem
+	var levels []string
+	switch {
+	case isFactor(v):
+		labels, levels = factorLabels(v), factorLevels(v)
+	case v.Type() == "character" || v.Type() == "logical":
+		var err error
+		if levels, err = sortedLevels(ctx, v); err != nil {
+			return nil, false, err
+		}
+		if v.Type() == "logical" {
+			levels = []string{"FALSE", "TRUE"}
+		}
+		cv, err := asCharVec(ctx, v)
+		if err != nil {
+			return nil, false, err
+		}
+		labels = cv
+	default:
+		fv, err := asDoubleVec(ctx, v)
+		if err != nil {
+			return nil, false, fmt.Errorf("invalid type (%s) for variable '%s'", v.Type(), name)
+		}
+		if nr, nc, ok := matrixDims(v); ok {
+			cols := make([]designColumn, nc)
+			colNames := dimNamesAt(v, 1)
+			for j := range cols {
+				cname := fmt.Sprint(name, j+1)
+				if colNames != nil {
+					cname = name + colNames[j]
+				}
+				cols[j] = designColumn{name: cname, data: fv[j*nr : (j+1)*nr]}
+			}
+			return cols, false, nil
+		}
+		return []designColumn{{name: name, data: fv}}, false, nil
+	}
+	if xlevels != nil {
+		levels = xlevels
+		for _, l := range labels {
+			if !l.NA && !containsString(levels, l.Val) {
+				return nil, false, fmt.Errorf("factor %s has new level %s", name, l.Val)
+			}
+		}
+	}
+	codes := factorCodes(labels, levels)
+	start := 0
+	if contrasts {
+		start = 1
+	}
+	var cols []designColumn
+	for k := start; k < len(levels); k++ {
+		data := make([]FloatElem, len(codes))
+		for i, c := range codes {
+			switch {
+			case c.NA:
+				data[i].NA = true
+			case int(c.Val) == k+1:
+				data[i].Val = 1
+			}
+		}
+		cols = append(cols, designColumn{name: name + levels[k], data: data})
+	}
+	return cols, true, nil
+}
+
+// modelMatrix builds the design matrix of mt from the model frame mf.
+// A factor in a term is coded by treatment contrasts when the term
+// without it (the intercept for a main effect) is already in the model,
+// and by one indicator per level otherwise.
+func modelMatrix(ctx *Context, mt *modelTerms, mf *ListVec) (*DoubleVec, error) {
+	n := dfNRow(mf)
+	var cols []designColumn
+	var assign []IntElem
+	if mt.intercept {
+		ones := make([]FloatElem, n)
+		for i := range ones {
+			ones[i].Val = 1
+		}
+		cols = append(cols, designColumn{name: "(Intercept)", data: ones})
+		assign = append(assign, IntElem{Val: 0})
+	}
+	emptyPresent := mt.intercept
+	var contrasted []string
+	for ti, term := range mt.terms {
+		termCols := []designColumn{{data: nil}}
+		for _, v := range term {
+			margin := removeVar(term, v)
+			coded := emptyPresent
+			if len(margin) > 0 {
+				coded = indexOfTerm(mt.terms[:ti], margin) >= 0
+			}
+			vc, isFactorVar, err := factorColumns(ctx, mt.varNames[v], mf.Data[v], coded, mt.xlevels[mt.varNames[v]])
+			if err != nil {
+				return nil, err
+			}
+			if isFactorVar {
+				if !coded && len(margin) == 0 {
+					emptyPresent = true
+				}
+				if coded && !containsString(contrasted, mt.varNames[v]) {
+					contrasted = append(contrasted, mt.varNames[v])
+				}
+			}
+			termCols = crossColumns(termCols, vc)
+		}
+		for _, c := range termCols {
+			cols = append(cols, c)
+			assign = append(assign, IntElem{Val: int64(ti + 1)})
+		}
+	}
+	data := make([]FloatElem, 0, n*len(cols))
+	names := make([]string, len(cols))
+	for j, c := range cols {
+		names[j] = c.name
+		data = append(data, c.data...)
+	}
+	mm := newDoubleMatrix(data, n, len(cols))
+	setDimNames(mm, dfRowNames(mf), names)
+	mm.SetAttr("assign", &IntVec{Data: assign})
+	if len(contrasted) > 0 {
+		vals := make([]Value, len(contrasted))
+		for i := range vals {
+			vals[i] = CharScalar("contr.treatment")
+		}
+		cl := &ListVec{Data: vals}
+		cl.SetAttr("names", charVecOf(contrasted))
+		mm.SetAttr("contrasts", cl)
+	}
+	return mm, nil
+}
+
+// modelLevels returns the levels of the factor and character variables
+// of a model frame, as the xlevels element of a fitted model keeps them.
+func modelLevels(ctx *Context, mt *modelTerms, mf *ListVec) (map[string][]string, []string, error) {
+	levels := map[string][]string{}
+	var names []string
+	for i, v := range mf.Data[:len(mt.vars)] {
+		if mt.response && i == 0 {
+			continue
+		}
+		switch {
+		case isFactor(v):
+			levels[mt.varNames[i]] = factorLevels(v)
+		case v.Type() == "character":
+			l, err := sortedLevels(ctx, v)
+			if err != nil {
+				return nil, nil, err
+			}
+			levels[mt.varNames[i]] = l
+		default:
+			continue
+		}
+		names = append(names, mt.varNames[i])
+	}
+	return levels, names, nil
+}
+
+func removeVar(term []int, v int) []int {
+	var out []int
+	for _, t := range term {
+		if t != v {
+			out = append(out, t)
+		}
+	}
+	return out
+}
+
+// crossColumns returns the products of the columns of a and b, with the
+// columns of a varying fastest, named a:b.
+func crossColumns(a, b []designColumn) []designColumn {
+	var out []designColumn
+	for _, cb := range b {
+		for _, ca := range a {
+			if ca.data == nil {
+				out = append(out, cb)
+				continue
+			}
+			data := make([]FloatElem, len(ca.data))
+			for i := range data {
+				data[i] = FloatElem{Val: ca.data[i].Val * cb.data[i].Val, NA: ca.data[i].NA || cb.data[i].NA}
+			}
+			out = append(out, designColumn{name: ca.name + ":" + cb.name, data: data})
+		}
+	}
+	return out
+}
+
+// builtinModelMatrix implements model.matrix(object, data). object is a
+// formula, a terms object or a model frame.
+func builtinModelMatrix(ctx *Context, args []ArgValue) (Value, error) {
+	fargs, err := forceArgs(ctx, args)
+	if err != nil {
+		return nil, err
+	}
+	m, _ := matchArgs(fargs, "object", "data")
+	if m[0] == nil {
+		return nil, fmt.Errorf("argument \"object\" is missing, with no default")
+	}
+	mf, ok := m[0].(*ListVec)
+	if !ok || !isDataFrame(mf) {
+		mf = nil
+	}
+	data := m[1]
+	if mf != nil && data == nil {
+		data = mf
+	}
+	mt, err := termsArg(ctx, m[0], data)
+	if err != nil {
+		return nil, err
+	}
+	if mf == nil || m[1] != nil {
+		if mf, err = modelFrame(ctx, mt, m[1], frameOpts{naAction: "na.omit"}); err != nil {
+			return nil, err
+		}
+	}
+	return modelMatrix(ctx, mt, mf)
+}
+
+// --- I, na.* ---
+
+// builtinAsIs implements I(x): x with class "AsIs" added, so that a
+// formula uses I(x^2) as a variable instead of expanding it.
+func builtinAsIs(ctx *Context, args []ArgValue) (Value, error) {
+	if len(args) != 1 {
+		return nil, fmt.Errorf("I(x) expects 1 argument")
+	}
+	x, err := Force(ctx, args[0].Val)
+	if err != nil {
+		return nil, err
+	}
+	if hasClass(x, "AsIs") {
+		return x, nil
+	}
+	out := cloneValue(x)
+	out.SetAttr("class", charVecOf(append([]string{"AsIs"}, classAttr(x)...)))
+	return out, nil
+}
+
+// builtinNaOmit drops the NA elements of a vector, or the rows of a data
+// frame that contain an NA.
+func builtinNaOmit(ctx *Context, args []ArgValue) (Value, error) {
+	if len(args) < 1 {
+		return nil, fmt.Errorf("na.omit(object) expects 1 argument")
+	}
+	x, err := Force(ctx, args[0].Val)
+	if err != nil {
+		return nil, err
+	}
+	var drop []bool
+	if df, ok := x.(*ListVec); ok && isDataFrame(df) {
+		drop = make([]bool, dfNRow(df))
+		for _, col := range df.Data {
+			na, err := naMask(ctx, col)
+			if err != nil {
+				return nil, err
+			}
+			for i, b := range na {
+				drop[i] = drop[i] || b
+			}
+		}
+	} else if drop, err = naMask(ctx, x); err != nil {
+		return nil, err
+	}
+	var keep []int
+	for i, d := range drop {
+		if !d {
+			keep = append(keep, i)
+		}
+	}
+	if len(keep) == len(drop) {
+		return x, nil
+	}
+	if isDataFrame(x) {
+		return dfSelect(ctx, x, positionsToIndex(keep), MissingValue, false)
+	}
+	return subsetIndexed(ctx, x, []Value{positionsToIndex(keep)}, indexOpts{}, false)
+}
+
+func builtinNaFail(ctx *Context, args []ArgValue) (Value, error) {
+	if len(args) < 1 {
+		return nil, fmt.Errorf("na.fail(object) expects 1 argument")
+	}
+	x, err := builtinNaOmit(ctx, args[:1])
+	if err != nil {
+		return nil, err
+	}
+	if orig, _ := Force(ctx, args[0].Val); x != orig {
+		return nil, fmt.Errorf("missing values in object")
+	}
+	return x, nil
+}
+
+func builtinNaPass(ctx *Context, args []ArgValue) (Value, error) {
+	if len(args) < 1 {
+		return nil, fmt.Errorf("na.pass(object) expects 1 argument")
+	}
+	return Force(ctx, args[0].Val)
+}
+
+// --- aggregate ---
+
+// builtinAggregate implements aggregate(formula, data, FUN, ...) and
+// aggregate(x, by, FUN, ...). FUN is applied to each value column within
+// each combination of the grouping variables that occurs; the result has
+// one row per group, sorted with the first grouping variable varying
+// fastest.
+func builtinAggregate(ctx *Context, args []ArgValue) (Value, error) {
+	m, rest := matchArgs(args, "x", "data", "FUN", "...")
+	if m[0] == nil {
+		for i, a := range args {
+			if a.Name == "formula" {
+				m[0] = a.Val
+				rest = append(rest[:0:0], args[:i]...)
+				rest = append(rest, args[i+1:]...)
+				m2, r2 := matchArgs(rest, "data", "FUN", "...")
+				m[1], m[2], rest = m2[0], m2[1], r2
+				break
+			}
+		}
+	}
+	if m[0] == nil {
+		return nil, fmt.Errorf("argument \"x\" is missing, with no default")
+	}
+	x, err := Force(ctx, m[0])
+	if err != nil {
+		return nil, err
+	}
+	var extra []ArgValue
+	var naAction Value
+	for _, a := range rest {
+		if a.Name == "by" && !isFormula(x) {
+			m[1] = a.Val
+			continue
+		}
+		if a.Name == "na.action" {
+			if naAction, err = Force(ctx, a.Val); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		extra = append(extra, a)
+	}
+	if m[2] == nil {
+		return nil, fmt.Errorf("argument \"FUN\" is missing, with no default")
+	}
+	fv, err := Force(ctx, m[2])
+	if err != nil {
+		return nil, err
+	}
+	if cv, ok := fv.(*CharVec); ok && cv.Len() == 1 {
+		if fv, ok = ctx.callingEnv().Get(cv.Data[0].Val); !ok {
+			return nil, fmt.Errorf("could not find function \"%s\"", cv.Data[0].Val)
+		}
+		if fv, err = Force(ctx, fv); err != nil {
+			return nil, err
+		}
+	}
+	fn, ok := fv.(Callable)
+	if !ok {
+		return nil, fmt.Errorf("'FUN' is not a function")
+	}
+	var data Value
+	if m[1] != nil {
+		if data, err = Force(ctx, m[1]); err != nil {
+			return nil, err
+		}
+	}
+	if isFormula(x) {
+		return aggregateFormula(ctx, x.(*ExprValue), data, naAction, fn, extra)
+	}
+	by, ok := data.(*ListVec)
+	if !ok {
+		return nil, fmt.Errorf("'by' must be a list")
+	}
+	var values []Value
+	var valueNames []string
+	if df, ok := x.(*ListVec); ok && isDataFrame(df) {
+		values = df.Data
+		valueNames, _ = listNames(df)
+	} else {
+		values, valueNames = []Value{x}, []string{"x"}
+	}
+	byNames, _ := listNames(by)
+	groupNames := make([]string, len(by.Data))
+	for i := range groupNames {
+		if i < len(byNames) && byNames[i] != "" {
+			groupNames[i] = byNames[i]
+		} else {
+			groupNames[i] = fmt.Sprintf("Group.%d", i+1)
+		}
+	}
+	return aggregateGroups(ctx, values, valueNames, by.Data, groupNames, fn, extra)
+}
+
+// aggregateFormula handles aggregate(y ~ g, data, FUN). cbind(a, b) on the
+// left aggregates several columns, and '.' on either side stands for all
+// columns not used on the other.
+func aggregateFormula(ctx *Context, f *ExprValue, data Value, naAction Value, fn Callable, extra []ArgValue) (Value, error) {
+	lhs, rhs := formulaSides(f)
+	if lhs == nil {
+		return nil, fmt.Errorf("'formula' missing or incorrect")
+	}
+	var columns []string
+	if l, ok := data.(*ListVec); ok {
+		columns, _ = listNames(l)
+	}
+	var lhsVars []ast.Expr
+	if c, ok := lhs.(*ast.CallExpr); ok && isIdent(c.Fun) && c.Fun.(*ast.Ident).Name == "cbind" {
+		for _, a := range c.Args {
+			lhsVars = append(lhsVars, a.Value)
+		}
+	} else {
+		lhsVars = []ast.Expr{lhs}
+	}
+	rhsTerms := &modelTerms{env: formulaEnv(ctx, f)}
+	var rhsVars []ast.Expr
+	if id, ok := rhs.(*ast.Ident); !ok || id.Name != "." {
+		terms, err := rhsTerms.expand(rhs, nil)
+		if err != nil {
+			return nil, err
+		}
+		for _, t := range terms {
+			if len(t) == 1 {
+				rhsVars = append(rhsVars, rhsTerms.vars[t[0]])
+			}
+		}
+	}
+	used := map[string]bool{}
+	for _, v := range append(append([]ast.Expr(nil), lhsVars...), rhsVars...) {
+		used[deparse.Expr(v)] = true
+	}
+	dotColumns := func() ([]ast.Expr, error) {
+		if columns == nil {
+			return nil, fmt.Errorf("'.' in formula and no 'data' argument")
+		}
+		var out []ast.Expr
+		for _, c := range columns {
+			if !used[c] {
+				out = append(out, &ast.Ident{Name: c})
+			}
+		}
+		return out, nil
+	}
+	var err error
+	if len(lhsVars) == 1 && deparse.Expr(lhsVars[0]) == "." {
+		delete(used, ".")
+		if lhsVars, err = dotColumns(); err != nil {
+			return nil, err
+		}
+	} else if rhsVars == nil {
+		if rhsVars, err = dotColumns(); err != nil {
+			return nil, err
+		}
+	}
+	// the model frame of all variables drops incomplete rows
+	mt := &modelTerms{env: rhsTerms.env}
+	for _, v := range append(append([]ast.Expr(nil), lhsVars...), rhsVars...) {
+		mt.variable(v)
+	}
+	action, err := naActionName(naAction)
+	if err != nil {
+		return nil, err
+	}
+	mt.formula = f
+	mf, err := modelFrame(ctx, mt, data, frameOpts{naAction: action})
+	if err != nil {
+		return nil, err
+	}
+	nl := len(lhsVars)
+	return aggregateGroups(ctx, mf.Data[:nl], mt.varNames[:nl], mf.Data[nl:], mt.varNames[nl:], fn, extra)
+}
+
+// aggregateGroups applies fn to each value column within each group.
+func aggregateGroups(ctx *Context, values []Value, valueNames []string, groups []Value, groupNames []string, fn Callable, extra []ArgValue) (Value, error) {
+	if len(groups) == 0 {
+		return nil, fmt.Errorf("no grouping variables")
+	}
+	n := groups[0].Len()
+	codes := make([][]IntElem, len(groups))
+	for g, gv := range groups {
+		if gv.Len() != n {
+			return nil, fmt.Errorf("arguments must have same length")
+		}
+		var labels []StringElem
+		var levels []string
+		if isFactor(gv) {
+			labels, levels = factorLabels(gv), factorLevels(gv)
+		} else {
+			var err error
+			if levels, err = sortedLevels(ctx, gv); err != nil {
+				return nil, err
+			}
+			if labels, err = asCharVec(ctx, gv); err != nil {
+				return nil, err
+			}
+		}
+		codes[g] = factorCodes(labels, levels)
+	}
+	for _, v := range values {
+		if v.Len() != n {
+			return nil, fmt.Errorf("arguments must have same length")
+		}
+	}
+	// collect the rows of each combination that occurs
+	type group struct {
+		key  []int64
+		rows []int
+	}
+	byKey := map[string]*group{}
+	var order []*group
+	for i := 0; i < n; i++ {
+		key := make([]int64, len(groups))
+		skip := false
+		for g := range groups {
+			if codes[g][i].NA {
+				skip = true
+				break
+			}
+			key[g] = codes[g][i].Val
+		}
+		if skip {
+			continue
+		}
+		k := fmt.Sprint(key)
+		gr, ok := byKey[k]
+		if !ok {
+			gr = &group{key: key}
+			byKey[k] = gr
+			order = append(order, gr)
+		}
+		gr.rows = append(gr.rows, i)
+	}
+	sort.Slice(order, func(a, b int) bool {
+		ka, kb := order[a].key, order[b].key
+		for g := len(ka) - 1; g >= 0; g-- {
+			if ka[g] != kb[g] {
+				return ka[g] < kb[g]
+			}
+		}
+		return false
+	})
+
+	var cols []Value
+	var names []StringElem
+	first := make([]int, len(order))
+	for i, gr := range order {
+		first[i] = gr.rows[0]
+	}
+	for g, gv := range groups {
+		col, err := subsetIndexed(ctx, gv, []Value{positionsToIndex(first)}, indexOpts{}, false)
+		if err != nil {
+			return nil, err
+		}
+		col.SetAttr("names", nil)
+		cols = append(cols, col)
+		names = append(names, StringElem{Val: groupNames[g]})
+	}
+	for v, val := range values {
+		results := make([]ArgValue, len(order))
+		for i, gr := range order {
+			part, err := subsetIndexed(ctx, val, []Value{positionsToIndex(gr.rows)}, indexOpts{}, false)
+			if err != nil {
+				return nil, err
+			}
+			callArgs := append([]ArgValue{{Val: part}}, extra...)
+			res, err := fn.Call(ctx, nil, callArgs)
+			if err != nil {
+				return nil, err
+			}
+			if res, err = Force(ctx, res); err != nil {
+				return nil, err
+			}
+			results[i] = ArgValue{Val: res}
+		}
+		col, err := builtinC(ctx, results)
+		if err != nil {
+			return nil, err
+		}
+		if col.Len() != len(order) {
+			col = &ListVec{Data: argValues(results)}
+		}
+		col.SetAttr("names", nil)
+		cols = append(cols, col)
+		names = append(names, StringElem{Val: valueNames[v]})
+	}
+	return newDataFrame(cols, names, nil), nil
+}
+
+func argValues(args []ArgValue) []Value {
+	out := make([]Value, len(args))
+	for i, a := range args {
+		out[i] = a.Val
+	}
+	return out
+}
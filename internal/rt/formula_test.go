@@ -0,0 +1,99 @@
+package rt
+
+import "testing"
+
+const formulaData = `df <- data.frame(y = c(1, 3, 2, 5, 4, 6), x = c(1, 2, 3, 4, 5, 6),
+  g = factor(c("a", "b", "a", "b", "c", "c")))
+`
+
+func TestFormula(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"all.vars(y ~ log(x) + I(z^2))", `"y" "x" "z"`},
+		{"length(all.vars(~ 1))", "0"},
+		{"formula(\"y ~ x + z\")", "y ~ x + z"},
+		{"class(as.formula(\"y ~ x\"))", `"formula"`},
+		{"attr(terms(y ~ a * b), \"term.labels\")", `"a" "b" "a:b"`},
+		{"attr(terms(y ~ (a + b + c)^2), \"term.labels\")", `"a" "b" "c" "a:b" "a:c" "b:c"`},
+		{"attr(terms(y ~ a:b + a), \"order\")", "1 2"},
+		{"attr(terms(y ~ a/b), \"term.labels\")", `"a" "a:b"`},
+		{"attr(terms(y ~ a * b - a:b), \"term.labels\")", `"a" "b"`},
+		{"attr(terms(y ~ x - 1), \"intercept\")", "0"},
+		{"attr(terms(~ x), \"response\")", "0"},
+		{"attr(terms(y ~ a + b), \"variables\")", "list(y, a, b)"},
+		{"class(terms(y ~ x))", `"terms" "formula"`},
+		{formulaData + "attr(terms(y ~ ., data = df), \"term.labels\")", `"x" "g"`},
+		{formulaData + "terms(y ~ ., data = df)", "y ~ x + g"},
+		{"update(y ~ x, ~ . + z)", "y ~ x + z"},
+		{"update(y ~ x + z, log(.) ~ . - z)", "log(y) ~ x"},
+		{"update(y ~ x, . ~ . - 1)", "y ~ x - 1"},
+		{formulaData + "names(model.frame(log(y) ~ x, df))", `"log(y)" "x"`},
+		{formulaData + "rownames(model.frame(y ~ x, df, subset = x > 3))", `"4" "5" "6"`},
+		{"rownames(model.frame(y ~ x, data.frame(y = c(1, NA, 3), x = 1:3)))", `"1" "3"`},
+		{"nrow(model.frame(y ~ x, data.frame(y = c(1, NA, 3), x = 1:3), na.action = na.pass))", "3"},
+		{"y <- c(2, 4); x <- 1:2; model.frame(y ~ x)$y", "2 4"},
+		{formulaData + "model.response(model.frame(y ~ x, df))", "1 3 2 5 4 6"},
+		{formulaData + "colnames(model.matrix(y ~ x * g, df))", `"(Intercept)" "x" "gb" "gc" "x:gb" "x:gc"`},
+		{formulaData + "attr(model.matrix(y ~ x * g, df), \"assign\")", "0 1 2 2 3 3"},
+		{formulaData + "colnames(model.matrix(y ~ g - 1, df))", `"ga" "gb" "gc"`},
+		{formulaData + "colnames(model.matrix(y ~ g + g:x, df))", `"(Intercept)" "gb" "gc" "ga:x" "gb:x" "gc:x"`},
+		{formulaData + "model.matrix(y ~ g, df)[, \"gc\"]", "0 0 0 0 1 1"},
+		{formulaData + "model.matrix(y ~ x + I(x^2), df)[, 3]", "1 4 9 16 25 36"},
+		{formulaData + "names(attr(model.matrix(y ~ g, df), \"contrasts\"))", `"g"`},
+		{"colnames(model.matrix(~ a + b, data.frame(a = c(\"u\", \"v\"), b = c(TRUE, FALSE))))", `"(Intercept)" "av" "bTRUE"`},
+		{formulaData + "dim(model.matrix(model.frame(y ~ x, df)))", "6 2"},
+		{"nrow(model.frame(~ 1, data.frame(x = 1:2)))", "2"},
+		{"dim(model.frame(~ 1, data.frame(x = 1:3), subset = x > 1))", "2 0"},
+		{"df <- data.frame(x = 1:2); m <- model.matrix(~ 1, df); c(dim(m), m)", "2 1 1 1"},
+		{"df <- data.frame(x = 1:2); colnames(model.matrix(~ 1, df))", `"(Intercept)"`},
+		{"class(I(1:3))", `"AsIs"`},
+		{"na.omit(c(1, NA, 3))", "1 3"},
+		{"nrow(na.omit(data.frame(a = c(1, NA, 3), b = c(\"x\", \"y\", NA))))", "1"},
+		{formulaData + "a <- aggregate(y ~ g, data = df, FUN = mean); a$y", "1.5 4 5"},
+		{formulaData + "a <- aggregate(y ~ g, data = df, FUN = mean); levels(a$g)", `"a" "b" "c"`},
+		{formulaData + "names(aggregate(cbind(y, x) ~ g, data = df, FUN = sum))", `"g" "y" "x"`},
+		{formulaData + "aggregate(. ~ g, data = df, FUN = max)$x", "3 4 6"},
+		{formulaData + "aggregate(x ~ g, df, function(v, k) sum(v) * k, k = 10)$x", "40 60 110"},
+		{formulaData + "names(aggregate(df$y, by = list(df$g), FUN = mean))", `"Group.1" "x"`},
+		{"a <- aggregate(c(1, 2, 3, 4), by = list(u = c(1, 2, 1, 2), v = c(\"p\", \"p\", \"q\", \"q\")), FUN = sum); a$x", "1 2 3 4"},
+	}
+
+	for _, tt := range tests {
+		ctx := NewContext()
+		res, err := ctx.EvalString(tt.input)
+		if err != nil {
+			t.Errorf("input %q: unexpected error: %v", tt.input, err)
+			continue
+		}
+		if res.Value.String() != tt.expected {
+			t.Errorf("input %q: expected %s, got %s", tt.input, tt.expected, res.Value.String())
+		}
+	}
+}
+
+func TestFormulaErrors(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"terms(y ~ .)", "'.' in formula and no 'data' argument"},
+		{"y <- 1:3; x <- 1:2; model.frame(y ~ x)", "variable lengths differ (found for 'x')"},
+		{"model.frame(y ~ x, data.frame(y = c(1, NA), x = 1:2), na.action = na.fail)", "missing values in object"},
+		{"aggregate(1:3, by = 1:3, FUN = sum)", "'by' must be a list"},
+		{"as.formula(1)", "invalid formula"},
+	}
+
+	for _, tt := range tests {
+		ctx := NewContext()
+		_, err := ctx.EvalString(tt.input)
+		if err == nil {
+			t.Errorf("input %q: expected error", tt.input)
+			continue
+		}
+		if err.Error() != tt.expected {
+			t.Errorf("input %q: expected %q, got %q", tt.input, tt.expected, err.Error())
+		}
+	}
+}
@@ -0,0 +1,1216 @@
+package rt
+
+import (
+	"fmt"
+	"math"
+	"strings"
+
+	"simonwaldherr.de/go/smallr/internal/ast"
+	"simonwaldherr.de/go/smallr/internal/deparse"
+)
+
+func installGlmBuiltins(env *Env) {
+	builtins := map[string]*BuiltinFunc{
+		"glm":         {FnName: "glm", Impl: builtinGlm},
+		"glm.control": {FnName: "glm.control", Impl: builtinGlmControl},
+		"family":      {FnName: "family", Impl: builtinFamily, Generic: true},
+	}
+	for name := range familyLinks {
+		builtins[name] = &BuiltinFunc{FnName: name, Impl: familyConstructor(name)}
+	}
+	for name, fn := range builtins {
+		env.SetLocal(name, fn)
+	}
+}
+
+// --- families ---
+
+// glmFamily is the error distribution and link function of a glm.
+type glmFamily struct {
+	family string
+	link   string
+}
+
+// familyLinks lists the links each family accepts, in the order R's
+// error message gives them; familyDefaultLink holds the defaults.
+var familyLinks = map[string][]string{
+	"binomial": {"logit", "probit", "cloglog", "cauchit", "log"},
+	"poisson":  {"log", "identity", "sqrt"},
+	"gaussian": {"inverse", "log", "identity"},
+	"Gamma":    {"inverse", "log", "identity"},
+}
+
+var familyDefaultLink = map[string]string{
+	"binomial": "logit",
+	"poisson":  "log",
+	"gaussian": "identity",
+	"Gamma":    "inverse",
+}
+
+func newFamily(family, link string) (*glmFamily, error) {
+	links, ok := familyLinks[family]
+	if !ok {
+		return nil, fmt.Errorf("family '%s' not recognized", family)
+	}
+	if link == "" {
+		link = familyDefaultLink[family]
+	}
+	for _, l := range links {
+		if l == link {
+			return &glmFamily{family: family, link: link}, nil
+		}
+	}
+	quoted := make([]string, len(links))
+	for i, l := range links {
+		quoted[i] = "‘" + l + "’"
+	}
+	return nil, fmt.Errorf("link \"%s\" not available for %s family; available links are %s", link, family, strings.Join(quoted, ", "))
+}
+
+// fixedDispersion reports whether the dispersion of the family is 1
+// rather than estimated from the residuals.
+func (f *glmFamily) fixedDispersion() bool {
+	return f.family == "binomial" || f.family == "poisson"
+}
+
+func (f *glmFamily) linkfun(mu float64) float64 {
+	switch f.link {
+	case "logit":
+		return math.Log(mu / (1 - mu))
+	case "probit":
+		return qnormFn(mu, 0, 1, true, false)
+	case "cauchit":
+		return math.Tan(math.Pi * (mu - 0.5))
+	case "cloglog":
+		return math.Log(-math.Log1p(-mu))
+	case "log":
+		return math.Log(mu)
+	case "inverse":
+		return 1 / mu
+	case "sqrt":
+		return math.Sqrt(mu)
+	}
+	return mu
+}
+
+// linkinv maps the linear predictor to the mean. Like R, the links of
+// the binomial family keep the mean strictly inside (0, 1).
+func (f *glmFamily) linkinv(eta float64) float64 {
+	switch f.link {
+	case "logit":
+		var e float64
+		switch {
+		case eta < -30:
+			e = machineEps
+		case eta > 30:
+			e = 1 / machineEps
+		default:
+			e = math.Exp(eta)
+		}
+		return e / (1 + e)
+	case "probit":
+		thresh := -qnormFn(machineEps, 0, 1, true, false)
+		return pnormFn(math.Max(-thresh, math.Min(thresh, eta)), 0, 1, true, false)
+	case "cauchit":
+		thresh := -math.Tan(math.Pi * (machineEps - 0.5))
+		return 0.5 + math.Atan(math.Max(-thresh, math.Min(thresh, eta)))/math.Pi
+	case "cloglog":
+		return math.Max(math.Min(-math.Expm1(-math.Exp(eta)), 1-machineEps), machineEps)
+	case "log":
+		return math.Max(math.Exp(eta), machineEps)
+	case "inverse":
+		return 1 / eta
+	case "sqrt":
+		return eta * eta
+	}
+	return eta
+}
+
+// muEta returns the derivative of the mean by the linear predictor.
+func (f *glmFamily) muEta(eta float64) float64 {
+	switch f.link {
+	case "logit":
+		if eta > 30 || eta < -30 {
+			return machineEps
+		}
+		opexp := 1 + math.Exp(eta)
+		return math.Exp(eta) / (opexp * opexp)
+	case "probit":
+		return math.Max(math.Exp(-eta*eta/2)/math.Sqrt(2*math.Pi), machineEps)
+	case "cauchit":
+		return math.Max(1/(math.Pi*(1+eta*eta)), machineEps)
+	case "cloglog":
+		eta = math.Min(eta, 700)
+		return math.Max(math.Exp(eta)*math.Exp(-math.Exp(eta)), machineEps)
+	case "log":
+		return math.Max(math.Exp(eta), machineEps)
+	case "inverse":
+		return -1 / (eta * eta)
+	case "sqrt":
+		return 2 * eta
+	}
+	return 1
+}
+
+func (f *glmFamily) variance(mu float64) float64 {
+	switch f.family {
+	case "binomial":
+		return mu * (1 - mu)
+	case "poisson":
+		return mu
+	case "Gamma":
+		return mu * mu
+	}
+	return 1
+}
+
+// devResid returns the deviance contribution of an observation y with
+// mean mu and prior weight w.
+func (f *glmFamily) devResid(y, mu, w float64) float64 {
+	ylogy := func(y, mu float64) float64 {
+		if y == 0 {
+			return 0
+		}
+		return y * math.Log(y/mu)
+	}
+	switch f.family {
+	case "binomial":
+		return 2 * w * (ylogy(y, mu) + ylogy(1-y, 1-mu))
+	case "poisson":
+		if y > 0 {
+			return 2 * w * (y*math.Log(y/mu) - (y - mu))
+		}
+		return 2 * mu * w
+	case "Gamma":
+		r := 1.0
+		if y != 0 {
+			r = y / mu
+		}
+		return -2 * w * (math.Log(r) - (y-mu)/mu)
+	}
+	return w * (y - mu) * (y - mu)
+}
+
+// deviance sums the deviance contributions of all observations.
+func (f *glmFamily) deviance(y, mu, w []float64) float64 {
+	var dev float64
+	for i := range y {
+		dev += f.devResid(y[i], mu[i], w[i])
+	}
+	return dev
+}
+
+// aic returns the AIC of a fit without the 2 * rank term, as the aic
+// function of R's families does.
+func (f *glmFamily) aic(r *glmResponse, mu []float64, dev float64) float64 {
+	var s float64
+	switch f.family {
+	case "binomial":
+		m := r.weights
+		if r.trials {
+			m = r.n
+		}
+		for i, y := range r.y {
+			if m[i] > 0 {
+				s += r.weights[i] / m[i] * dbinomFn(math.Round(m[i]*y), math.Round(m[i]), mu[i], true)
+			}
+		}
+		return -2 * s
+	case "poisson":
+		for i, y := range r.y {
+			s += dpoisFn(y, mu[i], true) * r.weights[i]
+		}
+		return -2 * s
+	case "Gamma":
+		var n float64
+		for _, w := range r.weights {
+			n += w
+		}
+		disp := dev / n
+		for i, y := range r.y {
+			s += dgammaFn(y, 1/disp, mu[i]*disp, true) * r.weights[i]
+		}
+		return -2*s + 2
+	}
+	nobs := float64(len(r.y))
+	return nobs*(math.Log(2*math.Pi*dev/nobs)+1) + 2
+}
+
+func (f *glmFamily) validMu(mu float64) bool {
+	switch f.family {
+	case "binomial":
+		return !math.IsInf(mu, 0) && mu > 0 && mu < 1
+	case "poisson", "Gamma":
+		return !math.IsInf(mu, 0) && mu > 0
+	}
+	return true
+}
+
+func (f *glmFamily) validEta(eta float64) bool {
+	switch f.link {
+	case "inverse":
+		return !math.IsInf(eta, 0) && eta != 0
+	case "sqrt":
+		return !math.IsInf(eta, 0) && eta > 0
+	}
+	return true
+}
+
+// glmResponse is the response of a glm with its prior weights. For the
+// binomial family y holds proportions; when they were given as a matrix
+// of successes and failures, trials is set, n holds the numbers of
+// trials and they are part of the weights.
+type glmResponse struct {
+	y, weights, n []float64
+	trials        bool
+}
+
+// mustart checks the response for the family and returns the means the
+// iterations start from.
+func (f *glmFamily) mustart(ctx *Context, r *glmResponse) ([]float64, error) {
+	out := make([]float64, len(r.y))
+	switch f.family {
+	case "binomial":
+		if !r.trials {
+			nonInteger := false
+			for i, y := range r.y {
+				if r.weights[i] == 0 {
+					r.y[i], y = 0, 0
+				}
+				if y < 0 || y > 1 {
+					return nil, fmt.Errorf("y values must be 0 <= y <= 1")
+				}
+				m := r.weights[i] * y
+				nonInteger = nonInteger || math.Abs(m-math.Round(m)) > 1e-3
+			}
+			if nonInteger {
+				if err := ctx.warn("non-integer #successes in a binomial glm!"); err != nil {
+					return nil, err
+				}
+			}
+		}
+		for i, y := range r.y {
+			m := r.weights[i]
+			if r.trials {
+				m = r.n[i]
+			}
+			out[i] = (m*y + 0.5) / (m + 1)
+		}
+	case "poisson":
+		for i, y := range r.y {
+			if y < 0 {
+				return nil, fmt.Errorf("negative values not allowed for the 'Poisson' family")
+			}
+			out[i] = y + 0.1
+		}
+	case "Gamma":
+		for i, y := range r.y {
+			if y <= 0 {
+				return nil, fmt.Errorf("non-positive values not allowed for the 'Gamma' family")
+			}
+			out[i] = y
+		}
+	default:
+		for i, y := range r.y {
+			if f.link == "inverse" && y == 0 || f.link == "log" && y <= 0 {
+				return nil, fmt.Errorf("cannot find valid starting values: please specify some")
+			}
+			out[i] = y
+		}
+	}
+	return out, nil
+}
+
+// value returns the family as the list R's family objects are, with its
+// functions as builtins.
+func (f *glmFamily) value() Value {
+	out := namedList(
+		"family", CharScalar(f.family),
+		"link", CharScalar(f.link),
+		"linkfun", familyFunc("linkfun", f.linkfun),
+		"linkinv", familyFunc("linkinv", f.linkinv),
+		"variance", familyFunc("variance", f.variance),
+		"dev.resids", &BuiltinFunc{FnName: "dev.resids", Impl: func(ctx *Context, args []ArgValue) (Value, error) {
+			fargs, err := forceArgs(ctx, args)
+			if err != nil {
+				return nil, err
+			}
+			m, _ := matchArgs(fargs, "y", "mu", "wt")
+			var v [3][]FloatElem
+			for i, x := range m {
+				if x == nil {
+					return nil, fmt.Errorf("dev.resids(y, mu, wt) expects 3 arguments")
+				}
+				if v[i], err = asDoubleVec(ctx, x); err != nil {
+					return nil, err
+				}
+			}
+			n := max(len(v[0]), max(len(v[1]), len(v[2])))
+			out := make([]FloatElem, n)
+			for i := range out {
+				if len(v[0]) == 0 || len(v[1]) == 0 || len(v[2]) == 0 {
+					break
+				}
+				y, mu, w := v[0][i%len(v[0])], v[1][i%len(v[1])], v[2][i%len(v[2])]
+				if y.NA || mu.NA || w.NA {
+					out[i].NA = true
+					continue
+				}
+				out[i].Val = f.devResid(y.Val, mu.Val, w.Val)
+			}
+			return &DoubleVec{Data: out}, nil
+		}},
+		"mu.eta", familyFunc("mu.eta", f.muEta),
+	)
+	out.SetAttr("class", CharScalar("family"))
+	return out
+}
+
+// familyFunc wraps an elementwise function of a family as a builtin.
+func familyFunc(name string, fn func(float64) float64) *BuiltinFunc {
+	return &BuiltinFunc{FnName: name, Impl: func(ctx *Context, args []ArgValue) (Value, error) {
+		fargs, err := forceArgs(ctx, args)
+		if err != nil {
+			return nil, err
+		}
+		if len(fargs) != 1 {
+			return nil, fmt.Errorf("%s() expects 1 argument", name)
+		}
+		x, err := asDoubleVec(ctx, fargs[0].Val)
+		if err != nil {
+			return nil, err
+		}
+		out := make([]FloatElem, len(x))
+		for i, e := range x {
+			if e.NA {
+				out[i].NA = true
+				continue
+			}
+			out[i].Val = fn(e.Val)
+		}
+		v := &DoubleVec{Data: out}
+		if names, ok := fargs[0].Val.GetAttr("names"); ok {
+			v.SetAttr("names", names)
+		}
+		return v, nil
+	}}
+}
+
+// familyConstructor returns the builtin for a family such as
+// binomial(link = "logit"). As in R, the link may also be given as a
+// bare name, binomial(link = probit).
+func familyConstructor(family string) func(*Context, []ArgValue) (Value, error) {
+	return func(ctx *Context, args []ArgValue) (Value, error) {
+		m, _ := matchArgs(args, "link")
+		link := ""
+		if m[0] != nil {
+			if id, ok := promiseExpr(m[0]).(*ast.Ident); ok && containsString(familyLinks[family], id.Name) {
+				link = id.Name
+			} else {
+				v, err := Force(ctx, m[0])
+				if err != nil {
+					return nil, err
+				}
+				cv, ok := v.(*CharVec)
+				if !ok || cv.Len() != 1 || cv.Data[0].NA {
+					return nil, fmt.Errorf("'link' must be a character string")
+				}
+				link = cv.Data[0].Val
+			}
+		}
+		f, err := newFamily(family, link)
+		if err != nil {
+			return nil, err
+		}
+		return f.value(), nil
+	}
+}
+
+// familyFromValue reads a family object back.
+func familyFromValue(v Value) (*glmFamily, error) {
+	if !hasClass(v, "family") {
+		return nil, fmt.Errorf("'family' not recognized")
+	}
+	family := toPlainStrings(listElem(v, "family"))
+	link := toPlainStrings(listElem(v, "link"))
+	if len(family) != 1 || len(link) != 1 {
+		return nil, fmt.Errorf("'family' not recognized")
+	}
+	return newFamily(family[0], link[0])
+}
+
+// familyArg interprets the family argument of glm(): a family object, a
+// family function such as binomial, or its name.
+func familyArg(ctx *Context, v Value) (*glmFamily, error) {
+	if v == nil {
+		return newFamily("gaussian", "")
+	}
+	v, err := Force(ctx, v)
+	if err != nil {
+		return nil, err
+	}
+	if cv, ok := v.(*CharVec); ok && cv.Len() == 1 {
+		fv, ok := ctx.callingEnv().Get(cv.Data[0].Val)
+		if !ok {
+			return nil, fmt.Errorf("could not find function \"%s\"", cv.Data[0].Val)
+		}
+		if v, err = Force(ctx, fv); err != nil {
+			return nil, err
+		}
+	}
+	if fn, ok := v.(Callable); ok {
+		if v, err = fn.Call(ctx, ctx.callingEnv(), nil); err != nil {
+			return nil, err
+		}
+	}
+	return familyFromValue(v)
+}
+
+// builtinFamily implements family(object): the family of a glm, or
+// gaussian() for an lm fit.
+func builtinFamily(ctx *Context, args []ArgValue) (Value, error) {
+	fargs, err := forceArgs(ctx, args)
+	if err != nil {
+		return nil, err
+	}
+	m, _ := matchArgs(fargs, "object", "...")
+	switch {
+	case m[0] == nil:
+		return nil, fmt.Errorf("argument \"object\" is missing, with no default")
+	case hasClass(m[0], "glm"):
+		return listElem(m[0], "family"), nil
+	case hasClass(m[0], "lm"):
+		f, _ := newFamily("gaussian", "")
+		return f.value(), nil
+	}
+	return nil, fmt.Errorf("no applicable method for 'family' applied to an object of class \"%s\"", classOf(m[0])[0])
+}
+
+// --- fitting ---
+
+// glmControl holds the convergence settings of glm.control().
+type glmControl struct {
+	epsilon float64
+	maxit   int
+}
+
+// builtinGlmControl implements glm.control(epsilon = 1e-8, maxit = 25).
+func builtinGlmControl(ctx *Context, args []ArgValue) (Value, error) {
+	fargs, err := forceArgs(ctx, args)
+	if err != nil {
+		return nil, err
+	}
+	m, _ := matchArgs(fargs, "epsilon", "maxit", "trace")
+	ctl, err := controlArg(ctx, namedList("epsilon", m[0], "maxit", m[1]))
+	if err != nil {
+		return nil, err
+	}
+	return namedList(
+		"epsilon", DoubleScalar(ctl.epsilon),
+		"maxit", IntScalar(int64(ctl.maxit)),
+		"trace", LogicalScalar(false),
+	), nil
+}
+
+// controlArg reads the control list of glm(); missing settings take
+// their defaults.
+func controlArg(ctx *Context, v Value) (glmControl, error) {
+	ctl := glmControl{epsilon: 1e-8, maxit: 25}
+	if v == nil || v == NullValue {
+		return ctl, nil
+	}
+	if _, ok := v.(*ListVec); !ok {
+		return ctl, fmt.Errorf("'control' must be a list")
+	}
+	if e := listElem(v, "epsilon"); e != nil {
+		f, err := asFloatElem(ctx, e)
+		if err != nil || f.NA || f.Val <= 0 {
+			return ctl, fmt.Errorf("value of 'epsilon' must be > 0")
+		}
+		ctl.epsilon = f.Val
+	}
+	if e := listElem(v, "maxit"); e != nil {
+		f, err := asFloatElem(ctx, e)
+		if err != nil || f.NA || f.Val <= 0 {
+			return ctl, fmt.Errorf("maximum number of iterations must be > 0")
+		}
+		ctl.maxit = int(f.Val)
+	}
+	return ctl, nil
+}
+
+// glmFit is the result of the iteratively reweighted least squares.
+type glmFit struct {
+	ls        *lsFit    // the weighted least-squares fit of the last iteration
+	coef      []float64 // NA for aliased columns
+	eta, mu   []float64
+	weights   []float64 // working weights of the last iteration
+	residuals []float64 // working residuals
+	dev       float64
+	nullDev   float64
+	aic       float64
+	iter      int
+	converged bool
+	boundary  bool
+	rank      int
+	dfNull    int
+	dfResid   int
+}
+
+// fitGlm fits a glm by iteratively reweighted least squares, as R's
+// glm.fit: each iteration regresses the working response on x with the
+// working weights, until the relative change of the deviance is below
+// ctl.epsilon. Steps that make the deviance infinite or leave the
+// valid range of the family are halved. start, when given, holds the
+// starting coefficients.
+func fitGlm(ctx *Context, x []float64, n, p int, r *glmResponse, fam *glmFamily, intercept bool, start []float64, ctl glmControl) (*glmFit, error) {
+	linear := func(b []float64) []float64 {
+		eta := make([]float64, n)
+		for j, bj := range b {
+			if math.IsNaN(bj) {
+				continue
+			}
+			for i := range eta {
+				eta[i] += x[j*n+i] * bj
+			}
+		}
+		return eta
+	}
+	means := func(eta []float64) []float64 {
+		mu := make([]float64, n)
+		for i, e := range eta {
+			mu[i] = fam.linkinv(e)
+		}
+		return mu
+	}
+	valid := func(eta, mu []float64) bool {
+		for i := range eta {
+			if !fam.validEta(eta[i]) || !fam.validMu(mu[i]) {
+				return false
+			}
+		}
+		return true
+	}
+	mustart, err := fam.mustart(ctx, r)
+	if err != nil {
+		return nil, err
+	}
+	fit := &glmFit{}
+	var eta []float64
+	if start != nil {
+		if len(start) != p {
+			return nil, fmt.Errorf("length of 'start' should equal %d and correspond to initial coefs", p)
+		}
+		eta = linear(start)
+	} else {
+		eta = make([]float64, n)
+		for i, m := range mustart {
+			eta[i] = fam.linkfun(m)
+		}
+	}
+	mu := means(eta)
+	if !valid(eta, mu) {
+		return nil, fmt.Errorf("cannot find valid starting values: please specify some")
+	}
+	if p == 0 {
+		// the empty model: nothing to estimate
+		fit.eta, fit.mu = make([]float64, n), means(make([]float64, n))
+		fit.dev = fam.deviance(r.y, fit.mu, r.weights)
+		fit.weights = make([]float64, n)
+		fit.residuals = make([]float64, n)
+		for i := range fit.mu {
+			me := fam.muEta(0)
+			fit.weights[i] = r.weights[i] * me * me / fam.variance(fit.mu[i])
+			fit.residuals[i] = (r.y[i] - fit.mu[i]) / me
+		}
+		fit.converged = true
+	} else {
+		devold := fam.deviance(r.y, mu, r.weights)
+		coefold := start
+		var coef []float64
+		z := make([]float64, n)
+		w := make([]float64, n)
+		tol := math.Min(1e-7, ctl.epsilon/1000)
+		for fit.iter = 1; fit.iter <= ctl.maxit; fit.iter++ {
+			good := 0
+			for i := range z {
+				me := fam.muEta(eta[i])
+				z[i], w[i] = 0, 0
+				if r.weights[i] > 0 && me != 0 {
+					z[i] = eta[i] + (r.y[i]-mu[i])/me
+					w[i] = r.weights[i] * me * me / fam.variance(mu[i])
+					good++
+				}
+			}
+			if good == 0 {
+				return nil, fmt.Errorf("no observations informative at iteration %d", fit.iter)
+			}
+			ls, err := fitLeastSquares(x, n, p, z, w, tol)
+			if err != nil {
+				return nil, err
+			}
+			fit.ls = ls
+			fit.weights = append([]float64(nil), w...)
+			coef = make([]float64, p)
+			for j, b := range ls.coef {
+				coef[j] = b.Val
+				if b.NA {
+					coef[j] = math.NaN()
+				}
+			}
+			eta = linear(coef)
+			mu = means(eta)
+			dev := fam.deviance(r.y, mu, r.weights)
+			fit.boundary = false
+			halve := func(ok func() bool, msg string) error {
+				if coefold == nil {
+					return fmt.Errorf("no valid set of coefficients has been found: please supply starting values")
+				}
+				for ii := 1; !ok(); ii++ {
+					if ii > ctl.maxit {
+						return fmt.Errorf("inner loop %s; cannot correct step size", msg)
+					}
+					for j := range coef {
+						coef[j] = (coef[j] + coefold[j]) / 2
+					}
+					eta = linear(coef)
+					mu = means(eta)
+					dev = fam.deviance(r.y, mu, r.weights)
+				}
+				fit.boundary = true
+				return nil
+			}
+			if math.IsInf(dev, 0) || math.IsNaN(dev) {
+				if err := halve(func() bool { return !math.IsInf(dev, 0) && !math.IsNaN(dev) }, "1"); err != nil {
+					return nil, err
+				}
+			}
+			if !valid(eta, mu) {
+				if err := halve(func() bool { return valid(eta, mu) }, "2"); err != nil {
+					return nil, err
+				}
+			}
+			fit.dev = dev
+			if math.Abs(dev-devold)/(math.Abs(dev)+0.1) < ctl.epsilon {
+				fit.converged = true
+				break
+			}
+			devold = dev
+			coefold = append([]float64(nil), coef...)
+		}
+		fit.iter = min(fit.iter, ctl.maxit)
+		fit.coef = coef
+		fit.rank = fit.ls.qr.rank
+		fit.residuals = make([]float64, n)
+		for i := range mu {
+			fit.residuals[i] = (r.y[i] - mu[i]) / fam.muEta(eta[i])
+		}
+		fit.eta, fit.mu = eta, mu
+	}
+	if !fit.converged {
+		if err := ctx.warn("glm.fit: algorithm did not converge"); err != nil {
+			return nil, err
+		}
+	}
+	if fit.boundary {
+		if err := ctx.warn("glm.fit: algorithm stopped at boundary value"); err != nil {
+			return nil, err
+		}
+	}
+	eps := 10 * machineEps
+	for _, m := range fit.mu {
+		var msg string
+		switch {
+		case fam.family == "binomial" && (m > 1-eps || m < eps):
+			msg = "glm.fit: fitted probabilities numerically 0 or 1 occurred"
+		case fam.family == "poisson" && m < eps:
+			msg = "glm.fit: fitted rates numerically 0 occurred"
+		}
+		if msg != "" {
+			if err := ctx.warn(msg); err != nil {
+				return nil, err
+			}
+			break
+		}
+	}
+	var sw, swy float64
+	nok := 0
+	for i, w := range r.weights {
+		sw += w
+		swy += w * r.y[i]
+		if w != 0 {
+			nok++
+		}
+	}
+	wtdmu := make([]float64, n)
+	for i := range wtdmu {
+		if intercept {
+			wtdmu[i] = swy / sw
+		} else {
+			wtdmu[i] = fam.linkinv(0)
+		}
+	}
+	fit.nullDev = fam.deviance(r.y, wtdmu, r.weights)
+	fit.dfNull = nok
+	if intercept {
+		fit.dfNull--
+	}
+	fit.dfResid = nok - fit.rank
+	fit.aic = fam.aic(r, fit.mu, fit.dev) + 2*float64(fit.rank)
+	return fit, nil
+}
+
+// glmResponseOf reads the response of a glm from its model frame. For
+// the binomial family it may be a factor, whose first level is failure,
+// or a two-column matrix of successes and failures.
+func glmResponseOf(ctx *Context, y Value, w []float64, fam *glmFamily) (*glmResponse, error) {
+	n := frameRows(y)
+	r := &glmResponse{weights: w, n: make([]float64, n)}
+	for i := range r.n {
+		r.n[i] = 1
+	}
+	if r.weights == nil {
+		r.weights = append([]float64(nil), r.n...)
+	}
+	if d := getDims(y); d != nil {
+		if fam.family != "binomial" || len(d) != 2 || d[1] != 2 {
+			return nil, fmt.Errorf("multiple responses are not supported")
+		}
+		counts, err := finiteFloats(ctx, y, "y")
+		if err != nil {
+			return nil, err
+		}
+		r.y, r.trials = make([]float64, n), true
+		for i := 0; i < n; i++ {
+			r.n[i] = counts[i] + counts[n+i]
+			if r.n[i] > 0 {
+				r.y[i] = counts[i] / r.n[i]
+			}
+			r.weights[i] *= r.n[i]
+		}
+		return r, nil
+	}
+	if fam.family == "binomial" && isFactor(y) {
+		codes := toFloats(stripAttrs(y))
+		r.y = make([]float64, n)
+		for i, c := range codes {
+			if math.IsNaN(c) {
+				return nil, fmt.Errorf("NA/NaN/Inf in 'y'")
+			}
+			if c != 1 {
+				r.y[i] = 1
+			}
+		}
+		return r, nil
+	}
+	if _, ok := y.(*CharVec); ok {
+		return nil, fmt.Errorf("non-numeric response in a glm")
+	}
+	var err error
+	r.y, err = finiteFloats(ctx, y, "y")
+	return r, err
+}
+
+// stripAttrs returns the integer codes of a factor without its class.
+func stripAttrs(v Value) Value {
+	if iv, ok := v.(*IntVec); ok {
+		return &IntVec{Data: iv.Data}
+	}
+	return v
+}
+
+// builtinGlm implements glm(formula, family, data, weights, subset,
+// na.action, start, control): the maximum-likelihood fit of a generalized
+// linear model by iteratively reweighted least squares. It returns an
+// object of class c("glm", "lm").
+func builtinGlm(ctx *Context, args []ArgValue) (Value, error) {
+	formals := []string{"formula", "family", "data", "weights", "subset", "na.action", "start", "control"}
+	m, _ := matchArgs(args, formals...)
+	call := modelCall("glm", formals, m)
+	fam, err := familyArg(ctx, m[1])
+	if err != nil {
+		return nil, err
+	}
+	mt, mf, err := fitFrame(ctx, m[0], m[2], m[4], m[3], m[5])
+	if err != nil {
+		return nil, err
+	}
+	if !mt.response {
+		return nil, fmt.Errorf("glm() needs a response: the formula has no left-hand side")
+	}
+	w, err := modelWeights(ctx, mt, mf)
+	if err != nil {
+		return nil, err
+	}
+	r, err := glmResponseOf(ctx, mf.Data[0], w, fam)
+	if err != nil {
+		return nil, err
+	}
+	var start []float64
+	if m[6] != nil {
+		sv, err := Force(ctx, m[6])
+		if err != nil {
+			return nil, err
+		}
+		if sv != NullValue {
+			if start, err = finiteFloats(ctx, sv, "start"); err != nil {
+				return nil, err
+			}
+		}
+	}
+	var ctlValue Value
+	if m[7] != nil {
+		if ctlValue, err = Force(ctx, m[7]); err != nil {
+			return nil, err
+		}
+	}
+	ctl, err := controlArg(ctx, ctlValue)
+	if err != nil {
+		return nil, err
+	}
+	mm, err := modelMatrix(ctx, mt, mf)
+	if err != nil {
+		return nil, err
+	}
+	x, err := finiteFloats(ctx, mm, "x")
+	if err != nil {
+		return nil, err
+	}
+	n, p, _ := matrixDims(mm)
+	fit, err := fitGlm(ctx, x, n, p, r, fam, mt.intercept, start, ctl)
+	if err != nil {
+		return nil, err
+	}
+	rowNames, colNames := dfRowNames(mf), dimNamesAt(mm, 1)
+	coef := make([]FloatElem, p)
+	for j, b := range fit.coef {
+		coef[j] = FloatElem{Val: b, NA: math.IsNaN(b)}
+	}
+	coefVec := &DoubleVec{Data: coef}
+	coefVec.SetAttr("names", charVecOf(colNames))
+	var effects, qr Value
+	if fit.ls != nil {
+		effects = fit.ls.effectsValue(colNames)
+		qr = fit.ls.qr.value(fittedRows(rowNames, fit.weights), colNames)
+	} else {
+		qr = (&qrDecomp{n: n}).value(rowNames, colNames)
+	}
+	contrasts, _ := mm.GetAttr("contrasts")
+	naAction, _ := mf.GetAttr("na.action")
+	xl, err := xlevelsValue(ctx, mt, mf)
+	if err != nil {
+		return nil, err
+	}
+	var data Value = NullValue
+	if m[2] != nil {
+		if data, err = Force(ctx, m[2]); err != nil {
+			return nil, err
+		}
+	}
+	formula, err := Force(ctx, m[0])
+	if err != nil {
+		return nil, err
+	}
+	out := namedList(
+		"coefficients", coefVec,
+		"residuals", namedDoubles(fit.residuals, rowNames),
+		"fitted.values", namedDoubles(fit.mu, rowNames),
+		"effects", effects,
+		"rank", IntScalar(int64(fit.rank)),
+		"qr", qr,
+		"family", fam.value(),
+		"linear.predictors", namedDoubles(fit.eta, rowNames),
+		"deviance", DoubleScalar(fit.dev),
+		"aic", DoubleScalar(fit.aic),
+		"null.deviance", DoubleScalar(fit.nullDev),
+		"iter", IntScalar(int64(fit.iter)),
+		"weights", namedDoubles(fit.weights, rowNames),
+		"prior.weights", namedDoubles(r.weights, rowNames),
+		"df.residual", IntScalar(int64(fit.dfResid)),
+		"df.null", IntScalar(int64(fit.dfNull)),
+		"y", namedDoubles(r.y, rowNames),
+		"converged", LogicalScalar(fit.converged),
+		"boundary", LogicalScalar(fit.boundary),
+		"model", mf,
+		"na.action", naAction,
+		"call", call,
+		"formula", formula,
+		"terms", mf.Attrs()["terms"],
+		"data", data,
+		"control", namedList("epsilon", DoubleScalar(ctl.epsilon), "maxit", IntScalar(int64(ctl.maxit)), "trace", LogicalScalar(false)),
+		"method", CharScalar("glm.fit"),
+		"contrasts", contrasts,
+		"xlevels", xl,
+	)
+	out.SetAttr("class", charVecOf([]string{"glm", "lm"}))
+	return out, nil
+}
+
+// --- methods ---
+
+// glmResponseFromValue returns the response, means and prior weights a
+// glm object records.
+func glmResponseFromValue(v Value) (y, mu, w []float64) {
+	return toFloats(listElem(v, "y")), toFloats(listElem(v, "fitted.values")), toFloats(listElem(v, "prior.weights"))
+}
+
+// residualsGlm implements residuals() for glm objects, of type
+// "deviance" (the default), "pearson", "working" or "response".
+func residualsGlm(ctx *Context, v Value, typ Value) (Value, error) {
+	kind, err := choiceArg(typ, "type", "deviance", "pearson", "working", "response")
+	if err != nil {
+		return nil, err
+	}
+	if kind == "working" {
+		return listElem(v, "residuals"), nil
+	}
+	fam, err := familyFromValue(listElem(v, "family"))
+	if err != nil {
+		return nil, err
+	}
+	y, mu, w := glmResponseFromValue(v)
+	out := make([]float64, len(y))
+	for i := range y {
+		d := y[i] - mu[i]
+		switch kind {
+		case "deviance":
+			out[i] = math.Sqrt(math.Max(fam.devResid(y[i], mu[i], w[i]), 0))
+			if d < 0 {
+				out[i] = -out[i]
+			}
+		case "pearson":
+			out[i] = d * math.Sqrt(w[i]) / math.Sqrt(fam.variance(mu[i]))
+		default:
+			out[i] = d
+		}
+	}
+	return namedDoubles(out, valueNames(listElem(v, "y"))), nil
+}
+
+// predictGlm implements predict(object, newdata, type, se.fit) for glm
+// fits: the linear predictor, or with type = "response" the means, with
+// standard errors by the delta method.
+func predictGlm(ctx *Context, fl *fittedLm, args []ArgValue) (Value, error) {
+	m, _ := matchArgs(args, "object", "newdata", "type", "se.fit", "...")
+	kind, err := choiceArg(m[2], "type", "link", "response")
+	if err != nil {
+		return nil, err
+	}
+	seFit, err := logicalArg(ctx, m[3], false)
+	if err != nil {
+		return nil, err
+	}
+	if m[1] == nil && !seFit {
+		if kind == "link" {
+			return listElem(fl.obj, "linear.predictors"), nil
+		}
+		return listElem(fl.obj, "fitted.values"), nil
+	}
+	fit, se, rowNames, err := fl.predictLinear(ctx, m[1])
+	if err != nil {
+		return nil, err
+	}
+	if kind == "response" {
+		for i := range fit {
+			if fit[i].NA {
+				continue
+			}
+			se[i].Val *= math.Abs(fl.family.muEta(fit[i].Val))
+			fit[i].Val = fl.family.linkinv(fit[i].Val)
+		}
+	}
+	fitVec := &DoubleVec{Data: fit}
+	fitVec.SetAttr("names", charVecOf(rowNames))
+	if !seFit {
+		return fitVec, nil
+	}
+	seVec := &DoubleVec{Data: se}
+	seVec.SetAttr("names", charVecOf(rowNames))
+	return namedList(
+		"fit", fitVec,
+		"se.fit", seVec,
+		"residual.scale", DoubleScalar(math.Sqrt(fl.dispersion())),
+	), nil
+}
+
+// summaryGlm implements summary() for glm objects: the coefficient table
+// with z tests, or t tests when the dispersion is estimated, and the
+// deviances.
+func summaryGlm(ctx *Context, v Value) (Value, error) {
+	fl, err := lmFromValue(ctx, v)
+	if err != nil {
+		return nil, err
+	}
+	disp := fl.dispersion()
+	estDisp := !fl.family.fixedDispersion()
+	rank := fl.qr.rank
+	cov := fl.qr.unscaledCov()
+	coefNames := make([]string, rank)
+	table := make([]FloatElem, rank*4)
+	for i, j := range fl.estimable() {
+		coefNames[i] = fl.names[j]
+		b := fl.coef[j].Val
+		se := math.Sqrt(cov[i*rank+i] * disp)
+		stat := b / se
+		p := 2 * pnormFn(-math.Abs(stat), 0, 1, true, false)
+		if estDisp {
+			p = 2 * ptFn(-math.Abs(stat), float64(fl.rdf), true, false)
+		}
+		table[i] = FloatElem{Val: b}
+		table[rank+i] = FloatElem{Val: se}
+		table[2*rank+i] = FloatElem{Val: stat}
+		table[3*rank+i] = FloatElem{Val: p}
+	}
+	coefMat := newDoubleMatrix(table, rank, 4)
+	testCols := []string{"z value", "Pr(>|z|)"}
+	if estDisp {
+		testCols = []string{"t value", "Pr(>|t|)"}
+	}
+	setDimNames(coefMat, coefNames, append([]string{"Estimate", "Std. Error"}, testCols...))
+	aliased := make([]LogicalElem, len(fl.coef))
+	for j, c := range fl.coef {
+		aliased[j].Val = c.NA
+	}
+	aliasedVec := &LogicalVec{Data: aliased}
+	aliasedVec.SetAttr("names", charVecOf(fl.names))
+	unscaled := make([]FloatElem, len(cov))
+	scaled := make([]FloatElem, len(cov))
+	for i, c := range cov {
+		unscaled[i].Val = c
+		scaled[i].Val = c * disp
+	}
+	unscaledMat := newDoubleMatrix(unscaled, rank, rank)
+	setDimNames(unscaledMat, coefNames, coefNames)
+	scaledMat := newDoubleMatrix(scaled, rank, rank)
+	setDimNames(scaledMat, coefNames, coefNames)
+	devResid, err := residualsGlm(ctx, v, nil)
+	if err != nil {
+		return nil, err
+	}
+	out := namedList(
+		"call", listElem(v, "call"),
+		"terms", listElem(v, "terms"),
+		"family", listElem(v, "family"),
+		"deviance", listElem(v, "deviance"),
+		"aic", listElem(v, "aic"),
+		"contrasts", listElem(v, "contrasts"),
+		"df.residual", listElem(v, "df.residual"),
+		"null.deviance", listElem(v, "null.deviance"),
+		"df.null", listElem(v, "df.null"),
+		"iter", listElem(v, "iter"),
+		"na.action", listElem(v, "na.action"),
+		"deviance.resid", devResid,
+		"coefficients", coefMat,
+		"aliased", aliasedVec,
+		"dispersion", DoubleScalar(disp),
+		"df", &IntVec{Data: []IntElem{{Val: int64(rank)}, {Val: int64(fl.rdf)}, {Val: int64(len(fl.coef))}}},
+		"cov.unscaled", unscaledMat,
+		"cov.scaled", scaledMat,
+	)
+	out.SetAttr("class", CharScalar("summary.glm"))
+	return out, nil
+}
+
+// --- printing ---
+
+// formatGlm renders a glm object like R's print.glm.
+func formatGlm(v Value) string {
+	const digits = 4
+	var sb strings.Builder
+	sb.WriteString("\nCall:  ")
+	if ev, ok := listElem(v, "call").(*ExprValue); ok {
+		sb.WriteString(deparse.Expr(ev.Expr))
+	}
+	sb.WriteString("\n\n")
+	coef, _ := listElem(v, "coefficients").(*DoubleVec)
+	if coef == nil || coef.Len() == 0 {
+		sb.WriteString("No coefficients\n\n")
+	} else {
+		sb.WriteString("Coefficients:\n")
+		sb.WriteString(formatNamedVector(valueNames(coef), formatReal(coef.Data, digits), 2))
+	}
+	scalar := func(name string) float64 {
+		if f := toFloats(listElem(v, name)); len(f) == 1 {
+			return f[0]
+		}
+		return math.NaN()
+	}
+	fmt.Fprintf(&sb, "\nDegrees of Freedom: %s Total (i.e. Null);  %s Residual\n",
+		formatFloat(scalar("df.null")), formatFloat(scalar("df.residual")))
+	if msg := naActionMessage(listElem(v, "na.action")); msg != "" {
+		sb.WriteString("  (" + msg + ")\n")
+	}
+	fmt.Fprintf(&sb, "Null Deviance:\t    %s \nResidual Deviance: %s \tAIC: %s\n",
+		formatFloat(signif(scalar("null.deviance"), digits)),
+		formatFloat(signif(scalar("deviance"), digits)),
+		formatFloat(signif(scalar("aic"), digits)))
+	return sb.String()
+}
+
+// formatSummaryGlm renders a summary.glm object like R's
+// print.summary.glm.
+func formatSummaryGlm(v Value) string {
+	const digits = 4
+	var sb strings.Builder
+	formatModelCall(&sb, listElem(v, "call"))
+	df := toFloats(listElem(v, "df"))
+	aliased, _ := listElem(v, "aliased").(*LogicalVec)
+	if aliased == nil || aliased.Len() == 0 {
+		sb.WriteString("No Coefficients\n")
+	} else {
+		if ns := int(df[2] - df[0]); ns > 0 {
+			fmt.Fprintf(&sb, "Coefficients: (%d not defined because of singularities)\n", ns)
+		} else {
+			sb.WriteString("Coefficients:\n")
+		}
+		coefs := listElem(v, "coefficients")
+		k, _, _ := matrixDims(coefs)
+		table := toFloats(coefs)
+		p := aliased.Len()
+		cols := make([][]float64, 4)
+		for c := range cols {
+			cols[c] = make([]float64, p)
+			r := 0
+			for j := 0; j < p; j++ {
+				if aliased.Data[j].Val {
+					cols[c][j] = math.NaN()
+					continue
+				}
+				cols[c][j] = table[c*k+r]
+				r++
+			}
+		}
+		sb.WriteString(formatCoefmat(coefmat{
+			rowNames: valueNames(aliased), colNames: dimNamesAt(coefs, 1), cols: cols, digits: digits,
+			cs: []int{0, 1}, tst: []int{2}, hasP: true, naPrint: "NA",
+		}))
+	}
+	scalar := func(name string) float64 {
+		if f := toFloats(listElem(v, name)); len(f) == 1 {
+			return f[0]
+		}
+		return math.NaN()
+	}
+	family := toPlainStrings(listElem(listElem(v, "family"), "family"))
+	fmt.Fprintf(&sb, "\n(Dispersion parameter for %s family taken to be %s)\n\n", strings.Join(family, ""), formatRealFloats([]float64{scalar("dispersion")}, 7)[0])
+	devs := formatRealFloats([]float64{scalar("null.deviance"), scalar("deviance")}, max(5, digits+1))
+	dfs := []string{formatFloat(scalar("df.null")), formatFloat(scalar("df.residual"))}
+	w := max(len(dfs[0]), len(dfs[1]))
+	dw := max(len(devs[0]), len(devs[1]))
+	for i, label := range []string{"    Null", "Residual"} {
+		fmt.Fprintf(&sb, "%s deviance: %s  on %s  degrees of freedom\n", label, padCell(devs[i], dw, false), padCell(dfs[i], w, false))
+	}
+	if msg := naActionMessage(listElem(v, "na.action")); msg != "" {
+		sb.WriteString("  (" + msg + ")\n")
+	}
+	fmt.Fprintf(&sb, "AIC: %s\n\nNumber of Fisher Scoring iterations: %s\n\n",
+		formatRealFloats([]float64{scalar("aic")}, max(4, digits+1))[0], formatFloat(scalar("iter")))
+	return sb.String()
+}
+
+// formatFamily renders a family object like R's print.family.
+func formatFamily(v Value) string {
+	family := strings.Join(toPlainStrings(listElem(v, "family")), "")
+	link := strings.Join(toPlainStrings(listElem(v, "link")), "")
+	return "\nFamily: " + family + " \nLink function: " + link + " \n\n"
+}
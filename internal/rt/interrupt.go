@@ -0,0 +1,84 @@
+package rt
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+)
+
+// ErrInterrupted is returned, wrapped together with the cause of the
+// cancellation, when an evaluation is stopped because its Go context was
+// cancelled or timed out. It is not an R condition: tryCatch and try do
+// not catch it, but on.exit expressions and finally clauses still run.
+var ErrInterrupted = errors.New("evaluation interrupted")
+
+// EvalStringContext is like EvalString, but stops the evaluation with an
+// error wrapping ErrInterrupted once c is done. Loops, closure calls and
+// the apply functions check c as they go.
+func (ctx *Context) EvalStringContext(c context.Context, src string) (EvalResult, error) {
+	return ctx.EvalScriptContext(c, "", src)
+}
+
+// EvalScriptContext is EvalScript with cancellation as in
+// EvalStringContext.
+func (ctx *Context) EvalScriptContext(c context.Context, name, src string) (EvalResult, error) {
+	defer ctx.withInterrupt(c)()
+	return ctx.EvalScript(name, src)
+}
+
+// withInterrupt makes c the context that stops evaluation and returns a
+// function restoring the previous one, for nested evaluations.
+func (ctx *Context) withInterrupt(c context.Context) func() {
+	saved := ctx.interrupt
+	ctx.interrupt = c
+	return func() { ctx.interrupt = saved }
+}
+
+// interrupted returns an error wrapping ErrInterrupted once the context
+// of the running evaluation is done, and nil otherwise.
+func (ctx *Context) interrupted() error {
+	if ctx.interrupt == nil {
+		return nil
+	}
+	select {
+	case <-ctx.interrupt.Done():
+		return fmt.Errorf("%w (%w)", ErrInterrupted, context.Cause(ctx.interrupt))
+	default:
+		return nil
+	}
+}
+
+// interruptPanic carries an interruption out of a sort comparison.
+type interruptPanic struct{ err error }
+
+// sortSlice sorts x like sort.Slice, checking for an interruption every
+// few thousand comparisons so that sorting a long vector can be stopped.
+func (ctx *Context) sortSlice(x any, less func(i, j int) bool) (err error) {
+	if ctx.interrupt == nil {
+		sort.Slice(x, less)
+		return nil
+	}
+	if err := ctx.interrupted(); err != nil {
+		return err
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			ip, ok := r.(interruptPanic)
+			if !ok {
+				panic(r)
+			}
+			err = ip.err
+		}
+	}()
+	n := 0
+	sort.Slice(x, func(i, j int) bool {
+		if n++; n%4096 == 0 {
+			if err := ctx.interrupted(); err != nil {
+				panic(interruptPanic{err})
+			}
+		}
+		return less(i, j)
+	})
+	return nil
+}
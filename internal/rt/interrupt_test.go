@@ -0,0 +1,102 @@
+package rt
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestEvalStringContext(t *testing.T) {
+	tests := []string{
+		"repeat {}",
+		"while (TRUE) x <- 1",
+		"for (i in 1:1e6) for (j in 1:1e6) NULL",
+		"f <- function(n) n + 1; repeat f(1)",
+		"g <- function(n) g(n); g(1)",
+		"sapply(1:1e6, function(i) i)",
+		"Reduce(function(a, b) a + b, 1:1e6)",
+		`tryCatch(repeat {}, error = function(e) "caught")`,
+		`try(repeat {}, silent = TRUE)`,
+	}
+	for _, src := range tests {
+		ctx := NewContext()
+		c, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+		start := time.Now()
+		res, err := ctx.EvalStringContext(c, src)
+		cancel()
+		if !errors.Is(err, ErrInterrupted) || !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("%s: expected an interruption, got %v", src, err)
+			continue
+		}
+		if res.Error != nil {
+			t.Errorf("%s: an interruption is not a RuntimeError", src)
+		}
+		if d := time.Since(start); d > 2*time.Second {
+			t.Errorf("%s: stopped only after %v", src, d)
+		}
+		// the context is usable again, without the deadline
+		if res, err := ctx.EvalString("1 + 1"); err != nil || res.Value.String() != "2" {
+			t.Errorf("%s: evaluation afterwards: got %v, %v", src, res.Value, err)
+		}
+		if len(ctx.frames) != 0 || len(ctx.handlers) != 0 {
+			t.Errorf("%s: %d frames and %d handlers left", src, len(ctx.frames), len(ctx.handlers))
+		}
+	}
+}
+
+func TestInterruptCleanup(t *testing.T) {
+	ctx := NewContext()
+	c, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	res, err := ctx.EvalStringContext(c, `f <- function() { on.exit(cat("exit\n")); repeat {} }
+tryCatch(f(), finally = cat("finally\n"))`)
+	if !errors.Is(err, ErrInterrupted) {
+		t.Fatalf("expected an interruption, got %v", err)
+	}
+	if res.Output != "exit\nfinally\n" {
+		t.Errorf("expected on.exit and finally to run, got output %q", res.Output)
+	}
+	if !strings.HasPrefix(err.Error(), "evaluation interrupted") {
+		t.Errorf("unexpected message %q", err.Error())
+	}
+}
+
+func TestInterruptCancelled(t *testing.T) {
+	c, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	ctx := NewContext()
+	if _, err := ctx.EvalStringContext(c, "x <- 1"); !errors.Is(err, context.Canceled) {
+		t.Errorf("cancelled context: expected context.Canceled, got %v", err)
+	}
+	if _, ok := ctx.Global.Get("x"); ok {
+		t.Errorf("cancelled context: the script was run")
+	}
+
+	prog, err := Compile("sum(x)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := prog.RunContext(c, ctx, map[string]Value{"x": IntScalar(1)}); !errors.Is(err, ErrInterrupted) {
+		t.Errorf("RunContext: expected an interruption, got %v", err)
+	}
+	if res, err := prog.RunContext(context.Background(), ctx, map[string]Value{"x": IntScalar(1)}); err != nil || res.Value.String() != "1" {
+		t.Errorf("RunContext: got %v, %v", res.Value, err)
+	}
+
+	// long sorts check the context between comparisons
+	defer ctx.withInterrupt(c)()
+	for _, name := range []string{"sort", "order"} {
+		b, _ := ctx.Global.Get(name)
+		x := make([]FloatElem, 100000)
+		for i := range x {
+			x[i].Val = float64(len(x) - i)
+		}
+		_, err := b.(*BuiltinFunc).Impl(ctx, []ArgValue{{Val: &DoubleVec{Data: x}}})
+		if !errors.Is(err, ErrInterrupted) {
+			t.Errorf("%s: expected an interruption, got %v", name, err)
+		}
+	}
+}
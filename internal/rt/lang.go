@@ -0,0 +1,1210 @@
+package rt
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+
+	"simonwaldherr.de/go/smallr/internal/ast"
+	"simonwaldherr.de/go/smallr/internal/deparse"
+	"simonwaldherr.de/go/smallr/internal/token"
+)
+
+func installLangBuiltins(env *Env) {
+	builtins := map[string]*BuiltinFunc{
+		"eval":        {FnName: "eval", Impl: builtinEval},
+		"evalq":       {FnName: "evalq", Impl: builtinEvalq},
+		"substitute":  {FnName: "substitute", Impl: builtinSubstitute},
+		"bquote":      {FnName: "bquote", Impl: builtinBquote},
+		"deparse":     {FnName: "deparse", Impl: builtinDeparse},
+		"dput":        {FnName: "dput", Impl: builtinDput},
+		"call":        {FnName: "call", Impl: builtinCall},
+		"as.call":     {FnName: "as.call", Impl: builtinAsCall},
+		"as.name":     {FnName: "as.name", Impl: builtinAsName},
+		"as.symbol":   {FnName: "as.symbol", Impl: builtinAsName},
+		"is.name":     {FnName: "is.name", Impl: builtinIsName},
+		"is.symbol":   {FnName: "is.symbol", Impl: builtinIsName},
+		"is.call":     {FnName: "is.call", Impl: builtinIsCall},
+		"is.language": {FnName: "is.language", Impl: builtinIsLanguage},
+		"body":        {FnName: "body", Impl: builtinBody},
+		"formals":     {FnName: "formals", Impl: builtinFormals},
+		"args":        {FnName: "args", Impl: builtinArgs},
+		"match.call":  {FnName: "match.call", Impl: builtinMatchCall},
+		"sys.call":    {FnName: "sys.call", Impl: builtinSysCall},
+	}
+	for name, fn := range builtins {
+		env.SetLocal(name, fn)
+	}
+}
+
+// Language objects are ExprValues. Symbols hold an *ast.Ident; everything
+// else is a call. To R code every call looks like a function applied to
+// arguments, so x + y is `+`(x, y) and x[i] is `[`(x, i). callParts and
+// makeCall translate between that view and the typed AST.
+
+var binaryOps = map[string]token.Type{}
+
+func init() {
+	for _, op := range []token.Type{
+		token.PLUS, token.MINUS, token.STAR, token.SLASH, token.CARET, token.MOD,
+		token.INTDIV, token.INOP, token.MATMUL, token.OUTER, token.COLON,
+		token.AND, token.ANDAND, token.OR, token.OROR,
+		token.LT, token.LTE, token.GT, token.GTE, token.EQ, token.NEQ,
+		token.TILDE, token.QUESTION, token.AT, token.NS_GET, token.NS_GET_INT,
+	} {
+		binaryOps[string(op)] = op
+	}
+}
+
+// callParts returns the function and the arguments of a call.
+func callParts(e ast.Expr) (ast.Expr, []ast.Arg, bool) {
+	sym := func(name string) ast.Expr { return &ast.Ident{P: e.Pos(), Name: name} }
+	switch x := e.(type) {
+	case *ast.CallExpr:
+		return x.Fun, x.Args, true
+	case *ast.BinaryExpr:
+		return sym(string(x.Op)), []ast.Arg{{Value: x.Left}, {Value: x.Right}}, true
+	case *ast.UnaryExpr:
+		return sym(string(x.Op)), []ast.Arg{{Value: x.X}}, true
+	case *ast.AssignExpr:
+		switch x.Op {
+		case token.ASSIGN_RIGHT:
+			return sym(string(token.ASSIGN_LEFT)), []ast.Arg{{Value: x.Right}, {Value: x.Left}}, true
+		case token.ASSIGN_SUPER_RIGHT:
+			return sym(string(token.ASSIGN_SUPER)), []ast.Arg{{Value: x.Right}, {Value: x.Left}}, true
+		}
+		return sym(string(x.Op)), []ast.Arg{{Value: x.Left}, {Value: x.Right}}, true
+	case *ast.IndexExpr:
+		name := "["
+		if x.Double {
+			name = "[["
+		}
+		return sym(name), append([]ast.Arg{{Value: x.X}}, x.Args...), true
+	case *ast.DollarExpr:
+		return sym("$"), []ast.Arg{{Value: x.X}, {Value: sym(x.Name)}}, true
+	case *ast.BlockExpr:
+		args := make([]ast.Arg, len(x.Exprs))
+		for i, v := range x.Exprs {
+			args[i] = ast.Arg{Value: v}
+		}
+		return sym("{"), args, true
+	case *ast.IfExpr:
+		args := []ast.Arg{{Value: x.Cond}, {Value: x.Then}}
+		if x.Else != nil {
+			args = append(args, ast.Arg{Value: x.Else})
+		}
+		return sym("if"), args, true
+	case *ast.ForExpr:
+		return sym("for"), []ast.Arg{{Value: sym(x.Var)}, {Value: x.Seq}, {Value: x.Body}}, true
+	case *ast.WhileExpr:
+		return sym("while"), []ast.Arg{{Value: x.Cond}, {Value: x.Body}}, true
+	case *ast.RepeatExpr:
+		return sym("repeat"), []ast.Arg{{Value: x.Body}}, true
+	case *ast.BreakExpr:
+		return sym("break"), nil, true
+	case *ast.NextExpr:
+		return sym("next"), nil, true
+	case *ast.ReturnExpr:
+		if x.X == nil {
+			return sym("return"), nil, true
+		}
+		return sym("return"), []ast.Arg{{Value: x.X}}, true
+	}
+	return nil, nil, false
+}
+
+// makeCall builds the expression for fun(args). Calls of operators and
+// keywords become the corresponding AST nodes so that they evaluate and
+// deparse like parsed code.
+func makeCall(pos token.Pos, fun ast.Expr, args []ast.Arg) ast.Expr {
+	id, ok := fun.(*ast.Ident)
+	if !ok {
+		return &ast.CallExpr{P: pos, Fun: fun, Args: args}
+	}
+	unnamed := true
+	vals := make([]ast.Expr, len(args))
+	for i, a := range args {
+		unnamed = unnamed && a.Name == "" && a.Value != nil
+		vals[i] = a.Value
+	}
+	name, n := id.Name, len(args)
+	switch {
+	case !unnamed && name != "[" && name != "[[":
+	case (binaryOps[name] != "" || token.IsSpecial(token.Type(name))) && n == 2:
+		return &ast.BinaryExpr{P: pos, Op: token.Type(name), Left: vals[0], Right: vals[1]}
+	case (name == "-" || name == "+" || name == "!" || name == "~" || name == "?") && n == 1:
+		return &ast.UnaryExpr{P: pos, Op: token.Type(name), X: vals[0]}
+	case (name == "<-" || name == "<<-" || name == "=") && n == 2:
+		return &ast.AssignExpr{P: pos, Op: token.Type(name), Left: vals[0], Right: vals[1]}
+	case (name == "[" || name == "[[") && n >= 1 && args[0].Name == "" && args[0].Value != nil:
+		return &ast.IndexExpr{P: pos, X: vals[0], Args: args[1:], Double: name == "[["}
+	case name == "$" && n == 2:
+		switch field := vals[1].(type) {
+		case *ast.Ident:
+			return &ast.DollarExpr{P: pos, X: vals[0], Name: field.Name}
+		case *ast.StringLit:
+			return &ast.DollarExpr{P: pos, X: vals[0], Name: field.Value}
+		}
+	case name == "{":
+		return &ast.BlockExpr{P: pos, Exprs: vals}
+	case name == "if" && (n == 2 || n == 3):
+		e := &ast.IfExpr{P: pos, Cond: vals[0], Then: vals[1]}
+		if n == 3 {
+			e.Else = vals[2]
+		}
+		return e
+	case name == "for" && n == 3:
+		if v, ok := vals[0].(*ast.Ident); ok {
+			return &ast.ForExpr{P: pos, Var: v.Name, Seq: vals[1], Body: vals[2]}
+		}
+	case name == "while" && n == 2:
+		return &ast.WhileExpr{P: pos, Cond: vals[0], Body: vals[1]}
+	case name == "repeat" && n == 1:
+		return &ast.RepeatExpr{P: pos, Body: vals[0]}
+	case name == "break" && n == 0:
+		return &ast.BreakExpr{P: pos}
+	case name == "next" && n == 0:
+		return &ast.NextExpr{P: pos}
+	case name == "return" && n <= 1:
+		e := &ast.ReturnExpr{P: pos}
+		if n == 1 {
+			e.X = vals[0]
+		}
+		return e
+	}
+	return &ast.CallExpr{P: pos, Fun: fun, Args: args}
+}
+
+// langValue returns the value of a quoted expression: constants stand for
+// themselves, anything else is a language object.
+func langValue(e ast.Expr) Value {
+	switch x := e.(type) {
+	case *ast.NumberLit:
+		return numberValue(x)
+	case *ast.StringLit:
+		return CharScalar(x.Value)
+	case *ast.BoolLit:
+		return LogicalScalar(x.Value)
+	case *ast.NullLit:
+		return NullValue
+	case *ast.NALit:
+		return LogicalNA()
+	case *ast.ValueExpr:
+		return x.Value.(Value)
+	}
+	return &ExprValue{Expr: e}
+}
+
+// valueExpr is the inverse of langValue: it turns a value into an
+// expression that evaluates to it.
+func valueExpr(v Value) ast.Expr {
+	switch t := v.(type) {
+	case *ExprValue:
+		return t.Expr
+	case *Promise:
+		if t.Expr != nil {
+			return t.Expr
+		}
+	case *Missing:
+		return &ast.Ident{}
+	}
+	return lazyValueExpr(v)
+}
+
+// lazyValueExpr embeds v in an expression. Its text is only deparsed when
+// something prints the expression, as sys.call() or an error message does,
+// so that passing a long vector through a call stays cheap.
+func lazyValueExpr(v Value) *ast.ValueExpr {
+	return &ast.ValueExpr{Value: v, Deparse: func() string { return deparseValue(v) }}
+}
+
+func isSymbol(v Value) bool {
+	e, ok := v.(*ExprValue)
+	if !ok {
+		return false
+	}
+	_, ok = e.Expr.(*ast.Ident)
+	return ok
+}
+
+// langClass is the class of a language object, as class() reports it.
+func langClass(e *ExprValue) string {
+	switch x := e.Expr.(type) {
+	case *ast.Ident:
+		return "name"
+	case *ast.IfExpr:
+		return "if"
+	case *ast.ForExpr:
+		return "for"
+	case *ast.WhileExpr:
+		return "while"
+	case *ast.BlockExpr:
+		return "{"
+	case *ast.AssignExpr:
+		if x.Op == token.ASSIGN_EQ {
+			return "="
+		}
+		return "<-"
+	}
+	return "call"
+}
+
+// langToList converts a call to a list of its function and arguments.
+func langToList(e *ExprValue) *ListVec {
+	fun, args, ok := callParts(e.Expr)
+	if !ok {
+		return &ListVec{Data: []Value{e}}
+	}
+	out := &ListVec{Data: []Value{langValue(fun)}}
+	names := []string{""}
+	named := false
+	for _, a := range args {
+		if a.Value == nil {
+			out.Data = append(out.Data, &ExprValue{Expr: &ast.Ident{}})
+		} else {
+			out.Data = append(out.Data, langValue(a.Value))
+		}
+		names = append(names, a.Name)
+		named = named || a.Name != ""
+	}
+	if named {
+		out.SetAttr("names", charVecOf(names))
+	}
+	return out
+}
+
+// listToLang builds a call from a list whose first element is the
+// function.
+func listToLang(l *ListVec) (Value, error) {
+	if len(l.Data) == 0 {
+		return nil, fmt.Errorf("invalid argument list")
+	}
+	names, _ := listNames(l)
+	fun := valueExpr(l.Data[0])
+	if cv, ok := l.Data[0].(*CharVec); ok && cv.Len() == 1 && !cv.Data[0].NA {
+		fun = &ast.Ident{Name: cv.Data[0].Val}
+	}
+	args := make([]ast.Arg, 0, len(l.Data)-1)
+	for i, v := range l.Data[1:] {
+		a := ast.Arg{Value: valueExpr(v)}
+		if i+1 < len(names) {
+			a.Name = names[i+1]
+		}
+		args = append(args, a)
+	}
+	return &ExprValue{Expr: makeCall(token.Pos{}, fun, args)}, nil
+}
+
+// exprRewriter rebuilds an expression bottom-up. expr may replace a node
+// outright; args may replace a single call argument by any number of
+// arguments.
+type exprRewriter struct {
+	expr func(ast.Expr) (ast.Expr, bool, error)
+	args func(ast.Arg) ([]ast.Arg, bool, error)
+}
+
+func (r *exprRewriter) rewrite(e ast.Expr) (ast.Expr, error) {
+	if e == nil {
+		return nil, nil
+	}
+	if r.expr != nil {
+		if out, ok, err := r.expr(e); ok || err != nil {
+			return out, err
+		}
+	}
+	if f, ok := e.(*ast.FuncExpr); ok {
+		out := &ast.FuncExpr{P: f.P, Params: make([]ast.Param, len(f.Params))}
+		for i, prm := range f.Params {
+			def, err := r.rewrite(prm.Default)
+			if err != nil {
+				return nil, err
+			}
+			out.Params[i] = ast.Param{Name: prm.Name, Default: def, Dots: prm.Dots}
+		}
+		body, err := r.rewrite(f.Body)
+		if err != nil {
+			return nil, err
+		}
+		out.Body = body
+		return out, nil
+	}
+	fun, args, ok := callParts(e)
+	if !ok {
+		return e, nil
+	}
+	if _, isCall := e.(*ast.CallExpr); isCall {
+		var err error
+		if fun, err = r.rewrite(fun); err != nil {
+			return nil, err
+		}
+	}
+	var out []ast.Arg
+	for _, a := range args {
+		if r.args != nil {
+			repl, ok, err := r.args(a)
+			if err != nil {
+				return nil, err
+			}
+			if ok {
+				out = append(out, repl...)
+				continue
+			}
+		}
+		v, err := r.rewrite(a.Value)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, ast.Arg{Name: a.Name, Value: v})
+	}
+	return makeCall(e.Pos(), fun, out), nil
+}
+
+// deparseValue renders a value as R source code.
+func deparseValue(v Value) string {
+	return deparse.Expr(valueAST(v))
+}
+
+// valueAST builds the expression dput writes for v. Attributes other than
+// names are set with structure().
+func valueAST(v Value) ast.Expr {
+	switch t := v.(type) {
+	case *Null:
+		return &ast.NullLit{}
+	case *ExprValue:
+		return t.Expr
+	case *ClosureFunc:
+		return closureExpr(t)
+	case *BuiltinFunc:
+		return callAST(".Primitive", []ast.Arg{{Value: &ast.StringLit{Value: t.FnName}}})
+	case *EnvValue:
+		return &ast.ValueExpr{Text: "<environment>"}
+	}
+	data := dataAST(v)
+	if data == nil {
+		return &ast.ValueExpr{Text: v.String()}
+	}
+	return structureAST(v, data)
+}
+
+// dataAST writes the elements of a vector or list together with their
+// names.
+func dataAST(v Value) ast.Expr {
+	if l, ok := v.(*ListVec); ok {
+		names, _ := listNames(l)
+		args := make([]ast.Arg, len(l.Data))
+		for i, e := range l.Data {
+			args[i] = ast.Arg{Name: nameAt(names, i), Value: valueAST(e)}
+		}
+		return callAST("list", args)
+	}
+	elems := deparseElems(v)
+	if elems == nil {
+		return nil
+	}
+	names := valueNames(v)
+	if len(elems) == 0 {
+		return callAST(emptyVectorFn[v.Type()], []ast.Arg{{Value: &ast.ValueExpr{Text: "0"}}})
+	}
+	if names == nil {
+		if len(elems) == 1 {
+			return &ast.ValueExpr{Text: elems[0]}
+		}
+		if s, ok := deparseRange(v); ok {
+			return &ast.ValueExpr{Text: s}
+		}
+	}
+	args := make([]ast.Arg, len(elems))
+	for i, e := range elems {
+		args[i] = ast.Arg{Name: nameAt(names, i), Value: &ast.ValueExpr{Text: e}}
+	}
+	return callAST("c", args)
+}
+
+func callAST(name string, args []ast.Arg) ast.Expr {
+	return &ast.CallExpr{Fun: &ast.Ident{Name: name}, Args: args}
+}
+
+var emptyVectorFn = map[string]string{
+	"logical": "logical", "integer": "integer", "double": "numeric", "complex": "complex", "character": "character",
+}
+
+// structureAST wraps data in structure() when v carries attributes besides
+// names. dim, dimnames and levels come first and class and row.names last,
+// the order in which R's constructors set them.
+func structureAST(v Value, data ast.Expr) ast.Expr {
+	var keys []string
+	for k := range v.Attrs() {
+		if k != "names" {
+			keys = append(keys, k)
+		}
+	}
+	if len(keys) == 0 {
+		return data
+	}
+	rank := map[string]int{"dim": -3, "dimnames": -2, "levels": -1, "class": 1, "row.names": 2}
+	sort.Slice(keys, func(i, j int) bool {
+		if rank[keys[i]] != rank[keys[j]] {
+			return rank[keys[i]] < rank[keys[j]]
+		}
+		return keys[i] < keys[j]
+	})
+	args := []ast.Arg{{Value: data}}
+	for _, k := range keys {
+		a, _ := v.GetAttr(k)
+		args = append(args, ast.Arg{Name: k, Value: valueAST(a)})
+	}
+	return callAST("structure", args)
+}
+
+// deparseElems returns the elements of an atomic vector in R syntax.
+func deparseElems(v Value) []string {
+	var out []string
+	switch t := v.(type) {
+	case *LogicalVec:
+		for _, e := range t.Data {
+			switch {
+			case e.NA:
+				out = append(out, "NA")
+			case e.Val:
+				out = append(out, "TRUE")
+			default:
+				out = append(out, "FALSE")
+			}
+		}
+	case *IntVec:
+		for _, e := range t.Data {
+			if e.NA {
+				out = append(out, "NA")
+			} else {
+				out = append(out, strconv.FormatInt(e.Val, 10)+"L")
+			}
+		}
+	case *DoubleVec:
+		for _, e := range t.Data {
+			out = append(out, deparseDouble(e))
+		}
+	case *ComplexVec:
+		for _, e := range t.Data {
+			if e.NA {
+				out = append(out, "NA")
+				continue
+			}
+			re, im := real(e.Val), imag(e.Val)
+			sign := "+"
+			if im < 0 || math.IsInf(im, -1) {
+				sign, im = "-", -im
+			}
+			out = append(out, deparseDouble(FloatElem{Val: re})+sign+deparseDouble(FloatElem{Val: im})+"i")
+		}
+	case *CharVec:
+		for _, e := range t.Data {
+			if e.NA {
+				out = append(out, "NA")
+			} else {
+				out = append(out, deparse.Quote(e.Val))
+			}
+		}
+	default:
+		return nil
+	}
+	if out == nil {
+		out = []string{}
+	}
+	// a vector of NAs only keeps its type through the typed constants
+	if na := typedNA[v.Type()]; na != "" && !anyNonNA(out) {
+		for i := range out {
+			out[i] = na
+		}
+	}
+	return out
+}
+
+var typedNA = map[string]string{
+	"integer": "NA_integer_", "double": "NA_real_", "complex": "NA_complex_", "character": "NA_character_",
+}
+
+func anyNonNA(elems []string) bool {
+	for _, e := range elems {
+		if e != "NA" {
+			return true
+		}
+	}
+	return false
+}
+
+func deparseDouble(e FloatElem) string {
+	switch {
+	case e.NA:
+		return "NA"
+	case math.IsNaN(e.Val):
+		return "NaN"
+	case math.IsInf(e.Val, 1):
+		return "Inf"
+	case math.IsInf(e.Val, -1):
+		return "-Inf"
+	case e.Val == 0:
+		return "0"
+	}
+	return strconv.FormatFloat(e.Val, 'g', 15, 64)
+}
+
+// deparseRange writes integer runs such as 1:10 the way R does.
+func deparseRange(v Value) (string, bool) {
+	iv, ok := v.(*IntVec)
+	if !ok || len(iv.Data) < 2 {
+		return "", false
+	}
+	for i, e := range iv.Data {
+		if e.NA || e.Val != iv.Data[0].Val+int64(i) {
+			return "", false
+		}
+	}
+	return fmt.Sprintf("%d:%d", iv.Data[0].Val, iv.Data[len(iv.Data)-1].Val), true
+}
+
+// closureExpr returns the function expression a closure was created from.
+func closureExpr(fn *ClosureFunc) *ast.FuncExpr {
+	params := make([]ast.Param, len(fn.Params))
+	for i, p := range fn.Params {
+		params[i] = ast.Param{Name: p.Name, Default: p.Default, Dots: p.Dots}
+	}
+	return &ast.FuncExpr{Params: params, Body: fn.Body}
+}
+
+// --- builtins ---
+
+// evalEnvArg converts the envir argument of eval: an environment, or a
+// list or data frame whose elements become variables of a new environment
+// enclosed by enclos.
+func evalEnvArg(v Value, enclos *Env) (*Env, error) {
+	switch t := v.(type) {
+	case nil:
+		return enclos, nil
+	case *EnvValue:
+		return t.Env, nil
+	case *Null:
+		return enclos, nil
+	case *ListVec:
+		env := NewEnv(enclos)
+		names, _ := listNames(t)
+		for i, n := range names {
+			if n != "" && i < len(t.Data) {
+				env.SetLocal(n, t.Data[i])
+			}
+		}
+		return env, nil
+	}
+	return nil, fmt.Errorf("invalid 'envir' argument of type '%s'", v.Type())
+}
+
+func evalIn(ctx *Context, expr Value, envir, enclos Value, caller *Env) (Value, error) {
+	enc, err := envArg(enclos, caller, "enclos")
+	if err != nil {
+		return nil, err
+	}
+	env, err := evalEnvArg(envir, enc)
+	if err != nil {
+		return nil, err
+	}
+	e, ok := expr.(*ExprValue)
+	if !ok {
+		return expr, nil
+	}
+	v, err := Eval(ctx, env, e.Expr)
+	if err != nil {
+		return nil, err
+	}
+	return Force(ctx, v)
+}
+
+// builtinEval implements eval(expr, envir = parent.frame(), enclos).
+func builtinEval(ctx *Context, args []ArgValue) (Value, error) {
+	caller := ctx.callingEnv()
+	fargs, err := forceArgs(ctx, args)
+	if err != nil {
+		return nil, err
+	}
+	m, _ := matchArgs(fargs, "expr", "envir", "enclos")
+	if m[0] == nil {
+		return nil, fmt.Errorf("argument \"expr\" is missing, with no default")
+	}
+	return evalIn(ctx, m[0], m[1], m[2], caller)
+}
+
+// builtinEvalq is eval with its first argument quoted.
+func builtinEvalq(ctx *Context, args []ArgValue) (Value, error) {
+	caller := ctx.callingEnv()
+	m, _ := matchArgs(args, "expr", "envir", "enclos")
+	if m[0] == nil {
+		return nil, fmt.Errorf("argument \"expr\" is missing, with no default")
+	}
+	var expr Value
+	if p, ok := m[0].(*Promise); ok {
+		expr = langValue(p.Expr)
+	} else {
+		expr = m[0]
+	}
+	for _, i := range []int{1, 2} {
+		if m[i] != nil {
+			v, err := Force(ctx, m[i])
+			if err != nil {
+				return nil, err
+			}
+			m[i] = v
+		}
+	}
+	return evalIn(ctx, expr, m[1], m[2], caller)
+}
+
+// builtinSubstitute implements substitute(expr, env). Symbols bound in
+// env are replaced: arguments by the expression they were called with,
+// other variables by their value. In the global environment nothing is
+// substituted.
+func builtinSubstitute(ctx *Context, args []ArgValue) (Value, error) {
+	caller := ctx.callingEnv()
+	m, _ := matchArgs(args, "expr", "env")
+	if m[0] == nil {
+		return nil, fmt.Errorf("argument \"expr\" is missing, with no default")
+	}
+	p, ok := m[0].(*Promise)
+	if !ok {
+		return m[0], nil
+	}
+	lookup := func(name string) (Value, bool) { return caller.GetLocal(name) }
+	if caller == ctx.Global {
+		lookup = func(string) (Value, bool) { return nil, false }
+	}
+	if m[1] != nil {
+		v, err := Force(ctx, m[1])
+		if err != nil {
+			return nil, err
+		}
+		switch t := v.(type) {
+		case *EnvValue:
+			lookup = func(name string) (Value, bool) { return t.Env.GetLocal(name) }
+			if t.Env == ctx.Global {
+				lookup = func(string) (Value, bool) { return nil, false }
+			}
+		case *ListVec:
+			names, _ := listNames(t)
+			lookup = func(name string) (Value, bool) {
+				for i, n := range names {
+					if n == name && i < len(t.Data) {
+						return t.Data[i], true
+					}
+				}
+				return nil, false
+			}
+		default:
+			return nil, fmt.Errorf("invalid environment specified")
+		}
+	}
+	r := &exprRewriter{
+		expr: func(e ast.Expr) (ast.Expr, bool, error) {
+			id, ok := e.(*ast.Ident)
+			if !ok || id.Name == "..." {
+				return nil, false, nil
+			}
+			v, ok := lookup(id.Name)
+			if !ok || v == MissingValue {
+				return e, true, nil
+			}
+			return valueExpr(v), true, nil
+		},
+		args: func(a ast.Arg) ([]ast.Arg, bool, error) {
+			id, ok := a.Value.(*ast.Ident)
+			if !ok || id.Name != "..." {
+				return nil, false, nil
+			}
+			v, ok := lookup("...")
+			d, isDots := v.(*Dots)
+			if !ok || !isDots {
+				return nil, false, nil
+			}
+			out := make([]ast.Arg, len(d.Args))
+			for i, da := range d.Args {
+				out[i] = ast.Arg{Name: da.Name, Value: valueExpr(da.Val)}
+			}
+			return out, true, nil
+		},
+	}
+	e, err := r.rewrite(p.Expr)
+	if err != nil {
+		return nil, err
+	}
+	return langValue(e), nil
+}
+
+// builtinBquote implements bquote(expr, where, splice): .(x) is replaced
+// by the value of x in where, and with splice = TRUE ..(x) splices the
+// elements of x into the surrounding call.
+func builtinBquote(ctx *Context, args []ArgValue) (Value, error) {
+	caller := ctx.callingEnv()
+	m, _ := matchArgs(args, "expr", "where", "splice")
+	if m[0] == nil {
+		return nil, fmt.Errorf("argument \"expr\" is missing, with no default")
+	}
+	for _, i := range []int{1, 2} {
+		if m[i] != nil {
+			v, err := Force(ctx, m[i])
+			if err != nil {
+				return nil, err
+			}
+			m[i] = v
+		}
+	}
+	where, err := envArg(m[1], caller, "where")
+	if err != nil {
+		return nil, err
+	}
+	splice, err := logicalArg(ctx, m[2], false)
+	if err != nil {
+		return nil, err
+	}
+	p, ok := m[0].(*Promise)
+	if !ok {
+		return m[0], nil
+	}
+	unquoted := func(e ast.Expr, fn string) (ast.Expr, bool) {
+		c, ok := e.(*ast.CallExpr)
+		if !ok || len(c.Args) != 1 {
+			return nil, false
+		}
+		id, ok := c.Fun.(*ast.Ident)
+		if !ok || id.Name != fn {
+			return nil, false
+		}
+		return c.Args[0].Value, true
+	}
+	eval := func(e ast.Expr) (Value, error) {
+		v, err := Eval(ctx, where, e)
+		if err != nil {
+			return nil, err
+		}
+		return Force(ctx, v)
+	}
+	r := &exprRewriter{
+		expr: func(e ast.Expr) (ast.Expr, bool, error) {
+			x, ok := unquoted(e, ".")
+			if !ok {
+				return nil, false, nil
+			}
+			v, err := eval(x)
+			if err != nil {
+				return nil, false, err
+			}
+			return valueExpr(v), true, nil
+		},
+		args: func(a ast.Arg) ([]ast.Arg, bool, error) {
+			x, ok := unquoted(a.Value, "..")
+			if !ok || !splice {
+				return nil, false, nil
+			}
+			v, err := eval(x)
+			if err != nil {
+				return nil, false, err
+			}
+			var out []ast.Arg
+			switch t := v.(type) {
+			case *ListVec:
+				names, _ := listNames(t)
+				for i, e := range t.Data {
+					out = append(out, ast.Arg{Name: nameAt(names, i), Value: valueExpr(e)})
+				}
+			case *ExprValue:
+				out = append(out, ast.Arg{Value: t.Expr})
+			default:
+				for i := 0; i < v.Len(); i++ {
+					e, err := vectorElement(ctx, v, i)
+					if err != nil {
+						return nil, false, err
+					}
+					out = append(out, ast.Arg{Value: valueExpr(e)})
+				}
+			}
+			return out, true, nil
+		},
+	}
+	e, err := r.rewrite(p.Expr)
+	if err != nil {
+		return nil, err
+	}
+	return langValue(e), nil
+}
+
+func nameAt(names []string, i int) string {
+	if i < len(names) {
+		return names[i]
+	}
+	return ""
+}
+
+// builtinDeparse returns the R source of a value, one element per line.
+// Lines are cut after width.cutoff characters where the code allows it.
+func builtinDeparse(ctx *Context, args []ArgValue) (Value, error) {
+	fargs, err := forceArgs(ctx, args)
+	if err != nil {
+		return nil, err
+	}
+	m, _ := matchArgs(fargs, "expr", "width.cutoff", "backtick", "control", "nlines")
+	if m[0] == nil {
+		return nil, fmt.Errorf("argument \"expr\" is missing, with no default")
+	}
+	opts := deparse.DefaultOptions
+	if m[1] != nil {
+		w, err := intArg(ctx, m[1], "width.cutoff")
+		if err != nil {
+			return nil, err
+		}
+		if w < 20 || w > 500 {
+			return nil, fmt.Errorf("invalid 'cutoff' value for 'deparse', using default")
+		}
+		opts.Width = w
+	}
+	return charVecOf(opts.Lines(valueAST(m[0]))), nil
+}
+
+// builtinDput writes the R source of x to the output and returns x.
+func builtinDput(ctx *Context, args []ArgValue) (Value, error) {
+	fargs, err := forceArgs(ctx, args)
+	if err != nil {
+		return nil, err
+	}
+	m, _ := matchArgs(fargs, "x", "file", "control")
+	if m[0] == nil {
+		return nil, fmt.Errorf("argument \"x\" is missing, with no default")
+	}
+	for _, line := range deparse.DefaultOptions.Lines(valueAST(m[0])) {
+		fmt.Fprintln(ctx.Output, line)
+	}
+	return m[0], nil
+}
+
+// builtinCall implements call(name, ...): the arguments are evaluated and
+// stored in the call.
+func builtinCall(ctx *Context, args []ArgValue) (Value, error) {
+	fargs, err := forceArgs(ctx, args)
+	if err != nil {
+		return nil, err
+	}
+	if len(fargs) == 0 {
+		return nil, fmt.Errorf("first argument must be a character string")
+	}
+	name, ok := fargs[0].Val.(*CharVec)
+	if !ok || name.Len() != 1 || name.Data[0].NA {
+		return nil, fmt.Errorf("first argument must be a character string")
+	}
+	callArgs := make([]ast.Arg, 0, len(fargs)-1)
+	for _, a := range fargs[1:] {
+		callArgs = append(callArgs, ast.Arg{Name: a.Name, Value: valueExpr(a.Val)})
+	}
+	return &ExprValue{Expr: makeCall(token.Pos{}, &ast.Ident{Name: name.Data[0].Val}, callArgs)}, nil
+}
+
+func builtinAsCall(ctx *Context, args []ArgValue) (Value, error) {
+	if len(args) != 1 {
+		return nil, fmt.Errorf("as.call(x) expects 1 argument")
+	}
+	v, err := Force(ctx, args[0].Val)
+	if err != nil {
+		return nil, err
+	}
+	switch t := v.(type) {
+	case *ExprValue:
+		if !isSymbol(t) {
+			return t, nil
+		}
+	case *ListVec:
+		return listToLang(t)
+	}
+	return nil, fmt.Errorf("invalid argument list")
+}
+
+func builtinAsName(ctx *Context, args []ArgValue) (Value, error) {
+	if len(args) != 1 {
+		return nil, fmt.Errorf("as.name(x) expects 1 argument")
+	}
+	v, err := Force(ctx, args[0].Val)
+	if err != nil {
+		return nil, err
+	}
+	if isSymbol(v) {
+		return v, nil
+	}
+	s, err := asCharVec(ctx, v)
+	if err != nil || len(s) == 0 || s[0].NA {
+		return nil, fmt.Errorf("invalid type/length (symbol/%d) in vector allocation", v.Len())
+	}
+	return &ExprValue{Expr: &ast.Ident{Name: s[0].Val}}, nil
+}
+
+func langPredicate(name string, test func(Value) bool) func(*Context, []ArgValue) (Value, error) {
+	return func(ctx *Context, args []ArgValue) (Value, error) {
+		if len(args) != 1 {
+			return nil, fmt.Errorf("%s(x) expects 1 argument", name)
+		}
+		v, err := Force(ctx, args[0].Val)
+		if err != nil {
+			return nil, err
+		}
+		return LogicalScalar(test(v)), nil
+	}
+}
+
+func builtinIsName(ctx *Context, args []ArgValue) (Value, error) {
+	return langPredicate("is.name", isSymbol)(ctx, args)
+}
+
+func builtinIsCall(ctx *Context, args []ArgValue) (Value, error) {
+	return langPredicate("is.call", func(v Value) bool {
+		_, ok := v.(*ExprValue)
+		return ok && !isSymbol(v)
+	})(ctx, args)
+}
+
+func builtinIsLanguage(ctx *Context, args []ArgValue) (Value, error) {
+	return langPredicate("is.language", func(v Value) bool {
+		_, ok := v.(*ExprValue)
+		return ok
+	})(ctx, args)
+}
+
+// closureArg returns the closure a function argument refers to; a string
+// is looked up as a function name.
+func closureArg(ctx *Context, v Value) (Value, error) {
+	if cv, ok := v.(*CharVec); ok && cv.Len() == 1 && !cv.Data[0].NA {
+		fn, ok := lookupFunction(ctx, ctx.callingEnv(), cv.Data[0].Val)
+		if !ok {
+			return nil, fmt.Errorf("could not find function \"%s\"", cv.Data[0].Val)
+		}
+		return fn, nil
+	}
+	return v, nil
+}
+
+func builtinBody(ctx *Context, args []ArgValue) (Value, error) {
+	if len(args) != 1 {
+		return nil, fmt.Errorf("body(fun) expects 1 argument")
+	}
+	v, err := Force(ctx, args[0].Val)
+	if err != nil {
+		return nil, err
+	}
+	if v, err = closureArg(ctx, v); err != nil {
+		return nil, err
+	}
+	if fn, ok := v.(*ClosureFunc); ok {
+		return langValue(fn.Body), nil
+	}
+	return NullValue, nil
+}
+
+// builtinFormals returns the parameters of a closure as a named list of
+// their defaults; parameters without a default hold the empty symbol.
+func builtinFormals(ctx *Context, args []ArgValue) (Value, error) {
+	if len(args) != 1 {
+		return nil, fmt.Errorf("formals(fun) expects 1 argument")
+	}
+	v, err := Force(ctx, args[0].Val)
+	if err != nil {
+		return nil, err
+	}
+	if v, err = closureArg(ctx, v); err != nil {
+		return nil, err
+	}
+	fn, ok := v.(*ClosureFunc)
+	if !ok || len(fn.Params) == 0 {
+		return NullValue, nil
+	}
+	out := &ListVec{}
+	names := make([]string, len(fn.Params))
+	for i, p := range fn.Params {
+		names[i] = p.Name
+		if p.Dots {
+			names[i] = "..."
+		}
+		if p.Default != nil {
+			out.Data = append(out.Data, langValue(p.Default))
+		} else {
+			out.Data = append(out.Data, &ExprValue{Expr: &ast.Ident{}})
+		}
+	}
+	out.SetAttr("names", charVecOf(names))
+	return out, nil
+}
+
+// builtinArgs returns a function with the parameters of fun and a NULL
+// body, which prints as the function's signature.
+func builtinArgs(ctx *Context, args []ArgValue) (Value, error) {
+	if len(args) != 1 {
+		return nil, fmt.Errorf("args(name) expects 1 argument")
+	}
+	v, err := Force(ctx, args[0].Val)
+	if err != nil {
+		return nil, err
+	}
+	if v, err = closureArg(ctx, v); err != nil {
+		return nil, err
+	}
+	fn, ok := v.(*ClosureFunc)
+	if !ok {
+		return NullValue, nil
+	}
+	return &ClosureFunc{Params: fn.Params, Body: &ast.NullLit{}, Env: fn.Env}, nil
+}
+
+// frameCall returns the call of fr. Calls made from Go code, e.g. by
+// lapply, have no call expression; one is built from the arguments.
+func frameCall(fr *Frame) *ast.CallExpr {
+	if fr.Call != nil {
+		return fr.Call
+	}
+	var fun ast.Expr = &ast.Ident{Name: fr.Fn.FnName}
+	if fr.Fn.FnName == "" {
+		fun = valueExpr(fr.Fn)
+	}
+	c := &ast.CallExpr{Fun: fun}
+	for _, a := range fr.Args {
+		c.Args = append(c.Args, ast.Arg{Name: a.Name, Value: valueExpr(a.Val)})
+	}
+	return c
+}
+
+// builtinSysCall implements sys.call(which): 0 is the current call,
+// positive values count from the outermost frame and negative ones back
+// from the current one.
+func builtinSysCall(ctx *Context, args []ArgValue) (Value, error) {
+	caller := ctx.callingEnv()
+	fargs, err := forceArgs(ctx, args)
+	if err != nil {
+		return nil, err
+	}
+	m, _ := matchArgs(fargs, "which")
+	which := 0
+	if m[0] != nil {
+		fe, err := asFloatElem(ctx, m[0])
+		if err != nil || fe.NA {
+			return nil, fmt.Errorf("invalid 'which' value")
+		}
+		which = int(fe.Val)
+	}
+	fr := ctx.frameFor(caller)
+	cur := -1
+	for i, f := range ctx.frames {
+		if f == fr {
+			cur = i
+		}
+	}
+	idx := cur
+	switch {
+	case which > 0:
+		idx = which - 1
+	case which < 0:
+		idx = cur + which
+	}
+	if idx < 0 || idx >= len(ctx.frames) {
+		if which == 0 && fr == nil {
+			return NullValue, nil
+		}
+		return nil, fmt.Errorf("not that many frames on the stack")
+	}
+	return &ExprValue{Expr: frameCall(ctx.frames[idx])}, nil
+}
+
+// builtinMatchCall implements match.call(definition, call, expand.dots):
+// the call is returned with every argument named by the parameter it
+// matched, in the order of the parameters. Arguments passed on through ...
+// are replaced by the expressions they stand for.
+func builtinMatchCall(ctx *Context, args []ArgValue) (Value, error) {
+	caller := ctx.callingEnv()
+	fargs, err := forceArgs(ctx, args)
+	if err != nil {
+		return nil, err
+	}
+	m, _ := matchArgs(fargs, "definition", "call", "expand.dots", "envir")
+	fr := ctx.frameFor(caller)
+	var def *ClosureFunc
+	var call *ast.CallExpr
+	var dotsEnv *Env
+	if fr != nil {
+		def, call, dotsEnv = fr.Fn, frameCall(fr), fr.Caller
+	}
+	if m[0] != nil && m[0] != NullValue {
+		fn, ok := m[0].(*ClosureFunc)
+		if !ok {
+			return nil, fmt.Errorf("invalid 'definition' argument")
+		}
+		def = fn
+	}
+	if m[1] != nil {
+		ev, ok := m[1].(*ExprValue)
+		c, isCall := ev.Expr.(*ast.CallExpr)
+		if !ok || !isCall {
+			return nil, fmt.Errorf("invalid 'call' argument")
+		}
+		call = c
+	}
+	if def == nil || call == nil {
+		return nil, fmt.Errorf("match.call() was called from outside a function")
+	}
+	expand, err := logicalArg(ctx, m[2], true)
+	if err != nil {
+		return nil, err
+	}
+
+	// expand ... in the call itself
+	var actual []ast.Arg
+	for _, a := range call.Args {
+		if id, ok := a.Value.(*ast.Ident); ok && id.Name == "..." && dotsEnv != nil {
+			if d, ok := lookupDots(dotsEnv); ok {
+				for _, da := range d.Args {
+					actual = append(actual, ast.Arg{Name: da.Name, Value: valueExpr(da.Val)})
+				}
+				continue
+			}
+		}
+		actual = append(actual, a)
+	}
+	names := make([]string, len(actual))
+	for i, a := range actual {
+		names[i] = a.Name
+	}
+	bound, dots, err := matchParams(def.Params, names)
+	if err != nil {
+		return nil, err
+	}
+	out := &ast.CallExpr{P: call.P, Fun: call.Fun}
+	for i, p := range def.Params {
+		if p.Dots {
+			if len(dots) == 0 {
+				continue
+			}
+			if expand {
+				for _, j := range dots {
+					out.Args = append(out.Args, actual[j])
+				}
+				continue
+			}
+			list := &ast.CallExpr{Fun: &ast.Ident{Name: "list"}}
+			for _, j := range dots {
+				list.Args = append(list.Args, actual[j])
+			}
+			out.Args = append(out.Args, ast.Arg{Name: "...", Value: list})
+			continue
+		}
+		if bound[i] >= 0 {
+			out.Args = append(out.Args, ast.Arg{Name: p.Name, Value: actual[bound[i]].Value})
+		}
+	}
+	return &ExprValue{Expr: out}, nil
+}
+
+func lookupDots(env *Env) (*Dots, bool) {
+	v, ok := env.Get("...")
+	if !ok {
+		return nil, false
+	}
+	d, ok := v.(*Dots)
+	return d, ok
+}
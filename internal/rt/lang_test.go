@@ -0,0 +1,209 @@
+package rt
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestLanguageObjects(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		// quote and language object basics
+		{"quote(x + y * 2)", "x + y * 2"},
+		{"quote((a + b) * c)", "(a + b) * c"},
+		{"class(quote(x))", `"name"`},
+		{"class(quote(f(x)))", `"call"`},
+		{"class(quote(if (a) b))", `"if"`},
+		{"class(quote(x <- 1))", `"<-"`},
+		{"typeof(quote(x))", `"symbol"`},
+		{"typeof(quote(x + 1))", `"language"`},
+		{"class(quote(1.5))", `"numeric"`},
+		{"length(quote(f(a, b, c)))", "4"},
+		{"is.call(quote(f(1)))", "TRUE"},
+		{"is.name(quote(f))", "TRUE"},
+		{"is.language(1)", "FALSE"},
+		// eval
+		{"x <- 2; eval(quote(x * 10))", "20"},
+		{"eval(quote(x + y), list(x = 1, y = 2))", "3"},
+		{"e <- new.env(); assign(\"v\", 5, envir = e); eval(quote(v), e)", "5"},
+		{"eval(7)", "7"},
+		{"f <- function() { z <- 3; eval(quote(z)) }; f()", "3"},
+		{"evalq(a * 2, list(a = 21))", "42"},
+		{"df <- data.frame(a = c(1, 2), b = c(3, 4)); eval(quote(a + b), df)", "4 6"},
+		// substitute and deparse
+		{"f <- function(x) substitute(x); f(a + b)", "a + b"},
+		{"f <- function(x) deparse(substitute(x)); f(mean(y[1:3]))", `"mean(y[1:3])"`},
+		{"f <- function(x, y) substitute(x * y); f(a + 1, b)", "(a + 1) * b"},
+		{"f <- function(...) substitute(list(...)); f(a, b = c + 1)", "list(a, b = c + 1)"},
+		{"substitute(a + b, list(a = 1.5, b = quote(z)))", "1.5 + z"},
+		{"x <- 1; substitute(x + 1)", "x + 1"},
+		{"f <- function(x) { y <- 2.5; substitute(x + y) }; f(q)", "q + 2.5"},
+		{"deparse(quote(if (a > 1) b else c))", `"if (a > 1) b else c"`},
+		{"deparse(c(a = 1.5, b = 2))", `"c(a = 1.5, b = 2)"`},
+		{"deparse(as.integer(c(1, 2, 3)))", `"1:3"`},
+		{"deparse(\"text\")", `"\"text\""`},
+		{"deparse(list(a = 1.5, b = \"x\"))", `"list(a = 1.5, b = \"x\")"`},
+		{"deparse(function(x, y = 2) x + y)", `"function(x, y = 2) x + y"`},
+		{"deparse(quote({ a; b }))", `"{" "    a" "    b" "}"`},
+		{"deparse(quote(f(aaaaaaaa, bbbbbbbb, cccccccc)), width.cutoff = 20)", `"f(aaaaaaaa, bbbbbbbb, " "    cccccccc)"`},
+		{"length(deparse(1:30 + 0.5))", "3"},
+		{"deparse(factor(c(\"a\", \"b\")))", `"structure(1:2, levels = c(\"a\", \"b\"), class = \"factor\")"`},
+		{"deparse(matrix(c(1.5, 2), 1))", `"structure(c(1.5, 2), dim = 1:2)"`},
+		{"deparse(c(NA_real_, NA))", `"c(NA_real_, NA_real_)"`},
+		{"deparse(character(0))", `"character(0)"`},
+		{"deparse(quote(x + Inf))", `"x + Inf"`},
+		{"deparse(sum)", `".Primitive(\"sum\")"`},
+		{"x <- dput(c(a = 1.5)); names(x)", `"a"`},
+		// bquote
+		{"v <- 2.5; bquote(x + .(v))", "x + 2.5"},
+		{"n <- quote(y); bquote(f(.(n), 2.5))", "f(y, 2.5)"},
+		{"args <- list(1.5, quote(z)); bquote(g(..(args)), splice = TRUE)", "g(1.5, z)"},
+		// call construction
+		{"call(\"round\", 10.5)", "round(10.5)"},
+		{"eval(call(\"sum\", 1, 2, 3))", "6"},
+		{"cl <- call(\"+\", quote(a), 1.5); cl", "a + 1.5"},
+		{"eval(as.call(list(as.name(\"max\"), 4, 9)))", "9"},
+		{"as.call(list(quote(f), x = 1.5))", "f(x = 1.5)"},
+		{"as.name(\"my var\")", "`my var`"},
+		{"as.symbol(\"x\")", "x"},
+		{"as.character(quote(f(x, 1)))", `"f" "x" "1"`},
+		{"as.character(quote(abc))", `"abc"`},
+		// manipulating calls
+		{"e <- quote(f(x, y)); e[[1]]", "f"},
+		{"e <- quote(f(x, y)); e[[3]]", "y"},
+		{"e <- quote(f(x, y)); e[[1]] <- as.name(\"g\"); e", "g(x, y)"},
+		{"e <- quote(a + b); e[[1]] <- as.name(\"*\"); e", "a * b"},
+		{"e <- quote(f(x, y, z)); e[-2]", "f(y, z)"},
+		{"names(as.list(quote(f(1, b = 2))))", `"" "" "b"`},
+		// functions
+		{"f <- function(x, y = 2) { x + y }; body(f)", "{\n    x + y\n}"},
+		{"f <- function(x, y = 2) x + y; names(formals(f))", `"x" "y"`},
+		{"f <- function(x, y = 2.5) x + y; formals(f)$y", "2.5"},
+		{"f <- function(x, y = 2) x + y; is.null(body(args(f)))", "TRUE"},
+		{"formals(sum)", "NULL"},
+		{"f <- function(a, b) NULL; names(formals(\"f\"))", `"a" "b"`},
+		// match.call and sys.call
+		{"f <- function(x, y = 2, ...) match.call(); f(1, z = 3, 4)", "f(x = 1, y = 4, z = 3)"},
+		{"f <- function(x, y) match.call(); f(y = 5, 1)", "f(x = 1, y = 5)"},
+		{"f <- function(x, y) match.call(); g <- function(...) f(...); g(7, a + b)", "f(x = 7, y = a + b)"},
+		{"f <- function(x, ...) match.call(expand.dots = FALSE); f(1, 2, 3)", "f(x = 1, ... = list(2, 3))"},
+		{"f <- function(x, y) NULL; match.call(f, quote(f(2, x = 1)))", "f(x = 1, y = 2)"},
+		{"f <- function(a) sys.call(); f(1 + 2)", "f(1 + 2)"},
+		{"g <- function() sys.call(-1); f <- function(x) g(); f(3)", "f(3)"},
+		{"sys.call()", "NULL"},
+		{"m <- function(x, ...) UseMethod(\"m\"); m.default <- function(x, ...) sys.call(); m(1)", "m(1)"},
+	}
+
+	for _, tt := range tests {
+		ctx := NewContext()
+		res, err := ctx.EvalString(tt.input)
+		if err != nil {
+			t.Errorf("input %q: unexpected error: %v", tt.input, err)
+			continue
+		}
+		if res.Value.String() != tt.expected {
+			t.Errorf("input %q: expected %s, got %s", tt.input, tt.expected, res.Value.String())
+		}
+	}
+}
+
+func TestLanguageErrors(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"call(1)", "first argument must be a character string"},
+		{"as.call(1)", "invalid argument list"},
+		{"match.call()", "match.call() was called from outside a function"},
+		{"eval(quote(x), 1.5)", "invalid 'envir' argument of type 'double'"},
+		{"eval(quote(nothere))", "object 'nothere' not found"},
+	}
+
+	for _, tt := range tests {
+		ctx := NewContext()
+		_, err := ctx.EvalString(tt.input)
+		if err == nil {
+			t.Errorf("input %q: expected error", tt.input)
+			continue
+		}
+		if err.Error() != tt.expected {
+			t.Errorf("input %q: expected error %q, got %q", tt.input, tt.expected, err.Error())
+		}
+	}
+}
+
+func TestDput(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"dput(c(1.5, 2))", "c(1.5, 2)\n"},
+		{"dput(5)", "5\n"},
+		{"dput(5L)", "5L\n"},
+		{"dput(c(2L, 4L))", "c(2L, 4L)\n"},
+		{"dput(2:4)", "2:4\n"},
+		{"dput(c(1i, 2))", "c(0+1i, 2+0i)\n"},
+		{"dput(list(a = \"x\", b = NULL))", "list(a = \"x\", b = NULL)\n"},
+		{"dput(data.frame(a = c(1.5, 2)))", "structure(list(a = c(1.5, 2)), class = \"data.frame\", row.names = 1:2)\n"},
+		{"dput(quote(f(x, y = 2)))", "f(x, y = 2)\n"},
+		{"dput(function(x) { x + 1 })", "function(x) {\n    x + 1\n}\n"},
+		{"dput((1:30) + 0.5)", "c(1.5, 2.5, 3.5, 4.5, 5.5, 6.5, 7.5, 8.5, 9.5, 10.5, 11.5, 12.5, \n" +
+			"    13.5, 14.5, 15.5, 16.5, 17.5, 18.5, 19.5, 20.5, 21.5, 22.5, \n" +
+			"    23.5, 24.5, 25.5, 26.5, 27.5, 28.5, 29.5, 30.5)\n"},
+		{"f <- function(x, y = 2) { if (x > y) x else y }; print(f)", "function(x, y = 2) {\n    if (x > y) x else y\n}\n"},
+		{"make <- function() function(a) a * 2; g <- make(); print(g)", "function(a) a * 2\n<environment: "},
+	}
+
+	for _, tt := range tests {
+		ctx := NewContext()
+		res, err := ctx.EvalString(tt.input)
+		if err != nil {
+			t.Errorf("input %q: unexpected error: %v", tt.input, err)
+			continue
+		}
+		if !strings.HasPrefix(res.Output, tt.expected) {
+			t.Errorf("input %q: expected output %q, got %q", tt.input, tt.expected, res.Output)
+		}
+	}
+}
+
+func TestDeparseRoundTrip(t *testing.T) {
+	inputs := []string{
+		"c(a = 1.5, b = -2, c = NA)",
+		"c(TRUE, NA, FALSE)",
+		"c(\"x\", NA, \"a\\\"b\")",
+		"list(1.5, list(x = \"y\"), NULL)",
+		"data.frame(n = c(1.5, 2), s = c(\"p\", \"q\"))",
+		"numeric(0)",
+		"c(1L, NA, 5L)",
+		"c(x = 1L, y = 2L)",
+		"factor(c(\"b\", \"a\", \"b\"))",
+		"matrix(1:6, nrow = 2)",
+		"c(1+2i, NA, -3i)",
+		"NA_complex_",
+		"complex(0)",
+		"function(x, ..., k = c(1.5, 2)) { y <- x[k]; y$z }",
+		"list(f = function(x) { x <- x * 2; -x^2 })",
+		"function(a) { y <- if (a) 1 else 2; f <- function(v) v; (function(v) v)(y) }",
+	}
+
+	for _, input := range inputs {
+		ctx := NewContext()
+		first, err := ctx.EvalString("paste(deparse(" + input + "), collapse = \"\\n\")")
+		if err != nil {
+			t.Errorf("input %q: unexpected error: %v", input, err)
+			continue
+		}
+		src := first.Value.(*CharVec).Data[0].Val
+		second, err := ctx.EvalString("paste(deparse(" + src + "), collapse = \"\\n\")")
+		if err != nil {
+			t.Errorf("input %q: deparsed %q does not evaluate: %v", input, src, err)
+			continue
+		}
+		if got := second.Value.(*CharVec).Data[0].Val; got != src {
+			t.Errorf("input %q: round trip changed %q to %q", input, src, got)
+		}
+	}
+}
@@ -0,0 +1,236 @@
+package rt
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+)
+
+// Struct support for ToValue and Decode. A struct converts to a named list
+// of its exported fields and a slice of structs to a data frame with one
+// column per field. The `r` field tag renames a field, and "-" leaves it
+// out; the option omitempty drops a zero field from the list (data frame
+// columns are always kept). Fields of embedded structs are promoted as if
+// they were fields of the outer struct. The database/sql Null types
+// convert like the value they hold, or NA if it is not Valid.
+
+// structField is a field of a struct as it appears in R.
+type structField struct {
+	name      string
+	index     []int
+	omitEmpty bool
+}
+
+var structFieldCache sync.Map // reflect.Type -> []structField
+
+// structFields returns the fields of the struct type t in declaration
+// order, with promoted fields in place of their embedded struct.
+func structFields(t reflect.Type) []structField {
+	if fs, ok := structFieldCache.Load(t); ok {
+		return fs.([]structField)
+	}
+	var all []structField
+	promoted := map[string]bool{}
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag := f.Tag.Get("r")
+		if tag == "-" {
+			continue
+		}
+		name, opts, _ := strings.Cut(tag, ",")
+		if f.Anonymous && name == "" && f.Type.Kind() == reflect.Struct && typeKind(f.Type) == kindNone {
+			for _, sf := range structFields(f.Type) {
+				sf.index = append([]int{i}, sf.index...)
+				all = append(all, sf)
+			}
+			continue
+		}
+		if !f.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		all = append(all, structField{name: name, index: f.Index, omitEmpty: opts == "omitempty"})
+	}
+	// fields of the outer struct hide promoted ones of the same name, and
+	// of two promoted fields the first wins
+	outer := map[string]bool{}
+	for _, sf := range all {
+		if len(sf.index) == 1 {
+			outer[sf.name] = true
+		}
+	}
+	var fields []structField
+	for _, sf := range all {
+		if len(sf.index) > 1 && (outer[sf.name] || promoted[sf.name]) {
+			continue
+		}
+		promoted[sf.name] = len(sf.index) > 1
+		fields = append(fields, sf)
+	}
+	structFieldCache.Store(t, fields)
+	return fields
+}
+
+// structType returns the struct type t or *t converts from as a list or
+// data frame row, false for non-structs and for the structs ToValue
+// treats as scalars (time.Time and the sql.Null types).
+func structType(t reflect.Type) (reflect.Type, bool) {
+	if t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	return t, t.Kind() == reflect.Struct && typeKind(t) == kindNone
+}
+
+// sqlNull reports whether t is one of the sql.Null types, which hold a
+// value in their first field and whether it is set in Valid.
+func sqlNull(t reflect.Type) bool {
+	return t.Kind() == reflect.Struct && t.PkgPath() == "database/sql" &&
+		t.NumField() == 2 && t.Field(1).Name == "Valid" && t.Field(1).Type.Kind() == reflect.Bool
+}
+
+// nullScalar returns the value held by the sql.Null rv, or an invalid
+// Value (NA) if it is not Valid.
+func nullScalar(rv reflect.Value) reflect.Value {
+	if !rv.Field(1).Bool() {
+		return reflect.Value{}
+	}
+	return rv.Field(0)
+}
+
+// isEmptyField reports whether a field tagged omitempty is left out.
+func isEmptyField(rv reflect.Value) bool {
+	switch rv.Kind() {
+	case reflect.Slice, reflect.Map:
+		return rv.Len() == 0
+	}
+	return rv.IsZero()
+}
+
+// structList converts the struct rv to a named list.
+func structList(rv reflect.Value) (Value, error) {
+	fields := structFields(rv.Type())
+	out := &ListVec{}
+	names := make([]string, 0, len(fields))
+	for _, f := range fields {
+		fv := rv.FieldByIndex(f.index)
+		if f.omitEmpty && isEmptyField(fv) {
+			continue
+		}
+		v, err := toValue(fv)
+		if err != nil {
+			return nil, fmt.Errorf("field %s: %w", f.name, err)
+		}
+		names = append(names, f.name)
+		out.Data = append(out.Data, v)
+	}
+	out.SetAttr("names", charVecOf(names))
+	return out, nil
+}
+
+// structFrame converts rows, structs of type st or pointers to them, to a
+// data frame. The fields of a nil row are NA.
+func structFrame(st reflect.Type, rows []reflect.Value) (Value, error) {
+	fields := structFields(st)
+	cols := make([]Value, len(fields))
+	names := make([]StringElem, len(fields))
+	cells := make([]reflect.Value, len(rows))
+	for j, f := range fields {
+		for i, r := range rows {
+			for r.Kind() == reflect.Pointer && !r.IsNil() {
+				r = r.Elem()
+			}
+			cells[i] = reflect.Value{}
+			if r.Kind() == reflect.Struct {
+				cells[i] = r.FieldByIndex(f.index)
+			}
+		}
+		var err error
+		if k := typeKind(st.FieldByIndex(f.index).Type); k != kindNone {
+			cols[j], err = atomicVector(k, cells)
+		} else {
+			col := &ListVec{Data: make([]Value, len(cells))}
+			for i, c := range cells {
+				if col.Data[i], err = toValue(c); err != nil {
+					break
+				}
+			}
+			cols[j] = col
+		}
+		if err != nil {
+			return nil, fmt.Errorf("column %s: %w", f.name, err)
+		}
+		names[j] = StringElem{Val: f.name}
+	}
+	rn := make([]IntElem, len(rows))
+	for i := range rn {
+		rn[i] = IntElem{Val: int64(i + 1)}
+	}
+	return newDataFrame(cols, names, &IntVec{Data: rn}), nil
+}
+
+// decodeStruct decodes the named list or vector v into the struct dst,
+// field by field. Fields without an element of their name keep their
+// value, and elements without a field are ignored.
+func decodeStruct(v Value, dst reflect.Value) error {
+	names := valueNames(v)
+	named := false
+	for _, n := range names {
+		named = named || n != ""
+	}
+	if !named && v.Len() > 0 {
+		return fmt.Errorf("cannot decode %s without names into %s", v.Type(), dst.Type())
+	}
+	for _, f := range structFields(dst.Type()) {
+		for i, n := range names {
+			if n != f.name {
+				continue
+			}
+			if err := decodeElem(v, i, dst.FieldByIndex(f.index)); err != nil {
+				return fmt.Errorf("field %s: %w", f.name, err)
+			}
+			break
+		}
+	}
+	return nil
+}
+
+// decodeRows decodes the data frame df into the slice of structs (or
+// pointers to structs) dst, one element per row.
+func decodeRows(df *ListVec, dst reflect.Value) error {
+	t := dst.Type()
+	st, _ := structType(t.Elem())
+	names := valueNames(df)
+	fields := structFields(st)
+	cols := make([]int, len(fields))
+	for j, f := range fields {
+		cols[j] = -1
+		for k, n := range names {
+			if n == f.name {
+				cols[j] = k
+				break
+			}
+		}
+	}
+	n := dfNRow(df)
+	s := reflect.MakeSlice(t, n, n)
+	for i := 0; i < n; i++ {
+		row := s.Index(i)
+		if row.Kind() == reflect.Pointer {
+			row.Set(reflect.New(st))
+			row = row.Elem()
+		}
+		for j, f := range fields {
+			if cols[j] < 0 {
+				continue
+			}
+			if err := decodeElem(df.Data[cols[j]], i, row.FieldByIndex(f.index)); err != nil {
+				return fmt.Errorf("row %d, column %s: %w", i+1, f.name, err)
+			}
+		}
+	}
+	dst.Set(s)
+	return nil
+}
@@ -0,0 +1,189 @@
+package rt
+
+import (
+	"database/sql"
+	"reflect"
+	"testing"
+	"time"
+)
+
+type marshalBase struct {
+	ID   int    `r:"id"`
+	Note string `r:"note,omitempty"`
+}
+
+type marshalPerson struct {
+	marshalBase
+	Name    string         `r:"name"`
+	Age     *int           `r:"age"`
+	Score   float64        `r:"score,omitempty"`
+	Email   sql.NullString `r:"email"`
+	Tags    []string       `r:"tags,omitempty"`
+	Secret  string         `r:"-"`
+	private int
+}
+
+func TestMarshalStruct(t *testing.T) {
+	age := 42
+	tests := []struct {
+		in       any
+		expected string
+	}{
+		{marshalPerson{Name: "Ann", Age: &age}, `list(id = 0L, name = "Ann", age = 42L, email = NA_character_)`},
+		{marshalPerson{marshalBase: marshalBase{ID: 7, Note: "x"}, Name: "Bo", Email: sql.NullString{String: "b@x.org", Valid: true}},
+			`list(id = 7L, note = "x", name = "Bo", age = NA_integer_, email = "b@x.org")`},
+		{struct{ X, Y int }{1, 2}, "list(X = 1L, Y = 2L)"},
+		{&struct{ N sql.NullInt64 }{}, "list(N = NA_integer_)"},
+		{sql.NullFloat64{Float64: 2.5, Valid: true}, "2.5"},
+		{[]sql.NullBool{{Bool: true, Valid: true}, {}}, "c(TRUE, NA)"},
+		{map[string]struct{ A int }{"k": {1}}, "list(k = list(A = 1L))"},
+	}
+	for _, tt := range tests {
+		v, err := ToValue(tt.in)
+		if err != nil {
+			t.Errorf("ToValue(%#v): %v", tt.in, err)
+			continue
+		}
+		if got := deparseValue(v); got != tt.expected {
+			t.Errorf("ToValue(%#v): expected %s, got %s", tt.in, tt.expected, got)
+		}
+	}
+}
+
+func TestMarshalDataFrame(t *testing.T) {
+	age := 30
+	rows := []*marshalPerson{
+		{marshalBase: marshalBase{ID: 1}, Name: "Ann", Age: &age, Score: 2},
+		nil,
+		{marshalBase: marshalBase{ID: 3, Note: "n"}, Name: "Cy", Score: 4, Tags: []string{"t"}},
+	}
+	ctx := NewContext()
+	if err := ctx.Set("d", rows); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		src      string
+		expected string
+	}{
+		{"class(d)", `"data.frame"`},
+		{"dim(d)", "c(3L, 7L)"},
+		{"names(d)", `c("id", "note", "name", "age", "score", "email", "tags")`},
+		{"d$age", "c(30L, NA, NA)"},
+		{"mean(d$score, na.rm = TRUE)", "3"},
+		{"d$note", `c("", NA, "n")`},
+		{"d$tags[[3]]", `"t"`},
+	}
+	for _, tt := range tests {
+		res, err := ctx.EvalString(tt.src)
+		if err != nil {
+			t.Errorf("%s: %v", tt.src, err)
+			continue
+		}
+		if got := deparseValue(res.Value); got != tt.expected {
+			t.Errorf("%s: expected %s, got %s", tt.src, tt.expected, got)
+		}
+	}
+
+	var empty []marshalBase
+	v, err := ToValue(empty)
+	if err != nil || !isDataFrame(v) || dfNRow(v.(*ListVec)) != 0 {
+		t.Errorf("empty slice: expected a data frame without rows, got %v, %v", v, err)
+	}
+
+	// without rows the columns still have the types of the fields
+	if err := ctx.Set("e", []*marshalPerson{}); err != nil {
+		t.Fatal(err)
+	}
+	tests = []struct {
+		src      string
+		expected string
+	}{
+		{"dim(e)", "c(0L, 7L)"},
+		{"names(e)", `c("id", "note", "name", "age", "score", "email", "tags")`},
+		{`paste(sapply(e, typeof), collapse = " ")`, `"integer character character integer double character list"`},
+		{"e$score", "numeric(0)"},
+		{"dim(e[e$score > 1, ])", "c(0L, 7L)"},
+	}
+	for _, tt := range tests {
+		res, err := ctx.EvalString(tt.src)
+		if err != nil {
+			t.Errorf("%s: %v", tt.src, err)
+			continue
+		}
+		if got := deparseValue(res.Value); got != tt.expected {
+			t.Errorf("%s: expected %s, got %s", tt.src, tt.expected, got)
+		}
+	}
+	res, err := ctx.EvalString("e[e$score > 1, ]")
+	if err != nil {
+		t.Fatal(err)
+	}
+	back := []marshalPerson{{Name: "stale"}}
+	if err := Decode(res.Value, &back); err != nil || len(back) != 0 {
+		t.Errorf("empty round trip: got %+v, %v", back, err)
+	}
+}
+
+func TestUnmarshal(t *testing.T) {
+	ctx := NewContext()
+	eval := func(src string) Value {
+		res, err := ctx.EvalString(src)
+		if err != nil {
+			t.Fatalf("%s: %v", src, err)
+		}
+		return res.Value
+	}
+
+	var p marshalPerson
+	err := Decode(eval(`list(id = 5, name = "Di", age = NA, email = "d@example.org", tags = c("x", "y"), extra = TRUE)`), &p)
+	expected := marshalPerson{marshalBase: marshalBase{ID: 5}, Name: "Di", Email: sql.NullString{String: "d@example.org", Valid: true}, Tags: []string{"x", "y"}}
+	if err != nil || !reflect.DeepEqual(p, expected) {
+		t.Errorf("struct: got %+v, %v", p, err)
+	}
+
+	type row struct {
+		Name  string          `r:"name"`
+		Group string          `r:"group"`
+		Value sql.NullFloat64 `r:"value"`
+		When  *time.Time      `r:"when"`
+	}
+	var rows []row
+	err = Decode(eval(`data.frame(name = c("a", "b"), group = factor(c("g1", "g2")), value = c(1.5, NA))`), &rows)
+	expectedRows := []row{
+		{Name: "a", Group: "g1", Value: sql.NullFloat64{Float64: 1.5, Valid: true}},
+		{Name: "b", Group: "g2"},
+	}
+	if err != nil || !reflect.DeepEqual(rows, expectedRows) {
+		t.Errorf("data frame: got %+v, %v", rows, err)
+	}
+	var ptrs []*row
+	if err := Decode(eval(`data.frame(name = "z")`), &ptrs); err != nil || len(ptrs) != 1 || ptrs[0].Name != "z" {
+		t.Errorf("data frame into []*row: got %v, %v", ptrs, err)
+	}
+
+	// round trip through R
+	in := []marshalBase{{ID: 1, Note: "a"}, {ID: 2}}
+	if err := ctx.Set("b", in); err != nil {
+		t.Fatal(err)
+	}
+	var out []marshalBase
+	if err := Decode(eval("b[order(-b$id), ]"), &out); err != nil || !reflect.DeepEqual(out, []marshalBase{in[1], in[0]}) {
+		t.Errorf("round trip: got %+v, %v", out, err)
+	}
+
+	errs := []struct {
+		src      string
+		dst      any
+		expected string
+	}{
+		{"list(1, 2)", new(marshalBase), "cannot decode list without names into rt.marshalBase"},
+		{`list(id = "x")`, new(marshalBase), "field id: cannot decode x into int"},
+		{"data.frame(id = c(1, NA))", new([]marshalBase), "row 2, column id: cannot decode NA into int"},
+	}
+	for _, tt := range errs {
+		err := Decode(eval(tt.src), tt.dst)
+		if err == nil || err.Error() != tt.expected {
+			t.Errorf("Decode(%s, %T): expected %q, got %v", tt.src, tt.dst, tt.expected, err)
+		}
+	}
+}
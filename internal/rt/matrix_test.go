@@ -0,0 +1,136 @@
+package rt
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestMatrixBuiltins(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"dim(matrix(1:6, nrow = 2))", "2 3"},
+		{"m <- matrix(1:6, nrow = 2); m[2, 3]", "6"},
+		{"m <- matrix(1:6, nrow = 2); m[, 2]", "3 4"},
+		{"m <- matrix(1:6, 2, byrow = TRUE); m[1, ]", "1 2 3"},
+		{"m <- matrix(1:6, nrow = 2); dim(m[1, , drop = FALSE])", "1 3"},
+		{"m <- matrix(1:6, nrow = 2); m[1, 2] <- 100; as.vector(m)", "1 2 100 4 5 6"},
+		{"m <- matrix(1:6, nrow = 2); m[[2, 2]]", "4"},
+		{"dim(t(matrix(1:6, nrow = 2)))", "3 2"},
+		{"as.vector(t(matrix(1:6, nrow = 2)))", "1 3 5 2 4 6"},
+		{"x <- 1:6; dim(x) <- c(3, 2); nrow(x)", "3"},
+		{"x <- 1:6; dim(x) <- c(3, 2); colnames(x) <- c(\"a\", \"b\"); x[, \"b\"]", "4 5 6"},
+		{"dim(cbind(1:3, 4:6))", "3 2"},
+		{"r <- rbind(a = 1:3, b = 4:6); r[\"b\", ]", "4 5 6"},
+		{"rownames(rbind(a = 1:3, b = 4:6))", `"a" "b"`},
+		{`rownames(matrix(1:4, 2, dimnames = list(c("a", "b"), c("x", "y"))))`, `"a" "b"`},
+		{`colnames(matrix(1:4, 2, dimnames = list(c("a", "b"), c("x", "y"))))`, `"x" "y"`},
+		{`matrix(1:4, 2, dimnames = list(NULL, c("x", "y")))[, "y"]`, "3 4"},
+		{"as.vector(matrix(1:4, 2) %*% matrix(1:4, 2))", "7 10 15 22"},
+		{"1:3 %*% 1:3", "14"},
+		{"as.vector(crossprod(cbind(1, 1:4)))", "4 10 10 30"},
+		{"as.vector(outer(1:2, 1:3))", "1 2 2 4 3 6"},
+		{"as.vector(outer(1:2, 1:2, \"+\"))", "2 3 3 4"},
+		{"as.vector(diag(2))", "1 0 0 1"},
+		{"diag(matrix(1:9, 3))", "1 5 9"},
+		{"det(matrix(c(2, 1, 1, 3), 2))", "5"},
+		{"as.vector(solve(matrix(c(2, 1, 1, 3), 2)))", "0.6 -0.2 -0.2 0.4"},
+		{"solve(matrix(c(2, 0, 0, 4), 2), c(2, 2))", "1 0.5"},
+		{"colSums(matrix(1:6, 2))", "3 7 11"},
+		{"rowMeans(matrix(1:6, 2))", "3 4"},
+		{"dim(matrix(1:6, 2) * 2)", "2 3"},
+		{"a <- array(1:24, dim = c(2, 3, 4)); a[2, 3, 4]", "24"},
+		{"is.matrix(matrix(1:4, 2))", "TRUE"},
+		{"m <- matrix(1:12, 3); m[cbind(c(1, 3, 2), c(4, 1, 2))]", "10 3 5"},
+		{"m <- matrix(1:12, 3); m[cbind(c(1, NA, 0), c(2, 1, 3))]", "4 NA"},
+		{"m <- matrix(1:12, 3); m[matrix(1:4, 2)]", "7 11"},
+		{"a <- array(1:24, c(2, 3, 4)); a[rbind(c(2, 3, 4), c(1, 1, 1))]", "24 1"},
+		{"m <- matrix(1:9, 3); m[cbind(1:3, 1:3)] <- 0L; as.vector(m)", "0 2 3 4 0 6 7 8 0"},
+		{"m <- matrix(1:6, 2); m[c(TRUE, FALSE)]", "1 3 5"},
+		{"x <- 1:6; x[cbind(1, 2)]", "1 2"},
+		// attributes are set on a copy, also for functions
+		{"f <- function() 1; g <- f; attr(g, \"x\") <- 2; c(is.null(attr(f, \"x\")), attr(g, \"x\"))", "1 2"},
+		{"s <- sum; attr(s, \"x\") <- 2; c(is.null(attr(sum, \"x\")), s(1:3))", "1 6"},
+	}
+
+	for _, tt := range tests {
+		ctx := NewContext()
+		res, err := ctx.EvalString(tt.input)
+		if err != nil {
+			t.Errorf("input %q: unexpected error: %v", tt.input, err)
+			continue
+		}
+		if res.Value.String() != tt.expected {
+			t.Errorf("input %q: expected %s, got %s", tt.input, tt.expected, res.Value.String())
+		}
+	}
+}
+
+func TestMatrixErrors(t *testing.T) {
+	tests := []struct {
+		input string
+		err   string
+	}{
+		{"matrix(1:4, 2) %*% matrix(1:6, 3)", "non-conformable"},
+		{"solve(matrix(c(1, 2, 2, 4), 2))", "singular"},
+		{"x <- 1:5; dim(x) <- c(2, 3)", "do not match"},
+		{"m <- matrix(1:4, 2); m[3, 1]", "subscript out of bounds"},
+		{"m <- matrix(1:4, 2); m[cbind(3, 1)]", "subscript out of bounds"},
+		{"m <- matrix(1:4, 2); m[cbind(-1, 1)]", "negative values are not allowed in a matrix subscript"},
+	}
+
+	for _, tt := range tests {
+		ctx := NewContext()
+		_, err := ctx.EvalString(tt.input)
+		if err == nil || !strings.Contains(err.Error(), tt.err) {
+			t.Errorf("input %q: expected error containing %q, got %v", tt.input, tt.err, err)
+		}
+	}
+}
+
+func TestMatrixRecycling(t *testing.T) {
+	tests := []struct {
+		input   string
+		warning string
+	}{
+		{"matrix(1:6, 4)", "data length [6] is not a sub-multiple or multiple of the number of rows [4]"},
+		{"matrix(1:6, ncol = 4)", "data length [6] is not a sub-multiple or multiple of the number of columns [4]"},
+		{"matrix(1:6, 2, 2)", "data length differs from size of matrix: [6 != 2 x 2]"},
+		{"matrix(1:2, 0, 3)", "data length exceeds size of matrix"},
+		{"matrix(1:3, 2, 3)", ""},
+		{"matrix(1:6, 2)", ""},
+		{"matrix(0, 2, 3)", ""},
+	}
+	for _, tt := range tests {
+		ctx := NewContext()
+		res, err := ctx.EvalString(`tryCatch({ ` + tt.input + `; "" }, warning = function(w) conditionMessage(w))`)
+		if err != nil {
+			t.Errorf("input %q: unexpected error: %v", tt.input, err)
+			continue
+		}
+		if got := res.Value.(*CharVec).Data[0].Val; got != tt.warning {
+			t.Errorf("input %q: expected warning %q, got %q", tt.input, tt.warning, got)
+		}
+	}
+}
+
+func TestMatrixPrint(t *testing.T) {
+	ctx := NewContext()
+	res, err := ctx.EvalString("print(matrix(1:6, nrow = 2))")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := "     [,1] [,2] [,3]\n[1,]    1    3    5\n[2,]    2    4    6\n"
+	if res.Output != expected {
+		t.Errorf("expected output %q, got %q", expected, res.Output)
+	}
+	res, err = ctx.EvalString(`print(matrix(1:4, 2, dimnames = list(c("a", "b"), c("x", "y"))))`)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected = "  x y\na 1 3\nb 2 4\n"
+	if res.Output != expected {
+		t.Errorf("dimnames: expected output %q, got %q", expected, res.Output)
+	}
+}
@@ -0,0 +1,1706 @@
+package rt
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	"simonwaldherr.de/go/smallr/internal/ast"
+	"simonwaldherr.de/go/smallr/internal/deparse"
+)
+
+func installModelBuiltins(env *Env) {
+	builtins := map[string]*BuiltinFunc{
+		"lm":            {FnName: "lm", Impl: builtinLm},
+		"coef":          {FnName: "coef", Impl: builtinCoef, Generic: true},
+		"coefficients":  {FnName: "coefficients", Impl: builtinCoef, Generic: true},
+		"residuals":     {FnName: "residuals", Impl: builtinResiduals, Generic: true},
+		"resid":         {FnName: "resid", Impl: builtinResiduals, Generic: true},
+		"fitted":        {FnName: "fitted", Impl: builtinFitted, Generic: true},
+		"fitted.values": {FnName: "fitted.values", Impl: builtinFitted, Generic: true},
+		"predict":       {FnName: "predict", Impl: builtinPredict, Generic: true},
+		"confint":       {FnName: "confint", Impl: builtinConfint, Generic: true},
+		"vcov":          {FnName: "vcov", Impl: builtinVcov, Generic: true},
+		"deviance":      {FnName: "deviance", Impl: builtinDeviance, Generic: true},
+		"df.residual":   {FnName: "df.residual", Impl: builtinDfResidual, Generic: true},
+		"anova":         {FnName: "anova", Impl: builtinAnova, Generic: true},
+		"AIC":           {FnName: "AIC", Impl: builtinAIC, Generic: true},
+	}
+	for name, fn := range builtins {
+		env.SetLocal(name, fn)
+	}
+}
+
+// --- QR decomposition ---
+
+// qrTolerance is the tolerance lm() uses to detect linearly dependent
+// columns.
+const qrTolerance = 1e-7
+
+// qrDecomp is a Householder QR decomposition of an n x p matrix, stored
+// column-major in the LINPACK manner: R on and above the diagonal, the
+// Householder vectors below it, with their leading elements in qraux.
+// Columns that are (nearly) linear combinations of earlier ones are moved
+// to the end, so that the first rank columns of pivot are the estimable
+// ones, in their original order.
+type qrDecomp struct {
+	qr    []float64
+	qraux []float64
+	pivot []int // original column of each column, 0-based
+	rank  int
+	n, p  int
+}
+
+// qrDecompose factors the column-major n x p matrix x. A column whose
+// norm, after the reflections of the columns before it, has fallen below
+// tol times its original norm is treated as dependent.
+func qrDecompose(x []float64, n, p int, tol float64) *qrDecomp {
+	q := &qrDecomp{qr: append([]float64(nil), x...), qraux: make([]float64, p), pivot: make([]int, p), n: n, p: p}
+	norms := make([]float64, p)
+	for j := range q.pivot {
+		q.pivot[j] = j
+		norms[j] = q.colNorm(j, 0)
+	}
+	limit := p
+	k := 0
+	for k < limit && k < n {
+		nrm := q.colNorm(k, k)
+		if nrm == 0 || nrm < tol*norms[q.pivot[k]] {
+			q.moveToEnd(k)
+			limit--
+			continue
+		}
+		col := q.qr[k*n : (k+1)*n]
+		alpha := -nrm
+		if col[k] < 0 {
+			alpha = nrm
+		}
+		col[k] -= alpha
+		q.qraux[k] = col[k]
+		for j := k + 1; j < p; j++ {
+			q.reflect(k, q.qr[j*n:(j+1)*n])
+		}
+		col[k] = alpha
+		k++
+	}
+	q.rank = k
+	return q
+}
+
+// colNorm returns the Euclidean norm of column j from row start on.
+func (q *qrDecomp) colNorm(j, start int) float64 {
+	var ss float64
+	for _, v := range q.qr[j*q.n+start : (j+1)*q.n] {
+		ss += v * v
+	}
+	return math.Sqrt(ss)
+}
+
+// moveToEnd rotates column k to the last position.
+func (q *qrDecomp) moveToEnd(k int) {
+	n := q.n
+	col := append([]float64(nil), q.qr[k*n:(k+1)*n]...)
+	copy(q.qr[k*n:], q.qr[(k+1)*n:])
+	copy(q.qr[(q.p-1)*n:], col)
+	piv := q.pivot[k]
+	copy(q.pivot[k:], q.pivot[k+1:])
+	q.pivot[q.p-1] = piv
+}
+
+// reflect applies the k-th Householder reflection to y in place.
+func (q *qrDecomp) reflect(k int, y []float64) {
+	n := q.n
+	v0 := q.qraux[k]
+	vv, s := v0*v0, v0*y[k]
+	for i := k + 1; i < n; i++ {
+		v := q.qr[k*n+i]
+		vv += v * v
+		s += v * y[i]
+	}
+	if vv == 0 {
+		return
+	}
+	f := 2 * s / vv
+	y[k] -= f * v0
+	for i := k + 1; i < n; i++ {
+		y[i] -= f * q.qr[k*n+i]
+	}
+}
+
+// qty returns Q'y.
+func (q *qrDecomp) qty(y []float64) []float64 {
+	out := append([]float64(nil), y...)
+	for k := 0; k < q.rank; k++ {
+		q.reflect(k, out)
+	}
+	return out
+}
+
+// r returns element (i, j) of R.
+func (q *qrDecomp) r(i, j int) float64 { return q.qr[j*q.n+i] }
+
+// coef returns the least-squares coefficients for y in the original
+// column order; those of dependent columns are NA.
+func (q *qrDecomp) coef(y []float64) []FloatElem {
+	qty := q.qty(y)
+	b := make([]float64, q.rank)
+	for i := q.rank - 1; i >= 0; i-- {
+		s := qty[i]
+		for j := i + 1; j < q.rank; j++ {
+			s -= q.r(i, j) * b[j]
+		}
+		b[i] = s / q.r(i, i)
+	}
+	out := make([]FloatElem, q.p)
+	for j := range out {
+		if j < q.rank {
+			out[q.pivot[j]] = FloatElem{Val: b[j]}
+		} else {
+			out[q.pivot[j]] = FloatElem{NA: true}
+		}
+	}
+	return out
+}
+
+// unscaledCov returns (R'R)^-1 for the estimable columns, a rank x rank
+// matrix in pivot order.
+func (q *qrDecomp) unscaledCov() []float64 {
+	k := q.rank
+	rinv := make([]float64, k*k)
+	for j := 0; j < k; j++ {
+		rinv[j*k+j] = 1 / q.r(j, j)
+		for i := j - 1; i >= 0; i-- {
+			var s float64
+			for l := i + 1; l <= j; l++ {
+				s += q.r(i, l) * rinv[j*k+l]
+			}
+			rinv[j*k+i] = -s / q.r(i, i)
+		}
+	}
+	cov := make([]float64, k*k)
+	for i := 0; i < k; i++ {
+		for j := 0; j < k; j++ {
+			var s float64
+			for l := max(i, j); l < k; l++ {
+				s += rinv[l*k+i] * rinv[l*k+j]
+			}
+			cov[j*k+i] = s
+		}
+	}
+	return cov
+}
+
+// value returns the decomposition as a list of class "qr", as the qr
+// element of a fitted model keeps it.
+func (q *qrDecomp) value(rowNames, colNames []string) Value {
+	data := make([]FloatElem, len(q.qr))
+	for i, v := range q.qr {
+		data[i].Val = v
+	}
+	m := newDoubleMatrix(data, q.n, q.p)
+	pivNames := make([]string, q.p)
+	pivot := make([]int, q.p)
+	for j, c := range q.pivot {
+		pivNames[j] = colNames[c]
+		pivot[j] = c
+	}
+	setDimNames(m, rowNames, pivNames)
+	out := &ListVec{Data: []Value{m, doubleVecOf(q.qraux), positionsToIndex(pivot), DoubleScalar(qrTolerance), IntScalar(int64(q.rank))}}
+	out.SetAttr("names", charVecOf([]string{"qr", "qraux", "pivot", "tol", "rank"}))
+	out.SetAttr("class", CharScalar("qr"))
+	return out
+}
+
+// qrFromValue reads back a decomposition written by value.
+func qrFromValue(ctx *Context, v Value) (*qrDecomp, error) {
+	m := listElem(v, "qr")
+	n, p, ok := matrixDims(m)
+	if !ok {
+		return nil, fmt.Errorf("invalid 'qr' argument")
+	}
+	x, err := asDoubleVec(ctx, m)
+	if err != nil {
+		return nil, err
+	}
+	q := &qrDecomp{qr: make([]float64, len(x)), n: n, p: p}
+	for i, e := range x {
+		q.qr[i] = e.Val
+	}
+	for _, e := range toFloats(listElem(v, "qraux")) {
+		q.qraux = append(q.qraux, e)
+	}
+	for _, e := range toFloats(listElem(v, "pivot")) {
+		q.pivot = append(q.pivot, int(e)-1)
+	}
+	rank := toFloats(listElem(v, "rank"))
+	if len(q.qraux) != p || len(q.pivot) != p || len(rank) != 1 {
+		return nil, fmt.Errorf("invalid 'qr' argument")
+	}
+	q.rank = int(rank[0])
+	return q, nil
+}
+
+// --- helpers ---
+
+// listElem returns the element of the list v named name, or nil.
+func listElem(v Value, name string) Value {
+	l, ok := v.(*ListVec)
+	if !ok {
+		return nil
+	}
+	names, _ := listNames(l)
+	for i, n := range names {
+		if n == name && i < len(l.Data) {
+			return l.Data[i]
+		}
+	}
+	return nil
+}
+
+// namedList builds a list from alternating names and values; nil values
+// are left out.
+func namedList(pairs ...any) *ListVec {
+	out := &ListVec{}
+	var names []string
+	for i := 0; i+1 < len(pairs); i += 2 {
+		v, _ := pairs[i+1].(Value)
+		if v == nil {
+			continue
+		}
+		names = append(names, pairs[i].(string))
+		out.Data = append(out.Data, v)
+	}
+	out.SetAttr("names", charVecOf(names))
+	return out
+}
+
+// toFloats returns the numeric elements of v, NA as NaN. It is meant for
+// values built by this package, which are known to be numeric.
+func toFloats(v Value) []float64 {
+	switch t := v.(type) {
+	case *DoubleVec:
+		out := make([]float64, len(t.Data))
+		for i, e := range t.Data {
+			out[i] = e.Val
+			if e.NA {
+				out[i] = math.NaN()
+			}
+		}
+		return out
+	case *IntVec:
+		out := make([]float64, len(t.Data))
+		for i, e := range t.Data {
+			out[i] = float64(e.Val)
+			if e.NA {
+				out[i] = math.NaN()
+			}
+		}
+		return out
+	case *LogicalVec:
+		out := make([]float64, len(t.Data))
+		for i, e := range t.Data {
+			out[i] = float64(boolInt(e.Val))
+			if e.NA {
+				out[i] = math.NaN()
+			}
+		}
+		return out
+	}
+	return nil
+}
+
+func doubleVecOf(x []float64) *DoubleVec {
+	data := make([]FloatElem, len(x))
+	for i, v := range x {
+		data[i].Val = v
+	}
+	return &DoubleVec{Data: data}
+}
+
+func namedDoubles(x []float64, names []string) *DoubleVec {
+	v := doubleVecOf(x)
+	v.SetAttr("names", charVecOf(names))
+	return v
+}
+
+// modelCall builds the call a fitted model records, such as
+// lm(formula = y ~ x, data = df), from the unevaluated arguments.
+func modelCall(name string, formals []string, m []Value) *ExprValue {
+	var args []ast.Arg
+	for i, v := range m {
+		if v != nil {
+			args = append(args, ast.Arg{Name: formals[i], Value: promiseExpr(v)})
+		}
+	}
+	return &ExprValue{Expr: &ast.CallExpr{Fun: &ast.Ident{Name: name}, Args: args}}
+}
+
+// fitFrame evaluates the model frame for a model fitting function: it
+// forces formula, data and na.action, and evaluates subset and weights
+// in the data.
+func fitFrame(ctx *Context, formula, data, subset, weights, naAction Value) (*modelTerms, *ListVec, error) {
+	if formula == nil {
+		return nil, nil, fmt.Errorf("argument \"formula\" is missing, with no default")
+	}
+	opts := frameOpts{subset: promiseExpr(subset), weights: promiseExpr(weights), env: ctx.callingEnv()}
+	f, err := Force(ctx, formula)
+	if err != nil {
+		return nil, nil, err
+	}
+	if data != nil {
+		if data, err = Force(ctx, data); err != nil {
+			return nil, nil, err
+		}
+	}
+	if naAction != nil {
+		if naAction, err = Force(ctx, naAction); err != nil {
+			return nil, nil, err
+		}
+	}
+	if opts.naAction, err = naActionName(naAction); err != nil {
+		return nil, nil, err
+	}
+	mt, err := termsArg(ctx, f, data)
+	if err != nil {
+		return nil, nil, err
+	}
+	mf, err := modelFrame(ctx, mt, data, opts)
+	if err != nil {
+		return nil, nil, err
+	}
+	return mt, mf, nil
+}
+
+// modelWeights returns the "(weights)" column of a model frame, or nil.
+func modelWeights(ctx *Context, mt *modelTerms, mf *ListVec) ([]float64, error) {
+	if len(mf.Data) <= len(mt.vars) {
+		return nil, nil
+	}
+	w, err := asDoubleVec(ctx, mf.Data[len(mt.vars)])
+	if err != nil {
+		return nil, fmt.Errorf("'weights' must be a numeric vector")
+	}
+	out := make([]float64, len(w))
+	for i, e := range w {
+		if e.NA || e.Val < 0 {
+			return nil, fmt.Errorf("missing or negative weights not allowed")
+		}
+		out[i] = e.Val
+	}
+	return out, nil
+}
+
+// finiteFloats converts v for a model fit; what names the argument in
+// the error for non-finite values.
+func finiteFloats(ctx *Context, v Value, what string) ([]float64, error) {
+	fv, err := asDoubleVec(ctx, v)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]float64, len(fv))
+	for i, e := range fv {
+		if e.NA || math.IsNaN(e.Val) || math.IsInf(e.Val, 0) {
+			return nil, fmt.Errorf("NA/NaN/Inf in '%s'", what)
+		}
+		out[i] = e.Val
+	}
+	return out, nil
+}
+
+// --- lm ---
+
+// lsFit is a weighted least-squares fit.
+type lsFit struct {
+	coef      []FloatElem // in column order, NA for dependent columns
+	fitted    []float64
+	residuals []float64
+	effects   []float64 // Q'y of the weighted response
+	qr        *qrDecomp
+	nobs      int // observations with non-zero weight
+}
+
+// fitLeastSquares fits y on the column-major n x p matrix x, with weights
+// w (nil for none), treating columns as dependent by tolerance tol.
+// Observations with zero weight do not take part in the fit, but get
+// fitted values and residuals.
+func fitLeastSquares(x []float64, n, p int, y, w []float64, tol float64) (*lsFit, error) {
+	rows := make([]int, 0, n)
+	for i := 0; i < n; i++ {
+		if w == nil || w[i] > 0 {
+			rows = append(rows, i)
+		}
+	}
+	if len(rows) == 0 {
+		return nil, fmt.Errorf("0 (non-NA) cases")
+	}
+	m := len(rows)
+	xs := make([]float64, m*p)
+	ys := make([]float64, m)
+	for k, i := range rows {
+		sw := 1.0
+		if w != nil {
+			sw = math.Sqrt(w[i])
+		}
+		ys[k] = sw * y[i]
+		for j := 0; j < p; j++ {
+			xs[j*m+k] = sw * x[j*n+i]
+		}
+	}
+	q := qrDecompose(xs, m, p, tol)
+	fit := &lsFit{coef: q.coef(ys), effects: q.qty(ys), qr: q, nobs: m}
+	fit.fitted = make([]float64, n)
+	fit.residuals = make([]float64, n)
+	for i := 0; i < n; i++ {
+		var s float64
+		for j, b := range fit.coef {
+			if !b.NA {
+				s += x[j*n+i] * b.Val
+			}
+		}
+		fit.fitted[i] = s
+		fit.residuals[i] = y[i] - s
+	}
+	return fit, nil
+}
+
+// builtinLm implements lm(formula, data, subset, weights, na.action): the
+// least-squares fit of the model, by a QR decomposition of the model
+// matrix. It returns an object of class "lm".
+func builtinLm(ctx *Context, args []ArgValue) (Value, error) {
+	formals := []string{"formula", "data", "subset", "weights", "na.action"}
+	m, _ := matchArgs(args, formals...)
+	call := modelCall("lm", formals, m)
+	mt, mf, err := fitFrame(ctx, m[0], m[1], m[2], m[3], m[4])
+	if err != nil {
+		return nil, err
+	}
+	if !mt.response {
+		return nil, fmt.Errorf("lm() needs a response: the formula has no left-hand side")
+	}
+	if getDims(mf.Data[0]) != nil {
+		return nil, fmt.Errorf("multiple responses are not supported")
+	}
+	y, err := finiteFloats(ctx, mf.Data[0], "y")
+	if err != nil {
+		return nil, err
+	}
+	w, err := modelWeights(ctx, mt, mf)
+	if err != nil {
+		return nil, err
+	}
+	mm, err := modelMatrix(ctx, mt, mf)
+	if err != nil {
+		return nil, err
+	}
+	x, err := finiteFloats(ctx, mm, "x")
+	if err != nil {
+		return nil, err
+	}
+	n, p, _ := matrixDims(mm)
+	fit, err := fitLeastSquares(x, n, p, y, w, qrTolerance)
+	if err != nil {
+		return nil, err
+	}
+	rowNames, colNames := dfRowNames(mf), dimNamesAt(mm, 1)
+	return lmValue(ctx, fit, lmParts{
+		call: call, mt: mt, mf: mf, mm: mm, weights: w, rowNames: rowNames, colNames: colNames,
+	}, "lm")
+}
+
+// lmParts are the pieces of a fitted model besides the fit itself.
+type lmParts struct {
+	call     *ExprValue
+	mt       *modelTerms
+	mf       *ListVec
+	mm       *DoubleVec
+	weights  []float64
+	rowNames []string
+	colNames []string
+}
+
+// lmValue assembles the list a model fit returns, with the elements of
+// R's lm objects in R's order.
+func lmValue(ctx *Context, fit *lsFit, parts lmParts, class ...string) (*ListVec, error) {
+	coef := &DoubleVec{Data: fit.coef}
+	coef.SetAttr("names", charVecOf(parts.colNames))
+	var weights Value
+	if parts.weights != nil {
+		weights = doubleVecOf(parts.weights)
+	}
+	assign, _ := parts.mm.GetAttr("assign")
+	contrasts, _ := parts.mm.GetAttr("contrasts")
+	naAction, _ := parts.mf.GetAttr("na.action")
+	xl, err := xlevelsValue(ctx, parts.mt, parts.mf)
+	if err != nil {
+		return nil, err
+	}
+	out := namedList(
+		"coefficients", coef,
+		"residuals", namedDoubles(fit.residuals, parts.rowNames),
+		"effects", fit.effectsValue(parts.colNames),
+		"rank", IntScalar(int64(fit.qr.rank)),
+		"fitted.values", namedDoubles(fit.fitted, parts.rowNames),
+		"weights", weights,
+		"assign", assign,
+		"qr", fit.qr.value(fittedRows(parts.rowNames, parts.weights), parts.colNames),
+		"df.residual", IntScalar(int64(fit.nobs-fit.qr.rank)),
+		"na.action", naAction,
+		"contrasts", contrasts,
+		"xlevels", xl,
+		"call", parts.call,
+		"terms", parts.mf.Attrs()["terms"],
+		"model", parts.mf,
+	)
+	out.SetAttr("class", charVecOf(class))
+	return out, nil
+}
+
+// effectsValue returns the effects of the fit, named by the columns they
+// belong to for the first rank of them.
+func (fit *lsFit) effectsValue(colNames []string) Value {
+	names := make([]string, len(fit.effects))
+	for j := 0; j < fit.qr.rank; j++ {
+		names[j] = colNames[fit.qr.pivot[j]]
+	}
+	return namedDoubles(fit.effects, names)
+}
+
+// fittedRows returns the names of the rows with non-zero weight, which
+// are the rows of the qr decomposition.
+func fittedRows(rowNames []string, w []float64) []string {
+	if w == nil {
+		return rowNames
+	}
+	var out []string
+	for i, name := range rowNames {
+		if w[i] > 0 {
+			out = append(out, name)
+		}
+	}
+	return out
+}
+
+// xlevelsValue returns the levels of the factors in a model frame, as
+// the xlevels element of a fit.
+func xlevelsValue(ctx *Context, mt *modelTerms, mf *ListVec) (Value, error) {
+	levels, levelNames, err := modelLevels(ctx, mt, mf)
+	if err != nil {
+		return nil, err
+	}
+	xlevels := make([]Value, len(levelNames))
+	for i, name := range levelNames {
+		xlevels[i] = charVecOf(levels[name])
+	}
+	xl := &ListVec{Data: xlevels}
+	xl.SetAttr("names", charVecOf(levelNames))
+	return xl, nil
+}
+
+// fittedLm is what summary, predict and confint read from an lm or glm
+// object. For a glm the residuals and weights are the working ones of
+// the last iteration.
+type fittedLm struct {
+	obj       *ListVec
+	coef      []FloatElem
+	names     []string
+	qr        *qrDecomp
+	residuals []float64
+	fitted    []float64
+	weights   []float64 // nil when unweighted
+	rdf       int
+	intercept bool
+	family    *glmFamily // nil for lm
+}
+
+func lmFromValue(ctx *Context, v Value) (*fittedLm, error) {
+	obj, ok := v.(*ListVec)
+	if !ok || !hasClass(v, "lm") {
+		return nil, fmt.Errorf("object is not an \"lm\" fit")
+	}
+	fl := &fittedLm{obj: obj}
+	coef, ok := listElem(v, "coefficients").(*DoubleVec)
+	if !ok {
+		return nil, fmt.Errorf("invalid \"lm\" object: no 'coefficients'")
+	}
+	fl.coef, fl.names = coef.Data, valueNames(coef)
+	var err error
+	if fl.qr, err = qrFromValue(ctx, listElem(v, "qr")); err != nil {
+		return nil, err
+	}
+	if len(fl.coef) != fl.qr.p {
+		return nil, fmt.Errorf("invalid \"lm\" object")
+	}
+	fl.residuals = toFloats(listElem(v, "residuals"))
+	fl.fitted = toFloats(listElem(v, "fitted.values"))
+	fl.weights = toFloats(listElem(v, "weights"))
+	if rdf := toFloats(listElem(v, "df.residual")); len(rdf) == 1 {
+		fl.rdf = int(rdf[0])
+	}
+	if t := listElem(v, "terms"); t != nil {
+		icpt, _ := t.GetAttr("intercept")
+		fl.intercept = len(toFloats(icpt)) == 1 && toFloats(icpt)[0] == 1
+	}
+	if hasClass(v, "glm") {
+		if fl.family, err = familyFromValue(listElem(v, "family")); err != nil {
+			return nil, err
+		}
+	}
+	return fl, nil
+}
+
+// weight returns the weight of observation i.
+func (fl *fittedLm) weight(i int) float64 {
+	if fl.weights == nil {
+		return 1
+	}
+	return fl.weights[i]
+}
+
+// rss returns the weighted residual sum of squares.
+func (fl *fittedLm) rss() float64 {
+	var s float64
+	for i, r := range fl.residuals {
+		if w := fl.weight(i); w > 0 {
+			s += w * r * r
+		}
+	}
+	return s
+}
+
+// resVar returns the estimated residual variance.
+func (fl *fittedLm) resVar() float64 {
+	return fl.rss() / float64(fl.rdf)
+}
+
+// dispersion returns the scale the coefficient covariance is multiplied
+// by: the residual variance, or 1 for binomial and Poisson glms.
+func (fl *fittedLm) dispersion() float64 {
+	if fl.family != nil && fl.family.fixedDispersion() {
+		return 1
+	}
+	if fl.rdf == 0 {
+		return math.NaN()
+	}
+	return fl.resVar()
+}
+
+// estimable returns the indices of the coefficients that are not
+// aliased, in pivot order, which is the order of unscaledCov.
+func (fl *fittedLm) estimable() []int {
+	return fl.qr.pivot[:fl.qr.rank]
+}
+
+// terms returns the model terms of the fit, with the factor levels it was
+// fitted with, so that new data is coded the same way.
+func (fl *fittedLm) terms(ctx *Context) (*modelTerms, error) {
+	f, err := asFormula(ctx, fl.obj, ctx.callingEnv())
+	if err != nil {
+		return nil, err
+	}
+	mt, err := expandFormula(ctx, f, nil)
+	if err != nil {
+		return nil, err
+	}
+	mt.xlevels = map[string][]string{}
+	if xl, ok := listElem(fl.obj, "xlevels").(*ListVec); ok {
+		names, _ := listNames(xl)
+		for i, name := range names {
+			mt.xlevels[name] = toPlainStrings(xl.Data[i])
+		}
+	}
+	return mt, nil
+}
+
+// --- summary.lm ---
+
+// summaryLm implements summary() for lm objects: the coefficient table
+// with standard errors, t values and p-values, the residual standard
+// error, R² and the overall F test.
+func summaryLm(ctx *Context, v Value) (Value, error) {
+	fl, err := lmFromValue(ctx, v)
+	if err != nil {
+		return nil, err
+	}
+	// observations with zero weight are left out, as in the fit
+	var resid []float64
+	var residNames []string
+	var w []float64
+	rowNames := valueNames(listElem(v, "residuals"))
+	for i, r := range fl.residuals {
+		wi := fl.weight(i)
+		if wi == 0 {
+			continue
+		}
+		resid = append(resid, math.Sqrt(wi)*r)
+		w = append(w, wi)
+		if rowNames != nil {
+			residNames = append(residNames, rowNames[i])
+		}
+	}
+	n, rank := len(resid), fl.qr.rank
+	rdf := n - rank
+	var mss, rss float64
+	var sw, swf float64
+	for i, r := range resid {
+		rss += r * r
+		sw += w[i]
+		swf += w[i] * fl.fitted[i]
+	}
+	k := 0
+	for i, f := range fl.fitted {
+		if fl.weight(i) == 0 {
+			continue
+		}
+		if fl.intercept {
+			d := f - swf/sw
+			mss += w[k] * d * d
+		} else {
+			mss += w[k] * f * f
+		}
+		k++
+	}
+	resVar := rss / float64(rdf)
+	if !math.IsInf(resVar, 0) && !math.IsNaN(resVar) && rss < 1e-30*(mss+rss) {
+		if err := ctx.warn("essentially perfect fit: summary may be unreliable"); err != nil {
+			return nil, err
+		}
+	}
+	cov := fl.qr.unscaledCov()
+	est := fl.estimable()
+	coefNames := make([]string, rank)
+	table := make([]FloatElem, rank*4)
+	for i, j := range est {
+		coefNames[i] = fl.names[j]
+		b := fl.coef[j].Val
+		se := math.Sqrt(cov[i*rank+i] * resVar)
+		t := b / se
+		table[i] = FloatElem{Val: b}
+		table[rank+i] = FloatElem{Val: se}
+		table[2*rank+i] = FloatElem{Val: t}
+		table[3*rank+i] = FloatElem{Val: 2 * ptFn(math.Abs(t), float64(rdf), false, false)}
+	}
+	coefMat := newDoubleMatrix(table, rank, 4)
+	setDimNames(coefMat, coefNames, []string{"Estimate", "Std. Error", "t value", "Pr(>|t|)"})
+	aliased := make([]LogicalElem, len(fl.coef))
+	for j, c := range fl.coef {
+		aliased[j].Val = c.NA
+	}
+	aliasedVec := &LogicalVec{Data: aliased}
+	aliasedVec.SetAttr("names", charVecOf(fl.names))
+	covData := make([]FloatElem, len(cov))
+	for i, c := range cov {
+		covData[i].Val = c
+	}
+	covMat := newDoubleMatrix(covData, rank, rank)
+	setDimNames(covMat, coefNames, coefNames)
+
+	dfInt := 0
+	if fl.intercept {
+		dfInt = 1
+	}
+	var r2, adjR2 Value
+	var fstat Value
+	if rank != dfInt {
+		r := mss / (mss + rss)
+		r2 = DoubleScalar(r)
+		adjR2 = DoubleScalar(1 - (1-r)*(float64(n-dfInt)/float64(rdf)))
+		numDF := float64(rank - dfInt)
+		fstat = namedDoubles([]float64{(mss / numDF) / resVar, numDF, float64(rdf)}, []string{"value", "numdf", "dendf"})
+	} else {
+		r2, adjR2 = DoubleScalar(0), DoubleScalar(0)
+	}
+	var weights Value
+	if fl.weights != nil {
+		weights = doubleVecOf(w)
+	}
+	residVec := doubleVecOf(resid)
+	if residNames != nil {
+		residVec.SetAttr("names", charVecOf(residNames))
+	}
+	out := namedList(
+		"call", listElem(v, "call"),
+		"terms", listElem(v, "terms"),
+		"weights", weights,
+		"residuals", residVec,
+		"coefficients", coefMat,
+		"aliased", aliasedVec,
+		"sigma", DoubleScalar(math.Sqrt(resVar)),
+		"df", &IntVec{Data: []IntElem{{Val: int64(rank)}, {Val: int64(rdf)}, {Val: int64(len(fl.coef))}}},
+		"r.squared", r2,
+		"adj.r.squared", adjR2,
+		"fstatistic", fstat,
+		"cov.unscaled", covMat,
+		"na.action", listElem(v, "na.action"),
+	)
+	out.SetAttr("class", CharScalar("summary.lm"))
+	return out, nil
+}
+
+// --- coef, residuals, fitted, vcov, deviance ---
+
+// modelElement returns the named element of a fitted model for the
+// default methods of coef(), residuals() and fitted(); NULL when the
+// object has none.
+func modelElement(ctx *Context, args []ArgValue, fn, name string) (Value, error) {
+	if len(args) < 1 {
+		return nil, fmt.Errorf("%s(object) expects at least 1 argument", fn)
+	}
+	x, err := Force(ctx, args[0].Val)
+	if err != nil {
+		return nil, err
+	}
+	if v := listElem(x, name); v != nil {
+		return v, nil
+	}
+	return NullValue, nil
+}
+
+func builtinCoef(ctx *Context, args []ArgValue) (Value, error) {
+	return modelElement(ctx, args, "coef", "coefficients")
+}
+
+func builtinResiduals(ctx *Context, args []ArgValue) (Value, error) {
+	fargs, err := forceArgs(ctx, args)
+	if err != nil {
+		return nil, err
+	}
+	if m, _ := matchArgs(fargs, "object", "type", "..."); hasClass(m[0], "glm") {
+		return residualsGlm(ctx, m[0], m[1])
+	}
+	return modelElement(ctx, fargs, "residuals", "residuals")
+}
+
+func builtinFitted(ctx *Context, args []ArgValue) (Value, error) {
+	return modelElement(ctx, args, "fitted", "fitted.values")
+}
+
+func builtinDfResidual(ctx *Context, args []ArgValue) (Value, error) {
+	return modelElement(ctx, args, "df.residual", "df.residual")
+}
+
+// lmArg returns the fitted model that is the first argument of fn.
+func lmArg(ctx *Context, fn string, v Value) (*fittedLm, error) {
+	if v == nil {
+		return nil, fmt.Errorf("argument \"object\" is missing, with no default")
+	}
+	if !hasClass(v, "lm") {
+		return nil, fmt.Errorf("no applicable method for '%s' applied to an object of class \"%s\"", fn, classOf(v)[0])
+	}
+	return lmFromValue(ctx, v)
+}
+
+// builtinDeviance returns the residual sum of squares of an lm fit, or
+// the deviance of a glm.
+func builtinDeviance(ctx *Context, args []ArgValue) (Value, error) {
+	fargs, err := forceArgs(ctx, args)
+	if err != nil {
+		return nil, err
+	}
+	m, _ := matchArgs(fargs, "object", "...")
+	fl, err := lmArg(ctx, "deviance", m[0])
+	if err != nil {
+		return nil, err
+	}
+	if fl.family != nil {
+		return listElem(fl.obj, "deviance"), nil
+	}
+	return DoubleScalar(fl.rss()), nil
+}
+
+// builtinVcov returns the covariance matrix of the coefficients, with NA
+// rows and columns for aliased ones.
+func builtinVcov(ctx *Context, args []ArgValue) (Value, error) {
+	fargs, err := forceArgs(ctx, args)
+	if err != nil {
+		return nil, err
+	}
+	m, _ := matchArgs(fargs, "object", "...")
+	fl, err := lmArg(ctx, "vcov", m[0])
+	if err != nil {
+		return nil, err
+	}
+	p, rank := len(fl.coef), fl.qr.rank
+	data := make([]FloatElem, p*p)
+	for i := range data {
+		data[i].NA = true
+	}
+	cov, s2 := fl.qr.unscaledCov(), fl.dispersion()
+	est := fl.estimable()
+	for a, i := range est {
+		for b, j := range est {
+			data[j*p+i] = FloatElem{Val: cov[b*rank+a] * s2}
+		}
+	}
+	out := newDoubleMatrix(data, p, p)
+	setDimNames(out, fl.names, fl.names)
+	return out, nil
+}
+
+// --- predict, confint ---
+
+// dropResponse returns the terms of mt without the response, for
+// evaluating the model on new data that need not contain it.
+func dropResponse(mt *modelTerms) *modelTerms {
+	if !mt.response {
+		return mt
+	}
+	out := *mt
+	out.response = false
+	out.vars, out.varNames = mt.vars[1:], mt.varNames[1:]
+	out.terms = make([][]int, len(mt.terms))
+	for i, t := range mt.terms {
+		out.terms[i] = make([]int, len(t))
+		for k, v := range t {
+			out.terms[i][k] = v - 1
+		}
+	}
+	return &out
+}
+
+// builtinPredict implements predict(object, newdata, se.fit, interval,
+// level) for lm fits. Without newdata the fitted values are returned.
+// interval = "confidence" or "prediction" adds the columns lwr and upr.
+// glm fits have a method of their own, predictGlm.
+func builtinPredict(ctx *Context, args []ArgValue) (Value, error) {
+	fargs, err := forceArgs(ctx, args)
+	if err != nil {
+		return nil, err
+	}
+	m, _ := matchArgs(fargs, "object", "newdata", "se.fit", "interval", "level", "type", "...")
+	fl, err := lmArg(ctx, "predict", m[0])
+	if err != nil {
+		return nil, err
+	}
+	if fl.family != nil {
+		return predictGlm(ctx, fl, fargs)
+	}
+	seFit, err := logicalArg(ctx, m[2], false)
+	if err != nil {
+		return nil, err
+	}
+	interval, err := choiceArg(m[3], "interval", "none", "confidence", "prediction")
+	if err != nil {
+		return nil, err
+	}
+	level, err := levelArg(ctx, m[4])
+	if err != nil {
+		return nil, err
+	}
+	if m[5] != nil {
+		if _, err := choiceArg(m[5], "type", "response"); err != nil {
+			return nil, err
+		}
+	}
+	if m[1] == nil && interval == "prediction" {
+		if err := ctx.warn("predictions on current data refer to _future_ responses"); err != nil {
+			return nil, err
+		}
+	}
+	fit, se, rowNames, err := fl.predictLinear(ctx, m[1])
+	if err != nil {
+		return nil, err
+	}
+	resVar := fl.resVar()
+	n := len(fit)
+	var out Value
+	if interval == "none" {
+		v := &DoubleVec{Data: fit}
+		v.SetAttr("names", charVecOf(rowNames))
+		out = v
+	} else {
+		tq := qtFn((1+level)/2, float64(fl.rdf), true, false)
+		data := make([]FloatElem, 3*n)
+		for i := 0; i < n; i++ {
+			varFit := se[i].Val * se[i].Val
+			if interval == "prediction" {
+				varFit += resVar
+			}
+			hw := tq * math.Sqrt(varFit)
+			data[i] = fit[i]
+			data[n+i] = FloatElem{Val: fit[i].Val - hw, NA: fit[i].NA}
+			data[2*n+i] = FloatElem{Val: fit[i].Val + hw, NA: fit[i].NA}
+		}
+		mat := newDoubleMatrix(data, n, 3)
+		setDimNames(mat, rowNames, []string{"fit", "lwr", "upr"})
+		out = mat
+	}
+	if !seFit {
+		return out, nil
+	}
+	seVec := &DoubleVec{Data: se}
+	seVec.SetAttr("names", charVecOf(rowNames))
+	return namedList(
+		"fit", out,
+		"se.fit", seVec,
+		"df", IntScalar(int64(fl.rdf)),
+		"residual.scale", DoubleScalar(math.Sqrt(resVar)),
+	), nil
+}
+
+// predictLinear evaluates the linear predictor of a fit on newdata, or
+// on the data it was fitted to when newdata is nil, with its standard
+// errors and the row names.
+func (fl *fittedLm) predictLinear(ctx *Context, newdata Value) ([]FloatElem, []FloatElem, []string, error) {
+	mt, err := fl.terms(ctx)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	var mf *ListVec
+	if newdata == nil {
+		if mf, _ = listElem(fl.obj, "model").(*ListVec); mf == nil {
+			return nil, nil, nil, fmt.Errorf("invalid \"lm\" object: no 'model'")
+		}
+	} else {
+		mt = dropResponse(mt)
+		if mf, err = modelFrame(ctx, mt, newdata, frameOpts{naAction: "na.pass"}); err != nil {
+			return nil, nil, nil, err
+		}
+	}
+	mm, err := modelMatrix(ctx, mt, mf)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	n, p, _ := matrixDims(mm)
+	if p != len(fl.coef) {
+		return nil, nil, nil, fmt.Errorf("the model matrix of 'newdata' does not match the fit")
+	}
+	if fl.qr.rank < p {
+		if err := ctx.warn("prediction from a rank-deficient fit may be misleading"); err != nil {
+			return nil, nil, nil, err
+		}
+	}
+	x := mm.Data
+	est := fl.estimable()
+	cov, scale := fl.qr.unscaledCov(), fl.dispersion()
+	rank := len(est)
+	fit := make([]FloatElem, n)
+	se := make([]FloatElem, n)
+	for i := 0; i < n; i++ {
+		var s, v float64
+		na := false
+		for a, j := range est {
+			xj := x[j*n+i]
+			na = na || xj.NA
+			s += xj.Val * fl.coef[j].Val
+			for b, k := range est {
+				v += xj.Val * cov[b*rank+a] * x[k*n+i].Val
+			}
+		}
+		fit[i] = FloatElem{Val: s, NA: na}
+		se[i] = FloatElem{Val: math.Sqrt(v * scale), NA: na}
+	}
+	return fit, se, dfRowNames(mf), nil
+}
+
+// choiceArg returns the value of a string argument that must be one of
+// choices; R's match.arg also accepts a unique prefix. The first choice
+// is the default.
+func choiceArg(v Value, name string, choices ...string) (string, error) {
+	if v == nil {
+		return choices[0], nil
+	}
+	cv, ok := v.(*CharVec)
+	if !ok || cv.Len() < 1 || cv.Data[0].NA {
+		return "", fmt.Errorf("'%s' must be a character string", name)
+	}
+	if cv.Len() > 1 {
+		// the full vector of choices, as a default: use the first
+		return choices[0], nil
+	}
+	s := cv.Data[0].Val
+	var match string
+	for _, c := range choices {
+		if c == s {
+			return c, nil
+		}
+		if s != "" && strings.HasPrefix(c, s) {
+			if match != "" {
+				match = ""
+				break
+			}
+			match = c
+		}
+	}
+	if match == "" {
+		return "", fmt.Errorf("'arg' should be one of %s", quoteChoices(choices))
+	}
+	return match, nil
+}
+
+func quoteChoices(choices []string) string {
+	q := make([]string, len(choices))
+	for i, c := range choices {
+		q[i] = "“" + c + "”"
+	}
+	return strings.Join(q, ", ")
+}
+
+// levelArg returns a confidence level, 0.95 by default.
+func levelArg(ctx *Context, v Value) (float64, error) {
+	if v == nil {
+		return 0.95, nil
+	}
+	f, err := asFloatElem(ctx, v)
+	if err != nil || f.NA || f.Val <= 0 || f.Val >= 1 {
+		return 0, fmt.Errorf("'level' must be a number between 0 and 1")
+	}
+	return f.Val, nil
+}
+
+// builtinConfint implements confint(object, parm, level): the t-based
+// confidence intervals of the coefficients of an lm fit, or the Wald
+// intervals of a glm.
+func builtinConfint(ctx *Context, args []ArgValue) (Value, error) {
+	fargs, err := forceArgs(ctx, args)
+	if err != nil {
+		return nil, err
+	}
+	m, _ := matchArgs(fargs, "object", "parm", "level", "...")
+	fl, err := lmArg(ctx, "confint", m[0])
+	if err != nil {
+		return nil, err
+	}
+	level, err := levelArg(ctx, m[2])
+	if err != nil {
+		return nil, err
+	}
+	parm := make([]int, len(fl.coef))
+	for i := range parm {
+		parm[i] = i
+	}
+	if m[1] != nil {
+		if parm, err = resolveSubscript(ctx, m[1], len(fl.coef), fl.names); err != nil {
+			return nil, err
+		}
+	}
+	ses := make([]float64, len(fl.coef))
+	for i := range ses {
+		ses[i] = math.NaN()
+	}
+	cov, scale := fl.qr.unscaledCov(), fl.dispersion()
+	rank := fl.qr.rank
+	for a, j := range fl.estimable() {
+		ses[j] = math.Sqrt(cov[a*rank+a] * scale)
+	}
+	// glm intervals are Wald intervals, from the normal distribution
+	q := qnormFn((1+level)/2, 0, 1, true, false)
+	if fl.family == nil {
+		q = qtFn((1+level)/2, float64(fl.rdf), true, false)
+	}
+	return confintMatrix(fl.coef, fl.names, ses, parm, level, q), nil
+}
+
+// confintMatrix returns the intervals coef ± q se for the coefficients in
+// parm, with columns named by their percentages such as "2.5 %".
+func confintMatrix(coef []FloatElem, names []string, ses []float64, parm []int, level, q float64) Value {
+	k := len(parm)
+	data := make([]FloatElem, 2*k)
+	rowNames := make([]string, k)
+	for i, j := range parm {
+		if j < 0 || j >= len(coef) {
+			data[i].NA, data[k+i].NA = true, true
+			rowNames[i] = "NA"
+			continue
+		}
+		rowNames[i] = names[j]
+		if coef[j].NA || math.IsNaN(ses[j]) {
+			data[i].NA, data[k+i].NA = true, true
+			continue
+		}
+		data[i].Val = coef[j].Val - q*ses[j]
+		data[k+i].Val = coef[j].Val + q*ses[j]
+	}
+	a := (1 - level) / 2
+	pct := func(p float64) string { return strconv.FormatFloat(100*p, 'g', 3, 64) + " %" }
+	out := newDoubleMatrix(data, k, 2)
+	setDimNames(out, rowNames, []string{pct(a), pct(1 - a)})
+	return out
+}
+
+// --- printing ---
+
+// formatReal formats numbers as R's format(x, digits = digits) does: with
+// a common number of decimals, just enough to show each element to
+// digits significant digits, or in scientific notation when that is
+// narrower.
+func formatReal(x []FloatElem, digits int) []string {
+	out := make([]string, len(x))
+	maxSig, maxLeft, maxRight, maxExp := 1, 1, 0, 0
+	finite := false
+	for _, e := range x {
+		if e.NA || math.IsNaN(e.Val) || math.IsInf(e.Val, 0) {
+			continue
+		}
+		finite = true
+		sig, exp := sigDigits(e.Val, digits)
+		maxSig = max(maxSig, sig)
+		maxLeft = max(maxLeft, exp+1)
+		maxRight = max(maxRight, sig-1-exp)
+		maxExp = max(maxExp, int(math.Abs(float64(exp))))
+	}
+	fixedWidth := maxLeft
+	if maxRight > 0 {
+		fixedWidth += maxRight + 1
+	}
+	sciWidth := maxSig + 4
+	if maxSig > 1 {
+		sciWidth++
+	}
+	if maxExp >= 100 {
+		sciWidth++
+	}
+	sci := finite && fixedWidth > sciWidth
+	for i, e := range x {
+		switch {
+		case e.NA:
+			out[i] = "NA"
+		case math.IsNaN(e.Val):
+			out[i] = "NaN"
+		case math.IsInf(e.Val, 1):
+			out[i] = "Inf"
+		case math.IsInf(e.Val, -1):
+			out[i] = "-Inf"
+		case sci:
+			out[i] = strconv.FormatFloat(e.Val, 'e', maxSig-1, 64)
+		default:
+			out[i] = strconv.FormatFloat(e.Val, 'f', maxRight, 64)
+		}
+		if out[i] == "-0" || strings.HasPrefix(out[i], "-0") && strings.Trim(out[i], "-0.") == "" {
+			out[i] = out[i][1:]
+		}
+	}
+	return out
+}
+
+// sigDigits returns how many significant digits (at most digits) x needs
+// when rounded to digits significant digits, and its decimal exponent.
+func sigDigits(x float64, digits int) (int, int) {
+	if x == 0 {
+		return 1, 0
+	}
+	s := strconv.FormatFloat(math.Abs(x), 'e', digits-1, 64)
+	mant, exp, _ := strings.Cut(s, "e")
+	e, _ := strconv.Atoi(exp)
+	mant = strings.TrimRight(strings.Replace(mant, ".", "", 1), "0")
+	return max(1, len(mant)), e
+}
+
+func formatRealFloats(x []float64, digits int) []string {
+	elems := make([]FloatElem, len(x))
+	for i, v := range x {
+		elems[i].Val = v
+	}
+	return formatReal(elems, digits)
+}
+
+// formatNamedVector lays out a named vector as R prints it: the names
+// above the values, every column as wide as the widest entry and
+// followed by gap spaces, wrapped at 80 characters.
+func formatNamedVector(names, values []string, gap int) string {
+	w := 0
+	for i := range values {
+		w = max(w, max(len(values[i]), len(names[i])))
+	}
+	perLine := max(1, 80/(w+gap))
+	var sb strings.Builder
+	for start := 0; start < len(values); start += perLine {
+		end := min(start+perLine, len(values))
+		for _, row := range [][]string{names[start:end], values[start:end]} {
+			for _, s := range row {
+				sb.WriteString(padCell(s, w, false))
+				sb.WriteString(strings.Repeat(" ", gap))
+			}
+			sb.WriteString("\n")
+		}
+	}
+	return sb.String()
+}
+
+// formatTable lays out a character matrix with left-aligned row names and
+// right-aligned columns, as print(quote = FALSE) shows it.
+func formatTable(rowNames, colNames []string, cols [][]string) string {
+	rw := 0
+	for _, r := range rowNames {
+		rw = max(rw, len(r))
+	}
+	widths := make([]int, len(cols))
+	for j, col := range cols {
+		widths[j] = len([]rune(colNames[j]))
+		for _, s := range col {
+			widths[j] = max(widths[j], len(s))
+		}
+	}
+	var sb strings.Builder
+	sb.WriteString(strings.Repeat(" ", rw))
+	for j, name := range colNames {
+		sb.WriteString(" " + strings.Repeat(" ", widths[j]-len([]rune(name))) + name)
+	}
+	sb.WriteString("\n")
+	for i, r := range rowNames {
+		sb.WriteString(padCell(r, rw, true))
+		for j, col := range cols {
+			sb.WriteString(" " + padCell(col[i], widths[j], false))
+		}
+		sb.WriteString("\n")
+	}
+	return sb.String()
+}
+
+// formatPval formats p-values as R's format.pval: values below eps are
+// shown as "<eps", the others with digits significant digits.
+func formatPval(pv []float64, digits int, eps float64) []string {
+	out := make([]string, len(pv))
+	var fixed, sci []int
+	var is0 []int
+	for i, p := range pv {
+		switch {
+		case math.IsNaN(p):
+			out[i] = "NA"
+		case p < eps:
+			is0 = append(is0, i)
+		case p == 0 || math.Floor(math.Log10(p)) >= -3:
+			fixed = append(fixed, i)
+		default:
+			sci = append(sci, i)
+		}
+	}
+	nc := 0
+	for _, group := range [][]int{fixed, sci} {
+		vals := make([]float64, len(group))
+		for k, i := range group {
+			vals[k] = pv[i]
+		}
+		for k, s := range formatRealFloats(vals, digits) {
+			out[group[k]] = s
+			nc = max(nc, len(s))
+		}
+	}
+	if len(is0) > 0 {
+		digits = max(1, digits-2)
+		sep := " "
+		if len(fixed)+len(sci) > 0 {
+			if digits > 1 && digits+6 > nc {
+				digits = max(1, nc-7)
+			}
+			if digits == 1 && nc <= 6 {
+				sep = ""
+			}
+		} else if digits == 1 {
+			sep = ""
+		}
+		e := formatRealFloats([]float64{eps}, digits)[0]
+		for _, i := range is0 {
+			out[i] = "<" + sep + e
+		}
+	}
+	return out
+}
+
+// signifStars returns the significance codes of printCoefmat.
+func signifStars(p float64) string {
+	switch {
+	case math.IsNaN(p):
+		return ""
+	case p <= 0.001:
+		return "***"
+	case p <= 0.01:
+		return "**"
+	case p <= 0.05:
+		return "*"
+	case p <= 0.1:
+		return "."
+	}
+	return " "
+}
+
+const signifLegend = "Signif. codes:  0 ‘***’ 0.001 ‘**’ 0.01 ‘*’ 0.05 ‘.’ 0.1 ‘ ’ 1\n"
+
+// machineEps is R's .Machine$double.eps.
+const machineEps = 2.220446049250313e-16
+
+// coefmat is a numeric table for formatCoefmat, with the arguments of
+// R's printCoefmat: cs are the columns of estimates and standard errors,
+// formatted together, tst those of test statistics, zap those whose
+// negligible values are shown as zero. With hasP the last column holds
+// p-values. NA cells are NaN and print as naPrint.
+type coefmat struct {
+	rowNames, colNames []string
+	cols               [][]float64
+	digits             int
+	cs, tst, zap       []int
+	hasP               bool
+	naPrint            string
+}
+
+// formatCoefmat lays out a coefficient matrix as printCoefmat does:
+// estimates and standard errors with common decimals, test statistics
+// rounded to digits-1 decimals, p-values by formatPval and, when any is
+// below 0.1, significance stars and their legend.
+func formatCoefmat(cm coefmat) string {
+	n, nc := len(cm.rowNames), len(cm.cols)
+	cols := make([][]float64, nc)
+	copy(cols, cm.cols)
+	for _, j := range cm.zap {
+		cols[j] = zapsmall(cols[j], cm.digits)
+	}
+	out := make([][]string, nc)
+	formatted := make([]bool, nc)
+	// formatCols formats the cells of several columns together
+	formatCols := func(idx []int, digits, round int) {
+		var vals []FloatElem
+		for _, j := range idx {
+			for _, v := range cols[j] {
+				if round >= 0 {
+					v = roundTo(v, round)
+				}
+				vals = append(vals, FloatElem{Val: v, NA: math.IsNaN(v)})
+			}
+		}
+		s := formatReal(vals, digits)
+		for k, j := range idx {
+			out[j] = s[k*n : (k+1)*n]
+			formatted[j] = true
+		}
+	}
+	if len(cm.cs) > 0 {
+		minAbs := math.Inf(1)
+		for _, j := range cm.cs {
+			for _, v := range cols[j] {
+				if a := math.Abs(v); a != 0 && !math.IsNaN(a) && !math.IsInf(a, 0) {
+					minAbs = math.Min(minAbs, a)
+				}
+			}
+		}
+		dec := 1
+		if !math.IsInf(minAbs, 1) {
+			dec = max(1, cm.digits-(1+int(math.Floor(math.Log10(minAbs)))))
+		}
+		formatCols(cm.cs, cm.digits, dec)
+	}
+	digTst := max(1, min(5, cm.digits-1))
+	if len(cm.tst) > 0 {
+		formatCols(cm.tst, cm.digits, digTst)
+	}
+	last := nc
+	if cm.hasP {
+		last = nc - 1
+	}
+	for j := 0; j < last; j++ {
+		if !formatted[j] {
+			formatCols([]int{j}, cm.digits, -1)
+		}
+	}
+	for j := 0; j < last; j++ {
+		for i, v := range cols[j] {
+			if math.IsNaN(v) {
+				out[j][i] = cm.naPrint
+			}
+		}
+	}
+	colNames := cm.colNames
+	stars := false
+	if cm.hasP {
+		pv := cols[nc-1]
+		out[nc-1] = make([]string, n)
+		var okP []int
+		var pvals []float64
+		for i, p := range pv {
+			if math.IsNaN(p) {
+				out[nc-1][i] = cm.naPrint
+				continue
+			}
+			okP = append(okP, i)
+			pvals = append(pvals, p)
+			stars = stars || p < 0.1
+		}
+		for k, s := range formatPval(pvals, digTst, machineEps) {
+			out[nc-1][okP[k]] = s
+		}
+		if stars {
+			codes := make([]string, n)
+			w := 0
+			for i, p := range pv {
+				codes[i] = signifStars(p)
+				w = max(w, len(codes[i]))
+			}
+			for i := range codes {
+				codes[i] = padCell(codes[i], w, true)
+			}
+			out = append(out, codes)
+			colNames = append(append([]string(nil), colNames...), "")
+		}
+	}
+	s := formatTable(cm.rowNames, colNames, out)
+	if stars {
+		s += "---\n" + signifLegend
+	}
+	return s
+}
+
+// roundTo rounds x to d decimals.
+func roundTo(x float64, d int) float64 {
+	p := math.Pow(10, float64(d))
+	return math.Round(x*p) / p
+}
+
+// formatModelCall writes the "Call:" header of the model printers.
+func formatModelCall(sb *strings.Builder, call Value) {
+	sb.WriteString("\nCall:\n")
+	if ev, ok := call.(*ExprValue); ok {
+		sb.WriteString(deparse.Expr(ev.Expr))
+	}
+	sb.WriteString("\n\n")
+}
+
+// formatLm renders an lm object like R's print.lm: the call and the
+// coefficients.
+func formatLm(v Value) string {
+	var sb strings.Builder
+	formatModelCall(&sb, listElem(v, "call"))
+	coef, _ := listElem(v, "coefficients").(*DoubleVec)
+	if coef == nil || coef.Len() == 0 {
+		sb.WriteString("No coefficients\n")
+	} else {
+		sb.WriteString("Coefficients:\n")
+		sb.WriteString(formatNamedVector(valueNames(coef), formatReal(coef.Data, 4), 2))
+	}
+	sb.WriteString("\n")
+	return sb.String()
+}
+
+// formatSummaryLm renders a summary.lm object like R's print.summary.lm.
+func formatSummaryLm(v Value) string {
+	const digits = 4
+	var sb strings.Builder
+	formatModelCall(&sb, listElem(v, "call"))
+	resid := toFloats(listElem(v, "residuals"))
+	df := toFloats(listElem(v, "df"))
+	rdf := int(df[1])
+	if w := toFloats(listElem(v, "weights")); len(w) > 0 && !allEqual(w) {
+		sb.WriteString("Weighted ")
+	}
+	sb.WriteString("Residuals:\n")
+	switch {
+	case rdf > 5:
+		sorted := append([]float64(nil), resid...)
+		sort.Float64s(sorted)
+		q := quantileSorted(sorted, []float64{0, 0.25, 0.5, 0.75, 1}, 7)
+		sb.WriteString(formatNamedVector([]string{"Min", "1Q", "Median", "3Q", "Max"}, formatRealFloats(zapsmall(q, digits+1), digits), 1))
+	case rdf > 0:
+		names := valueNames(listElem(v, "residuals"))
+		if names == nil {
+			names = make([]string, len(resid))
+			for i := range names {
+				names[i] = strconv.Itoa(i + 1)
+			}
+		}
+		sb.WriteString(formatNamedVector(names, formatRealFloats(resid, digits), 1))
+	default:
+		fmt.Fprintf(&sb, "ALL %d residuals are 0: no residual degrees of freedom!\n", len(resid))
+	}
+
+	aliased, _ := listElem(v, "aliased").(*LogicalVec)
+	if aliased == nil || aliased.Len() == 0 {
+		sb.WriteString("\nNo Coefficients\n")
+	} else {
+		if ns := int(df[2] - df[0]); ns > 0 {
+			fmt.Fprintf(&sb, "\nCoefficients: (%d not defined because of singularities)\n", ns)
+		} else {
+			sb.WriteString("\nCoefficients:\n")
+		}
+		coefs := listElem(v, "coefficients")
+		k, _, _ := matrixDims(coefs)
+		table := toFloats(coefs)
+		names := valueNames(aliased)
+		p := aliased.Len()
+		cols := make([][]float64, 4)
+		for c := range cols {
+			cols[c] = make([]float64, p)
+			r := 0
+			for j := 0; j < p; j++ {
+				if aliased.Data[j].Val {
+					cols[c][j] = math.NaN()
+					continue
+				}
+				cols[c][j] = table[c*k+r]
+				r++
+			}
+		}
+		sb.WriteString(formatCoefmat(coefmat{
+			rowNames: names, colNames: dimNamesAt(coefs, 1), cols: cols, digits: digits,
+			cs: []int{0, 1}, tst: []int{2}, hasP: true, naPrint: "NA",
+		}))
+	}
+
+	sigma := toFloats(listElem(v, "sigma"))[0]
+	fmt.Fprintf(&sb, "\nResidual standard error: %s on %d degrees of freedom\n", formatFloat(signif(sigma, digits)), rdf)
+	if msg := naActionMessage(listElem(v, "na.action")); msg != "" {
+		sb.WriteString("  (" + msg + ")\n")
+	}
+	if fs := toFloats(listElem(v, "fstatistic")); len(fs) == 3 {
+		r2 := toFloats(listElem(v, "r.squared"))[0]
+		adj := toFloats(listElem(v, "adj.r.squared"))[0]
+		fmt.Fprintf(&sb, "Multiple R-squared:  %s,\tAdjusted R-squared:  %s \n", formatG(r2, digits), formatG(adj, digits))
+		p := pfFn(fs[0], fs[1], fs[2], false, false)
+		fmt.Fprintf(&sb, "F-statistic: %s on %s and %s DF,  p-value: %s\n", formatG(fs[0], digits), formatFloat(fs[1]), formatFloat(fs[2]), formatPval([]float64{p}, digits, machineEps)[0])
+	}
+	sb.WriteString("\n")
+	return sb.String()
+}
+
+// naActionMessage describes the rows a model frame dropped, as naprint().
+func naActionMessage(v Value) string {
+	if v == nil || !hasClass(v, "omit") {
+		return ""
+	}
+	n := v.Len()
+	if n == 1 {
+		return "1 observation deleted due to missingness"
+	}
+	return fmt.Sprintf("%d observations deleted due to missingness", n)
+}
+
+func allEqual(x []float64) bool {
+	for _, v := range x {
+		if v != x[0] {
+			return false
+		}
+	}
+	return true
+}
+
+// zapsmall rounds values that are negligible compared to the largest to
+// zero, as R's zapsmall(x, digits).
+func zapsmall(x []float64, digits int) []float64 {
+	mx := 0.0
+	for _, v := range x {
+		if !math.IsNaN(v) {
+			mx = math.Max(mx, math.Abs(v))
+		}
+	}
+	d := digits
+	if mx > 0 {
+		d = max(0, digits-int(math.Ceil(math.Log10(mx))))
+	}
+	out := make([]float64, len(x))
+	for i, v := range x {
+		out[i] = roundTo(v, d)
+	}
+	return out
+}
+
+// signif rounds x to digits significant digits.
+func signif(x float64, digits int) float64 {
+	if x == 0 || math.IsNaN(x) || math.IsInf(x, 0) {
+		return x
+	}
+	f, _ := strconv.ParseFloat(strconv.FormatFloat(x, 'e', digits-1, 64), 64)
+	return f
+}
+
+// formatG formats x like C's %.{digits}g, as R's formatC does.
+func formatG(x float64, digits int) string {
+	return strconv.FormatFloat(x, 'g', digits, 64)
+}
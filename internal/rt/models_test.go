@@ -0,0 +1,312 @@
+package rt
+
+import "testing"
+
+// carsData is R's cars data set.
+const carsData = `cars <- data.frame(
+  speed = c(4, 4, 7, 7, 8, 9, 10, 10, 10, 11, 11, 12, 12, 12, 12, 13, 13, 13, 13, 14, 14, 14, 14, 15, 15,
+    15, 16, 16, 17, 17, 17, 18, 18, 18, 18, 19, 19, 19, 20, 20, 20, 20, 20, 22, 23, 24, 24, 24, 24, 25),
+  dist = c(2, 10, 4, 22, 16, 10, 18, 26, 34, 17, 28, 14, 20, 24, 28, 26, 34, 34, 46, 26, 36, 60, 80, 20, 26,
+    54, 32, 40, 32, 40, 50, 42, 56, 76, 84, 36, 46, 68, 32, 48, 52, 56, 64, 66, 54, 70, 92, 93, 120, 85))
+fit <- lm(dist ~ speed, data = cars)
+`
+
+func TestLm(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{carsData + "round(coef(fit), 4)", "-17.5791 3.9324"},
+		{carsData + "names(coef(fit))", `"(Intercept)" "speed"`},
+		{carsData + "class(fit)", `"lm"`},
+		{carsData + "fit$rank; fit$df.residual", "48"},
+		{carsData + "round(sum(residuals(fit)^2), 2) == round(deviance(fit), 2)", "TRUE"},
+		{carsData + "round(fitted(fit)[1:2], 3)", "-1.849 -1.849"},
+		{carsData + "s <- summary(fit); round(s$r.squared, 4)", "0.6511"},
+		{carsData + "s <- summary(fit); round(s$adj.r.squared, 4)", "0.6438"},
+		{carsData + "s <- summary(fit); round(s$sigma, 3)", "15.38"},
+		{carsData + "s <- summary(fit); round(s$fstatistic, 2)", "89.57 1 48"},
+		{carsData + "round(coef(summary(fit))[, \"Std. Error\"], 4)", "6.7584 0.4155"},
+		{carsData + "coef(summary(fit))[2, 4] < 1.5e-12", "TRUE"},
+		{carsData + "round(confint(fit)[2, ], 3)", "3.097 4.768"},
+		{carsData + "colnames(confint(fit, level = 0.9))", `"5 %" "95 %"`},
+		{carsData + "round(predict(fit, data.frame(speed = c(10, 20))), 3)", "21.745 61.069"},
+		{carsData + "round(predict(fit, data.frame(speed = 10), interval = \"confidence\"), 3)", "21.745 15.462 28.028"},
+		{carsData + "round(predict(fit, data.frame(speed = 10), interval = \"prediction\"), 3)", "21.745 -9.81 53.3"},
+		{carsData + "round(predict(fit, data.frame(speed = 10), se.fit = TRUE)$se.fit, 4)", "3.1249"},
+		{carsData + "round(diag(vcov(fit)), 4)", "45.6765 0.1727"},
+		// factors, dependent columns and weights
+		{formulaData + "round(coef(lm(y ~ g, df)), 4)", "1.5 2.5 3.5"},
+		{formulaData + "coef(lm(y ~ x + I(2 * x), df))[[3]]", "NA"},
+		{formulaData + "summary(lm(y ~ x + I(2 * x), df))$aliased", "FALSE FALSE TRUE"},
+		{formulaData + "f <- lm(y ~ g, df); round(predict(f, data.frame(g = c(\"c\", \"a\"))), 4)", "5 1.5"},
+		{formulaData + "round(coef(lm(y ~ x, df, weights = c(1, 1, 1, 1, 1, 0))), 4)", "0.6 0.8"},
+		{formulaData + "lm(y ~ x, df, weights = c(1, 2, 1, 1, 1, 0))$df.residual", "3"},
+		{formulaData + "lm(y ~ x, df, subset = x > 2)$df.residual", "2"},
+		{"f <- lm(y ~ x, data.frame(y = c(1, NA, 3, 4), x = 1:4)); names(residuals(f))", `"1" "3" "4"`},
+		{formulaData + "round(coef(lm(y ~ x - 1, df)) - 89 / 91, 10)", "0"},
+	}
+
+	for _, tt := range tests {
+		ctx := NewContext()
+		res, err := ctx.EvalString(tt.input)
+		if err != nil {
+			t.Errorf("input %q: unexpected error: %v", tt.input, err)
+			continue
+		}
+		if res.Value.String() != tt.expected {
+			t.Errorf("input %q: expected %s, got %s", tt.input, tt.expected, res.Value.String())
+		}
+	}
+}
+
+func TestLmPrint(t *testing.T) {
+	ctx := NewContext()
+	res, err := ctx.EvalString(carsData + "print(fit)\nprint(summary(fit))")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	// the layout, including trailing blanks, is that of R
+	expected := "" +
+		"\n" +
+		"Call:\n" +
+		"lm(formula = dist ~ speed, data = cars)\n" +
+		"\n" +
+		"Coefficients:\n" +
+		"(Intercept)        speed  \n" +
+		"    -17.579        3.932  \n" +
+		"\n" +
+		"\n" +
+		"Call:\n" +
+		"lm(formula = dist ~ speed, data = cars)\n" +
+		"\n" +
+		"Residuals:\n" +
+		"    Min      1Q  Median      3Q     Max \n" +
+		"-29.069  -9.525  -2.272   9.215  43.201 \n" +
+		"\n" +
+		"Coefficients:\n" +
+		"            Estimate Std. Error t value Pr(>|t|)    \n" +
+		"(Intercept) -17.5791     6.7584  -2.601   0.0123 *  \n" +
+		"speed         3.9324     0.4155   9.464 1.49e-12 ***\n" +
+		"---\n" +
+		"Signif. codes:  0 ‘***’ 0.001 ‘**’ 0.01 ‘*’ 0.05 ‘.’ 0.1 ‘ ’ 1\n" +
+		"\n" +
+		"Residual standard error: 15.38 on 48 degrees of freedom\n" +
+		"Multiple R-squared:  0.6511,\tAdjusted R-squared:  0.6438 \n" +
+		"F-statistic: 89.57 on 1 and 48 DF,  p-value: 1.49e-12\n" +
+		"\n"
+	if res.Output != expected {
+		t.Errorf("expected output %q, got %q", expected, res.Output)
+	}
+}
+
+func TestLmErrors(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"lm()", "argument \"formula\" is missing, with no default"},
+		{formulaData + "lm(~ x, df)", "lm() needs a response: the formula has no left-hand side"},
+		{formulaData + "lm(y ~ x, df, weights = c(1, -1, 1, 1, 1, 1))", "missing or negative weights not allowed"},
+		{formulaData + "f <- lm(y ~ g, df); predict(f, data.frame(g = \"d\"))", "factor g has new level d"},
+		{formulaData + "predict(lm(y ~ x, df), interval = \"bogus\")", "'arg' should be one of “none”, “confidence”, “prediction”"},
+		{"predict(1:3)", `no applicable method for 'predict' applied to an object of class "integer"`},
+	}
+
+	for _, tt := range tests {
+		ctx := NewContext()
+		_, err := ctx.EvalString(tt.input)
+		if err == nil {
+			t.Errorf("input %q: expected error", tt.input)
+			continue
+		}
+		if err.Error() != tt.expected {
+			t.Errorf("input %q: expected %q, got %q", tt.input, tt.expected, err.Error())
+		}
+	}
+}
+
+// mtcarsData holds the weight, horsepower and transmission columns of R's
+// mtcars data set.
+const mtcarsData = `mtcars <- data.frame(
+  wt = c(2.620, 2.875, 2.320, 3.215, 3.440, 3.460, 3.570, 3.190, 3.150, 3.440, 3.440, 4.070, 3.730, 3.780, 5.250, 5.424,
+    5.345, 2.200, 1.615, 1.835, 2.465, 3.520, 3.435, 3.840, 3.845, 1.935, 2.140, 1.513, 3.170, 2.770, 3.570, 2.780),
+  hp = c(110, 110, 93, 110, 175, 105, 245, 62, 95, 123, 123, 180, 180, 180, 205, 215, 230, 66, 52, 65, 97, 150, 150,
+    245, 175, 66, 91, 113, 264, 175, 335, 109),
+  am = c(1, 1, 1, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 1, 1, 1, 0, 0, 0, 0, 0, 1, 1, 1, 1, 1, 1, 1))
+fit <- glm(am ~ wt, family = binomial, data = mtcars)
+`
+
+// dobsonData is the Poisson example of R's ?glm, from Dobson (1990).
+const dobsonData = `counts <- c(18, 17, 15, 20, 10, 20, 25, 13, 12)
+outcome <- factor(c(1, 2, 3, 1, 2, 3, 1, 2, 3))
+treatment <- factor(c(1, 1, 1, 2, 2, 2, 3, 3, 3))
+pois <- glm(counts ~ outcome + treatment, family = poisson())
+`
+
+func TestGlm(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{mtcarsData + "round(coef(fit), 4)", "12.0404 -4.024"},
+		{mtcarsData + "class(fit)", `"glm" "lm"`},
+		{mtcarsData + "round(c(deviance(fit), fit$null.deviance, fit$aic), 3)", "19.176 43.23 23.176"},
+		{mtcarsData + "c(fit$df.residual, fit$df.null, fit$iter)", "30 31 6"},
+		{mtcarsData + "fit$converged", "TRUE"},
+		{mtcarsData + "round(coef(summary(fit))[, \"Std. Error\"], 4)", "4.5097 1.4364"},
+		{mtcarsData + "colnames(coef(summary(fit)))", `"Estimate" "Std. Error" "z value" "Pr(>|z|)"`},
+		{mtcarsData + "round(coef(summary(fit))[2, 4], 5)", "0.00509"},
+		{mtcarsData + "summary(fit)$dispersion", "1"},
+		{mtcarsData + "round(predict(fit, data.frame(wt = c(2, 3)), type = \"response\"), 4)", "0.9819 0.4921"},
+		{mtcarsData + "round(predict(fit, data.frame(wt = 3)), 4)", "-0.0315"},
+		{mtcarsData + "max(abs(predict(fit, type = \"response\") - fitted(fit)))", "0"},
+		{mtcarsData + "round(predict(fit, data.frame(wt = 3), type = \"response\", se.fit = TRUE)$se.fit, 4)", "0.1513"},
+		{mtcarsData + "round(sum(residuals(fit)^2), 3)", "19.176"},
+		{mtcarsData + "round(residuals(fit, type = \"response\")[[1]], 4)", "0.1828"},
+		{mtcarsData + "round(confint(fit)[2, ], 3)", "-6.839 -1.209"},
+		{mtcarsData + "round(AIC(fit), 3)", "23.176"},
+		{mtcarsData + "a <- anova(fit); round(a[[\"Pr(>Chi)\"]][2] * 1e7, 3)", "9.369"},
+		{mtcarsData + "fit2 <- glm(am ~ wt + hp, binomial, mtcars); round(anova(fit, fit2)$Deviance[2], 3)", "9.117"},
+		{mtcarsData + "names(anova(fit, test = FALSE))", `"Df" "Deviance" "Resid. Df" "Resid. Dev"`},
+		{mtcarsData + "g <- glm(factor(am) ~ wt, binomial, mtcars); round(coef(g), 4)", "12.0404 -4.024"},
+		{mtcarsData + "g <- glm(am ~ wt, binomial(link = probit), mtcars); g$family$link", `"probit"`},
+		{mtcarsData + "g <- glm(am ~ wt, \"binomial\", mtcars); g$family$family", `"binomial"`},
+		// successes and failures
+		{"s <- c(2, 5, 8); f <- c(8, 5, 2); x <- 1:3; round(coef(glm(cbind(s, f) ~ x, binomial)), 4)", "-2.7726 1.3863"},
+		{"s <- c(2, 5, 8, NA); f <- c(8, 5, 2, 1); x <- 1:4; glm(cbind(s, f) ~ x, binomial)$prior.weights", "10 10 10"},
+		// Poisson, gaussian and Gamma
+		{dobsonData + "round(c(deviance(pois), pois$null.deviance, pois$aic), 3)", "5.129 10.581 56.761"},
+		{dobsonData + "round(coef(pois)[1:3], 4)", "3.0445 -0.4543 -0.293"},
+		{carsData + "g <- glm(dist ~ speed, data = cars); round(coef(g), 4)", "-17.5791 3.9324"},
+		{carsData + "g <- glm(dist ~ speed, data = cars); round(c(summary(g)$dispersion, AIC(g), AIC(fit)), 3)", "236.532 419.157 419.157"},
+		{"x <- 1:6; y <- exp(1 + 0.5 * x); round(coef(glm(y ~ x, family = Gamma(link = \"log\"))), 6)", "1 0.5"},
+		{"binomial()$linkinv(0)", "0.5"},
+		{"poisson()$linkfun(exp(2))", "2"},
+		{"Gamma()$variance(3)", "9"},
+		{carsData + "family(fit)$family", `"gaussian"`},
+	}
+
+	for _, tt := range tests {
+		ctx := NewContext()
+		res, err := ctx.EvalString(tt.input)
+		if err != nil {
+			t.Errorf("input %q: unexpected error: %v", tt.input, err)
+			continue
+		}
+		if res.Value.String() != tt.expected {
+			t.Errorf("input %q: expected %s, got %s", tt.input, tt.expected, res.Value.String())
+		}
+	}
+}
+
+func TestGlmPrint(t *testing.T) {
+	ctx := NewContext()
+	res, err := ctx.EvalString(mtcarsData + "print(fit)\nprint(summary(fit))\nprint(anova(fit))")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := "" +
+		"\n" +
+		"Call:  glm(formula = am ~ wt, family = binomial, data = mtcars)\n" +
+		"\n" +
+		"Coefficients:\n" +
+		"(Intercept)           wt  \n" +
+		"     12.040       -4.024  \n" +
+		"\n" +
+		"Degrees of Freedom: 31 Total (i.e. Null);  30 Residual\n" +
+		"Null Deviance:\t    43.23 \n" +
+		"Residual Deviance: 19.18 \tAIC: 23.18\n" +
+		"\n" +
+		"Call:\n" +
+		"glm(formula = am ~ wt, family = binomial, data = mtcars)\n" +
+		"\n" +
+		"Coefficients:\n" +
+		"            Estimate Std. Error z value Pr(>|z|)   \n" +
+		"(Intercept)   12.040      4.510   2.670  0.00759 **\n" +
+		"wt            -4.024      1.436  -2.801  0.00509 **\n" +
+		"---\n" +
+		"Signif. codes:  0 ‘***’ 0.001 ‘**’ 0.01 ‘*’ 0.05 ‘.’ 0.1 ‘ ’ 1\n" +
+		"\n" +
+		"(Dispersion parameter for binomial family taken to be 1)\n" +
+		"\n" +
+		"    Null deviance: 43.230  on 31  degrees of freedom\n" +
+		"Residual deviance: 19.176  on 30  degrees of freedom\n" +
+		"AIC: 23.176\n" +
+		"\n" +
+		"Number of Fisher Scoring iterations: 6\n" +
+		"\n" +
+		"Analysis of Deviance Table\n" +
+		"\n" +
+		"Model: binomial, link: logit\n" +
+		"\n" +
+		"Response: am\n" +
+		"\n" +
+		"Terms added sequentially (first to last)\n" +
+		"\n" +
+		"\n" +
+		"     Df Deviance Resid. Df Resid. Dev  Pr(>Chi)    \n" +
+		"NULL                    31     43.230              \n" +
+		"wt    1   24.054        30     19.176 9.369e-07 ***\n" +
+		"---\n" +
+		"Signif. codes:  0 ‘***’ 0.001 ‘**’ 0.01 ‘*’ 0.05 ‘.’ 0.1 ‘ ’ 1\n"
+	if res.Output != expected {
+		t.Errorf("expected output %q, got %q", expected, res.Output)
+	}
+}
+
+func TestAnovaLm(t *testing.T) {
+	ctx := NewContext()
+	res, err := ctx.EvalString(carsData + "print(anova(fit))\nprint(anova(lm(dist ~ 1, cars), fit))")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := "" +
+		"Analysis of Variance Table\n" +
+		"\n" +
+		"Response: dist\n" +
+		"          Df Sum Sq Mean Sq F value   Pr(>F)    \n" +
+		"speed      1  21185   21185  89.567 1.49e-12 ***\n" +
+		"Residuals 48  11354     237                     \n" +
+		"---\n" +
+		"Signif. codes:  0 ‘***’ 0.001 ‘**’ 0.01 ‘*’ 0.05 ‘.’ 0.1 ‘ ’ 1\n" +
+		"Analysis of Variance Table\n" +
+		"\n" +
+		"Model 1: dist ~ 1\n" +
+		"Model 2: dist ~ speed\n" +
+		"  Res.Df   RSS Df Sum of Sq      F   Pr(>F)    \n" +
+		"1     49 32539                                 \n" +
+		"2     48 11354  1     21185 89.567 1.49e-12 ***\n" +
+		"---\n" +
+		"Signif. codes:  0 ‘***’ 0.001 ‘**’ 0.01 ‘*’ 0.05 ‘.’ 0.1 ‘ ’ 1\n"
+	if res.Output != expected {
+		t.Errorf("expected output %q, got %q", expected, res.Output)
+	}
+}
+
+func TestGlmErrors(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"glm(y ~ x, binomial, data.frame(y = c(0, 2), x = 1:2))", "y values must be 0 <= y <= 1"},
+		{"glm(y ~ x, poisson, data.frame(y = c(-1, 2), x = 1:2))", "negative values not allowed for the 'Poisson' family"},
+		{"glm(y ~ x, Gamma, data.frame(y = c(0, 2), x = 1:2))", "non-positive values not allowed for the 'Gamma' family"},
+		{"binomial(link = \"sqrt\")", "link \"sqrt\" not available for binomial family; available links are ‘logit’, ‘probit’, ‘cloglog’, ‘cauchit’, ‘log’"},
+		{"glm(y ~ x, \"quasi\", data.frame(y = 1:2, x = 1:2))", "could not find function \"quasi\""},
+		{mtcarsData + "predict(fit, type = \"terms\")", "'arg' should be one of “link”, “response”"},
+		{mtcarsData + "anova(fit, lm(am ~ wt, mtcars))", "models are not all of the same class"},
+	}
+
+	for _, tt := range tests {
+		ctx := NewContext()
+		_, err := ctx.EvalString(tt.input)
+		if err == nil {
+			t.Errorf("input %q: expected error", tt.input)
+			continue
+		}
+		if err.Error() != tt.expected {
+			t.Errorf("input %q: expected %q, got %q", tt.input, tt.expected, err.Error())
+		}
+	}
+}
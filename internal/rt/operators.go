@@ -0,0 +1,188 @@
+package rt
+
+import (
+	"fmt"
+
+	"simonwaldherr.de/go/smallr/internal/ast"
+	"simonwaldherr.de/go/smallr/internal/deparse"
+	"simonwaldherr.de/go/smallr/internal/token"
+)
+
+// installOperatorBuiltins binds the operators to their names, so that they
+// can be called as `+`(1, 2) and passed to functions such as sapply() and
+// Reduce(). %*% and %o% are installed with the matrix builtins.
+func installOperatorBuiltins(env *Env) {
+	for _, name := range []string{
+		"+", "-", "*", "/", "^", "%%", "%/%", "%in%", ":",
+		"==", "!=", "<", "<=", ">", ">=", "!", "&", "|", "&&", "||",
+		"[", "[[", "~",
+	} {
+		env.SetLocal(name, operatorBuiltin(name))
+	}
+	env.SetLocal("?", &BuiltinFunc{FnName: "?", Impl: builtinHelp})
+	env.SetLocal("%||%", &BuiltinFunc{FnName: "%||%", Impl: builtinNullDefault})
+}
+
+// operatorBuiltin calls an operator as a function. The call is rebuilt as
+// the operator expression with the (still lazy) arguments as operands, so
+// that S3 dispatch and the short-circuit of && and || work as usual.
+func operatorBuiltin(name string) *BuiltinFunc {
+	return &BuiltinFunc{FnName: name, Impl: func(ctx *Context, args []ArgValue) (Value, error) {
+		operands := make([]ast.Arg, len(args))
+		for i, a := range args {
+			operands[i] = ast.Arg{Name: a.Name, Value: operandExpr(a.Val)}
+		}
+		e := makeCall(token.Pos{}, &ast.Ident{Name: name}, operands)
+		if _, ok := e.(*ast.CallExpr); ok {
+			return nil, fmt.Errorf("invalid arguments to `%s`", name)
+		}
+		// evalNode rather than Eval: errors are reported at the call
+		// of the operator function, which has a source position
+		return evalNode(ctx, ctx.callerEnv(nil), e)
+	}}
+}
+
+// operandExpr wraps an argument of an operator function as an expression
+// that evaluates to it. An empty argument, as in `[`(m, , 1), stays empty.
+func operandExpr(v Value) ast.Expr {
+	if p, ok := v.(*Promise); ok {
+		if p.Expr == nil {
+			return nil
+		}
+		return &ast.ValueExpr{Value: p, Deparse: func() string { return deparse.Expr(p.Expr) }}
+	}
+	if v == MissingValue {
+		return nil
+	}
+	return lazyValueExpr(v)
+}
+
+// isUserOp reports whether op is a %op% operator without a built-in
+// implementation, such as %||%. These call the function of that name.
+func isUserOp(op token.Type) bool {
+	return token.IsSpecial(op) && binaryOps[string(op)] == ""
+}
+
+// callOperator evaluates an operator expression as the call `op`(operands)
+// of the function bound to the operator's name.
+func callOperator(ctx *Context, env *Env, pos token.Pos, op token.Type, operands ...ast.Expr) (Value, error) {
+	args := make([]ast.Arg, len(operands))
+	for i, x := range operands {
+		args[i] = ast.Arg{Value: x}
+	}
+	fun := &ast.Ident{P: pos, Name: string(op)}
+	if _, ok := env.Get(fun.Name); !ok {
+		return nil, fmt.Errorf("could not find function \"%s\"", fun.Name)
+	}
+	return evalCall(ctx, env, &ast.CallExpr{P: pos, Fun: fun, Args: args})
+}
+
+// builtinNullDefault implements x %||% y, which is y when x is NULL and
+// x otherwise. y is only evaluated when it is needed.
+func builtinNullDefault(ctx *Context, args []ArgValue) (Value, error) {
+	if len(args) != 2 {
+		return nil, fmt.Errorf("%%||%% expects 2 arguments")
+	}
+	x, err := Force(ctx, args[0].Val)
+	if err != nil {
+		return nil, err
+	}
+	if x != NullValue {
+		return x, nil
+	}
+	return Force(ctx, args[1].Val)
+}
+
+// namespaces lists the packages pkg::name accepts. smallR has no package
+// system; names from all of them are looked up among the builtins, which
+// user code cannot mask this way.
+var namespaces = map[string]bool{
+	"base": true, "stats": true, "utils": true, "methods": true,
+	"graphics": true, "grDevices": true, "datasets": true,
+}
+
+// evalNamespaceGet implements pkg::name and pkg:::name.
+func evalNamespaceGet(ctx *Context, e *ast.BinaryExpr) (Value, error) {
+	pkg, name := symbolName(e.Left), symbolName(e.Right)
+	if !namespaces[pkg] {
+		return nil, fmt.Errorf("there is no package called '%s'", pkg)
+	}
+	if v, ok := ctx.builtins[name]; ok {
+		return v, nil
+	}
+	if e.Op == token.NS_GET_INT {
+		return nil, fmt.Errorf("object '%s' not found", name)
+	}
+	return nil, fmt.Errorf("'%s' is not an exported object from 'namespace:%s'", name, pkg)
+}
+
+// symbolName returns the name written as a symbol or a string.
+func symbolName(e ast.Expr) string {
+	switch x := e.(type) {
+	case *ast.Ident:
+		return x.Name
+	case *ast.StringLit:
+		return x.Value
+	}
+	return ""
+}
+
+// evalSlot implements x@name. There are no S4 classes, so slots are the
+// attributes of x.
+func evalSlot(ctx *Context, env *Env, e *ast.BinaryExpr) (Value, error) {
+	x, err := Eval(ctx, env, e.Left)
+	if err != nil {
+		return nil, err
+	}
+	x, err = Force(ctx, x)
+	if err != nil {
+		return nil, err
+	}
+	name := symbolName(e.Right)
+	if v, ok := x.GetAttr(name); ok {
+		return v, nil
+	}
+	return nil, fmt.Errorf("no slot of name \"%s\" for this object of class \"%s\"", name, classOf(x)[0])
+}
+
+// makeFormula returns the value of y ~ x or ~ x: the unevaluated call,
+// with class "formula" and the environment it was created in.
+func makeFormula(env *Env, e ast.Expr) Value {
+	f := &ExprValue{Expr: e}
+	f.SetAttr("class", CharScalar("formula"))
+	f.SetAttr(".Environment", &EnvValue{Env: env})
+	return f
+}
+
+// isFormula reports whether v was created by ~. A formula prints as the
+// call, without its attributes.
+func isFormula(v Value) bool {
+	_, ok := v.(*ExprValue)
+	return ok && hasClass(v, "formula")
+}
+
+// builtinHelp implements ?topic and type?topic. smallR has no help pages,
+// so it reports that, as R does for an unknown topic.
+func builtinHelp(ctx *Context, args []ArgValue) (Value, error) {
+	if len(args) == 0 {
+		return nil, fmt.Errorf("? expects a topic")
+	}
+	topic := args[len(args)-1].Val
+	name := ""
+	if p, ok := topic.(*Promise); ok {
+		name = symbolName(p.Expr)
+	}
+	if name == "" {
+		v, err := Force(ctx, topic)
+		if err != nil {
+			return nil, err
+		}
+		cv, ok := v.(*CharVec)
+		if !ok || cv.Len() != 1 || cv.Data[0].NA {
+			return nil, fmt.Errorf("invalid help topic")
+		}
+		name = cv.Data[0].Val
+	}
+	ctx.Println(fmt.Sprintf("No documentation for '%s' in specified packages and libraries", name))
+	return NullValue, nil
+}
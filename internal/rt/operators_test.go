@@ -0,0 +1,126 @@
+package rt
+
+import (
+	"testing"
+
+	"simonwaldherr.de/go/smallr/internal/ast"
+)
+
+func TestOperators(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"`%||%` <- function(a, b) if (is.null(a)) b else a; NULL %||% 2", "2"},
+		{"`%>%` <- function(lhs, rhs) rhs(lhs); c(1, 4, 9) %>% sqrt %>% sum", "6"},
+		{"`%+%` <- function(a, b) paste0(a, b); \"a\" %+% \"b\" %+% \"c\"", `"abc"`},
+		{"`%f%` <- function(x, y) sys.call(); deparse(1 %f% 2)", `"1 %f% 2"`},
+		{"f <- function(x) x %in% c(1, 2); f(2)", "TRUE"},
+		{"c(NULL %||% 1, 2 %||% stop(\"lazy\"))", "1 2"},
+		{"`+`(1, 2)", "3"},
+		{"`-`(5)", "-5"},
+		{"`!`(TRUE)", "FALSE"},
+		{"`&&`(FALSE, stop(\"lazy\"))", "FALSE"},
+		{"sapply(1:3, `*`, 2)", "2 4 6"},
+		{"sapply(1:3, \"-\", 1)", "0 1 2"},
+		{"Reduce(`+`, 1:4)", "10"},
+		{"sapply(list(1:3, 4:6), `[`, 2)", "2 5"},
+		{"sapply(list(list(a = 1), list(a = 2)), `[[`, \"a\")", "1 2"},
+		{"`[`(matrix(1:4, 2), 2, 2)", "4"},
+		{"`+.money` <- function(e1, e2) \"added\"; `+`(structure(1, class = \"money\"), 1)", `"added"`},
+		{"2 ** 3", "8"},
+		{"-2^2", "-4"},
+		{"2 * 5 %% 3", "4"},
+		{"!TRUE == FALSE", "TRUE"},
+		{"f <- function() 5 ->> g; f(); g", "5"},
+		{"base::sum(1:3)", "6"},
+		{"sum <- function(...) 0; base::sum(1, 2)", "3"},
+		{"stats:::sd(c(1, 3, 5))", "2"},
+		{"x <- structure(1:3, unit = \"cm\"); x@unit", `"cm"`},
+		{"x <- 1:3; x@unit <- \"m\"; attr(x, \"unit\")", `"m"`},
+		{"f <- y ~ x + z; class(f)", `"formula"`},
+		{"f <- y ~ x; deparse(f)", `"y ~ x"`},
+		{"f <- ~ x; length(f)", "2"},
+		{"f <- y ~ x; identical(environment(f), globalenv())", "TRUE"},
+		{"quote(a %||% b)[[1]]", "`%||%`"},
+		{"h <- function(x) substitute(x); deparse(do.call(h, list(c(1.5, 2))))", `"c(1.5, 2)"`},
+		{"g <- function(a, b) match.call(); deparse(do.call(g, list(1:3, \"x\"))[[3]])", `"\"x\""`},
+	}
+
+	for _, tt := range tests {
+		ctx := NewContext()
+		res, err := ctx.EvalString(tt.input)
+		if err != nil {
+			t.Errorf("input %q: unexpected error: %v", tt.input, err)
+			continue
+		}
+		if res.Value.String() != tt.expected {
+			t.Errorf("input %q: expected %s, got %s", tt.input, tt.expected, res.Value.String())
+		}
+	}
+}
+
+func TestOperatorOutput(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"print(y ~ x)", "y ~ x\n"},
+		{"?mean", "No documentation for 'mean' in specified packages and libraries\n"},
+	}
+
+	for _, tt := range tests {
+		ctx := NewContext()
+		res, err := ctx.EvalString(tt.input)
+		if err != nil {
+			t.Errorf("input %q: unexpected error: %v", tt.input, err)
+			continue
+		}
+		if res.Output != tt.expected {
+			t.Errorf("input %q: expected output %q, got %q", tt.input, tt.expected, res.Output)
+		}
+	}
+}
+
+func TestOperandExprLazy(t *testing.T) {
+	// deparsing the operands of every call through `+` made Reduce over
+	// long vectors slow; the text is only built when it is printed
+	res, err := NewContext().EvalString("1:3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	e, ok := operandExpr(res.Value).(*ast.ValueExpr)
+	if !ok {
+		t.Fatalf("operandExpr returned %T", operandExpr(res.Value))
+	}
+	if e.Text != "" {
+		t.Errorf("operand deparsed eagerly: %q", e.Text)
+	}
+	if got := e.Source(); got != "1:3" {
+		t.Errorf("expected 1:3, got %q", got)
+	}
+}
+
+func TestOperatorErrors(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"1 %nope% 2", `could not find function "%nope%"`},
+		{"foo::bar", "there is no package called 'foo'"},
+		{"base::nothere", "'nothere' is not an exported object from 'namespace:base'"},
+		{"x <- 1; x@a", `no slot of name "a" for this object of class "numeric"`},
+	}
+
+	for _, tt := range tests {
+		ctx := NewContext()
+		_, err := ctx.EvalString(tt.input)
+		if err == nil {
+			t.Errorf("input %q: expected error", tt.input)
+			continue
+		}
+		if err.Error() != tt.expected {
+			t.Errorf("input %q: expected %q, got %q", tt.input, tt.expected, err.Error())
+		}
+	}
+}
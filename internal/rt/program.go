@@ -0,0 +1,59 @@
+package rt
+
+import (
+	"context"
+
+	"simonwaldherr.de/go/smallr/internal/ast"
+	"simonwaldherr.de/go/smallr/internal/parser"
+)
+
+// Program is a parsed script that can be run many times without parsing
+// it again. It embeds the syntax tree, so Exprs and String are those of the
+// parsed program. Running a program does not change it, so one program may
+// be run from several goroutines at once, each with its own Context.
+type Program struct {
+	*ast.Program
+	name string
+}
+
+// Compile parses src into a Program. Syntax errors are returned as a
+// parser.ErrorList, as by EvalString.
+func Compile(src string) (*Program, error) {
+	return CompileScript("", src)
+}
+
+// CompileScript is like Compile; runtime errors of the program name the
+// script in their position, as with EvalScript.
+func CompileScript(name, src string) (*Program, error) {
+	prog, err := parser.New(src).ParseProgram()
+	if err != nil {
+		return nil, err
+	}
+	return &Program{Program: prog, name: name}, nil
+}
+
+// Name returns the script name the program was compiled with.
+func (p *Program) Name() string { return p.name }
+
+// Run evaluates the program in a new environment whose parent is the
+// global environment of ctx, with the given bindings as its variables.
+// Top-level assignments go to this environment and are dropped after the
+// run, so runs do not see each other's variables; `<<-` still reaches the
+// global environment.
+func (p *Program) Run(ctx *Context, bindings map[string]Value) (EvalResult, error) {
+	env := NewEnv(ctx.Global)
+	for name, v := range bindings {
+		if v == nil {
+			v = NullValue
+		}
+		env.SetLocal(name, v)
+	}
+	return ctx.run(p.name, env, p.Exprs)
+}
+
+// RunContext is Run with cancellation as in EvalStringContext: the run
+// stops with an error wrapping ErrInterrupted once c is done.
+func (p *Program) RunContext(c context.Context, ctx *Context, bindings map[string]Value) (EvalResult, error) {
+	defer ctx.withInterrupt(c)()
+	return p.Run(ctx, bindings)
+}
@@ -0,0 +1,123 @@
+package rt
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+)
+
+func TestProgramRun(t *testing.T) {
+	prog, err := Compile("y <- x * 2\ncat(y, \"\\n\")\nsum(y) + offset")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the syntax tree stays available, as from ParseProgram
+	if len(prog.Exprs) != 3 || prog.Exprs[0].String() != "(y <- (x * 2))" {
+		t.Errorf("unexpected expressions %q", prog.String())
+	}
+	ctx := NewContext()
+	if _, err := ctx.EvalString("offset <- 100"); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		x        Value
+		expected string
+		output   string
+	}{
+		{doubleVecOf([]float64{1, 2, 3}), "112", "2 4 6 \n"},
+		{IntScalar(5), "110", "10 \n"},
+	}
+	for _, tt := range tests {
+		res, err := prog.Run(ctx, map[string]Value{"x": tt.x})
+		if err != nil {
+			t.Errorf("x = %s: %v", tt.x.String(), err)
+			continue
+		}
+		if got := res.Value.String(); got != tt.expected {
+			t.Errorf("x = %s: expected %s, got %s", tt.x.String(), tt.expected, got)
+		}
+		if res.Output != tt.output {
+			t.Errorf("x = %s: expected output %q, got %q", tt.x.String(), tt.output, res.Output)
+		}
+	}
+
+	// top-level assignments stay in the run's environment
+	for _, name := range []string{"x", "y"} {
+		if _, ok := ctx.Global.Get(name); ok {
+			t.Errorf("%s leaked into the global environment", name)
+		}
+	}
+	if _, err := prog.Run(ctx, nil); err == nil || err.Error() != "object 'x' not found" {
+		t.Errorf("run without bindings: expected object 'x' not found, got %v", err)
+	}
+
+	global, err := Compile("n <<- n + 1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx.Global.SetLocal("n", IntScalar(0))
+	for i := 0; i < 3; i++ {
+		if _, err := global.Run(ctx, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if v, _ := ctx.Global.Get("n"); v.String() != "3" {
+		t.Errorf("<<-: expected n = 3, got %s", v.String())
+	}
+}
+
+func TestProgramErrors(t *testing.T) {
+	if _, err := Compile("x <- (1 +"); err == nil {
+		t.Errorf("Compile: expected a syntax error")
+	}
+	prog, err := CompileScript("score.R", "z <- 1\nstop(\"bad input\")")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if prog.Name() != "score.R" {
+		t.Errorf("expected name score.R, got %q", prog.Name())
+	}
+	res, err := prog.Run(NewContext(), nil)
+	if err == nil || res.Error == nil {
+		t.Fatalf("expected a RuntimeError, got %v", err)
+	}
+	if got := res.Error.Report(); got != "Error: bad input (score.R:2:1)" {
+		t.Errorf("unexpected report %q", got)
+	}
+	if res.Value.String() != "1" {
+		t.Errorf("expected the last value before the error, got %s", res.Value.String())
+	}
+}
+
+func TestProgramConcurrent(t *testing.T) {
+	prog, err := Compile("f <- function(v) sum(v^2)\nf(seq_len(n)) + length(letters)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var wg sync.WaitGroup
+	errs := make(chan error, 8)
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			ctx := NewContext()
+			for n := 1; n <= 20; n++ {
+				res, err := prog.Run(ctx, map[string]Value{"n": IntScalar(int64(n + g))})
+				if err != nil {
+					errs <- err
+					return
+				}
+				m := n + g
+				if want := fmt.Sprint(m*(m+1)*(2*m+1)/6 + 26); res.Value.String() != want {
+					errs <- fmt.Errorf("n = %d: expected %s, got %s", m, want, res.Value.String())
+					return
+				}
+			}
+		}(g)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+}
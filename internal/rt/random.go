@@ -0,0 +1,1448 @@
+package rt
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"time"
+)
+
+// Random number generation. The generators reproduce GNU R's defaults
+// (RNGkind "Mersenne-Twister", "Inversion", "Rejection"), so a script
+// that calls set.seed() draws the same numbers as it does in R. The
+// state lives on the Context: every context is an independent stream.
+
+func installRandomBuiltins(env *Env) {
+	builtins := map[string]*BuiltinFunc{
+		"set.seed":   {FnName: "set.seed", Impl: builtinSetSeed},
+		"RNGkind":    {FnName: "RNGkind", Impl: builtinRNGkind},
+		"runif":      {FnName: "runif", Impl: builtinRunif},
+		"rnorm":      {FnName: "rnorm", Impl: builtinRnorm},
+		"rexp":       {FnName: "rexp", Impl: builtinRexp},
+		"rbinom":     {FnName: "rbinom", Impl: builtinRbinom},
+		"rpois":      {FnName: "rpois", Impl: builtinRpois},
+		"rgamma":     {FnName: "rgamma", Impl: builtinRgamma},
+		"rbeta":      {FnName: "rbeta", Impl: builtinRbeta},
+		"rchisq":     {FnName: "rchisq", Impl: builtinRchisq},
+		"rt":         {FnName: "rt", Impl: builtinRt},
+		"rf":         {FnName: "rf", Impl: builtinRf},
+		"sample":     {FnName: "sample", Impl: builtinSample},
+		"sample.int": {FnName: "sample.int", Impl: builtinSampleInt},
+	}
+	for name, fn := range builtins {
+		env.SetLocal(name, fn)
+	}
+}
+
+const (
+	mtN       = 624
+	mtM       = 397
+	mtMatrixA = 0x9908b0df
+	mtUpper   = 0x80000000
+	mtLower   = 0x7fffffff
+
+	// i2_32m1 is 1/(2^32 - 1), used to keep uniforms inside (0, 1).
+	i2_32m1 = 2.328306437080797e-10
+)
+
+// rngState is the Mersenne-Twister state of one context.
+type rngState struct {
+	mt       [mtN]uint32
+	mti      int
+	rounding bool // sample.kind "Rounding" (R < 3.6.0) instead of "Rejection"
+}
+
+// rng returns the generator of the context, seeding it from the clock
+// on first use as R does when no seed has been set.
+func (ctx *Context) rng() *rngState {
+	if ctx.random == nil {
+		ctx.random = &rngState{}
+		ctx.random.seed(timeSeed())
+	}
+	return ctx.random
+}
+
+// timeSeed mixes the time and the process id like R's TimeToSeed.
+func timeSeed() uint32 {
+	return uint32(time.Now().UnixMicro()) ^ uint32(os.Getpid())<<16
+}
+
+// seed initialises the state the way set.seed() does: the seed is
+// scrambled by 50 rounds of a linear congruential generator, which then
+// fills the state words.
+func (r *rngState) seed(s uint32) {
+	for j := 0; j < 50; j++ {
+		s = 69069*s + 1
+	}
+	// R keeps mti as the first of its 625 seed words; it is overwritten
+	// with N below, so the first draw regenerates the whole block.
+	s = 69069*s + 1
+	for j := range r.mt {
+		s = 69069*s + 1
+		r.mt[j] = s
+	}
+	r.mti = mtN
+}
+
+// genrand returns the next tempered 32-bit output scaled to [0, 1).
+func (r *rngState) genrand() float64 {
+	if r.mti >= mtN {
+		mag01 := [2]uint32{0, mtMatrixA}
+		var y uint32
+		kk := 0
+		for ; kk < mtN-mtM; kk++ {
+			y = r.mt[kk]&mtUpper | r.mt[kk+1]&mtLower
+			r.mt[kk] = r.mt[kk+mtM] ^ y>>1 ^ mag01[y&1]
+		}
+		for ; kk < mtN-1; kk++ {
+			y = r.mt[kk]&mtUpper | r.mt[kk+1]&mtLower
+			r.mt[kk] = r.mt[kk+mtM-mtN] ^ y>>1 ^ mag01[y&1]
+		}
+		y = r.mt[mtN-1]&mtUpper | r.mt[0]&mtLower
+		r.mt[mtN-1] = r.mt[mtM-1] ^ y>>1 ^ mag01[y&1]
+		r.mti = 0
+	}
+	y := r.mt[r.mti]
+	r.mti++
+	y ^= y >> 11
+	y ^= y << 7 & 0x9d2c5680
+	y ^= y << 15 & 0xefc60000
+	y ^= y >> 18
+	return float64(y) * 2.3283064365386963e-10
+}
+
+// unif returns a uniform deviate strictly inside (0, 1).
+func (r *rngState) unif() float64 {
+	u := r.genrand()
+	switch {
+	case u <= 0:
+		return 0.5 * i2_32m1
+	case 1-u <= 0:
+		return 1 - 0.5*i2_32m1
+	}
+	return u
+}
+
+// norm returns a standard normal deviate by inversion. A single uniform
+// has only 32 bits, so two are combined before calling qnorm.
+func (r *rngState) norm() float64 {
+	const big = 134217728 // 2^27
+	u := r.unif()
+	u = float64(int(big*u)) + r.unif()
+	return qnormFn(u/big, 0, 1, true, false)
+}
+
+// expQ holds the partial sums of log(2)^k / k! used by exp.
+var expQ = [...]float64{
+	0.6931471805599453,
+	0.9333736875190459,
+	0.9888777961838675,
+	0.9984959252914960040,
+	0.9998292811061389,
+	0.9999833164100727,
+	0.9999985508193531,
+	0.9999998906925558,
+	0.9999999924734159,
+	0.9999999995283275,
+	0.9999999999728814,
+	0.9999999999985598,
+	0.9999999999999289,
+	0.9999999999999968,
+	0.9999999999999999,
+	1.0000000000000000,
+}
+
+// exp returns a standard exponential deviate using the algorithm of
+// Ahrens & Dieter (1972), as R's exp_rand does.
+func (r *rngState) exp() float64 {
+	a := 0.0
+	u := r.unif()
+	for {
+		u += u
+		if u > 1 {
+			break
+		}
+		a += expQ[0]
+	}
+	u--
+	if u <= expQ[0] {
+		return a + u
+	}
+	i := 0
+	umin := r.unif()
+	for {
+		ustar := r.unif()
+		if umin > ustar {
+			umin = ustar
+		}
+		i++
+		if u <= expQ[i] {
+			break
+		}
+	}
+	return a + umin*expQ[0]
+}
+
+// bits returns a uniform integer below 2^n, built from 16-bit chunks.
+func (r *rngState) bits(n int) float64 {
+	var v int64
+	for k := 0; k <= n; k += 16 {
+		v = 65536*v + int64(math.Floor(r.unif()*65536))
+	}
+	return float64(v & (int64(1)<<n - 1))
+}
+
+// index returns a uniform integer in [0, dn). The "Rejection" kind draws
+// just enough bits and retries until the value is in range, which avoids
+// the bias of scaling a single uniform.
+func (r *rngState) index(dn float64) float64 {
+	if r.rounding {
+		return math.Floor(dn * r.unif())
+	}
+	if dn <= 0 {
+		return 0
+	}
+	n := int(math.Ceil(math.Log2(dn)))
+	for {
+		if v := r.bits(n); v < dn {
+			return v
+		}
+	}
+}
+
+// binom returns a binomial deviate using the inversion and BTPE
+// algorithms of Kachitvichyanukul & Schmeiser (1988), following R's
+// rbinom exactly so that seeded streams agree.
+func (r *rngState) binom(nin, pp float64) float64 {
+	if math.IsNaN(nin) || math.IsInf(nin, 0) || math.IsNaN(pp) {
+		return math.NaN()
+	}
+	rn := math.Round(nin)
+	if rn != nin || rn < 0 || pp < 0 || pp > 1 || rn > math.MaxInt32 {
+		return math.NaN()
+	}
+	if rn == 0 || pp == 0 {
+		return 0
+	}
+	if pp == 1 {
+		return rn
+	}
+	n := int(rn)
+	p := math.Min(pp, 1-pp)
+	q := 1 - p
+	np := float64(n) * p
+	rr := p / q
+	g := rr * float64(n+1)
+
+	var ix int
+	if np < 30 {
+		qn := powDi(q, n)
+		for {
+			ix = 0
+			f := qn
+			u := r.unif()
+			for {
+				if u < f {
+					goto finis
+				}
+				if ix > 110 {
+					break
+				}
+				u -= f
+				ix++
+				f *= g/float64(ix) - rr
+			}
+		}
+	}
+	{
+		fm := np + p
+		m := int(fm)
+		npq := np * q
+		p1 := float64(int(2.195*math.Sqrt(npq)-4.6*q)) + 0.5
+		xm := float64(m) + 0.5
+		xl := xm - p1
+		xr := xm + p1
+		c := 0.134 + 20.5/(15.3+float64(m))
+		al := (fm - xl) / (fm - xl*p)
+		xll := al * (1 + 0.5*al)
+		al = (xr - fm) / (xr * q)
+		xlr := al * (1 + 0.5*al)
+		p2 := p1 * (1 + c + c)
+		p3 := p2 + c/xll
+		p4 := p3 + c/xlr
+		for {
+			u := r.unif() * p4
+			v := r.unif()
+			if u <= p1 {
+				ix = int(xm - p1*v + u)
+				goto finis
+			}
+			if u <= p2 {
+				x := xl + (u-p1)/c
+				v = v*c + 1 - math.Abs(xm-x)/p1
+				if v > 1 || v <= 0 {
+					continue
+				}
+				ix = int(x)
+			} else if u > p3 {
+				ix = int(xr - math.Log(v)/xlr)
+				if ix > n {
+					continue
+				}
+				v = v * (u - p3) * xlr
+			} else {
+				ix = int(xl + math.Log(v)/xll)
+				if ix < 0 {
+					continue
+				}
+				v = v * (u - p2) * xll
+			}
+			k := ix - m
+			if k < 0 {
+				k = -k
+			}
+			if k <= 20 || float64(k) >= npq/2-1 {
+				f := 1.0
+				if m < ix {
+					for i := m + 1; i <= ix; i++ {
+						f *= g/float64(i) - rr
+					}
+				} else if m > ix {
+					for i := ix + 1; i <= m; i++ {
+						f /= g/float64(i) - rr
+					}
+				}
+				if v <= f {
+					goto finis
+				}
+				continue
+			}
+			fk := float64(k)
+			amaxp := (fk / npq) * ((fk*(fk/3+0.625)+0.1666666666666)/npq + 0.5)
+			ynorm := -fk * fk / (2 * npq)
+			alv := math.Log(v)
+			if alv < ynorm-amaxp {
+				goto finis
+			}
+			if alv <= ynorm+amaxp {
+				x1 := float64(ix + 1)
+				f1 := fm + 1
+				z := float64(n+1) - fm
+				w := float64(n-ix) + 1
+				if alv <= xm*math.Log(f1/x1)+(float64(n-m)+0.5)*math.Log(z/w)+float64(ix-m)*math.Log(w*p/(x1*q))+
+					stirlingTerm(f1)+stirlingTerm(z)+stirlingTerm(x1)+stirlingTerm(w) {
+					goto finis
+				}
+			}
+		}
+	}
+finis:
+	if pp > 0.5 {
+		ix = n - ix
+	}
+	return float64(ix)
+}
+
+// powDi raises x to the integer power n by repeated squaring, rounding
+// the same way as R's R_pow_di.
+func powDi(x float64, n int) float64 {
+	pow := 1.0
+	for {
+		if n&1 != 0 {
+			pow *= x
+		}
+		if n >>= 1; n == 0 {
+			return pow
+		}
+		x *= x
+	}
+}
+
+// stirlingTerm is one correction term of de Moivre's formula in binom.
+func stirlingTerm(x float64) float64 {
+	x2 := x * x
+	return (13860 - (462-(132-(99-140/x2)/x2)/x2)/x2) / x / 166320
+}
+
+// poisFact holds 0! through 9! for pois.
+var poisFact = [...]float64{1, 1, 2, 6, 24, 120, 720, 5040, 40320, 362880}
+
+// pois returns a Poisson deviate: table lookup by inversion for mu < 10
+// and the PD algorithm of Ahrens & Dieter (1982) otherwise, as in R's
+// rpois.
+func (r *rngState) pois(mu float64) float64 {
+	const (
+		a0 = -0.5
+		a1 = 0.3333333
+		a2 = -0.2500068
+		a3 = 0.2000118
+		a4 = -0.1661269
+		a5 = 0.1421878
+		a6 = -0.1384794
+		a7 = 0.1250060
+
+		one7  = 0.1428571428571428571
+		one12 = 0.0833333333333333333
+		one24 = 0.0416666666666666667
+	)
+	if math.IsNaN(mu) || math.IsInf(mu, 0) || mu < 0 {
+		return math.NaN()
+	}
+	if mu <= 0 {
+		return 0
+	}
+	if mu < 10 {
+		// Case B: inversion, building the cumulative table pp on demand.
+		var pp [36]float64
+		m := int(mu)
+		if m < 1 {
+			m = 1
+		}
+		l := 0
+		p0 := math.Exp(-mu)
+		p, q := p0, p0
+		for {
+			u := r.unif()
+			if u <= p0 {
+				return 0
+			}
+			if l > 0 {
+				j := 1
+				if u > 0.458 {
+					j = min(l, m)
+				}
+				for k := j; k <= l; k++ {
+					if u <= pp[k] {
+						return float64(k)
+					}
+				}
+				if l == 35 {
+					continue
+				}
+			}
+			l++
+			for k := l; k <= 35; k++ {
+				p *= mu / float64(k)
+				q += p
+				pp[k] = q
+				if u <= q {
+					return float64(k)
+				}
+			}
+			l = 35
+		}
+	}
+
+	s := math.Sqrt(mu)
+	d := 6 * mu * mu
+	bigL := math.Floor(mu - 1.1484)
+
+	var pois, fk, difmuk, u float64
+	// Step N: normal sample, with immediate and squeeze acceptance.
+	g := mu + s*r.norm()
+	if g >= 0 {
+		pois = math.Floor(g)
+		if pois >= bigL {
+			return pois
+		}
+		fk = pois
+		difmuk = mu - fk
+		u = r.unif()
+		if d*u >= difmuk*difmuk*difmuk {
+			return pois
+		}
+	}
+
+	// Step P: coefficients of the Hermite approximation to the discrete
+	// normal probabilities.
+	omega := invSqrt2Pi / s
+	b1 := one24 / mu
+	b2 := 0.3 * b1 * b1
+	c3 := one7 * b1 * b2
+	c2 := b2 - 15*c3
+	c1 := b1 - 6*b2 + 45*c3
+	c0 := 1 - b1 + 3*b2 - 15*c3
+	c := 0.1069 / mu
+
+	// procF computes px, py, fx and fy for the current pois.
+	procF := func() (px, py, fx, fy float64) {
+		if pois < 10 {
+			px = -mu
+			py = math.Pow(mu, pois) / poisFact[int(pois)]
+		} else {
+			del := one12 / fk
+			del = del * (1 - 4.8*del*del)
+			v := difmuk / fk
+			if math.Abs(v) <= 0.25 {
+				px = fk*v*v*(((((((a7*v+a6)*v+a5)*v+a4)*v+a3)*v+a2)*v+a1)*v+a0) - del
+			} else {
+				px = fk*math.Log(1+v) - difmuk - del
+			}
+			py = invSqrt2Pi / math.Sqrt(fk)
+		}
+		x := (0.5 - difmuk) / s
+		xx := x * x
+		fx = -0.5 * xx
+		fy = omega * (((c3*xx+c2)*xx+c1)*xx + c0)
+		return
+	}
+
+	if g >= 0 {
+		// Step Q: quotient acceptance.
+		px, py, fx, fy := procF()
+		if fy-u*fy <= py*math.Exp(px-fx) {
+			return pois
+		}
+	}
+	for {
+		// Step E: double exponential sample from the Laplace hat.
+		e := r.exp()
+		u = 2*r.unif() - 1
+		t := 1.8 + math.Copysign(e, u)
+		if t <= -0.6744 {
+			continue
+		}
+		pois = math.Floor(mu + s*t)
+		fk = pois
+		difmuk = mu - fk
+		// Step H: hat acceptance.
+		px, py, fx, fy := procF()
+		if c*math.Abs(u) <= py*math.Exp(px+e)-fy*math.Exp(fx+e) {
+			return pois
+		}
+	}
+}
+
+// gamma returns a gamma deviate with the given shape and scale: the GS
+// algorithm of Ahrens & Dieter (1974) for shape < 1 and their GD
+// algorithm (1982) otherwise, as in R's rgamma.
+func (r *rngState) gamma(a, scale float64) float64 {
+	const (
+		sqrt32 = 5.656854
+		expM1  = 0.36787944117144233 // exp(-1)
+
+		// q0 = sum(q[k] * a^-k), q = q0 + t*t/2 * sum(a[k] * v^k)
+		q1, q2, q3, q4, q5, q6, q7 = 0.04166669, 0.02083148, 0.00801191, 0.00144121, -7.388e-5, 2.4511e-4, 2.424e-4
+		a1, a2, a3, a4, a5, a6, a7 = 0.3333333, -0.250003, 0.2000062, -0.1662921, 0.1423657, -0.1367177, 0.1233795
+	)
+	if math.IsNaN(a) || math.IsNaN(scale) {
+		return math.NaN()
+	}
+	if a <= 0 || scale <= 0 {
+		if scale == 0 || a == 0 {
+			return 0
+		}
+		return math.NaN()
+	}
+	if math.IsInf(a, 0) || math.IsInf(scale, 0) {
+		return math.Inf(1)
+	}
+
+	if a < 1 {
+		e := 1 + expM1*a
+		for {
+			p := e * r.unif()
+			if p >= 1 {
+				x := -math.Log((e - p) / a)
+				if r.exp() >= (1-a)*math.Log(x) {
+					return scale * x
+				}
+			} else {
+				x := math.Exp(math.Log(p) / a)
+				if r.exp() >= x {
+					return scale * x
+				}
+			}
+		}
+	}
+
+	// Step 1: constants of the normal approximation.
+	s2 := a - 0.5
+	s := math.Sqrt(s2)
+	d := sqrt32 - s*12
+
+	// Step 2: t is standard normal, x is (s, 1/2)-normal; immediate
+	// acceptance.
+	t := r.norm()
+	x := s + 0.5*t
+	if t >= 0 {
+		return scale * x * x
+	}
+
+	// Step 3: squeeze acceptance.
+	u := r.unif()
+	if d*u <= t*t*t {
+		return scale * x * x
+	}
+
+	// Step 4: constants of the quotient test, fitted by Ahrens & Dieter.
+	rr := 1 / a
+	q0 := ((((((q7*rr+q6)*rr+q5)*rr+q4)*rr+q3)*rr+q2)*rr + q1) * rr
+	var b, si, c float64
+	switch {
+	case a <= 3.686:
+		b = 0.463 + s + 0.178*s2
+		si = 1.235
+		c = 0.195/s - 0.079 + 0.16*s
+	case a <= 13.022:
+		b = 1.654 + 0.0076*s2
+		si = 1.68/s + 0.275
+		c = 0.062/s + 0.024
+	default:
+		b = 1.77
+		si = 0.75
+		c = 0.1515 / s
+	}
+	quotient := func(t float64) float64 {
+		v := t / (s + s)
+		if math.Abs(v) <= 0.25 {
+			return q0 + 0.5*t*t*((((((a7*v+a6)*v+a5)*v+a4)*v+a3)*v+a2)*v+a1)*v
+		}
+		return q0 - s*t + 0.25*t*t + (s2+s2)*math.Log(1+v)
+	}
+
+	// Steps 5 to 7: quotient acceptance, if x is positive.
+	if x > 0 && math.Log(1-u) <= quotient(t) {
+		return scale * x * x
+	}
+
+	// Steps 8 to 11: double exponential rejection.
+	for {
+		e := r.exp()
+		u = r.unif()
+		u = u + u - 1
+		if u < 0 {
+			t = b - si*e
+		} else {
+			t = b + si*e
+		}
+		if t >= -0.71874483771719 {
+			q := quotient(t)
+			if q > 0 && c*math.Abs(u) <= math.Expm1(q)*math.Exp(e-0.5*t*t) {
+				break
+			}
+		}
+	}
+	x = s + 0.5*t
+	return scale * x * x
+}
+
+// chisq returns a chi-squared deviate, a gamma deviate of shape df/2.
+func (r *rngState) chisq(df float64) float64 {
+	if math.IsNaN(df) || math.IsInf(df, 0) || df < 0 {
+		return math.NaN()
+	}
+	return r.gamma(df/2, 2)
+}
+
+// t returns a deviate of Student's t with df degrees of freedom.
+func (r *rngState) t(df float64) float64 {
+	if math.IsNaN(df) || df <= 0 {
+		return math.NaN()
+	}
+	if math.IsInf(df, 1) {
+		return r.norm()
+	}
+	num := r.norm()
+	return num / math.Sqrt(r.chisq(df)/df)
+}
+
+// f returns a deviate of the F distribution with m and n degrees of
+// freedom, the ratio of two scaled chi-squared deviates.
+func (r *rngState) f(m, n float64) float64 {
+	if math.IsNaN(m) || math.IsNaN(n) || m <= 0 || n <= 0 {
+		return math.NaN()
+	}
+	num, den := 1.0, 1.0
+	if !math.IsInf(m, 1) {
+		num = r.chisq(m) / m
+	}
+	if !math.IsInf(n, 1) {
+		den = r.chisq(n) / n
+	}
+	return num / den
+}
+
+// beta returns a beta deviate using the algorithms BB (both shapes above
+// 1) and BC of Cheng (1978), as in R's rbeta.
+func (r *rngState) beta(aa, bb float64) float64 {
+	const expmax = 1024 * math.Ln2 // log(DBL_MAX)
+	switch {
+	case math.IsNaN(aa) || math.IsNaN(bb) || aa < 0 || bb < 0:
+		return math.NaN()
+	case math.IsInf(aa, 1) && math.IsInf(bb, 1):
+		return 0.5
+	case aa == 0 && bb == 0:
+		if r.unif() < 0.5 {
+			return 0
+		}
+		return 1
+	case math.IsInf(aa, 1) || bb == 0:
+		return 1
+	case math.IsInf(bb, 1) || aa == 0:
+		return 0
+	}
+
+	a, b := math.Min(aa, bb), math.Max(aa, bb)
+	alpha := a + b
+	var beta, v, w float64
+	// vw sets v = beta * logit(u1) and w = scale * exp(v)
+	vw := func(u1, scale float64) {
+		v = beta * math.Log(u1/(1-u1))
+		if v <= expmax {
+			w = scale * math.Exp(v)
+			if math.IsInf(w, 1) {
+				w = math.MaxFloat64
+			}
+		} else {
+			w = math.MaxFloat64
+		}
+	}
+
+	if a <= 1 {
+		// algorithm BC
+		beta = 1 / a
+		delta := 1 + b - a
+		k1 := delta * (0.0138889 + 0.0416667*a) / (b*beta - 0.777778)
+		k2 := 0.25 + (0.5+0.25/delta)*a
+		for {
+			u1, u2 := r.unif(), r.unif()
+			var z float64
+			if u1 < 0.5 {
+				y := u1 * u2
+				z = u1 * y
+				if 0.25*u2+z-y >= k1 {
+					continue
+				}
+			} else {
+				z = u1 * u1 * u2
+				if z <= 0.25 {
+					vw(u1, b)
+					break
+				}
+				if z >= k2 {
+					continue
+				}
+			}
+			vw(u1, b)
+			if alpha*(math.Log(alpha/(a+w))+v)-1.3862944 >= math.Log(z) {
+				break
+			}
+		}
+		if aa == a {
+			return a / (a + w)
+		}
+		return w / (a + w)
+	}
+
+	// algorithm BB
+	beta = math.Sqrt((alpha - 2) / (2*a*b - alpha))
+	gamma := a + 1/beta
+	for {
+		u1, u2 := r.unif(), r.unif()
+		vw(u1, a)
+		z := u1 * u1 * u2
+		rr := gamma*v - 1.3862944
+		s := a + rr - w
+		if s+2.609438 >= 5*z {
+			break
+		}
+		t := math.Log(z)
+		if s > t || rr+alpha*math.Log(alpha/(b+w)) >= t {
+			break
+		}
+	}
+	if aa != a {
+		return b / (b + w)
+	}
+	return w / (b + w)
+}
+
+// revsort sorts a into descending order by heapsort, permuting ib
+// alongside. Ties end up in the same order as in R, which matters for
+// reproducing weighted samples.
+func revsort(a []float64, ib []int) {
+	n := len(a)
+	if n <= 1 {
+		return
+	}
+	// one-based views as in the original algorithm
+	at := func(i int) *float64 { return &a[i-1] }
+	bt := func(i int) *int { return &ib[i-1] }
+	l := n>>1 + 1
+	ir := n
+	for {
+		var ra float64
+		var ii int
+		if l > 1 {
+			l--
+			ra, ii = *at(l), *bt(l)
+		} else {
+			ra, ii = *at(ir), *bt(ir)
+			*at(ir), *bt(ir) = *at(1), *bt(1)
+			ir--
+			if ir == 1 {
+				*at(1), *bt(1) = ra, ii
+				return
+			}
+		}
+		i := l
+		j := l << 1
+		for j <= ir {
+			if j < ir && *at(j) > *at(j + 1) {
+				j++
+			}
+			if ra > *at(j) {
+				*at(i), *bt(i) = *at(j), *bt(j)
+				i = j
+				j += j
+			} else {
+				j = ir + 1
+			}
+		}
+		*at(i), *bt(i) = ra, ii
+	}
+}
+
+// drawParams reads the parameters of an r* function: n (or the length
+// of n when it is a vector) and the parameter vectors, which are
+// recycled. Parameters without a default are marked by NaN in defaults.
+func drawParams(ctx *Context, args []ArgValue, formals []string, defaults []float64) (int, [][]float64, error) {
+	fargs, err := forceArgs(ctx, args)
+	if err != nil {
+		return 0, nil, err
+	}
+	m, _ := matchArgs(fargs, formals...)
+	n, err := drawCount(ctx, m[0])
+	if err != nil {
+		return 0, nil, err
+	}
+	params := make([][]float64, len(defaults))
+	for i, def := range defaults {
+		v := m[i+1]
+		if v == nil {
+			if math.IsNaN(def) {
+				return 0, nil, fmt.Errorf("argument \"%s\" is missing, with no default", formals[i+1])
+			}
+			params[i] = []float64{def}
+			continue
+		}
+		fv, err := asDoubleVec(ctx, v)
+		if err != nil {
+			return 0, nil, fmt.Errorf("invalid arguments")
+		}
+		params[i] = make([]float64, len(fv))
+		for k, f := range fv {
+			params[i][k] = f.Val
+			if f.NA {
+				params[i][k] = math.NaN()
+			}
+		}
+	}
+	return n, params, nil
+}
+
+// drawCount interprets the n argument of the r* functions.
+func drawCount(ctx *Context, v Value) (int, error) {
+	if v == nil {
+		return 0, fmt.Errorf("argument \"n\" is missing, with no default")
+	}
+	if v.Len() > 1 {
+		return v.Len(), nil
+	}
+	f, err := asFloatElem(ctx, v)
+	if err != nil || f.NA || f.Val < 0 || math.IsInf(f.Val, 0) {
+		return 0, fmt.Errorf("invalid arguments")
+	}
+	return int(f.Val), nil
+}
+
+// draw fills n deviates from gen, recycling the parameter vectors, and
+// warns if any came out NaN.
+func draw(ctx *Context, n int, params [][]float64, gen func(r *rngState, p []float64) float64) ([]float64, error) {
+	r := ctx.rng()
+	out := make([]float64, n)
+	p := make([]float64, len(params))
+	nan := false
+	for i := range out {
+		for k, pv := range params {
+			if len(pv) == 0 {
+				p[k] = math.NaN()
+			} else {
+				p[k] = pv[i%len(pv)]
+			}
+		}
+		out[i] = gen(r, p)
+		if math.IsNaN(out[i]) {
+			nan = true
+		}
+	}
+	if nan {
+		if err := ctx.warn("NAs produced"); err != nil {
+			return nil, err
+		}
+	}
+	return out, nil
+}
+
+func doubleDraws(x []float64) Value {
+	out := &DoubleVec{Data: make([]FloatElem, len(x))}
+	for i, v := range x {
+		out.Data[i] = FloatElem{Val: v}
+	}
+	return out
+}
+
+func intDraws(x []float64) Value {
+	out := &IntVec{Data: make([]IntElem, len(x))}
+	for i, v := range x {
+		if math.IsNaN(v) {
+			out.Data[i] = IntElem{NA: true}
+			continue
+		}
+		out.Data[i] = IntElem{Val: int64(v)}
+	}
+	return out
+}
+
+func builtinRunif(ctx *Context, args []ArgValue) (Value, error) {
+	n, params, err := drawParams(ctx, args, []string{"n", "min", "max"}, []float64{0, 1})
+	if err != nil {
+		return nil, err
+	}
+	x, err := draw(ctx, n, params, func(r *rngState, p []float64) float64 {
+		a, b := p[0], p[1]
+		if math.IsNaN(a) || math.IsNaN(b) || math.IsInf(a, 0) || math.IsInf(b, 0) || b < a {
+			return math.NaN()
+		}
+		if a == b {
+			return a
+		}
+		return a + (b-a)*r.unif()
+	})
+	if err != nil {
+		return nil, err
+	}
+	return doubleDraws(x), nil
+}
+
+func builtinRnorm(ctx *Context, args []ArgValue) (Value, error) {
+	n, params, err := drawParams(ctx, args, []string{"n", "mean", "sd"}, []float64{0, 1})
+	if err != nil {
+		return nil, err
+	}
+	x, err := draw(ctx, n, params, func(r *rngState, p []float64) float64 {
+		mu, sigma := p[0], p[1]
+		if math.IsNaN(mu) || math.IsNaN(sigma) || math.IsInf(sigma, 0) || sigma < 0 {
+			return math.NaN()
+		}
+		if sigma == 0 || math.IsInf(mu, 0) {
+			return mu
+		}
+		return mu + sigma*r.norm()
+	})
+	if err != nil {
+		return nil, err
+	}
+	return doubleDraws(x), nil
+}
+
+func builtinRexp(ctx *Context, args []ArgValue) (Value, error) {
+	n, params, err := drawParams(ctx, args, []string{"n", "rate"}, []float64{1})
+	if err != nil {
+		return nil, err
+	}
+	x, err := draw(ctx, n, params, func(r *rngState, p []float64) float64 {
+		scale := 1 / p[0]
+		if math.IsNaN(scale) || math.IsInf(scale, 0) || scale <= 0 {
+			if scale == 0 {
+				return 0
+			}
+			return math.NaN()
+		}
+		return scale * r.exp()
+	})
+	if err != nil {
+		return nil, err
+	}
+	return doubleDraws(x), nil
+}
+
+func builtinRbinom(ctx *Context, args []ArgValue) (Value, error) {
+	n, params, err := drawParams(ctx, args, []string{"n", "size", "prob"}, []float64{math.NaN(), math.NaN()})
+	if err != nil {
+		return nil, err
+	}
+	x, err := draw(ctx, n, params, func(r *rngState, p []float64) float64 {
+		return r.binom(p[0], p[1])
+	})
+	if err != nil {
+		return nil, err
+	}
+	return intDraws(x), nil
+}
+
+func builtinRpois(ctx *Context, args []ArgValue) (Value, error) {
+	n, params, err := drawParams(ctx, args, []string{"n", "lambda"}, []float64{math.NaN()})
+	if err != nil {
+		return nil, err
+	}
+	x, err := draw(ctx, n, params, func(r *rngState, p []float64) float64 {
+		return r.pois(p[0])
+	})
+	if err != nil {
+		return nil, err
+	}
+	return intDraws(x), nil
+}
+
+// builtinRgamma implements rgamma(n, shape, rate = 1, scale = 1/rate).
+func builtinRgamma(ctx *Context, args []ArgValue) (Value, error) {
+	fargs, err := forceArgs(ctx, args)
+	if err != nil {
+		return nil, err
+	}
+	m, _ := matchArgs(fargs, "n", "shape", "rate", "scale")
+	scale, err := gammaScale(ctx, m[2], m[3])
+	if err != nil {
+		return nil, err
+	}
+	var scaled []ArgValue
+	for i, name := range []string{"n", "shape"} {
+		if m[i] != nil {
+			scaled = append(scaled, ArgValue{Name: name, Val: m[i]})
+		}
+	}
+	scaled = append(scaled, ArgValue{Name: "scale", Val: scale})
+	n, params, err := drawParams(ctx, scaled, []string{"n", "shape", "scale"}, []float64{math.NaN(), 1})
+	if err != nil {
+		return nil, err
+	}
+	x, err := draw(ctx, n, params, func(r *rngState, p []float64) float64 {
+		return r.gamma(p[0], p[1])
+	})
+	if err != nil {
+		return nil, err
+	}
+	return doubleDraws(x), nil
+}
+
+func builtinRbeta(ctx *Context, args []ArgValue) (Value, error) {
+	n, params, err := drawParams(ctx, args, []string{"n", "shape1", "shape2"}, []float64{math.NaN(), math.NaN()})
+	if err != nil {
+		return nil, err
+	}
+	x, err := draw(ctx, n, params, func(r *rngState, p []float64) float64 {
+		return r.beta(p[0], p[1])
+	})
+	if err != nil {
+		return nil, err
+	}
+	return doubleDraws(x), nil
+}
+
+func builtinRchisq(ctx *Context, args []ArgValue) (Value, error) {
+	n, params, err := drawParams(ctx, args, []string{"n", "df"}, []float64{math.NaN()})
+	if err != nil {
+		return nil, err
+	}
+	x, err := draw(ctx, n, params, func(r *rngState, p []float64) float64 {
+		return r.chisq(p[0])
+	})
+	if err != nil {
+		return nil, err
+	}
+	return doubleDraws(x), nil
+}
+
+func builtinRt(ctx *Context, args []ArgValue) (Value, error) {
+	n, params, err := drawParams(ctx, args, []string{"n", "df"}, []float64{math.NaN()})
+	if err != nil {
+		return nil, err
+	}
+	x, err := draw(ctx, n, params, func(r *rngState, p []float64) float64 {
+		return r.t(p[0])
+	})
+	if err != nil {
+		return nil, err
+	}
+	return doubleDraws(x), nil
+}
+
+func builtinRf(ctx *Context, args []ArgValue) (Value, error) {
+	n, params, err := drawParams(ctx, args, []string{"n", "df1", "df2"}, []float64{math.NaN(), math.NaN()})
+	if err != nil {
+		return nil, err
+	}
+	x, err := draw(ctx, n, params, func(r *rngState, p []float64) float64 {
+		return r.f(p[0], p[1])
+	})
+	if err != nil {
+		return nil, err
+	}
+	return doubleDraws(x), nil
+}
+
+// builtinSample implements sample(x, size, replace = FALSE, prob = NULL).
+// A single number x >= 1 samples from 1:x, anything else samples the
+// elements of x.
+func builtinSample(ctx *Context, args []ArgValue) (Value, error) {
+	fargs, err := forceArgs(ctx, args)
+	if err != nil {
+		return nil, err
+	}
+	m, _ := matchArgs(fargs, "x", "size", "replace", "prob")
+	x := m[0]
+	if x == nil {
+		return nil, fmt.Errorf("argument \"x\" is missing, with no default")
+	}
+	if x.Len() == 1 && isNumericValue(x) {
+		if f, err := asFloatElem(ctx, x); err == nil && !f.NA && !math.IsInf(f.Val, 0) && f.Val >= 1 {
+			return sampleInt(ctx, int(f.Val), m[1], m[2], m[3])
+		}
+	}
+	idx, err := sampleInt(ctx, x.Len(), m[1], m[2], m[3])
+	if err != nil {
+		return nil, err
+	}
+	return subsetIndexed(ctx, x, []Value{idx}, indexOpts{}, false)
+}
+
+// builtinSampleInt implements sample.int(n, size = n, replace = FALSE,
+// prob = NULL).
+func builtinSampleInt(ctx *Context, args []ArgValue) (Value, error) {
+	fargs, err := forceArgs(ctx, args)
+	if err != nil {
+		return nil, err
+	}
+	m, _ := matchArgs(fargs, "n", "size", "replace", "prob")
+	if m[0] == nil {
+		return nil, fmt.Errorf("argument \"n\" is missing, with no default")
+	}
+	f, err := asFloatElem(ctx, m[0])
+	if err != nil || f.NA || f.Val < 0 || f.Val > math.MaxInt32 {
+		return nil, fmt.Errorf("invalid first argument")
+	}
+	return sampleInt(ctx, int(f.Val), m[1], m[2], m[3])
+}
+
+func isNumericValue(v Value) bool {
+	switch v.(type) {
+	case *IntVec, *DoubleVec:
+		return !hasClass(v, "factor")
+	}
+	return false
+}
+
+// sampleInt draws size indices from 1..n, following R's do_sample.
+func sampleInt(ctx *Context, n int, sizeV, replaceV, probV Value) (Value, error) {
+	size := n
+	if sizeV != nil && sizeV != NullValue {
+		f, err := asFloatElem(ctx, sizeV)
+		if err != nil || f.NA || f.Val < 0 || math.IsInf(f.Val, 0) {
+			return nil, fmt.Errorf("invalid 'size' argument")
+		}
+		size = int(f.Val)
+	}
+	replace, err := logicalArg(ctx, replaceV, false)
+	if err != nil {
+		return nil, err
+	}
+	r := ctx.rng()
+	out := make([]int, size)
+
+	if probV != nil && probV != NullValue {
+		pv, err := asDoubleVec(ctx, probV)
+		if err != nil || len(pv) != n {
+			return nil, fmt.Errorf("incorrect number of probabilities")
+		}
+		p, err := fixupProb(pv, size, replace)
+		if err != nil {
+			return nil, err
+		}
+		perm := make([]int, n)
+		switch {
+		case !replace:
+			if size > n {
+				return nil, fmt.Errorf("cannot take a sample larger than the population when 'replace = FALSE'")
+			}
+			probSampleNoReplace(r, p, perm, out)
+		case countLarge(p) > 200:
+			walkerSample(r, p, out)
+		default:
+			probSampleReplace(r, p, perm, out)
+		}
+		return sampleResult(out), nil
+	}
+
+	if !replace && size > n {
+		return nil, fmt.Errorf("cannot take a sample larger than the population when 'replace = FALSE'")
+	}
+	dn := float64(n)
+	switch {
+	case replace || size < 2:
+		for i := range out {
+			out[i] = int(r.index(dn)) + 1
+		}
+	case n > 1e7 && size <= n/2:
+		// sample.int's hashed variant: draw with replacement and discard
+		// duplicates.
+		seen := make(map[int]bool, size)
+		for i := 0; i < size; {
+			v := int(r.index(dn)) + 1
+			if !seen[v] {
+				seen[v] = true
+				out[i] = v
+				i++
+			}
+		}
+	default:
+		x := make([]int, n)
+		for i := range x {
+			x[i] = i
+		}
+		left := n
+		for i := range out {
+			j := int(r.index(float64(left)))
+			out[i] = x[j] + 1
+			left--
+			x[j] = x[left]
+		}
+	}
+	return sampleResult(out), nil
+}
+
+func sampleResult(idx []int) Value {
+	out := &IntVec{Data: make([]IntElem, len(idx))}
+	for i, v := range idx {
+		out.Data[i] = IntElem{Val: int64(v)}
+	}
+	return out
+}
+
+// fixupProb checks the prob argument of sample and normalises it to sum
+// to one.
+func fixupProb(pv []FloatElem, size int, replace bool) ([]float64, error) {
+	p := make([]float64, len(pv))
+	sum := 0.0
+	npos := 0
+	for i, f := range pv {
+		if f.NA || math.IsNaN(f.Val) || math.IsInf(f.Val, 0) {
+			return nil, fmt.Errorf("NA in probability vector")
+		}
+		if f.Val < 0 {
+			return nil, fmt.Errorf("negative probability")
+		}
+		if f.Val > 0 {
+			npos++
+			sum += f.Val
+		}
+		p[i] = f.Val
+	}
+	if npos == 0 || (!replace && size > npos) {
+		return nil, fmt.Errorf("too few positive probabilities")
+	}
+	for i := range p {
+		p[i] /= sum
+	}
+	return p, nil
+}
+
+// countLarge counts the probabilities above 0.1/n; R switches to
+// Walker's alias method when there are more than 200 of them.
+func countLarge(p []float64) int {
+	n := float64(len(p))
+	c := 0
+	for _, v := range p {
+		if n*v > 0.1 {
+			c++
+		}
+	}
+	return c
+}
+
+// probSampleReplace samples with replacement by inversion over the
+// probabilities sorted in decreasing order.
+func probSampleReplace(r *rngState, p []float64, perm, out []int) {
+	for i := range perm {
+		perm[i] = i + 1
+	}
+	revsort(p, perm)
+	for i := 1; i < len(p); i++ {
+		p[i] += p[i-1]
+	}
+	for i := range out {
+		u := r.unif()
+		j := 0
+		for ; j < len(p)-1; j++ {
+			if u <= p[j] {
+				break
+			}
+		}
+		out[i] = perm[j]
+	}
+}
+
+// probSampleNoReplace samples without replacement, removing each drawn
+// element and its mass from the table.
+func probSampleNoReplace(r *rngState, p []float64, perm, out []int) {
+	for i := range perm {
+		perm[i] = i + 1
+	}
+	revsort(p, perm)
+	total := 1.0
+	n1 := len(p) - 1
+	for i := range out {
+		rt := total * r.unif()
+		mass := 0.0
+		j := 0
+		for ; j < n1; j++ {
+			mass += p[j]
+			if rt <= mass {
+				break
+			}
+		}
+		out[i] = perm[j]
+		total -= p[j]
+		copy(p[j:n1], p[j+1:n1+1])
+		copy(perm[j:n1], perm[j+1:n1+1])
+		n1--
+	}
+}
+
+// walkerSample samples with replacement using Walker's alias method.
+func walkerSample(r *rngState, p []float64, out []int) {
+	n := len(p)
+	hl := make([]int, n)
+	a := make([]int, n)
+	q := make([]float64, n)
+	// Entries with q < 1 are collected from the front of hl, the others
+	// from the back.
+	h, l := -1, n
+	for i := range p {
+		q[i] = p[i] * float64(n)
+		if q[i] < 1 {
+			h++
+			hl[h] = i
+		} else {
+			l--
+			hl[l] = i
+		}
+	}
+	if h >= 0 && l < n {
+		for k := 0; k < n-1; k++ {
+			i := hl[k]
+			j := hl[l]
+			a[i] = j
+			q[j] += q[i] - 1
+			if q[j] < 1 {
+				l++
+			}
+			if l >= n {
+				break
+			}
+		}
+	}
+	for i := range q {
+		q[i] += float64(i)
+	}
+	for i := range out {
+		u := r.unif() * float64(n)
+		k := int(u)
+		if u < q[k] {
+			out[i] = k + 1
+		} else {
+			out[i] = a[k] + 1
+		}
+	}
+}
+
+// rngKinds reports the current kinds in the form RNGkind() returns.
+func (r *rngState) kinds() *CharVec {
+	sample := "Rejection"
+	if r.rounding {
+		sample = "Rounding"
+	}
+	return charVecOf([]string{"Mersenne-Twister", "Inversion", sample})
+}
+
+// setKinds applies the kind arguments shared by RNGkind and set.seed.
+// Only R's default generators are implemented; asking for another kind
+// is an error rather than a silently different stream.
+func setKinds(ctx *Context, kind, normalKind, sampleKind Value) error {
+	r := ctx.rng()
+	check := func(v Value, what string, allowed ...string) (string, error) {
+		if v == nil || v == NullValue {
+			return "", nil
+		}
+		cv, ok := v.(*CharVec)
+		if !ok || len(cv.Data) != 1 || cv.Data[0].NA {
+			return "", fmt.Errorf("'%s' must be a character string of length 1 (RNG to be used)", what)
+		}
+		s := cv.Data[0].Val
+		if s == "default" || containsString(allowed, s) {
+			return s, nil
+		}
+		return "", fmt.Errorf("%s \"%s\" is not supported", what, s)
+	}
+	k, err := check(kind, "RNG kind", "Mersenne-Twister")
+	if err != nil {
+		return err
+	}
+	if _, err := check(normalKind, "normal.kind", "Inversion"); err != nil {
+		return err
+	}
+	sk, err := check(sampleKind, "sample.kind", "Rejection", "Rounding")
+	if err != nil {
+		return err
+	}
+	if k != "" {
+		// R seeds the new generator from the old one.
+		r.seed(uint32(r.unif() * math.MaxUint32))
+	}
+	if sk != "" {
+		r.rounding = sk == "Rounding"
+		if r.rounding {
+			return ctx.warn("non-uniform 'Rounding' sampler used")
+		}
+	}
+	return nil
+}
+
+// builtinRNGkind implements RNGkind(kind, normal.kind, sample.kind) and
+// returns the kinds in use before the call.
+func builtinRNGkind(ctx *Context, args []ArgValue) (Value, error) {
+	fargs, err := forceArgs(ctx, args)
+	if err != nil {
+		return nil, err
+	}
+	m, _ := matchArgs(fargs, "kind", "normal.kind", "sample.kind")
+	prev := ctx.rng().kinds()
+	if err := setKinds(ctx, m[0], m[1], m[2]); err != nil {
+		return nil, err
+	}
+	return prev, nil
+}
+
+// builtinSetSeed implements set.seed(seed, kind, normal.kind,
+// sample.kind). A NULL seed reinitialises the generator from the clock.
+func builtinSetSeed(ctx *Context, args []ArgValue) (Value, error) {
+	fargs, err := forceArgs(ctx, args)
+	if err != nil {
+		return nil, err
+	}
+	m, _ := matchArgs(fargs, "seed", "kind", "normal.kind", "sample.kind")
+	if err := setKinds(ctx, m[1], m[2], m[3]); err != nil {
+		return nil, err
+	}
+	if m[0] == nil || m[0] == NullValue {
+		ctx.rng().seed(timeSeed())
+		return NullValue, nil
+	}
+	f, err := asFloatElem(ctx, m[0])
+	if err != nil || f.NA || math.IsNaN(f.Val) || math.Abs(f.Val) > math.MaxInt32 {
+		return nil, fmt.Errorf("supplied seed is not a valid integer")
+	}
+	ctx.rng().seed(uint32(int32(f.Val)))
+	return NullValue, nil
+}
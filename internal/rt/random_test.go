@@ -0,0 +1,113 @@
+package rt
+
+import "testing"
+
+func TestRandom(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		// reference values from GNU R with the default RNGkind
+		{"set.seed(42); round(rnorm(3), 6)", "1.370958 -0.564698 0.363128"},
+		{"set.seed(42); round(runif(3), 7)", "0.914806 0.9370754 0.2861395"},
+		{"set.seed(1); round(rnorm(1), 7)", "-0.6264538"},
+		{"set.seed(123); round(runif(1), 7)", "0.2875775"},
+		{"set.seed(123); sample(1:10)", "3 10 2 8 6 9 1 7 5 4"},
+		{"set.seed(42); sample(10)", "1 5 10 8 2 4 6 9 7 3"},
+		{"set.seed(123); rbinom(10, 1, 0.5)", "0 1 0 1 1 0 1 1 1 0"},
+		{"set.seed(1); rpois(5, 3)", "2 2 3 5 2"},
+		{"set.seed(1); round(rexp(3), 7)", "0.7551818 1.1816428 0.1457067"},
+		{"set.seed(5); a <- runif(4); set.seed(5); identical(a, runif(4))", "TRUE"},
+		{"set.seed(5); a <- runif(1); b <- runif(1); a == b", "FALSE"},
+		{"length(rnorm(c(5, 6, 7)))", "3"},
+		{"typeof(rbinom(2, 10, 0.3))", `"integer"`},
+		{"typeof(rpois(2, 40))", `"integer"`},
+		{"rnorm(2, mean = 3, sd = 0)", "3 3"},
+		{"runif(2, 4, 4)", "4 4"},
+		{"rbinom(3, 5, 1)", "5 5 5"},
+		{"rpois(2, 0)", "0 0"},
+		{"tryCatch(rnorm(1, sd = -1), warning = function(w) conditionMessage(w))", `"NAs produced"`},
+		{"set.seed(2); x <- rbinom(1000, 200, 0.3); all(x >= 0 & x <= 200)", "TRUE"},
+		{"set.seed(2); abs(mean(rpois(10000, 50)) - 50) < 0.5", "TRUE"},
+		{"set.seed(3); sort(sample(5))", "1 2 3 4 5"},
+		{"set.seed(3); length(sample(3, 10, replace = TRUE))", "10"},
+		{`set.seed(3); sample(c("a", "b", "c"), 1) %in% c("a", "b", "c")`, "TRUE"},
+		{"set.seed(4); unique(sample(3, 20, replace = TRUE, prob = c(0, 1, 0)))", "2"},
+		{"set.seed(4); sort(sample(4, 2, prob = c(0, 1, 1, 0)))", "2 3"},
+		{"set.seed(4); x <- sample(300, 2000, replace = TRUE, prob = rep(1, 300)); all(x >= 1 & x <= 300)", "TRUE"},
+		{"set.seed(6); a <- rchisq(3, 4); set.seed(6); identical(a, rgamma(3, 2, scale = 2))", "TRUE"},
+		{"set.seed(6); a <- rt(3, Inf); set.seed(6); identical(a, rnorm(3))", "TRUE"},
+		{"set.seed(7); abs(mean(rgamma(20000, 2.5, rate = 2)) - 1.25) < 0.02", "TRUE"},
+		{"set.seed(7); abs(mean(rgamma(20000, 0.5)) - 0.5) < 0.02", "TRUE"},
+		{"set.seed(7); abs(var(rgamma(20000, 20)) - 20) < 1", "TRUE"},
+		{"set.seed(7); x <- rbeta(20000, 2, 3); all(x > 0 & x < 1) && abs(mean(x) - 0.4) < 0.01", "TRUE"},
+		{"set.seed(7); abs(mean(rbeta(20000, 0.5, 3)) - 1/7) < 0.01", "TRUE"},
+		{"set.seed(7); abs(mean(rchisq(20000, 4)) - 4) < 0.1", "TRUE"},
+		{"set.seed(7); abs(var(rt(20000, 10)) - 1.25) < 0.05", "TRUE"},
+		{"set.seed(7); abs(mean(rf(20000, 5, 20)) - 20/18) < 0.03", "TRUE"},
+		{"rgamma(2, 0)", "0 0"},
+		{"c(rbeta(1, Inf, Inf), rbeta(1, 2, 0), rbeta(1, 0, 2))", "0.5 1 0"},
+		{"tryCatch(rchisq(1, -1), warning = function(w) conditionMessage(w))", `"NAs produced"`},
+		{"RNGkind()", `"Mersenne-Twister" "Inversion" "Rejection"`},
+		{`RNGkind(sample.kind = "Rounding"); RNGkind()[3]`, `"Rounding"`},
+		{`set.seed(123, sample.kind = "Rounding"); sample(1:10)`, "3 8 4 7 6 1 10 9 2 5"},
+	}
+
+	for _, tt := range tests {
+		ctx := NewContext()
+		res, err := ctx.EvalString(tt.input)
+		if err != nil {
+			t.Errorf("input %q: unexpected error: %v", tt.input, err)
+			continue
+		}
+		if res.Value.String() != tt.expected {
+			t.Errorf("input %q: expected %s, got %s", tt.input, tt.expected, res.Value.String())
+		}
+	}
+}
+
+func TestRandomIndependentContexts(t *testing.T) {
+	a, b := NewContext(), NewContext()
+	if _, err := a.EvalString("set.seed(10)"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := b.EvalString("set.seed(10); runif(5)"); err != nil {
+		t.Fatal(err)
+	}
+	res, err := a.EvalString("round(runif(1), 7)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := res.Value.String(); got != "0.5074782" {
+		t.Errorf("draws in one context changed another: got %s", got)
+	}
+}
+
+func TestRandomErrors(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"rnorm()", `argument "n" is missing, with no default`},
+		{"rnorm(-1)", "invalid arguments"},
+		{"rbinom(3, 10)", `argument "prob" is missing, with no default`},
+		{"sample(3, 5)", "cannot take a sample larger than the population when 'replace = FALSE'"},
+		{"sample(3, 2, prob = c(1, 2))", "incorrect number of probabilities"},
+		{"sample(3, 2, prob = c(1, -1, 1))", "negative probability"},
+		{"sample(3, 2, prob = c(1, 0, 0))", "too few positive probabilities"},
+		{"set.seed(NA)", "supplied seed is not a valid integer"},
+		{`RNGkind("Wichmann-Hill")`, `RNG kind "Wichmann-Hill" is not supported`},
+	}
+
+	for _, tt := range tests {
+		ctx := NewContext()
+		_, err := ctx.EvalString(tt.input)
+		if err == nil {
+			t.Errorf("input %q: expected error", tt.input)
+			continue
+		}
+		if err.Error() != tt.expected {
+			t.Errorf("input %q: expected %q, got %q", tt.input, tt.expected, err.Error())
+		}
+	}
+}
@@ -0,0 +1,591 @@
+package rt
+
+import (
+	"fmt"
+	"strings"
+
+	"simonwaldherr.de/go/smallr/internal/ast"
+	"simonwaldherr.de/go/smallr/internal/token"
+)
+
+// dollarBuiltin is the internal default of the `$` generic. It is set up
+// in init because builtinDollar indirectly refers back to it.
+var dollarBuiltin *BuiltinFunc
+
+func init() {
+	dollarBuiltin = &BuiltinFunc{FnName: "$", Impl: builtinDollar, Generic: true}
+}
+
+func installS3Builtins(env *Env) {
+	builtins := map[string]*BuiltinFunc{
+		"$":             dollarBuiltin,
+		"print.default": {FnName: "print.default", Impl: builtinPrint},
+		"inherits":      {FnName: "inherits", Impl: builtinInherits},
+		"unclass":       {FnName: "unclass", Impl: builtinUnclass},
+		"oldClass":      {FnName: "oldClass", Impl: builtinOldClass},
+		"class<-":       {FnName: "class<-", Impl: builtinSetClass},
+		"oldClass<-":    {FnName: "oldClass<-", Impl: builtinSetClass},
+		"structure":     {FnName: "structure", Impl: builtinStructure},
+		"invisible":     {FnName: "invisible", Impl: builtinInvisible},
+	}
+	for name, fn := range builtins {
+		env.SetLocal(name, fn)
+	}
+}
+
+// classAttr returns the explicit class attribute of v, or nil.
+func classAttr(v Value) []string {
+	cls, ok := v.GetAttr("class")
+	if !ok {
+		return nil
+	}
+	cv, ok := cls.(*CharVec)
+	if !ok || cv.Len() == 0 {
+		return nil
+	}
+	return toPlainStrings(cv)
+}
+
+// classOf returns what class(v) reports: the class attribute, or the
+// implicit class derived from dimensions and type.
+func classOf(v Value) []string {
+	if cls := classAttr(v); cls != nil {
+		return cls
+	}
+	switch d := getDims(v); {
+	case len(d) == 2:
+		return []string{"matrix", "array"}
+	case len(d) > 0:
+		return []string{"array"}
+	}
+	switch v.(type) {
+	case *DoubleVec:
+		return []string{"numeric"}
+	case *IntVec:
+		return []string{"integer"}
+	}
+	return []string{typeClass(v)}
+}
+
+// dispatchClasses returns the classes UseMethod tries, in order. For
+// objects without a class attribute this is the implicit class followed
+// by the storage type, e.g. "matrix", "array", "double", "numeric".
+func dispatchClasses(v Value) []string {
+	if cls := classAttr(v); cls != nil {
+		return cls
+	}
+	var out []string
+	switch d := getDims(v); {
+	case len(d) == 2:
+		out = append(out, "matrix", "array")
+	case len(d) > 0:
+		out = append(out, "array")
+	}
+	switch v.(type) {
+	case *DoubleVec:
+		return append(out, "double", "numeric")
+	case *IntVec:
+		return append(out, "integer", "numeric")
+	}
+	return append(out, typeClass(v))
+}
+
+func typeClass(v Value) string {
+	switch t := v.(type) {
+	case Callable:
+		return "function"
+	case *Null:
+		return "NULL"
+	case *ExprValue:
+		return langClass(t)
+	}
+	return v.Type()
+}
+
+// findMethod looks up generic.cls for each class in turn, then
+// generic.default, first from env outwards and then in the global
+// environment. It returns the method and the classes still to be tried
+// by NextMethod, starting with the matched one. For members of a group
+// generic, group.cls is tried after generic.cls and there is no default.
+func findMethod(ctx *Context, env *Env, generic, group string, classes []string) (Callable, []string, bool) {
+	if fn, _, rest := lookupMethod(ctx, env, generic, group, classes); fn != nil {
+		return fn, rest, true
+	}
+	if group != "" {
+		return nil, nil, false
+	}
+	if fn, ok := lookupFunction(ctx, env, generic+".default"); ok {
+		return fn, nil, true
+	}
+	return nil, nil, false
+}
+
+// lookupMethod is findMethod without the default; it also reports the
+// name under which the method was found.
+func lookupMethod(ctx *Context, env *Env, generic, group string, classes []string) (Callable, string, []string) {
+	for i, cls := range classes {
+		names := []string{generic + "." + cls}
+		if group != "" {
+			names = append(names, group+"."+cls)
+		}
+		for _, name := range names {
+			if fn, ok := lookupFunction(ctx, env, name); ok {
+				return fn, name, classes[i:]
+			}
+		}
+	}
+	return nil, "", nil
+}
+
+func lookupFunction(ctx *Context, env *Env, name string) (Callable, bool) {
+	for _, e := range []*Env{env, ctx.Global} {
+		if e == nil {
+			continue
+		}
+		if v, ok := e.Get(name); ok {
+			if fn, ok := v.(Callable); ok {
+				return fn, true
+			}
+		}
+	}
+	return nil, false
+}
+
+// callMethod invokes an S3 method. Closures get a dispatch frame so that
+// .Generic, .Class (and .Method for Ops) and NextMethod work inside them.
+func callMethod(ctx *Context, fn Callable, fr *Frame) (Value, error) {
+	if c, ok := fn.(*ClosureFunc); ok {
+		fr.Fn = c
+		return callClosure(ctx, fr)
+	}
+	if b, ok := fn.(*BuiltinFunc); ok && b.FnName == fr.Generic {
+		// the generic itself was found again; use its default
+		return b.Impl(ctx, fr.Args)
+	}
+	return fn.Call(ctx, fr.Caller, fr.Args)
+}
+
+// dispatchInternal dispatches a call of a generic builtin to an S3 method
+// when the first argument carries a class attribute. ok is false when no
+// method applies and the builtin should run its own implementation.
+func dispatchInternal(ctx *Context, caller *Env, b *BuiltinFunc, args []ArgValue) (Value, bool, error) {
+	if len(args) == 0 {
+		return nil, false, nil
+	}
+	obj, err := Force(ctx, args[0].Val)
+	if err != nil {
+		return nil, true, err
+	}
+	classes := classAttr(obj)
+	if classes == nil {
+		return nil, false, nil
+	}
+	env := ctx.callerEnv(caller)
+	fn, rest, ok := findMethod(ctx, env, b.FnName, b.Group, classes)
+	if !ok {
+		return nil, false, nil
+	}
+	if m, isBuiltin := fn.(*BuiltinFunc); isBuiltin && !m.Generic {
+		return nil, false, nil
+	}
+	v, err := callMethod(ctx, fn, &Frame{
+		Args:     args,
+		Caller:   env,
+		Generic:  b.FnName,
+		Group:    b.Group,
+		Classes:  rest,
+		Object:   obj,
+		Internal: b,
+	})
+	return v, true, err
+}
+
+// dispatchOps dispatches an operator to an Ops group method (or a method
+// for the operator itself, such as "+.money") when an operand carries a
+// class attribute. If the two operands select different methods a
+// warning is given and the internal operator is used, as in R.
+func dispatchOps(ctx *Context, op token.Type, operands ...Value) (Value, bool, error) {
+	var (
+		fn   Callable
+		name string
+		rest []string
+		obj  Value
+	)
+	// .Method names the method for each operand that selected it
+	methods := make([]string, len(operands))
+	env := ctx.callerEnv(nil)
+	for i, v := range operands {
+		classes := classAttr(v)
+		if classes == nil {
+			continue
+		}
+		m, mname, mrest := lookupMethod(ctx, env, string(op), "Ops", classes)
+		if m == nil {
+			continue
+		}
+		methods[i] = mname
+		if fn == nil {
+			fn, name, rest, obj = m, mname, mrest, v
+			continue
+		}
+		if m != fn {
+			err := ctx.warn(fmt.Sprintf("Incompatible methods (\"%s\", \"%s\") for \"%s\"", name, mname, op))
+			return nil, err != nil, err
+		}
+	}
+	if fn == nil {
+		return nil, false, nil
+	}
+	args := make([]ArgValue, len(operands))
+	for i, v := range operands {
+		args[i] = ArgValue{Val: v}
+	}
+	v, err := callMethod(ctx, fn, &Frame{
+		Args:     args,
+		Caller:   env,
+		Generic:  string(op),
+		Group:    "Ops",
+		Method:   methods,
+		Classes:  rest,
+		Object:   obj,
+		Internal: opBuiltin(op),
+	})
+	return v, true, err
+}
+
+// opBuiltin wraps an operator as a builtin. It is the internal default
+// that NextMethod falls back to from an Ops method.
+func opBuiltin(op token.Type) *BuiltinFunc {
+	return &BuiltinFunc{FnName: string(op), Impl: func(ctx *Context, args []ArgValue) (Value, error) {
+		fargs, err := forceArgs(ctx, args)
+		if err != nil {
+			return nil, err
+		}
+		switch len(fargs) {
+		case 1:
+			return unaryOp(ctx, op, fargs[0].Val)
+		case 2:
+			return binaryOp(ctx, op, fargs[0].Val, fargs[1].Val)
+		}
+		return nil, fmt.Errorf("operator needs one or two arguments")
+	}}
+}
+
+// evalUseMethod implements UseMethod(generic, object). The selected method
+// receives the arguments of the enclosing call, and its result is returned
+// from that call.
+func evalUseMethod(ctx *Context, env *Env, c *ast.CallExpr) (Value, error) {
+	if len(c.Args) < 1 || len(c.Args) > 2 {
+		return nil, fmt.Errorf("UseMethod() expects 1 or 2 arguments")
+	}
+	gv, err := Eval(ctx, env, c.Args[0].Value)
+	if err != nil {
+		return nil, err
+	}
+	gv, err = Force(ctx, gv)
+	if err != nil {
+		return nil, err
+	}
+	gcv, ok := gv.(*CharVec)
+	if !ok || gcv.Len() != 1 || gcv.Data[0].NA {
+		return nil, fmt.Errorf("'generic' argument must be a character string")
+	}
+	generic := gcv.Data[0].Val
+	fr := ctx.frameFor(env)
+	if fr == nil {
+		return nil, fmt.Errorf("UseMethod called from outside a function")
+	}
+	var obj Value
+	if len(c.Args) == 2 {
+		obj, err = Eval(ctx, env, c.Args[1].Value)
+	} else {
+		obj, err = dispatchObject(ctx, fr)
+	}
+	if err != nil {
+		return nil, err
+	}
+	obj, err = Force(ctx, obj)
+	if err != nil {
+		return nil, err
+	}
+
+	classes := dispatchClasses(obj)
+	mfr := &Frame{Call: fr.Call, Args: fr.Args, Caller: fr.Caller, Generic: generic, Object: obj}
+	fn, rest, ok := findMethod(ctx, fr.Caller, generic, "", classes)
+	if ok {
+		mfr.Classes = rest
+	} else if b := internalGeneric(ctx, generic); b != nil {
+		fn = b
+	} else {
+		return nil, fmt.Errorf("no applicable method for '%s' applied to an object of class \"%s\"",
+			generic, classes[0])
+	}
+	v, err := callMethod(ctx, fn, mfr)
+	if err != nil {
+		return nil, err
+	}
+	return nil, &ControlError{Kind: ctrlReturn, Value: v}
+}
+
+// evalNextMethod implements NextMethod(): it calls the method for the next
+// class in .Class, falling back to the default method and finally to the
+// builtin implementation of the generic.
+func evalNextMethod(ctx *Context, env *Env, c *ast.CallExpr) (Value, error) {
+	fr := ctx.frameFor(env)
+	if fr == nil || fr.Generic == "" {
+		return nil, fmt.Errorf("NextMethod called from outside a method dispatch")
+	}
+	args := append([]ArgValue(nil), fr.Args...)
+	for _, a := range c.Args {
+		if a.Name == "" || a.Name == "generic" || a.Name == "object" {
+			continue
+		}
+		v, err := Eval(ctx, env, a.Value)
+		if err != nil {
+			return nil, err
+		}
+		replaced := false
+		for i := range args {
+			if args[i].Name == a.Name {
+				args[i].Val = v
+				replaced = true
+			}
+		}
+		if !replaced {
+			args = append(args, ArgValue{Name: a.Name, Val: v})
+		}
+	}
+
+	var remaining []string
+	if len(fr.Classes) > 0 {
+		remaining = fr.Classes[1:]
+	}
+	nfr := &Frame{
+		Call:     fr.Call,
+		Args:     args,
+		Caller:   fr.Caller,
+		Generic:  fr.Generic,
+		Group:    fr.Group,
+		Object:   fr.Object,
+		Internal: fr.Internal,
+	}
+	if fr.Method != nil {
+		// an Ops method: the operands that selected it move on together
+		if fn, name, rest := lookupMethod(ctx, fr.Caller, fr.Generic, fr.Group, remaining); fn != nil {
+			nfr.Classes = rest
+			nfr.Method = make([]string, len(fr.Method))
+			for i, m := range fr.Method {
+				if m != "" {
+					nfr.Method[i] = name
+				}
+			}
+			return callMethod(ctx, fn, nfr)
+		}
+	} else if fn, rest, ok := findMethod(ctx, fr.Caller, fr.Generic, fr.Group, remaining); ok {
+		nfr.Classes = rest
+		return callMethod(ctx, fn, nfr)
+	}
+	internal := fr.Internal
+	if internal == nil {
+		internal = internalGeneric(ctx, fr.Generic)
+	}
+	if internal == nil {
+		return nil, fmt.Errorf("no more methods for '%s'", fr.Generic)
+	}
+	return internal.Impl(ctx, args)
+}
+
+// internalGeneric returns the builtin implementing generic, if any.
+func internalGeneric(ctx *Context, generic string) *BuiltinFunc {
+	if generic == "$" {
+		return dollarBuiltin
+	}
+	if v, ok := ctx.Global.Get(generic); ok {
+		if b, ok := v.(*BuiltinFunc); ok {
+			return b
+		}
+	}
+	return nil
+}
+
+// dispatchObject returns the value of the first formal argument of the
+// call described by fr, which UseMethod dispatches on by default.
+func dispatchObject(ctx *Context, fr *Frame) (Value, error) {
+	if len(fr.Fn.Params) == 0 {
+		return nil, fmt.Errorf("UseMethod called from a function without arguments")
+	}
+	p := fr.Fn.Params[0]
+	if p.Dots {
+		dv, _ := fr.Env.GetLocal("...")
+		if d, ok := dv.(*Dots); ok && len(d.Args) > 0 {
+			return Force(ctx, d.Args[0].Val)
+		}
+		return NullValue, nil
+	}
+	v, _ := fr.Env.GetLocal(p.Name)
+	if v == MissingValue {
+		return nil, fmt.Errorf("argument \"%s\" is missing, with no default", p.Name)
+	}
+	return Force(ctx, v)
+}
+
+// --- class builtins ---
+
+func builtinDollar(ctx *Context, args []ArgValue) (Value, error) {
+	fargs, err := forceArgs(ctx, args)
+	if err != nil {
+		return nil, err
+	}
+	if len(fargs) != 2 {
+		return nil, fmt.Errorf("$ expects 2 arguments")
+	}
+	name, ok := fargs[1].Val.(*CharVec)
+	if !ok || name.Len() != 1 || name.Data[0].NA {
+		return nil, fmt.Errorf("invalid subscript type '%s'", fargs[1].Val.Type())
+	}
+	return dollar(ctx, fargs[0].Val, name.Data[0].Val)
+}
+
+func builtinInherits(ctx *Context, args []ArgValue) (Value, error) {
+	fargs, err := forceArgs(ctx, args)
+	if err != nil {
+		return nil, err
+	}
+	m, _ := matchArgs(fargs, "x", "what", "which")
+	if m[0] == nil || m[1] == nil {
+		return nil, fmt.Errorf("inherits(x, what) expects 2 arguments")
+	}
+	what, ok := m[1].(*CharVec)
+	if !ok {
+		return nil, fmt.Errorf("'what' must be a character vector")
+	}
+	which, err := logicalArg(ctx, m[2], false)
+	if err != nil {
+		return nil, err
+	}
+	classes := classOf(m[0])
+	pos := make([]IntElem, what.Len())
+	found := false
+	for i, w := range what.Data {
+		for j, cls := range classes {
+			if !w.NA && w.Val == cls {
+				pos[i] = IntElem{Val: int64(j + 1)}
+				found = true
+				break
+			}
+		}
+	}
+	if which {
+		return &IntVec{Data: pos}, nil
+	}
+	return LogicalScalar(found), nil
+}
+
+func builtinUnclass(ctx *Context, args []ArgValue) (Value, error) {
+	if len(args) != 1 {
+		return nil, fmt.Errorf("unclass(x) expects 1 argument")
+	}
+	v, err := Force(ctx, args[0].Val)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := v.GetAttr("class"); !ok {
+		return v, nil
+	}
+	out := cloneValue(v)
+	out.SetAttr("class", nil)
+	return out, nil
+}
+
+func builtinOldClass(ctx *Context, args []ArgValue) (Value, error) {
+	if len(args) != 1 {
+		return nil, fmt.Errorf("oldClass(x) expects 1 argument")
+	}
+	v, err := Force(ctx, args[0].Val)
+	if err != nil {
+		return nil, err
+	}
+	if cls, ok := v.GetAttr("class"); ok {
+		return cls, nil
+	}
+	return NullValue, nil
+}
+
+func builtinSetClass(ctx *Context, args []ArgValue) (Value, error) {
+	fargs, err := forceArgs(ctx, args)
+	if err != nil {
+		return nil, err
+	}
+	m, _ := matchArgs(fargs, "x", "value")
+	if m[0] == nil || m[1] == nil {
+		return nil, fmt.Errorf("class<- expects 2 arguments")
+	}
+	out := cloneValue(m[0])
+	if m[1] == NullValue || m[1].Len() == 0 {
+		out.SetAttr("class", nil)
+		return out, nil
+	}
+	cls, ok := m[1].(*CharVec)
+	if !ok {
+		return nil, fmt.Errorf("attempt to set invalid 'class' attribute")
+	}
+	out.SetAttr("class", cloneValue(cls))
+	return out, nil
+}
+
+// builtinStructure implements structure(.Data, ...): every named argument
+// is set as an attribute of .Data.
+func builtinStructure(ctx *Context, args []ArgValue) (Value, error) {
+	fargs, err := forceArgs(ctx, args)
+	if err != nil {
+		return nil, err
+	}
+	m, rest := matchArgs(fargs, ".Data", "...")
+	if m[0] == nil {
+		return nil, fmt.Errorf("argument \".Data\" is missing, with no default")
+	}
+	out := m[0]
+	for _, a := range rest {
+		if a.Name == "" {
+			return nil, fmt.Errorf("attributes must be named")
+		}
+		name := a.Name
+		if name == ".Names" {
+			name = "names"
+		}
+		if name == "class" {
+			out, err = builtinSetClass(ctx, []ArgValue{{Val: out}, {Val: a.Val}})
+		} else {
+			out, err = builtinSetAttr(ctx, []ArgValue{{Val: out}, {Val: CharScalar(name)}, {Val: a.Val}})
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return out, nil
+}
+
+// builtinInvisible returns its argument without it being printed at top
+// level; print methods conventionally end with invisible(x).
+func builtinInvisible(ctx *Context, args []ArgValue) (Value, error) {
+	var v Value = NullValue
+	if len(args) > 0 {
+		var err error
+		if v, err = Force(ctx, args[0].Val); err != nil {
+			return nil, err
+		}
+	}
+	ctx.invisible = true
+	return v, nil
+}
+
+// formatClassAttr renders the class attribute line that print shows for
+// objects without a print method.
+func formatClassAttr(v Value) string {
+	cls := classAttr(v)
+	quoted := make([]string, len(cls))
+	for i, c := range cls {
+		quoted[i] = fmt.Sprintf("%q", c)
+	}
+	return "attr(,\"class\")\n" + strings.Join(quoted, " ")
+}
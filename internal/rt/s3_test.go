@@ -0,0 +1,211 @@
+package rt
+
+import "testing"
+
+func TestS3Dispatch(t *testing.T) {
+	const shapes = `area <- function(s, ...) UseMethod("area")
+area.default <- function(s, ...) stop("unknown shape")
+area.circle <- function(s, ...) s$r^2
+area.square <- function(s, ...) s$side^2
+c1 <- structure(list(r = 2), class = "circle")
+`
+	const desc = `desc <- function(x) UseMethod("desc")
+desc.numeric <- function(x) paste(.Generic, paste(.Class, collapse = "/"))
+desc.integer <- function(x) paste("integer >", NextMethod())
+desc.default <- function(x) "default"
+`
+	const hello = `hello <- function(x) UseMethod("hello")
+hello.parent <- function(x) "parent"
+hello.child <- function(x) paste("child >", NextMethod())
+s <- structure(1:3, class = c("child", "parent"))
+`
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{shapes + "area(c1)", "4"},
+		{shapes + "area(structure(list(side = 3), class = \"square\"))", "9"},
+		{desc + "desc(1.5)", `"desc numeric"`},
+		{desc + "desc(as.integer(1))", `"integer > desc numeric"`},
+		{desc + "desc(\"a\")", `"default"`},
+		{desc + "desc(matrix(1:4, 2))", `"integer > desc numeric"`},
+		{hello + "hello(s)", `"child > parent"`},
+		{hello + "f <- function(y) hello(y); f(s)", `"child > parent"`},
+		{"g <- function(x, n) UseMethod(\"g\"); g.foo <- function(x, n) n * 2; g(structure(1, class = \"foo\"), 21)", "42"},
+		{"g <- function(x) { UseMethod(\"g\"); 1 }; g.default <- function(x) 2; g(0)", "2"},
+		{"f <- function() { k.foo <- function(x) \"local\"; k <- function(x) UseMethod(\"k\"); k(structure(1, class = \"foo\")) }; f()", `"local"`},
+		// generic builtins
+		{"format.money <- function(x, ...) paste0(\"$\", unclass(x)); format(structure(5, class = \"money\"))", `"$5"`},
+		{"length.stack <- function(x) length(x$items); length(structure(list(items = 1:4), class = \"stack\"))", "4"},
+		{"as.character.id <- function(x, ...) paste0(\"#\", unclass(x)); as.character(structure(7, class = \"id\"))", `"#7"`},
+		{"summary.foo <- function(object, ...) \"sum\"; summary(structure(1, class = \"foo\"))", `"sum"`},
+		{"print.foo <- function(x, ...) invisible(\"printed\"); print(structure(1, class = \"foo\"))", `"printed"`},
+		{"f <- function(x) { summary.foo <- function(object, ...) \"local\"; summary(x) }; f(structure(1, class = \"foo\"))", `"local"`},
+		{"r <- structure(list(w = 2, h = 3), class = \"rect\"); `$.rect` <- function(x, name) if (name == \"area\") x[[\"w\"]] * x[[\"h\"]] else NextMethod(); r$area", "6"},
+		{"r <- structure(list(w = 2, h = 3), class = \"rect\"); `$.rect` <- function(x, name) if (name == \"area\") 0 else NextMethod(); r$w", "2"},
+		{"length(structure(1:3, class = \"foo\"))", "3"},
+		// class utilities
+		{"class(matrix(1:4, 2))", `"matrix" "array"`},
+		{"class(1.5)", `"numeric"`},
+		{"class(as.integer(1))", `"integer"`},
+		{"class(sum)", `"function"`},
+		{"class(NULL)", `"NULL"`},
+		{"x <- 1:3; class(x) <- \"foo\"; class(x)", `"foo"`},
+		{"x <- structure(1:3, class = \"foo\"); class(x) <- NULL; class(x)", `"integer"`},
+		{"class(unclass(structure(1.5, class = \"foo\")))", `"numeric"`},
+		{"oldClass(1)", "NULL"},
+		{"inherits(structure(1, class = c(\"a\", \"b\")), \"b\")", "TRUE"},
+		{"inherits(structure(1, class = c(\"a\", \"b\")), c(\"z\", \"b\", \"a\"), which = TRUE)", "0 2 1"},
+		{"inherits(matrix(1:4, 2), \"matrix\")", "TRUE"},
+		{"names(structure(1:2, names = c(\"a\", \"b\")))", `"a" "b"`},
+		{"dim(structure(1:6, dim = c(2, 3)))", "2 3"},
+		// attributes go on a copy of functions and language objects
+		{"g <- function() 1; h <- structure(g, class = \"memo\"); c(class(g), class(h))", `"function" "memo"`},
+		{"s <- structure(sum, class = \"foo\"); c(class(sum), class(s), s(1:3))", `"function" "foo" "6"`},
+		{"g <- function() 1; h <- g; class(h) <- \"memo\"; class(g)", `"function"`},
+		{"q <- quote(a + b); r <- structure(q, note = \"n\"); c(is.null(attributes(q)), attr(r, \"note\"))", `"TRUE" "n"`},
+		{"g <- function() x; h <- structure(g, class = \"memo\"); environment(h) <- new.env(); c(class(h), is.null(attr(g, \"class\")))", `"memo" "TRUE"`},
+	}
+
+	for _, tt := range tests {
+		ctx := NewContext()
+		res, err := ctx.EvalString(tt.input)
+		if err != nil {
+			t.Errorf("input %q: unexpected error: %v", tt.input, err)
+			continue
+		}
+		if res.Value.String() != tt.expected {
+			t.Errorf("input %q: expected %s, got %s", tt.input, tt.expected, res.Value.String())
+		}
+	}
+}
+
+func TestBuiltinAttributesPerContext(t *testing.T) {
+	a, b := NewContext(), NewContext()
+	if _, err := a.EvalString("class(`$`) <- \"marked\"; attr(sum, \"x\") <- 1"); err != nil {
+		t.Fatal(err)
+	}
+	res, err := b.EvalString("c(class(`$`), is.null(attr(sum, \"x\")))")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := res.Value.String(); got != `"function" "TRUE"` {
+		t.Errorf("attributes of builtins leaked into another context: %s", got)
+	}
+	if len(dollarBuiltin.Attrs()) != 0 {
+		t.Errorf("the shared $ builtin was changed: %v", dollarBuiltin.Attrs())
+	}
+}
+
+func TestS3Errors(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"f <- function(x) UseMethod(\"f\"); f(1.5)", `no applicable method for 'f' applied to an object of class "double"`},
+		{"UseMethod(\"f\")", "UseMethod called from outside a function"},
+		{"f <- function(x) NextMethod(); f(1)", "NextMethod called from outside a method dispatch"},
+		{"f <- function(x) UseMethod(\"f\"); f.foo <- function(x) NextMethod(); f(structure(1, class = \"foo\"))", "no more methods for 'f'"},
+	}
+
+	for _, tt := range tests {
+		ctx := NewContext()
+		_, err := ctx.EvalString(tt.input)
+		if err == nil {
+			t.Errorf("input %q: expected error", tt.input)
+			continue
+		}
+		if err.Error() != tt.expected {
+			t.Errorf("input %q: expected error %q, got %q", tt.input, tt.expected, err.Error())
+		}
+	}
+}
+
+func TestS3Print(t *testing.T) {
+	ctx := NewContext()
+	res, err := ctx.EvalString(`print.circle <- function(x, ...) { cat("<circle r =", x$r, ">\n"); invisible(x) }
+c1 <- structure(list(r = 1), class = "circle")
+print(c1)
+p <- structure(1:2, class = "plain")
+print(p)
+c1`)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := "<circle r = 1 >\n1 2\nattr(,\"class\")\n\"plain\"\n"
+	if res.Output != expected {
+		t.Errorf("expected output %q, got %q", expected, res.Output)
+	}
+	if got := ctx.SprintValue(res.Value); got != "<circle r = 1 >" {
+		t.Errorf("expected REPL rendering via print method, got %q", got)
+	}
+}
+
+func TestGroupGenerics(t *testing.T) {
+	const money = `money <- function(x) structure(x, class = "money")
+Ops.money <- function(e1, e2) {
+  v <- NextMethod()
+  if (.Generic %in% c("+", "-", "*", "/")) money(v) else v
+}
+Math.money <- function(x, ...) money(NextMethod())
+Summary.money <- function(..., na.rm = FALSE) money(NextMethod())
+m <- money(c(1.5, 4, 9))
+`
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{money + "class(m + 1)", `"money"`},
+		{money + "unclass(m * 2)", "3 8 18"},
+		{money + "unclass(2 - m)", "0.5 -2 -7"},
+		{money + "m > 3", "FALSE TRUE TRUE"},
+		{money + "class(-m)", `"money"`},
+		{money + "unclass(sqrt(m))", "1.224744871391589 2 3"},
+		{money + "class(floor(m))", `"money"`},
+		{money + "unclass(sum(m))", "14.5"},
+		{money + "unclass(max(m))", "9"},
+		{money + "unclass(range(m))", "1.5 9"},
+		{"x <- structure(1, class = \"u\"); `+.u` <- function(e1, e2) \"plus\"; Ops.u <- function(e1, e2) \"ops\"; c(x + 1, x * 1)", `"plus" "ops"`},
+		{"x <- structure(1, class = \"u\"); Ops.u <- function(e1, e2) if (missing(e2)) \"unary\" else \"binary\"; c(-x, !x, x - 1)", `"unary" "unary" "binary"`},
+		{"x <- structure(1, class = \"u\"); Ops.u <- function(e1, e2) class(e2); 1 + x", `"u"`},
+		{"x <- structure(4, class = \"u\"); Math.u <- function(x, ...) .Generic; c(sqrt(x), abs(x), cumsum(x))", `"sqrt" "abs" "cumsum"`},
+		{"x <- structure(4, class = \"u\"); sqrt.u <- function(x) \"own\"; Math.u <- function(x, ...) \"group\"; c(sqrt(x), exp(x))", `"own" "group"`},
+		{"x <- structure(1:3, class = \"u\"); Summary.u <- function(..., na.rm = FALSE) paste(.Generic, na.rm); c(any(x), prod(x, na.rm = TRUE))", `"any FALSE" "prod TRUE"`},
+		{"f <- function() { Ops.v <- function(e1, e2) \"local\"; structure(1, class = \"v\") + 1 }; f()", `"local"`},
+		{"structure(1, class = \"nomethod\") + 1", "2"},
+		{"x <- structure(1, class = \"u\"); Ops.u <- function(e1, e2) .Method; x + 1", `"Ops.u" ""`},
+		{"x <- structure(1, class = \"u\"); Ops.u <- function(e1, e2) .Method; 1 + x", `"" "Ops.u"`},
+		{"x <- structure(1, class = \"u\"); `+.u` <- function(e1, e2) .Method; x + x", `"+.u" "+.u"`},
+		{"x <- structure(1, class = \"u\"); Ops.u <- function(e1, e2) .Method; -x", `"Ops.u"`},
+		{"x <- structure(1, class = c(\"v\", \"u\")); Ops.v <- function(e1, e2) NextMethod(); Ops.u <- function(e1, e2) .Method; 2 * x", `"" "Ops.u"`},
+	}
+
+	for _, tt := range tests {
+		ctx := NewContext()
+		res, err := ctx.EvalString(tt.input)
+		if err != nil {
+			t.Errorf("input %q: unexpected error: %v", tt.input, err)
+			continue
+		}
+		if res.Value.String() != tt.expected {
+			t.Errorf("input %q: expected %s, got %s", tt.input, tt.expected, res.Value.String())
+		}
+	}
+}
+
+func TestGroupGenericsIncompatible(t *testing.T) {
+	ctx := NewContext()
+	res, err := ctx.EvalString(`Ops.a <- function(e1, e2) "a"
+Ops.b <- function(e1, e2) "b"
+structure(1, class = "a") + structure(2, class = "b")`)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if res.Value.String() != "3" {
+		t.Errorf("expected internal operator result 3, got %s", res.Value.String())
+	}
+	expected := "Warning: Incompatible methods (\"Ops.a\", \"Ops.b\") for \"+\"\n"
+	if res.Output != expected {
+		t.Errorf("expected output %q, got %q", expected, res.Output)
+	}
+}
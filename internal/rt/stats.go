@@ -0,0 +1,1023 @@
+package rt
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Descriptive statistics: medians and quantiles, spread, ranks, and the
+// covariances and correlations of vectors, matrices and data frames. The
+// numeric data is handled as float64 with NA as NaN, as in toFloats;
+// na.rm = TRUE drops the missing values, otherwise they make the result
+// NA (or are an error where R says so).
+
+func installStatsBuiltins(env *Env) {
+	builtins := map[string]*BuiltinFunc{
+		"median":        {FnName: "median", Impl: builtinMedian, Generic: true},
+		"quantile":      {FnName: "quantile", Impl: builtinQuantile, Generic: true},
+		"fivenum":       {FnName: "fivenum", Impl: builtinFivenum},
+		"IQR":           {FnName: "IQR", Impl: builtinIQR},
+		"mad":           {FnName: "mad", Impl: builtinMad},
+		"var":           {FnName: "var", Impl: builtinVar},
+		"cov":           {FnName: "cov", Impl: builtinCov},
+		"cor":           {FnName: "cor", Impl: builtinCor},
+		"weighted.mean": {FnName: "weighted.mean", Impl: builtinWeightedMean, Generic: true},
+		"rank":          {FnName: "rank", Impl: builtinRank},
+		"scale":         {FnName: "scale", Impl: builtinScale, Generic: true},
+	}
+	for name, fn := range builtins {
+		env.SetLocal(name, fn)
+	}
+}
+
+// --- helpers ---
+
+// statData returns the numeric or logical vector x as doubles, NA as NaN.
+// Anything else is an error with message msg.
+func statData(x Value, msg string) ([]float64, error) {
+	if x == nil {
+		return nil, fmt.Errorf("argument \"x\" is missing, with no default")
+	}
+	if !isNumericValue(x) && x.Type() != "logical" {
+		return nil, fmt.Errorf("%s", msg)
+	}
+	return toFloats(x), nil
+}
+
+// dropNaN returns the elements of x that are not missing, in a new slice.
+func dropNaN(x []float64) []float64 {
+	out := make([]float64, 0, len(x))
+	for _, v := range x {
+		if !math.IsNaN(v) {
+			out = append(out, v)
+		}
+	}
+	return out
+}
+
+// sortedData returns the non-missing elements of x in increasing order.
+// Missing elements are an error unless naRm is set.
+func sortedData(x []float64, naRm bool) ([]float64, error) {
+	if !naRm && anyNaN(x...) {
+		return nil, fmt.Errorf("missing values and NaN's not allowed if 'na.rm' is FALSE")
+	}
+	s := dropNaN(x)
+	sort.Float64s(s)
+	return s, nil
+}
+
+// statVec builds a double vector, turning NaN into NA.
+func statVec(x []float64) *DoubleVec {
+	data := make([]FloatElem, len(x))
+	for i, v := range x {
+		if math.IsNaN(v) {
+			data[i].NA = true
+		} else {
+			data[i].Val = v
+		}
+	}
+	return &DoubleVec{Data: data}
+}
+
+func meanOf(x []float64) float64 {
+	s := 0.0
+	for _, v := range x {
+		s += v
+	}
+	return s / float64(len(x))
+}
+
+// medianOf returns the median of sorted, NaN if it is empty.
+func medianOf(sorted []float64) float64 {
+	n := len(sorted)
+	if n == 0 {
+		return math.NaN()
+	}
+	if n%2 == 1 {
+		return sorted[n/2]
+	}
+	return (sorted[n/2-1] + sorted[n/2]) / 2
+}
+
+// --- location and spread ---
+
+func builtinMedian(ctx *Context, args []ArgValue) (Value, error) {
+	fargs, err := forceArgs(ctx, args)
+	if err != nil {
+		return nil, err
+	}
+	m, _ := matchArgs(fargs, "x", "na.rm", "...")
+	x, err := statData(m[0], "need numeric data")
+	if err != nil {
+		return nil, err
+	}
+	naRm, err := logicalArg(ctx, m[1], false)
+	if err != nil {
+		return nil, err
+	}
+	_, isInt := m[0].(*IntVec)
+	if (!naRm && anyNaN(x...)) || len(dropNaN(x)) == 0 {
+		if isInt {
+			return IntNA(), nil
+		}
+		return DoubleNA(), nil
+	}
+	s, _ := sortedData(x, true)
+	if isInt && len(s)%2 == 1 {
+		// the middle element of an integer vector stays an integer
+		return IntScalar(int64(s[len(s)/2])), nil
+	}
+	return DoubleScalar(medianOf(s)), nil
+}
+
+func builtinWeightedMean(ctx *Context, args []ArgValue) (Value, error) {
+	fargs, err := forceArgs(ctx, args)
+	if err != nil {
+		return nil, err
+	}
+	m, _ := matchArgs(fargs, "x", "w", "...", "na.rm")
+	x, err := statData(m[0], "'x' must be numeric")
+	if err != nil {
+		return nil, err
+	}
+	naRm, err := logicalArg(ctx, m[3], false)
+	if err != nil {
+		return nil, err
+	}
+	w := make([]float64, len(x))
+	for i := range w {
+		w[i] = 1
+	}
+	if m[1] != nil {
+		if w, err = statData(m[1], "'w' must be numeric"); err != nil {
+			return nil, err
+		}
+		if len(w) != len(x) {
+			return nil, fmt.Errorf("'x' and 'w' must have the same length")
+		}
+	}
+	var sum, sumW float64
+	for i, v := range x {
+		if naRm && math.IsNaN(v) {
+			continue
+		}
+		sumW += w[i]
+		// elements of weight zero do not count, even if infinite
+		if w[i] != 0 {
+			sum += v * w[i]
+		}
+	}
+	if math.IsNaN(sum) || math.IsNaN(sumW) {
+		return DoubleNA(), nil
+	}
+	return DoubleScalar(sum / sumW), nil
+}
+
+func builtinMad(ctx *Context, args []ArgValue) (Value, error) {
+	fargs, err := forceArgs(ctx, args)
+	if err != nil {
+		return nil, err
+	}
+	m, _ := matchArgs(fargs, "x", "center", "constant", "na.rm", "low", "high")
+	x, err := statData(m[0], "need numeric data")
+	if err != nil {
+		return nil, err
+	}
+	var flags [3]bool
+	for i, v := range m[3:] {
+		if flags[i], err = logicalArg(ctx, v, false); err != nil {
+			return nil, err
+		}
+	}
+	naRm, low, high := flags[0], flags[1], flags[2]
+	constant := 1.4826
+	if m[2] != nil {
+		f, err := asFloatElem(ctx, m[2])
+		if err != nil {
+			return nil, err
+		}
+		constant = f.Val
+	}
+	if naRm {
+		x = dropNaN(x)
+	} else if anyNaN(x...) {
+		return DoubleNA(), nil
+	}
+	s := append([]float64(nil), x...)
+	sort.Float64s(s)
+	center := medianOf(s)
+	if m[1] != nil {
+		f, err := asFloatElem(ctx, m[1])
+		if err != nil {
+			return nil, err
+		}
+		if center = f.Val; f.NA {
+			return DoubleNA(), nil
+		}
+	}
+	dev := make([]float64, len(x))
+	for i, v := range x {
+		dev[i] = math.Abs(v - center)
+	}
+	sort.Float64s(dev)
+	n := len(dev)
+	if (low || high) && n%2 == 0 {
+		if low && high {
+			return nil, fmt.Errorf("'low' and 'high' cannot be both TRUE")
+		}
+		k := n / 2
+		if high {
+			k++
+		}
+		return DoubleScalar(constant * dev[k-1]), nil
+	}
+	return statVec([]float64{constant * medianOf(dev)}), nil
+}
+
+// --- quantiles ---
+
+// quantileSorted returns the quantiles of the sorted data x at probs (in
+// [0, 1] or NaN) by one of Hyndman and Fan's nine methods, numbered as in
+// R's quantile(type =). Types 1 to 3 pick order statistics, types 4 to 9
+// interpolate between them.
+func quantileSorted(x, probs []float64, typ int) []float64 {
+	n := len(x)
+	qs := make([]float64, len(probs))
+	const fuzz = 4 * dblEpsilon
+	for i, p := range probs {
+		switch {
+		case n == 0 || math.IsNaN(p):
+			qs[i] = math.NaN()
+		case typ == 7:
+			index := float64(n-1) * p
+			lo := math.Floor(index)
+			hi := math.Ceil(index)
+			qs[i] = x[int(lo)]
+			if h := index - lo; index > lo && x[int(hi)] != qs[i] {
+				qs[i] = (1-h)*qs[i] + h*x[int(hi)]
+			}
+		default:
+			var nppm, j, h float64
+			if typ <= 3 {
+				nppm = float64(n) * p
+				if typ == 3 {
+					nppm -= 0.5
+				}
+				j = math.Floor(nppm + fuzz)
+				switch typ {
+				case 1:
+					h = pick(nppm > j, 1, 0)
+				case 2:
+					h = pick(nppm > j, 1, 0.5)
+				case 3:
+					h = pick(nppm != j || math.Mod(j, 2) != 0, 1, 0)
+				}
+			} else {
+				a, b := 1.0/3, 1.0/3
+				switch typ {
+				case 4:
+					a, b = 0, 1
+				case 5:
+					a, b = 0.5, 0.5
+				case 6:
+					a, b = 0, 0
+				case 9:
+					a, b = 3.0/8, 3.0/8
+				}
+				nppm = a + p*(float64(n)+1-a-b)
+				j = math.Floor(nppm + fuzz)
+				if h = nppm - j; math.Abs(h) < fuzz {
+					h = 0
+				}
+			}
+			// order statistic k (1-based), x[1] below the data and x[n]
+			// above it
+			at := func(k float64) float64 {
+				return x[int(math.Max(1, math.Min(k, float64(n))))-1]
+			}
+			lo, hi := at(j), at(j+1)
+			switch {
+			case h == 1:
+				qs[i] = hi
+			case h > 0 && h < 1 && lo != hi:
+				qs[i] = (1-h)*lo + h*hi
+			default:
+				qs[i] = lo
+			}
+		}
+	}
+	return qs
+}
+
+// quantileNames labels probabilities as R's quantile does, "25%", "12.5%"
+// or "33.33333%".
+func quantileNames(probs []float64) []string {
+	out := make([]string, len(probs))
+	for i, p := range probs {
+		if !math.IsNaN(p) {
+			out[i] = strconv.FormatFloat(signif(100*p, 7), 'f', -1, 64) + "%"
+		}
+	}
+	return out
+}
+
+// quantileType returns the type argument of quantile and IQR, 7 by default.
+func quantileType(ctx *Context, v Value) (int, error) {
+	if v == nil {
+		return 7, nil
+	}
+	f, err := asFloatElem(ctx, v)
+	if err != nil || f.NA || f.Val != math.Trunc(f.Val) || f.Val < 1 || f.Val > 9 {
+		return 0, fmt.Errorf("'type' must be an integer between 1 and 9")
+	}
+	return int(f.Val), nil
+}
+
+func builtinQuantile(ctx *Context, args []ArgValue) (Value, error) {
+	fargs, err := forceArgs(ctx, args)
+	if err != nil {
+		return nil, err
+	}
+	m, _ := matchArgs(fargs, "x", "probs", "na.rm", "names", "type", "digits", "...")
+	x, err := statData(m[0], "'x' must be numeric")
+	if err != nil {
+		return nil, err
+	}
+	naRm, err := logicalArg(ctx, m[2], false)
+	if err != nil {
+		return nil, err
+	}
+	names, err := logicalArg(ctx, m[3], true)
+	if err != nil {
+		return nil, err
+	}
+	typ, err := quantileType(ctx, m[4])
+	if err != nil {
+		return nil, err
+	}
+	probs := []float64{0, 0.25, 0.5, 0.75, 1}
+	if m[1] != nil {
+		if probs, err = statData(m[1], "'probs' must be numeric"); err != nil {
+			return nil, err
+		}
+	}
+	const eps = 100 * dblEpsilon
+	for i, p := range probs {
+		if p < -eps || p > 1+eps {
+			return nil, fmt.Errorf("'probs' outside [0,1]")
+		}
+		if !math.IsNaN(p) {
+			probs[i] = math.Max(0, math.Min(1, p))
+		}
+	}
+	s, err := sortedData(x, naRm)
+	if err != nil {
+		return nil, err
+	}
+	out := statVec(quantileSorted(s, probs, typ))
+	if names && len(probs) > 0 {
+		out.SetAttr("names", charVecOf(quantileNames(probs)))
+	}
+	return out, nil
+}
+
+func builtinIQR(ctx *Context, args []ArgValue) (Value, error) {
+	fargs, err := forceArgs(ctx, args)
+	if err != nil {
+		return nil, err
+	}
+	m, _ := matchArgs(fargs, "x", "na.rm", "type")
+	x, err := statData(m[0], "'x' must be numeric")
+	if err != nil {
+		return nil, err
+	}
+	naRm, err := logicalArg(ctx, m[1], false)
+	if err != nil {
+		return nil, err
+	}
+	typ, err := quantileType(ctx, m[2])
+	if err != nil {
+		return nil, err
+	}
+	s, err := sortedData(x, naRm)
+	if err != nil {
+		return nil, err
+	}
+	q := quantileSorted(s, []float64{0.25, 0.75}, typ)
+	return statVec([]float64{q[1] - q[0]}), nil
+}
+
+func builtinFivenum(ctx *Context, args []ArgValue) (Value, error) {
+	fargs, err := forceArgs(ctx, args)
+	if err != nil {
+		return nil, err
+	}
+	m, _ := matchArgs(fargs, "x", "na.rm")
+	x, err := statData(m[0], "'x' must be numeric")
+	if err != nil {
+		return nil, err
+	}
+	naRm, err := logicalArg(ctx, m[1], true)
+	if err != nil {
+		return nil, err
+	}
+	out := []float64{math.NaN(), math.NaN(), math.NaN(), math.NaN(), math.NaN()}
+	if !naRm && anyNaN(x...) {
+		return statVec(out), nil
+	}
+	s, _ := sortedData(x, true)
+	if n := float64(len(s)); n > 0 {
+		// Tukey's hinges: the medians of the lower and upper halves
+		n4 := math.Floor((n+3)/2) / 2
+		for i, d := range []float64{1, n4, (n + 1) / 2, n + 1 - n4, n} {
+			out[i] = 0.5 * (s[int(math.Floor(d))-1] + s[int(math.Ceil(d))-1])
+		}
+	}
+	return statVec(out), nil
+}
+
+// summaryDefault is summary() of a numeric vector: its quartiles and mean,
+// followed by the number of missing values if there are any.
+func summaryDefault(x Value) Value {
+	v := toFloats(x)
+	s, _ := sortedData(v, true)
+	q := quantileSorted(s, []float64{0, 0.25, 0.5, 0.75, 1}, 7)
+	vals := []float64{q[0], q[1], q[2], meanOf(s), q[3], q[4]}
+	names := []string{"Min.", "1st Qu.", "Median", "Mean", "3rd Qu.", "Max."}
+	if nas := len(v) - len(s); nas > 0 {
+		vals = append(vals, float64(nas))
+		names = append(names, "NA's")
+	}
+	out := statVec(vals)
+	out.SetAttr("names", charVecOf(names))
+	out.SetAttr("class", charVecOf([]string{"summaryDefault", "table"}))
+	return out
+}
+
+// formatSummaryDefault prints a numeric summary with four significant
+// digits and the count of missing values as an integer.
+func formatSummaryDefault(v Value) string {
+	x := toFloats(v)
+	names := valueNames(v)
+	var nas []string
+	if n := len(x); n > 0 && n == len(names) && names[n-1] == "NA's" {
+		nas = []string{strconv.FormatFloat(x[n-1], 'f', -1, 64)}
+		x = x[:n-1]
+	}
+	vals := append(formatRealFloats(zapsmall(x, 7), 4), nas...)
+	return strings.TrimSuffix(formatNamedVector(names, vals, 1), "\n")
+}
+
+// --- ranks ---
+
+// averageRanks returns the ranks of x, ties getting the mean of the ranks
+// they span. x must not have missing values.
+func averageRanks(x []float64) []float64 {
+	idx := make([]int, len(x))
+	for i := range idx {
+		idx[i] = i
+	}
+	sort.SliceStable(idx, func(a, b int) bool { return x[idx[a]] < x[idx[b]] })
+	out := make([]float64, len(x))
+	for s := 0; s < len(idx); {
+		e := s + 1
+		for e < len(idx) && x[idx[e]] == x[idx[s]] {
+			e++
+		}
+		for _, k := range idx[s:e] {
+			out[k] = float64(s+1+e) / 2
+		}
+		s = e
+	}
+	return out
+}
+
+func builtinRank(ctx *Context, args []ArgValue) (Value, error) {
+	fargs, err := forceArgs(ctx, args)
+	if err != nil {
+		return nil, err
+	}
+	m, _ := matchArgs(fargs, "x", "na.last", "ties.method")
+	x := m[0]
+	if x == nil {
+		return nil, fmt.Errorf("argument \"x\" is missing, with no default")
+	}
+	ties, err := choiceArg(m[2], "ties.method", "average", "first", "last", "random", "max", "min")
+	if err != nil {
+		return nil, err
+	}
+	// naLast is "TRUE", "FALSE", "NA" (drop) or "keep"
+	naLast := "TRUE"
+	if m[1] != nil {
+		if cv, ok := m[1].(*CharVec); ok && cv.Len() == 1 && cv.Data[0].Val == "keep" {
+			naLast = "keep"
+		} else if b, na, err := asLogicalScalar(ctx, m[1]); err != nil {
+			return nil, fmt.Errorf("invalid 'na.last' argument")
+		} else if na {
+			naLast = "NA"
+		} else if !b {
+			naLast = "FALSE"
+		}
+	}
+
+	var less, equal func(i, j int) bool
+	var missing []bool
+	if cv, ok := x.(*CharVec); ok {
+		less = func(i, j int) bool { return cv.Data[i].Val < cv.Data[j].Val }
+		equal = func(i, j int) bool { return cv.Data[i].Val == cv.Data[j].Val }
+		for _, e := range cv.Data {
+			missing = append(missing, e.NA)
+		}
+	} else {
+		v, err := statData(x, "argument is not a numeric, character or logical vector")
+		if err != nil {
+			return nil, err
+		}
+		less = func(i, j int) bool { return v[i] < v[j] }
+		equal = func(i, j int) bool { return v[i] == v[j] }
+		for _, f := range v {
+			missing = append(missing, math.IsNaN(f))
+		}
+	}
+	var idx []int
+	for i, na := range missing {
+		if !na {
+			idx = append(idx, i)
+		}
+	}
+	nas := len(missing) - len(idx)
+
+	// rank of each non-missing element, by position in x
+	ranks := make([]float64, len(missing))
+	if ties == "random" {
+		// ties are broken by uniform draws, one per element in order
+		u := make([]float64, len(missing))
+		for _, i := range idx {
+			u[i] = ctx.rng().unif()
+		}
+		sort.SliceStable(idx, func(a, b int) bool {
+			if equal(idx[a], idx[b]) {
+				return u[idx[a]] < u[idx[b]]
+			}
+			return less(idx[a], idx[b])
+		})
+		for k, i := range idx {
+			ranks[i] = float64(k + 1)
+		}
+	} else {
+		sort.SliceStable(idx, func(a, b int) bool { return less(idx[a], idx[b]) })
+		for s := 0; s < len(idx); {
+			e := s + 1
+			for e < len(idx) && equal(idx[e], idx[s]) {
+				e++
+			}
+			for k, i := range idx[s:e] {
+				switch ties {
+				case "average":
+					ranks[i] = float64(s+1+e) / 2
+				case "first":
+					ranks[i] = float64(s + 1 + k)
+				case "last":
+					ranks[i] = float64(e - k)
+				case "max":
+					ranks[i] = float64(e)
+				case "min":
+					ranks[i] = float64(s + 1)
+				}
+			}
+			s = e
+		}
+	}
+
+	names := valueNames(x)
+	var outNames []string
+	var out []float64
+	next := float64(len(idx))
+	if naLast == "FALSE" {
+		next = 0
+	}
+	for i, na := range missing {
+		r := ranks[i]
+		switch {
+		case !na && naLast == "FALSE":
+			r += float64(nas)
+		case na && naLast == "NA":
+			continue
+		case na && naLast == "keep":
+			r = math.NaN()
+		case na:
+			next++
+			r = next
+		}
+		out = append(out, r)
+		if names != nil {
+			outNames = append(outNames, names[i])
+		}
+	}
+
+	var res Value
+	if ties == "average" {
+		res = statVec(out)
+	} else {
+		iv := make([]IntElem, len(out))
+		for i, r := range out {
+			if math.IsNaN(r) {
+				iv[i].NA = true
+			} else {
+				iv[i].Val = int64(r)
+			}
+		}
+		res = &IntVec{Data: iv}
+	}
+	if names != nil {
+		res.SetAttr("names", charVecOf(outNames))
+	}
+	return res, nil
+}
+
+// --- covariance and correlation ---
+
+// statColumns is the x or y argument of var, cov and cor split into
+// columns.
+type statColumns struct {
+	cols   [][]float64
+	names  []string // column names, nil if there are none
+	matrix bool     // a matrix or data frame rather than a vector
+}
+
+func statColumnsOf(v Value, arg string, logicalOK bool) (*statColumns, error) {
+	msg := fmt.Sprintf("'%s' must be numeric", arg)
+	check := func(c Value) ([]float64, error) {
+		if !isNumericValue(c) && !(logicalOK && c.Type() == "logical") {
+			return nil, fmt.Errorf("%s", msg)
+		}
+		return toFloats(c), nil
+	}
+	if df, ok := v.(*ListVec); ok && isDataFrame(v) {
+		sc := &statColumns{matrix: true}
+		sc.names, _ = listNames(df)
+		for _, c := range df.Data {
+			col, err := check(c)
+			if err != nil {
+				return nil, err
+			}
+			sc.cols = append(sc.cols, col)
+		}
+		return sc, nil
+	}
+	data, err := check(v)
+	if err != nil {
+		return nil, err
+	}
+	if nr, nc, ok := matrixDims(v); ok {
+		sc := &statColumns{matrix: true, names: dimNamesAt(v, 1)}
+		for j := 0; j < nc; j++ {
+			sc.cols = append(sc.cols, data[j*nr:(j+1)*nr])
+		}
+		return sc, nil
+	}
+	return &statColumns{cols: [][]float64{data}}, nil
+}
+
+func (sc *statColumns) rows() int {
+	if len(sc.cols) == 0 {
+		return 0
+	}
+	return len(sc.cols[0])
+}
+
+// covArgs reads the x, y, use and method arguments shared by cov and cor.
+func covArgs(ctx *Context, args []ArgValue, logicalOK bool) (x, y *statColumns, use, method string, err error) {
+	fargs, err := forceArgs(ctx, args)
+	if err != nil {
+		return nil, nil, "", "", err
+	}
+	m, _ := matchArgs(fargs, "x", "y", "use", "method")
+	if m[0] == nil {
+		return nil, nil, "", "", fmt.Errorf("argument \"x\" is missing, with no default")
+	}
+	if x, err = statColumnsOf(m[0], "x", logicalOK); err != nil {
+		return nil, nil, "", "", err
+	}
+	if m[1] != nil && m[1] != NullValue {
+		if y, err = statColumnsOf(m[1], "y", logicalOK); err != nil {
+			return nil, nil, "", "", err
+		}
+	} else if !x.matrix {
+		return nil, nil, "", "", fmt.Errorf("supply both 'x' and 'y' or a matrix-like 'x'")
+	}
+	if use, err = useArg(m[2], "everything"); err != nil {
+		return nil, nil, "", "", err
+	}
+	method, err = choiceArg(m[3], "method", "pearson", "kendall", "spearman")
+	return x, y, use, method, err
+}
+
+// useArg returns the way cov and cor handle missing values.
+func useArg(v Value, def string) (string, error) {
+	if v == nil {
+		return def, nil
+	}
+	use, err := choiceArg(v, "use", "everything", "all.obs", "complete.obs", "na.or.complete", "pairwise.complete.obs")
+	if err != nil {
+		return "", fmt.Errorf("invalid 'use' argument")
+	}
+	return use, nil
+}
+
+func builtinVar(ctx *Context, args []ArgValue) (Value, error) {
+	fargs, err := forceArgs(ctx, args)
+	if err != nil {
+		return nil, err
+	}
+	m, _ := matchArgs(fargs, "x", "y", "na.rm", "use")
+	if m[0] == nil {
+		return nil, fmt.Errorf("argument \"x\" is missing, with no default")
+	}
+	if isFactor(m[0]) {
+		return nil, fmt.Errorf("Calling var(x) on a factor x is defunct.\n  Use something like 'all(duplicated(x)[-1L])' to test for a constant vector.")
+	}
+	naRm, err := logicalArg(ctx, m[2], false)
+	if err != nil {
+		return nil, err
+	}
+	use := "everything"
+	if naRm {
+		use = "na.or.complete"
+	}
+	if use, err = useArg(m[3], use); err != nil {
+		return nil, err
+	}
+	x, err := statColumnsOf(m[0], "x", true)
+	if err != nil {
+		return nil, err
+	}
+	var y *statColumns
+	if m[1] != nil && m[1] != NullValue {
+		if y, err = statColumnsOf(m[1], "y", true); err != nil {
+			return nil, err
+		}
+	}
+	return covCor(ctx, x, y, use, "pearson", false)
+}
+
+func builtinCov(ctx *Context, args []ArgValue) (Value, error) {
+	x, y, use, method, err := covArgs(ctx, args, true)
+	if err != nil {
+		return nil, err
+	}
+	return covCor(ctx, x, y, use, method, false)
+}
+
+func builtinCor(ctx *Context, args []ArgValue) (Value, error) {
+	x, y, use, method, err := covArgs(ctx, args, false)
+	if err != nil {
+		return nil, err
+	}
+	return covCor(ctx, x, y, use, method, true)
+}
+
+// covCor computes the covariances (or correlations, if cor is set) between
+// the columns of x and those of y, or of x with itself when y is nil. The
+// result is a scalar for two vectors and a matrix otherwise.
+func covCor(ctx *Context, x, y *statColumns, use, method string, cor bool) (Value, error) {
+	n := x.rows()
+	ys := x
+	all := x.cols
+	if y != nil {
+		if y.rows() != n {
+			return nil, fmt.Errorf("incompatible dimensions")
+		}
+		ys = y
+		all = append(append([][]float64{}, x.cols...), y.cols...)
+	}
+	// complete: the rows kept for every pair of columns
+	complete := make([]bool, n)
+	for i := range complete {
+		complete[i] = true
+		for _, c := range all {
+			if math.IsNaN(c[i]) {
+				complete[i] = false
+			}
+		}
+	}
+	someMissing := false
+	nComplete := 0
+	for _, ok := range complete {
+		if ok {
+			nComplete++
+		} else {
+			someMissing = true
+		}
+	}
+	switch use {
+	case "all.obs":
+		if someMissing {
+			return nil, fmt.Errorf("missing observations in cov/cor")
+		}
+	case "complete.obs":
+		if nComplete == 0 {
+			return nil, fmt.Errorf("no complete element pairs")
+		}
+	}
+
+	nx, ny := len(x.cols), len(ys.cols)
+	res := make([]float64, nx*ny)
+	zeroSD := false
+	for j := 0; j < ny; j++ {
+		for i := 0; i < nx; i++ {
+			var a, b []float64
+			for k := 0; k < n; k++ {
+				xi, yj := x.cols[i][k], ys.cols[j][k]
+				switch use {
+				case "complete.obs", "na.or.complete":
+					if !complete[k] {
+						continue
+					}
+				case "pairwise.complete.obs":
+					if math.IsNaN(xi) || math.IsNaN(yj) {
+						continue
+					}
+				}
+				a = append(a, xi)
+				b = append(b, yj)
+			}
+			if anyNaN(a...) || anyNaN(b...) {
+				res[i+j*nx] = math.NaN()
+				continue
+			}
+			r, zero := pairStat(a, b, method, cor)
+			res[i+j*nx] = r
+			zeroSD = zeroSD || zero
+		}
+	}
+	if zeroSD {
+		if err := ctx.warn("the standard deviation is zero"); err != nil {
+			return nil, err
+		}
+	}
+	out := statVec(res)
+	if x.matrix || (y != nil && y.matrix) {
+		setDims(out, nx, ny)
+		if x.names != nil || ys.names != nil {
+			setDimNames(out, x.names, ys.names)
+		}
+	}
+	return out, nil
+}
+
+// pairStat returns the covariance or correlation of x and y, which have no
+// missing values, and reports a correlation undefined because one of them
+// is constant.
+func pairStat(x, y []float64, method string, cor bool) (float64, bool) {
+	n := len(x)
+	if n < 2 {
+		return math.NaN(), false
+	}
+	if method == "kendall" {
+		// sums over all ordered pairs of observations of the products of
+		// the signs of their differences
+		var s, sx, sy float64
+		for i := range x {
+			for k := range x {
+				a, b := sign(x[i]-x[k]), sign(y[i]-y[k])
+				s += a * b
+				sx += a * a
+				sy += b * b
+			}
+		}
+		if !cor {
+			return s, false
+		}
+		if sx == 0 || sy == 0 {
+			return math.NaN(), true
+		}
+		return clampCor(s / (math.Sqrt(sx) * math.Sqrt(sy))), false
+	}
+	if method == "spearman" {
+		x, y = averageRanks(x), averageRanks(y)
+	}
+	mx, my := meanOf(x), meanOf(y)
+	var sxy, sxx, syy float64
+	for i := range x {
+		dx, dy := x[i]-mx, y[i]-my
+		sxy += dx * dy
+		sxx += dx * dx
+		syy += dy * dy
+	}
+	n1 := float64(n - 1)
+	if !cor {
+		return sxy / n1, false
+	}
+	if sxx == 0 || syy == 0 {
+		return math.NaN(), true
+	}
+	return clampCor(sxy / n1 / (math.Sqrt(sxx/n1) * math.Sqrt(syy/n1))), false
+}
+
+func sign(x float64) float64 {
+	switch {
+	case x > 0:
+		return 1
+	case x < 0:
+		return -1
+	}
+	return 0
+}
+
+func clampCor(r float64) float64 {
+	return math.Max(-1, math.Min(1, r))
+}
+
+// --- scaling ---
+
+func builtinScale(ctx *Context, args []ArgValue) (Value, error) {
+	fargs, err := forceArgs(ctx, args)
+	if err != nil {
+		return nil, err
+	}
+	m, _ := matchArgs(fargs, "x", "center", "scale")
+	if m[0] == nil {
+		return nil, fmt.Errorf("argument \"x\" is missing, with no default")
+	}
+	mat := asMatrixValue(ctx, m[0])
+	x, err := statColumnsOf(mat, "x", true)
+	if err != nil {
+		return nil, err
+	}
+	nr, nc := x.rows(), len(x.cols)
+	cols := make([][]float64, nc)
+	for j, c := range x.cols {
+		cols[j] = append([]float64(nil), c...)
+	}
+
+	// sweep subtracts (or divides by) one value per column; by is nil
+	// when the argument was FALSE
+	sweep := func(v Value, name string, def func(col []float64) float64, op func(a, b float64) float64) (Value, error) {
+		var by []float64
+		if v == nil || v.Type() == "logical" {
+			on, err := logicalArg(ctx, v, true)
+			if err != nil {
+				return nil, err
+			}
+			if !on {
+				return nil, nil
+			}
+			for _, c := range cols {
+				by = append(by, def(dropNaN(c)))
+			}
+		} else {
+			if by, err = statData(v, fmt.Sprintf("'%s' must be numeric", name)); err != nil {
+				return nil, err
+			}
+			if len(by) != nc {
+				return nil, fmt.Errorf("length of '%s' must equal the number of columns of 'x'", name)
+			}
+		}
+		for j, c := range cols {
+			for i := range c {
+				c[i] = op(c[i], by[j])
+			}
+		}
+		out := statVec(by)
+		if x.names != nil {
+			out.SetAttr("names", charVecOf(x.names))
+		}
+		return out, nil
+	}
+	center, err := sweep(m[1], "center", meanOf, func(a, b float64) float64 { return a - b })
+	if err != nil {
+		return nil, err
+	}
+	rootMeanSquare := func(c []float64) float64 {
+		s := 0.0
+		for _, v := range c {
+			s += v * v
+		}
+		return math.Sqrt(s / math.Max(1, float64(len(c)-1)))
+	}
+	scale, err := sweep(m[2], "scale", rootMeanSquare, func(a, b float64) float64 { return a / b })
+	if err != nil {
+		return nil, err
+	}
+
+	data := make([]float64, 0, nr*nc)
+	for _, c := range cols {
+		data = append(data, c...)
+	}
+	out := statVec(data)
+	setDims(out, nr, nc)
+	if dn, ok := mat.GetAttr("dimnames"); ok {
+		out.SetAttr("dimnames", dn)
+	}
+	if center != nil {
+		out.SetAttr("scaled:center", center)
+	}
+	if scale != nil {
+		out.SetAttr("scaled:scale", scale)
+	}
+	return out, nil
+}
@@ -0,0 +1,141 @@
+package rt
+
+import "testing"
+
+func TestStats(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"median(c(3, 1, 2))", "2"},
+		{"median(1:4)", "2.5"},
+		{"typeof(median(1:3))", `"integer"`},
+		{"median(c(1, NA, 3))", "NA"},
+		{"median(c(1, NA, 3), na.rm = TRUE)", "2"},
+		{"round(var(1:10), 6)", "9.166667"},
+		{"var(c(1, 2, NA))", "NA"},
+		{"var(c(1, 2, NA), na.rm = TRUE)", "0.5"},
+		{"var(1)", "NA"},
+		{"sd(c(1, 2, 3, NA), TRUE)", "1"},
+		{"x <- 1:10; y <- c(2, 1, 4, 3, 7, 8, 6, 9, 10, 12); round(cor(x, y), 6)", "0.946314"},
+		{"x <- 1:10; y <- c(2, 1, 4, 3, 7, 8, 6, 9, 10, 12); round(cor(x, y, method = \"spearman\"), 6)", "0.939394"},
+		{"x <- 1:10; y <- c(2, 1, 4, 3, 7, 8, 6, 9, 10, 12); round(cor(x, y, method = \"kendall\"), 6)", "0.822222"},
+		{"x <- 1:10; y <- c(2, 1, 4, 3, 7, 8, 6, 9, 10, 12); cov(x, y, method = \"kendall\")", "74"},
+		{"round(cor(c(1, 2, 3, 4), c(1, 3, 2, 4), method = \"kendall\"), 6)", "0.666667"},
+		{"cor(c(1, NA, 3, 4), c(2, 3, 5, 1))", "NA"},
+		{"round(cor(c(1, NA, 3, 4), c(2, 3, 5, 1), use = \"complete.obs\"), 6)", "-0.052414"},
+		{"m <- cbind(a = 1:5, b = c(2, 4, 5, 4, 5)); dim(cor(m))", "2 2"},
+		{"m <- cbind(a = 1:5, b = c(2, 4, 5, 4, 5)); colnames(cov(m))", `"a" "b"`},
+		{"m <- cbind(a = 1:5, b = c(2, 4, 5, 4, 5)); cov(m)[1, 2]", "1.5"},
+		{"d <- data.frame(a = c(1, 2, 3), b = c(3, 2, 1)); cor(d)[2, 1]", "-1"},
+		{"d <- data.frame(a = c(1, NA, 3, 4), b = c(2, 4, 6, 9)); round(cor(d, use = \"pairwise\")[1, 2], 6)", "0.994192"},
+		{"quantile(1:10)", "1 3.25 5.5 7.75 10"},
+		{"names(quantile(1:10, c(0.125, 1/3, 0.5)))", `"12.5%" "33.33333%" "50%"`},
+		{"x <- c(1, 3, 7, 8, 10, 15, 22); quantile(x, c(0.1, 0.25, 0.5, 0.9), type = 1)", "1 3 8 22"},
+		{"x <- c(1, 3, 7, 8, 10, 15, 22); quantile(x, c(0.25, 0.5), type = 2)", "3 8"},
+		{"x <- c(1, 3, 7, 8, 10, 15, 22); quantile(x, c(0.1, 0.5, 0.9), type = 3)", "1 8 15"},
+		{"x <- c(1, 3, 7, 8, 10, 15, 22); quantile(x, c(0.1, 0.25, 0.9), type = 4)", "1 2.5 17.1"},
+		{"x <- c(1, 3, 7, 8, 10, 15, 22); round(quantile(x, c(0.1, 0.9), type = 5), 6)", "1.4 20.6"},
+		{"x <- c(1, 3, 7, 8, 10, 15, 22); quantile(x, c(0.1, 0.9), type = 6)", "1 22"},
+		{"x <- c(1, 3, 7, 8, 10, 15, 22); round(quantile(x, c(0.1, 0.9), type = 7), 6)", "2.2 17.8"},
+		{"x <- c(1, 3, 7, 8, 10, 15, 22); round(quantile(x, c(0.1, 0.25), type = 8), 6)", "1.133333 3.666667"},
+		{"x <- c(1, 3, 7, 8, 10, 15, 22); round(quantile(x, c(0.1, 0.25), type = 9), 6)", "1.2 3.75"},
+		{"quantile(c(1, NA, 3), 0.5, na.rm = TRUE, names = FALSE)", "2"},
+		{"IQR(1:10)", "4.5"},
+		{"mad(c(1, 2, 3, 4, 100))", "1.4826"},
+		{"mad(c(1, 2, 3, 4), constant = 1, high = TRUE)", "1.5"},
+		{"fivenum(c(1, 2, 3, 4, 5, 6))", "1 2 3.5 5 6"},
+		{"weighted.mean(c(1, 2, 3), c(3, 2, 1)) * 3", "5"},
+		{"weighted.mean(c(1, NA, 3), c(1, 1, 2), na.rm = TRUE)", "2.3333333333333335"},
+		{"rank(c(10, 20, 10, NA, 5))", "2.5 4 2.5 5 1"},
+		{"rank(c(10, 20, 10, NA, 5), ties.method = \"min\")", "2 4 2 5 1"},
+		{"rank(c(10, 20, 10, NA, 5), ties.method = \"max\")", "3 4 3 5 1"},
+		{"rank(c(10, 20, 10, NA, 5), ties.method = \"last\")", "3 4 2 5 1"},
+		{"rank(c(10, 20, 10, NA, 5), ties.method = \"first\", na.last = \"keep\")", "2 4 3 NA 1"},
+		{"rank(c(10, 20, 10, NA, 5), na.last = FALSE)", "3.5 5 3.5 1 2"},
+		{"rank(c(10, 20, 10, NA, 5), na.last = NA)", "2.5 4 2.5 1"},
+		{`rank(c("b", "a", "c"))`, "2 1 3"},
+		{"names(rank(c(x = 2, y = 1)))", `"x" "y"`},
+		{"set.seed(1); sort(rank(c(1, 1, 1), ties.method = \"random\"))", "1 2 3"},
+		{"tabulate(c(2, 3, 3, 5), nbins = 3)", "0 1 2"},
+		{"tabulate(integer(0))", "0"},
+		{"tabulate(factor(c(\"a\", \"c\"), levels = c(\"a\", \"b\", \"c\")))", "1 0 1"},
+		{"cumall(c(TRUE, NA, TRUE))", "TRUE NA NA"},
+		{"cumall(c(TRUE, NA, FALSE, TRUE))", "TRUE NA FALSE FALSE"},
+		{"cumany(c(FALSE, NA, TRUE, FALSE))", "FALSE NA TRUE TRUE"},
+		{"as.vector(scale(c(1, 2, 3)))", "-1 0 1"},
+		{"attr(scale(matrix(c(1, 2, 3, 2, 4, 6), 3)), \"scaled:scale\")", "1 2"},
+		{"attr(scale(c(1, 2, 3), center = c(1)), \"scaled:center\")", "1"},
+		{"s <- summary(c(1, 2, NA, 4, 100)); names(s)", `"Min." "1st Qu." "Median" "Mean" "3rd Qu." "Max." "NA's"`},
+		{"unclass(summary(c(1, 2, NA, 4, 100)))[4]", "26.75"},
+	}
+
+	for _, tt := range tests {
+		ctx := NewContext()
+		res, err := ctx.EvalString(tt.input)
+		if err != nil {
+			t.Errorf("input %q: unexpected error: %v", tt.input, err)
+			continue
+		}
+		if res.Value.String() != tt.expected {
+			t.Errorf("input %q: expected %s, got %s", tt.input, tt.expected, res.Value.String())
+		}
+	}
+}
+
+func TestStatsPrint(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"print(summary(1:10))", "   Min. 1st Qu.  Median    Mean 3rd Qu.    Max. \n   1.00    3.25    5.50    5.50    7.75   10.00 \n"},
+		{"print(summary(c(1, 2, NA, 4, 100)))", "   Min. 1st Qu.  Median    Mean 3rd Qu.    Max.    NA's \n   1.00    1.75    3.00   26.75   28.00  100.00       1 \n"},
+		{"print(cor(cbind(x = c(1, 2, 3), y = c(1, 3, 2))))", "    x   y\nx 1.0 0.5\ny 0.5 1.0\n"},
+	}
+
+	for _, tt := range tests {
+		ctx := NewContext()
+		res, err := ctx.EvalString(tt.input)
+		if err != nil {
+			t.Errorf("input %q: unexpected error: %v", tt.input, err)
+			continue
+		}
+		if res.Output != tt.expected {
+			t.Errorf("input %q: expected %q, got %q", tt.input, tt.expected, res.Output)
+		}
+	}
+}
+
+func TestStatsErrors(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`median("a")`, "need numeric data"},
+		{"quantile(c(1, NA))", "missing values and NaN's not allowed if 'na.rm' is FALSE"},
+		{"quantile(1:3, 1.5)", "'probs' outside [0,1]"},
+		{"quantile(1:3, type = 10)", "'type' must be an integer between 1 and 9"},
+		{"cor(1:3)", "supply both 'x' and 'y' or a matrix-like 'x'"},
+		{"cor(1:3, 1:4)", "incompatible dimensions"},
+		{`cor(1:3, c("a", "b", "c"))`, "'y' must be numeric"},
+		{"cor(1:3, 1:3, method = \"foo\")", "'arg' should be one of “pearson”, “kendall”, “spearman”"},
+		{"cor(c(1, NA), 1:2, use = \"all.obs\")", "missing observations in cov/cor"},
+		{"cor(c(1, NA), c(NA, 1), use = \"complete.obs\")", "no complete element pairs"},
+		{"cov(1:3, 1:3, use = \"foo\")", "invalid 'use' argument"},
+		{"weighted.mean(1:3, 1:2)", "'x' and 'w' must have the same length"},
+		{"scale(matrix(1:4, 2), center = 1:3)", "length of 'center' must equal the number of columns of 'x'"},
+		{"tabulate(\"a\")", "'bin' must be numeric or a factor"},
+	}
+
+	for _, tt := range tests {
+		ctx := NewContext()
+		_, err := ctx.EvalString(tt.input)
+		if err == nil {
+			t.Errorf("input %q: expected error", tt.input)
+			continue
+		}
+		if err.Error() != tt.expected {
+			t.Errorf("input %q: expected %q, got %q", tt.input, tt.expected, err.Error())
+		}
+	}
+}
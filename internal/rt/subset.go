@@ -0,0 +1,576 @@
+package rt
+
+import (
+	"fmt"
+	"math"
+	"strings"
+
+	"simonwaldherr.de/go/smallr/internal/ast"
+)
+
+// indexOpts carries the named arguments of a subscript such as
+// x[i, j, drop = FALSE] or x[["na", exact = FALSE]].
+type indexOpts struct {
+	drop    bool
+	dropSet bool
+	exact   bool
+}
+
+// evalSubscripts evaluates the subscripts of an index expression. Empty
+// subscripts (x[, j]) become MissingValue; drop= and exact= are split off.
+func evalSubscripts(ctx *Context, env *Env, args []ast.Arg) ([]Value, indexOpts, error) {
+	opts := indexOpts{drop: true, exact: true}
+	subs := make([]Value, 0, len(args))
+	for _, a := range args {
+		if a.Name == "drop" || a.Name == "exact" {
+			if a.Value == nil {
+				continue
+			}
+			v, err := Eval(ctx, env, a.Value)
+			if err != nil {
+				return nil, opts, err
+			}
+			b, na, err := asLogicalScalar(ctx, v)
+			if err != nil {
+				return nil, opts, err
+			}
+			if a.Name == "drop" {
+				opts.drop = na || b
+				opts.dropSet = true
+			} else {
+				// exact = NA allows partial matching, like exact = FALSE
+				opts.exact = !na && b
+			}
+			continue
+		}
+		if a.Value == nil {
+			subs = append(subs, MissingValue)
+			continue
+		}
+		v, err := Eval(ctx, env, a.Value)
+		if err != nil {
+			return nil, opts, err
+		}
+		v, err = Force(ctx, v)
+		if err != nil {
+			return nil, opts, err
+		}
+		subs = append(subs, v)
+	}
+	return subs, opts, nil
+}
+
+// subsetIndexed implements x[...] and x[[...]] for any number of subscripts.
+func subsetIndexed(ctx *Context, x Value, subs []Value, opts indexOpts, dbl bool) (Value, error) {
+	if _, ok := x.(*EnvValue); ok {
+		name, err := envSubscript(subs, dbl)
+		if err != nil {
+			return nil, err
+		}
+		return dollar(ctx, x, name)
+	}
+	if e, ok := x.(*ExprValue); ok && !isSymbol(e) {
+		// calls are indexed like the list of function and arguments
+		v, err := subsetIndexed(ctx, langToList(e), subs, opts, dbl)
+		if l, ok := v.(*ListVec); ok && err == nil && !dbl {
+			return listToLang(l)
+		}
+		return v, err
+	}
+	switch len(subs) {
+	case 0:
+		return x, nil
+	case 1:
+		idx := subs[0]
+		if dbl {
+			// x[["name"]] looks the name up itself: partially unless exact,
+			// and in atomic vectors as well as lists
+			if cv, ok := idx.(*CharVec); ok && (!opts.exact || isAtomic(x)) && cv.Len() == 1 && !cv.Data[0].NA {
+				if i := matchName(valueNames(x), cv.Data[0].Val, opts.exact); i >= 0 {
+					return subset(ctx, x, IntScalar(int64(i+1)), true)
+				}
+				if isAtomic(x) {
+					return nil, fmt.Errorf("subscript out of bounds")
+				}
+				return NullValue, nil
+			}
+			return subset(ctx, x, idx, true)
+		}
+		if isDataFrame(x) {
+			// df[j] selects columns and always keeps the data frame
+			return dfSelect(ctx, x, MissingValue, idx, false)
+		}
+		if pos, ok, err := matrixSubscript(x, idx); ok {
+			if err != nil {
+				return nil, err
+			}
+			idx = pos
+		}
+		return subset(ctx, x, idx, false)
+	case 2:
+		if isDataFrame(x) {
+			if dbl {
+				col, err := subsetIndexed(ctx, x, subs[1:], opts, true)
+				if err != nil {
+					return nil, err
+				}
+				row, err := dfRowSubscript(ctx, x, subs[0])
+				if err != nil {
+					return nil, err
+				}
+				return subset(ctx, col, row, true)
+			}
+			return dfSelect(ctx, x, subs[0], subs[1], opts.drop)
+		}
+	}
+	if d := getDims(x); len(d) == len(subs) {
+		if dbl {
+			return arrayElement(ctx, x, d, subs)
+		}
+		return arraySubset(ctx, x, d, subs, opts.drop)
+	}
+	return nil, fmt.Errorf("incorrect number of dimensions")
+}
+
+// setSubsetIndexed implements x[...] <- value and x[[...]] <- value.
+func setSubsetIndexed(ctx *Context, x Value, subs []Value, rhs Value, dbl bool) (Value, error) {
+	if _, ok := x.(*EnvValue); ok {
+		name, err := envSubscript(subs, dbl)
+		if err != nil {
+			return nil, err
+		}
+		return setDollar(ctx, x, name, rhs)
+	}
+	if e, ok := x.(*ExprValue); ok && !isSymbol(e) {
+		v, err := setSubsetIndexed(ctx, langToList(e), subs, rhs, dbl)
+		if err != nil {
+			return nil, err
+		}
+		return listToLang(v.(*ListVec))
+	}
+	switch len(subs) {
+	case 0:
+		return setSubset(ctx, x, MissingValue, rhs, dbl)
+	case 1:
+		if isDataFrame(x) {
+			return dfAssign(ctx, x, MissingValue, subs[0], rhs)
+		}
+		return setSubset(ctx, x, subs[0], rhs, dbl)
+	case 2:
+		if isDataFrame(x) {
+			return dfAssign(ctx, x, subs[0], subs[1], rhs)
+		}
+	}
+	if d := getDims(x); len(d) == len(subs) {
+		return arrayAssign(ctx, x, d, subs, rhs, dbl)
+	}
+	return nil, fmt.Errorf("incorrect number of subscripts")
+}
+
+// envSubscript checks that an environment is indexed as env[["name"]].
+func envSubscript(subs []Value, dbl bool) (string, error) {
+	if !dbl {
+		return "", fmt.Errorf("object of type 'environment' is not subsettable")
+	}
+	if len(subs) == 1 {
+		if cv, ok := subs[0].(*CharVec); ok && cv.Len() == 1 && !cv.Data[0].NA {
+			return cv.Data[0].Val, nil
+		}
+	}
+	return "", fmt.Errorf("wrong args for environment subassignment")
+}
+
+// matchName returns the position of name in names, or -1. Without exact
+// matching a unique prefix match is accepted as well.
+func matchName(names []string, name string, exact bool) int {
+	for i, n := range names {
+		if n == name {
+			return i
+		}
+	}
+	if exact {
+		return -1
+	}
+	found := -1
+	for i, n := range names {
+		if strings.HasPrefix(n, name) {
+			if found >= 0 {
+				return -1 // ambiguous
+			}
+			found = i
+		}
+	}
+	return found
+}
+
+func valueNames(v Value) []string {
+	nm, ok := v.GetAttr("names")
+	if !ok {
+		return nil
+	}
+	cv, ok := nm.(*CharVec)
+	if !ok {
+		return nil
+	}
+	out := make([]string, len(cv.Data))
+	for i, e := range cv.Data {
+		if !e.NA {
+			out[i] = e.Val
+		}
+	}
+	return out
+}
+
+// resolveSubscript turns one subscript into 0-based positions for an extent
+// of length n. NA subscripts and unknown names yield -1; positions past the
+// end are returned as is and left to the caller.
+func resolveSubscript(ctx *Context, idx Value, n int, names []string) ([]int, error) {
+	switch t := idx.(type) {
+	case *Missing:
+		out := make([]int, n)
+		for i := range out {
+			out[i] = i
+		}
+		return out, nil
+	case *CharVec:
+		out := make([]int, len(t.Data))
+		for i, e := range t.Data {
+			out[i] = -1
+			if !e.NA {
+				out[i] = matchName(names, e.Val, true)
+			}
+		}
+		return out, nil
+	default:
+		indices, naMask, err := normalizeIndex(ctx, idx, n)
+		if err != nil {
+			return nil, err
+		}
+		out := make([]int, len(indices))
+		for k, i := range indices {
+			if naMask[k] {
+				out[k] = -1
+			} else {
+				out[k] = i
+			}
+		}
+		return out, nil
+	}
+}
+
+// positionsToIndex converts 0-based positions (-1 for NA) into a 1-based
+// integer subscript.
+func positionsToIndex(pos []int) *IntVec {
+	data := make([]IntElem, len(pos))
+	for k, p := range pos {
+		if p < 0 {
+			data[k] = IntElem{NA: true}
+		} else {
+			data[k] = IntElem{Val: int64(p + 1)}
+		}
+	}
+	return &IntVec{Data: data}
+}
+
+// --- Data frames ---
+
+// matrixSubscript converts a numeric matrix with one column per dimension
+// of the array x, as in m[cbind(i, j)], to the positions of the elements
+// it selects, one per row. Rows with a 0 select nothing and rows with an
+// NA give NA. ok is false for any other subscript.
+func matrixSubscript(x, idx Value) (pos *IntVec, ok bool, err error) {
+	d, id := getDims(x), getDims(idx)
+	if len(d) < 2 || len(id) != 2 || id[1] != len(d) || (idx.Type() != "integer" && idx.Type() != "double") {
+		return nil, false, nil
+	}
+	vals := toFloats(idx)
+	nr := id[0]
+	pos = &IntVec{}
+rows:
+	for i := 0; i < nr; i++ {
+		p, stride, na := 0, 1, false
+		for k, n := range d {
+			f := vals[i+k*nr]
+			switch {
+			case math.IsNaN(f):
+				na = true
+				continue
+			case f < 0:
+				return nil, true, fmt.Errorf("negative values are not allowed in a matrix subscript")
+			case int(f) == 0:
+				continue rows
+			case int(f) > n:
+				return nil, true, fmt.Errorf("subscript out of bounds")
+			}
+			p += (int(f) - 1) * stride
+			stride *= n
+		}
+		pos.Data = append(pos.Data, IntElem{Val: int64(p + 1), NA: na})
+	}
+	return pos, true, nil
+}
+
+func newDataFrame(cols []Value, names []StringElem, rowNames Value) *ListVec {
+	df := &ListVec{Data: cols}
+	df.SetAttr("names", &CharVec{Data: names})
+	df.SetAttr("class", &CharVec{Data: []StringElem{{Val: "data.frame"}}})
+	if rowNames == nil {
+		nrow := 0
+		if len(cols) > 0 {
+			nrow = frameRows(cols[0])
+		}
+		// default 1:nrow
+		rn := make([]IntElem, nrow)
+		for i := 0; i < nrow; i++ {
+			rn[i] = IntElem{Val: int64(i + 1)}
+		}
+		rowNames = &IntVec{Data: rn}
+	}
+	df.SetAttr("row.names", rowNames)
+	return df
+}
+
+func dfNRow(df *ListVec) int {
+	if rn, ok := df.GetAttr("row.names"); ok && rn != NullValue {
+		return rn.Len()
+	}
+	if len(df.Data) > 0 {
+		return df.Data[0].Len()
+	}
+	return 0
+}
+
+func dfRowNames(df *ListVec) []string {
+	rn, ok := df.GetAttr("row.names")
+	if !ok {
+		return nil
+	}
+	return toPlainStrings(rn)
+}
+
+// dfRowSubscript maps a row subscript (possibly by row name) to a 1-based
+// integer subscript.
+func dfRowSubscript(ctx *Context, x Value, i Value) (Value, error) {
+	df := x.(*ListVec)
+	if _, ok := i.(*CharVec); !ok {
+		return i, nil
+	}
+	rows, err := resolveSubscript(ctx, i, dfNRow(df), dfRowNames(df))
+	if err != nil {
+		return nil, err
+	}
+	return positionsToIndex(rows), nil
+}
+
+// dfSelect implements df[i, j]. A missing i or j selects everything; with
+// drop a single selected column is returned as a plain vector.
+func dfSelect(ctx *Context, x Value, i, j Value, drop bool) (Value, error) {
+	df, ok := x.(*ListVec)
+	if !ok {
+		return nil, fmt.Errorf("expected data.frame to be a list")
+	}
+	colNames, _ := listNames(df)
+	cols, err := resolveSubscript(ctx, j, len(df.Data), colNames)
+	if err != nil {
+		return nil, err
+	}
+	for _, c := range cols {
+		if c < 0 || c >= len(df.Data) {
+			return nil, fmt.Errorf("undefined columns selected")
+		}
+	}
+
+	var rowIdx Value = MissingValue
+	if i != MissingValue {
+		rows, err := resolveSubscript(ctx, i, dfNRow(df), dfRowNames(df))
+		if err != nil {
+			return nil, err
+		}
+		rowIdx = positionsToIndex(rows)
+	}
+
+	if drop && len(cols) == 1 {
+		return subset(ctx, df.Data[cols[0]], rowIdx, false)
+	}
+
+	outCols := make([]Value, len(cols))
+	outNames := make([]StringElem, len(cols))
+	for k, c := range cols {
+		v, err := subset(ctx, df.Data[c], rowIdx, false)
+		if err != nil {
+			return nil, err
+		}
+		outCols[k] = v
+		outNames[k] = StringElem{Val: colNames[c]}
+	}
+	var rowNames Value
+	if rn, ok := df.GetAttr("row.names"); ok {
+		rowNames, err = subset(ctx, rn, rowIdx, false)
+		if err != nil {
+			return nil, err
+		}
+	}
+	out := newDataFrame(outCols, outNames, rowNames)
+	for k, a := range df.Attrs() {
+		if k != "names" && k != "row.names" {
+			out.SetAttr(k, a)
+		}
+	}
+	return out, nil
+}
+
+// dfAssign implements df[i, j] <- value, df[j] <- value and df$j <- value.
+// Unknown column names (or positions past the last column) add columns;
+// assigning NULL to whole columns removes them.
+func dfAssign(ctx *Context, x Value, i, j Value, rhs Value) (Value, error) {
+	df, ok := x.(*ListVec)
+	if !ok {
+		return nil, fmt.Errorf("expected data.frame to be a list")
+	}
+	rhs, err := Force(ctx, rhs)
+	if err != nil {
+		return nil, err
+	}
+	nrow := dfNRow(df)
+	out := cloneList(df)
+	colNames, _ := listNames(df)
+	for len(colNames) < len(out.Data) {
+		colNames = append(colNames, "")
+	}
+
+	// resolve columns, creating new ones as needed
+	var cols []int
+	if cv, ok := j.(*CharVec); ok {
+		for _, e := range cv.Data {
+			if e.NA {
+				return nil, fmt.Errorf("missing values are not allowed in subscripted assignments of data frames")
+			}
+			c := matchName(colNames, e.Val, true)
+			if c < 0 {
+				c = len(colNames)
+				colNames = append(colNames, e.Val)
+			}
+			cols = append(cols, c)
+		}
+	} else {
+		cols, err = resolveSubscript(ctx, j, len(df.Data), colNames)
+		if err != nil {
+			return nil, err
+		}
+		for _, c := range cols {
+			if c < 0 {
+				return nil, fmt.Errorf("missing values are not allowed in subscripted assignments of data frames")
+			}
+			for len(colNames) <= c {
+				colNames = append(colNames, fmt.Sprintf("V%d", len(colNames)+1))
+			}
+		}
+	}
+
+	if rhs == NullValue {
+		if i != MissingValue {
+			return nil, fmt.Errorf("replacement has length zero")
+		}
+		drop := map[int]bool{}
+		for _, c := range cols {
+			drop[c] = true
+		}
+		var keptCols []Value
+		var keptNames []StringElem
+		for c, v := range out.Data {
+			if !drop[c] {
+				keptCols = append(keptCols, v)
+				keptNames = append(keptNames, StringElem{Val: colNames[c]})
+			}
+		}
+		out.Data = keptCols
+		out.SetAttr("names", &CharVec{Data: keptNames})
+		return out, nil
+	}
+	if rhs.Len() == 0 {
+		return nil, fmt.Errorf("replacement has length zero")
+	}
+
+	var rowIdx Value = MissingValue
+	nsel := nrow
+	if i != MissingValue {
+		rows, err := resolveSubscript(ctx, i, nrow, dfRowNames(df))
+		if err != nil {
+			return nil, err
+		}
+		kept := rows[:0:0]
+		for _, r := range rows {
+			if r >= 0 {
+				kept = append(kept, r)
+			}
+		}
+		rowIdx = positionsToIndex(kept)
+		nsel = len(kept)
+	}
+
+	rhsList, isList := rhs.(*ListVec)
+	for k, c := range cols {
+		// the part of rhs destined for this column
+		var part Value
+		if isList && len(rhsList.Data) == len(cols) {
+			part = rhsList.Data[k]
+		} else {
+			part, err = recyclePart(ctx, rhs, k*nsel, nsel)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		var col Value
+		if i == MissingValue {
+			if part.Len() != nrow {
+				part, err = recycleTo(ctx, part, nrow)
+				if err != nil {
+					return nil, err
+				}
+			}
+			col = part
+		} else {
+			var cur Value
+			if c < len(out.Data) {
+				cur = out.Data[c]
+			} else {
+				cur = makeNAOfType(part.Type(), nrow)
+			}
+			col, err = setSubset(ctx, cur, rowIdx, part, false)
+			if err != nil {
+				return nil, err
+			}
+		}
+		for len(out.Data) <= c {
+			out.Data = append(out.Data, makeNAOfType("logical", nrow))
+		}
+		out.Data[c] = col
+	}
+	names := make([]StringElem, len(out.Data))
+	for c := range names {
+		names[c] = StringElem{Val: colNames[c]}
+	}
+	out.SetAttr("names", &CharVec{Data: names})
+	return out, nil
+}
+
+// recyclePart returns n elements of v starting at offset, recycling v.
+func recyclePart(ctx *Context, v Value, offset, n int) (Value, error) {
+	if v.Len() == 0 {
+		return nil, fmt.Errorf("replacement has length zero")
+	}
+	if offset == 0 && v.Len() == n {
+		return v, nil
+	}
+	pos := make([]int, n)
+	for r := range pos {
+		pos[r] = (offset + r) % v.Len()
+	}
+	out, err := subset(ctx, v, positionsToIndex(pos), false)
+	if err != nil {
+		return nil, err
+	}
+	out.SetAttr("names", nil)
+	return out, nil
+}
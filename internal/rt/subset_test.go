@@ -0,0 +1,88 @@
+package rt
+
+import "testing"
+
+func TestDataFrameSubset(t *testing.T) {
+	const df = `df <- data.frame(x = c(1, 2, 3, 4, 5), y = c("a", "b", "c", "d", "e")); `
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{df + `df[2, "y"]`, `"b"`},
+		{df + `df[1:3, "x"]`, "1 2 3"},
+		{df + `df[, 2]`, `"a" "b" "c" "d" "e"`},
+		{df + `nrow(df[df$x > 3, ])`, "2"},
+		{df + `df[df$x > 3, ]$y`, `"d" "e"`},
+		{df + `is.data.frame(df[, "x", drop = FALSE])`, "TRUE"},
+		{df + `ncol(df[c("y", "x")])`, "2"},
+		{df + `df[["y"]][2]`, `"b"`},
+		{df + `df[[4, "x"]]`, "4"},
+		{df + `df[["x", exact = FALSE]][1]`, "1"},
+		{df + `df[df$x > 3, "x"] <- 0; df$x`, "1 2 3 0 0"},
+		{df + `df$z <- df$x * 2; df[, "z"]`, "2 4 6 8 10"},
+		{df + `df$y <- NULL; names(df)`, `"x"`},
+	}
+
+	for _, tt := range tests {
+		ctx := NewContext()
+		res, err := ctx.EvalString(tt.input)
+		if err != nil {
+			t.Errorf("input %q: unexpected error: %v", tt.input, err)
+			continue
+		}
+		if res.Value.String() != tt.expected {
+			t.Errorf("input %q: expected %s, got %s", tt.input, tt.expected, res.Value.String())
+		}
+	}
+}
+
+func TestVectorSubsetAssign(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"v <- c(1, 2, 3, 4); v[v > 2] <- 0; v", "1 2 0 0"},
+		{"v <- c(1, 2, 3); v[5] <- 9; v", "1 2 3 NA 9"},
+		{"v <- c(1, 2, 3); v[2] <- \"b\"; v", `"1" "b" "3"`},
+		{"v <- c(a = 1, b = 2); v[\"b\"]", "2"},
+		{"l <- list(a = 1, b = 2); l[[\"b\"]]", "2"},
+		{"v <- c(a = 1, b = 2); v[[\"b\"]]", "2"},
+		{"v <- c(abc = 1, b = 2); v[[\"a\", exact = FALSE]]", "1"},
+		{"x <- c(1, 2, 3); y <- 2 * x + 1; round(coef(lm(y ~ x))[[\"x\"]], 6)", "2"},
+		{"l <- list(a = 1); l[[\"z\"]]", "NULL"},
+		{"l <- list(a = 1, b = 2); l$a <- NULL; length(l)", "1"},
+		{"m <- c(5, 6, 7); m[c(1, m[1] - 3)]", "5 6"},
+		{"x <- c(1, 2); x[]", "1 2"},
+	}
+
+	for _, tt := range tests {
+		ctx := NewContext()
+		res, err := ctx.EvalString(tt.input)
+		if err != nil {
+			t.Errorf("input %q: unexpected error: %v", tt.input, err)
+			continue
+		}
+		if res.Value.String() != tt.expected {
+			t.Errorf("input %q: expected %s, got %s", tt.input, tt.expected, res.Value.String())
+		}
+	}
+}
+
+func TestSubsetErrors(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"v <- c(a = 1, b = 2); v[[\"z\"]]", "subscript out of bounds"},
+		{"v <- c(abc = 1, b = 2); v[[\"a\"]]", "subscript out of bounds"},
+		{"v <- c(a = 1); v[[\"z\", exact = FALSE]]", "subscript out of bounds"},
+	}
+
+	for _, tt := range tests {
+		ctx := NewContext()
+		_, err := ctx.EvalString(tt.input)
+		if err == nil || err.Error() != tt.expected {
+			t.Errorf("input %q: expected error %q, got %v", tt.input, tt.expected, err)
+		}
+	}
+}
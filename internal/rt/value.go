@@ -9,6 +9,7 @@ import (
 	"strings"
 
 	"simonwaldherr.de/go/smallr/internal/ast"
+	"simonwaldherr.de/go/smallr/internal/deparse"
 )
 
 type Value interface {
@@ -51,6 +52,15 @@ func (b *Base) SetAttr(name string, v Value) {
 	b.attrs[name] = v
 }
 
+// cloneAttrs returns a Base with a copy of b's attributes.
+func (b *Base) cloneAttrs() Base {
+	out := Base{}
+	for k, v := range b.attrs {
+		out.SetAttr(k, v)
+	}
+	return out
+}
+
 type Null struct{ Base }
 
 func (n *Null) Type() string { return "null" }
@@ -86,6 +96,11 @@ type FloatElem struct {
 	NA  bool
 }
 
+type ComplexElem struct {
+	Val complex128
+	NA  bool
+}
+
 type StringElem struct {
 	Val string
 	NA  bool
@@ -99,7 +114,7 @@ type LogicalVec struct {
 func (v *LogicalVec) Type() string { return "logical" }
 func (v *LogicalVec) Len() int     { return len(v.Data) }
 func (v *LogicalVec) String() string {
-	return formatAtomic(func(i int) (string, bool) {
+	return formatAtomic(v.Type(), func(i int) (string, bool) {
 		e := v.Data[i]
 		if e.NA {
 			return "NA", true
@@ -119,7 +134,7 @@ type IntVec struct {
 func (v *IntVec) Type() string { return "integer" }
 func (v *IntVec) Len() int     { return len(v.Data) }
 func (v *IntVec) String() string {
-	return formatAtomic(func(i int) (string, bool) {
+	return formatAtomic(v.Type(), func(i int) (string, bool) {
 		e := v.Data[i]
 		if e.NA {
 			return "NA", true
@@ -136,26 +151,78 @@ type DoubleVec struct {
 func (v *DoubleVec) Type() string { return "double" }
 func (v *DoubleVec) Len() int     { return len(v.Data) }
 func (v *DoubleVec) String() string {
-	return formatAtomic(func(i int) (string, bool) {
+	return formatAtomic(v.Type(), func(i int) (string, bool) {
 		e := v.Data[i]
 		if e.NA {
 			return "NA", true
 		}
-		if math.IsNaN(e.Val) {
-			return "NaN", true
-		}
-		if math.IsInf(e.Val, 1) {
-			return "Inf", true
+		return formatFloat(e.Val), true
+	}, len(v.Data))
+}
+
+func formatFloat(f float64) string {
+	switch {
+	case math.IsNaN(f):
+		return "NaN"
+	case math.IsInf(f, 1):
+		return "Inf"
+	case math.IsInf(f, -1):
+		return "-Inf"
+	}
+	if f == 0 {
+		f = 0 // R shows -0 as 0
+	}
+	// As R (with scipen = 0) does, use fixed notation unless it is wider
+	// than scientific notation: 1234567 but 1e+06, 0.001 but 1e-04.
+	sci := strconv.FormatFloat(f, 'e', -1, 64)
+	mant, exp, _ := strings.Cut(sci, "e")
+	e, _ := strconv.Atoi(exp)
+	digits := len(strings.TrimLeft(strings.Replace(mant, ".", "", 1), "-"))
+	width := 0
+	if f < 0 {
+		width = 1
+	}
+	if e >= 0 {
+		width += e + 1
+		if frac := digits - 1 - e; frac > 0 {
+			width += frac + 1
 		}
-		if math.IsInf(e.Val, -1) {
-			return "-Inf", true
+	} else {
+		width += 1 + -e + digits // "0." then the zeros and digits
+	}
+	if width <= len(sci) {
+		return strconv.FormatFloat(f, 'f', -1, 64)
+	}
+	return sci
+}
+
+type ComplexVec struct {
+	Base
+	Data []ComplexElem
+}
+
+func (v *ComplexVec) Type() string { return "complex" }
+func (v *ComplexVec) Len() int     { return len(v.Data) }
+func (v *ComplexVec) String() string {
+	return formatAtomic(v.Type(), func(i int) (string, bool) {
+		e := v.Data[i]
+		if e.NA {
+			return "NA", true
 		}
-		// R prints without trailing .0 sometimes; keep compact
-		s := strconv.FormatFloat(e.Val, 'g', -1, 64)
-		return s, true
+		return formatComplex(e.Val), true
 	}, len(v.Data))
 }
 
+// formatComplex writes c as R does, e.g. 1+2i, 0-1i or 1.5+0i.
+func formatComplex(c complex128) string {
+	re, im := real(c), imag(c)
+	sign := "+"
+	if im < 0 || math.IsInf(im, -1) {
+		sign, im = "-", -im
+	}
+	return formatFloat(re) + sign + formatFloat(im) + "i"
+}
+
 type CharVec struct {
 	Base
 	Data []StringElem
@@ -164,7 +231,7 @@ type CharVec struct {
 func (v *CharVec) Type() string { return "character" }
 func (v *CharVec) Len() int     { return len(v.Data) }
 func (v *CharVec) String() string {
-	return formatAtomic(func(i int) (string, bool) {
+	return formatAtomic(v.Type(), func(i int) (string, bool) {
 		e := v.Data[i]
 		if e.NA {
 			return "NA", true
@@ -197,15 +264,25 @@ type ExprValue struct {
 	Expr ast.Expr
 }
 
-func (e *ExprValue) Type() string { return "expr" }
-func (e *ExprValue) Len() int     { return 1 }
-func (e *ExprValue) String() string {
-	if e.Expr == nil {
-		return "expression()"
+// Type is "symbol" for a quoted name and "language" for any other quoted
+// expression, as typeof() reports in R.
+func (e *ExprValue) Type() string {
+	if _, ok := e.Expr.(*ast.Ident); ok {
+		return "symbol"
+	}
+	return "language"
+}
+
+// Len is 1 for a symbol and the number of arguments plus one for a call.
+func (e *ExprValue) Len() int {
+	if _, args, ok := callParts(e.Expr); ok {
+		return len(args) + 1
 	}
-	return "expression(" + e.Expr.String() + ")"
+	return 1
 }
 
+func (e *ExprValue) String() string { return deparse.Expr(e.Expr) }
+
 type Promise struct {
 	Base
 	Expr   ast.Expr
@@ -251,6 +328,27 @@ func (d *Dots) String() string {
 	return "<...>"
 }
 
+// EnvValue exposes an environment as an R value. Environments have
+// reference semantics: all copies of the value share the same bindings.
+type EnvValue struct {
+	Base
+	Env *Env
+}
+
+func (e *EnvValue) Type() string { return "environment" }
+func (e *EnvValue) Len() int     { return len(e.Env.vars) }
+func (e *EnvValue) String() string {
+	if e.Env.name != "" {
+		return "<environment: " + e.Env.name + ">"
+	}
+	return fmt.Sprintf("<environment: %p>", e.Env)
+}
+
+// Callable is a value that can be called as an R function. Closures and
+// builtins implement it, and so can Go types, which then work wherever R
+// takes a function: in calls, lapply, Reduce, do.call and so on. The
+// arguments may be unevaluated promises; Force evaluates them. caller is
+// the environment of the call, nil if it comes from Go code.
 type Callable interface {
 	Value
 	Call(ctx *Context, caller *Env, args []ArgValue) (Value, error)
@@ -259,8 +357,10 @@ type Callable interface {
 
 type BuiltinFunc struct {
 	Base
-	FnName string
-	Impl   func(ctx *Context, args []ArgValue) (Value, error)
+	FnName  string
+	Impl    func(ctx *Context, args []ArgValue) (Value, error)
+	Generic bool   // dispatch to S3 methods on classed first arguments
+	Group   string // group generic the builtin belongs to ("Math", "Summary")
 }
 
 func (b *BuiltinFunc) Type() string { return "function" }
@@ -270,7 +370,14 @@ func (b *BuiltinFunc) String() string {
 }
 func (b *BuiltinFunc) Name() string { return b.FnName }
 func (b *BuiltinFunc) Call(ctx *Context, caller *Env, args []ArgValue) (Value, error) {
-	_ = caller
+	if b.Generic || b.Group != "" {
+		if v, ok, err := dispatchInternal(ctx, caller, b, args); ok {
+			return v, err
+		}
+	}
+	saved := ctx.builtinEnv
+	ctx.builtinEnv = caller
+	defer func() { ctx.builtinEnv = saved }()
 	return b.Impl(ctx, args)
 }
 
@@ -298,8 +405,7 @@ func (c *ClosureFunc) String() string {
 }
 func (c *ClosureFunc) Name() string { return c.FnName }
 func (c *ClosureFunc) Call(ctx *Context, caller *Env, args []ArgValue) (Value, error) {
-	_ = caller
-	return callClosure(ctx, c, args)
+	return callClosure(ctx, &Frame{Fn: c, Args: args, Caller: ctx.callerEnv(caller)})
 }
 
 // --- Constructors ---
@@ -313,6 +419,10 @@ func IntNA() *IntVec            { return &IntVec{Data: []IntElem{{NA: true}}} }
 func DoubleScalar(v float64) *DoubleVec { return &DoubleVec{Data: []FloatElem{{Val: v}}} }
 func DoubleNA() *DoubleVec              { return &DoubleVec{Data: []FloatElem{{NA: true}}} }
 
+func ComplexScalar(v complex128) *ComplexVec {
+	return &ComplexVec{Data: []ComplexElem{{Val: v}}}
+}
+
 func CharScalar(v string) *CharVec { return &CharVec{Data: []StringElem{{Val: v}}} }
 func CharNA() *CharVec             { return &CharVec{Data: []StringElem{{NA: true}}} }
 
@@ -320,9 +430,11 @@ func List(values ...Value) *ListVec { return &ListVec{Data: values} }
 
 // --- Formatting helpers ---
 
-func formatAtomic(get func(i int) (string, bool), n int) string {
+// formatAtomic joins the n formatted elements of a vector of type typ.
+// An empty vector prints as R prints it, e.g. character(0).
+func formatAtomic(typ string, get func(i int) (string, bool), n int) string {
 	if n == 0 {
-		return "c()"
+		return emptyVectorFn[typ] + "(0)"
 	}
 	if n == 1 {
 		s, _ := get(0)
@@ -345,7 +457,67 @@ func formatValueForPrint(v Value) string {
 		// cannot force without ctx here; show promise
 		_ = p
 	}
-	return v.String()
+	if isFactor(v) {
+		return formatFactor(v)
+	}
+	if isCondition(v) {
+		return formatCondition(v)
+	}
+	if isFormula(v) {
+		return v.String()
+	}
+	if hasClass(v, "glm") {
+		return strings.TrimSuffix(formatGlm(v), "\n")
+	}
+	if hasClass(v, "lm") {
+		return strings.TrimSuffix(formatLm(v), "\n")
+	}
+	if hasClass(v, "summary.glm") {
+		return strings.TrimSuffix(formatSummaryGlm(v), "\n")
+	}
+	if hasClass(v, "anova") {
+		return strings.TrimSuffix(formatAnova(v), "\n")
+	}
+	if hasClass(v, "family") {
+		return strings.TrimSuffix(formatFamily(v), "\n")
+	}
+	if hasClass(v, "summaryDefault") {
+		return formatSummaryDefault(v)
+	}
+	if hasClass(v, "summary.lm") {
+		return strings.TrimSuffix(formatSummaryLm(v), "\n")
+	}
+	if fn, ok := v.(*ClosureFunc); ok {
+		return formatClosure(fn)
+	}
+	var out string
+	if d := getDims(v); len(d) >= 2 && !isDataFrame(v) {
+		out = formatArray(v, d)
+	} else if names := valueNames(v); names != nil && isAtomic(v) && v.Len() > 0 {
+		out = formatNamed(v, names)
+	} else {
+		out = v.String()
+	}
+	// I() only marks a value for formulas and prints as the value
+	if classAttr(v) != nil && !isDataFrame(v) && !(len(classAttr(v)) == 1 && hasClass(v, "AsIs")) {
+		out += "\n" + formatClassAttr(v)
+	}
+	return out
+}
+
+// formatClosure prints a function as its source, followed by the
+// environment it was defined in unless that is the global one.
+func formatClosure(fn *ClosureFunc) string {
+	out := deparse.Expr(closureExpr(fn))
+	if fn.Env != nil && fn.Env.name == "" {
+		out += "\n" + (&EnvValue{Env: fn.Env}).String()
+	}
+	return out
+}
+
+// formatNamed prints a named vector with each name above its element.
+func formatNamed(v Value, names []string) string {
+	return strings.TrimSuffix(formatNamedVector(names, formatColumn(v, 0, v.Len()), 1), "\n")
 }
 
 func listNames(l *ListVec) ([]string, bool) {
@@ -432,6 +604,24 @@ func toJSONValue(v Value) any {
 			}
 		}
 		return arr
+	case *ComplexVec:
+		// JSON has no complex numbers; they are written as R prints them
+		if t.Len() == 1 {
+			e := t.Data[0]
+			if e.NA {
+				return nil
+			}
+			return formatComplex(e.Val)
+		}
+		arr := make([]any, 0, t.Len())
+		for _, e := range t.Data {
+			if e.NA {
+				arr = append(arr, nil)
+			} else {
+				arr = append(arr, formatComplex(e.Val))
+			}
+		}
+		return arr
 	case *CharVec:
 		if t.Len() == 1 {
 			e := t.Data[0]
@@ -481,10 +671,7 @@ func toJSONValue(v Value) any {
 		}
 		return arr
 	case *ExprValue:
-		if t.Expr == nil {
-			return "expression()"
-		}
-		return t.Expr.String()
+		return deparse.Expr(t.Expr)
 	case *Promise:
 		// do not force for JSON
 		if t.forced {
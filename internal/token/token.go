@@ -39,6 +39,8 @@ const (
 	ASSIGN_EQ    Type = "="
 	ASSIGN_SUPER Type = "<<-"
 
+	ASSIGN_SUPER_RIGHT Type = "->>"
+
 	PLUS   Type = "+"
 	MINUS  Type = "-"
 	STAR   Type = "*"
@@ -47,6 +49,8 @@ const (
 	MOD    Type = "%%"
 	INTDIV Type = "%/%"
 	INOP   Type = "%in%"
+	MATMUL Type = "%*%"
+	OUTER  Type = "%o%"
 	COLON  Type = ":"
 	BANG   Type = "!"
 	AND    Type = "&"
@@ -61,7 +65,12 @@ const (
 	EQ     Type = "=="
 	NEQ    Type = "!="
 
-	DOLLAR Type = "$"
+	DOLLAR     Type = "$"
+	AT         Type = "@"
+	NS_GET     Type = "::"
+	NS_GET_INT Type = ":::"
+	TILDE      Type = "~"
+	QUESTION   Type = "?"
 
 	COMMA   Type = ","
 	LPAREN  Type = "("
@@ -74,6 +83,13 @@ const (
 	RDBRACK Type = "]]"
 )
 
+// IsSpecial reports whether t is a %op% operator. Besides the built-in
+// ones above, any %name% is an operator: its type is its spelling, and it
+// calls the function of that name.
+func IsSpecial(t Type) bool {
+	return len(t) >= 2 && t[0] == '%' && t[len(t)-1] == '%'
+}
+
 type Pos struct {
 	Offset int
 	Line   int
@@ -88,6 +104,11 @@ type Token struct {
 	Type Type
 	Lit  string
 	Pos  Pos
+	End  Pos // position just after the token
+
+	// AfterNewline is set when a newline inside parentheses or brackets,
+	// where it does not end a statement, preceded the token.
+	AfterNewline bool
 }
 
 func (t Token) String() string {
@@ -1,6 +1,7 @@
 package smallr
 
 import (
+	"context"
 	"io"
 
 	"simonwaldherr.de/go/smallr/internal/ast"
@@ -44,16 +45,40 @@ func NewParser(src string) *Parser {
 	return parser.New(src)
 }
 
-// ParseProgram parst ein komplettes Programm und gibt das AST zurück.
-func ParseProgram(p *Parser) (*ast.Program, error) {
-	return p.ParseProgram()
+// ParseProgram parst ein komplettes Programm und gibt es als Program
+// zurück; bei Syntaxfehlern enthält es die fehlerfrei geparsten Ausdrücke.
+func ParseProgram(p *Parser) (*Program, error) {
+	prog, err := p.ParseProgram()
+	return &Program{Program: prog}, err
 }
 
-// Program und Expr sind Aliase für die AST-Typen
-type Program = ast.Program
+// Diagnostic ist ein Alias für internal/parser.Diagnostic: ein Syntaxfehler
+// mit Start- und Endposition und den an dieser Stelle erwarteten Tokens.
+type Diagnostic = parser.Diagnostic
+
+// ErrorList ist ein Alias für internal/parser.ErrorList. ParseProgram gibt
+// alle Syntaxfehler eines Quelltexts als ErrorList zurück.
+type ErrorList = parser.ErrorList
+
+// Check parst den Quelltext und liefert alle Syntaxfehler in einem
+// Durchlauf, z. B. für Editor-Integrationen oder einen Lint-Schritt.
+// Beispiel:
+//
+//	for _, d := range smallr.Check(src) {
+//		fmt.Printf("%s-%s: %s\n", d.Start, d.End, d.Msg)
+//	}
+func Check(src string) []*Diagnostic {
+	p := parser.New(src)
+	p.ParseProgram() // das Ergebnis steht auch in p.Diagnostics()
+	return p.Diagnostics()
+}
+
+// Expr ist ein Alias für die AST-Ausdrücke
 type Expr = ast.Expr
 
-// Context ist ein Alias für internal/rt.Context
+// Context ist ein Alias für internal/rt.Context. Mit Set und Get tauschen
+// Host-Programme Variablen mit R aus, mit RegisterFunc machen sie
+// Go-Funktionen in R aufrufbar, und mit Call rufen sie R-Funktionen auf.
 type Context = rt.Context
 
 // NewContext erstellt einen neuen Auswertungskontext mit Standard-Builtins.
@@ -65,6 +90,11 @@ func NewContextWithOutput(w io.Writer) *Context { return rt.NewContextWithOutput
 // EvalResult ist ein Alias für internal/rt.EvalResult
 type EvalResult = rt.EvalResult
 
+// RuntimeError ist ein Alias für internal/rt.RuntimeError. Laufzeitfehler
+// enthalten den fehlgeschlagenen Aufruf, die Quellposition und den
+// Aufrufstapel; Report() liefert die Meldung im Stil von R.
+type RuntimeError = rt.RuntimeError
+
 // Env, Value sind Aliase für die entsprechenden Laufzeit-Typen
 type Env = rt.Env
 type Value = rt.Value
@@ -79,3 +109,137 @@ func Eval(ctx *Context, env *Env, expr Expr) (Value, error) {
 func EvalString(ctx *Context, src string) (EvalResult, error) {
 	return ctx.EvalString(src)
 }
+
+// EvalScript wertet den Quelltext eines Skripts aus; Laufzeitfehler
+// nennen den Skriptnamen in ihrer Position.
+func EvalScript(ctx *Context, name, src string) (EvalResult, error) {
+	return ctx.EvalScript(name, src)
+}
+
+// ErrInterrupted wird (zusammen mit context.Canceled bzw.
+// context.DeadlineExceeded) zurückgegeben, wenn eine Auswertung über ihren
+// context.Context abgebrochen wurde. tryCatch und try fangen es nicht ab.
+var ErrInterrupted = rt.ErrInterrupted
+
+// EvalStringContext ist wie EvalString, bricht die Auswertung aber ab,
+// sobald c beendet ist, etwa nach einem Timeout. Schleifen,
+// Funktionsaufrufe und die apply-Funktionen prüfen c laufend. Beispiel:
+//
+//	c, cancel := context.WithTimeout(context.Background(), time.Second)
+//	defer cancel()
+//	res, err := smallr.EvalStringContext(c, ctx, "repeat {}")
+//	if errors.Is(err, smallr.ErrInterrupted) { ... }
+func EvalStringContext(c context.Context, ctx *Context, src string) (EvalResult, error) {
+	return ctx.EvalStringContext(c, src)
+}
+
+// Program ist ein Alias für internal/rt.Program: ein einmal geparstes
+// Skript, das mit Run beliebig oft ausgewertet werden kann. Es enthält den
+// AST, Exprs und String stehen also wie beim geparsten Programm zur
+// Verfügung. Ein Program wird beim Ausführen nicht verändert und darf von
+// mehreren Goroutinen zugleich verwendet werden, jede mit ihrem eigenen
+// Context.
+type Program = rt.Program
+
+// Compile parst den Quelltext einmalig zu einem Program. Beispiel:
+//
+//	prog, err := smallr.Compile("score <- sum(x * w)")
+//	res, err := prog.Run(ctx, map[string]smallr.Value{"x": x, "w": w})
+//
+// Run wertet das Programm in einem neuen Kind-Environment des globalen
+// Environments aus; die Bindungen sind dort Variablen, und Zuweisungen
+// eines Laufs sind im nächsten nicht mehr sichtbar.
+// RunContext bricht wie EvalStringContext ab, sobald der übergebene
+// context.Context beendet ist.
+func Compile(src string) (*Program, error) { return rt.Compile(src) }
+
+// CompileScript ist wie Compile; Laufzeitfehler nennen den Skriptnamen in
+// ihrer Position, wie bei EvalScript.
+func CompileScript(name, src string) (*Program, error) { return rt.CompileScript(name, src) }
+
+// Die Vektor- und Listentypen der Laufzeit. Host-Programme können damit
+// Ergebnisse direkt untersuchen oder Werte selbst aufbauen; NA wird in den
+// Elementtypen über das Feld NA markiert.
+type (
+	LogicalVec  = rt.LogicalVec
+	IntVec      = rt.IntVec
+	DoubleVec   = rt.DoubleVec
+	ComplexVec  = rt.ComplexVec
+	CharVec     = rt.CharVec
+	ListVec     = rt.ListVec
+	LogicalElem = rt.LogicalElem
+	IntElem     = rt.IntElem
+	FloatElem   = rt.FloatElem
+	ComplexElem = rt.ComplexElem
+	StringElem  = rt.StringElem
+)
+
+// BuiltinFunc ist ein in Go implementiertes R-Builtin; ArgValue ist ein
+// (ggf. benanntes) Argument eines Aufrufs. Einfacher ist meist
+// Context.RegisterFunc, das Argumente per Reflection umwandelt.
+type (
+	BuiltinFunc = rt.BuiltinFunc
+	ArgValue    = rt.ArgValue
+)
+
+// Arg ist ein Argument für Context.Call, dasselbe wie ArgValue: Mit Name
+// wird es per Name zugeordnet, sonst nach Position. Beispiel:
+//
+//	v, err := ctx.Call(handler, smallr.Arg{Val: ev}, smallr.Arg{Name: "retry", Val: smallr.LogicalScalar(true)})
+type Arg = rt.ArgValue
+
+// Callable ist ein Alias für internal/rt.Callable, das Interface aller in
+// R aufrufbaren Werte. Eigene Go-Typen, die es implementieren (die
+// Attribut-Methoden liefert ein eingebettetes Base), verhalten sich in R
+// wie Funktionen, auch in lapply, Reduce oder do.call. Ihre Argumente
+// können noch nicht ausgewertete Promises sein; Force wertet sie aus.
+type Callable = rt.Callable
+
+// Base speichert die Attribute eines Werts und implementiert die
+// Attribut-Methoden von Value.
+type Base = rt.Base
+
+// Force wertet v aus, falls es ein Promise ist, und liefert sonst v.
+func Force(ctx *Context, v Value) (Value, error) { return rt.Force(ctx, v) }
+
+// LogicalScalar, IntScalar, DoubleScalar und CharScalar erzeugen Vektoren
+// der Länge 1.
+func LogicalScalar(v bool) *LogicalVec  { return rt.LogicalScalar(v) }
+func IntScalar(v int64) *IntVec         { return rt.IntScalar(v) }
+func DoubleScalar(v float64) *DoubleVec { return rt.DoubleScalar(v) }
+func CharScalar(v string) *CharVec      { return rt.CharScalar(v) }
+
+// NullValue ist der R-Wert NULL.
+var NullValue = rt.NullValue
+
+// ToValue wandelt einen Go-Wert in einen R-Wert um: Skalare in Vektoren
+// der Länge 1, Slices in Vektoren (oder Listen), Maps mit String-Schlüsseln
+// in benannte Listen und time.Time in POSIXct. nil-Zeiger werden zu NA.
+// Context.Set verwendet dieselbe Umwandlung.
+func ToValue(x any) (Value, error) { return rt.ToValue(x) }
+
+// FromValue wandelt einen R-Wert in einen Go-Wert um, wie Context.Get:
+// Vektoren der Länge 1 in Skalare, längere in []any, benannte Listen in
+// map[string]any; NA und NULL werden zu nil.
+func FromValue(v Value) any { return rt.FromValue(v) }
+
+// Decode schreibt den R-Wert v in die Go-Variable, auf die dst zeigt, und
+// wandelt dabei in deren statischen Typ um. Beispiel:
+//
+//	var xs []float64
+//	err := smallr.Decode(res.Value, &xs)
+func Decode(v Value, dst any) error { return rt.Decode(v, dst) }
+
+// Marshal wandelt einen Go-Wert wie ToValue in einen R-Wert um und ist vor
+// allem für Structs gedacht: Ein Struct wird zu einer benannten Liste
+// seiner exportierten Felder, ein Slice von Structs zu einem data.frame mit
+// einer Spalte je Feld. Der Tag `r:"name,omitempty"` benennt ein Feld um
+// und lässt es bei Nullwert weg, `r:"-"` lässt es immer weg. nil-Zeiger
+// und ungültige sql.Null*-Werte werden zu NA.
+func Marshal(v any) (Value, error) { return rt.ToValue(v) }
+
+// Unmarshal ist die Umkehrung von Marshal und arbeitet wie Decode: Benannte
+// Listen werden feldweise in Structs geschrieben, data.frames zeilenweise
+// in Slices von Structs. NA wird zu nil-Zeigern bzw. ungültigen
+// sql.Null*-Werten.
+func Unmarshal(v Value, dst any) error { return rt.Decode(v, dst) }